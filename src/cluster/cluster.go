@@ -13,6 +13,7 @@ import (
 )
 
 import (
+	"hlc"
 	"node"
 	"partitioner"
 	"store"
@@ -36,9 +37,17 @@ const (
 type ConsistencyLevel string
 
 const (
+	CONSISTENCY_ANY				= ConsistencyLevel("ANY")
+	// ONE only waits on a response from the local datacenter
 	CONSISTENCY_ONE 			= ConsistencyLevel("ONE")
+	// LOCAL_ONE waits on a response from the local datacenter,
+	// and only queries the local datacenter's replicas
+	CONSISTENCY_LOCAL_ONE		= ConsistencyLevel("LOCAL_ONE")
+	// QUORUM waits on a quorum of responses from every datacenter
 	CONSISTENCY_QUORUM			= ConsistencyLevel("QUORUM")
 	CONSISTENCY_QUORUM_LOCAL 	= ConsistencyLevel("QUORUM_LOCAL")
+	// EACH_QUORUM waits on a quorum of responses in each datacenter
+	CONSISTENCY_EACH_QUORUM		= ConsistencyLevel("EACH_QUORUM")
 	CONSISTENCY_ALL 			= ConsistencyLevel("ALL")
 	CONSISTENCY_ALL_LOCAL		= ConsistencyLevel("ALL_LOCAL")
 	CONSISTENCY_CONSENSUS		= ConsistencyLevel("CONSENSUS")
@@ -69,6 +78,27 @@ type Cluster struct {
 	peerServer *PeerServer
 	partitioner partitioner.Partitioner
 
+	// writes waiting to be replayed
+	// against unreachable replicas
+	hints *hintStore
+
+	// executes queries made at CONSENSUS
+	// and CONSENSUS_LOCAL consistency
+	consensus ConsensusExecutor
+
+	// hybrid logical clock used to timestamp writes,
+	// kept in step with the rest of the cluster through
+	// the timestamps carried on peer messages
+	clock *hlc.Clock
+
+	// the most recently measured clock offsets of peers,
+	// and the maximum offset tolerated before warning about,
+	// or refusing, connections with them
+	clockSkews *skewTracker
+
+	heartbeatInterval time.Duration
+	stopHeartbeat chan bool
+
 	status ClusterStatus
 }
 
@@ -105,6 +135,11 @@ func NewCluster(
 	c.localNode = NewLocalNode(c.nodeId, c.dcId, c.token, c.name, c.store)
 
 	c.peerServer = NewPeerServer(c, c.peerAddr)
+	c.hints = newHintStore()
+	c.clock = hlc.NewClock(c.nodeId)
+	c.clock.SetMaxOffset(DEFAULT_MAX_CLOCK_SKEW)
+	c.clockSkews = newSkewTracker(DEFAULT_MAX_CLOCK_SKEW)
+	c.heartbeatInterval = DEFAULT_HEARTBEAT_INTERVAL
 
 	if replicationFactor < 1 {
 		return nil, fmt.Errorf("Invalid replication factor: %v", replicationFactor)
@@ -137,6 +172,10 @@ func (c* Cluster) GetToken() partitioner.Token { return c.token }
 func (c* Cluster) GetName() string { return c.name }
 func (c* Cluster) GetPeerAddr() string { return c.peerAddr }
 
+// returns a new timestamp from the cluster's hybrid logical
+// clock, packed into a time value for use on instructions
+func (c *Cluster) Now() time.Time { return c.clock.Now().Time() }
+
 // adds a node to the cluster, if it's not already
 // part of the cluster, and starting it if the cluster
 // has been started
@@ -144,6 +183,7 @@ func (c *Cluster) addNode(n topology.Node) error {
 	// add to ring, and start if it hasn't been seen before
 	err := c.topology.AddNode(n)
 	if err != nil { return err }
+	c.notifyTopologyChange()
 	if c.status != CLUSTER_INITIALIZING {
 		if err := n.Start(); err != nil { return err }
 	}
@@ -270,10 +310,18 @@ func (c* Cluster) Start() error {
 		c.status = CLUSTER_NORMAL
 	}
 
+	// monitor peer clock skew
+	c.stopHeartbeat = make(chan bool)
+	go c.heartbeatLoop(c.stopHeartbeat)
+
 	return nil
 }
 
 func (c* Cluster) Stop() error {
+	if c.stopHeartbeat != nil {
+		close(c.stopHeartbeat)
+		c.stopHeartbeat = nil
+	}
 	c.peerServer.Stop()
 	for _, n := range c.topology.AllLocalNodes() {
 		n.Stop()
@@ -317,10 +365,10 @@ func (c *Cluster) streamToNode(cn topology.Node) error {
 	//
 	n := cn.(*RemoteNode)
 
-	// determines if the given key is replicated by
+	// determines if the given token is replicated by
 	// the destination node
-	replicates:= func(key string) bool {
-		nodes := c.GetLocalNodesForKey(key)
+	replicates:= func(token partitioner.Token) bool {
+		nodes := c.topology.GetLocalNodesForToken(token)
 		for _, rnode := range nodes {
 			if rnode.GetId() == n.GetId() {
 				return true
@@ -330,14 +378,12 @@ func (c *Cluster) streamToNode(cn topology.Node) error {
 	}
 
 	// iterate over the keys and send replicated k/v
-	keys := c.store.GetKeys()
-	for _, key := range keys {
-		if replicates(key) {
-			val, err := c.store.GetRawKey(key)
-			if err != nil { return err }
-			valBytes, err := c.store.SerializeValue(val)
+	iter := c.store.IterateKeys(c.partitioner, nil)
+	for iter.Next() {
+		if replicates(iter.Token()) {
+			valBytes, err := c.store.SerializeValue(iter.Value())
 			if err != nil { return err }
-			sd := &StreamData{Key:key, Data:valBytes}
+			sd := &StreamData{Key:iter.Key(), Data:valBytes}
 			msg := &StreamDataRequest{Data:[]*StreamData{sd}}
 			response , err := n.SendMessage(msg)
 			if err != nil { return err }
@@ -495,6 +541,8 @@ func readLocalOnly(cl ConsistencyLevel) bool {
 	switch cl {
 	case CONSISTENCY_ONE:
 		return true
+	case CONSISTENCY_LOCAL_ONE:
+		return true
 	case CONSISTENCY_QUORUM_LOCAL:
 		return true
 	case CONSISTENCY_ALL_LOCAL:
@@ -507,6 +555,52 @@ func readLocalOnly(cl ConsistencyLevel) bool {
 	return false
 }
 
+// determines how many responses are needed to satisfy the given consistency
+// level. Returns the number of responses required from each datacenter, and the
+// number of responses required in total, from any datacenter
+func consistencyRequirements(
+	consistency ConsistencyLevel,
+	localDC topology.DatacenterID,
+	replicaMap map[topology.DatacenterID][]topology.Node,
+) (map[topology.DatacenterID]int, int, error) {
+	perDC := make(map[topology.DatacenterID]int, len(replicaMap))
+	total := 0
+	for dcid, nodes := range replicaMap {
+		isLocal := dcid == localDC
+		switch consistency {
+		case CONSISTENCY_ANY:
+			perDC[dcid] = 0
+			total = 1
+		case CONSISTENCY_QUORUM:
+			perDC[dcid] = (len(nodes) / 2) + 1
+		case CONSISTENCY_EACH_QUORUM:
+			perDC[dcid] = (len(nodes) / 2) + 1
+		case CONSISTENCY_ALL:
+			perDC[dcid] = len(nodes)
+		case CONSISTENCY_ONE, CONSISTENCY_LOCAL_ONE, CONSISTENCY_QUORUM_LOCAL, CONSISTENCY_ALL_LOCAL:
+			if !isLocal {
+				perDC[dcid] = 0
+				continue
+			}
+			switch consistency {
+			case CONSISTENCY_ONE:
+				perDC[dcid] = 1
+			case CONSISTENCY_LOCAL_ONE:
+				perDC[dcid] = 1
+			case CONSISTENCY_QUORUM_LOCAL:
+				perDC[dcid] = (len(nodes) / 2) + 1
+			case CONSISTENCY_ALL_LOCAL:
+				perDC[dcid] = len(nodes)
+			}
+		case CONSISTENCY_CONSENSUS, CONSISTENCY_CONSENSUS_LOCAL:
+			return nil, 0, fmt.Errorf("%v queries are executed through consensus, not by replica responses", consistency)
+		default:
+			return nil, 0, fmt.Errorf("Unknown consistency level: %v", consistency)
+		}
+	}
+	return perDC, total, nil
+}
+
 type baseNodeError string
 func (ne baseNodeError) Error() string { return string(ne) }
 
@@ -549,6 +643,21 @@ func (c *Cluster) reconcileRead(
 			}
 		}
 
+	c.repairValues(key, nodeMap, values, valueNids)
+}
+
+// reconciles the values returned by the given nodes, and sends
+// the resulting corrections to the nodes with inaccurate values
+func (c *Cluster) repairValues(
+	key string,
+	nodeMap map[node.NodeId]topology.Node,
+	values []store.Value,
+	valueNids []node.NodeId,
+) {
+	if len(values) == 0 {
+		return
+	}
+
 	// TODO: fix
 	_, instructions, err := c.store.Reconcile(key, values)
 	if err != nil {
@@ -556,7 +665,7 @@ func (c *Cluster) reconcileRead(
 	}
 
 	write := func(n topology.Node, inst store.Instruction) {
-		n.ExecuteQuery(inst.Cmd, inst.Key, inst.Args, inst.Timestamp)
+		n.ExecuteQuery(inst)
 	}
 
 	for i, instructionList := range instructions {
@@ -567,7 +676,6 @@ func (c *Cluster) reconcileRead(
 			}
 		}
 	}
-
 }
 
 // executes a read against the cluster
@@ -597,39 +705,36 @@ func (c *Cluster) ExecuteRead(
 	// used for reconciling all responses
 	reconcileChannel := make(chan queryResponse, numNodes)
 
+	if isConsensusLevel(consistency) {
+		return c.executeConsensus(store.NewInstruction(cmd, key, args, time.Time{}), consistency)
+	}
+
 	// executes the read against the cluster
 	execute := func(n topology.Node) {
-		val, err := n.ExecuteQuery(cmd, key, args, time.Time{})
+		val, err := n.ExecuteQuery(store.NewInstruction(cmd, key, args, time.Time{}))
 		response := queryResponse{nid:n.GetId() , val:val, err:err}
 		responseChannel <- response
 		reconcileChannel <- response
 	}
 
+	if consistency == CONSISTENCY_ANY {
+		return nil, fmt.Errorf("ANY consistency is only supported for writes")
+	}
+
 	// determine if the read only needs to be executed against local nodes
 	localOnly := readLocalOnly(consistency)
 
-	// determine how many nodes we need a response from, per datacenter
-	// and start querying nodes
-	numRequiredResponses := make(map[topology.DatacenterID] int, len(replicaMap))
+	// determine how many nodes we need a response from
+	numRequiredResponses, numRequiredTotal, err := consistencyRequirements(consistency, c.GetDatacenterId(), replicaMap)
+	if err != nil {
+		return nil, err
+	}
+
+	// start querying nodes
 	for dcid, nodes := range replicaMap {
 		if dcid != c.GetDatacenterId() && localOnly {
-			numRequiredResponses[dcid] = 0
 			continue
-		} else {
-			switch consistency {
-			case CONSISTENCY_ONE:
-				numRequiredResponses[dcid] = 1
-			case CONSISTENCY_QUORUM, CONSISTENCY_QUORUM_LOCAL:
-				numRequiredResponses[dcid] = (len(nodes) / 2) + 1
-			case CONSISTENCY_ALL, CONSISTENCY_ALL_LOCAL:
-				numRequiredResponses[dcid] = len(nodes)
-			case CONSISTENCY_CONSENSUS, CONSISTENCY_CONSENSUS_LOCAL:
-				return nil, fmt.Errorf("CONSENSUS consistency not implemented yet")
-			default:
-				return nil, fmt.Errorf("Unknown consistency level: %v", consistency)
-			}
 		}
-
 		for _, n := range nodes {
 			nodeMap[n.GetId()] = n
 			go execute(n)
@@ -639,6 +744,7 @@ func (c *Cluster) ExecuteRead(
 	// wait for responses
 	numReceivedResponses := make(map[topology.DatacenterID] int, len(replicaMap))
 	numTotalResponses := 0
+	numSuccessfulResponses := 0
 	// determines if the number of responses received satisfies the
 	// required consistency level
 	consistencySatisfied := func() bool {
@@ -647,14 +753,14 @@ func (c *Cluster) ExecuteRead(
 				return false
 			}
 		}
-		return true
+		return numSuccessfulResponses >= numRequiredTotal
 	}
 	values := make([]store.Value, 0)
 	var response queryResponse
 	timeoutEvent := time.After(timeout * time.Millisecond)
 	for !consistencySatisfied() {
 		// too many errors received to satisfy consistency
-		if numTotalResponses >= numNodes {
+		if numTotalResponses >= len(nodeMap) {
 			return nil, fmt.Errorf("Errors received from remote nodes, could not satisfy consistency")
 		}
 
@@ -670,6 +776,7 @@ func (c *Cluster) ExecuteRead(
 			}
 			// increment number of responses for responding datacenter
 			numReceivedResponses[nodeMap[response.nid].GetDatacenterId()]++
+			numSuccessfulResponses++
 			values = append(values, val)
 		case <-timeoutEvent:
 			return nil, nodeTimeoutError(fmt.Sprintf("Read not completed before timeout"))
@@ -693,7 +800,25 @@ func (c *Cluster) ExecuteRead(
 	return val, nil
 }
 
+// returns a write instruction stamped with the local clock, or with the
+// timestamp provided by the client. Client timestamps aren't trusted to
+// advance the local clock, only timestamps received from peers do
+func (c *Cluster) stampWrite(cmd string, key string, args []string, timestamp time.Time) store.Instruction {
+	if timestamp.IsZero() {
+		timestamp = c.Now()
+	}
+	instruction := store.NewInstruction(cmd, key, args, timestamp)
+	instruction.Origin = c.GetNodeId().UUID
+	return instruction
+}
+
 // executes a write against the cluster
+//
+// writes are sent to every replica, in every datacenter. The consistency
+// level only determines how many acknowledgements are waited on before
+// returning. Replicas that fail to acknowledge the write are given a hint,
+// which is replayed when they reconnect. At consistency ANY, a stored hint
+// counts as an acknowledgement
 func (c *Cluster) ExecuteWrite(
 	// the read command to perform
 	cmd string,
@@ -710,6 +835,211 @@ func (c *Cluster) ExecuteWrite(
 	// if true, reconciliation should be performed before returning
 	synchronous bool,
 ) (store.Value, error) {
-	return nil, nil
+
+	// order sensitive writes can't be reconciled, so
+	// they can only be executed through consensus
+	if isConsensusLevel(consistency) {
+		return c.executeConsensus(c.stampWrite(cmd, key, args, timestamp), consistency)
+	} else if c.store.RequiresConsensus(store.NewInstruction(cmd, key, args, timestamp)) {
+		return nil, fmt.Errorf("%v can only be executed at %v or %v consistency", cmd, CONSISTENCY_CONSENSUS, CONSISTENCY_CONSENSUS_LOCAL)
+	}
+
+	// map of dcid -> []Node
+	replicaMap := c.GetNodesForKey(key)
+	// map of node ids-> node contacted
+	nodeMap := make(map[node.NodeId]topology.Node)
+	numNodes := numMappedNodes(replicaMap)
+	// used for constructing a response
+	responseChannel := make(chan queryResponse, numNodes)
+
+	// determine how many nodes we need a response from
+	numRequiredResponses, numRequiredTotal, err := consistencyRequirements(consistency, c.GetDatacenterId(), replicaMap)
+	if err != nil {
+		return nil, err
+	}
+
+	instruction := c.stampWrite(cmd, key, args, timestamp)
+
+	// writes that can't be replicated by executing them on every replica
+	// are executed by a single leader replica, and it's result is replicated
+	if leader := c.writeLeader(replicaMap); leader != nil {
+		if leaderInstruction, ok := c.store.LeaderInstruction(instruction, leader.GetId().String()); ok {
+			replication, err := c.executeLeaderWrite(leader, leaderInstruction, timeout)
+			if err != nil || replication == nil {
+				return nil, err
+			}
+			instruction = *replication
+			instruction.Origin = c.GetNodeId().UUID
+		}
+	}
+
+	// executes the write against the cluster
+	execute := func(n topology.Node) {
+		val, err := n.ExecuteQuery(instruction)
+		responseChannel <- queryResponse{nid:n.GetId() , val:val, err:err}
+	}
+
+	for _, nodes := range replicaMap {
+		for _, n := range nodes {
+			nodeMap[n.GetId()] = n
+			go execute(n)
+		}
+	}
+
+	// wait for responses
+	numReceivedResponses := make(map[topology.DatacenterID] int, len(replicaMap))
+	numTotalResponses := 0
+	numAcknowledged := 0
+	responded := make(map[node.NodeId]bool, numNodes)
+	// determines if the number of responses received satisfies the
+	// required consistency level
+	consistencySatisfied := func() bool {
+		for dcid, num := range numRequiredResponses {
+			if numReceivedResponses[dcid] < num {
+				return false
+			}
+		}
+		return numAcknowledged >= numRequiredTotal
+	}
+	// hints a write to a replica that didn't receive it. Writes
+	// acknowledged by hints satisfy the ANY consistency level
+	hint := func(nid node.NodeId) {
+		if nid == c.GetNodeId() {
+			return
+		}
+		if !c.hints.add(nid, instruction) {
+			logger.Warning("Hints for node %v are full, dropping write to %v", nid, key)
+			return
+		}
+		if consistency == CONSISTENCY_ANY {
+			numAcknowledged++
+		}
+	}
+	// write responses are acknowledgements, not values, so they aren't
+	// reconciled. The local node's response is preferred, since it's
+	// the replica the client would be reading from
+	var value store.Value
+	hasValue := false
+	var response queryResponse
+	timeoutEvent := time.After(timeout * time.Millisecond)
+	receive:
+		for !consistencySatisfied() {
+			// too many errors received to satisfy consistency
+			if numTotalResponses >= numNodes {
+				return nil, fmt.Errorf("Errors received from remote nodes, could not satisfy consistency")
+			}
+
+			select {
+			case response = <-responseChannel:
+				numTotalResponses++
+				responded[response.nid] = true
+				if response.err != nil {
+					// the replica missed the write, hold
+					// onto it until it can be replayed
+					if isHintable(response.err) {
+						hint(response.nid)
+					}
+					continue
+				}
+				// increment number of responses for responding datacenter
+				numReceivedResponses[nodeMap[response.nid].GetDatacenterId()]++
+				numAcknowledged++
+				if !hasValue || response.nid == c.GetNodeId() {
+					value = response.val
+					hasValue = true
+				}
+			case <-timeoutEvent:
+				// hand the write off to the unresponsive replicas as hints
+				for nid := range nodeMap {
+					if !responded[nid] {
+						hint(nid)
+					}
+				}
+				if consistency != CONSISTENCY_ANY || !consistencySatisfied() {
+					return nil, nodeTimeoutError(fmt.Sprintf("Write not completed before timeout"))
+				}
+				break receive
+			}
+		}
+
+	// the write may have only been acknowledged by hints
+	return value, nil
 }
 
+// returns the replica that should execute leader writes for the given
+// replicas. The local node is preferred, then nodes in the local datacenter
+func (c *Cluster) writeLeader(replicaMap map[topology.DatacenterID][]topology.Node) topology.Node {
+	var leader topology.Node
+	for _, n := range replicaMap[c.GetDatacenterId()] {
+		if n.GetId() == c.GetNodeId() {
+			return n
+		}
+		if leader == nil {
+			leader = n
+		}
+	}
+	if leader != nil {
+		return leader
+	}
+	for _, nodes := range replicaMap {
+		for _, n := range nodes {
+			return n
+		}
+	}
+	return nil
+}
+
+// executes the given leader instruction against the leader, and returns
+// the instruction that replicates it's result to the other replicas. Nil
+// is returned if the leader ignored the write, and there's nothing to replicate
+func (c *Cluster) executeLeaderWrite(leader topology.Node, instruction store.Instruction, timeout time.Duration) (*store.Instruction, error) {
+	responseChannel := make(chan queryResponse, 1)
+	go func() {
+		val, err := leader.ExecuteQuery(instruction)
+		responseChannel <- queryResponse{nid:leader.GetId(), val:val, err:err}
+	}()
+
+	var response queryResponse
+	select {
+	case response = <-responseChannel:
+		if response.err != nil {
+			return nil, response.err
+		}
+	case <-time.After(timeout * time.Millisecond):
+		return nil, nodeTimeoutError(fmt.Sprintf("Leader write not completed before timeout"))
+	}
+	if response.val == nil {
+		return nil, nil
+	}
+
+	replication, err := c.store.ReplicationInstruction(instruction, leader.GetId().String(), response.val)
+	if err != nil {
+		return nil, err
+	}
+	return &replication, nil
+}
+
+// replays any writes the given node missed while it was unreachable
+func (c *Cluster) replayHints(nid node.NodeId) error {
+	n, err := c.topology.GetNode(nid)
+	if err != nil {
+		return err
+	}
+
+	instructions := c.hints.drain(nid)
+	for i, instruction := range instructions {
+		if _, err := n.ExecuteQuery(instruction); err != nil {
+			if !isHintable(err) {
+				// the node received the write, but couldn't execute it
+				logger.Warning("Error replaying hint to node %v: %v", nid, err)
+				continue
+			}
+			// put the unsent hints back
+			for _, remaining := range instructions[i:] {
+				c.hints.add(nid, remaining)
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"time"
+)
+
+import (
+	"launchpad.net/gocheck"
+)
+
+import (
+	"consensus"
+	"kvstore"
+	"store"
+)
+
+// tests conditional writes executed end to end, through a
+// consensus manager, against the local node's store
+type ConsensusTest struct {
+	cluster *Cluster
+}
+
+var _ = gocheck.Suite(&ConsensusTest{})
+
+func (s *ConsensusTest) SetUpTest(c *gocheck.C) {
+	s.cluster = setupCluster()
+	s.cluster.SetConsensusExecutor(consensus.NewManager(s.cluster.topology, s.cluster.store))
+}
+
+func (s *ConsensusTest) write(c *gocheck.C, cmd string, args []string, consistency ConsistencyLevel) store.Value {
+	val, err := s.cluster.ExecuteWrite(cmd, "a", args, time.Time{}, consistency, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	return val
+}
+
+// executes a conditional write, and returns if the condition was met
+func (s *ConsensusTest) met(c *gocheck.C, cmd string, args []string, consistency ConsistencyLevel) bool {
+	val := s.write(c, cmd, args, consistency)
+	c.Assert(val, gocheck.FitsTypeOf, &kvstore.Boolean{})
+	return val.(*kvstore.Boolean).GetValue()
+}
+
+func (s *ConsensusTest) get(c *gocheck.C) string {
+	val, err := s.cluster.ExecuteRead("GET", "a", []string{}, CONSISTENCY_CONSENSUS, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(val, gocheck.FitsTypeOf, &kvstore.String{})
+	return val.(*kvstore.String).GetValue()
+}
+
+func (s *ConsensusTest) TestSetNX(c *gocheck.C) {
+	for _, consistency := range []ConsistencyLevel{CONSISTENCY_CONSENSUS, CONSISTENCY_CONSENSUS_LOCAL} {
+		s.SetUpTest(c)
+		c.Check(s.met(c, "SETNX", []string{"1"}, consistency), gocheck.Equals, true)
+		c.Check(s.met(c, "SETNX", []string{"2"}, consistency), gocheck.Equals, false)
+		c.Check(s.get(c), gocheck.Equals, "1")
+	}
+}
+
+func (s *ConsensusTest) TestSetNXXX(c *gocheck.C) {
+	c.Check(s.met(c, "SET", []string{"1", "XX"}, CONSISTENCY_CONSENSUS), gocheck.Equals, false)
+	c.Check(s.met(c, "SET", []string{"1", "NX"}, CONSISTENCY_CONSENSUS), gocheck.Equals, true)
+	c.Check(s.met(c, "SET", []string{"2", "NX"}, CONSISTENCY_CONSENSUS), gocheck.Equals, false)
+	c.Check(s.met(c, "SET", []string{"3", "XX"}, CONSISTENCY_CONSENSUS), gocheck.Equals, true)
+	c.Check(s.get(c), gocheck.Equals, "3")
+}
+
+func (s *ConsensusTest) TestGetSetAndCAS(c *gocheck.C) {
+	c.Check(s.write(c, "GETSET", []string{"1"}, CONSISTENCY_CONSENSUS), gocheck.IsNil)
+	previous := s.write(c, "GETSET", []string{"2"}, CONSISTENCY_CONSENSUS)
+	c.Assert(previous, gocheck.FitsTypeOf, &kvstore.String{})
+	c.Check(previous.(*kvstore.String).GetValue(), gocheck.Equals, "1")
+
+	c.Check(s.met(c, "CAS", []string{"1", "3"}, CONSISTENCY_CONSENSUS), gocheck.Equals, false)
+	c.Check(s.met(c, "CAS", []string{"2", "3"}, CONSISTENCY_CONSENSUS), gocheck.Equals, true)
+	c.Check(s.get(c), gocheck.Equals, "3")
+}
+
+// conditional writes are rejected below consensus consistency
+func (s *ConsensusTest) TestConditionalRequiresConsensus(c *gocheck.C) {
+	val, err := s.cluster.ExecuteWrite("SETNX", "a", []string{"1"}, time.Time{}, CONSISTENCY_QUORUM, time.Duration(50), false)
+	c.Check(val, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+}
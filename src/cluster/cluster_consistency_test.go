@@ -0,0 +1,485 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+)
+
+import (
+	"launchpad.net/gocheck"
+)
+
+import (
+	"consensus"
+	"kvstore"
+	"node"
+	"partitioner"
+	"store"
+	"topology"
+)
+
+type ConsistencyTest struct {
+	cluster *Cluster
+	localDC topology.DatacenterID
+	remoteDC topology.DatacenterID
+}
+
+var _ = gocheck.Suite(&ConsistencyTest{})
+
+// sets up a cluster of mock nodes, with 3
+// replicas in the local dc and 3 in a remote dc
+func (s *ConsistencyTest) SetUpTest(c *gocheck.C) {
+	s.cluster = setupCluster()
+	s.localDC = s.cluster.GetDatacenterId()
+	s.remoteDC = topology.DatacenterID("DC6000")
+	s.cluster.topology = topology.NewTopology(
+		s.cluster.GetNodeId(),
+		s.localDC,
+		s.cluster.partitioner,
+		uint(s.cluster.replicationFactor),
+	)
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for i:=0; i<3; i++ {
+			n := newMockNode(
+				node.NewNodeId(),
+				dcid,
+				partitioner.Token([]byte{0,0,byte(i),0}),
+				fmt.Sprintf("%vN%v", dcid, i),
+			)
+			s.cluster.topology.AddNode(n)
+		}
+	}
+}
+
+// returns the mock replicas for the given key in the given dc
+func (s *ConsistencyTest) getReplicas(key string, dcid topology.DatacenterID) []*mockNode {
+	nodes := s.cluster.GetNodesForKey(key)[dcid]
+	replicas := make([]*mockNode, len(nodes))
+	for i, n := range nodes {
+		replicas[i] = n.(*mockNode)
+	}
+	return replicas
+}
+
+func (s *ConsistencyTest) TestRequirements(c *gocheck.C) {
+	replicaMap := s.cluster.GetNodesForKey("a")
+	local := s.localDC
+	remote := s.remoteDC
+
+	var expectations = []struct {
+		consistency ConsistencyLevel
+		local int
+		remote int
+		total int
+	}{
+		{CONSISTENCY_ANY, 0, 0, 1},
+		{CONSISTENCY_ONE, 1, 0, 0},
+		{CONSISTENCY_LOCAL_ONE, 1, 0, 0},
+		{CONSISTENCY_QUORUM, 2, 2, 0},
+		{CONSISTENCY_QUORUM_LOCAL, 2, 0, 0},
+		{CONSISTENCY_EACH_QUORUM, 2, 2, 0},
+		{CONSISTENCY_ALL, 3, 3, 0},
+		{CONSISTENCY_ALL_LOCAL, 3, 0, 0},
+	}
+
+	for _, e := range expectations {
+		perDC, total, err := consistencyRequirements(e.consistency, local, replicaMap)
+		c.Assert(err, gocheck.IsNil)
+		c.Check(perDC[local], gocheck.Equals, e.local, gocheck.Commentf("%v", e.consistency))
+		c.Check(perDC[remote], gocheck.Equals, e.remote, gocheck.Commentf("%v", e.consistency))
+		c.Check(total, gocheck.Equals, e.total, gocheck.Commentf("%v", e.consistency))
+	}
+
+	// LOCAL_ONE only queries the local datacenter
+	c.Check(readLocalOnly(CONSISTENCY_LOCAL_ONE), gocheck.Equals, true)
+	c.Check(readLocalOnly(CONSISTENCY_EACH_QUORUM), gocheck.Equals, false)
+
+	_, _, err := consistencyRequirements(ConsistencyLevel("SOME"), local, replicaMap)
+	c.Check(err, gocheck.NotNil)
+}
+
+// ANY is not a valid consistency level for reads
+func (s *ConsistencyTest) TestReadAnyFailure(c *gocheck.C) {
+	val, err := s.cluster.ExecuteRead("GET", "a", []string{}, CONSISTENCY_ANY, time.Duration(10), false)
+	c.Check(val, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+}
+
+// tests that EACH_QUORUM waits on a quorum
+// of responses from every datacenter
+func (s *ConsistencyTest) TestReadEachQuorum(c *gocheck.C) {
+	expected := kvstore.NewString("b", time.Now())
+	for _, n := range s.getReplicas("a", s.localDC) {
+		n.addResponse(expected, nil)
+	}
+	// only a quorum of remote replicas respond
+	remote := s.getReplicas("a", s.remoteDC)
+	remote[0].addResponse(expected, nil)
+	remote[1].addResponse(expected, nil)
+
+	val, err := s.cluster.ExecuteRead("GET", "a", []string{}, CONSISTENCY_EACH_QUORUM, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(expected.Equal(val), gocheck.Equals, true)
+}
+
+// tests that EACH_QUORUM fails if one of the
+// datacenters can't reach a quorum
+func (s *ConsistencyTest) TestReadEachQuorumFailure(c *gocheck.C) {
+	expected := kvstore.NewString("b", time.Now())
+	for _, n := range s.getReplicas("a", s.localDC) {
+		n.addResponse(expected, nil)
+	}
+	remote := s.getReplicas("a", s.remoteDC)
+	remote[0].addResponse(expected, nil)
+	remote[1].addResponse(nil, fmt.Errorf("nope"))
+	remote[2].addResponse(nil, fmt.Errorf("nope"))
+
+	val, err := s.cluster.ExecuteRead("GET", "a", []string{}, CONSISTENCY_EACH_QUORUM, time.Duration(50), false)
+	c.Check(val, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+}
+
+// tests that LOCAL_ONE is satisfied by a single local
+// response, and doesn't query remote nodes
+func (s *ConsistencyTest) TestReadLocalOne(c *gocheck.C) {
+	expected := kvstore.NewString("b", time.Now())
+	local := s.getReplicas("a", s.localDC)
+	local[0].addResponse(expected, nil)
+	local[1].addResponse(nil, fmt.Errorf("nope"))
+	local[2].addResponse(nil, fmt.Errorf("nope"))
+
+	val, err := s.cluster.ExecuteRead("GET", "a", []string{}, CONSISTENCY_LOCAL_ONE, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(expected.Equal(val), gocheck.Equals, true)
+
+	for _, n := range s.getReplicas("a", s.remoteDC) {
+		c.Check(len(n.requests), gocheck.Equals, 0)
+	}
+}
+
+// tests that ONE is only satisfied by local
+// nodes, and doesn't query remote nodes
+func (s *ConsistencyTest) TestReadLocalOneOnly(c *gocheck.C) {
+	for _, n := range s.getReplicas("a", s.localDC) {
+		n.addResponse(nil, fmt.Errorf("nope"))
+	}
+	remote := s.getReplicas("a", s.remoteDC)
+	remote[0].addResponse(kvstore.NewString("b", time.Now()), nil)
+
+	val, err := s.cluster.ExecuteRead("GET", "a", []string{}, CONSISTENCY_ONE, time.Duration(50), false)
+	c.Check(val, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+	for _, n := range remote {
+		c.Check(len(n.requests), gocheck.Equals, 0)
+	}
+}
+
+// tests that a write at ANY succeeds, and stores
+// hints, when none of the replicas acknowledge it
+func (s *ConsistencyTest) TestWriteAnyHinted(c *gocheck.C) {
+	ts := time.Now()
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			n.addResponse(nil, fmt.Errorf("nope"))
+		}
+	}
+
+	val, err := s.cluster.ExecuteWrite("SET", "a", []string{"b"}, ts, CONSISTENCY_ANY, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(val, gocheck.IsNil)
+
+	// wait for the remaining errors to be received
+	// the write only waits on the first one
+	time.Sleep(time.Duration(10 * time.Millisecond))
+
+	numHints := 0
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			numHints += s.cluster.hints.count(n.GetId())
+		}
+	}
+	c.Check(numHints > 0, gocheck.Equals, true)
+}
+
+// tests that a write at ANY succeeds, and stores hints
+// for every replica, when none of the replicas respond
+func (s *ConsistencyTest) TestWriteAnyTimeout(c *gocheck.C) {
+	ts := time.Now()
+	val, err := s.cluster.ExecuteWrite("SET", "a", []string{"b"}, ts, CONSISTENCY_ANY, time.Duration(5), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(val, gocheck.IsNil)
+
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			c.Check(s.cluster.hints.count(n.GetId()), gocheck.Equals, 1)
+		}
+	}
+}
+
+// tests that a write at EACH_QUORUM fails if a
+// quorum isn't reached in each datacenter, and that
+// hints are stored for the failed replicas
+func (s *ConsistencyTest) TestWriteEachQuorumFailure(c *gocheck.C) {
+	ts := time.Now()
+	expected := kvstore.NewString("b", ts)
+	for _, n := range s.getReplicas("a", s.localDC) {
+		n.addResponse(expected, nil)
+	}
+	remote := s.getReplicas("a", s.remoteDC)
+	for _, n := range remote {
+		n.addResponse(nil, fmt.Errorf("nope"))
+	}
+
+	val, err := s.cluster.ExecuteWrite("SET", "a", []string{"b"}, ts, CONSISTENCY_EACH_QUORUM, time.Duration(50), false)
+	c.Check(val, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+
+	for _, n := range remote {
+		c.Check(s.cluster.hints.count(n.GetId()), gocheck.Equals, 1)
+	}
+}
+
+// tests that writes which reached a replica, but failed
+// to execute, aren't hinted, since replaying them wouldn't succeed
+func (s *ConsistencyTest) TestWriteNodeErrorNotHinted(c *gocheck.C) {
+	ts := time.Now()
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			n.addResponse(nil, NewNodeError("nope"))
+		}
+	}
+
+	val, err := s.cluster.ExecuteWrite("SET", "a", []string{"b"}, ts, CONSISTENCY_ANY, time.Duration(50), false)
+	c.Check(val, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			c.Check(s.cluster.hints.count(n.GetId()), gocheck.Equals, 0)
+		}
+	}
+}
+
+// tests that writes are dropped once a node's hints are full, and
+// that dropped hints don't acknowledge writes at ANY
+func (s *ConsistencyTest) TestWriteHintLimit(c *gocheck.C) {
+	oldMaxHints := MAX_HINTS_PER_NODE
+	defer func() { MAX_HINTS_PER_NODE = oldMaxHints }()
+	MAX_HINTS_PER_NODE = 1
+
+	ts := time.Now()
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			c.Assert(s.cluster.hints.add(n.GetId(), store.NewInstruction("SET", "a", []string{"a"}, ts)), gocheck.Equals, true)
+		}
+	}
+
+	val, err := s.cluster.ExecuteWrite("SET", "a", []string{"b"}, ts, CONSISTENCY_ANY, time.Duration(5), false)
+	c.Check(val, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			c.Check(s.cluster.hints.count(n.GetId()), gocheck.Equals, 1)
+		}
+	}
+}
+
+// tests that write acknowledgements aren't reconciled as values, by
+// deleting missing and existing keys from replicas with real stores
+func (s *ConsistencyTest) TestWriteDelete(c *gocheck.C) {
+	s.cluster.topology = topology.NewTopology(
+		s.cluster.GetNodeId(),
+		s.localDC,
+		s.cluster.partitioner,
+		uint(s.cluster.replicationFactor),
+	)
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for i:=0; i<3; i++ {
+			n := NewLocalNode(
+				node.NewNodeId(),
+				dcid,
+				partitioner.Token([]byte{0,0,byte(i),0}),
+				fmt.Sprintf("%vN%v", dcid, i),
+				kvstore.NewKVStore(),
+			)
+			s.cluster.topology.AddNode(n)
+		}
+	}
+
+	val, err := s.cluster.ExecuteWrite("DEL", "a", []string{}, time.Time{}, CONSISTENCY_QUORUM, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(val, gocheck.FitsTypeOf, &kvstore.Boolean{})
+	c.Check(val.(*kvstore.Boolean).GetValue(), gocheck.Equals, false)
+
+	_, err = s.cluster.ExecuteWrite("SET", "a", []string{"b"}, time.Time{}, CONSISTENCY_ALL, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+
+	val, err = s.cluster.ExecuteWrite("DEL", "a", []string{}, time.Time{}, CONSISTENCY_QUORUM, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(val, gocheck.FitsTypeOf, &kvstore.Boolean{})
+	c.Check(val.(*kvstore.Boolean).GetValue(), gocheck.Equals, true)
+}
+
+// tests that writes are sent to the replicas with the
+// id of the node that issued their timestamp
+func (s *ConsistencyTest) TestWriteOrigin(c *gocheck.C) {
+	ts := time.Now()
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			n.addResponse(kvstore.NewString("b", ts), nil)
+		}
+	}
+
+	_, err := s.cluster.ExecuteWrite("SET", "a", []string{"b"}, time.Time{}, CONSISTENCY_ALL, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			c.Assert(len(n.requests), gocheck.Equals, 1)
+			c.Check(n.requests[0].origin, gocheck.Equals, s.cluster.GetNodeId().UUID)
+		}
+	}
+}
+
+// tests that hints are replayed against the node, and removed
+func (s *ConsistencyTest) TestReplayHints(c *gocheck.C) {
+	n := s.getReplicas("a", s.localDC)[0]
+	instruction := store.NewInstruction("SET", "a", []string{"b"}, time.Now())
+	s.cluster.hints.add(n.GetId(), instruction)
+	n.addResponse(kvstore.NewString("b", instruction.Timestamp), nil)
+
+	c.Assert(s.cluster.replayHints(n.GetId()), gocheck.IsNil)
+	c.Check(s.cluster.hints.count(n.GetId()), gocheck.Equals, 0)
+	c.Assert(len(n.requests), gocheck.Equals, 1)
+	c.Check(n.requests[0].cmd, gocheck.Equals, "SET")
+	c.Check(n.requests[0].timestamp, gocheck.Equals, instruction.Timestamp)
+}
+
+// tests that writes which can only be executed
+// through consensus are rejected at other levels
+func (s *ConsistencyTest) TestWriteRequiresConsensus(c *gocheck.C) {
+	mstore := newMockStore()
+	mstore.requiresConsensus = true
+	s.cluster.store = mstore
+
+	val, err := s.cluster.ExecuteWrite("LPUSH", "a", []string{"b"}, time.Now(), CONSISTENCY_QUORUM, time.Duration(5), false)
+	c.Check(val, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			c.Check(len(n.requests), gocheck.Equals, 0)
+		}
+	}
+}
+
+// tests that increments are executed by a single leader
+// replica, and it's result is replicated to the others
+func (s *ConsistencyTest) TestWriteLeaderInstruction(c *gocheck.C) {
+	ts := s.cluster.Now()
+	local := s.getReplicas("a", s.localDC)
+	leader := local[0]
+	leaderId := leader.GetId().String()
+
+	// the leader's result
+	kv := kvstore.NewKVStore()
+	expected, err := kv.ExecuteInstruction(store.NewInternalInstruction("CINCRBY", "a", []string{"5", leaderId}, ts))
+	c.Assert(err, gocheck.IsNil)
+
+	leader.addResponse(expected, nil)
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			n.addResponse(expected, nil)
+		}
+	}
+
+	val, err := s.cluster.ExecuteWrite("INCRBY", "a", []string{"5"}, ts, CONSISTENCY_QUORUM, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(val, gocheck.NotNil)
+	c.Check(val.(*kvstore.Counter).GetValue(), gocheck.Equals, int64(5))
+
+	// wait for the remaining responses
+	time.Sleep(time.Duration(10 * time.Millisecond))
+
+	c.Assert(len(leader.requests), gocheck.Equals, 2)
+	c.Check(leader.requests[0].cmd, gocheck.Equals, "CINCRBY")
+	c.Check(leader.requests[0].args, gocheck.DeepEquals, []string{"5", leaderId})
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			c.Assert(len(n.requests) > 0, gocheck.Equals, true)
+			request := n.requests[len(n.requests) - 1]
+			c.Check(request.cmd, gocheck.Equals, "CSHARD")
+			c.Check(request.args[0], gocheck.Equals, leaderId)
+		}
+	}
+}
+
+// tests that a failed leader write isn't replicated
+func (s *ConsistencyTest) TestWriteLeaderInstructionFailure(c *gocheck.C) {
+	leader := s.getReplicas("a", s.localDC)[0]
+	leader.addResponse(nil, fmt.Errorf("nope"))
+
+	val, err := s.cluster.ExecuteWrite("INCR", "a", []string{}, s.cluster.Now(), CONSISTENCY_QUORUM, time.Duration(50), false)
+	c.Check(val, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			if n != leader {
+				c.Check(len(n.requests), gocheck.Equals, 0)
+			}
+		}
+	}
+}
+
+// tests that writes at consensus consistency are stamped, and
+// sent to the consensus executor instead of the replicas
+func (s *ConsistencyTest) TestWriteConsensus(c *gocheck.C) {
+	mstore := newMockStore()
+	mstore.requiresConsensus = true
+	s.cluster.store = mstore
+	executor := &mockConsensusExecutor{val:newMockString("c", time.Now())}
+	s.cluster.SetConsensusExecutor(executor)
+
+	for _, consistency := range []ConsistencyLevel{CONSISTENCY_CONSENSUS, CONSISTENCY_CONSENSUS_LOCAL} {
+		val, err := s.cluster.ExecuteWrite("LPUSH", "a", []string{"b"}, time.Time{}, consistency, time.Duration(5), false)
+		c.Assert(err, gocheck.IsNil)
+		c.Check(val, gocheck.Equals, executor.val)
+	}
+
+	c.Assert(len(executor.instructions), gocheck.Equals, 2)
+	for _, instruction := range executor.instructions {
+		c.Check(instruction.Cmd, gocheck.Equals, "LPUSH")
+		c.Check(instruction.Key, gocheck.Equals, "a")
+		c.Check(instruction.Timestamp.IsZero(), gocheck.Equals, false)
+		c.Check(instruction.Origin, gocheck.Equals, s.cluster.GetNodeId().UUID)
+	}
+	c.Assert(len(executor.consistencies), gocheck.Equals, 2)
+	c.Check(executor.consistencies[0], gocheck.Equals, consensus.CONSISTENCY_CONSENSUS)
+	c.Check(executor.consistencies[1], gocheck.Equals, consensus.CONSISTENCY_CONSENSUS_LOCAL)
+	for _, dcid := range []topology.DatacenterID{s.localDC, s.remoteDC} {
+		for _, n := range s.getReplicas("a", dcid) {
+			c.Check(len(n.requests), gocheck.Equals, 0)
+		}
+	}
+}
+
+// tests that reads at consensus consistency
+// are sent to the consensus executor
+func (s *ConsistencyTest) TestReadConsensus(c *gocheck.C) {
+	executor := &mockConsensusExecutor{val:newMockString("b", time.Now())}
+	s.cluster.SetConsensusExecutor(executor)
+
+	val, err := s.cluster.ExecuteRead("LRANGE", "a", []string{"0", "-1"}, CONSISTENCY_CONSENSUS, time.Duration(5), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(val, gocheck.Equals, executor.val)
+	c.Assert(len(executor.instructions), gocheck.Equals, 1)
+	c.Check(executor.instructions[0].Timestamp.IsZero(), gocheck.Equals, true)
+	c.Check(executor.consistencies[0], gocheck.Equals, consensus.CONSISTENCY_CONSENSUS)
+}
+
+// tests that queries at consensus consistency fail
+// when no consensus executor has been set
+func (s *ConsistencyTest) TestConsensusUnavailable(c *gocheck.C) {
+	val, err := s.cluster.ExecuteWrite("LPUSH", "a", []string{"b"}, time.Time{}, CONSISTENCY_CONSENSUS, time.Duration(5), false)
+	c.Check(val, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+}
@@ -0,0 +1,175 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+)
+
+import (
+	"launchpad.net/gocheck"
+)
+
+import (
+	"kvstore"
+	"store"
+	"topology"
+)
+
+type MultiKeyTest struct {
+	// provides the cluster of mock nodes
+	replicas *ConsistencyTest
+	cluster *Cluster
+}
+
+var _ = gocheck.Suite(&MultiKeyTest{})
+
+func (s *MultiKeyTest) SetUpTest(c *gocheck.C) {
+	s.replicas = &ConsistencyTest{}
+	s.replicas.SetUpTest(c)
+	s.cluster = s.replicas.cluster
+}
+
+// tests that each replica receives the instructions for
+// every key it holds, and the results are returned in key order
+func (s *MultiKeyTest) TestMultiGet(c *gocheck.C) {
+	ts := time.Now()
+	a := kvstore.NewString("1", ts)
+	b := kvstore.NewString("2", ts)
+	for _, n := range s.replicas.getReplicas("a", s.replicas.localDC) {
+		n.addResponse(a, nil)
+		n.addResponse(b, nil)
+	}
+
+	results, err := s.cluster.ExecuteMultiKey("MGET", []string{"a", "b"}, time.Time{}, CONSISTENCY_QUORUM_LOCAL, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(results), gocheck.Equals, 2)
+	c.Check(results[0].Key, gocheck.Equals, "a")
+	c.Check(results[0].Err, gocheck.IsNil)
+	c.Check(a.Equal(results[0].Val), gocheck.Equals, true)
+	c.Check(results[1].Key, gocheck.Equals, "b")
+	c.Check(results[1].Err, gocheck.IsNil)
+	c.Check(b.Equal(results[1].Val), gocheck.Equals, true)
+
+	// wait for the remaining responses
+	time.Sleep(time.Duration(10 * time.Millisecond))
+
+	for _, n := range s.replicas.getReplicas("a", s.replicas.localDC) {
+		c.Assert(len(n.requests), gocheck.Equals, 2)
+		c.Check(n.requests[0].cmd, gocheck.Equals, "GET")
+		c.Check(n.requests[0].key, gocheck.Equals, "a")
+		c.Check(n.requests[1].key, gocheck.Equals, "b")
+	}
+	for _, n := range s.replicas.getReplicas("a", s.replicas.remoteDC) {
+		c.Check(len(n.requests), gocheck.Equals, 0)
+	}
+}
+
+// tests that consistency is evaluated for each key, and
+// a key that fails doesn't fail the others
+func (s *MultiKeyTest) TestMultiGetKeyFailure(c *gocheck.C) {
+	a := kvstore.NewString("1", time.Now())
+	for _, n := range s.replicas.getReplicas("a", s.replicas.localDC) {
+		n.addResponse(a, nil)
+		n.addResponse(nil, fmt.Errorf("nope"))
+	}
+
+	results, err := s.cluster.ExecuteMultiKey("MGET", []string{"a", "b"}, time.Time{}, CONSISTENCY_QUORUM_LOCAL, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(results), gocheck.Equals, 2)
+	c.Check(results[0].Err, gocheck.IsNil)
+	c.Check(a.Equal(results[0].Val), gocheck.Equals, true)
+	c.Check(results[1].Err, gocheck.NotNil)
+	c.Check(results[1].Val, gocheck.IsNil)
+}
+
+// tests that multi key writes are stamped with a
+// single timestamp, and sent to every replica
+func (s *MultiKeyTest) TestMultiSet(c *gocheck.C) {
+	ts := s.cluster.Now()
+	for _, dcid := range []topology.DatacenterID{s.replicas.localDC, s.replicas.remoteDC} {
+		for _, n := range s.replicas.getReplicas("a", dcid) {
+			n.addResponse(kvstore.NewString("1", ts), nil)
+			n.addResponse(kvstore.NewString("2", ts), nil)
+		}
+	}
+
+	results, err := s.cluster.ExecuteMultiKey("MSET", []string{"a", "1", "b", "2"}, ts, CONSISTENCY_ALL, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(results), gocheck.Equals, 2)
+	c.Check(results[0].Err, gocheck.IsNil)
+	c.Check(results[1].Err, gocheck.IsNil)
+
+	for _, dcid := range []topology.DatacenterID{s.replicas.localDC, s.replicas.remoteDC} {
+		for _, n := range s.replicas.getReplicas("a", dcid) {
+			c.Assert(len(n.requests), gocheck.Equals, 2)
+			for i, expected := range []store.Instruction{
+				store.NewInstruction("SET", "a", []string{"1"}, ts),
+				store.NewInstruction("SET", "b", []string{"2"}, ts),
+			} {
+				c.Check(n.requests[i].cmd, gocheck.Equals, expected.Cmd)
+				c.Check(n.requests[i].key, gocheck.Equals, expected.Key)
+				c.Check(n.requests[i].args, gocheck.DeepEquals, expected.Args)
+				c.Check(n.requests[i].timestamp, gocheck.Equals, expected.Timestamp)
+			}
+		}
+	}
+}
+
+// tests that hints are stored for the keys a replica
+// failed to write, and the other keys still succeed
+func (s *MultiKeyTest) TestMultiDelHints(c *gocheck.C) {
+	ts := s.cluster.Now()
+	failed := s.replicas.getReplicas("a", s.replicas.localDC)[0]
+	for _, dcid := range []topology.DatacenterID{s.replicas.localDC, s.replicas.remoteDC} {
+		for _, n := range s.replicas.getReplicas("a", dcid) {
+			n.addResponse(kvstore.NewBoolean(true, ts), nil)
+			if n == failed {
+				n.addResponse(nil, fmt.Errorf("nope"))
+			} else {
+				n.addResponse(kvstore.NewBoolean(true, ts), nil)
+			}
+		}
+	}
+
+	results, err := s.cluster.ExecuteMultiKey("DEL", []string{"a", "b"}, ts, CONSISTENCY_ALL, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(results[0].Err, gocheck.IsNil)
+	c.Check(kvstore.NewBoolean(true, ts).Equal(results[0].Val), gocheck.Equals, true)
+	c.Check(results[1].Err, gocheck.NotNil)
+	c.Check(s.cluster.hints.count(failed.GetId()), gocheck.Equals, 1)
+}
+
+// write errors returned by replicas that received the write aren't hinted
+func (s *MultiKeyTest) TestMultiDelNodeErrorNotHinted(c *gocheck.C) {
+	ts := s.cluster.Now()
+	failed := s.replicas.getReplicas("a", s.replicas.localDC)[0]
+	for _, dcid := range []topology.DatacenterID{s.replicas.localDC, s.replicas.remoteDC} {
+		for _, n := range s.replicas.getReplicas("a", dcid) {
+			n.addResponse(kvstore.NewBoolean(true, ts), nil)
+			if n == failed {
+				n.addResponse(nil, NewNodeError("nope"))
+			} else {
+				n.addResponse(kvstore.NewBoolean(true, ts), nil)
+			}
+		}
+	}
+
+	results, err := s.cluster.ExecuteMultiKey("DEL", []string{"a", "b"}, ts, CONSISTENCY_ALL, time.Duration(50), false)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(results[1].Err, gocheck.NotNil)
+	c.Check(s.cluster.hints.count(failed.GetId()), gocheck.Equals, 0)
+}
+
+// multi key commands can't be executed through consensus
+func (s *MultiKeyTest) TestMultiKeyConsensus(c *gocheck.C) {
+	results, err := s.cluster.ExecuteMultiKey("MGET", []string{"a", "b"}, time.Time{}, CONSISTENCY_CONSENSUS, time.Duration(50), false)
+	c.Check(results, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+}
+
+// single key commands are rejected
+func (s *MultiKeyTest) TestSingleKeyCommand(c *gocheck.C) {
+	results, err := s.cluster.ExecuteMultiKey("GET", []string{"a"}, time.Time{}, CONSISTENCY_ONE, time.Duration(50), false)
+	c.Check(results, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+}
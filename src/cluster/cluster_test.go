@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"testing"
+	"time"
 )
 
 import (
@@ -141,6 +142,36 @@ func (t *AddNodeTest) TestAddOtherDCNode(c *gocheck.C) {
 	c.Check(rnode.GetId(), gocheck.Equals, n.GetId())
 }
 
+// tests that the consensus executor is notified when nodes are added
+func (t *AddNodeTest) TestAddingNodeNotifiesConsensus(c *gocheck.C) {
+	clstr := makeRing(5, 3)
+	executor := &mockConsensusExecutor{topologyChanges: make(chan bool, 1)}
+	clstr.SetConsensusExecutor(executor)
+	rnode := NewRemoteNodeInfo(
+		node.NewNodeId(),
+		topology.DatacenterID("DC5000"),
+		partitioner.Token([]byte{0,0,1,0}),
+		"N1",
+		"127.0.0.1:9999",
+		clstr,
+	)
+	c.Assert(clstr.addNode(rnode), gocheck.IsNil)
+
+	select {
+	case <-executor.topologyChanges:
+	case <-time.After(time.Second):
+		c.Error("consensus executor wasn't notified of the topology change")
+	}
+
+	// nodes that are already known don't change the topology
+	c.Assert(clstr.addNode(rnode), gocheck.NotNil)
+	select {
+	case <-executor.topologyChanges:
+		c.Error("consensus executor was notified of an unchanged topology")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
 /************** getPeerData tests **************/
 
 type PeerDataTest struct {}
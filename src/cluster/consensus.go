@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"fmt"
+)
+
+import (
+	"consensus"
+	"store"
+)
+
+// executes instructions whose order has to be agreed on by the
+// replicas of their keys, like list mutations, through consensus
+type ConsensusExecutor interface {
+	ExecuteQuery(instruction store.Instruction, consistency consensus.ConsistencyLevel) (store.Value, error)
+
+	// called after the cluster's topology changes, so instances
+	// whose keys changed owners can be handed off
+	HandleTopologyChange() error
+}
+
+var _ = ConsensusExecutor(&consensus.Manager{})
+
+// sets the executor that queries made at CONSENSUS and
+// CONSENSUS_LOCAL consistency are sent to
+func (c *Cluster) SetConsensusExecutor(executor ConsensusExecutor) {
+	c.consensus = executor
+}
+
+// returns true if queries at the given consistency
+// level are executed through consensus
+func isConsensusLevel(consistency ConsistencyLevel) bool {
+	switch consistency {
+	case CONSISTENCY_CONSENSUS, CONSISTENCY_CONSENSUS_LOCAL:
+		return true
+	}
+	return false
+}
+
+// returns the consensus consistency level queries made
+// at the given cluster consistency level are executed at
+func getConsensusLevel(consistency ConsistencyLevel) (consensus.ConsistencyLevel, error) {
+	switch consistency {
+	case CONSISTENCY_CONSENSUS:
+		return consensus.CONSISTENCY_CONSENSUS, nil
+	case CONSISTENCY_CONSENSUS_LOCAL:
+		return consensus.CONSISTENCY_CONSENSUS_LOCAL, nil
+	}
+	return 0, fmt.Errorf("%v consistency isn't executed through consensus", consistency)
+}
+
+// executes the given instruction through consensus. Consensus queries aren't
+// forwarded, so keys the local node doesn't replicate return a NotReplicatedError
+func (c *Cluster) executeConsensus(instruction store.Instruction, consistency ConsistencyLevel) (store.Value, error) {
+	if c.consensus == nil {
+		return nil, fmt.Errorf("%v consistency is unavailable, no consensus executor has been set", consistency)
+	}
+	level, err := getConsensusLevel(consistency)
+	if err != nil {
+		return nil, err
+	}
+	return c.consensus.ExecuteQuery(instruction, level)
+}
+
+// notifies the consensus executor, if there is one, that the topology has
+// changed. The handoff waits for in flight instances to commit, so it's
+// run in the background
+func (c *Cluster) notifyTopologyChange() {
+	if c.consensus == nil {
+		return
+	}
+	executor := c.consensus
+	go func() {
+		if err := executor.HandleTopologyChange(); err != nil {
+			logger.Warning("Error handing off consensus instances after topology change: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,29 @@
+package cluster
+
+import (
+	"consensus"
+	"store"
+)
+
+type mockConsensusExecutor struct {
+	instructions []store.Instruction
+	consistencies []consensus.ConsistencyLevel
+	val store.Value
+	err error
+	topologyChanges chan bool
+}
+
+var _ = ConsensusExecutor(&mockConsensusExecutor{})
+
+func (e *mockConsensusExecutor) ExecuteQuery(instruction store.Instruction, consistency consensus.ConsistencyLevel) (store.Value, error) {
+	e.instructions = append(e.instructions, instruction)
+	e.consistencies = append(e.consistencies, consistency)
+	return e.val, e.err
+}
+
+func (e *mockConsensusExecutor) HandleTopologyChange() error {
+	if e.topologyChanges != nil {
+		e.topologyChanges <- true
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package cluster
+
+import (
+	"sync"
+)
+
+import (
+	"node"
+	"store"
+)
+
+// the maximum number of hints held for a single node. Writes
+// missed after the limit is reached are dropped, and have to
+// be repaired by reads
+var MAX_HINTS_PER_NODE = 10000
+
+// returns true if a write that failed with the given error should
+// be hinted. Errors returned by replicas that received the write
+// would be returned again if it was replayed, so only writes that
+// didn't reach the replica are hinted
+func isHintable(err error) bool {
+	_, ok := err.(*NodeError)
+	return !ok
+}
+
+// holds writes that could not be delivered to a
+// replica, so they can be replayed once it's
+// reachable again
+type hintStore struct {
+	hints map[node.NodeId][]store.Instruction
+	lock sync.Mutex
+}
+
+func newHintStore() *hintStore {
+	return &hintStore{hints: make(map[node.NodeId][]store.Instruction)}
+}
+
+// records a write missed by the given node. Returns
+// false if the node's hints are full, and the write
+// was dropped
+func (h *hintStore) add(nid node.NodeId, instruction store.Instruction) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.hints[nid]) >= MAX_HINTS_PER_NODE {
+		return false
+	}
+	h.hints[nid] = append(h.hints[nid], instruction)
+	return true
+}
+
+// returns the number of hints held for the given node
+func (h *hintStore) count(nid node.NodeId) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.hints[nid])
+}
+
+// removes and returns all of the hints held for the given node
+func (h *hintStore) drain(nid node.NodeId) []store.Instruction {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	instructions := h.hints[nid]
+	delete(h.hints, nid)
+	return instructions
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/binary"
 	"fmt"
+	"time"
 )
 
 import (
@@ -20,6 +21,9 @@ const (
 	CONNECTION_ACCEPTED_RESPONSE = uint32(102)
 	CONNECTION_REFUSED_RESPONSE = uint32(103)
 
+	HEARTBEAT_REQUEST = uint32(104)
+	HEARTBEAT_RESPONSE = uint32(105)
+
 	DISCOVER_PEERS_REQUEST = uint32(201)
 	DISCOVER_PEERS_RESPONSE = uint32(202)
 )
@@ -112,13 +116,29 @@ func (m *PeerData) NumBytes() int {
 // sent when connecting to another node
 type ConnectionRequest struct {
 	PeerData
+	// the requesting node's wall clock time when the
+	// request was sent, used to measure clock skew
+	Time time.Time
 }
 
 var _ = message.Message(&ConnectionRequest{})
 
+func (m *ConnectionRequest) Serialize(buf *bufio.Writer) error {
+	if err := m.PeerData.Serialize(buf); err != nil { return err }
+	if err := serializer.WriteTime(buf, m.Time); err != nil { return err }
+	return nil
+}
+
+func (m *ConnectionRequest) Deserialize(buf *bufio.Reader) error {
+	if err := m.PeerData.Deserialize(buf); err != nil { return err }
+	var err error
+	if m.Time, err = serializer.ReadTime(buf); err != nil { return err }
+	return nil
+}
+
 func (m *ConnectionRequest) GetType() uint32 { return CONNECTION_REQUEST }
 
-func (m *ConnectionRequest) NumBytes() int { return m.PeerData.NumBytes() }
+func (m *ConnectionRequest) NumBytes() int { return m.PeerData.NumBytes() + serializer.NumTimeBytes() }
 
 type ConnectionAcceptedResponse struct {
 	// the id of the requesting node
@@ -129,6 +149,9 @@ type ConnectionAcceptedResponse struct {
 	Name string
 	// the token of the requesting node
 	Token partitioner.Token
+	// the accepting node's wall clock time when the
+	// request was received, used to measure clock skew
+	Time time.Time
 }
 
 
@@ -139,6 +162,7 @@ func (m *ConnectionAcceptedResponse) Serialize(buf *bufio.Writer) error {
 	if err := serializer.WriteFieldBytes(buf, []byte(m.DCId)); err != nil { return err }
 	if err := serializer.WriteFieldBytes(buf, []byte(m.Name)); err != nil { return err }
 	if err := serializer.WriteFieldBytes(buf, []byte(m.Token)); err != nil { return err }
+	if err := serializer.WriteTime(buf, m.Time); err != nil { return err }
 
 	return nil
 }
@@ -164,6 +188,8 @@ func (m *ConnectionAcceptedResponse) Deserialize(buf *bufio.Reader) error {
 	}
 	m.Token = partitioner.Token(b)
 
+	if m.Time, err = serializer.ReadTime(buf); err != nil { return err }
+
 	return nil
 }
 
@@ -184,6 +210,9 @@ func (m *ConnectionAcceptedResponse) NumBytes() int {
 	// token
 	numBytes += serializer.NumSliceBytes(m.Token)
 
+	// time
+	numBytes += serializer.NumTimeBytes()
+
 	return numBytes
 }
 
@@ -211,6 +240,56 @@ func (m *ConnectionRefusedResponse) NumBytes() int {
 	return serializer.NumStringBytes(m.Reason)
 }
 
+// periodically sent to peers to measure clock skew
+type HeartbeatRequest struct {
+	// the id of the requesting node
+	NodeId node.NodeId
+	// the requesting node's wall clock time
+	// when the request was sent
+	Time time.Time
+}
+
+var _ = message.Message(&HeartbeatRequest{})
+
+func (m *HeartbeatRequest) Serialize(buf *bufio.Writer) error {
+	if err := (&m.NodeId).WriteBuffer(buf); err != nil { return err }
+	if err := serializer.WriteTime(buf, m.Time); err != nil { return err }
+	return nil
+}
+
+func (m *HeartbeatRequest) Deserialize(buf *bufio.Reader) error {
+	if err := (&m.NodeId).ReadBuffer(buf); err != nil { return err }
+	var err error
+	if m.Time, err = serializer.ReadTime(buf); err != nil { return err }
+	return nil
+}
+
+func (m *HeartbeatRequest) GetType() uint32 { return HEARTBEAT_REQUEST }
+
+func (m *HeartbeatRequest) NumBytes() int { return types.UUID_NUM_BYTES + serializer.NumTimeBytes() }
+
+type HeartbeatResponse struct {
+	// the responding node's wall clock time
+	// when the request was received
+	Time time.Time
+}
+
+var _ = message.Message(&HeartbeatResponse{})
+
+func (m *HeartbeatResponse) Serialize(buf *bufio.Writer) error {
+	return serializer.WriteTime(buf, m.Time)
+}
+
+func (m *HeartbeatResponse) Deserialize(buf *bufio.Reader) error {
+	var err error
+	m.Time, err = serializer.ReadTime(buf)
+	return err
+}
+
+func (m *HeartbeatResponse) GetType() uint32 { return HEARTBEAT_RESPONSE }
+
+func (m *HeartbeatResponse) NumBytes() int { return serializer.NumTimeBytes() }
+
 // asks other nodes for peer info
 type DiscoverPeersRequest struct {
 	// the id of the requesting node
@@ -286,6 +365,9 @@ func init() {
 	message.RegisterMessage(CONNECTION_ACCEPTED_RESPONSE, func() message.Message {return &ConnectionAcceptedResponse{}} )
 	message.RegisterMessage(CONNECTION_REFUSED_RESPONSE, func() message.Message {return &ConnectionRefusedResponse{}} )
 
+	message.RegisterMessage(HEARTBEAT_REQUEST, func() message.Message {return &HeartbeatRequest{}} )
+	message.RegisterMessage(HEARTBEAT_RESPONSE, func() message.Message {return &HeartbeatResponse{}} )
+
 	message.RegisterMessage(DISCOVER_PEERS_REQUEST, func() message.Message {return &DiscoverPeersRequest{}} )
 	message.RegisterMessage(DISCOVER_PEERS_RESPONSE, func() message.Message {return &DiscoverPeerResponse{}} )
 }
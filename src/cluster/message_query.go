@@ -7,14 +7,19 @@ import (
 )
 
 import (
+	"hlc"
 	"message"
 	"serializer"
+	"store"
+	"types"
 )
 
 const (
 	READ_REQUEST = uint32(301)
 	WRITE_REQUEST = uint32(302)
 	QUERY_RESPONSE = uint32(303)
+	BATCH_REQUEST = uint32(304)
+	BATCH_RESPONSE = uint32(305)
 )
 
 // ----------- query execution -----------
@@ -23,6 +28,9 @@ type ReadRequest struct {
 	Cmd string
 	Key string
 	Args []string
+
+	// the sending node's clock at the time of the request
+	Clock hlc.Timestamp
 }
 
 var _ = message.Message(&ReadRequest{})
@@ -35,6 +43,7 @@ func (m *ReadRequest) Serialize(buf *bufio.Writer) error {
 	for i:=0;i<int(numArgs);i++ {
 		if err := serializer.WriteFieldBytes(buf, []byte(m.Args[i])); err != nil { return err }
 	}
+	if err := hlc.WriteTimestamp(buf, m.Clock); err != nil { return err }
 	return nil
 }
 
@@ -55,6 +64,9 @@ func (m *ReadRequest) Deserialize(buf *bufio.Reader) error {
 		}
 	}
 
+	var err error
+	if m.Clock, err = hlc.ReadTimestamp(buf); err != nil { return err }
+
 	return nil
 }
 
@@ -71,12 +83,19 @@ func (m *ReadRequest) NumBytes() int {
 	for _, arg := range m.Args {
 		numBytes += serializer.NumStringBytes(arg)
 	}
+	numBytes += hlc.NumTimestampBytes()
 	return numBytes
 }
 
 type WriteRequest struct {
 	ReadRequest
 	Timestamp time.Time
+
+	// the node that issued the timestamp
+	Origin types.UUID
+
+	// true if the write is an internal instruction, like a counter shard
+	Internal bool
 }
 
 var _ = message.Message(&WriteRequest{})
@@ -84,6 +103,8 @@ var _ = message.Message(&WriteRequest{})
 func (m *WriteRequest) Serialize(buf *bufio.Writer) error {
 	if err := m.ReadRequest.Serialize(buf); err != nil { return err }
 	if err := serializer.WriteTime(buf, m.Timestamp); err != nil { return err }
+	if err := (&m.Origin).WriteBuffer(buf); err != nil { return err }
+	if err := binary.Write(buf, binary.LittleEndian, &m.Internal); err != nil { return err }
 	return nil
 }
 
@@ -91,6 +112,8 @@ func (m *WriteRequest) Deserialize(buf *bufio.Reader) error {
 	if err := m.ReadRequest.Deserialize(buf); err != nil { return err }
 	var err error
 	if m.Timestamp, err = serializer.ReadTime(buf); err != nil { return err }
+	if err := (&m.Origin).ReadBuffer(buf); err != nil { return err }
+	if err := binary.Read(buf, binary.LittleEndian, &m.Internal); err != nil { return err }
 	return nil
 }
 
@@ -100,26 +123,39 @@ func (m *WriteRequest) GetType() uint32 { return WRITE_REQUEST }
 func (m *WriteRequest) NumBytes() int {
 	numBytes := m.ReadRequest.NumBytes()
 	numBytes += serializer.NumTimeBytes()
+	numBytes += types.UUID_NUM_BYTES
+	numBytes += 1
 	return numBytes
 }
 
 type QueryResponse struct {
+	// the responding node's clock at the time of the response
+	Clock hlc.Timestamp
+
 	// ad hoc data returned by the storage backend
 	Data [][]byte
+
+	// empty if the query succeeded
+	Error string
 }
 
 var _ = message.Message(&QueryResponse{})
 
 func (m *QueryResponse) Serialize(buf *bufio.Writer) error {
+	if err := hlc.WriteTimestamp(buf, m.Clock); err != nil { return err }
 	size := uint32(len(m.Data))
 	if err := binary.Write(buf, binary.LittleEndian, &size); err != nil { return err }
 	for i:=0;i<int(size);i++ {
 		if err := serializer.WriteFieldBytes(buf, m.Data[i]); err != nil { return err }
 	}
+	if err := serializer.WriteFieldString(buf, m.Error); err != nil { return err }
 	return nil
 }
 
 func (m *QueryResponse) Deserialize(buf *bufio.Reader) error {
+	var err error
+	if m.Clock, err = hlc.ReadTimestamp(buf); err != nil { return err }
+
 	var size uint32
 	if err := binary.Read(buf, binary.LittleEndian, &size); err != nil { return err }
 
@@ -129,6 +165,7 @@ func (m *QueryResponse) Deserialize(buf *bufio.Reader) error {
 			m.Data[i] = b
 		}
 	}
+	if m.Error, err = serializer.ReadFieldString(buf); err != nil { return err }
 
 	return nil
 }
@@ -137,7 +174,7 @@ func (m *QueryResponse) GetType() uint32 { return QUERY_RESPONSE }
 
 // TODO: implement and fix
 func (m *QueryResponse) NumBytes() int {
-	numBytes := 0
+	numBytes := hlc.NumTimestampBytes()
 
 	// num entries
 	numBytes += 4
@@ -145,12 +182,116 @@ func (m *QueryResponse) NumBytes() int {
 	for _, datum := range m.Data {
 		numBytes += serializer.NumSliceBytes(datum)
 	}
+	numBytes += serializer.NumStringBytes(m.Error)
 	return numBytes
 }
 
+// executes several instructions against a replica with
+// a single request. Instructions with zero timestamps
+// are executed as reads
+type BatchRequest struct {
+	Instructions []store.Instruction
+
+	// the sending node's clock at the time of the request
+	Clock hlc.Timestamp
+}
+
+var _ = message.Message(&BatchRequest{})
+
+func (m *BatchRequest) Serialize(buf *bufio.Writer) error {
+	numInstructions := uint32(len(m.Instructions))
+	if err := binary.Write(buf, binary.LittleEndian, &numInstructions); err != nil { return err }
+	for i := range m.Instructions {
+		if err := m.Instructions[i].Serialize(buf); err != nil { return err }
+	}
+	if err := hlc.WriteTimestamp(buf, m.Clock); err != nil { return err }
+	return nil
+}
+
+func (m *BatchRequest) Deserialize(buf *bufio.Reader) error {
+	var numInstructions uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numInstructions); err != nil { return err }
+	m.Instructions = make([]store.Instruction, numInstructions)
+	for i := range m.Instructions {
+		if err := m.Instructions[i].Deserialize(buf); err != nil { return err }
+	}
+
+	var err error
+	if m.Clock, err = hlc.ReadTimestamp(buf); err != nil { return err }
+	return nil
+}
+
+func (m *BatchRequest) GetType() uint32 { return BATCH_REQUEST }
+
+func (m *BatchRequest) NumBytes() int {
+	numBytes := 4
+	for i := range m.Instructions {
+		numBytes += m.Instructions[i].NumBytes()
+	}
+	numBytes += hlc.NumTimestampBytes()
+	return numBytes
+}
+
+// the result of a single instruction in a batch
+type BatchResult struct {
+	// the serialized value, empty if the instruction didn't return one
+	Data []byte
+
+	// empty if the instruction succeeded
+	Error string
+}
+
+type BatchResponse struct {
+	// the responding node's clock at the time of the response
+	Clock hlc.Timestamp
+
+	// results, in the order of the requested instructions
+	Results []BatchResult
+}
+
+var _ = message.Message(&BatchResponse{})
+
+func (m *BatchResponse) Serialize(buf *bufio.Writer) error {
+	if err := hlc.WriteTimestamp(buf, m.Clock); err != nil { return err }
+	numResults := uint32(len(m.Results))
+	if err := binary.Write(buf, binary.LittleEndian, &numResults); err != nil { return err }
+	for _, result := range m.Results {
+		if err := serializer.WriteFieldBytes(buf, result.Data); err != nil { return err }
+		if err := serializer.WriteFieldString(buf, result.Error); err != nil { return err }
+	}
+	return nil
+}
+
+func (m *BatchResponse) Deserialize(buf *bufio.Reader) error {
+	var err error
+	if m.Clock, err = hlc.ReadTimestamp(buf); err != nil { return err }
+
+	var numResults uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numResults); err != nil { return err }
+	m.Results = make([]BatchResult, numResults)
+	for i := range m.Results {
+		if m.Results[i].Data, err = serializer.ReadFieldBytes(buf); err != nil { return err }
+		if m.Results[i].Error, err = serializer.ReadFieldString(buf); err != nil { return err }
+	}
+	return nil
+}
+
+func (m *BatchResponse) GetType() uint32 { return BATCH_RESPONSE }
+
+func (m *BatchResponse) NumBytes() int {
+	numBytes := hlc.NumTimestampBytes()
+	numBytes += 4
+	for _, result := range m.Results {
+		numBytes += serializer.NumSliceBytes(result.Data)
+		numBytes += serializer.NumStringBytes(result.Error)
+	}
+	return numBytes
+}
 
 func init() {
 	message.RegisterMessage(READ_REQUEST, func() message.Message {return &ReadRequest{}} )
 	message.RegisterMessage(WRITE_REQUEST, func() message.Message {return &WriteRequest{}} )
 	message.RegisterMessage(QUERY_RESPONSE, func() message.Message {return &QueryResponse{}} )
+	message.RegisterMessage(BATCH_REQUEST, func() message.Message {return &BatchRequest{}} )
+	message.RegisterMessage(BATCH_RESPONSE, func() message.Message {return &BatchResponse{}} )
 }
@@ -0,0 +1,138 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/binary"
+)
+
+import (
+	"message"
+	"partitioner"
+	"serializer"
+)
+
+const (
+	SCAN_REQUEST = uint32(501)
+	SCAN_RESPONSE = uint32(502)
+)
+
+// ----------- key scanning -----------
+
+// requests a page of the live keys a replica holds in a token range
+type ScanRequest struct {
+	// the position to scan from, exclusive. If AfterToken is true,
+	// every key with the From token is skipped
+	From partitioner.Token
+	FromKey string
+	AfterToken bool
+
+	// the last token of the range, inclusive. An empty token
+	// scans to the end of the token space
+	To partitioner.Token
+
+	// glob pattern keys are filtered with, empty matches every key
+	Match string
+
+	// the maximum number of keys to examine
+	Count uint32
+}
+
+var _ = message.Message(&ScanRequest{})
+
+func (m *ScanRequest) Serialize(buf *bufio.Writer) error {
+	if err := serializer.WriteFieldBytes(buf, m.From); err != nil { return err }
+	if err := serializer.WriteFieldString(buf, m.FromKey); err != nil { return err }
+	if err := binary.Write(buf, binary.LittleEndian, &m.AfterToken); err != nil { return err }
+	if err := serializer.WriteFieldBytes(buf, m.To); err != nil { return err }
+	if err := serializer.WriteFieldString(buf, m.Match); err != nil { return err }
+	if err := binary.Write(buf, binary.LittleEndian, &m.Count); err != nil { return err }
+	return nil
+}
+
+func (m *ScanRequest) Deserialize(buf *bufio.Reader) error {
+	if b, err := serializer.ReadFieldBytes(buf); err != nil { return err } else {
+		m.From = partitioner.Token(b)
+	}
+	var err error
+	if m.FromKey, err = serializer.ReadFieldString(buf); err != nil { return err }
+	if err := binary.Read(buf, binary.LittleEndian, &m.AfterToken); err != nil { return err }
+	if b, err := serializer.ReadFieldBytes(buf); err != nil { return err } else {
+		m.To = partitioner.Token(b)
+	}
+	if m.Match, err = serializer.ReadFieldString(buf); err != nil { return err }
+	if err := binary.Read(buf, binary.LittleEndian, &m.Count); err != nil { return err }
+	return nil
+}
+
+func (m *ScanRequest) GetType() uint32 { return SCAN_REQUEST }
+
+func (m *ScanRequest) NumBytes() int {
+	numBytes := serializer.NumSliceBytes(m.From)
+	numBytes += serializer.NumStringBytes(m.FromKey)
+	numBytes += 1
+	numBytes += serializer.NumSliceBytes(m.To)
+	numBytes += serializer.NumStringBytes(m.Match)
+	numBytes += 4
+	return numBytes
+}
+
+type ScanResponse struct {
+	// the matching live keys, in token order
+	Keys []string
+
+	// the position of the last key examined, which
+	// the next page of the range is scanned from
+	LastToken partitioner.Token
+	LastKey string
+
+	// true if every key in the range has been examined
+	Done bool
+}
+
+var _ = message.Message(&ScanResponse{})
+
+func (m *ScanResponse) Serialize(buf *bufio.Writer) error {
+	numKeys := uint32(len(m.Keys))
+	if err := binary.Write(buf, binary.LittleEndian, &numKeys); err != nil { return err }
+	for _, key := range m.Keys {
+		if err := serializer.WriteFieldString(buf, key); err != nil { return err }
+	}
+	if err := serializer.WriteFieldBytes(buf, m.LastToken); err != nil { return err }
+	if err := serializer.WriteFieldString(buf, m.LastKey); err != nil { return err }
+	if err := binary.Write(buf, binary.LittleEndian, &m.Done); err != nil { return err }
+	return nil
+}
+
+func (m *ScanResponse) Deserialize(buf *bufio.Reader) error {
+	var numKeys uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numKeys); err != nil { return err }
+	m.Keys = make([]string, numKeys)
+	var err error
+	for i := range m.Keys {
+		if m.Keys[i], err = serializer.ReadFieldString(buf); err != nil { return err }
+	}
+	if b, err := serializer.ReadFieldBytes(buf); err != nil { return err } else {
+		m.LastToken = partitioner.Token(b)
+	}
+	if m.LastKey, err = serializer.ReadFieldString(buf); err != nil { return err }
+	if err := binary.Read(buf, binary.LittleEndian, &m.Done); err != nil { return err }
+	return nil
+}
+
+func (m *ScanResponse) GetType() uint32 { return SCAN_RESPONSE }
+
+func (m *ScanResponse) NumBytes() int {
+	numBytes := 4
+	for _, key := range m.Keys {
+		numBytes += serializer.NumStringBytes(key)
+	}
+	numBytes += serializer.NumSliceBytes(m.LastToken)
+	numBytes += serializer.NumStringBytes(m.LastKey)
+	numBytes += 1
+	return numBytes
+}
+
+func init() {
+	message.RegisterMessage(SCAN_REQUEST, func() message.Message {return &ScanRequest{}} )
+	message.RegisterMessage(SCAN_RESPONSE, func() message.Message {return &ScanResponse{}} )
+}
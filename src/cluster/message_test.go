@@ -10,9 +10,11 @@ import (
 )
 
 import (
+	"hlc"
 	"message"
 	"node"
 	"partitioner"
+	"store"
 	"topology"
 	"types"
 )
@@ -37,13 +39,16 @@ func (t *ClusterMessageTest) checkMessage(c *gocheck.C, src message.Message) {
 }
 
 func (t *ClusterMessageTest) TestConnectionRequest(c *gocheck.C) {
-	src := &ConnectionRequest{PeerData{
-		NodeId:node.NewNodeId(),
-		DCId:"DC5000",
-		Addr:"127.0.0.1:9999",
-		Name:"Test Node",
-		Token:partitioner.Token([]byte{0,1,2,3,4,5,6,7,0,1,2,3,4,5,6,7}),
-	}}
+	src := &ConnectionRequest{
+		PeerData:PeerData{
+			NodeId:node.NewNodeId(),
+			DCId:"DC5000",
+			Addr:"127.0.0.1:9999",
+			Name:"Test Node",
+			Token:partitioner.Token([]byte{0,1,2,3,4,5,6,7,0,1,2,3,4,5,6,7}),
+		},
+		Time:time.Unix(time.Now().Unix(), 0),
+	}
 	t.checkMessage(c, src)
 }
 
@@ -53,10 +58,24 @@ func (t *ClusterMessageTest) TestConnectionAcceptedResponse(c *gocheck.C) {
 		DCId:"DC5000",
 		Name:"Test Node",
 		Token:partitioner.Token([]byte{0,1,2,3,4,5,6,7,0,1,2,3,4,5,6,7}),
+		Time:time.Unix(time.Now().Unix(), 0),
 	}
 	t.checkMessage(c, src)
 }
 
+func (t *ClusterMessageTest) TestHeartbeatRequest(c *gocheck.C) {
+	src := &HeartbeatRequest{
+		NodeId:node.NewNodeId(),
+		Time:time.Unix(time.Now().Unix(), 0),
+	}
+	t.checkMessage(c, src)
+}
+
+func (t *ClusterMessageTest) TestHeartbeatResponse(c *gocheck.C) {
+	src := &HeartbeatResponse{Time:time.Unix(time.Now().Unix(), 0)}
+	t.checkMessage(c, src)
+}
+
 func (t *ClusterMessageTest) TestConnectionRefusedResponse(c *gocheck.C) {
 	src := &ConnectionRefusedResponse{Reason:"you suck"}
 	t.checkMessage(c, src)
@@ -96,6 +115,7 @@ func (t *ClusterMessageTest) TestReadRequest(c *gocheck.C) {
 		Cmd:  "GET",
 		Key:  "A",
 		Args: []string{"B", "C"},
+		Clock: hlc.NewClock(node.NewNodeId()).Now(),
 	}
 	t.checkMessage(c, src)
 }
@@ -106,19 +126,68 @@ func (t *ClusterMessageTest) TestWriteRequest(c *gocheck.C) {
 			Cmd:  "GET",
 			Key:  "A",
 			Args: []string{"B", "C"},
+			Clock: hlc.NewClock(node.NewNodeId()).Now(),
 		},
 		Timestamp: time.Now(),
+		Origin: node.NewNodeId().UUID,
+		Internal: true,
 	}
 	t.checkMessage(c, src)
 }
 
 func (t *ClusterMessageTest) TestQueryResponse(c *gocheck.C) {
 	src := &QueryResponse{
+		Clock: hlc.NewClock(node.NewNodeId()).Now(),
 		Data: [][]byte{
 			types.NewUUID4().Bytes(),
 			types.NewUUID4().Bytes(),
 			types.NewUUID4().Bytes(),
 		},
+		Error: "nope",
+	}
+	t.checkMessage(c, src)
+}
+
+func (t *ClusterMessageTest) TestBatchRequest(c *gocheck.C) {
+	src := &BatchRequest{
+		Instructions: []store.Instruction{
+			store.NewInstruction("GET", "A", []string{}, time.Time{}),
+			store.NewInstruction("SET", "B", []string{"C"}, time.Unix(time.Now().Unix(), 0)),
+		},
+		Clock: hlc.NewClock(node.NewNodeId()).Now(),
+	}
+	t.checkMessage(c, src)
+}
+
+func (t *ClusterMessageTest) TestBatchResponse(c *gocheck.C) {
+	src := &BatchResponse{
+		Clock: hlc.NewClock(node.NewNodeId()).Now(),
+		Results: []BatchResult{
+			BatchResult{Data:types.NewUUID4().Bytes(), Error:""},
+			BatchResult{Data:[]byte{}, Error:"nope"},
+		},
+	}
+	t.checkMessage(c, src)
+}
+
+func (t *ClusterMessageTest) TestScanRequest(c *gocheck.C) {
+	src := &ScanRequest{
+		From: partitioner.Token([]byte{0,1,2,3}),
+		FromKey: "A",
+		AfterToken: true,
+		To: partitioner.Token([]byte{4,5,6,7}),
+		Match: "a*",
+		Count: 10,
+	}
+	t.checkMessage(c, src)
+}
+
+func (t *ClusterMessageTest) TestScanResponse(c *gocheck.C) {
+	src := &ScanResponse{
+		Keys: []string{"A", "B"},
+		LastToken: partitioner.Token([]byte{0,1,2,3}),
+		LastKey: "C",
+		Done: true,
 	}
 	t.checkMessage(c, src)
 }
@@ -0,0 +1,364 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+)
+
+import (
+	"node"
+	"store"
+	"topology"
+)
+
+// the result of a single key of a multi key command
+type KeyResult struct {
+	Key string
+	Val store.Value
+	Err error
+}
+
+// nodes that can execute several instructions with a single request
+type batchExecutor interface {
+	ExecuteBatch(instructions []store.Instruction) ([]store.Value, []error)
+}
+
+// executes the instructions against the given node with a single request,
+// or one at a time if the node can't execute batches
+func executeBatch(n topology.Node, instructions []store.Instruction) ([]store.Value, []error) {
+	if b, ok := n.(batchExecutor); ok {
+		return b.ExecuteBatch(instructions)
+	}
+	vals := make([]store.Value, len(instructions))
+	errs := make([]error, len(instructions))
+	for i, inst := range instructions {
+		vals[i], errs[i] = n.ExecuteQuery(inst)
+	}
+	return vals, errs
+}
+
+// the instructions of a multi key command sent to a single replica
+type replicaBatch struct {
+	node topology.Node
+
+	// the positions of the batch's instructions in the command
+	indexes []int
+	instructions []store.Instruction
+}
+
+// a replica's response to a batch
+type batchResponse struct {
+	nid node.NodeId
+	indexes []int
+	vals []store.Value
+	errs []error
+}
+
+// tracks the responses received for a single key of a multi key
+// command, so consistency can be evaluated for each key
+type keyProgress struct {
+	numRequired map[topology.DatacenterID]int
+	numRequiredTotal int
+	numReceived map[topology.DatacenterID]int
+	numAcknowledged int
+
+	// the number of replicas sent the key, and the number that have responded
+	numReplicas int
+	numResponses int
+
+	// the values returned by each replica for reads, and the
+	// value returned for writes
+	values []store.Value
+	value store.Value
+	hasValue bool
+
+	lastErr error
+	done bool
+}
+
+func (p *keyProgress) satisfied() bool {
+	for dcid, num := range p.numRequired {
+		if p.numReceived[dcid] < num {
+			return false
+		}
+	}
+	return p.numAcknowledged >= p.numRequiredTotal
+}
+
+// groups the instructions by replica, returning a batch for each replica,
+// holding the instructions for every key it replicates, and the progress
+// trackers for each instruction's key
+func (c *Cluster) groupByReplica(
+	instructions []store.Instruction,
+	consistency ConsistencyLevel,
+	localOnly bool,
+) (map[node.NodeId]*replicaBatch, []*keyProgress, error) {
+	batches := make(map[node.NodeId]*replicaBatch)
+	progress := make([]*keyProgress, len(instructions))
+	for i, instruction := range instructions {
+		replicaMap := c.GetNodesForKey(instruction.Key)
+		numRequired, numRequiredTotal, err := consistencyRequirements(consistency, c.GetDatacenterId(), replicaMap)
+		if err != nil {
+			return nil, nil, err
+		}
+		p := &keyProgress{
+			numRequired:numRequired,
+			numRequiredTotal:numRequiredTotal,
+			numReceived:make(map[topology.DatacenterID]int, len(replicaMap)),
+		}
+
+		for dcid, nodes := range replicaMap {
+			if dcid != c.GetDatacenterId() && localOnly {
+				continue
+			}
+			for _, n := range nodes {
+				batch, exists := batches[n.GetId()]
+				if !exists {
+					batch = &replicaBatch{node:n}
+					batches[n.GetId()] = batch
+				}
+				batch.indexes = append(batch.indexes, i)
+				batch.instructions = append(batch.instructions, instruction)
+				p.numReplicas++
+			}
+		}
+		progress[i] = p
+	}
+	return batches, progress, nil
+}
+
+// executes a command operating on several keys, like MGET, MSET, or DEL
+// with several keys, against the cluster
+//
+// the command is split into single key instructions by the store, and
+// the instructions for every key a replica holds are sent to it in a single
+// batch. Consistency is evaluated for each key, and an error for one key
+// doesn't fail the others. Results are returned in the order of the keys
+func (c *Cluster) ExecuteMultiKey(
+	// the multi key command to perform
+	cmd string,
+	// the command args, including the keys
+	args []string,
+	// the timestamp to record on writes
+	timestamp time.Time,
+	// the consistency level to execute the query at, for each key
+	consistency ConsistencyLevel,
+	// query timeout
+	timeout time.Duration,
+	// if true, read reconciliation should be performed before returning
+	synchronous bool,
+) ([]KeyResult, error) {
+
+	switch consistency {
+	case CONSISTENCY_CONSENSUS, CONSISTENCY_CONSENSUS_LOCAL:
+		return nil, fmt.Errorf("Multi key commands can't be executed at %v consistency", consistency)
+	}
+
+	instructions, ok, err := c.store.SplitMultiKey(cmd, args, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%v is not a multi key command", cmd)
+	}
+	if len(instructions) == 0 {
+		return []KeyResult{}, nil
+	}
+
+	isRead := c.store.IsReadOnly(instructions[0])
+	if isRead {
+		if consistency == CONSISTENCY_ANY {
+			return nil, fmt.Errorf("ANY consistency is only supported for writes")
+		}
+	} else {
+		// stamp the writes with the local clock, unless
+		// the client provided a timestamp
+		if timestamp.IsZero() {
+			timestamp = c.Now()
+		}
+		for i, instruction := range instructions {
+			if c.store.RequiresConsensus(instruction) {
+				return nil, fmt.Errorf("%v can only be executed at %v or %v consistency", instruction.Cmd, CONSISTENCY_CONSENSUS, CONSISTENCY_CONSENSUS_LOCAL)
+			}
+			if _, isLeaderWrite := c.store.LeaderInstruction(instruction, c.GetNodeId().String()); isLeaderWrite {
+				return nil, fmt.Errorf("%v can't be executed as part of a multi key command", instruction.Cmd)
+			}
+			instructions[i] = store.NewInstruction(instruction.Cmd, instruction.Key, instruction.Args, timestamp)
+			instructions[i].Origin = c.GetNodeId().UUID
+		}
+	}
+
+	batches, progress, err := c.groupByReplica(instructions, consistency, isRead && readLocalOnly(consistency))
+	if err != nil {
+		return nil, err
+	}
+
+	// used for constructing a response
+	responseChannel := make(chan batchResponse, len(batches))
+	// used for reconciling all read responses
+	repairChannel := make(chan batchResponse, len(batches))
+
+	execute := func(nid node.NodeId, batch *replicaBatch) {
+		vals, errs := executeBatch(batch.node, batch.instructions)
+		response := batchResponse{nid:nid, indexes:batch.indexes, vals:vals, errs:errs}
+		responseChannel <- response
+		if isRead {
+			repairChannel <- response
+		}
+	}
+	for nid, batch := range batches {
+		go execute(nid, batch)
+	}
+
+	// hints a write to a replica that didn't receive it. Writes
+	// acknowledged by hints satisfy the ANY consistency level
+	hint := func(nid node.NodeId, idx int) {
+		if nid == c.GetNodeId() {
+			return
+		}
+		if !c.hints.add(nid, instructions[idx]) {
+			logger.Warning("Hints for node %v are full, dropping write to %v", nid, instructions[idx].Key)
+			return
+		}
+		if consistency == CONSISTENCY_ANY {
+			progress[idx].numAcknowledged++
+		}
+	}
+
+	// wait for every key to satisfy, or fail to satisfy, the consistency level
+	numPending := len(instructions)
+	responded := make(map[node.NodeId]bool, len(batches))
+	timeoutEvent := time.After(timeout * time.Millisecond)
+	receive:
+		for numPending > 0 {
+			select {
+			case response := <-responseChannel:
+				responded[response.nid] = true
+				dcid := batches[response.nid].node.GetDatacenterId()
+				for i, idx := range response.indexes {
+					p := progress[idx]
+					if p.done {
+						continue
+					}
+					p.numResponses++
+					if err := response.errs[i]; err != nil {
+						p.lastErr = err
+						if !isRead && isHintable(err) {
+							// the replica missed the write, hold
+							// onto it until it can be replayed
+							hint(response.nid, idx)
+						}
+					} else {
+						p.numReceived[dcid]++
+						p.numAcknowledged++
+						if isRead {
+							p.values = append(p.values, response.vals[i])
+						} else if !p.hasValue || response.nid == c.GetNodeId() {
+							// write responses are acknowledgements, not values, so they
+							// aren't reconciled. The local node's response is preferred
+							p.value = response.vals[i]
+							p.hasValue = true
+						}
+					}
+					if p.satisfied() || p.numResponses >= p.numReplicas {
+						p.done = true
+						numPending--
+					}
+				}
+			case <-timeoutEvent:
+				if !isRead {
+					// hand the writes off to the unresponsive replicas as hints
+					for nid, batch := range batches {
+						if responded[nid] {
+							continue
+						}
+						for _, idx := range batch.indexes {
+							if !progress[idx].done {
+								hint(nid, idx)
+							}
+						}
+					}
+				}
+				break receive
+			}
+		}
+
+	// assemble the results in key order
+	results := make([]KeyResult, len(instructions))
+	for i, instruction := range instructions {
+		p := progress[i]
+		results[i].Key = instruction.Key
+		switch {
+		case p.satisfied():
+			if !isRead {
+				// the write may have only been acknowledged by hints
+				results[i].Val = p.value
+				continue
+			}
+			if len(p.values) == 0 {
+				continue
+			}
+			val, _, err := c.store.Reconcile(instruction.Key, p.values)
+			if err != nil {
+				results[i].Err = fmt.Errorf("Error reconciling values: %v", err)
+			} else {
+				results[i].Val = val
+			}
+		case p.done:
+			results[i].Err = fmt.Errorf("Errors received from remote nodes, could not satisfy consistency: %v", p.lastErr)
+		default:
+			results[i].Err = nodeTimeoutError(fmt.Sprintf("Query for key [%v] not completed before timeout", instruction.Key))
+		}
+	}
+
+	// repair discrepancies
+	if isRead {
+		repairResponseTimeout := timeout * 2
+		if synchronous {
+			c.reconcileMultiKeyRead(instructions, batches, repairChannel, repairResponseTimeout)
+		} else {
+			go c.reconcileMultiKeyRead(instructions, batches, repairChannel, repairResponseTimeout)
+		}
+	}
+
+	return results, nil
+}
+
+// reconciles the values of each key of a multi key read, and
+// issues repair statements to the replicas with inaccurate values
+func (c *Cluster) reconcileMultiKeyRead(
+	instructions []store.Instruction,
+	batches map[node.NodeId]*replicaBatch,
+	rchan chan batchResponse,
+	timeout time.Duration,
+) {
+	nodeMap := make(map[node.NodeId]topology.Node, len(batches))
+	for nid, batch := range batches {
+		nodeMap[nid] = batch.node
+	}
+	values := make([][]store.Value, len(instructions))
+	valueNids := make([][]node.NodeId, len(instructions))
+
+	numReceived := 0
+	timeoutEvent := time.After(timeout * time.Millisecond)
+	receive:
+		for numReceived < len(batches) {
+			select {
+			case response := <-rchan:
+				numReceived++
+				for i, idx := range response.indexes {
+					if response.errs[i] != nil {
+						continue
+					}
+					values[idx] = append(values[idx], response.vals[i])
+					valueNids[idx] = append(valueNids[idx], response.nid)
+				}
+			case <-timeoutEvent:
+				break receive
+			}
+		}
+
+	for i, instruction := range instructions {
+		c.repairValues(instruction.Key, nodeMap, values[i], valueNids[i])
+	}
+}
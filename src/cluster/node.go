@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"fmt"
+	"sync"
 	"time"
 )
 
@@ -13,6 +14,9 @@ import (
 	"topology"
 )
 
+// error returned by a node that received a query, but failed
+// to execute it. Unlike connection errors and timeouts, replaying
+// the query against the node wouldn't succeed
 type NodeError struct {
 	reason string
 }
@@ -34,6 +38,7 @@ type baseNode struct {
 	id node.NodeId
 	dcId topology.DatacenterID
 	status topology.NodeStatus
+	statusLock sync.RWMutex
 }
 
 func (n *baseNode) Name() string { return n.name }
@@ -46,7 +51,17 @@ func (n *baseNode) GetId() node.NodeId { return n.id }
 
 func (n *baseNode) GetDatacenterId() topology.DatacenterID { return n.dcId }
 
-func (n *baseNode) GetStatus() topology.NodeStatus { return n.status }
+func (n *baseNode) GetStatus() topology.NodeStatus {
+	n.statusLock.RLock()
+	defer n.statusLock.RUnlock()
+	return n.status
+}
+
+func (n *baseNode) setStatus(status topology.NodeStatus) {
+	n.statusLock.Lock()
+	defer n.statusLock.Unlock()
+	n.status = status
+}
 
 // LocalNode provides access to the local store
 type LocalNode struct {
@@ -90,13 +105,27 @@ func (n *LocalNode) SendMessage(m message.Message) (message.Message, error) {
 }
 
 // executes a write instruction against the node's store
-func (n *LocalNode) ExecuteQuery(cmd string, key string, args []string, timestamp time.Time) (store.Value, error) {
-	_ = cmd
-	_ = key
-	_ = args
-	_ = timestamp
+func (n *LocalNode) ExecuteQuery(instruction store.Instruction) (store.Value, error) {
+	val, err := n.store.ExecuteInstruction(instruction)
+	if err != nil {
+		return nil, NewNodeError(err.Error())
+	}
+	return val, nil
+}
 
-	return nil, nil
+// executes several instructions against the node's store, returning
+// the value and error of each instruction
+func (n *LocalNode) ExecuteBatch(instructions []store.Instruction) ([]store.Value, []error) {
+	vals := make([]store.Value, len(instructions))
+	errs := make([]error, len(instructions))
+	for i, instruction := range instructions {
+		if val, err := n.store.ExecuteInstruction(instruction); err != nil {
+			errs[i] = NewNodeError(err.Error())
+		} else {
+			vals[i] = val
+		}
+	}
+	return vals, errs
 }
 
 // RemoteNode communicates with other nodes in the cluster
@@ -107,6 +136,14 @@ type RemoteNode struct {
 	cluster *Cluster
 
 	isStarted bool
+
+	// set while the node's clock is skewed beyond the cluster's
+	// maximum. Skewed nodes stay down until a handshake or
+	// heartbeat measures their clock within the maximum again
+	clockSkewed bool
+
+	// set while a heartbeat to the node is in flight
+	heartbeating int32
 }
 
 var _ = topology.Node(&RemoteNode{})
@@ -139,11 +176,34 @@ func (n *RemoteNode) Start() error {
 	conn, err := n.getConnection()
 	if err != nil { return err }
 	n.pool.Put(conn)
-	n.status = topology.NODE_UP
+	n.markUp()
 	n.isStarted = true
 	return nil
 }
 
+// marks the node as up, unless it's clock is skewed
+func (n *RemoteNode) markUp() {
+	n.statusLock.Lock()
+	defer n.statusLock.Unlock()
+	if !n.clockSkewed {
+		n.status = topology.NODE_UP
+	}
+}
+
+// records whether the node's clock is skewed beyond the cluster's
+// maximum. Skewed nodes are marked down, and are marked up again
+// once their clock is back within the maximum
+func (n *RemoteNode) setClockSkewed(skewed bool) {
+	n.statusLock.Lock()
+	defer n.statusLock.Unlock()
+	if skewed {
+		n.status = topology.NODE_DOWN
+	} else if n.clockSkewed {
+		n.status = topology.NODE_UP
+	}
+	n.clockSkewed = skewed
+}
+
 func (n *RemoteNode) Stop() error {
 	// connect to the node and get it's info
 	n.isStarted = false
@@ -161,34 +221,51 @@ func (n *RemoteNode) getConnection() (*Connection, error) {
 	if err != nil { return nil, err }
 
 	if !conn.HandshakeCompleted() {
-		msg := &ConnectionRequest{PeerData{
-			NodeId:n.cluster.GetNodeId(),
-			DCId:n.cluster.GetDatacenterId(),
-			Addr:n.cluster.GetPeerAddr(),
-			Name:n.cluster.GetName(),
-			Token:n.cluster.GetToken(),
-		}}
+		msg := &ConnectionRequest{
+			PeerData:PeerData{
+				NodeId:n.cluster.GetNodeId(),
+				DCId:n.cluster.GetDatacenterId(),
+				Addr:n.cluster.GetPeerAddr(),
+				Name:n.cluster.GetName(),
+				Token:n.cluster.GetToken(),
+			},
+			Time:time.Now(),
+		}
 		if err := message.WriteMessage(conn, msg); err != nil {
-			n.status = topology.NODE_DOWN
+			n.setStatus(topology.NODE_DOWN)
 			return nil, err
 		}
 		response, err := message.ReadMessage(conn)
+		received := time.Now()
 		if err != nil {
-			n.status = topology.NODE_DOWN
+			n.setStatus(topology.NODE_DOWN)
 			return nil, err
 		}
-		if _, ok := response.(*ConnectionAcceptedResponse); !ok {
-			n.status = topology.NODE_DOWN
+		accept, ok := response.(*ConnectionAcceptedResponse)
+		if !ok {
+			n.setStatus(topology.NODE_DOWN)
+			if refusal, ok := response.(*ConnectionRefusedResponse); ok {
+				return nil, fmt.Errorf("Connection refused: %v", refusal.Reason)
+			}
 			return nil, fmt.Errorf("Unexpected response type, expected *ConnectionAcceptedResponse, got %T", response)
-		} else if n.status == topology.NODE_INITIALIZING {
+		} else if n.GetStatus() == topology.NODE_INITIALIZING {
 			// copy the response info if we're still initializing
-			accept := response.(*ConnectionAcceptedResponse)
 			n.id = accept.NodeId
 			n.dcId = accept.DCId
 			n.name = accept.Name
 			n.token = accept.Token
 		}
 
+		if !accept.Time.IsZero() {
+			skew := estimateClockSkew(msg.Time, received, accept.Time)
+			if err := n.cluster.recordClockSkew(accept.NodeId, accept.Name, skew); err != nil {
+				conn.Close()
+				n.setClockSkewed(true)
+				return nil, err
+			}
+			n.setClockSkewed(false)
+		}
+
 		conn.SetHandshakeCompleted()
 	}
 	return conn, nil
@@ -199,7 +276,7 @@ func (n *RemoteNode) SendMessage(m message.Message) (message.Message, error) {
 	// get connection
 	conn, err := n.getConnection()
 	if  err != nil {
-		n.status = topology.NODE_DOWN
+		n.setStatus(topology.NODE_DOWN)
 		return nil, err
 	}
 
@@ -207,7 +284,7 @@ func (n *RemoteNode) SendMessage(m message.Message) (message.Message, error) {
 	// send the message
 	if err := message.WriteMessage(conn, m); err != nil {
 		conn.Close()
-		n.status = topology.NODE_DOWN
+		n.setStatus(topology.NODE_DOWN)
 		return nil, err
 	}
 
@@ -215,21 +292,108 @@ func (n *RemoteNode) SendMessage(m message.Message) (message.Message, error) {
 	response, err := message.ReadMessage(conn)
 	if err != nil {
 		conn.Close()
-		n.status = topology.NODE_DOWN
+		n.setStatus(topology.NODE_DOWN)
 		return nil, err
 	}
 
-	n.status = topology.NODE_UP
+	n.markUp()
 	n.pool.Put(conn)
 	return response, nil
 }
 
 // executes a write instruction against the node's store
-func (n *RemoteNode) ExecuteQuery(cmd string, key string, args []string, timestamp time.Time) (store.Value, error) {
-	_ = cmd
-	_ = key
-	_ = args
-	_ = timestamp
-	return nil, nil
+func (n *RemoteNode) ExecuteQuery(instruction store.Instruction) (store.Value, error) {
+	readRequest := ReadRequest{
+		Cmd:instruction.Cmd,
+		Key:instruction.Key,
+		Args:instruction.Args,
+		Clock:n.cluster.clock.Now(),
+	}
+
+	// reads don't have a timestamp
+	var request message.Message
+	if instruction.Timestamp.IsZero() {
+		request = &readRequest
+	} else {
+		request = &WriteRequest{ReadRequest:readRequest, Timestamp:instruction.Timestamp, Origin:instruction.Origin, Internal:instruction.Internal}
+	}
+
+	rawResponse, err := n.SendMessage(request)
+	if err != nil { return nil, err }
+	response, ok := rawResponse.(*QueryResponse)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected response type, expected *QueryResponse, got %T", rawResponse)
+	}
+
+	// keep the local clock ahead of the remote node's, unless
+	// it's too far ahead. The query has already been executed
+	// remotely, so the result is still returned
+	if _, err := n.cluster.clock.Update(response.Clock); err != nil {
+		logger.Warning("Not advancing clock past node %v: %v", n.Name(), err)
+	}
+
+	if response.Error != "" {
+		return nil, NewNodeError(response.Error)
+	}
+	if len(response.Data) == 0 {
+		return nil, nil
+	}
+	val, _, err := n.cluster.store.DeserializeValue(response.Data[0])
+	if err != nil { return nil, err }
+	return val, nil
 }
 
+// executes several instructions against the node's store with a single
+// request, returning the value and error of each instruction. If the
+// request fails, it's error is returned for every instruction
+func (n *RemoteNode) ExecuteBatch(instructions []store.Instruction) ([]store.Value, []error) {
+	vals := make([]store.Value, len(instructions))
+	errs := make([]error, len(instructions))
+	failAll := func(err error) ([]store.Value, []error) {
+		for i := range errs {
+			errs[i] = err
+		}
+		return vals, errs
+	}
+
+	request := &BatchRequest{Instructions:instructions, Clock:n.cluster.clock.Now()}
+	rawResponse, err := n.SendMessage(request)
+	if err != nil { return failAll(err) }
+	response, ok := rawResponse.(*BatchResponse)
+	if !ok {
+		return failAll(fmt.Errorf("Unexpected response type, expected *BatchResponse, got %T", rawResponse))
+	}
+	if len(response.Results) != len(instructions) {
+		return failAll(fmt.Errorf("Expected %v batch results, got %v", len(instructions), len(response.Results)))
+	}
+
+	// keep the local clock ahead of the remote node's, unless
+	// it's too far ahead. The query has already been executed
+	// remotely, so the result is still returned
+	if _, err := n.cluster.clock.Update(response.Clock); err != nil {
+		logger.Warning("Not advancing clock past node %v: %v", n.Name(), err)
+	}
+
+	for i, result := range response.Results {
+		if result.Error != "" {
+			errs[i] = NewNodeError(result.Error)
+			continue
+		}
+		if len(result.Data) == 0 {
+			continue
+		}
+		vals[i], _, errs[i] = n.cluster.store.DeserializeValue(result.Data)
+	}
+	return vals, errs
+}
+
+// requests a page of the keys the node holds in a token range
+func (n *RemoteNode) ScanKeys(request *ScanRequest) (*ScanResponse, error) {
+	rawResponse, err := n.SendMessage(request)
+	if err != nil { return nil, err }
+	response, ok := rawResponse.(*ScanResponse)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected response type, expected *ScanResponse, got %T", rawResponse)
+	}
+	return response, nil
+}
@@ -12,6 +12,7 @@ import (
 	"partitioner"
 	"store"
 	"topology"
+	"types"
 )
 
 type queryCall struct {
@@ -19,6 +20,7 @@ type queryCall struct {
 	key string
 	args []string
 	timestamp time.Time
+	origin types.UUID
 }
 
 type mockQueryResponse struct {
@@ -63,11 +65,17 @@ func (n *mockNode) IsStarted() bool {
 }
 
 // executes a write instruction against the node's store
-func (n *mockNode) ExecuteQuery(cmd string, key string, args []string, timestamp time.Time) (store.Value, error) {
-	call := queryCall{cmd:cmd, key:key, args:args, timestamp:timestamp}
+func (n *mockNode) ExecuteQuery(instruction store.Instruction) (store.Value, error) {
+	call := queryCall{
+		cmd:instruction.Cmd,
+		key:instruction.Key,
+		args:instruction.Args,
+		timestamp:instruction.Timestamp,
+		origin:instruction.Origin,
+	}
 	n.requests = append(n.requests, call)
 
-	n.log(fmt.Sprintf("Write Requested: %v, %v, %v, %v", cmd, key, args, timestamp))
+	n.log(fmt.Sprintf("Write Requested: %v, %v, %v, %v", call.cmd, call.key, call.args, call.timestamp))
 	response := <- n.responses
 	n.log(fmt.Sprintf("Read Response: %v", response.val))
 	return response.val, response.err
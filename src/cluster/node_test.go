@@ -1,13 +1,20 @@
 package cluster
 
+import (
+	"time"
+)
+
 import (
 	"launchpad.net/gocheck"
 )
 
 import (
+	"hlc"
+	"kvstore"
 	"message"
 	"node"
 	"partitioner"
+	"store"
 	"topology"
 )
 
@@ -119,7 +126,104 @@ func (t *RemoteNodeTest) TestMessageSendingFailureCase(c *gocheck.C) {
 
 // tests calling execute query sends correct message
 func (t *RemoteNodeTest) TestExecuteQueryMessage(c *gocheck.C) {
-	// TODO: this
-	c.Skip("TODO: this")
+	cluster := setupCluster()
+	n := NewRemoteNode("127.0.0.2:9998", cluster)
+	n.status = topology.NODE_UP
+
+	// the remote node's clock is ahead of the local clock
+	ts := cluster.Now()
+	expected := kvstore.NewString("b", ts)
+	data, err := cluster.store.SerializeValue(expected)
+	c.Assert(err, gocheck.IsNil)
+	remoteClock := hlc.FromTime(time.Now().Add(DEFAULT_MAX_CLOCK_SKEW / 2))
+	remoteClock.NodeId = node.NewNodeId()
+	response := &QueryResponse{Clock:remoteClock, Data:[][]byte{data}}
+
+	sock := newPgmConn()
+	sock.addOutgoingMessage(response)
+	conn := &Connection{socket:sock}
+	conn.SetHandshakeCompleted()
+	n.pool.Put(conn)
+
+	val, err := n.ExecuteQuery(store.NewInstruction("SET", "a", []string{"b"}, ts))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(expected.Equal(val), gocheck.Equals, true)
+
+	// check the request
+	c.Assert(len(sock.incoming), gocheck.Equals, 1)
+	c.Assert(sock.incoming[0], gocheck.FitsTypeOf, &WriteRequest{})
+	request := sock.incoming[0].(*WriteRequest)
+	c.Check(request.Cmd, gocheck.Equals, "SET")
+	c.Check(request.Key, gocheck.Equals, "a")
+	c.Check(request.Args, gocheck.DeepEquals, []string{"b"})
+	c.Check(request.Timestamp.Equal(ts), gocheck.Equals, true)
+
+	// the local clock should have been moved past the remote clock
+	c.Check(cluster.clock.Now().After(response.Clock), gocheck.Equals, true)
+}
+
+// tests that queries without a timestamp are sent as reads
+func (t *RemoteNodeTest) TestExecuteQueryReadMessage(c *gocheck.C) {
+	cluster := setupCluster()
+	n := NewRemoteNode("127.0.0.2:9998", cluster)
+	n.status = topology.NODE_UP
+
+	sock := newPgmConn()
+	sock.addOutgoingMessage(&QueryResponse{Clock:cluster.clock.Now(), Data:[][]byte{}})
+	conn := &Connection{socket:sock}
+	conn.SetHandshakeCompleted()
+	n.pool.Put(conn)
+
+	val, err := n.ExecuteQuery(store.NewInstruction("GET", "a", []string{}, time.Time{}))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(val, gocheck.IsNil)
 
+	c.Assert(len(sock.incoming), gocheck.Equals, 1)
+	c.Check(sock.incoming[0], gocheck.FitsTypeOf, &ReadRequest{})
+}
+
+// tests that responses from nodes with clocks too far ahead of the local
+// clock don't advance it, but the query's result is still returned
+func (t *RemoteNodeTest) TestExecuteQueryClockOffset(c *gocheck.C) {
+	cluster := setupCluster()
+	n := NewRemoteNode("127.0.0.2:9998", cluster)
+	n.status = topology.NODE_UP
+
+	ts := cluster.Now()
+	expected := kvstore.NewString("b", ts)
+	data, err := cluster.store.SerializeValue(expected)
+	c.Assert(err, gocheck.IsNil)
+	remoteClock := hlc.FromTime(time.Now().Add(time.Hour))
+	remoteClock.NodeId = node.NewNodeId()
+
+	sock := newPgmConn()
+	sock.addOutgoingMessage(&QueryResponse{Clock:remoteClock, Data:[][]byte{data}})
+	conn := &Connection{socket:sock}
+	conn.SetHandshakeCompleted()
+	n.pool.Put(conn)
+
+	val, err := n.ExecuteQuery(store.NewInstruction("SET", "a", []string{"b"}, ts))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(expected.Equal(val), gocheck.Equals, true)
+	c.Check(cluster.clock.Now().Before(remoteClock), gocheck.Equals, true)
+}
+
+// tests that errors returned by the remote node's store are returned as
+// node errors, and don't mark the node as down
+func (t *RemoteNodeTest) TestExecuteQueryErrorResponse(c *gocheck.C) {
+	cluster := setupCluster()
+	n := NewRemoteNode("127.0.0.2:9998", cluster)
+	n.status = topology.NODE_UP
+
+	sock := newPgmConn()
+	sock.addOutgoingMessage(&QueryResponse{Clock:cluster.clock.Now(), Data:[][]byte{}, Error:"nope"})
+	conn := &Connection{socket:sock}
+	conn.SetHandshakeCompleted()
+	n.pool.Put(conn)
+
+	val, err := n.ExecuteQuery(store.NewInstruction("GET", "a", []string{}, time.Time{}))
+	c.Check(val, gocheck.IsNil)
+	c.Assert(err, gocheck.FitsTypeOf, &NodeError{})
+	c.Check(err.Error(), gocheck.Equals, "nope")
+	c.Check(n.status, gocheck.Equals, topology.NODE_UP)
 }
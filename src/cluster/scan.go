@@ -0,0 +1,373 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+import (
+	"partitioner"
+	"topology"
+)
+
+const (
+	// the number of keys examined by each scan KEYS and DBSIZE perform
+	SCAN_BATCH_SIZE = 1000
+
+	// the cursor that starts a scan, and is returned when it's finished
+	SCAN_START_CURSOR = "0"
+)
+
+// a position in the token space. Keys are ordered by token, and
+// by key within a token, so a position stays valid if keys are
+// added or removed, or the token ring changes, during a scan
+type scanPosition struct {
+	token partitioner.Token
+	key string
+
+	// if true, the position is after every key with the token
+	afterToken bool
+}
+
+// returns true if the given key comes after the position
+func (p scanPosition) precedes(token partitioner.Token, key string) bool {
+	if cmp := bytes.Compare(token, p.token); cmp != 0 {
+		return cmp > 0
+	}
+	if p.afterToken {
+		return false
+	}
+	return key > p.key
+}
+
+// encodes the position as a cursor. Positions ending a range
+// are encoded as `<token>+`, and positions at a key as `<token>:<key>`,
+// with both the token and key hex encoded
+func (p scanPosition) cursor() string {
+	if p.afterToken {
+		return hex.EncodeToString(p.token) + "+"
+	}
+	return hex.EncodeToString(p.token) + ":" + hex.EncodeToString([]byte(p.key))
+}
+
+func parseCursor(cursor string) (scanPosition, error) {
+	if cursor == SCAN_START_CURSOR {
+		return scanPosition{}, nil
+	}
+	invalid := fmt.Errorf("Invalid cursor: %v", cursor)
+	if strings.HasSuffix(cursor, "+") {
+		token, err := hex.DecodeString(cursor[:len(cursor) - 1])
+		if err != nil {
+			return scanPosition{}, invalid
+		}
+		return scanPosition{token:partitioner.Token(token), afterToken:true}, nil
+	}
+	parts := strings.Split(cursor, ":")
+	if len(parts) != 2 {
+		return scanPosition{}, invalid
+	}
+	token, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return scanPosition{}, invalid
+	}
+	key, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return scanPosition{}, invalid
+	}
+	return scanPosition{token:partitioner.Token(token), key:string(key)}, nil
+}
+
+// a range of the local datacenter's token ring, covering the tokens
+// after the previous range's end, up to and including end. The range
+// wrapping around the end of the token space has an empty end token
+type tokenRange struct {
+	end partitioner.Token
+	replicas []topology.Node
+}
+
+func (r *tokenRange) contains(p scanPosition) bool {
+	if len(r.end) == 0 {
+		return true
+	}
+	cmp := bytes.Compare(p.token, r.end)
+	return cmp < 0 || (cmp == 0 && !p.afterToken)
+}
+
+// returns the token ranges of the local datacenter, in token order
+func (c *Cluster) localTokenRanges() []*tokenRange {
+	nodes := c.topology.AllLocalNodes()
+	ranges := make([]*tokenRange, 0, len(nodes) + 1)
+	for _, n := range nodes {
+		ranges = append(ranges, &tokenRange{
+			end:n.GetToken(),
+			replicas:c.topology.GetLocalNodesForToken(n.GetToken()),
+		})
+	}
+	// tokens after the last node's token belong to the first node
+	if len(nodes) > 0 {
+		ranges = append(ranges, &tokenRange{
+			replicas:c.topology.GetLocalNodesForToken(nodes[0].GetToken()),
+		})
+	}
+	return ranges
+}
+
+// nodes that can scan the keys they hold
+type keyScanner interface {
+	ScanKeys(request *ScanRequest) (*ScanResponse, error)
+}
+
+// scans a page of the live keys held by the local store in the
+// requested range. Keys that are deleted when they're examined,
+// including keys that have expired but haven't been swept yet,
+// count towards the request's count, but aren't returned
+func (c *Cluster) scanLocal(request *ScanRequest) *ScanResponse {
+	from := scanPosition{token:request.From, key:request.FromKey, afterToken:request.AfterToken}
+	response := &ScanResponse{Keys:make([]string, 0), Done:true}
+
+	examined := 0
+	iter := c.store.IterateKeys(c.partitioner, request.From)
+	for iter.Next() {
+		token, key := iter.Token(), iter.Key()
+		if !from.precedes(token, key) {
+			continue
+		}
+		if len(request.To) > 0 && bytes.Compare(token, request.To) > 0 {
+			break
+		}
+		if examined >= int(request.Count) {
+			response.Done = false
+			break
+		}
+		examined++
+		response.LastToken = token
+		response.LastKey = key
+
+		if c.store.IsDeleted(iter.Value()) {
+			continue
+		}
+		if request.Match != "" && !globMatch(request.Match, key) {
+			continue
+		}
+		response.Keys = append(response.Keys, key)
+	}
+	return response
+}
+
+// scans a page of keys from the given replica
+func (c *Cluster) scanReplica(n topology.Node, request *ScanRequest, timeout time.Duration) (*ScanResponse, error) {
+	if n.GetId() == c.GetNodeId() {
+		return c.scanLocal(request), nil
+	}
+	scanner, ok := n.(keyScanner)
+	if !ok {
+		return nil, fmt.Errorf("Node [%v] can't scan keys", n.GetId())
+	}
+
+	type result struct {
+		response *ScanResponse
+		err error
+	}
+	resultChan := make(chan result, 1)
+	go func() {
+		response, err := scanner.ScanKeys(request)
+		resultChan <- result{response:response, err:err}
+	}()
+	select {
+	case r := <-resultChan:
+		return r.response, r.err
+	case <-time.After(timeout * time.Millisecond):
+		return nil, nodeTimeoutError(fmt.Sprintf("Scan of node [%v] not completed before timeout", n.GetId()))
+	}
+}
+
+// scans a page of keys in the given range from one of it's replicas,
+// preferring the local node, and trying the others in ring order
+// if a replica fails or times out
+func (c *Cluster) scanRange(r *tokenRange, request *ScanRequest, timeout time.Duration) (*ScanResponse, error) {
+	replicas := make([]topology.Node, 0, len(r.replicas))
+	for _, n := range r.replicas {
+		if n.GetId() == c.GetNodeId() {
+			replicas = append([]topology.Node{n}, replicas...)
+		} else if n.GetStatus() != topology.NODE_DOWN {
+			replicas = append(replicas, n)
+		}
+	}
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("No replicas available to scan range ending at token [%v]", r.end)
+	}
+
+	var lastErr error
+	for _, n := range replicas {
+		response, err := c.scanReplica(n, request, timeout)
+		if err == nil {
+			return response, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// scans a page of the cluster's keys, starting at the given cursor, and
+// returns the cursor the next page should be scanned from, which is
+// SCAN_START_CURSOR once every key has been scanned
+//
+// the token ranges of the local datacenter are scanned in order, on a
+// single replica each. At most count keys are examined, and fewer, or
+// no, keys may be returned, either because they didn't match the
+// pattern, were deleted, or the end of a range was reached
+func (c *Cluster) Scan(
+	// the position to scan from
+	cursor string,
+	// glob pattern keys are filtered with, empty matches every key
+	match string,
+	// the maximum number of keys to examine
+	count int,
+	// query timeout
+	timeout time.Duration,
+) (string, []string, error) {
+	if count < 1 {
+		return "", nil, fmt.Errorf("COUNT must be greater than 0, got %v", count)
+	}
+	position, err := parseCursor(cursor)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var r *tokenRange
+	for _, tr := range c.localTokenRanges() {
+		if tr.contains(position) {
+			r = tr
+			break
+		}
+	}
+	if r == nil {
+		return "", nil, fmt.Errorf("No token ranges found for datacenter [%v]", c.GetDatacenterId())
+	}
+
+	request := &ScanRequest{
+		From:position.token,
+		FromKey:position.key,
+		AfterToken:position.afterToken,
+		To:r.end,
+		Match:match,
+		Count:uint32(count),
+	}
+	response, err := c.scanRange(r, request, timeout)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch {
+	case !response.Done:
+		position = scanPosition{token:response.LastToken, key:response.LastKey}
+	case len(r.end) > 0:
+		position = scanPosition{token:r.end, afterToken:true}
+	default:
+		return SCAN_START_CURSOR, response.Keys, nil
+	}
+	return position.cursor(), response.Keys, nil
+}
+
+// returns every key in the cluster matching the given pattern
+func (c *Cluster) Keys(match string, timeout time.Duration) ([]string, error) {
+	keys := make([]string, 0)
+	cursor := SCAN_START_CURSOR
+	for {
+		next, page, err := c.Scan(cursor, match, SCAN_BATCH_SIZE, timeout)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page...)
+		if next == SCAN_START_CURSOR {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// returns the number of live keys in the cluster
+func (c *Cluster) DBSize(timeout time.Duration) (int, error) {
+	keys, err := c.Keys("", timeout)
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// matches the given key against a redis style glob pattern, supporting
+// `*`, `?`, character classes like `[a-z]` and `[^abc]`, and `\` escapes
+func globMatch(pattern string, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i:=0; i<=len(key); i++ {
+				if globMatch(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			key = key[1:]
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			negate := len(pattern) > 0 && pattern[0] == '^'
+			if negate {
+				pattern = pattern[1:]
+			}
+			matched := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) > 1:
+					matched = matched || pattern[1] == key[0]
+					pattern = pattern[2:]
+				case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+					lo, hi := pattern[0], pattern[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					matched = matched || (key[0] >= lo && key[0] <= hi)
+					pattern = pattern[3:]
+				default:
+					matched = matched || pattern[0] == key[0]
+					pattern = pattern[1:]
+				}
+			}
+			if len(pattern) > 0 {
+				pattern = pattern[1:]
+			}
+			if matched == negate {
+				return false
+			}
+			key = key[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(key) == 0 || pattern[0] != key[0] {
+				return false
+			}
+			pattern = pattern[1:]
+			key = key[1:]
+		}
+	}
+	return len(key) == 0
+}
@@ -0,0 +1,202 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+)
+
+import (
+	"launchpad.net/gocheck"
+)
+
+import (
+	"message"
+	"store"
+)
+
+type ScanTest struct {
+	cluster *Cluster
+}
+
+var _ = gocheck.Suite(&ScanTest{})
+
+func (t *ScanTest) SetUpTest(c *gocheck.C) {
+	t.cluster = makeLiteralRing(1, 3)
+}
+
+func (t *ScanTest) execute(c *gocheck.C, cmd string, key string, args ...string) {
+	instruction := store.NewInstruction(cmd, key, args, time.Now())
+	_, err := t.cluster.store.ExecuteInstruction(instruction)
+	c.Assert(err, gocheck.IsNil)
+}
+
+// scans every page with the given count, returning the keys and the number of pages
+func (t *ScanTest) scanAll(c *gocheck.C, match string, count int) ([]string, int) {
+	keys := make([]string, 0)
+	cursor := SCAN_START_CURSOR
+	numPages := 0
+	for {
+		next, page, err := t.cluster.Scan(cursor, match, count, time.Duration(50))
+		c.Assert(err, gocheck.IsNil)
+		keys = append(keys, page...)
+		numPages++
+		if next == SCAN_START_CURSOR {
+			return keys, numPages
+		}
+		cursor = next
+	}
+}
+
+// tests that keys are returned in token order, and
+// deleted keys are skipped
+func (t *ScanTest) TestScan(c *gocheck.C) {
+	for i:=0; i<10; i++ {
+		t.execute(c, "SET", fmt.Sprint(i * 10), "a")
+	}
+	t.execute(c, "DEL", "30")
+
+	keys, numPages := t.scanAll(c, "", 3)
+	c.Check(keys, gocheck.DeepEquals, []string{"0", "10", "20", "40", "50", "60", "70", "80", "90"})
+	// 1 page for the range ending at the local node's token,
+	// and 3 for the 9 keys examined in the range after it
+	c.Check(numPages, gocheck.Equals, 4)
+}
+
+// tests that keys which have expired, but haven't
+// been swept by the store yet, are skipped
+func (t *ScanTest) TestScanExpired(c *gocheck.C) {
+	for i:=0; i<5; i++ {
+		t.execute(c, "SET", fmt.Sprint(i * 10), "a")
+	}
+	past := time.Now().Add(-time.Second).UnixNano() / int64(time.Millisecond)
+	t.execute(c, "PEXPIREAT", "20", fmt.Sprint(past))
+
+	keys, _ := t.scanAll(c, "", 10)
+	c.Check(keys, gocheck.DeepEquals, []string{"0", "10", "30", "40"})
+}
+
+func (t *ScanTest) TestScanMatch(c *gocheck.C) {
+	for i:=0; i<30; i++ {
+		t.execute(c, "SET", fmt.Sprint(i), "a")
+	}
+	keys, _ := t.scanAll(c, "1?", 4)
+	c.Check(keys, gocheck.DeepEquals, []string{"10", "11", "12", "13", "14", "15", "16", "17", "18", "19"})
+}
+
+// tests that the cursor's position is unaffected by writes made during the scan
+func (t *ScanTest) TestScanConcurrentWrites(c *gocheck.C) {
+	for i:=1; i<=6; i++ {
+		t.execute(c, "SET", fmt.Sprint(i * 10), "a")
+	}
+	cursor, page, err := t.cluster.Scan(SCAN_START_CURSOR, "", 10, time.Duration(50))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(page, gocheck.DeepEquals, []string{})
+
+	cursor, page, err = t.cluster.Scan(cursor, "", 3, time.Duration(50))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(page, gocheck.DeepEquals, []string{"10", "20", "30"})
+
+	// keys before the cursor aren't returned, and the
+	// removal of the cursor's key doesn't affect the scan
+	t.execute(c, "SET", "15", "a")
+	t.execute(c, "SET", "35", "a")
+	t.execute(c, "DEL", "30")
+
+	cursor, page, err = t.cluster.Scan(cursor, "", 10, time.Duration(50))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(page, gocheck.DeepEquals, []string{"35", "40", "50", "60"})
+	c.Check(cursor, gocheck.Equals, SCAN_START_CURSOR)
+}
+
+func (t *ScanTest) TestKeysAndDBSize(c *gocheck.C) {
+	for i:=0; i<25; i++ {
+		t.execute(c, "SET", fmt.Sprint(i), "a")
+	}
+	t.execute(c, "DEL", "5")
+
+	keys, err := t.cluster.Keys("2*", time.Duration(50))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(keys, gocheck.DeepEquals, []string{"2", "20", "21", "22", "23", "24"})
+
+	size, err := t.cluster.DBSize(time.Duration(50))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(size, gocheck.Equals, 24)
+}
+
+func (t *ScanTest) TestInvalidScan(c *gocheck.C) {
+	_, _, err := t.cluster.Scan("nope", "", 10, time.Duration(50))
+	c.Check(err, gocheck.NotNil)
+	_, _, err = t.cluster.Scan(SCAN_START_CURSOR, "", 0, time.Duration(50))
+	c.Check(err, gocheck.NotNil)
+}
+
+// tests that ranges the local node doesn't replicate are scanned on a replica
+func (t *ScanTest) TestScanRemoteRange(c *gocheck.C) {
+	cluster := makeLiteralRing(10, 3)
+	p := literalPartitioner{}
+
+	// the local node doesn't replicate the range ending at token 1000
+	n := cluster.topology.GetLocalNodesForToken(p.GetToken("1000"))[0].(*RemoteNode)
+	sock := newPgmConn()
+	sock.outputFactory = func(_ *pgmConn) message.Message {
+		return &ScanResponse{Keys:[]string{"500"}, LastToken:p.GetToken("500"), LastKey:"500"}
+	}
+	n.pool.Put(&Connection{socket:sock, completedHandshake:true, isClosed:false})
+
+	cursor := scanPosition{token:p.GetToken("0"), afterToken:true}.cursor()
+	next, keys, err := cluster.Scan(cursor, "5*", 10, time.Duration(50))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(keys, gocheck.DeepEquals, []string{"500"})
+	c.Check(next, gocheck.Equals, scanPosition{token:p.GetToken("500"), key:"500"}.cursor())
+
+	c.Assert(len(sock.incoming), gocheck.Equals, 1)
+	request, ok := sock.incoming[0].(*ScanRequest)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Check(request.To, gocheck.DeepEquals, p.GetToken("1000"))
+	c.Check(request.AfterToken, gocheck.Equals, true)
+	c.Check(request.Match, gocheck.Equals, "5*")
+	c.Check(request.Count, gocheck.Equals, uint32(10))
+}
+
+func (t *ScanTest) TestCursor(c *gocheck.C) {
+	positions := []scanPosition{
+		scanPosition{token:[]byte{0,1,2}, key:"a:b+"},
+		scanPosition{token:[]byte{0,1,2}, key:""},
+		scanPosition{token:[]byte{0,1,2}, afterToken:true},
+	}
+	for _, position := range positions {
+		parsed, err := parseCursor(position.cursor())
+		c.Assert(err, gocheck.IsNil)
+		c.Check(parsed, gocheck.DeepEquals, position)
+	}
+}
+
+func (t *ScanTest) TestGlobMatch(c *gocheck.C) {
+	matches := map[string][]string{
+		"*": []string{"", "abc"},
+		"a*c": []string{"ac", "abc", "abbbc"},
+		"h?llo": []string{"hello", "hallo"},
+		"h[ae]llo": []string{"hello", "hallo"},
+		"h[^e]llo": []string{"hallo", "hbllo"},
+		"h[a-c]llo": []string{"hallo", "hbllo"},
+		"h\\*llo": []string{"h*llo"},
+	}
+	for pattern, keys := range matches {
+		for _, key := range keys {
+			c.Check(globMatch(pattern, key), gocheck.Equals, true, gocheck.Commentf("%v %v", pattern, key))
+		}
+	}
+	misses := map[string][]string{
+		"a*c": []string{"ab", "bc"},
+		"h?llo": []string{"hllo", "heello"},
+		"h[ae]llo": []string{"hillo"},
+		"h[^e]llo": []string{"hello"},
+		"h[a-c]llo": []string{"hdllo"},
+		"h\\*llo": []string{"hello"},
+	}
+	for pattern, keys := range misses {
+		for _, key := range keys {
+			c.Check(globMatch(pattern, key), gocheck.Equals, false, gocheck.Commentf("%v %v", pattern, key))
+		}
+	}
+}
@@ -3,10 +3,12 @@ package cluster
 import (
 	"fmt"
 	"net"
+	"time"
 )
 
 import (
 	"message"
+	"store"
 	"topology"
 )
 
@@ -30,15 +32,45 @@ func NewPeerServer(cluster *Cluster, listenAddr string) *PeerServer {
 // executes a request and returns a response message
 func (s *PeerServer) executeRequest(node topology.Node, request message.Message) (message.Message, error) {
 	switch request.GetType() {
+	case HEARTBEAT_REQUEST:
+		return &HeartbeatResponse{Time:time.Now()}, nil
+
 	case DISCOVER_PEERS_REQUEST:
 		peerData := s.cluster.getPeerData()
 		return &DiscoverPeerResponse{Peers:peerData}, nil
 
 	case READ_REQUEST:
-		//
+		request := request.(*ReadRequest)
+		if _, err := s.cluster.clock.Update(request.Clock); err != nil {
+			return s.clockErrorResponse(err), nil
+		}
+		instruction := store.NewInstruction(request.Cmd, request.Key, request.Args, time.Time{})
+		return s.executeQuery(instruction)
 
 	case WRITE_REQUEST:
-		//
+		request := request.(*WriteRequest)
+		if _, err := s.cluster.clock.Update(request.Clock); err != nil {
+			return s.clockErrorResponse(err), nil
+		}
+		instruction := store.NewInstruction(request.Cmd, request.Key, request.Args, request.Timestamp)
+		instruction.Origin = request.Origin
+		instruction.Internal = request.Internal
+		return s.executeQuery(instruction)
+
+	case BATCH_REQUEST:
+		request := request.(*BatchRequest)
+		if _, err := s.cluster.clock.Update(request.Clock); err != nil {
+			response := &BatchResponse{Clock:s.cluster.clock.Now(), Results:make([]BatchResult, len(request.Instructions))}
+			for i := range response.Results {
+				response.Results[i].Error = err.Error()
+			}
+			return response, nil
+		}
+		return s.executeBatch(request.Instructions)
+
+	case SCAN_REQUEST:
+		request := request.(*ScanRequest)
+		return s.cluster.scanLocal(request), nil
 
 	case STREAM_REQUEST:
 		//
@@ -57,6 +89,61 @@ func (s *PeerServer) executeRequest(node topology.Node, request message.Message)
 	panic("unreachable")
 }
 
+// returns a query response refusing a request from a node whose
+// clock is too far ahead of the local clock. The request isn't
+// executed, since it's timestamps can't be trusted
+func (s *PeerServer) clockErrorResponse(err error) message.Message {
+	logger.Warning("Refusing request: %v", err)
+	return &QueryResponse{Clock:s.cluster.clock.Now(), Data:[][]byte{}, Error:err.Error()}
+}
+
+// executes a query against the local store, and returns the
+// serialized result, along with the local clock's timestamp
+func (s *PeerServer) executeQuery(instruction store.Instruction) (message.Message, error) {
+	val, err := s.cluster.localNode.ExecuteQuery(instruction)
+
+	response := &QueryResponse{Clock:s.cluster.clock.Now(), Data:[][]byte{}}
+	if err != nil {
+		// the query reached this node, so the error is returned to the
+		// requesting node, instead of closing the connection on it
+		response.Error = err.Error()
+		return response, nil
+	}
+	if val != nil {
+		b, err := s.cluster.store.SerializeValue(val)
+		if err != nil {
+			return nil, err
+		}
+		response.Data = append(response.Data, b)
+	}
+	return response, nil
+}
+
+// executes a batch of queries against the local store. Errors are
+// returned with the result of the instruction that caused them, so
+// one failed instruction doesn't fail the rest of the batch
+func (s *PeerServer) executeBatch(instructions []store.Instruction) (message.Message, error) {
+	vals, errs := s.cluster.localNode.ExecuteBatch(instructions)
+
+	response := &BatchResponse{Clock:s.cluster.clock.Now(), Results:make([]BatchResult, len(instructions))}
+	for i := range instructions {
+		if errs[i] != nil {
+			response.Results[i].Error = errs[i].Error()
+			continue
+		}
+		if vals[i] == nil {
+			continue
+		}
+		b, err := s.cluster.store.SerializeValue(vals[i])
+		if err != nil {
+			response.Results[i].Error = err.Error()
+			continue
+		}
+		response.Results[i].Data = b
+	}
+	return response, nil
+}
+
 func (s *PeerServer) handleConnection(conn net.Conn) error {
 	// check that the opening message is a ConnectionRequest
 	msg, err := message.ReadMessage(conn)
@@ -81,11 +168,26 @@ func (s *PeerServer) handleConnection(conn net.Conn) error {
 		return fmt.Errorf(errMsg)
 	}
 
+	// refuse nodes with clocks too far out of sync. Latency
+	// can't be accounted for here, so the measured skew is
+	// a slight overestimate for nodes with slower clocks
+	received := time.Now()
+	if !connectionRequest.Time.IsZero() {
+		skew := connectionRequest.Time.Sub(received)
+		if err := s.cluster.recordClockSkew(connectionRequest.NodeId, connectionRequest.Name, skew); err != nil {
+			refusal := &ConnectionRefusedResponse{Reason:err.Error()}
+			message.WriteMessage(conn, refusal)
+			conn.Close()
+			return err
+		}
+	}
+
 	// otherwise, accept it
 	acceptance := &ConnectionAcceptedResponse{
 		NodeId:s.cluster.GetNodeId(),
 		Name:s.cluster.GetName(),
 		Token:s.cluster.GetToken(),
+		Time:received,
 	}
 	if err := message.WriteMessage(conn, acceptance); err != nil {
 		return err
@@ -102,6 +204,10 @@ func (s *PeerServer) handleConnection(conn net.Conn) error {
 	)
 	s.cluster.addNode(node)
 
+	// the node may have been unreachable, send
+	// it any writes it missed in the meantime
+	go s.cluster.replayHints(node.GetId())
+
 	for {
 		// get the request
 		request, err := message.ReadMessage(conn)
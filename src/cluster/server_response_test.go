@@ -1,11 +1,18 @@
 package cluster
 
+import (
+	"time"
+)
+
 import (
 	"launchpad.net/gocheck"
 )
 
 import (
+	"hlc"
+	"kvstore"
 	"node"
+	"store"
 )
 
 type ServerResponseTest struct {}
@@ -33,6 +40,116 @@ func (t *ServerResponseTest) TestServerDiscoverPeersResponse(c *gocheck.C) {
 	c.Check(len(peerResponse.Peers), gocheck.Equals, len(clstr.getPeerData()))
 }
 
+// tests that write requests are executed against the local
+// store, and that the local clock is advanced past the sender's
+func (t *ServerResponseTest) TestServerWriteRequestResponse(c *gocheck.C) {
+	clstr := makeRing(5, 3)
+	server := &PeerServer{cluster:clstr}
+
+	// the sender's clock is ahead, but within the max offset
+	remoteClock := hlc.FromTime(time.Now().Add(DEFAULT_MAX_CLOCK_SKEW / 2))
+	remoteClock.NodeId = node.NewNodeId()
+	ts := clstr.Now()
+	msg := &WriteRequest{
+		ReadRequest:ReadRequest{
+			Cmd:"SET",
+			Key:"a",
+			Args:[]string{"b"},
+			Clock:remoteClock,
+		},
+		Timestamp:ts,
+	}
+	response, err := server.executeRequest(nil, msg)
+	c.Assert(err, gocheck.IsNil)
+
+	c.Assert(response, gocheck.FitsTypeOf, &QueryResponse{})
+	queryResponse := response.(*QueryResponse)
+	c.Check(queryResponse.Clock.After(msg.Clock), gocheck.Equals, true)
+	c.Assert(len(queryResponse.Data), gocheck.Equals, 1)
+
+	val, _, err := clstr.store.DeserializeValue(queryResponse.Data[0])
+	c.Assert(err, gocheck.IsNil)
+	c.Check(kvstore.NewString("b", ts).Equal(val), gocheck.Equals, true)
+
+	stored, err := clstr.store.GetRawKey("a")
+	c.Assert(err, gocheck.IsNil)
+	c.Check(kvstore.NewString("b", ts).Equal(stored), gocheck.Equals, true)
+}
+
+// tests that read requests for missing keys return no data
+func (t *ServerResponseTest) TestServerReadRequestResponse(c *gocheck.C) {
+	clstr := makeRing(5, 3)
+	server := &PeerServer{cluster:clstr}
+
+	msg := &ReadRequest{Cmd:"GET", Key:"a", Clock:hlc.NewClock(node.NewNodeId()).Now()}
+	response, err := server.executeRequest(nil, msg)
+	c.Assert(err, gocheck.IsNil)
+
+	c.Assert(response, gocheck.FitsTypeOf, &QueryResponse{})
+	queryResponse := response.(*QueryResponse)
+	c.Check(len(queryResponse.Data), gocheck.Equals, 0)
+	c.Check(queryResponse.Clock.IsZero(), gocheck.Equals, false)
+}
+
+// tests that queries the local store fails to execute return
+// their error in the response, instead of failing the request
+func (t *ServerResponseTest) TestServerQueryErrorResponse(c *gocheck.C) {
+	clstr := makeRing(5, 3)
+	server := &PeerServer{cluster:clstr}
+
+	msg := &ReadRequest{Cmd:"NOPE", Key:"a", Clock:hlc.NewClock(node.NewNodeId()).Now()}
+	response, err := server.executeRequest(nil, msg)
+	c.Assert(err, gocheck.IsNil)
+
+	c.Assert(response, gocheck.FitsTypeOf, &QueryResponse{})
+	queryResponse := response.(*QueryResponse)
+	c.Check(len(queryResponse.Data), gocheck.Equals, 0)
+	c.Check(queryResponse.Error, gocheck.Not(gocheck.Equals), "")
+}
+
+// tests that requests from nodes with clocks too far ahead of the
+// local clock are refused, and don't advance the local clock
+func (t *ServerResponseTest) TestServerClockOffsetResponse(c *gocheck.C) {
+	clstr := makeRing(5, 3)
+	server := &PeerServer{cluster:clstr}
+
+	remoteClock := hlc.FromTime(time.Now().Add(time.Hour))
+	remoteClock.NodeId = node.NewNodeId()
+	msg := &WriteRequest{
+		ReadRequest:ReadRequest{
+			Cmd:"SET",
+			Key:"a",
+			Args:[]string{"b"},
+			Clock:remoteClock,
+		},
+		Timestamp:clstr.Now(),
+	}
+	response, err := server.executeRequest(nil, msg)
+	c.Assert(err, gocheck.IsNil)
+
+	c.Assert(response, gocheck.FitsTypeOf, &QueryResponse{})
+	queryResponse := response.(*QueryResponse)
+	c.Check(queryResponse.Error, gocheck.Not(gocheck.Equals), "")
+	c.Check(queryResponse.Clock.Before(remoteClock), gocheck.Equals, true)
+	c.Check(clstr.clock.Now().Before(remoteClock), gocheck.Equals, true)
+
+	// the write wasn't executed
+	_, err = clstr.store.GetRawKey("a")
+	c.Check(err, gocheck.NotNil)
+
+	batch := &BatchRequest{
+		Instructions:[]store.Instruction{store.NewInstruction("SET", "a", []string{"b"}, clstr.Now())},
+		Clock:remoteClock,
+	}
+	response, err = server.executeRequest(nil, batch)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(response, gocheck.FitsTypeOf, &BatchResponse{})
+	batchResponse := response.(*BatchResponse)
+	c.Assert(len(batchResponse.Results), gocheck.Equals, 1)
+	c.Check(batchResponse.Results[0].Error, gocheck.Not(gocheck.Equals), "")
+	c.Check(clstr.clock.Now().Before(remoteClock), gocheck.Equals, true)
+}
+
 func (t *ServerResponseTest) TestStreamRequestResonse(c *gocheck.C) {
 
 }
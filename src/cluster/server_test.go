@@ -3,6 +3,7 @@ package cluster
 import (
 	"io"
 	"strings"
+	"time"
 )
 
 import (
@@ -27,12 +28,15 @@ func (t *ServerTest) TestGetUnknownNode(c *gocheck.C) {
 	conn := newBiConn(2,1)
 
 	// write input messages
-	connectMessage := &ConnectionRequest{PeerData{
-		NodeId:node.NewNodeId(),
-		Addr:"127.0.0.1:9999",
-		Name:"Test Node",
-		Token:partitioner.Token([]byte{0,1,2,3,4,5,6,7,0,1,2,3,4,5,6,7}),
-	}}
+	connectMessage := &ConnectionRequest{
+		PeerData:PeerData{
+			NodeId:node.NewNodeId(),
+			Addr:"127.0.0.1:9999",
+			Name:"Test Node",
+			Token:partitioner.Token([]byte{0,1,2,3,4,5,6,7,0,1,2,3,4,5,6,7}),
+		},
+		Time:time.Now(),
+	}
 	err := message.WriteMessage(conn.input[0], connectMessage)
 	c.Assert(err, gocheck.IsNil)
 
@@ -118,13 +122,16 @@ func (t *ServerTest) TestServerNodeRegistrationOnConnection(c *gocheck.C) {
 	conn := newBiConn(2,1)
 
 	// write input messages
-	connectMessage := &ConnectionRequest{PeerData{
-		NodeId:node.NewNodeId(),
-		DCId:"DC1",
-		Addr:"127.0.0.1:9999",
-		Name:"Test Node",
-		Token:partitioner.Token([]byte{0,1,2,3,4,5,6,7,0,1,2,3,4,5,6,7}),
-	}}
+	connectMessage := &ConnectionRequest{
+		PeerData:PeerData{
+			NodeId:node.NewNodeId(),
+			DCId:"DC1",
+			Addr:"127.0.0.1:9999",
+			Name:"Test Node",
+			Token:partitioner.Token([]byte{0,1,2,3,4,5,6,7,0,1,2,3,4,5,6,7}),
+		},
+		Time:time.Now(),
+	}
 
 	err := message.WriteMessage(conn.input[0], connectMessage)
 	c.Assert(err, gocheck.IsNil)
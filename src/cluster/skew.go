@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	"node"
+)
+
+const (
+	// the default maximum clock offset tolerated between nodes
+	DEFAULT_MAX_CLOCK_SKEW = time.Duration(500 * time.Millisecond)
+
+	// the default interval between heartbeats sent to peers
+	DEFAULT_HEARTBEAT_INTERVAL = time.Duration(1 * time.Second)
+)
+
+type clockSkewError string
+func (e clockSkewError) Error() string { return string(e) }
+
+// estimates the offset of a remote node's clock from the
+// local clock, from the local times a request was sent and
+// it's response received, and the remote time the request
+// was handled. Latency is assumed to be symmetric
+func estimateClockSkew(sent, received, remote time.Time) time.Duration {
+	midpoint := sent.Add(received.Sub(sent) / 2)
+	return remote.Sub(midpoint)
+}
+
+// holds the most recently measured clock offset of each
+// peer, and the maximum offset tolerated before warning about,
+// or refusing, connections with them. Positive values mean
+// the peer's clock is ahead
+type skewTracker struct {
+	skews map[node.NodeId]time.Duration
+	max time.Duration
+	refuse bool
+	lock sync.RWMutex
+}
+
+func newSkewTracker(max time.Duration) *skewTracker {
+	return &skewTracker{skews: make(map[node.NodeId]time.Duration), max: max}
+}
+
+func (s *skewTracker) setMax(max time.Duration, refuse bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.max = max
+	s.refuse = refuse
+}
+
+func (s *skewTracker) getMax() (time.Duration, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.max, s.refuse
+}
+
+func (s *skewTracker) set(nid node.NodeId, skew time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.skews[nid] = skew
+}
+
+func (s *skewTracker) get(nid node.NodeId) (time.Duration, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	skew, exists := s.skews[nid]
+	return skew, exists
+}
+
+// returns a copy of all measured skews
+func (s *skewTracker) all() map[node.NodeId]time.Duration {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	skews := make(map[node.NodeId]time.Duration, len(s.skews))
+	for nid, skew := range s.skews {
+		skews[nid] = skew
+	}
+	return skews
+}
+
+// sets the maximum clock offset tolerated between this node and it's
+// peers. If refuse is true, connections with peers whose clocks exceed
+// it are refused, otherwise a warning is logged. Timestamps received from
+// peers that are further ahead than it are always rejected by the local
+// clock. A max of 0 disables the check
+func (c *Cluster) SetMaxClockSkew(max time.Duration, refuse bool) {
+	c.clockSkews.setMax(max, refuse)
+	c.clock.SetMaxOffset(max)
+}
+
+// returns the most recently measured clock offset of each
+// known peer. Positive values mean the peer's clock is ahead
+func (c *Cluster) GetClockSkews() map[node.NodeId]time.Duration {
+	return c.clockSkews.all()
+}
+
+// records the measured clock offset of the given peer, and checks it
+// against the configured maximum. An error is returned if the skew
+// exceeds it, and connections with skewed peers are being refused
+func (c *Cluster) recordClockSkew(nid node.NodeId, name string, skew time.Duration) error {
+	c.clockSkews.set(nid, skew)
+
+	max, refuse := c.clockSkews.getMax()
+	if max <= 0 {
+		return nil
+	}
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs <= max {
+		return nil
+	}
+
+	if refuse {
+		return clockSkewError(fmt.Sprintf("Clock skew with node %v (%v) of %v exceeds maximum of %v", name, nid, skew, max))
+	}
+	logger.Warning("Clock skew with node %v (%v) of %v exceeds maximum of %v", name, nid, skew, max)
+	return nil
+}
+
+// sends a heartbeat to the given node, and records it's clock skew.
+// Nodes marked down because of a skewed clock are marked up again
+// once their clock is back within the maximum
+func (c *Cluster) sendHeartbeat(n *RemoteNode) error {
+	request := &HeartbeatRequest{NodeId:c.GetNodeId(), Time:time.Now()}
+	rawResponse, err := n.SendMessage(request)
+	received := time.Now()
+	if err != nil {
+		return err
+	}
+	response, ok := rawResponse.(*HeartbeatResponse)
+	if !ok {
+		return fmt.Errorf("Unexpected response type, expected *HeartbeatResponse, got %T", rawResponse)
+	}
+
+	skew := estimateClockSkew(request.Time, received, response.Time)
+	if err := c.recordClockSkew(n.GetId(), n.Name(), skew); err != nil {
+		// stop routing queries to the node until
+		// it's clock is back within the maximum
+		n.setClockSkewed(true)
+		return err
+	}
+	n.setClockSkewed(false)
+	return nil
+}
+
+// sends a heartbeat to the given node in it's own goroutine, so slow
+// or unreachable nodes don't delay heartbeats to the rest of the
+// cluster. Nodes with a heartbeat already in flight are skipped
+func (c *Cluster) sendHeartbeatAsync(n *RemoteNode) {
+	if !atomic.CompareAndSwapInt32(&n.heartbeating, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&n.heartbeating, 0)
+		if err := c.sendHeartbeat(n); err != nil {
+			logger.Warning("Heartbeat to node %v failed: %v", n.Name(), err)
+		}
+	}()
+}
+
+// periodically sends heartbeats to all remote nodes
+// until the stop channel is closed
+func (c *Cluster) heartbeatLoop(stop chan bool) {
+	ticker := time.NewTicker(c.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, v := range c.topology.AllNodes() {
+				if n, ok := v.(*RemoteNode); ok && n.IsStarted() {
+					c.sendHeartbeatAsync(n)
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
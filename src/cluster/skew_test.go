@@ -0,0 +1,200 @@
+package cluster
+
+import (
+	"time"
+)
+
+import (
+	"launchpad.net/gocheck"
+)
+
+import (
+	"message"
+	"node"
+	"partitioner"
+	"topology"
+)
+
+type ClockSkewTest struct {
+	cluster *Cluster
+}
+
+var _ = gocheck.Suite(&ClockSkewTest{})
+
+func (s *ClockSkewTest) SetUpTest(c *gocheck.C) {
+	s.cluster = setupCluster()
+}
+
+func (s *ClockSkewTest) TestEstimateClockSkew(c *gocheck.C) {
+	sent := time.Now()
+	received := sent.Add(100 * time.Millisecond)
+
+	// remote clock is 1 second ahead, request
+	// was handled half way through the round trip
+	remote := sent.Add(1050 * time.Millisecond)
+	c.Check(estimateClockSkew(sent, received, remote), gocheck.Equals, time.Second)
+
+	// remote clock is 1 second behind
+	remote = sent.Add(-950 * time.Millisecond)
+	c.Check(estimateClockSkew(sent, received, remote), gocheck.Equals, -time.Second)
+}
+
+// tests that skews are recorded, and not
+// refused when the cluster is only warning
+func (s *ClockSkewTest) TestRecordClockSkewWarn(c *gocheck.C) {
+	nid := node.NewNodeId()
+	s.cluster.SetMaxClockSkew(time.Second, false)
+
+	c.Check(s.cluster.recordClockSkew(nid, "N1", time.Minute), gocheck.IsNil)
+	c.Check(s.cluster.GetClockSkews()[nid], gocheck.Equals, time.Minute)
+}
+
+func (s *ClockSkewTest) TestRecordClockSkewRefuse(c *gocheck.C) {
+	nid := node.NewNodeId()
+	s.cluster.SetMaxClockSkew(time.Second, true)
+
+	c.Check(s.cluster.recordClockSkew(nid, "N1", 500 * time.Millisecond), gocheck.IsNil)
+	c.Check(s.cluster.recordClockSkew(nid, "N1", -500 * time.Millisecond), gocheck.IsNil)
+	c.Check(s.cluster.recordClockSkew(nid, "N1", time.Minute), gocheck.FitsTypeOf, clockSkewError(""))
+	c.Check(s.cluster.recordClockSkew(nid, "N1", -time.Minute), gocheck.FitsTypeOf, clockSkewError(""))
+	c.Check(s.cluster.GetClockSkews()[nid], gocheck.Equals, -time.Minute)
+
+	// a max of 0 disables the check
+	s.cluster.SetMaxClockSkew(0, true)
+	c.Check(s.cluster.recordClockSkew(nid, "N1", time.Minute), gocheck.IsNil)
+}
+
+// tests that the peer server refuses connections
+// from nodes with skewed clocks
+func (s *ClockSkewTest) TestServerRefusesSkewedNode(c *gocheck.C) {
+	s.cluster.SetMaxClockSkew(time.Second, true)
+	conn := newBiConn(2, 1)
+	connectMessage := &ConnectionRequest{
+		PeerData:PeerData{
+			NodeId:node.NewNodeId(),
+			DCId:"DC5000",
+			Addr:"127.0.0.1:9998",
+			Name:"Test Node",
+			Token:partitioner.Token([]byte{0,1,2,3,4,5,6,7,0,1,2,3,4,5,6,7}),
+		},
+		Time:time.Now().Add(time.Minute),
+	}
+	err := message.WriteMessage(conn.input[0], connectMessage)
+	c.Assert(err, gocheck.IsNil)
+
+	server := &PeerServer{cluster:s.cluster}
+	err = server.handleConnection(conn)
+	c.Assert(err, gocheck.FitsTypeOf, clockSkewError(""))
+
+	response, err := message.ReadMessage(conn.output[0])
+	c.Assert(err, gocheck.IsNil)
+	c.Check(response, gocheck.FitsTypeOf, &ConnectionRefusedResponse{})
+
+	// the node shouldn't have been added
+	_, err = s.cluster.topology.GetNode(connectMessage.NodeId)
+	c.Check(err, gocheck.NotNil)
+
+	skew, exists := s.cluster.clockSkews.get(connectMessage.NodeId)
+	c.Assert(exists, gocheck.Equals, true)
+	c.Check(skew > 59 * time.Second, gocheck.Equals, true)
+}
+
+// tests that remote nodes refuse to complete
+// handshakes with peers with skewed clocks
+func (s *ClockSkewTest) TestHandshakeRefusesSkewedPeer(c *gocheck.C) {
+	s.cluster.SetMaxClockSkew(time.Second, true)
+	sock := newPgmConn()
+	sock.addOutgoingMessage(&ConnectionAcceptedResponse{
+		NodeId:node.NewNodeId(),
+		DCId:"DC5000",
+		Name:"Ghost",
+		Token:partitioner.Token([]byte{0,0,0,0,0,0,0,0,0,0,0,0,0,1,2,3}),
+		Time:time.Now().Add(-time.Minute),
+	})
+
+	n := NewRemoteNode("127.0.0.2:9998", s.cluster)
+	conn := &Connection{socket:sock}
+	n.pool.Put(conn)
+
+	err := n.Start()
+	c.Assert(err, gocheck.FitsTypeOf, clockSkewError(""))
+	c.Check(conn.HandshakeCompleted(), gocheck.Equals, false)
+	c.Check(n.GetStatus(), gocheck.Equals, topology.NODE_DOWN)
+}
+
+// tests that heartbeats record the peer's clock
+// skew, and mark skewed peers as down
+func (s *ClockSkewTest) TestHeartbeat(c *gocheck.C) {
+	s.cluster.SetMaxClockSkew(time.Second, true)
+	sock := newPgmConn()
+	sock.addOutgoingMessage(&HeartbeatResponse{Time:time.Now()})
+	sock.addOutgoingMessage(&HeartbeatResponse{Time:time.Now().Add(time.Minute)})
+
+	n := NewRemoteNodeInfo(node.NewNodeId(), "DC5000", partitioner.Token([]byte{0,0,1}), "N1", "127.0.0.2:9998", s.cluster)
+	conn := &Connection{socket:sock}
+	conn.SetHandshakeCompleted()
+	n.pool.Put(conn)
+
+	c.Assert(s.cluster.sendHeartbeat(n), gocheck.IsNil)
+	c.Check(n.GetStatus(), gocheck.Equals, topology.NODE_UP)
+	_, exists := s.cluster.clockSkews.get(n.GetId())
+	c.Check(exists, gocheck.Equals, true)
+
+	c.Assert(s.cluster.sendHeartbeat(n), gocheck.FitsTypeOf, clockSkewError(""))
+	c.Check(n.GetStatus(), gocheck.Equals, topology.NODE_DOWN)
+
+	c.Assert(len(sock.incoming), gocheck.Equals, 2)
+	c.Check(sock.incoming[0], gocheck.FitsTypeOf, &HeartbeatRequest{})
+}
+
+// tests that nodes marked down because of a skewed clock aren't
+// marked up by other messages, but are marked up again once a
+// heartbeat measures their clock within the maximum
+func (s *ClockSkewTest) TestHeartbeatRestoresSkewedNode(c *gocheck.C) {
+	s.cluster.SetMaxClockSkew(time.Second, true)
+	sock := newPgmConn()
+	sock.addOutgoingMessage(&HeartbeatResponse{Time:time.Now().Add(time.Minute)})
+	sock.addOutgoingMessage(&HeartbeatResponse{Time:time.Now()})
+	sock.addOutgoingMessage(&HeartbeatResponse{Time:time.Now()})
+
+	n := NewRemoteNodeInfo(node.NewNodeId(), "DC5000", partitioner.Token([]byte{0,0,1}), "N1", "127.0.0.2:9998", s.cluster)
+	conn := &Connection{socket:sock}
+	conn.SetHandshakeCompleted()
+	n.pool.Put(conn)
+
+	c.Assert(s.cluster.sendHeartbeat(n), gocheck.FitsTypeOf, clockSkewError(""))
+	c.Check(n.GetStatus(), gocheck.Equals, topology.NODE_DOWN)
+
+	// a successful message exchange shouldn't mark the node up
+	_, err := n.SendMessage(&HeartbeatRequest{NodeId:s.cluster.GetNodeId(), Time:time.Now()})
+	c.Assert(err, gocheck.IsNil)
+	c.Check(n.GetStatus(), gocheck.Equals, topology.NODE_DOWN)
+
+	c.Assert(s.cluster.sendHeartbeat(n), gocheck.IsNil)
+	c.Check(n.GetStatus(), gocheck.Equals, topology.NODE_UP)
+}
+
+// tests that heartbeats aren't sent to nodes
+// with a heartbeat already in flight
+func (s *ClockSkewTest) TestHeartbeatInFlight(c *gocheck.C) {
+	sock := newPgmConn()
+	sock.addOutgoingMessage(&HeartbeatResponse{Time:time.Now()})
+
+	n := NewRemoteNodeInfo(node.NewNodeId(), "DC5000", partitioner.Token([]byte{0,0,1}), "N1", "127.0.0.2:9998", s.cluster)
+	conn := &Connection{socket:sock}
+	conn.SetHandshakeCompleted()
+	n.pool.Put(conn)
+
+	n.heartbeating = 1
+	s.cluster.sendHeartbeatAsync(n)
+	c.Check(len(sock.incoming), gocheck.Equals, 0)
+}
+
+func (s *ClockSkewTest) TestServerHeartbeatResponse(c *gocheck.C) {
+	server := &PeerServer{cluster:s.cluster}
+	before := time.Now()
+	response, err := server.executeRequest(nil, &HeartbeatRequest{NodeId:node.NewNodeId(), Time:before})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(response, gocheck.FitsTypeOf, &HeartbeatResponse{})
+	c.Check(response.(*HeartbeatResponse).Time.Before(before), gocheck.Equals, false)
+}
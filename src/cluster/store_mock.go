@@ -7,6 +7,7 @@ import (
 )
 
 import (
+	"partitioner"
 	"serializer"
 )
 
@@ -33,6 +34,7 @@ type mockStore struct {
 	isRead bool
 	isWrite bool
 	returnsValue bool
+	requiresConsensus bool
 }
 
 var _ = store.Store(&mockStore{})
@@ -82,6 +84,20 @@ func (s *mockStore) Reconcile(key string, values []store.Value) (store.Value, []
 
 func (s *mockStore) IsReadOnly(instruction store.Instruction) bool { return s.isRead }
 func (s *mockStore) IsWriteOnly(instruction store.Instruction) bool { return s.isWrite }
+func (s *mockStore) RequiresConsensus(instruction store.Instruction) bool { return s.requiresConsensus }
+func (s *mockStore) LeaderInstruction(instruction store.Instruction, leader string) (store.Instruction, bool) {
+	return instruction, false
+}
+func (s *mockStore) ReplicationInstruction(instruction store.Instruction, leader string, result store.Value) (store.Instruction, error) {
+	return instruction, nil
+}
+func (s *mockStore) SplitMultiKey(cmd string, args []string, timestamp time.Time) ([]store.Instruction, bool, error) {
+	instructions := make([]store.Instruction, len(args))
+	for i, key := range args {
+		instructions[i] = store.NewInstruction(cmd, key, []string{}, timestamp)
+	}
+	return instructions, true, nil
+}
 func (s *mockStore) InterferingKeys(instruction store.Instruction) []string { return []string{instruction.Key} }
 func (s *mockStore) ReturnsValue(cmd string) bool { return s.returnsValue }
 func (s *mockStore) Start() error { s.isStarted = true; return nil }
@@ -91,7 +107,11 @@ func (s *mockStore) DeserializeValue(b []byte) (store.Value, store.ValueType, er
 func (s *mockStore) GetRawKey(key string) (store.Value, error) { return nil, nil}
 func (s *mockStore) SetRawKey(key string, val store.Value) error { return nil }
 func (s *mockStore) GetKeys() []string { return []string{} }
+func (s *mockStore) IterateKeys(p partitioner.Partitioner, start partitioner.Token) store.Iterator {
+	return store.NewIterator(start, 0, func(store.PageRead) {})
+}
 func (s *mockStore) KeyExists(key string) bool { return true }
+func (s *mockStore) IsDeleted(val store.Value) bool { return false }
 
 // values
 
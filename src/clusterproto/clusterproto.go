@@ -15,9 +15,17 @@ import (
 type ConsistencyLevel string
 
 const (
+	CONSISTENCY_ANY				= ConsistencyLevel("ANY")
+	// ONE only waits on a response from the local datacenter
 	CONSISTENCY_ONE 			= ConsistencyLevel("ONE")
+	// LOCAL_ONE waits on a response from the local datacenter,
+	// and only queries the local datacenter's replicas
+	CONSISTENCY_LOCAL_ONE		= ConsistencyLevel("LOCAL_ONE")
+	// QUORUM waits on a quorum of responses from every datacenter
 	CONSISTENCY_QUORUM			= ConsistencyLevel("QUORUM")
 	CONSISTENCY_QUORUM_LOCAL 	= ConsistencyLevel("QUORUM_LOCAL")
+	// EACH_QUORUM waits on a quorum of responses in each datacenter
+	CONSISTENCY_EACH_QUORUM		= ConsistencyLevel("EACH_QUORUM")
 	CONSISTENCY_ALL 			= ConsistencyLevel("ALL")
 	CONSISTENCY_ALL_LOCAL		= ConsistencyLevel("ALL_LOCAL")
 	CONSISTENCY_CONSENSUS		= ConsistencyLevel("CONSENSUS")
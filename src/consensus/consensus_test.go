@@ -37,6 +37,7 @@ var (
 	benchProfile = flag.Bool("bench.profile", false, "activates performance profiling")
 	benchNumKeys = flag.Int("bench.numkeys", 1, "activates performance profiling")
 	benchMaster = flag.Bool("bench.master", false, "only executes queries against a single node")
+	benchBatchWindow = flag.Uint64("bench.batchwindow", 0, "milliseconds queries are batched into a single instance for, 0 disables batching")
 	benchExport = flag.Bool("bench.export", false, "exports inconsistent dependency chain if set")
 )
 
@@ -182,10 +183,10 @@ func (s *ConsensusQueryBenchmarks) checkConsistency(c *gocheck.C) {
 	}()
 	nodeInstructions := make([]map[string][]*Instance, s.numNodes)
 	getKey := func(inst *Instance) string {
-		return inst.Command.Key
+		return inst.Commands[0].Key
 	}
 	getVal := func(inst *Instance) string {
-		return inst.Command.Args[0]
+		return inst.Commands[0].Args[0]
 	}
 	for i, n := range s.nodes {
 
@@ -293,7 +294,7 @@ func (s *ConsensusQueryBenchmarks) checkConsistency(c *gocheck.C) {
 						for j, n := range s.nodes {
 							instances := make([]*Instance, 0)
 							for _, instance := range n.manager.instances.Instances() {
-								if instance.Command.Key == key {
+								if instance.Commands[0].Key == key {
 									instances = append(instances, instance)
 								}
 							}
@@ -410,6 +411,10 @@ func (s *ConsensusQueryBenchmarks) runBenchmark(numQueries int, c *gocheck.C) {
 	numAccept := 0
 	numPrepare := 0
 
+	oldBatchWindow := QUERY_BATCH_WINDOW
+	QUERY_BATCH_WINDOW = *benchBatchWindow
+	defer func() { QUERY_BATCH_WINDOW = oldBatchWindow }()
+
 	oldAcceptPhase := managerAcceptPhase
 	managerAcceptPhase = func(m *Manager, instance *Instance) error {
 		numAccept++
@@ -447,7 +452,7 @@ func (s *ConsensusQueryBenchmarks) runBenchmark(numQueries int, c *gocheck.C) {
 			key = s.keys[0]
 		}
 		instructions := store.NewInstruction("set", key, []string{fmt.Sprint(i)}, time.Now())
-		_, err := manager.ExecuteQuery(instructions)
+		_, err := manager.ExecuteQuery(instructions, s.consistency)
 		if err != nil {
 			s.stats.Inc("query.failed", 1, 1.0)
 			logger.Info("FAILED QUERY: %v\n", err)
@@ -27,6 +27,44 @@ func NewBallotError(format string, a ...interface{}) BallotError {
 	return BallotError{fmt.Sprintf(format, a...)}
 }
 
+// returned if a transaction isn't executed because
+// one of the keys it was watching had changed
+type TransactionAbortedError struct {
+	message string
+}
+
+func (e TransactionAbortedError) Error() string  { return e.message }
+func (e TransactionAbortedError) String() string { return e.message }
+func NewTransactionAbortedError(format string, a ...interface{}) TransactionAbortedError {
+	return TransactionAbortedError{fmt.Sprintf(format, a...)}
+}
+
+// returned if a query is rejected because the local node
+// is leading too many instances. The query hasn't been
+// started, so it's safe for the client to retry it
+type OverloadedError struct {
+	message string
+}
+
+func (e OverloadedError) Error() string  { return e.message }
+func (e OverloadedError) String() string { return e.message }
+func NewOverloadedError(format string, a ...interface{}) OverloadedError {
+	return OverloadedError{fmt.Sprintf(format, a...)}
+}
+
+// returned if a query is made on a node that doesn't
+// replicate its keys. Queries aren't forwarded to the
+// key's replicas, so the client should retry on one of them
+type NotReplicatedError struct {
+	message string
+}
+
+func (e NotReplicatedError) Error() string  { return e.message }
+func (e NotReplicatedError) String() string { return e.message }
+func NewNotReplicatedError(format string, a ...interface{}) NotReplicatedError {
+	return NotReplicatedError{fmt.Sprintf(format, a...)}
+}
+
 // returned if a request is aborted because
 // it's no longer valid (ie: running a prepare phase on a committed
 // instance)
@@ -41,6 +41,29 @@ func (s InstanceStatus) String() string {
 	panic("unreachable")
 }
 
+// determines which replicas run consensus on an instance
+type ConsistencyLevel byte
+
+const (
+	// consensus is run by the replicas in the local datacenter
+	CONSISTENCY_CONSENSUS_LOCAL = ConsistencyLevel(iota)
+
+	// consensus is run by the replicas in every datacenter
+	CONSISTENCY_CONSENSUS
+)
+
+func (c ConsistencyLevel) String() string {
+	switch c {
+	case CONSISTENCY_CONSENSUS_LOCAL:
+		return "CONSISTENCY_CONSENSUS_LOCAL"
+	case CONSISTENCY_CONSENSUS:
+		return "CONSISTENCY_CONSENSUS"
+	default:
+		return fmt.Sprintf("Unknown ConsistencyLevel: %v", byte(c))
+	}
+	panic("unreachable")
+}
+
 type InstanceID struct {
 	types.UUID
 }
@@ -50,8 +73,19 @@ func NewInstanceID() InstanceID {
 }
 
 type InstanceResult struct {
-	val store.Value
+	// the results of each of the instance's instructions
+	vals []store.Value
+	errs []error
+
+	// set if the instance couldn't be executed
 	err error
+
+	// set if none of the instructions were executed because
+	// one of the instance's watched keys had changed
+	aborted bool
+
+	// the timestamps of the watched keys, for instances without instructions
+	watched []time.Time
 }
 
 type InstanceResultChan (chan InstanceResult)
@@ -65,6 +99,14 @@ func NewInstanceResultChan() InstanceResultChan {
 	return make(chan InstanceResult, 1)
 }
 
+// a key watched by a transaction, and the timestamp of its last write,
+// including deletes, when it was watched. A zero timestamp indicates
+// the key didn't exist
+type WatchedKey struct {
+	Key string
+	Timestamp time.Time
+}
+
 type DepsLog struct {
 	Reason string
 	Deps []InstanceID
@@ -81,8 +123,14 @@ type Instance struct {
 	// the order other nodes handle prepare phases
 	Successors []node.NodeId
 
-	// the Instruction to be executed
-	Command store.Instruction
+	// the Instructions to be executed. They may operate on different
+	// keys, owned by different replica sets, and are executed together
+	Commands []store.Instruction
+
+	// keys watched by the client before the instance was created. If the
+	// value of any of them has changed when the instance is executed, none
+	// of the commands are executed
+	Watches []WatchedKey
 
 	// a list of other instance ids that
 	// execution of this instance depends on
@@ -94,6 +142,11 @@ type Instance struct {
 	// the highest seen message number for this instance
 	MaxBallot uint32
 
+	// the ballot the instance's current attributes were preaccepted or
+	// accepted at. Unlike MaxBallot, it isn't raised by prepare requests,
+	// so prepare phases can tell which attributes were voted for last
+	AcceptedBallot uint32
+
 	// indicates that the paxos protocol
 	// for this instance failed, and this
 	// instance should be ignored
@@ -115,6 +168,17 @@ type Instance struct {
 	// and writes
 	ReadOnly bool
 
+	// the topology epoch the instance was created at. All of
+	// the instance's phases, including prepare, message the
+	// replicas that owned it's keys at this epoch. Zero indicates
+	// that the current topology should be used
+	Epoch uint64
+
+	// the consistency level the instance was created at, this
+	// determines the replicas, quorum sizes, and timeouts used
+	// by all of the instance's phases
+	Consistency ConsistencyLevel
+
 	// channels that clients are waiting on for results
 	ResultListeners []InstanceResultChan
 
@@ -256,16 +320,24 @@ func (i *Instance) Copy() (*Instance, error) {
 		InstanceID: i.InstanceID,
 		LeaderID: i.LeaderID,
 		Successors: make([]node.NodeId, len(i.Successors)),
-		Command: i.Command.Copy(),
+		Commands: make([]store.Instruction, len(i.Commands)),
+		Watches: make([]WatchedKey, len(i.Watches)),
 		Dependencies: make([]InstanceID, len(i.Dependencies)),
 		Status: i.Status,
 		MaxBallot: i.MaxBallot,
+		AcceptedBallot: i.AcceptedBallot,
 		Noop: i.Noop,
 		DependencyMatch: i.DependencyMatch,
+		Epoch: i.Epoch,
+		Consistency: i.Consistency,
 		commitTimeout: i.commitTimeout,
 		manager: i.manager,
 	}
 	copy(newInst.Successors, i.Successors)
+	for idx, instruction := range i.Commands {
+		newInst.Commands[idx] = instruction.Copy()
+	}
+	copy(newInst.Watches, i.Watches)
 	copy(newInst.Dependencies, i.Dependencies)
 
 	return newInst, nil
@@ -285,6 +357,7 @@ func (i *Instance) mergeAttributes(deps []InstanceID) (bool, error) {
 	oSet := NewInstanceIDSet(deps)
 	if !iSet.Equal(oSet) {
 		changes = true
+		i.DependencyMatch = false
 		union := iSet.Union(oSet)
 		deps = make([]InstanceID, 0, len(union))
 		for id := range union {
@@ -319,10 +392,15 @@ func (i *Instance) preaccept(inst *Instance, incrementBallot bool) error {
 	} else {
 		i.setDeps("preaccept", deps)
 	}
-	i.commitTimeout = makePreAcceptCommitTimeout()
+	i.commitTimeout = makePreAcceptCommitTimeout(i.Consistency)
 	if incrementBallot {
 		i.MaxBallot++
+
+		// the leader's dependencies agree with themselves, replicas
+		// set the flag when they handle the leader's message
+		i.DependencyMatch = true
 	}
+	i.AcceptedBallot = i.MaxBallot
 	return nil
 }
 
@@ -346,10 +424,11 @@ func (i *Instance) accept(inst *Instance, incrementBallot bool) error {
 		inst.lock.RUnlock()
 	}
 	i.Status = INSTANCE_ACCEPTED
-	i.commitTimeout = makeAcceptCommitTimeout()
+	i.commitTimeout = makeAcceptCommitTimeout(i.Consistency)
 	if incrementBallot {
 		i.MaxBallot++
 	}
+	i.AcceptedBallot = i.MaxBallot
 	return nil
 }
 
@@ -411,7 +490,17 @@ func (i *Instance) NumBytesLimitedUnsafe() int {
 	numBytes += types.UUID_NUM_BYTES * len(i.Successors)
 
 	// instructions
-	numBytes += i.Command.NumBytes()
+	numBytes += 4  // num instructions header
+	for idx := range i.Commands {
+		numBytes += i.Commands[idx].NumBytes()
+	}
+
+	// watches
+	numBytes += 4  // num watches header
+	for idx := range i.Watches {
+		numBytes += serializer.NumStringBytes(i.Watches[idx].Key)
+		numBytes += serializer.NumTimeBytes()
+	}
 
 	// dependencies
 	numBytes += 4  // num dependencies header
@@ -420,7 +509,8 @@ func (i *Instance) NumBytesLimitedUnsafe() int {
 	// status
 	numBytes += 1
 
-	// ballot
+	// ballot, accepted ballot
+	numBytes += 4
 	numBytes += 4
 
 	// noop
@@ -432,6 +522,12 @@ func (i *Instance) NumBytesLimitedUnsafe() int {
 	// read only
 	numBytes += 1
 
+	// epoch
+	numBytes += 8
+
+	// consistency
+	numBytes += 1
+
 	return numBytes
 }
 
@@ -451,7 +547,18 @@ func (i *Instance) SerializeLimitedUnsafe(buf *bufio.Writer) error {
 		if err := (&i.Successors[idx]).WriteBuffer(buf); err != nil { return err }
 	}
 
-	if err := i.Command.Serialize(buf); err != nil { return nil}
+	numCommands := uint32(len(i.Commands))
+	if err := binary.Write(buf, binary.LittleEndian, &numCommands); err != nil { return err }
+	for idx := range i.Commands {
+		if err := i.Commands[idx].Serialize(buf); err != nil { return err }
+	}
+
+	numWatches := uint32(len(i.Watches))
+	if err := binary.Write(buf, binary.LittleEndian, &numWatches); err != nil { return err }
+	for idx := range i.Watches {
+		if err := serializer.WriteFieldString(buf, i.Watches[idx].Key); err != nil { return err }
+		if err := serializer.WriteTime(buf, i.Watches[idx].Timestamp); err != nil { return err }
+	}
 
 	numDeps := uint32(len(i.Dependencies))
 	if err := binary.Write(buf, binary.LittleEndian, &numDeps); err != nil { return err }
@@ -461,6 +568,7 @@ func (i *Instance) SerializeLimitedUnsafe(buf *bufio.Writer) error {
 
 	if err := binary.Write(buf, binary.LittleEndian, &i.Status); err != nil { return err }
 	if err := binary.Write(buf, binary.LittleEndian, &i.MaxBallot); err != nil { return err }
+	if err := binary.Write(buf, binary.LittleEndian, &i.AcceptedBallot); err != nil { return err }
 
 	var noop byte
 	if i.Noop { noop = 0xff }
@@ -474,6 +582,9 @@ func (i *Instance) SerializeLimitedUnsafe(buf *bufio.Writer) error {
 	if i.ReadOnly { readonly = 0xff }
 	if err := binary.Write(buf, binary.LittleEndian, &readonly); err != nil { return err }
 
+	if err := binary.Write(buf, binary.LittleEndian, &i.Epoch); err != nil { return err }
+	if err := binary.Write(buf, binary.LittleEndian, &i.Consistency); err != nil { return err }
+
 	return nil
 }
 
@@ -488,7 +599,24 @@ func (i *Instance) DeserializeLimited(buf *bufio.Reader) error {
 		if err := (&i.Successors[idx]).ReadBuffer(buf); err != nil { return err }
 	}
 
-	if err := i.Command.Deserialize(buf); err != nil { return err }
+	var numCommands uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numCommands); err != nil { return err }
+	i.Commands = make([]store.Instruction, numCommands)
+	for idx := range i.Commands {
+		if err := i.Commands[idx].Deserialize(buf); err != nil { return err }
+	}
+
+	var numWatches uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numWatches); err != nil { return err }
+	i.Watches = make([]WatchedKey, numWatches)
+	for idx := range i.Watches {
+		if val, err := serializer.ReadFieldString(buf); err != nil { return err } else {
+			i.Watches[idx].Key = val
+		}
+		if val, err := serializer.ReadTime(buf); err != nil { return err } else {
+			i.Watches[idx].Timestamp = val
+		}
+	}
 
 	var numDeps uint32
 	if err := binary.Read(buf, binary.LittleEndian, &numDeps); err != nil { return err }
@@ -500,6 +628,7 @@ func (i *Instance) DeserializeLimited(buf *bufio.Reader) error {
 
 	if err := binary.Read(buf, binary.LittleEndian, &i.Status); err != nil { return err }
 	if err := binary.Read(buf, binary.LittleEndian, &i.MaxBallot); err != nil { return err }
+	if err := binary.Read(buf, binary.LittleEndian, &i.AcceptedBallot); err != nil { return err }
 
 	var noop byte
 	if err := binary.Read(buf, binary.LittleEndian, &noop); err != nil { return err }
@@ -513,6 +642,9 @@ func (i *Instance) DeserializeLimited(buf *bufio.Reader) error {
 	if err := binary.Read(buf, binary.LittleEndian, &readonly); err != nil { return err }
 	i.ReadOnly = readonly != 0x0
 
+	if err := binary.Read(buf, binary.LittleEndian, &i.Epoch); err != nil { return err }
+	if err := binary.Read(buf, binary.LittleEndian, &i.Consistency); err != nil { return err }
+
 	return nil
 }
 
@@ -142,7 +142,14 @@ func (s *InstanceSerializationTest) TestSerialization(c *gocheck.C) {
 		InstanceID: NewInstanceID(),
 		LeaderID: node.NewNodeId(),
 		Successors: []node.NodeId{node.NewNodeId(), node.NewNodeId(), node.NewNodeId()},
-		Command: store.NewInstruction("set", "a", []string{"b", "c"}, time.Now()),
+		Commands: []store.Instruction{
+			store.NewInstruction("set", "a", []string{"b", "c"}, time.Now()),
+			store.NewInstruction("set", "d", []string{"e"}, time.Now()),
+		},
+		Watches: []WatchedKey{
+			WatchedKey{Key: "f", Timestamp: time.Now()},
+			WatchedKey{Key: "g"},
+		},
 		Dependencies: []InstanceID{NewInstanceID(), NewInstanceID()},
 		Status: INSTANCE_ACCEPTED,
 		MaxBallot: uint32(500),
@@ -150,6 +157,8 @@ func (s *InstanceSerializationTest) TestSerialization(c *gocheck.C) {
 		commitTimeout: time.Now(),
 		DependencyMatch: true,
 		ReadOnly: true,
+		Epoch: uint64(7),
+		Consistency: CONSISTENCY_CONSENSUS,
 	}
 	writer := bufio.NewWriter(buf)
 	err = src.Serialize(writer)
@@ -45,7 +45,7 @@ var _ = gocheck.Suite(&PreAcceptIntegrationTest{})
 
 func (s *PreAcceptIntegrationTest) TestSuccessCase(c *gocheck.C) {
 	// make a pre-existing instance
-	knownInstance := s.manager.makeInstance(s.makeInstruction(0))
+	knownInstance := s.manager.makeInstance(s.consistency, s.makeInstruction(0))
 	for _, manager := range s.managers {
 		inst, err := knownInstance.Copy()
 		c.Assert(err, gocheck.IsNil)
@@ -55,7 +55,7 @@ func (s *PreAcceptIntegrationTest) TestSuccessCase(c *gocheck.C) {
 	}
 
 	// run a preaccept phase on a new instance
-	newInstance := s.replicaManagers[0].makeInstance(s.makeInstruction(2))
+	newInstance := s.replicaManagers[0].makeInstance(s.consistency, s.makeInstruction(2))
 	shouldAccept, err := s.manager.preAcceptPhase(newInstance)
 	c.Assert(shouldAccept, gocheck.Equals, false)
 	c.Assert(err, gocheck.IsNil)
@@ -72,7 +72,7 @@ func (s *PreAcceptIntegrationTest) TestSuccessCase(c *gocheck.C) {
 
 func (s *PreAcceptIntegrationTest) TestMissingInstanceSuccessCase(c *gocheck.C) {
 	// make a pre-existing instance
-	knownInstance := s.manager.makeInstance(s.makeInstruction(0))
+	knownInstance := s.manager.makeInstance(s.consistency, s.makeInstruction(0))
 	for _, manager := range s.managers {
 		inst, err := knownInstance.Copy()
 		c.Assert(err, gocheck.IsNil)
@@ -82,7 +82,7 @@ func (s *PreAcceptIntegrationTest) TestMissingInstanceSuccessCase(c *gocheck.C)
 	}
 
 	// make an instance known by a subset of replicas
-	remoteInstance := s.replicaManagers[0].makeInstance(s.makeInstruction(1))
+	remoteInstance := s.replicaManagers[0].makeInstance(s.consistency, s.makeInstruction(1))
 	quorumSize := (len(s.replicas) / 2) + 1
 	c.Assert(quorumSize < len(s.replicas), gocheck.Equals, true)
 	for i:=0; i<quorumSize; i++ {
@@ -93,7 +93,7 @@ func (s *PreAcceptIntegrationTest) TestMissingInstanceSuccessCase(c *gocheck.C)
 	}
 
 	// run a preaccept phase on a new instance
-	newInstance := s.replicaManagers[0].makeInstance(s.makeInstruction(2))
+	newInstance := s.replicaManagers[0].makeInstance(s.consistency, s.makeInstruction(2))
 	shouldAccept, err := s.manager.preAcceptPhase(newInstance)
 	c.Assert(shouldAccept, gocheck.Equals, true)
 	c.Assert(err, gocheck.IsNil)
@@ -124,7 +124,7 @@ var _ = gocheck.Suite(&AcceptIntegrationTest{})
 // test successful accept cycle
 func (s *AcceptIntegrationTest) TestAcceptSuccessCase(c *gocheck.C) {
 	// make a pre-existing instance
-	knownInstance := s.manager.makeInstance(s.makeInstruction(0))
+	knownInstance := s.manager.makeInstance(s.consistency, s.makeInstruction(0))
 	for _, manager := range s.managers {
 		inst, err := knownInstance.Copy()
 		c.Assert(err, gocheck.IsNil)
@@ -134,7 +134,7 @@ func (s *AcceptIntegrationTest) TestAcceptSuccessCase(c *gocheck.C) {
 	}
 
 	// make an instance known by a subset of replicas
-	remoteInstance := s.replicaManagers[0].makeInstance(s.makeInstruction(1))
+	remoteInstance := s.replicaManagers[0].makeInstance(s.consistency, s.makeInstruction(1))
 	quorumSize := (len(s.replicas) / 2) + 1
 	c.Assert(quorumSize < len(s.replicas), gocheck.Equals, true)
 	for i:=0; i<quorumSize; i++ {
@@ -145,7 +145,7 @@ func (s *AcceptIntegrationTest) TestAcceptSuccessCase(c *gocheck.C) {
 	}
 
 	// run a preaccept phase on a new instance
-	newInstance := s.replicaManagers[0].makeInstance(s.makeInstruction(2))
+	newInstance := s.replicaManagers[0].makeInstance(s.consistency, s.makeInstruction(2))
 	shouldAccept, err := s.manager.preAcceptPhase(newInstance)
 	c.Assert(shouldAccept, gocheck.Equals, true)
 	c.Assert(err, gocheck.IsNil)
@@ -181,7 +181,7 @@ var _ = gocheck.Suite(&CommitIntegrationTest{})
 // an accept message, but receive a commit message with the correct deps
 func (s *CommitIntegrationTest) TestSkippedAcceptSuccessCase(c *gocheck.C) {
 	// make a pre-existing instance
-	knownInstance := s.manager.makeInstance(s.makeInstruction(0))
+	knownInstance := s.manager.makeInstance(s.consistency, s.makeInstruction(0))
 	for _, manager := range s.managers {
 		inst, err := knownInstance.Copy()
 		c.Assert(err, gocheck.IsNil)
@@ -191,7 +191,7 @@ func (s *CommitIntegrationTest) TestSkippedAcceptSuccessCase(c *gocheck.C) {
 	}
 
 	// make an instance known by a subset of replicas
-	remoteInstance := s.replicaManagers[0].makeInstance(s.makeInstruction(1))
+	remoteInstance := s.replicaManagers[0].makeInstance(s.consistency, s.makeInstruction(1))
 	quorumSize := (len(s.replicas) / 2) + 1
 	c.Assert(quorumSize < len(s.replicas), gocheck.Equals, true)
 	for i:=0; i<quorumSize; i++ {
@@ -202,7 +202,7 @@ func (s *CommitIntegrationTest) TestSkippedAcceptSuccessCase(c *gocheck.C) {
 	}
 
 	// run a preaccept phase on a new instance
-	newInstance := s.replicaManagers[0].makeInstance(s.makeInstruction(2))
+	newInstance := s.replicaManagers[0].makeInstance(s.consistency, s.makeInstruction(2))
 	shouldAccept, err := s.manager.preAcceptPhase(newInstance)
 	c.Assert(shouldAccept, gocheck.Equals, true)
 	c.Assert(err, gocheck.IsNil)
@@ -256,7 +256,7 @@ func (s *PrepareIntegrationTest) waitOnStatus(iid InstanceID, status InstanceSta
 func (s *PrepareIntegrationTest) TestPreparePreAccept(c *gocheck.C) {
 	// make and accept the instance across the cluster
 	instructions := s.makeInstruction(0)
-	instance := s.manager.makeInstance(instructions)
+	instance := s.manager.makeInstance(s.consistency, instructions)
 	c.Logf("Leader ID: %v", instance.LeaderID)
 	initialBallot := instance.getBallot()
 	shouldAccept, err := s.manager.preAcceptPhase(instance)
@@ -297,8 +297,9 @@ func (s *PrepareIntegrationTest) TestPreparePreAccept(c *gocheck.C) {
 	err = s.manager.preparePhase(instance)
 	s.waitForStatus(instance.InstanceID, INSTANCE_COMMITTED)
 	c.Assert(err, gocheck.IsNil)
-	// ballot +3: prepare, preaccept, and commit messages
-	// TODO: this fails 10% of the time, obtained ballot 3, expected 4
+	// ballot +3: prepare, accept, and commit messages, every replica,
+	// including the leader, agreed with the leader's dependencies, so
+	// the preaccept phase is skipped
 	c.Assert(instance.getBallot(), gocheck.Equals, localBallot1 + 3)
 	c.Assert(instance.getStatus(), gocheck.Equals, INSTANCE_COMMITTED)
 	c.Assert(s.manager.instances.Get(instance.InstanceID), gocheck.Equals, instance)
@@ -317,7 +318,7 @@ func (s *PrepareIntegrationTest) TestPrepareAccept(c *gocheck.C) {
 
 	// make and accept the instance across the cluster
 	instruction := store.NewInstruction("set", "a", []string{fmt.Sprint(0)}, time.Now())
-	instance := s.manager.makeInstance(instruction)
+	instance := s.manager.makeInstance(s.consistency, instruction)
 	initialBallot := instance.getBallot()
 	err = s.manager.acceptPhase(instance)
 	c.Assert(err, gocheck.IsNil)
@@ -361,3 +362,37 @@ func (s *PrepareIntegrationTest) TestPrepareAccept(c *gocheck.C) {
 	c.Assert(s.manager.instances.Get(instance.InstanceID), gocheck.Equals, instance)
 }
 
+// the integration tests above, run by replicas in multiple datacenters
+// at the CONSISTENCY_CONSENSUS level
+type CrossDatacenterPreAcceptIntegrationTest struct {
+	PreAcceptIntegrationTest
+}
+
+var _ = gocheck.Suite(&CrossDatacenterPreAcceptIntegrationTest{})
+
+func (s *CrossDatacenterPreAcceptIntegrationTest) SetUpSuite(c *gocheck.C) {
+	s.baseReplicaTest.SetUpSuite(c)
+	s.consistency = CONSISTENCY_CONSENSUS
+}
+
+type CrossDatacenterAcceptIntegrationTest struct {
+	AcceptIntegrationTest
+}
+
+var _ = gocheck.Suite(&CrossDatacenterAcceptIntegrationTest{})
+
+func (s *CrossDatacenterAcceptIntegrationTest) SetUpSuite(c *gocheck.C) {
+	s.baseReplicaTest.SetUpSuite(c)
+	s.consistency = CONSISTENCY_CONSENSUS
+}
+
+type CrossDatacenterCommitIntegrationTest struct {
+	CommitIntegrationTest
+}
+
+var _ = gocheck.Suite(&CrossDatacenterCommitIntegrationTest{})
+
+func (s *CrossDatacenterCommitIntegrationTest) SetUpSuite(c *gocheck.C) {
+	s.baseReplicaTest.SetUpSuite(c)
+	s.consistency = CONSISTENCY_CONSENSUS
+}
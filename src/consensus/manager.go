@@ -3,6 +3,7 @@ package consensus
 import (
 	"fmt"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 )
@@ -14,6 +15,7 @@ import (
 import (
 	"message"
 	"node"
+	"partitioner"
 	"store"
 	"topology"
 )
@@ -54,6 +56,11 @@ var (
 	// successor is up while waiting on a prepare phase
 	SUCCESSOR_CONTACT_INTERVAL = uint64(1000)
 
+	// the message and commit timeouts of CONSISTENCY_CONSENSUS
+	// instances are multiplied by this, to allow for the latency
+	// between datacenters
+	CONSENSUS_TIMEOUT_MULTIPLIER = uint64(4)
+
 	// wait period between retrying operations
 	// that failed due to ballot failures
 	BALLOT_FAILURE_WAIT_TIME = uint64(250)
@@ -69,6 +76,60 @@ var (
 	// assumed to have failed, and they execute it
 	EXECUTE_TIMEOUT = uint64(500)
 
+	// if true, read only queries are executed locally, without
+	// running an instance, if a quorum of replicas confirms that
+	// no interfering writes are in flight
+	LEADERLESS_READS = false
+
+	// timeout receiving a quorum of read responses
+	READ_TIMEOUT = uint64(2000)
+
+	// the amount of time a leader will wait for other
+	// queries to batch into the same instance as a new
+	// query. Zero disables batching
+	QUERY_BATCH_WINDOW = uint64(0)
+
+	// the maximum number of queries batched into a single
+	// instance. A full batch is executed without waiting
+	// for the batch window to end
+	QUERY_BATCH_MAX_SIZE = 64
+
+	// if true, preaccept and accept messages are only sent to
+	// the replicas needed for a quorum, and the other replicas
+	// are only messaged if responses are late, or fail
+	THRIFTY = false
+
+	// the amount of time a thrifty leader will wait on
+	// responses before messaging the other replicas
+	THRIFTY_FALLBACK_TIMEOUT = uint64(50)
+
+	// the amount of time a leader will wait for a fast path
+	// quorum of preaccept responses, once it's received a
+	// slow path quorum, before running an accept phase
+	FAST_PATH_TIMEOUT = uint64(50)
+
+	// the number of queries led by the local node that can
+	// be in flight before new queries are delayed. Zero disables
+	// delays
+	ADMISSION_DELAY_THRESHOLD = 0
+
+	// the delay added to new queries for each in flight
+	// query past the delay threshold
+	ADMISSION_DELAY_STEP = uint64(1)
+
+	// the longest a new query will be delayed
+	ADMISSION_MAX_DELAY = uint64(100)
+
+	// the number of queries led by the local node that can
+	// be in flight before new queries are rejected with an
+	// OverloadedError. Zero disables rejections
+	ADMISSION_REJECT_THRESHOLD = 0
+
+	// the amount of time a topology change will wait for
+	// in flight instances to commit before they're handed
+	// off to their new replicas uncommitted
+	HANDOFF_DRAIN_TIMEOUT = uint64(2000)
+
 	STATS_SAMPLE_RATE = float32(0.1)
 
 	// flag enabling additional instance info to be kept
@@ -77,39 +138,17 @@ var (
 
 /*
 
-TODO: support instances with multiple instructions, operating on different keys, owned by multiple nodes
-	* will need to determine dependencies from different nodes, and track the status of
-		dependencies it doesn't own (weird / hard / error prone)
-	* this will be a much bigger job than anticipated, should factor out support for multiple
-		instruction instances for now
-
 TODO: add and test ballot checking to every message handler
 TODO: add a 'passive' flag to executeInstance, that will execute if possible, but not prepare
 TODO: instead of jumping into a prepare, the preparer, or successor should first check if the leader is still 'working' on the query
 
-TODO: consider batching up queries into a single instance
-
 TODO: make execute query tolerant of prepare phases updating the status of it's instance
 
 TODO: remove commit timeouts, replace with last activity (last message sent received)
-TODO: add missing instances "requests" to preaccept, accept, and commit message replies
 
 Prepare successors should increment the last activity time outs by their order in the successor list. The
 farther they are from first successor, the higher their timeout should be
 
-TODO: make the prepare phase a bit smarter
-
-Prepare phase improvement:
-If the prepare responses are all preaccepted instances with the dependency match flag set, prepare should send a commit
-If the prepare responses are all preaccepted instances with the same ballot, the dependencies should be merged and an
-	accept message should be sent.
-If the prepare responses are all accepted instances with the same ballot, seq, and deps, a commit message should be sent
-If any response has a committed or executed messages, a commit message should be sent to all replicas
-
-TODO: work out a way to prioritize the completion of existing commands vs the processing of new ones
-
-Keep a count of instances in progress, and delay new queries proportionally
-
 TODO: think about potential race conditions caused by multiple goroutines running executeDependencyChain concurrently
 
  */
@@ -143,6 +182,15 @@ func (e *event) broadcast() {
 	e.cond.Broadcast()
 }
 
+// returns the given timeout, in milliseconds, as a duration
+// adjusted for the given consistency level
+func getConsistencyTimeout(consistency ConsistencyLevel, timeout uint64) time.Duration {
+	if consistency == CONSISTENCY_CONSENSUS {
+		timeout *= CONSENSUS_TIMEOUT_MULTIPLIER
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
 var consensusTimeoutEvent = func(d time.Duration) <-chan time.Time {
 	return time.After(d)
 }
@@ -167,6 +215,10 @@ type Manager struct {
 	executedLock sync.RWMutex
 
 	depsMngr  *dependencyManager
+
+	batcher *queryBatcher
+
+	admission *admissionController
 }
 
 func NewManager(
@@ -185,6 +237,8 @@ func NewManager(
 	}
 
 	mngr.depsMngr = newDependencyManager(mngr)
+	mngr.batcher = newQueryBatcher(mngr)
+	mngr.admission = newAdmissionController(mngr)
 	return mngr
 }
 
@@ -195,10 +249,103 @@ func (m *Manager) setStatter(s statsd.Statter) {
 	m.stats = s
 }
 
-// returns the replicas for the manager's key, at the given  consistency level
-func (m *Manager) getInstanceNodes(instance *Instance) []topology.Node {
-	tk := m.topology.GetToken(instance.Command.Key)
-	return m.topology.GetLocalNodesForToken(tk)
+// returns the distinct keys the given instance's instructions operate on
+func (m *Manager) getInstanceKeys(instance *Instance) []string {
+	keys := make([]string, 0, len(instance.Commands) + len(instance.Watches))
+	seen := make(map[string]bool, len(instance.Commands) + len(instance.Watches))
+	for _, instruction := range instance.Commands {
+		if seen[instruction.Key] { continue }
+		seen[instruction.Key] = true
+		keys = append(keys, instruction.Key)
+	}
+	for _, watch := range instance.Watches {
+		if seen[watch.Key] { continue }
+		seen[watch.Key] = true
+		keys = append(keys, watch.Key)
+	}
+	return keys
+}
+
+// returns the replicas of the given token at the given epoch and consistency
+// level. Replicas in the local datacenter are ordered before the others
+func (m *Manager) getTokenReplicas(tk partitioner.Token, epoch uint64, consistency ConsistencyLevel) ([]topology.Node, error) {
+	if consistency != CONSISTENCY_CONSENSUS {
+		return m.topology.GetLocalNodesForTokenAtEpoch(tk, epoch)
+	}
+
+	dcNodes, err := m.topology.GetNodesForTokenAtEpoch(tk, epoch)
+	if err != nil {
+		return nil, err
+	}
+	localDC := m.topology.GetLocalDatacenterID()
+	dcIDs := make([]string, 0, len(dcNodes))
+	for dcid := range dcNodes {
+		if dcid != localDC {
+			dcIDs = append(dcIDs, string(dcid))
+		}
+	}
+	sort.Strings(dcIDs)
+
+	nodes := make([]topology.Node, 0)
+	nodes = append(nodes, dcNodes[localDC]...)
+	for _, dcid := range dcIDs {
+		nodes = append(nodes, dcNodes[topology.DatacenterID(dcid)]...)
+	}
+	return nodes, nil
+}
+
+// returns the replicas of the given key at the instance's topology epoch and
+// consistency level. An error is returned if the epoch isn't known locally.
+// The current replicas can't be used in it's place, since they may not
+// intersect the quorums the instance's attributes were agreed on by
+func (m *Manager) getKeyReplicas(instance *Instance, key string) ([]topology.Node, error) {
+	tk := m.topology.GetToken(key)
+	epoch := instance.Epoch
+	if epoch == 0 {
+		epoch = m.topology.GetEpoch()
+	}
+	nodes, err := m.getTokenReplicas(tk, epoch, instance.Consistency)
+	if err != nil {
+		m.statsInc("manager.epoch.unknown.count", 1)
+		return nil, fmt.Errorf("Unable to get replicas for instance %v at epoch %v: %v", instance.InstanceID, instance.Epoch, err)
+	}
+	return nodes, nil
+}
+
+// returns the replicas for all of the instance's keys, at the given consistency level
+func (m *Manager) getInstanceNodes(instance *Instance) ([]topology.Node, error) {
+	nodes := make([]topology.Node, 0)
+	seen := make(map[node.NodeId]bool)
+	for _, key := range m.getInstanceKeys(instance) {
+		keyNodes, err := m.getKeyReplicas(instance, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range keyNodes {
+			if seen[n.GetId()] { continue }
+			seen[n.GetId()] = true
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, nil
+}
+
+// returns the ids of the replicas for each of the instance's keys. An instance
+// needs a quorum of responses from each of them to make progress
+func (m *Manager) getInstanceReplicaSets(instance *Instance) ([][]node.NodeId, error) {
+	keys := m.getInstanceKeys(instance)
+	replicaSets := make([][]node.NodeId, len(keys))
+	for i, key := range keys {
+		nodes, err := m.getKeyReplicas(instance, key)
+		if err != nil {
+			return nil, err
+		}
+		replicaSets[i] = make([]node.NodeId, len(nodes))
+		for j, n := range nodes {
+			replicaSets[i][j] = n.GetId()
+		}
+	}
+	return replicaSets, nil
 }
 
 func (m *Manager) checkLocalKeyEligibility(key string) bool {
@@ -206,20 +353,64 @@ func (m *Manager) checkLocalKeyEligibility(key string) bool {
 	return m.topology.TokenLocallyReplicated(tk)
 }
 
+// returns true if the instance's keys aren't all owned by the same replicas
+func (m *Manager) instanceSpansReplicaSets(instance *Instance) (bool, error) {
+	nodes, err := m.getInstanceNodes(instance)
+	if err != nil {
+		return false, err
+	}
+	replicaSets, err := m.getInstanceReplicaSets(instance)
+	if err != nil {
+		return false, err
+	}
+	for _, replicaSet := range replicaSets {
+		if len(replicaSet) != len(nodes) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// returns copies of the local instances the given instance depends on
+func (m *Manager) getDependencyInstances(instance *Instance) ([]*Instance, error) {
+	deps := instance.getDependencies()
+	instances := make([]*Instance, 0, len(deps))
+	for _, iid := range deps {
+		dep := m.instances.Get(iid)
+		if dep == nil {
+			continue
+		}
+		depCopy, err := dep.Copy()
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, depCopy)
+	}
+	return instances, nil
+}
+
+// returns true if the local node replicates any of the instance's keys
 func (m *Manager) checkLocalInstanceEligibility(instance *Instance) bool {
-	return m.checkLocalKeyEligibility(instance.Command.Key)
+	for _, key := range m.getInstanceKeys(instance) {
+		if m.checkLocalKeyEligibility(key) {
+			return true
+		}
+	}
+	return false
 }
 
-// returns the replicas for the given instance's key, excluding the local node
-func (m *Manager) getInstanceReplicas(instance *Instance) []node.Node {
-	tk := m.topology.GetToken(instance.Command.Key)
-	nodes := m.topology.GetLocalNodesForToken(tk)
+// returns the replicas for all of the given instance's keys, excluding the local node
+func (m *Manager) getInstanceReplicas(instance *Instance) ([]node.Node, error) {
+	nodes, err := m.getInstanceNodes(instance)
+	if err != nil {
+		return nil, err
+	}
 	replicas := make([]node.Node, 0, len(nodes))
 	for _, n := range nodes {
 		if n.GetId() == m.nodeID { continue }
 		replicas = append(replicas, n)
 	}
-	return replicas
+	return replicas, nil
 }
 
 func (m *Manager) GetLocalID() node.NodeId {
@@ -238,6 +429,10 @@ func (m *Manager) HandleMessage(msg message.Message) (message.Message, error) {
 		return m.HandlePrepare(request)
 	case *PrepareSuccessorRequest:
 		return m.HandlePrepareSuccessor(request)
+	case *InstanceRequest:
+		return m.HandleInstanceRequest(request)
+	case *ReadRequest:
+		return m.HandleRead(request)
 	default:
 		return nil, fmt.Errorf("Unhandled request type: %T", request)
 	}
@@ -295,15 +490,25 @@ func (m *Manager) getInstanceDeps(instance *Instance) ([]InstanceID, error) {
 	return managerGetInstanceDeps(m, instance)
 }
 
-// creates a bare epaxos instance from the given instructions
-func (m *Manager) makeInstance(instruction store.Instruction) *Instance {
+// creates a bare epaxos instance from the given instructions. Instances
+// at CONSISTENCY_CONSENSUS_LOCAL only involve the replicas in the local
+// datacenter, instances at CONSISTENCY_CONSENSUS involve the replicas
+// in every datacenter, and need a quorum of all of them
+func (m *Manager) makeInstance(consistency ConsistencyLevel, instructions ...store.Instruction) *Instance {
 	instance := &Instance{
 		InstanceID:   NewInstanceID(),
 		LeaderID:     m.nodeID,
-		Command:      instruction,
+		Commands:     instructions,
+		Epoch:        m.topology.GetEpoch(),
+		Consistency:  consistency,
 		manager:	  m,
 	}
-	replicas := m.getInstanceReplicas(instance)
+	replicas, err := m.getInstanceReplicas(instance)
+	if err != nil {
+		// the current epoch was just read, so this only happens if it's been
+		// replaced and forgotten since. The instance's phases fail the same way
+		logger.Warning("Unable to get successors for new instance %v: %v", instance.InstanceID, err)
+	}
 	instance.Successors = make([]node.NodeId, len(replicas))
 
 	// add randomly ordered successors
@@ -358,6 +563,78 @@ func (m *Manager) addMissingInstances(instances ...*Instance) error {
 	return nil
 }
 
+// returns the ids of the given instance's dependencies
+// that have never been seen by the local node
+func (m *Manager) getUnknownDependencies(instance *Instance) []InstanceID {
+	unknown := make([]InstanceID, 0)
+	for _, iid := range instance.Dependencies {
+		if m.instances.Get(iid) == nil {
+			unknown = append(unknown, iid)
+		}
+	}
+	return unknown
+}
+
+// sends copies of the given instances to a replica that reported them as
+// unknown in it's reply to a leader message, so it doesn't have to wait
+// for a prepare phase to learn about them
+func (m *Manager) sendMissingInstances(replica node.Node, iids []InstanceID) {
+	start := time.Now()
+	defer m.statsTiming("manager.missing_instance.send.time", start)
+	m.statsInc("manager.missing_instance.send.count", 1)
+
+	msg := &InstanceRequest{Instances: make([]*Instance, 0, len(iids))}
+	for _, iid := range iids {
+		instance := m.instances.Get(iid)
+		if instance == nil {
+			continue
+		}
+		instanceCopy, err := instance.Copy()
+		if err != nil {
+			logger.Warning("Error copying missing instance %v: %v", iid, err)
+			return
+		}
+		msg.Instances = append(msg.Instances, instanceCopy)
+	}
+	if len(msg.Instances) == 0 {
+		return
+	}
+
+	logger.Debug("Sending %v missing instances to node %v", len(msg.Instances), replica.GetId())
+	if _, err := replica.SendMessage(msg); err != nil {
+		m.statsInc("manager.missing_instance.send.error", 1)
+		logger.Warning("Error sending missing instances to node %v: %v", replica.GetId(), err)
+	}
+}
+
+// adds the instances sent with the request that the local node is missing,
+// like the ones a leader sends after they're reported as unknown, and
+// responds with copies of the requested instances it knows about
+func (m *Manager) HandleInstanceRequest(request *InstanceRequest) (*InstanceResponse, error) {
+	m.statsInc("manager.instance_request.received.count", 1)
+	if len(request.Instances) > 0 {
+		m.statsInc("manager.missing_instance.received.count", 1)
+		logger.Debug("Adding %v missing instances sent with instance request", len(request.Instances))
+		if err := m.addMissingInstances(request.Instances...); err != nil {
+			return nil, err
+		}
+	}
+
+	response := &InstanceResponse{Instances: make([]*Instance, 0, len(request.InstanceIDs))}
+	for _, iid := range request.InstanceIDs {
+		instance := m.instances.Get(iid)
+		if instance == nil {
+			continue
+		}
+		instanceCopy, err := instance.Copy()
+		if err != nil {
+			return nil, err
+		}
+		response.Instances = append(response.Instances, instanceCopy)
+	}
+	return response, nil
+}
+
 func (m *Manager) updateInstanceBallotFromResponses(instance *Instance, responses []BallotMessage) error {
 	var maxBallot uint32
 	for _, response := range responses {
@@ -391,7 +668,24 @@ func (m *Manager) ExecutePaxos(instance *Instance) {
 
 	logger.Debug("Preaccept leader phase completed for: %v", instance.InstanceID)
 
-	if acceptRequired {
+	// the leader decides if a transaction is aborted by its watched keys, and the
+	// decision is accepted by a quorum with the instance, so it survives recovery
+	if len(instance.Watches) > 0 && len(instance.Commands) > 0 {
+		if m.watchedKeysChangedBefore(instance) {
+			m.statsInc("manager.client.watch_abort.count", 1)
+			logger.Debug("Watched key changed, aborting %v", instance.InstanceID)
+			instance.setNoop()
+		}
+		acceptRequired = true
+	}
+
+	// replicas of instances spanning multiple replica sets may not know about all
+	// of the instance's dependencies, the accept phase sends them the missing ones
+	spansReplicaSets, err := m.instanceSpansReplicaSets(instance)
+	if err != nil {
+		logger.Info("Error getting replicas for instance: %v", err)
+	}
+	if acceptRequired || spansReplicaSets {
 		logger.Debug("Beginning accept leader phase for: %v", instance.InstanceID)
 		// some of the instance attributes received from the other replicas
 		// were different from what was sent to them. Run the multi-paxos
@@ -414,26 +708,138 @@ func (m *Manager) ExecutePaxos(instance *Instance) {
 	m.executeInstance(instance)
 }
 
-func (m *Manager) ExecuteQuery(instruction store.Instruction) (store.Value, error) {
+// executes the given instruction in an instance at the given consistency level
+func (m *Manager) ExecuteQuery(instruction store.Instruction, consistency ConsistencyLevel) (store.Value, error) {
 
 	if !m.checkLocalKeyEligibility(instruction.Key) {
-		// need to iterate over the possible replicas, allowing for
-		// some to be down
-		panic("Forward to eligible replica not implemented yet")
+		return nil, NewNotReplicatedError("key '%v' isn't replicated locally", instruction.Key)
 	}
 
-	// create epaxos instance, and preaccept locally
-	instance := m.makeInstance(instruction)
-	resultListener := instance.addListener()
+	if err := m.admission.admit(); err != nil {
+		return nil, err
+	}
+	defer m.admission.finish()
 
-	go m.ExecutePaxos(instance)
+	if LEADERLESS_READS && m.store.IsReadOnly(instruction) {
+		return m.executeRead(instruction, consistency)
+	}
+	return m.executeQueryInstance(instruction, consistency)
+}
+
+// executes the given instruction in an instance led by the local node
+func (m *Manager) executeQueryInstance(instruction store.Instruction, consistency ConsistencyLevel) (store.Value, error) {
+	var resultListener InstanceResultChan
+	if QUERY_BATCH_WINDOW > 0 {
+		resultListener = m.batcher.add(instruction, consistency)
+	} else {
+		// create epaxos instance, and preaccept locally
+		instance := m.makeInstance(consistency, instruction)
+		resultListener = instance.addListener()
+
+		go m.ExecutePaxos(instance)
+	}
 
 	var result InstanceResult
 	select {
 	case result = <-resultListener:
-		return result.val, result.err
+		if result.err != nil {
+			return nil, result.err
+		}
+		return result.vals[0], result.errs[0]
 	}
 
 	return nil, nil
 }
 
+// executes the given instructions atomically, in a single instance. The instructions
+// may operate on keys owned by different replica sets, but the local node must
+// replicate all of them, since it only executes the instructions on the keys it
+// replicates, and returns their results. A NotReplicatedError is returned if it
+// doesn't. Returns the result and error of each instruction.
+//
+// If any watched keys are given, and any of their values have changed when the
+// instance is executed, none of the instructions are executed, and a
+// TransactionAbortedError is returned
+func (m *Manager) ExecuteTransaction(instructions []store.Instruction, watches []WatchedKey, consistency ConsistencyLevel) ([]store.Value, []error, error) {
+	if len(instructions) == 0 {
+		return nil, nil, fmt.Errorf("transactions require at least one instruction")
+	}
+
+	for _, instruction := range instructions {
+		if !m.checkLocalKeyEligibility(instruction.Key) {
+			return nil, nil, NewNotReplicatedError("%v on key '%v' can't be executed in a transaction, the key isn't replicated locally", instruction.Cmd, instruction.Key)
+		}
+	}
+
+	instance := m.makeInstance(consistency, instructions...)
+	if len(watches) > 0 {
+		instance.Watches = watches
+		// every replica executing the instance has to reach the same
+		// decision about the watched keys, so they all need to replicate them
+		spansReplicaSets, err := m.instanceSpansReplicaSets(instance)
+		if err != nil {
+			return nil, nil, err
+		}
+		if spansReplicaSets {
+			return nil, nil, fmt.Errorf("watched keys must be replicated by every replica of the transaction's keys")
+		}
+	}
+	if err := m.admission.admit(); err != nil {
+		return nil, nil, err
+	}
+	defer m.admission.finish()
+	resultListener := instance.addListener()
+
+	go m.ExecutePaxos(instance)
+
+	result := <-resultListener
+	if result.err != nil {
+		return nil, nil, result.err
+	} else if result.aborted {
+		return nil, nil, NewTransactionAbortedError("watched key changed, transaction %v aborted", instance.InstanceID)
+	}
+	return result.vals, result.errs, nil
+}
+
+// returns the current timestamp of the given key's value, to be checked
+// against its value when a transaction watching it is executed. The
+// key must be replicated locally
+//
+// the timestamp is read by an instance without instructions, that only
+// interferes with the watched key, so it's read after every instance ordered
+// before it has been executed, not just the ones the local replica has seen
+func (m *Manager) WatchKey(key string, consistency ConsistencyLevel) (WatchedKey, error) {
+	if !m.checkLocalKeyEligibility(key) {
+		return WatchedKey{}, fmt.Errorf("can't watch key '%v', it isn't replicated locally", key)
+	}
+	if err := m.admission.admit(); err != nil {
+		return WatchedKey{}, err
+	}
+	defer m.admission.finish()
+
+	instance := m.makeInstance(consistency)
+	instance.Watches = []WatchedKey{WatchedKey{Key: key}}
+	resultListener := instance.addListener()
+
+	go m.ExecutePaxos(instance)
+
+	result := <-resultListener
+	if result.err != nil {
+		return WatchedKey{}, result.err
+	}
+	return WatchedKey{Key: key, Timestamp: result.watched[0]}, nil
+}
+
+// returns the timestamp of the last write to the given key, including
+// deletes, or a zero time if the key doesn't exist. A replica that's
+// deleted the key reports a newer timestamp than one still holding the
+// deleted value, and a watched key that's written, then deleted, reports
+// the timestamp of the delete, instead of looking unchanged
+func (m *Manager) getKeyWriteTimestamp(key string) time.Time {
+	val, err := m.store.GetRawKey(key)
+	if err != nil || val == nil {
+		return time.Time{}
+	}
+	return val.GetTimestamp()
+}
+
@@ -8,9 +8,9 @@ import (
 	"node"
 )
 
-func makeAcceptCommitTimeout() time.Time {
+func makeAcceptCommitTimeout(consistency ConsistencyLevel) time.Time {
 	waitTime := ACCEPT_COMMIT_TIMEOUT
-	return time.Now().Add(time.Duration(waitTime) * time.Millisecond)
+	return time.Now().Add(getConsistencyTimeout(consistency, waitTime))
 }
 
 // sets the given instance as accepted
@@ -67,42 +67,96 @@ func (m *Manager) sendAccept(instance *Instance, replicas []node.Node) error {
 	defer m.statsTiming("accept.message.send.time", start)
 	m.statsInc("accept.message.send.count", 1)
 
-	// send the message
-	recvChan := make(chan *AcceptResponse, len(replicas))
+	// send the message, responses are nil if the message failed
+	type reply struct {
+		nid node.NodeId
+		response *AcceptResponse
+	}
+	recvChan := make(chan reply, len(replicas))
 	instanceCopy, err := instance.Copy()
 	if err != nil {
 		return err
 	}
-	// TODO: send missing instances
 	msg := &AcceptRequest{Instance: instanceCopy}
+
+	// replicas of one of the instance's keys won't have seen instances
+	// that only operate on the others, so the dependencies are sent along
+	spansReplicaSets, err := m.instanceSpansReplicaSets(instance)
+	if err != nil {
+		return err
+	}
+	if spansReplicaSets {
+		missing, err := m.getDependencyInstances(instance)
+		if err != nil {
+			return err
+		}
+		msg.MissingInstances = missing
+	}
 	sendMsg := func(n node.Node) {
 		if response, err := n.SendMessage(msg); err != nil {
 			logger.Warning("Error receiving AcceptResponse: %v", err)
+			recvChan <- reply{n.GetId(), nil}
 		} else {
 			if accept, ok := response.(*AcceptResponse); ok {
-				recvChan <- accept
+				recvChan <- reply{n.GetId(), accept}
+				if len(accept.UnknownInstances) > 0 {
+					m.sendMissingInstances(n, accept.UnknownInstances)
+				}
 			} else {
 				logger.Warning("Unexpected Accept response type: %T", response)
+				recvChan <- reply{n.GetId(), nil}
 			}
 		}
 	}
-	for _, replica := range replicas {
+
+	quorum, err := m.newInstanceQuorumTracker(instance)
+	if err != nil {
+		return err
+	}
+
+	// thrifty leaders only message the replicas needed for a quorum
+	sendTo, fallback := replicas, []node.Node{}
+	if THRIFTY {
+		sendTo, fallback = quorum.thriftyReplicas(replicas, quorumSize)
+	}
+	for _, replica := range sendTo {
 		go sendMsg(replica)
 	}
+	numPending := len(sendTo)
+
+	var fallbackEvent <-chan time.Time
+	if len(fallback) > 0 {
+		fallbackEvent = getTimeoutEvent(getConsistencyTimeout(instance.Consistency, THRIFTY_FALLBACK_TIMEOUT))
+	}
+	sendFallback := func() {
+		m.statsInc("accept.message.send.fallback", 1)
+		logger.Debug("Accept: sending message to remaining %v replicas for instance %v", len(fallback), instance.InstanceID)
+		for _, replica := range fallback {
+			go sendMsg(replica)
+		}
+		numPending += len(fallback)
+		fallback = []node.Node{}
+		fallbackEvent = nil
+	}
 
 	// receive the replies
-	numReceived := 1 // this node counts as a response
-//	quorumSize := ((len(replicas) + 1) / 2) + 1
-	quorumSize := (len(replicas) / 2) + 1
-	timeoutEvent := getTimeoutEvent(time.Duration(ACCEPT_TIMEOUT) * time.Millisecond)
-	var response *AcceptResponse
+	timeoutEvent := getTimeoutEvent(getConsistencyTimeout(instance.Consistency, ACCEPT_TIMEOUT))
 	responses := make([]*AcceptResponse, 0, len(replicas))
-	for numReceived < quorumSize {
+	for !quorum.satisfied() {
+		if numPending == 0 && len(fallback) > 0 {
+			sendFallback()
+		}
 		select {
-		case response = <-recvChan:
+		case r := <-recvChan:
+			numPending--
+			if r.response == nil {
+				continue
+			}
 			logger.Debug("Accept response received: %v", instance.InstanceID)
-			responses = append(responses, response)
-			numReceived++
+			responses = append(responses, r.response)
+			quorum.add(r.nid)
+		case <-fallbackEvent:
+			sendFallback()
 		case <-timeoutEvent:
 			m.statsInc("accept.message.send.timeout", 1)
 			logger.Info("Accept timeout for instance: %v", instance.InstanceID)
@@ -140,7 +194,10 @@ var managerAcceptPhase = func(m *Manager, instance *Instance) error {
 
 	logger.Debug("Accept phase started")
 
-	replicas := m.getInstanceReplicas(instance)
+	replicas, err := m.getInstanceReplicas(instance)
+	if err != nil {
+		return err
+	}
 
 	if err := m.acceptInstance(instance, true); err != nil {
 		if _, ok := err.(InvalidStatusUpdateError); !ok {
@@ -177,6 +234,8 @@ func (m *Manager) HandleAccept(request *AcceptRequest) (*AcceptResponse, error)
 		m.addMissingInstancesUnsafe(request.MissingInstances...)
 	}
 
+	unknownDeps := m.getUnknownDependencies(request.Instance)
+
 	// should the ballot even matter here? If we're receiving an accept response,
 	// it means that a quorum of preaccept responses were received by a node
 	if instance := m.instances.Get(request.Instance.InstanceID); instance != nil {
@@ -194,7 +253,13 @@ func (m *Manager) HandleAccept(request *AcceptRequest) (*AcceptResponse, error)
 		}
 	}
 
+	reply := &AcceptResponse{Accepted: true, UnknownInstances: unknownDeps}
+	if len(reply.UnknownInstances) > 0 {
+		m.statsInc("accept.message.response.unknown.count", int64(len(reply.UnknownInstances)))
+		logger.Debug("Accept reply for %v includes %v unknown instances", request.Instance.InstanceID, len(reply.UnknownInstances))
+	}
+
 	logger.Debug("Accept message replied for %v, accepted", request.Instance.InstanceID)
-	return &AcceptResponse{Accepted: true}, nil
+	return reply, nil
 }
 
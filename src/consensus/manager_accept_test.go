@@ -95,7 +95,7 @@ func (s *AcceptInstanceTest) TestHigherStatusFailure(c *gocheck.C) {
 // instances references in the manager's containers
 func (s *AcceptInstanceTest) TestRepeatAccept(c *gocheck.C ) {
 	var err error
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	repeat, _ := instance.Copy()
 
 	err = s.manager.acceptInstance(instance, false)
@@ -122,7 +122,7 @@ func (s *AcceptInstanceTest) TestOldNoopAccept(c *gocheck.C) {
 // tests that instance dependencies are marked as acknowledged on commit
 func (s *AcceptInstanceTest) TestReportAcknowledged(c *gocheck.C) {
 	var err error
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	toAcknowledge := NewInstanceID()
 	instance.Dependencies = []InstanceID{toAcknowledge}
 
@@ -159,7 +159,7 @@ func (s *AcceptLeaderTest) TearDownSuite(c *gocheck.C) {
 
 func (s *AcceptLeaderTest) SetUpTest(c *gocheck.C) {
 	s.baseReplicaTest.SetUpTest(c)
-	s.instance = s.manager.makeInstance(getBasicInstruction())
+	s.instance = s.manager.makeInstance(s.consistency, getBasicInstruction())
 	var err error
 
 	err = s.manager.preAcceptInstance(s.instance, false)
@@ -193,6 +193,50 @@ func (s *AcceptLeaderTest) TestSendAcceptSuccess(c *gocheck.C) {
 	}
 }
 
+// tests that thrifty leaders only message the replicas needed
+// for a quorum, and message the rest if they don't respond
+func (s *AcceptLeaderTest) TestThriftySend(c *gocheck.C) {
+	oldThrifty := THRIFTY
+	THRIFTY = true
+	defer func() { THRIFTY = oldThrifty }()
+
+	responseFunc := func(n *mockNode, m message.Message) (message.Message, error) {
+		return &AcceptResponse{
+			Accepted:         true,
+			MaxBallot:        s.instance.MaxBallot,
+		}, nil
+	}
+	failResponse := func(n *mockNode, m message.Message) (message.Message, error) {
+		return nil, fmt.Errorf("nope")
+	}
+	numMessaged := func() int {
+		num := 0
+		for _, replica := range s.replicas {
+			if len(replica.sentMessages) > 0 {
+				num++
+			}
+		}
+		return num
+	}
+
+	for _, replica := range s.replicas {
+		replica.messageHandler = responseFunc
+	}
+	err := s.manager.sendAccept(s.instance, transformMockNodeArray(s.replicas))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(numMessaged(), gocheck.Equals, quorumSize(s.numNodes) - 1)
+
+	// the first replica messaged fails
+	for _, replica := range s.replicas {
+		replica.sentMessages = []message.Message{}
+	}
+	s.replicas[0].messageHandler = failResponse
+	err = s.manager.sendAccept(s.instance, transformMockNodeArray(s.replicas))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(len(s.replicas[0].sentMessages), gocheck.Equals, 1)
+	c.Check(numMessaged() > quorumSize(s.numNodes) - 1, gocheck.Equals, true)
+}
+
 // tests proper error is returned if
 // less than a quorum respond
 func (s *AcceptLeaderTest) TestQuorumFailure(c *gocheck.C) {
@@ -291,7 +335,7 @@ var _ = gocheck.Suite(&AcceptReplicaTest{})
 
 func (s *AcceptReplicaTest) SetUpTest(c *gocheck.C) {
 	s.baseManagerTest.SetUpTest(c)
-	s.instance = s.manager.makeInstance(getBasicInstruction())
+	s.instance = s.manager.makeInstance(s.consistency, getBasicInstruction())
 }
 
 // test that instances are marked as accepted when
@@ -429,5 +473,29 @@ func (s *AcceptReplicaTest) TestMissingInstanceSuccess(c *gocheck.C) {
 
 	c.Check(response.Accepted, gocheck.Equals, true)
 	c.Check(s.manager.instances.ContainsID(missingInstance.InstanceID), gocheck.Equals, true)
+	c.Check(len(response.UnknownInstances), gocheck.Equals, 0)
+}
+
+// tests that dependencies the replica has never seen, and
+// weren't sent with the request, are reported to the leader
+func (s *AcceptReplicaTest) TestUnknownInstances(c *gocheck.C) {
+	var err error
+	err = s.manager.preAcceptInstance(s.instance, false)
+	c.Assert(err, gocheck.IsNil)
+
+	unknownID := NewInstanceID()
+	leaderInstance, _ := s.instance.Copy()
+	leaderInstance.Dependencies = append(leaderInstance.Dependencies, unknownID)
+	leaderInstance.MaxBallot++
+
+	request := &AcceptRequest{
+		Instance: leaderInstance,
+		MissingInstances: []*Instance{},
+	}
+
+	response, err := s.manager.HandleAccept(request)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(response.Accepted, gocheck.Equals, true)
+	c.Check(response.UnknownInstances, gocheck.DeepEquals, []InstanceID{unknownID})
 }
 
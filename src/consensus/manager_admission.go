@@ -0,0 +1,108 @@
+package consensus
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// tracks the number of queries the local node is leading, and delays,
+// or rejects new queries once too many are in flight, so the queries
+// already in progress can complete before new ones are started
+type admissionController struct {
+	manager *Manager
+
+	// the number of queries admitted that haven't finished, accessed atomically
+	inFlight int64
+
+	// the number of queries currently being delayed, accessed atomically
+	waiting int64
+}
+
+func newAdmissionController(manager *Manager) *admissionController {
+	return &admissionController{manager: manager}
+}
+
+func (a *admissionController) numInFlight() int64 {
+	return atomic.LoadInt64(&a.inFlight)
+}
+
+// returns the amount of time a new query should be delayed
+// for, given the number of queries in flight
+func (a *admissionController) getDelay(inFlight int64) time.Duration {
+	if ADMISSION_DELAY_THRESHOLD <= 0 || inFlight < int64(ADMISSION_DELAY_THRESHOLD) {
+		return 0
+	}
+	// queries are delayed proportionally to the number
+	// of queries in flight past the threshold
+	over := inFlight - int64(ADMISSION_DELAY_THRESHOLD) + 1
+	delay := time.Duration(over * int64(ADMISSION_DELAY_STEP)) * time.Millisecond
+	if maxDelay := time.Duration(ADMISSION_MAX_DELAY) * time.Millisecond; delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func (a *admissionController) overRejectThreshold(inFlight int64) bool {
+	return ADMISSION_REJECT_THRESHOLD > 0 && inFlight >= int64(ADMISSION_REJECT_THRESHOLD)
+}
+
+func (a *admissionController) reject(inFlight int64) error {
+	a.manager.statsInc("manager.admission.rejected.count", 1)
+	logger.Info("Rejecting query, %v queries in flight", inFlight)
+	return NewOverloadedError("overloaded, %v queries in flight", inFlight)
+}
+
+// takes an in flight slot for a new query, unless the reject threshold has
+// been reached. The count is checked and incremented atomically, so
+// concurrent queries can't all be admitted past the threshold
+func (a *admissionController) reserve() (int64, bool) {
+	for {
+		inFlight := a.numInFlight()
+		if a.overRejectThreshold(inFlight) {
+			return inFlight, false
+		}
+		if atomic.CompareAndSwapInt64(&a.inFlight, inFlight, inFlight + 1) {
+			a.manager.statsGauge("manager.admission.inflight", inFlight + 1)
+			return inFlight + 1, true
+		}
+	}
+}
+
+// called before a new query is started. Returns an OverloadedError if
+// the reject threshold has been reached, and blocks for a delay
+// proportional to the number of in flight queries past the delay threshold.
+// If the query is admitted, finish must be called once it's completed
+//
+// the query's slot is reserved after its delay, so queries that were
+// delayed are rejected if the threshold was reached while they waited
+func (a *admissionController) admit() error {
+	m := a.manager
+	inFlight := a.numInFlight()
+	m.statsGauge("manager.admission.inflight", inFlight)
+
+	if a.overRejectThreshold(inFlight) {
+		return a.reject(inFlight)
+	}
+
+	if delay := a.getDelay(inFlight); delay > 0 {
+		start := time.Now()
+		m.statsInc("manager.admission.delayed.count", 1)
+		m.statsGauge("manager.admission.waiting", atomic.AddInt64(&a.waiting, 1))
+		logger.Debug("Delaying query %v, %v queries in flight", delay, inFlight)
+
+		time.Sleep(delay)
+
+		m.statsGauge("manager.admission.waiting", atomic.AddInt64(&a.waiting, -1))
+		m.statsTiming("manager.admission.delay.time", start)
+	}
+
+	if inFlight, reserved := a.reserve(); !reserved {
+		return a.reject(inFlight)
+	}
+	return nil
+}
+
+// called when an admitted query has completed, or has failed
+func (a *admissionController) finish() {
+	a.manager.statsGauge("manager.admission.inflight", atomic.AddInt64(&a.inFlight, -1))
+}
@@ -0,0 +1,159 @@
+package consensus
+
+import (
+	"time"
+)
+
+import (
+	"launchpad.net/gocheck"
+)
+
+import (
+	"store"
+)
+
+type AdmissionControlTest struct {
+	baseManagerTest
+	oldDelayThreshold int
+	oldDelayStep uint64
+	oldMaxDelay uint64
+	oldRejectThreshold int
+}
+
+var _ = gocheck.Suite(&AdmissionControlTest{})
+
+func (s *AdmissionControlTest) SetUpTest(c *gocheck.C) {
+	s.baseManagerTest.SetUpTest(c)
+	s.oldDelayThreshold = ADMISSION_DELAY_THRESHOLD
+	s.oldDelayStep = ADMISSION_DELAY_STEP
+	s.oldMaxDelay = ADMISSION_MAX_DELAY
+	s.oldRejectThreshold = ADMISSION_REJECT_THRESHOLD
+}
+
+func (s *AdmissionControlTest) TearDownTest(c *gocheck.C) {
+	ADMISSION_DELAY_THRESHOLD = s.oldDelayThreshold
+	ADMISSION_DELAY_STEP = s.oldDelayStep
+	ADMISSION_MAX_DELAY = s.oldMaxDelay
+	ADMISSION_REJECT_THRESHOLD = s.oldRejectThreshold
+}
+
+// reserves in flight slots for the given number of queries
+func (s *AdmissionControlTest) reserve(c *gocheck.C, num int) {
+	for i := 0; i < num; i++ {
+		_, reserved := s.manager.admission.reserve()
+		c.Assert(reserved, gocheck.Equals, true)
+	}
+}
+
+// tests that queries are counted as in flight
+// until they've completed
+func (s *AdmissionControlTest) TestInFlightTracking(c *gocheck.C) {
+	_, err := s.manager.ExecuteQuery(s.getInstruction(5), s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(s.manager.admission.numInFlight(), gocheck.Equals, int64(0))
+
+	stats := s.manager.stats.(*mockStatter)
+	c.Check(stats.guages["manager.admission.inflight"], gocheck.Equals, int64(0))
+}
+
+// tests that new queries are rejected with an
+// overloaded error past the reject threshold
+func (s *AdmissionControlTest) TestReject(c *gocheck.C) {
+	ADMISSION_REJECT_THRESHOLD = 2
+	s.reserve(c, 2)
+
+	_, err := s.manager.ExecuteQuery(s.getInstruction(5), s.consistency)
+	c.Assert(err, gocheck.FitsTypeOf, OverloadedError{})
+	c.Check(s.manager.instances.Len(), gocheck.Equals, 0)
+
+	_, _, err = s.manager.ExecuteTransaction(
+		[]store.Instruction{s.getInstruction(5)},
+		[]WatchedKey{},
+		s.consistency,
+	)
+	c.Assert(err, gocheck.FitsTypeOf, OverloadedError{})
+
+	stats := s.manager.stats.(*mockStatter)
+	c.Check(stats.counters["manager.admission.rejected.count"], gocheck.Equals, int64(2))
+	c.Check(stats.guages["manager.admission.inflight"], gocheck.Equals, int64(2))
+
+	// queries are accepted again once queries complete
+	s.manager.admission.finish()
+	_, err = s.manager.ExecuteQuery(s.getInstruction(5), s.consistency)
+	c.Assert(err, gocheck.IsNil)
+}
+
+// tests that new queries are delayed proportionally
+// to the number of queries past the delay threshold
+func (s *AdmissionControlTest) TestDelay(c *gocheck.C) {
+	ADMISSION_DELAY_THRESHOLD = 2
+	ADMISSION_DELAY_STEP = uint64(10)
+	ADMISSION_MAX_DELAY = uint64(35)
+
+	admission := s.manager.admission
+	c.Check(admission.getDelay(1), gocheck.Equals, time.Duration(0))
+	c.Check(admission.getDelay(2), gocheck.Equals, 10 * time.Millisecond)
+	c.Check(admission.getDelay(3), gocheck.Equals, 20 * time.Millisecond)
+	c.Check(admission.getDelay(10), gocheck.Equals, 35 * time.Millisecond)
+
+	s.reserve(c, 3)
+	start := time.Now()
+	c.Assert(admission.admit(), gocheck.IsNil)
+	c.Check(time.Now().Sub(start) >= 20 * time.Millisecond, gocheck.Equals, true)
+
+	stats := s.manager.stats.(*mockStatter)
+	c.Check(stats.counters["manager.admission.delayed.count"], gocheck.Equals, int64(1))
+	c.Check(stats.guages["manager.admission.waiting"], gocheck.Equals, int64(0))
+}
+
+// tests that queries aren't delayed or rejected
+// if the thresholds are zero
+func (s *AdmissionControlTest) TestDisabled(c *gocheck.C) {
+	ADMISSION_DELAY_THRESHOLD = 0
+	ADMISSION_REJECT_THRESHOLD = 0
+	s.reserve(c, 100)
+	c.Check(s.manager.admission.getDelay(s.manager.admission.numInFlight()), gocheck.Equals, time.Duration(0))
+	c.Check(s.manager.admission.admit(), gocheck.IsNil)
+}
+
+// tests that delayed queries are rejected if the reject
+// threshold is reached while they're delayed
+func (s *AdmissionControlTest) TestRejectAfterDelay(c *gocheck.C) {
+	ADMISSION_DELAY_THRESHOLD = 1
+	ADMISSION_DELAY_STEP = uint64(50)
+	ADMISSION_MAX_DELAY = uint64(50)
+	ADMISSION_REJECT_THRESHOLD = 3
+
+	admission := s.manager.admission
+	s.reserve(c, 2)
+
+	errChan := make(chan error)
+	go func() { errChan <- admission.admit() }()
+	time.Sleep(10 * time.Millisecond)
+	s.reserve(c, 1)
+
+	err := <-errChan
+	c.Assert(err, gocheck.FitsTypeOf, OverloadedError{})
+	c.Check(admission.numInFlight(), gocheck.Equals, int64(3))
+}
+
+// tests that concurrent queries can't be admitted past the reject threshold
+func (s *AdmissionControlTest) TestConcurrentAdmit(c *gocheck.C) {
+	ADMISSION_REJECT_THRESHOLD = 5
+	admission := s.manager.admission
+
+	errChan := make(chan error)
+	for i := 0; i < 20; i++ {
+		go func() { errChan <- admission.admit() }()
+	}
+	admitted := 0
+	for i := 0; i < 20; i++ {
+		if err := <-errChan; err == nil {
+			admitted++
+		} else {
+			c.Check(err, gocheck.FitsTypeOf, OverloadedError{})
+		}
+	}
+	c.Check(admitted, gocheck.Equals, 5)
+	c.Check(admission.numInFlight(), gocheck.Equals, int64(5))
+}
@@ -0,0 +1,132 @@
+package consensus
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"store"
+)
+
+// a query waiting to be executed as part of a batch
+type batchedQuery struct {
+	instruction store.Instruction
+	listener InstanceResultChan
+}
+
+// queries gathered into a single instance
+type queryBatch struct {
+	key string
+	consistency ConsistencyLevel
+	queries []*batchedQuery
+}
+
+// gathers queries arriving within the batch window, or until the
+// max batch size is reached, and executes them in a single instance,
+// so they share the cost of the preaccept, accept, and commit rounds
+//
+// queries are batched with queries on keys owned by the same replica set.
+// Batching unrelated keys would make each instance interfere with every
+// instance on any of its keys, and need a quorum of every replica set
+type queryBatcher struct {
+	manager *Manager
+	lock sync.Mutex
+
+	// the batches currently gathering queries, by their batch key
+	pending map[string]*queryBatch
+}
+
+func newQueryBatcher(manager *Manager) *queryBatcher {
+	return &queryBatcher{manager: manager, pending: make(map[string]*queryBatch)}
+}
+
+// returns the key of the batches the given instruction can be added to,
+// which identifies the consistency level, and the replica set that owns
+// the instruction's key at that level
+func (b *queryBatcher) batchKey(instruction store.Instruction, consistency ConsistencyLevel) string {
+	m := b.manager
+	instance := &Instance{Commands: []store.Instruction{instruction}, Consistency: consistency}
+	// the current epoch is always known, if it's been replaced since it was
+	// read, the query is batched by itself, and it's instance fails
+	replicas, _ := m.getKeyReplicas(instance, instruction.Key)
+	ids := make([]string, len(replicas))
+	for i, n := range replicas {
+		ids[i] = n.GetId().String()
+	}
+	sort.Strings(ids)
+	return consistency.String() + ":" + strings.Join(ids, ",")
+}
+
+// adds the given instruction to the pending batch for its replica set and
+// consistency level, starting a new one if there isn't one. The query's result
+// is sent to the returned channel once the batch's instance has been executed
+func (b *queryBatcher) add(instruction store.Instruction, consistency ConsistencyLevel) InstanceResultChan {
+	query := &batchedQuery{instruction: instruction, listener: NewInstanceResultChan()}
+	key := b.batchKey(instruction, consistency)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	batch := b.pending[key]
+	if batch == nil {
+		batch = &queryBatch{key: key, consistency: consistency, queries: make([]*batchedQuery, 0, QUERY_BATCH_MAX_SIZE)}
+		b.pending[key] = batch
+		time.AfterFunc(time.Duration(QUERY_BATCH_WINDOW) * time.Millisecond, func() {
+			b.flush(batch)
+		})
+	}
+	batch.queries = append(batch.queries, query)
+	if len(batch.queries) >= QUERY_BATCH_MAX_SIZE {
+		b.flushUnsafe(batch)
+	}
+
+	return query.listener
+}
+
+// executes the given batch, if it hasn't already been
+func (b *queryBatcher) flush(batch *queryBatch) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.flushUnsafe(batch)
+}
+
+func (b *queryBatcher) flushUnsafe(batch *queryBatch) {
+	if b.pending[batch.key] != batch {
+		return
+	}
+	delete(b.pending, batch.key)
+
+	m := b.manager
+	instructions := make([]store.Instruction, len(batch.queries))
+	for i, query := range batch.queries {
+		instructions[i] = query.instruction
+	}
+	instance := m.makeInstance(batch.consistency, instructions...)
+	resultListener := instance.addListener()
+
+	m.statsInc("manager.batch.instance.count", 1)
+	m.statsInc("manager.batch.query.count", int64(len(instructions)))
+	m.statsGauge("manager.batch.size", int64(len(instructions)))
+	logger.Debug("Executing batch of %v queries in %v", len(instructions), instance.InstanceID)
+
+	go m.ExecutePaxos(instance)
+	go batch.distributeResult(resultListener)
+}
+
+// sends each query the result of it's own instruction
+func (batch *queryBatch) distributeResult(resultListener InstanceResultChan) {
+	result := <-resultListener
+	for i, query := range batch.queries {
+		if result.err != nil {
+			query.listener <- InstanceResult{err: result.err}
+		} else {
+			query.listener <- InstanceResult{
+				vals: []store.Value{result.vals[i]},
+				errs: []error{result.errs[i]},
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package consensus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+import (
+	"launchpad.net/gocheck"
+)
+
+import (
+	"store"
+)
+
+type QueryBatcherTest struct {
+	baseManagerTest
+	oldBatchWindow uint64
+	oldBatchMaxSize int
+}
+
+var _ = gocheck.Suite(&QueryBatcherTest{})
+
+func (s *QueryBatcherTest) SetUpTest(c *gocheck.C) {
+	s.baseManagerTest.SetUpTest(c)
+	s.oldBatchWindow = QUERY_BATCH_WINDOW
+	s.oldBatchMaxSize = QUERY_BATCH_MAX_SIZE
+}
+
+func (s *QueryBatcherTest) TearDownTest(c *gocheck.C) {
+	QUERY_BATCH_WINDOW = s.oldBatchWindow
+	QUERY_BATCH_MAX_SIZE = s.oldBatchMaxSize
+}
+
+func (s *QueryBatcherTest) receive(c *gocheck.C, listener InstanceResultChan) InstanceResult {
+	select {
+	case result := <-listener:
+		return result
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for batch result")
+	}
+	panic("unreachable")
+}
+
+// tests that a full batch is executed without
+// waiting for the batch window to end
+func (s *QueryBatcherTest) TestMaxSizeFlush(c *gocheck.C) {
+	QUERY_BATCH_WINDOW = uint64(60000)
+	QUERY_BATCH_MAX_SIZE = 3
+
+	listeners := make([]InstanceResultChan, 3)
+	for i := range listeners {
+		listeners[i] = s.manager.batcher.add(s.getInstruction(i + 1), s.consistency)
+	}
+	c.Check(len(s.manager.batcher.pending), gocheck.Equals, 0)
+
+	// each query gets the result of it's own instruction
+	for i, listener := range listeners {
+		result := s.receive(c, listener)
+		c.Assert(result.err, gocheck.IsNil)
+		c.Assert(len(result.vals), gocheck.Equals, 1)
+		c.Check(result.errs[0], gocheck.IsNil)
+		c.Check(result.vals[0].(*intVal).value, gocheck.Equals, i + 1)
+	}
+	c.Check(s.manager.instances.Len(), gocheck.Equals, 1)
+	c.Check(len(s.manager.store.(*mockStore).instructions), gocheck.Equals, 3)
+
+	stats := s.manager.stats.(*mockStatter)
+	c.Check(stats.counters["manager.batch.instance.count"], gocheck.Equals, int64(1))
+	c.Check(stats.counters["manager.batch.query.count"], gocheck.Equals, int64(3))
+	c.Check(stats.guages["manager.batch.size"], gocheck.Equals, int64(3))
+}
+
+// tests that a partial batch is executed when the batch window ends
+func (s *QueryBatcherTest) TestWindowFlush(c *gocheck.C) {
+	QUERY_BATCH_WINDOW = uint64(10)
+	QUERY_BATCH_MAX_SIZE = 64
+
+	listener1 := s.manager.batcher.add(s.getInstruction(1), s.consistency)
+	listener2 := s.manager.batcher.add(s.getInstruction(2), s.consistency)
+
+	c.Check(s.receive(c, listener1).vals[0].(*intVal).value, gocheck.Equals, 1)
+	c.Check(s.receive(c, listener2).vals[0].(*intVal).value, gocheck.Equals, 2)
+	c.Check(s.manager.instances.Len(), gocheck.Equals, 1)
+
+	// the next query starts a new batch
+	listener3 := s.manager.batcher.add(s.getInstruction(3), s.consistency)
+	c.Check(s.receive(c, listener3).vals[0].(*intVal).value, gocheck.Equals, 3)
+	c.Check(s.manager.instances.Len(), gocheck.Equals, 2)
+}
+
+// tests that an error in one query doesn't affect the rest of it's batch
+func (s *QueryBatcherTest) TestQueryError(c *gocheck.C) {
+	QUERY_BATCH_WINDOW = uint64(60000)
+	QUERY_BATCH_MAX_SIZE = 2
+
+	listener1 := s.manager.batcher.add(store.NewInstruction("set", "a", []string{"x"}, time.Now()), s.consistency)
+	listener2 := s.manager.batcher.add(s.getInstruction(2), s.consistency)
+
+	result1 := s.receive(c, listener1)
+	c.Check(result1.errs[0], gocheck.NotNil)
+	result2 := s.receive(c, listener2)
+	c.Check(result2.errs[0], gocheck.IsNil)
+	c.Check(result2.vals[0].(*intVal).value, gocheck.Equals, 2)
+}
+
+// tests that concurrent queries are batched by ExecuteQuery
+func (s *QueryBatcherTest) TestExecuteQuery(c *gocheck.C) {
+	QUERY_BATCH_WINDOW = uint64(60000)
+	QUERY_BATCH_MAX_SIZE = 4
+
+	vals := make([]store.Value, 4)
+	errs := make([]error, 4)
+	wg := sync.WaitGroup{}
+	wg.Add(len(vals))
+	for i := range vals {
+		go func(i int) {
+			vals[i], errs[i] = s.manager.ExecuteQuery(s.getInstruction(i), s.consistency)
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range vals {
+		c.Check(errs[i], gocheck.IsNil)
+		c.Check(vals[i].(*intVal).value, gocheck.Equals, i)
+	}
+	c.Check(s.manager.instances.Len(), gocheck.Equals, 1)
+}
+
+// tests that queries are only batched with queries
+// on keys owned by the same replica set
+type QueryBatcherReplicaSetTest struct {
+	keys ManagerMultiKeyTest
+	oldBatchWindow uint64
+	oldBatchMaxSize int
+}
+
+var _ = gocheck.Suite(&QueryBatcherReplicaSetTest{})
+
+func (s *QueryBatcherReplicaSetTest) SetUpTest(c *gocheck.C) {
+	s.keys.SetUpTest(c)
+	s.oldBatchWindow = QUERY_BATCH_WINDOW
+	s.oldBatchMaxSize = QUERY_BATCH_MAX_SIZE
+}
+
+func (s *QueryBatcherReplicaSetTest) TearDownTest(c *gocheck.C) {
+	QUERY_BATCH_WINDOW = s.oldBatchWindow
+	QUERY_BATCH_MAX_SIZE = s.oldBatchMaxSize
+}
+
+func (s *QueryBatcherReplicaSetTest) TestBatchKeys(c *gocheck.C) {
+	QUERY_BATCH_WINDOW = uint64(60000)
+	QUERY_BATCH_MAX_SIZE = 64
+	manager := s.keys.manager
+	batcher := manager.batcher
+	localKey := s.keys.localKey
+
+	// find a locally replicated key owned by a different replica set
+	otherKey := ""
+	localBatch := batcher.batchKey(s.keys.instruction(localKey, 1), CONSISTENCY_CONSENSUS_LOCAL)
+	for i:=0; otherKey == ""; i++ {
+		key := fmt.Sprintf("key%v", i)
+		if !manager.checkLocalKeyEligibility(key) {
+			continue
+		}
+		if batcher.batchKey(s.keys.instruction(key, 1), CONSISTENCY_CONSENSUS_LOCAL) != localBatch {
+			otherKey = key
+		}
+	}
+
+	batcher.add(s.keys.instruction(localKey, 1), CONSISTENCY_CONSENSUS_LOCAL)
+	batcher.add(s.keys.instruction(otherKey, 2), CONSISTENCY_CONSENSUS_LOCAL)
+	batcher.add(s.keys.instruction(localKey, 3), CONSISTENCY_CONSENSUS_LOCAL)
+
+	// queries at different consistency levels aren't batched together
+	batcher.add(s.keys.instruction(localKey, 4), CONSISTENCY_CONSENSUS)
+
+	c.Assert(len(batcher.pending), gocheck.Equals, 3)
+	c.Check(len(batcher.pending[localBatch].queries), gocheck.Equals, 2)
+	for key, batch := range batcher.pending {
+		for _, query := range batch.queries {
+			c.Check(batcher.batchKey(query.instruction, batch.consistency), gocheck.Equals, key)
+		}
+	}
+
+	// drop the batches, so they aren't executed when the window ends
+	batcher.pending = make(map[string]*queryBatch)
+}
@@ -81,8 +81,10 @@ func (m *Manager) sendCommit(instance *Instance, replicas []node.Node) error {
 	}
 	msg := &CommitRequest{Instance: instanceCopy}
 	sendCommitMessage := func(n node.Node) {
-		if _, err := n.SendMessage(msg); err != nil {
+		if response, err := n.SendMessage(msg); err != nil {
 			logger.Critical("Error sending commit message: %v", err)
+		} else if commit, ok := response.(*CommitResponse); ok && len(commit.UnknownInstances) > 0 {
+			m.sendMissingInstances(n, commit.UnknownInstances)
 		}
 	}
 	for _, replica := range replicas {
@@ -97,7 +99,10 @@ var managerCommitPhase = func(m *Manager, instance *Instance) error {
 	m.statsInc("commit.phase.count", 1)
 
 	logger.Debug("Commit phase started for %v", instance.InstanceID)
-	replicas := m.getInstanceReplicas(instance)
+	replicas, err := m.getInstanceReplicas(instance)
+	if err != nil {
+		return err
+	}
 
 	if err := m.commitInstance(instance, true); err != nil {
 		if _, ok := err.(InvalidStatusUpdateError); !ok {
@@ -129,6 +134,8 @@ func (m *Manager) HandleCommit(request *CommitRequest) (*CommitResponse, error)
 
 	logger.Debug("Commit message received, ballot: %v", request.Instance.MaxBallot)
 
+	unknownDeps := m.getUnknownDependencies(request.Instance)
+
 	// TODO: check ballot
 //	if instance := s.instances.Get(request.Instance.InstanceID); instance != nil {
 //		if ballot := instance.getBallot(); ballot >= request.Instance.MaxBallot {
@@ -149,7 +156,13 @@ func (m *Manager) HandleCommit(request *CommitRequest) (*CommitResponse, error)
 //		go s.executeInstance(s.instances.Get(request.Instance.InstanceID))
 	}
 
+	reply := &CommitResponse{UnknownInstances: unknownDeps}
+	if len(reply.UnknownInstances) > 0 {
+		m.statsInc("commit.message.response.unknown.count", int64(len(reply.UnknownInstances)))
+		logger.Debug("Commit reply for %v includes %v unknown instances", request.Instance.InstanceID, len(reply.UnknownInstances))
+	}
+
 	logger.Debug("Commit message replied")
-	return &CommitResponse{}, nil
+	return reply, nil
 }
 
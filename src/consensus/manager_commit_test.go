@@ -2,6 +2,7 @@ package consensus
 
 import (
 	"sync"
+	"time"
 )
 
 import (
@@ -105,7 +106,7 @@ func (s *CommitInstanceTest) TestCommitExecutedFailure(c *gocheck.C) {
 // instances references in the manager's containers
 func (s *CommitInstanceTest) TestRepeatCommit(c *gocheck.C ) {
 	var err error
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	repeat, _ := instance.Copy()
 
 	err = s.manager.commitInstance(instance, false)
@@ -130,7 +131,7 @@ func (s *CommitInstanceTest) TestCommitNoop(c *gocheck.C) {
 // tests that instance dependencies are marked as acknowledged on commit
 func (s *CommitInstanceTest) TestReportAcknowledged(c *gocheck.C) {
 	var err error
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	toAcknowledge := NewInstanceID()
 	instance.Dependencies = []InstanceID{toAcknowledge}
 
@@ -159,7 +160,7 @@ var _ = gocheck.Suite(&CommitLeaderTest{})
 
 func (s *CommitLeaderTest) SetUpTest(c *gocheck.C) {
 	s.baseReplicaTest.SetUpTest(c)
-	s.instance = s.manager.makeInstance(getBasicInstruction())
+	s.instance = s.manager.makeInstance(s.consistency, getBasicInstruction())
 	var err error
 
 	err = s.manager.preAcceptInstance(s.instance, false)
@@ -197,6 +198,41 @@ func (s *CommitLeaderTest) TestSendSuccess(c *gocheck.C) {
 	}
 }
 
+// tests that the leader sends replicas the instances
+// they reported as unknown in their commit replies
+func (s *CommitLeaderTest) TestSendMissingInstances(c *gocheck.C) {
+	dep := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	err := s.manager.commitInstance(dep, false)
+	c.Assert(err, gocheck.IsNil)
+
+	received := make(chan *InstanceRequest, len(s.replicas))
+	responseFunc := func(n *mockNode, m message.Message) (message.Message, error) {
+		switch request := m.(type) {
+		case *InstanceRequest:
+			received <- request
+			return &InstanceResponse{}, nil
+		default:
+			return &CommitResponse{UnknownInstances: []InstanceID{dep.InstanceID, NewInstanceID()}}, nil
+		}
+	}
+
+	replica := s.replicas[0]
+	replica.messageHandler = responseFunc
+
+	err = s.manager.sendCommit(s.instance, []node.Node{replica})
+	c.Assert(err, gocheck.IsNil)
+
+	select {
+	case request := <-received:
+		// instances unknown to the leader are skipped
+		c.Assert(len(request.Instances), gocheck.Equals, 1)
+		c.Check(request.Instances[0].InstanceID, gocheck.Equals, dep.InstanceID)
+		c.Check(request.Instances[0], gocheck.Not(gocheck.Equals), dep)
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for missing instances")
+	}
+}
+
 // tests that the accept messages sent out have the same ballot
 // as the local instance
 func (s *CommitLeaderTest) TestCommitMessageBallotIsUpToDate(c *gocheck.C) {
@@ -241,7 +277,7 @@ var _ = gocheck.Suite(&CommitReplicaTest{})
 // tests that an instance is marked as committed when
 // a commit request is recived
 func (s *CommitReplicaTest) TestHandleSuccess(c *gocheck.C) {
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	err := s.manager.acceptInstance(instance, false)
 	c.Assert(err, gocheck.IsNil)
 
@@ -256,6 +292,19 @@ func (s *CommitReplicaTest) TestHandleSuccess(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(response, gocheck.NotNil)
 	c.Assert(instance.Status, gocheck.Equals, INSTANCE_COMMITTED)
+	c.Check(len(response.UnknownInstances), gocheck.Equals, 0)
+}
+
+// tests that dependencies the replica has never
+// seen are reported to the leader in the reply
+func (s *CommitReplicaTest) TestHandleUnknownInstances(c *gocheck.C) {
+	unknownID := NewInstanceID()
+	instance := makeInstance(node.NewNodeId(), []InstanceID{unknownID})
+	instance.Status = INSTANCE_ACCEPTED
+
+	response, err := s.manager.HandleCommit(&CommitRequest{Instance: instance})
+	c.Assert(err, gocheck.IsNil)
+	c.Check(response.UnknownInstances, gocheck.DeepEquals, []InstanceID{unknownID})
 }
 
 // tests that commits are handled properly if
@@ -296,7 +345,7 @@ func (s *CommitReplicaTest) TestHandleCommitAsyncExecute(c *gocheck.C) {
 		return nil
 	}
 
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	err := s.manager.acceptInstance(instance, false)
 	c.Assert(err, gocheck.IsNil)
 
@@ -201,6 +201,27 @@ func (d *dependencies) GetAndSetDeps(keys []string, instance *Instance) Instance
 	return deps
 }
 
+// returns the dependencies the given instance would have,
+// without adding it to the dependency tree
+func (d *dependencies) GetDeps(keys []string, instance *Instance) InstanceIDSet {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	deps := d.getLocalDeps(instance)
+	if len(keys) == 1 {
+		if subDependencies := d.subDependencies.all(); len(subDependencies) > 0 {
+			for _, subDeps := range subDependencies {
+				deps.Combine(subDeps.getChildDeps(instance))
+			}
+		}
+	} else {
+		nextKeys := keys[1:]
+		deps.Combine(d.subDependencies.get(nextKeys[0]).GetDeps(nextKeys, instance))
+	}
+	deps.Remove(instance.InstanceID)
+	return deps
+}
+
 // when an instance is accepted or committed, it's dependencies can be
 // considered acknowledged by the cluster. The instance itself is not,
 // because we want to make sure that it's a dependency for at least one
@@ -294,52 +315,88 @@ type dependencyManager struct {
 	lock sync.RWMutex
 }
 
+// returns the interfering keys of each of the instance's instructions. Instances
+// with multiple instructions depend on the instances interfering with any of them
+func (dm *dependencyManager) getKeyPaths(instance *Instance) ([][]string, error) {
+	keyPaths := make([][]string, len(instance.Commands))
+	for i, instruction := range instance.Commands {
+		keys := dm.manager.store.InterferingKeys(instruction)
+		if len(keys) < 1 {
+			return nil, fmt.Errorf("at least one interfering key required, none found")
+		}
+		keyPaths[i] = keys
+	}
+	// watched keys interfere with any writes to them
+	for _, watch := range instance.Watches {
+		keyPaths = append(keyPaths, []string{watch.Key})
+	}
+	if len(keyPaths) < 1 {
+		return nil, fmt.Errorf("at least one instruction required, none found")
+	}
+	return keyPaths, nil
+}
+
 func (dm *dependencyManager) GetAndSetDeps(instance *Instance) ([]InstanceID, error) {
-	keys := dm.manager.store.InterferingKeys(instance.Command)
+	keyPaths, err := dm.getKeyPaths(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	deps := NewSizedInstanceIDSet(0)
+	for _, keys := range keyPaths {
+		deps.Combine(dm.deps.get(keys[0]).GetAndSetDeps(keys, instance))
+	}
+	return deps.List(), nil
+}
 
-	if len(keys) < 1 {
-		return nil, fmt.Errorf("at least one interfering key required, none found")
+func (dm *dependencyManager) GetDeps(instance *Instance) ([]InstanceID, error) {
+	keyPaths, err := dm.getKeyPaths(instance)
+	if err != nil {
+		return nil, err
 	}
 
-	deps := dm.deps.get(keys[0])
-	return deps.GetAndSetDeps(keys, instance).List(), nil
+	deps := NewSizedInstanceIDSet(0)
+	for _, keys := range keyPaths {
+		deps.Combine(dm.deps.get(keys[0]).GetDeps(keys, instance))
+	}
+	return deps.List(), nil
 }
 
 // When an instance is committed, it has been acknowledged by a quorum of replicas,
 // and it's dependencies can be removed from the dependency manager
 func (dm *dependencyManager) ReportAcknowledged(instance *Instance) error {
-	keys := dm.manager.store.InterferingKeys(instance.Command)
-
-	if len(keys) < 1 {
-		return fmt.Errorf("at least one interfering key required, none found")
+	keyPaths, err := dm.getKeyPaths(instance)
+	if err != nil {
+		return err
 	}
 
-	deps := dm.deps.get(keys[0])
-	deps.ReportAcknowledged(keys, instance)
+	for _, keys := range keyPaths {
+		dm.deps.get(keys[0]).ReportAcknowledged(keys, instance)
+	}
 	return nil
 }
 
 func (dm *dependencyManager) ReportExecuted(instance *Instance) error {
-	keys := dm.manager.store.InterferingKeys(instance.Command)
-
-	if len(keys) < 1 {
-		return fmt.Errorf("at least one interfering key required, none found")
+	keyPaths, err := dm.getKeyPaths(instance)
+	if err != nil {
+		return err
 	}
 
-	deps := dm.deps.get(keys[0])
-	deps.ReportExecuted(keys, instance)
+	for _, keys := range keyPaths {
+		dm.deps.get(keys[0]).ReportExecuted(keys, instance)
+	}
 	return nil
 }
 
 func (dm *dependencyManager) AddDependency(instance *Instance) error {
-	keys := dm.manager.store.InterferingKeys(instance.Command)
-
-	if len(keys) < 1 {
-		return fmt.Errorf("at least one interfering key required, none found")
+	keyPaths, err := dm.getKeyPaths(instance)
+	if err != nil {
+		return err
 	}
 
-	deps := dm.deps.get(keys[0])
-	deps.AddDependency(keys, instance)
+	for _, keys := range keyPaths {
+		dm.deps.get(keys[0]).AddDependency(keys, instance)
+	}
 	return nil
 }
 
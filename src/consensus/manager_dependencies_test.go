@@ -28,7 +28,7 @@ var _ = gocheck.Suite(&DependencyMapTest{})
 
 // tests that a new dependencies object is created for new root nodes
 func (s *DependencyMapTest) TestNewRootDependencyMap(c *gocheck.C) {
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 
 	c.Assert(s.manager.depsMngr.deps.deps["a"], gocheck.IsNil)
 
@@ -42,7 +42,7 @@ func (s *DependencyMapTest) TestNewRootDependencyMap(c *gocheck.C) {
 
 // tests that an existing dependencies object is used for a key if it exists
 func (s *DependencyMapTest) TestExistingRootDependencyMap(c *gocheck.C) {
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 
 	depsNode := s.manager.depsMngr.deps.get("a")
 	lastWrite := NewInstanceID()
@@ -66,7 +66,7 @@ func (s *DependencyMapTest) TestSingleKeyIntegration(c *gocheck.C) {
 	expected := NewSizedInstanceIDSet(0)
 
 	// instance 0
-	instance0 := s.manager.makeInstance(s.newInstruction("a"))
+	instance0 := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 	instance0.Dependencies, err = s.manager.depsMngr.GetAndSetDeps(instance0)
 	c.Assert(err, gocheck.IsNil)
 
@@ -75,7 +75,7 @@ func (s *DependencyMapTest) TestSingleKeyIntegration(c *gocheck.C) {
 
 	// instance 1
 	expected.Add(instance0.InstanceID)
-	instance1 := s.manager.makeInstance(s.newInstruction("a"))
+	instance1 := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 	instance1.Dependencies, err = s.manager.depsMngr.GetAndSetDeps(instance1)
 	c.Assert(err, gocheck.IsNil)
 
@@ -89,7 +89,7 @@ func (s *DependencyMapTest) TestSingleKeyIntegration(c *gocheck.C) {
 
 	// instance 2
 	expected.Add(instance1.InstanceID)
-	instance2 := s.manager.makeInstance(s.newInstruction("a"))
+	instance2 := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 	instance2.Dependencies, err = s.manager.depsMngr.GetAndSetDeps(instance2)
 	c.Assert(err, gocheck.IsNil)
 
@@ -103,7 +103,7 @@ func (s *DependencyMapTest) TestSingleKeyIntegration(c *gocheck.C) {
 
 	// instance 3
 	expected.Add(instance2.InstanceID)
-	instance3 := s.manager.makeInstance(s.newInstruction("a"))
+	instance3 := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 	instance3.Dependencies, err = s.manager.depsMngr.GetAndSetDeps(instance3)
 	c.Assert(err, gocheck.IsNil)
 
@@ -113,7 +113,7 @@ func (s *DependencyMapTest) TestSingleKeyIntegration(c *gocheck.C) {
 	// instance 4
 	expected.Add(instance3.InstanceID)
 	expected.Remove(instance0.InstanceID)
-	instance4 := s.manager.makeInstance(s.newInstruction("a"))
+	instance4 := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 	instance4.Dependencies, err = s.manager.depsMngr.GetAndSetDeps(instance4)
 	c.Assert(err, gocheck.IsNil)
 
@@ -121,6 +121,38 @@ func (s *DependencyMapTest) TestSingleKeyIntegration(c *gocheck.C) {
 	c.Check(depsNode.writes.Contains(instance4.InstanceID), gocheck.Equals, true)
 }
 
+// tests that instances with multiple instructions depend on the instances
+// interfering with any of their keys, and are recorded against all of them
+func (s *DependencyMapTest) TestMultiKeyInstance(c *gocheck.C) {
+	var err error
+	instanceA := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
+	instanceA.Dependencies, err = s.manager.depsMngr.GetAndSetDeps(instanceA)
+	c.Assert(err, gocheck.IsNil)
+	instanceB := s.manager.makeInstance(s.consistency, s.newInstruction("b"))
+	instanceB.Dependencies, err = s.manager.depsMngr.GetAndSetDeps(instanceB)
+	c.Assert(err, gocheck.IsNil)
+
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"), s.newInstruction("b"), s.newInstruction("a"))
+	instance.Dependencies, err = s.manager.depsMngr.GetAndSetDeps(instance)
+	c.Assert(err, gocheck.IsNil)
+
+	expected := NewInstanceIDSet([]InstanceID{instanceA.InstanceID, instanceB.InstanceID})
+	c.Check(NewInstanceIDSet(instance.Dependencies), gocheck.DeepEquals, expected)
+	c.Check(s.manager.depsMngr.deps.get("a").writes.Contains(instance.InstanceID), gocheck.Equals, true)
+	c.Check(s.manager.depsMngr.deps.get("b").writes.Contains(instance.InstanceID), gocheck.Equals, true)
+
+	// subsequent instances on either key depend on it
+	next := s.manager.makeInstance(s.consistency, s.newInstruction("b"))
+	next.Dependencies, err = s.manager.depsMngr.GetAndSetDeps(next)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(NewInstanceIDSet(next.Dependencies).Contains(instance.InstanceID), gocheck.Equals, true)
+
+	err = s.manager.depsMngr.ReportExecuted(instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(s.manager.depsMngr.deps.get("a").executed.Contains(instance.InstanceID), gocheck.Equals, true)
+	c.Check(s.manager.depsMngr.deps.get("b").executed.Contains(instance.InstanceID), gocheck.Equals, true)
+}
+
 type DependenciesTest struct {
 	baseDependencyTest
 }
@@ -129,7 +161,7 @@ var _ = gocheck.Suite(&DependenciesTest{})
 
 // tests that a new dependencies object is created for new leaf nodes
 func (s *DependenciesTest) TestNewDependencyMap(c *gocheck.C) {
-	instance := s.manager.makeInstance(s.newInstruction("a:b"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a:b"))
 	keys := []string{"a", "b"}
 	deps := newDependencies()
 
@@ -144,7 +176,7 @@ func (s *DependenciesTest) TestNewDependencyMap(c *gocheck.C) {
 
 // tests that existing dependencies object is used if it exists
 func (s *DependenciesTest) TestExistingDependencyMap(c *gocheck.C) {
-	instance := s.manager.makeInstance(s.newInstruction("a:b"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a:b"))
 	keys := []string{"a", "b"}
 	deps := newDependencies()
 
@@ -162,7 +194,7 @@ func (s *DependenciesTest) TestExistingDependencyMap(c *gocheck.C) {
 
 // tests the last reads array is updated if the instance is a read
 func (s *DependenciesTest) TestLastKeyReadIsUpdated(c *gocheck.C) {
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 	instance.ReadOnly = true
 	keys := []string{"a"}
 	deps := newDependencies()
@@ -179,7 +211,7 @@ func (s *DependenciesTest) TestLastKeyReadIsUpdated(c *gocheck.C) {
 
 // tests that an instance cannot gain a dependency on itself
 func (s *DependenciesTest) TestNoSelfDependence(c *gocheck.C) {
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 	keys := []string{"a"}
 	deps := newDependencies()
 
@@ -193,7 +225,7 @@ func (s *DependenciesTest) TestNoSelfDependence(c *gocheck.C) {
 
 // tests the last write is updated if the instance is a write
 func (s *DependenciesTest) TestLastKeyWriteIsUpdated(c *gocheck.C) {
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 	keys := []string{"a"}
 	deps := newDependencies()
 
@@ -211,7 +243,7 @@ func (s *DependenciesTest) TestLocalReadDeps(c *gocheck.C) {
 	depsNode := newDependencies()
 	depsNode.reads.Add(NewInstanceID(), NewInstanceID())
 
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 	instance.ReadOnly = true
 
 	deps := depsNode.getLocalDeps(instance)
@@ -227,7 +259,7 @@ func (s *DependenciesTest) TestLocalWriteDeps(c *gocheck.C) {
 	expected := depsNode.reads.Copy()
 	expected.Combine(depsNode.writes)
 
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 
 	actual := depsNode.getLocalDeps(instance)
 
@@ -238,7 +270,7 @@ func (s *DependenciesTest) TestReportAcknowledged(c *gocheck.C) {
 	depsNode := newDependencies()
 	depsNode.reads.Add(NewInstanceID(), NewInstanceID())
 
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 	dependency := NewInstanceID()
 	instance.Dependencies = []InstanceID{dependency}
 
@@ -252,7 +284,7 @@ func (s *DependenciesTest) TestReportExecution(c *gocheck.C) {
 	depsNode := newDependencies()
 	depsNode.reads.Add(NewInstanceID(), NewInstanceID())
 
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 
 	c.Assert(depsNode.executed.Contains(instance.InstanceID), gocheck.Equals, false)
 	depsNode.ReportExecuted([]string{"a"}, instance)
@@ -271,7 +303,7 @@ func (s *DependenciesTest) TestRemovalOfExAckedWrites(c *gocheck.C) {
 	depsNode.executed.Add(executed, exAcked)
 	depsNode.acknowledged.Add(acknowledged, exAcked)
 
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 
 	deps := depsNode.GetAndSetDeps([]string{"a"}, instance)
 
@@ -305,7 +337,7 @@ func (s *DependenciesTest) TestRemovalOfExAckedReads(c *gocheck.C) {
 	depsNode.executed.Add(executed, exAcked)
 	depsNode.acknowledged.Add(acknowledged, exAcked)
 
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 
 	deps := depsNode.GetAndSetDeps([]string{"a"}, instance)
 
@@ -331,7 +363,7 @@ func (s *DependenciesTest) TestSelfDependenciesAreNotAcknowledged(c *gocheck.C)
 	depsNode := newDependencies()
 	toAcknowledge := NewInstanceID()
 
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 	instance.Dependencies = []InstanceID{instance.InstanceID, toAcknowledge}
 
 	c.Assert(len(depsNode.acknowledged), gocheck.Equals, 0)
@@ -344,7 +376,7 @@ func (s *DependenciesTest) TestSelfDependenciesAreNotAcknowledged(c *gocheck.C)
 
 func (s *DependenciesTest) TestAddReadDependency(c *gocheck.C) {
 	depsNode := newDependencies()
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 
 	c.Check(depsNode.writes.Contains(instance.InstanceID), gocheck.Equals, false)
 
@@ -355,7 +387,7 @@ func (s *DependenciesTest) TestAddReadDependency(c *gocheck.C) {
 
 func (s *DependenciesTest) TestAddWriteDependency(c *gocheck.C) {
 	depsNode := newDependencies()
-	instance := s.manager.makeInstance(s.newInstruction("a"))
+	instance := s.manager.makeInstance(s.consistency, s.newInstruction("a"))
 	instance.ReadOnly = true
 
 	c.Check(depsNode.reads.Contains(instance.InstanceID), gocheck.Equals, false)
@@ -368,7 +400,7 @@ func (s *DependenciesTest) TestAddWriteDependency(c *gocheck.C) {
 func (s *DependenciesTest) TestIntegration(c *gocheck.C) {
 	// the key "a:b" is the key being used for tests
 	addInstance := func(key string, readOnly bool) InstanceID {
-		instance := s.manager.makeInstance(s.newInstruction(key))
+		instance := s.manager.makeInstance(s.consistency, s.newInstruction(key))
 		instance.ReadOnly = readOnly
 		_, err := s.manager.depsMngr.GetAndSetDeps(instance)
 		c.Assert(err, gocheck.IsNil)
@@ -389,7 +421,7 @@ func (s *DependenciesTest) TestIntegration(c *gocheck.C) {
 	addInstance("a:b1", true)
 
 	// check read deps
-	readInstance := s.manager.makeInstance(s.newInstruction("a:b"))
+	readInstance := s.manager.makeInstance(s.consistency, s.newInstruction("a:b"))
 	readInstance.ReadOnly = true
 	expected := NewInstanceIDSet([]InstanceID{aWrite, abWrite, abcWrite, abcdWrite})
 	deps, err := s.manager.depsMngr.GetAndSetDeps(readInstance)
@@ -398,7 +430,7 @@ func (s *DependenciesTest) TestIntegration(c *gocheck.C) {
 	c.Check(actual, gocheck.DeepEquals, expected)
 
 	// check write deps
-	writeInstance := s.manager.makeInstance(s.newInstruction("a:b"))
+	writeInstance := s.manager.makeInstance(s.consistency, s.newInstruction("a:b"))
 	expected.Add(aRead, abRead, abcRead, abcdRead, readInstance.InstanceID)
 	deps, err = s.manager.depsMngr.GetAndSetDeps(writeInstance)
 	c.Assert(err, gocheck.IsNil)
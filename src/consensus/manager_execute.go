@@ -200,14 +200,93 @@ func (m *Manager) getExecutionOrder(instance *Instance) (exOrder []InstanceID, u
 	return exOrder, uncommittedSet.List(), nil
 }
 
-// executes an instance against the store
-func (m *Manager) applyInstance(instance *Instance) (store.Value, error) {
+// returns true if any of the instance's watched keys
+// have been written, or deleted, since they were watched
+func (m *Manager) watchedKeysChanged(instance *Instance) bool {
+	for _, watch := range instance.Watches {
+		if !m.getKeyWriteTimestamp(watch.Key).Equal(watch.Timestamp) {
+			return true
+		}
+	}
+	return false
+}
+
+// decides if a transaction is aborted by changes to its watched keys. This is
+// decided once, by the leader, before the instance is accepted, and committed
+// with the instance as a noop if it's aborted, so every replica reaches the
+// same decision, regardless of the writes it's seen outside of consensus
+//
+// the dependencies on the watched keys are executed first, so the watched keys
+// reflect every instance ordered before the transaction. Dependencies that can't
+// be executed before the transaction is committed, because they, or instances
+// they depend on, aren't committed yet, or depend on the transaction, are
+// concurrent writes to the watched keys, and abort it
+func (m *Manager) watchedKeysChangedBefore(instance *Instance) bool {
+	watched := make(map[string]bool, len(instance.Watches))
+	for _, watch := range instance.Watches {
+		watched[watch.Key] = true
+	}
+	touchesWatched := func(dep *Instance) bool {
+		for _, key := range m.getInstanceKeys(dep) {
+			if watched[key] {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, iid := range instance.getDependencies() {
+		dep := m.instances.Get(iid)
+		if dep == nil {
+			return true
+		}
+		if !touchesWatched(dep) {
+			continue
+		}
+		switch dep.getStatus() {
+		case INSTANCE_EXECUTED:
+			continue
+		case INSTANCE_COMMITTED:
+		default:
+			return true
+		}
+		exOrder, uncommitted, err := m.getExecutionOrder(dep)
+		if err != nil || len(uncommitted) > 0 {
+			return true
+		}
+		for _, id := range exOrder {
+			if id == instance.InstanceID {
+				return true
+			}
+		}
+		if err := m.executeDependencyChain(exOrder, dep); err != nil {
+			return true
+		}
+	}
+	return m.watchedKeysChanged(instance)
+}
+
+// executes an instance against the store. Instructions on keys the local node
+// doesn't replicate are skipped by it, and executed by the key's replicas, so
+// their values are nil, and their errors are NotReplicatedErrors. The leader of
+// a transaction replicates all of it's keys, so it's client never sees them.
+// An error returned
+// by an instruction doesn't prevent the others from being executed, and the
+// instructions that succeeded aren't rolled back, like redis's EXEC. Every
+// replica executes the same instructions against the same state, so they
+// fail the same way. Instruction errors are reported to the instance's
+// result listeners
+//
+// transactions aborted by their leader are committed as noops, and report
+// their abort to listeners. Instances without instructions read the
+// timestamps of their watched keys, for WatchKey
+func (m *Manager) applyInstance(instance *Instance) ([]store.Value, error) {
 	start := time.Now()
 	defer m.statsTiming("execute.instance.apply.time", start)
 	m.statsInc("execute.instance.apply.count", 1)
 
 	// lock both
-	synchronizedApply := func() (store.Value, error) {
+	synchronizedApply := func() ([]store.Value, error) {
 		instance.lock.Lock()
 		defer instance.lock.Unlock()
 		if status := instance.Status; status == INSTANCE_EXECUTED {
@@ -215,12 +294,31 @@ func (m *Manager) applyInstance(instance *Instance) (store.Value, error) {
 		} else if status != INSTANCE_COMMITTED {
 			return nil, fmt.Errorf("instance not committed")
 		}
-		var val store.Value
-		var err error
-		if !instance.Noop {
-			val, err = m.store.ExecuteInstruction(instance.Command)
-			if err != nil {
-				return nil, err
+		vals := make([]store.Value, len(instance.Commands))
+		errs := make([]error, len(instance.Commands))
+		var watched []time.Time
+		aborted := false
+		if instance.Noop && len(instance.Watches) > 0 {
+			aborted = true
+			vals, errs = nil, nil
+			logger.Debug("Execute: watched key changed, %v aborted", instance.InstanceID)
+		} else if len(instance.Commands) == 0 {
+			watched = make([]time.Time, len(instance.Watches))
+			for i, watch := range instance.Watches {
+				watched[i] = m.getKeyWriteTimestamp(watch.Key)
+			}
+		} else if !instance.Noop {
+			for i, instruction := range instance.Commands {
+				if !m.checkLocalKeyEligibility(instruction.Key) {
+					// the key's replicas execute it
+					errs[i] = NewNotReplicatedError("key '%v' isn't replicated locally", instruction.Key)
+					continue
+				}
+				vals[i], errs[i] = m.store.ExecuteInstruction(instruction)
+				if errs[i] != nil {
+					m.statsInc("execute.instruction.error.count", 1)
+					logger.Info("Execute: error executing instruction %v for %v: %v", instruction, instance.InstanceID, errs[i])
+				}
 			}
 		} else {
 			m.statsInc("execute.instance.noop.count", 1)
@@ -245,19 +343,19 @@ func (m *Manager) applyInstance(instance *Instance) (store.Value, error) {
 
 		// notify listeners of query result
 		for _, listener := range instance.ResultListeners {
-			listener <- InstanceResult{val:val, err:err}
+			listener <- InstanceResult{vals:vals, errs:errs, aborted:aborted, watched:watched}
 		}
 
-		return val, err
+		return vals, nil
 	}
 
-	val, err := synchronizedApply()
+	vals, err := synchronizedApply()
 	if err != nil {
 		return nil, err
 	}
 
 	logger.Debug("Execute: success: %v on %v", instance.InstanceID, m.topology.GetLocalNodeID())
-	return val, nil
+	return vals, nil
 }
 
 // executes the dependencies up to the given instance
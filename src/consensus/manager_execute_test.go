@@ -16,6 +16,7 @@ import (
 
 import (
 	"node"
+	"store"
 )
 
 type baseExecutionTest struct {
@@ -45,7 +46,7 @@ func (s *baseExecutionTest) SetUpTest(c *gocheck.C) {
 	// sets up a new instance, and appends it to the expected order needs
 	// to be called in the same order as the expected dependency ordering
 	addInst := func() *Instance {
-		inst := s.manager.makeInstance(s.getInstruction(lastVal))
+		inst := s.manager.makeInstance(s.consistency, s.getInstruction(lastVal))
 		inst.Successors = []node.NodeId{inst.LeaderID}
 		lastVal++
 		s.manager.preAcceptInstanceUnsafe(inst, false)
@@ -150,7 +151,7 @@ func (s *ExecuteInstanceTest) TestExplicitPrepare(c *gocheck.C) {
 
 	result := <-listener
 	c.Assert(result.err, gocheck.IsNil)
-	c.Assert(result.val, gocheck.NotNil)
+	c.Assert(result.vals[0], gocheck.NotNil)
 	c.Assert(s.preparePhaseCalls, gocheck.Equals, 1)
 
 	c.Check(s.toPrepare.Status, gocheck.Equals, INSTANCE_EXECUTED)
@@ -179,7 +180,7 @@ func (s *ExecuteInstanceTest) TestExplicitPrepareRetry(c *gocheck.C) {
 
 	result := <-listener
 	c.Assert(result.err, gocheck.IsNil)
-	c.Assert(result.val, gocheck.NotNil)
+	c.Assert(result.vals[0], gocheck.NotNil)
 	c.Assert(s.preparePhaseCalls, gocheck.Equals, 2)
 
 	c.Check(s.toPrepare.Status, gocheck.Equals, INSTANCE_EXECUTED)
@@ -210,7 +211,7 @@ func (s *ExecuteInstanceTest) TestExplicitPrepareRetryCondAbort(c *gocheck.C) {
 
 	result := <- listener
 	c.Assert(err, gocheck.IsNil)
-	c.Assert(result.val, gocheck.NotNil)
+	c.Assert(result.vals[0], gocheck.NotNil)
 	c.Assert(result.err, gocheck.IsNil)
 
 	c.Assert(s.preparePhaseCalls, gocheck.Equals, 1)
@@ -276,7 +277,7 @@ func (s *ExecuteDependencyChainTest) TestDependencyOrdering(c *gocheck.C) {
 func (s *ExecuteDependencyChainTest) TestInstanceDependentConnectedDependencyOrdering(c *gocheck.C) {
 	s.commitInstances()
 
-	inst := s.manager.makeInstance(s.getInstruction(6))
+	inst := s.manager.makeInstance(s.consistency, s.getInstruction(6))
 	s.manager.preAcceptInstanceUnsafe(inst, false)
 	inst.commit(nil, false)
 	c.Assert(len(inst.Dependencies), gocheck.Equals, len(s.expectedOrder))
@@ -306,7 +307,7 @@ func (s *ExecuteDependencyChainTest) TestInstanceDependentDependencyOrdering(c *
 	s.manager = setupEmptyManager()
 	instances := make([]*Instance, 50)
 	for i := range instances {
-		instance := s.manager.makeInstance(s.getInstruction(1))
+		instance := s.manager.makeInstance(s.consistency, s.getInstruction(1))
 		s.manager.preAcceptInstance(instance, false)
 		numExpectedDeps := i
 		c.Assert(len(instance.Dependencies), gocheck.Equals, numExpectedDeps, gocheck.Commentf("instance: %v", i))
@@ -361,7 +362,7 @@ func (s *ExecuteDependencyChainTest) TestExternalDependencySuccess(c *gocheck.C)
 
 	result := <-resultListener
 	c.Assert(result.err, gocheck.IsNil)
-	val := result.val
+	val := result.vals[0]
 	c.Assert(val, gocheck.NotNil)
 
 	// check stats
@@ -398,7 +399,7 @@ func (s *ExecuteDependencyChainTest) TestInstanceStrongComponentsAreIncludedInEx
 	instances := make([]*Instance, 0)
 	component := make([]InstanceID, 0)
 	for i:=0; i<3; i++ {
-		instance := s.manager.makeInstance(getBasicInstruction())
+		instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 		err := s.manager.commitInstance(instance, true)
 		c.Assert(err, gocheck.IsNil)
 		if prevInstance == nil {
@@ -429,7 +430,7 @@ func (s *ExecuteDependencyChainTest) TestInstanceStrongComponentsAreIncludedInEx
 func (s *ExecuteDependencyChainTest) TestSingleStrongComponentsAreSkipped(c *gocheck.C) {
 	var err error
 	s.manager = setupEmptyManager()
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	err = s.manager.commitInstance(instance, true)
 	c.Assert(err, gocheck.IsNil)
 
@@ -446,7 +447,7 @@ func (s *ExecuteDependencyChainTest) TestExecutingLongStronglyConnectedComponent
 	var prevInstance *Instance
 	instances := make([]*Instance, 0)
 	for i:=0; i<10; i++ {
-		instance := s.manager.makeInstance(getBasicInstruction())
+		instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 		err := s.manager.commitInstance(instance, true)
 		c.Assert(err, gocheck.IsNil)
 		if prevInstance == nil {
@@ -492,7 +493,7 @@ func (s *ExecuteDependencyChainTest) TestRecordStronglyConnectedComponentsSucces
 	depMap := make(map[InstanceID]*Instance)
 	component := make([]InstanceID, 0)
 	for i:=0; i<10; i++ {
-		instance := s.manager.makeInstance(getBasicInstruction())
+		instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 		err := s.manager.commitInstance(instance, true)
 		c.Assert(err, gocheck.IsNil)
 		if prevInstance == nil {
@@ -528,7 +529,7 @@ func (s *ExecuteDependencyChainTest) TestRecordStronglyConnectedComponentsUncomm
 	depMap := make(map[InstanceID]*Instance)
 	component := make([]InstanceID, 0)
 	for i:=0; i<3; i++ {
-		instance := s.manager.makeInstance(getBasicInstruction())
+		instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 		if i > 0 {
 			err := s.manager.commitInstance(instance, true)
 			c.Assert(err, gocheck.IsNil)
@@ -569,7 +570,7 @@ func (s *ExecuteDependencyChainTest) TestRecordStronglyConnectedComponentsUncomm
 	depMap := make(map[InstanceID]*Instance)
 	component := make([]InstanceID, 0)
 	for i:=0; i<5; i++ {
-		instance := s.manager.makeInstance(getBasicInstruction())
+		instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 		if i > 0 {
 			err := s.manager.commitInstance(instance, true)
 			c.Assert(err, gocheck.IsNil)
@@ -620,8 +621,8 @@ func (s *ExecuteDependencyChainTest) TestRejectedInstanceSkip(c *gocheck.C) {
 
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(result.err, gocheck.IsNil)
-	c.Assert(result.val, gocheck.NotNil)
-	c.Check(result.val.(*intVal).value, gocheck.Equals, 1)
+	c.Assert(result.vals[0], gocheck.NotNil)
+	c.Check(result.vals[0].(*intVal).value, gocheck.Equals, 1)
 
 	// check the number of instructions
 	c.Assert(len(s.manager.store.(*mockStore).instructions), gocheck.Equals, 1)
@@ -644,7 +645,7 @@ func (s *ExecuteDependencyChainTest) TestSkipExecuted(c *gocheck.C) {
 
 	result := <-resultListener
 	c.Assert(result.err, gocheck.IsNil)
-	c.Assert(result.val, gocheck.NotNil)
+	c.Assert(result.vals[0], gocheck.NotNil)
 
 	// the target instance should have been executed
 	c.Check(targetInst.Status, gocheck.Equals, INSTANCE_EXECUTED)
@@ -681,26 +682,56 @@ type ExecuteApplyInstanceTest struct {
 var _ = gocheck.Suite(&ExecuteApplyInstanceTest{})
 
 func (s *ExecuteApplyInstanceTest) TestSuccess(c *gocheck.C) {
-	instance := s.manager.makeInstance(s.getInstruction(5))
+	instance := s.manager.makeInstance(s.consistency, s.getInstruction(5))
 	err := s.manager.commitInstance(instance, false)
 	c.Assert(err, gocheck.IsNil)
-	val, err := s.manager.applyInstance(instance)
+	vals, err := s.manager.applyInstance(instance)
 	c.Assert(err, gocheck.IsNil)
-	c.Assert(val, gocheck.FitsTypeOf, &intVal{})
-	c.Assert(val.(*intVal).value, gocheck.Equals, 5)
+	c.Assert(vals[0], gocheck.FitsTypeOf, &intVal{})
+	c.Assert(vals[0].(*intVal).value, gocheck.Equals, 5)
 	c.Check(instance.Status, gocheck.Equals, INSTANCE_EXECUTED)
 	c.Check(len(s.manager.store.(*mockStore).instructions), gocheck.Equals, 1)
 	c.Check(s.manager.store.(*mockStore).values["a"].value, gocheck.Equals, 5)
 }
 
+// tests that an instruction that fails doesn't prevent the instance's other
+// instructions from being executed, and that they aren't rolled back
+func (s *ExecuteApplyInstanceTest) TestFailedInstruction(c *gocheck.C) {
+	instance := s.manager.makeInstance(
+		s.consistency,
+		store.NewInstruction("set", "a", []string{"5"}, time.Now()),
+		store.NewInstruction("set", "b", []string{"x"}, time.Now()),
+		store.NewInstruction("set", "c", []string{"6"}, time.Now()),
+	)
+	err := s.manager.commitInstance(instance, false)
+	c.Assert(err, gocheck.IsNil)
+
+	resultListener := instance.addListener()
+	vals, err := s.manager.applyInstance(instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(instance.Status, gocheck.Equals, INSTANCE_EXECUTED)
+	c.Check(vals[1], gocheck.IsNil)
+
+	result := <-resultListener
+	c.Check(result.errs[0], gocheck.IsNil)
+	c.Check(result.errs[1], gocheck.NotNil)
+	c.Check(result.errs[2], gocheck.IsNil)
+
+	mStore := s.manager.store.(*mockStore)
+	c.Check(len(mStore.instructions), gocheck.Equals, 2)
+	c.Check(mStore.values["a"].value, gocheck.Equals, 5)
+	c.Check(mStore.values["b"], gocheck.IsNil)
+	c.Check(mStore.values["c"].value, gocheck.Equals, 6)
+}
+
 // tests that noop instances aren't applied to the store
 func (s *ExecuteApplyInstanceTest) TestSkipRejectedInstance(c *gocheck.C) {
-	instance := s.manager.makeInstance(s.getInstruction(5))
+	instance := s.manager.makeInstance(s.consistency, s.getInstruction(5))
 	instance.Status = INSTANCE_COMMITTED
 	instance.Noop = true
-	val, err := s.manager.applyInstance(instance)
+	vals, err := s.manager.applyInstance(instance)
 	c.Assert(err, gocheck.IsNil)
-	c.Assert(val, gocheck.IsNil)
+	c.Assert(vals[0], gocheck.IsNil)
 	c.Check(instance.Noop, gocheck.Equals, true)
 	c.Check(len(s.manager.store.(*mockStore).instructions), gocheck.Equals, 0)
 }
@@ -708,7 +739,7 @@ func (s *ExecuteApplyInstanceTest) TestSkipRejectedInstance(c *gocheck.C) {
 // tests that goroutines listening on an instance are
 // notified of the result when it executes
 func (s *ExecuteApplyInstanceTest) TestResultListenerBroadcast(c *gocheck.C) {
-	instance := s.manager.makeInstance(s.getInstruction(5))
+	instance := s.manager.makeInstance(s.consistency, s.getInstruction(5))
 	instance.Status = INSTANCE_COMMITTED
 
 	var result InstanceResult
@@ -720,19 +751,19 @@ func (s *ExecuteApplyInstanceTest) TestResultListenerBroadcast(c *gocheck.C) {
 		result = <- resultListener
 		wg.Done()
 	}()
-	val, err := s.manager.applyInstance(instance)
+	vals, err := s.manager.applyInstance(instance)
 	wg.Wait()
 
 	c.Assert(err, gocheck.IsNil)
-	c.Assert(val, gocheck.NotNil)
+	c.Assert(vals[0], gocheck.NotNil)
 	c.Assert(result.err, gocheck.IsNil)
-	c.Assert(result.val, gocheck.Equals, val)
+	c.Assert(result.vals[0], gocheck.Equals, vals[0])
 }
 
 // tests that apply instance marks the instance as
 // executed, and moves it into the executed container
 func (s *ExecuteApplyInstanceTest) TestBookKeeping(c *gocheck.C) {
-	instance := s.manager.makeInstance(s.getInstruction(5))
+	instance := s.manager.makeInstance(s.consistency, s.getInstruction(5))
 	iid := instance.InstanceID
 	s.manager.commitInstance(instance, false)
 
@@ -750,7 +781,7 @@ func (s *ExecuteApplyInstanceTest) TestBookKeeping(c *gocheck.C) {
 
 // tests that apply instance fails if the instance is not committed
 func (s *ExecuteApplyInstanceTest) TestUncommittedFailure(c *gocheck.C) {
-	instance := s.manager.makeInstance(s.getInstruction(5))
+	instance := s.manager.makeInstance(s.consistency, s.getInstruction(5))
 	iid := instance.InstanceID
 	s.manager.acceptInstance(instance, false)
 
@@ -765,7 +796,7 @@ func (s *ExecuteApplyInstanceTest) TestUncommittedFailure(c *gocheck.C) {
 // tests that instances are marked as executed
 func (s *ExecuteApplyInstanceTest) TestReportExecuted(c *gocheck.C) {
 	var err error
-	instance := s.manager.makeInstance(s.getInstruction(5))
+	instance := s.manager.makeInstance(s.consistency, s.getInstruction(5))
 
 	// check that this instance hasn't already been somehow acknowledged
 	depsNode := s.manager.depsMngr.deps.get("a")
@@ -779,3 +810,56 @@ func (s *ExecuteApplyInstanceTest) TestReportExecuted(c *gocheck.C) {
 	// check that the dependency manager knows this instance was executed
 	c.Assert(depsNode.executed.Contains(instance.InstanceID), gocheck.Equals, true)
 }
+
+// tests that transactions whose watched keys changed after the leader decided
+// to execute them are executed anyway, since replicas don't re-check watches
+func (s *ExecuteApplyInstanceTest) TestWatchesNotRechecked(c *gocheck.C) {
+	instance := s.manager.makeInstance(s.consistency, s.getInstruction(5))
+	instance.Watches = []WatchedKey{WatchedKey{Key: "a", Timestamp: time.Now()}}
+	err := s.manager.commitInstance(instance, false)
+	c.Assert(err, gocheck.IsNil)
+
+	resultListener := instance.addListener()
+	vals, err := s.manager.applyInstance(instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(vals[0].(*intVal).value, gocheck.Equals, 5)
+	c.Check((<-resultListener).aborted, gocheck.Equals, false)
+}
+
+// tests that transactions aborted by their leader are committed as
+// noops, and report their abort to their listeners
+func (s *ExecuteApplyInstanceTest) TestLeaderAbortedTransaction(c *gocheck.C) {
+	instance := s.manager.makeInstance(s.consistency, s.getInstruction(5))
+	instance.Watches = []WatchedKey{WatchedKey{Key: "a"}}
+	instance.Noop = true
+	err := s.manager.commitInstance(instance, false)
+	c.Assert(err, gocheck.IsNil)
+
+	resultListener := instance.addListener()
+	vals, err := s.manager.applyInstance(instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(vals, gocheck.IsNil)
+	c.Check((<-resultListener).aborted, gocheck.Equals, true)
+	c.Check(len(s.manager.store.(*mockStore).instructions), gocheck.Equals, 0)
+}
+
+// tests that instances without instructions report
+// the timestamps of their watched keys
+func (s *ExecuteApplyInstanceTest) TestWatchRead(c *gocheck.C) {
+	mStore := s.manager.store.(*mockStore)
+	write := s.manager.makeInstance(s.consistency, s.getInstruction(5))
+	c.Assert(s.manager.commitInstance(write, false), gocheck.IsNil)
+	_, err := s.manager.applyInstance(write)
+	c.Assert(err, gocheck.IsNil)
+
+	instance := s.manager.makeInstance(s.consistency)
+	instance.Watches = []WatchedKey{WatchedKey{Key: "a"}}
+	c.Assert(s.manager.commitInstance(instance, false), gocheck.IsNil)
+
+	resultListener := instance.addListener()
+	_, err = s.manager.applyInstance(instance)
+	c.Assert(err, gocheck.IsNil)
+	result := <-resultListener
+	c.Check(result.aborted, gocheck.Equals, false)
+	c.Check(result.watched, gocheck.DeepEquals, []time.Time{mStore.values["a"].time})
+}
@@ -0,0 +1,137 @@
+package consensus
+
+import (
+	"time"
+)
+
+import (
+	"node"
+	"topology"
+)
+
+// returns the nodes that replicate any of the instance's keys in the
+// current topology, but didn't at the instance's epoch. Epochs aren't
+// forgotten while uncommitted instances use them, so if the instance's
+// epoch isn't known, it's committed, and it's sent to all of the current
+// replicas, which ignore it if they already have it
+func (m *Manager) getNewInstanceReplicas(instance *Instance) []topology.Node {
+	oldReplicas := make(map[node.NodeId]bool)
+	oldNodes, err := m.getInstanceNodes(instance)
+	if err != nil {
+		logger.Warning("Handing off %v to all current replicas: %v", instance.InstanceID, err)
+	}
+	for _, n := range oldNodes {
+		oldReplicas[n.GetId()] = true
+	}
+
+	current := &Instance{
+		InstanceID: instance.InstanceID,
+		Commands: instance.Commands,
+		Watches: instance.Watches,
+		Epoch: m.topology.GetEpoch(),
+		Consistency: instance.Consistency,
+	}
+	// the current epoch is always known
+	currentNodes, _ := m.getInstanceNodes(current)
+	newReplicas := make([]topology.Node, 0)
+	for _, n := range currentNodes {
+		if nid := n.GetId(); !oldReplicas[nid] && nid != m.nodeID {
+			newReplicas = append(newReplicas, n)
+		}
+	}
+	return newReplicas
+}
+
+// waits for the given instances to commit, until the drain timeout
+// expires. Returns the number of instances that didn't commit in time
+func (m *Manager) drainInstances(instances []*Instance) int {
+	timeoutEvent := getTimeoutEvent(time.Duration(HANDOFF_DRAIN_TIMEOUT) * time.Millisecond)
+	numUncommitted := 0
+	timedOut := false
+	for _, instance := range instances {
+		if timedOut {
+			if instance.getStatus() < INSTANCE_COMMITTED {
+				numUncommitted++
+			}
+			continue
+		}
+		commitEvent := instance.getCommitEvent().getChan()
+		if instance.getStatus() >= INSTANCE_COMMITTED {
+			continue
+		}
+		select {
+		case <- commitEvent:
+		case <- timeoutEvent:
+			timedOut = true
+			if instance.getStatus() < INSTANCE_COMMITTED {
+				numUncommitted++
+			}
+		}
+	}
+	return numUncommitted
+}
+
+// forgets the topology epochs that no uncommitted instances were created
+// at. Committed instances don't message their replicas again, so only
+// uncommitted instances need the replicas of their epoch
+func (m *Manager) pruneEpochs() {
+	inUse := make(map[uint64]bool)
+	for _, instance := range m.instances.Instances() {
+		if instance.getStatus() < INSTANCE_COMMITTED {
+			inUse[instance.Epoch] = true
+		}
+	}
+	m.topology.PruneEpochs(inUse)
+}
+
+// called by the cluster after the topology has changed. Instances that
+// haven't been executed, and have keys that changed owners since the
+// epoch they were created at are given a chance to commit with their
+// original replicas, then are sent to their new replicas. Uncommitted
+// instances are sent as they are, and continue to use the replicas
+// of their epoch if the new replicas have to run a prepare phase.
+// Epochs no longer used by uncommitted instances are then forgotten
+func (m *Manager) HandleTopologyChange() error {
+	start := time.Now()
+	defer m.statsTiming("manager.handoff.time", start)
+	m.statsInc("manager.handoff.count", 1)
+
+	epoch := m.topology.GetEpoch()
+	logger.Info("Handing off instances for topology epoch %v", epoch)
+
+	// find the instances that have new replicas
+	instances := make([]*Instance, 0)
+	newReplicas := make(map[node.NodeId]topology.Node)
+	transfers := make(map[node.NodeId][]InstanceID)
+	for _, instance := range m.instances.Instances() {
+		if instance.getStatus() >= INSTANCE_EXECUTED {
+			continue
+		}
+		replicas := m.getNewInstanceReplicas(instance)
+		if len(replicas) == 0 {
+			continue
+		}
+		instances = append(instances, instance)
+		for _, n := range replicas {
+			nid := n.GetId()
+			newReplicas[nid] = n
+			transfers[nid] = append(transfers[nid], instance.InstanceID)
+		}
+	}
+	defer m.pruneEpochs()
+	if len(instances) == 0 {
+		return nil
+	}
+	m.statsInc("manager.handoff.instance.count", int64(len(instances)))
+
+	if numUncommitted := m.drainInstances(instances); numUncommitted > 0 {
+		m.statsInc("manager.handoff.drain.timeout.count", int64(numUncommitted))
+		logger.Warning("Handoff: %v instances were not committed before the drain timeout", numUncommitted)
+	}
+
+	for nid, iids := range transfers {
+		logger.Debug("Handoff: sending %v instances to node %v", len(iids), nid)
+		m.sendMissingInstances(newReplicas[nid], iids)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package consensus
+
+import (
+	"launchpad.net/gocheck"
+)
+
+import (
+	"node"
+	"partitioner"
+	"topology"
+)
+
+type HandoffTest struct {
+	baseReplicaTest
+	newNode *mockNode
+	oldDrainTimeout uint64
+}
+
+var _ = gocheck.Suite(&HandoffTest{})
+
+// returns the given token, with the given byte appended
+func extendToken(tk partitioner.Token, b byte) partitioner.Token {
+	extended := make(partitioner.Token, len(tk), len(tk) + 1)
+	copy(extended, tk)
+	return append(extended, b)
+}
+
+// sets up a leader topology where the first 3 nodes replicate the basic
+// instruction's key, and the 4th node doesn't. The 5th node isn't added
+// to the topology, and will take over the 3rd node's ownership of the
+// key when it is added
+func (s *HandoffTest) SetUpTest(c *gocheck.C) {
+	s.baseReplicaTest.SetUpTest(c)
+	s.oldDrainTimeout = HANDOFF_DRAIN_TIMEOUT
+
+	tk := partitioner.NewMD5Partitioner().GetToken(getBasicInstruction().Key)
+	s.nodes[0].token = tk
+	s.nodes[1].token = extendToken(tk, 1)
+	s.nodes[2].token = extendToken(tk, 2)
+	s.nodes[3].token = extendToken(tk, 3)
+	s.nodes[4].token = extendToken(tk, 0)
+	s.newNode = s.nodes[4]
+
+	s.manager.topology = topology.NewTopology(
+		s.leader.id,
+		s.leader.dcID,
+		partitioner.NewMD5Partitioner(),
+		3,
+	)
+	for _, n := range s.nodes[:4] {
+		c.Assert(s.manager.topology.AddNode(n), gocheck.IsNil)
+	}
+}
+
+func (s *HandoffTest) TearDownTest(c *gocheck.C) {
+	HANDOFF_DRAIN_TIMEOUT = s.oldDrainTimeout
+}
+
+func (s *HandoffTest) addNewNode(c *gocheck.C) {
+	c.Assert(s.manager.topology.AddNode(s.newNode), gocheck.IsNil)
+}
+
+func (s *HandoffTest) replicaIDs(replicas []node.Node) map[node.NodeId]bool {
+	ids := make(map[node.NodeId]bool, len(replicas))
+	for _, replica := range replicas {
+		ids[replica.GetId()] = true
+	}
+	return ids
+}
+
+// tests that instances are created with the current topology epoch
+func (s *HandoffTest) TestInstanceEpoch(c *gocheck.C) {
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	c.Check(instance.Epoch, gocheck.Equals, s.manager.topology.GetEpoch())
+
+	s.addNewNode(c)
+	instance = s.manager.makeInstance(s.consistency, getBasicInstruction())
+	c.Check(instance.Epoch, gocheck.Equals, s.manager.topology.GetEpoch())
+}
+
+// tests that instances created before a topology change continue
+// to use the replicas of their epoch, and new instances use the
+// current replicas
+func (s *HandoffTest) TestEpochReplicas(c *gocheck.C) {
+	oldInstance := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	s.addNewNode(c)
+	newInstance := s.manager.makeInstance(s.consistency, getBasicInstruction())
+
+	replicas, err := s.manager.getInstanceReplicas(oldInstance)
+	c.Assert(err, gocheck.IsNil)
+	oldReplicas := s.replicaIDs(replicas)
+	c.Check(len(oldReplicas), gocheck.Equals, 2)
+	c.Check(oldReplicas[s.nodes[1].id], gocheck.Equals, true)
+	c.Check(oldReplicas[s.nodes[2].id], gocheck.Equals, true)
+	c.Check(len(oldInstance.Successors), gocheck.Equals, 2)
+
+	replicas, err = s.manager.getInstanceReplicas(newInstance)
+	c.Assert(err, gocheck.IsNil)
+	newReplicas := s.replicaIDs(replicas)
+	c.Check(len(newReplicas), gocheck.Equals, 2)
+	c.Check(newReplicas[s.newNode.id], gocheck.Equals, true)
+	c.Check(newReplicas[s.nodes[1].id], gocheck.Equals, true)
+
+	// the quorum tracker should also use the epoch's replicas
+	replicaSets, err := s.manager.getInstanceReplicaSets(oldInstance)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(replicaSets), gocheck.Equals, 1)
+	for _, nid := range replicaSets[0] {
+		c.Check(nid, gocheck.Not(gocheck.Equals), s.newNode.id)
+	}
+}
+
+// tests that instances with unknown epochs return an error
+// instead of using the current topology
+func (s *HandoffTest) TestUnknownEpoch(c *gocheck.C) {
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	instance.Epoch = s.manager.topology.GetEpoch() + 10
+	s.addNewNode(c)
+
+	replicas, err := s.manager.getInstanceReplicas(instance)
+	c.Check(replicas, gocheck.IsNil)
+	c.Check(err, gocheck.NotNil)
+	c.Check(s.manager.stats.(*mockStatter).counters["manager.epoch.unknown.count"], gocheck.Equals, int64(1))
+
+	// phases can't be run against the unknown epoch
+	c.Assert(s.manager.preAcceptInstance(instance, false), gocheck.IsNil)
+	c.Check(s.manager.acceptPhase(instance), gocheck.NotNil)
+}
+
+// tests that committed instances whose keys changed owners are
+// sent to the new replicas
+func (s *HandoffTest) TestHandoffCommittedInstance(c *gocheck.C) {
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	c.Assert(s.manager.commitInstance(instance, false), gocheck.IsNil)
+	s.addNewNode(c)
+
+	c.Assert(s.manager.HandleTopologyChange(), gocheck.IsNil)
+
+	c.Assert(len(s.newNode.sentMessages), gocheck.Equals, 1)
+	request, ok := s.newNode.sentMessages[0].(*InstanceRequest)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(len(request.Instances), gocheck.Equals, 1)
+	c.Check(request.Instances[0].InstanceID, gocheck.Equals, instance.InstanceID)
+	c.Check(request.Instances[0].Epoch, gocheck.Equals, instance.Epoch)
+
+	remote := s.newNode.manager.instances.Get(instance.InstanceID)
+	c.Assert(remote, gocheck.NotNil)
+	c.Check(remote.Status, gocheck.Equals, INSTANCE_COMMITTED)
+
+	// the old replicas shouldn't have been messaged
+	for _, n := range s.nodes[1:4] {
+		c.Check(len(n.sentMessages), gocheck.Equals, 0)
+	}
+
+	stats := s.manager.stats.(*mockStatter)
+	c.Check(stats.counters["manager.handoff.instance.count"], gocheck.Equals, int64(1))
+	c.Check(stats.counters["manager.handoff.drain.timeout.count"], gocheck.Equals, int64(0))
+}
+
+// tests that uncommitted instances are sent to the new replicas
+// if they don't commit before the drain timeout
+func (s *HandoffTest) TestHandoffDrainTimeout(c *gocheck.C) {
+	HANDOFF_DRAIN_TIMEOUT = 0
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	c.Assert(s.manager.preAcceptInstance(instance, false), gocheck.IsNil)
+	s.addNewNode(c)
+
+	c.Assert(s.manager.HandleTopologyChange(), gocheck.IsNil)
+
+	c.Assert(len(s.newNode.sentMessages), gocheck.Equals, 1)
+	remote := s.newNode.manager.instances.Get(instance.InstanceID)
+	c.Assert(remote, gocheck.NotNil)
+	c.Check(remote.Status, gocheck.Equals, INSTANCE_PREACCEPTED)
+	c.Check(remote.Epoch, gocheck.Equals, instance.Epoch)
+
+	stats := s.manager.stats.(*mockStatter)
+	c.Check(stats.counters["manager.handoff.drain.timeout.count"], gocheck.Equals, int64(1))
+}
+
+// tests that instances whose replicas haven't changed aren't handed off
+func (s *HandoffTest) TestHandoffUnchangedInstances(c *gocheck.C) {
+	s.addNewNode(c)
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	c.Assert(s.manager.commitInstance(instance, false), gocheck.IsNil)
+
+	executed := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	executed.Epoch = 1
+	executed.Status = INSTANCE_EXECUTED
+	s.manager.instances.Add(executed)
+
+	c.Assert(s.manager.HandleTopologyChange(), gocheck.IsNil)
+
+	for _, n := range s.nodes {
+		c.Check(len(n.sentMessages), gocheck.Equals, 0)
+	}
+	stats := s.manager.stats.(*mockStatter)
+	c.Check(stats.counters["manager.handoff.instance.count"], gocheck.Equals, int64(0))
+}
+
+// tests that epochs are forgotten once no uncommitted instances use them
+func (s *HandoffTest) TestPruneEpochs(c *gocheck.C) {
+	HANDOFF_DRAIN_TIMEOUT = 0
+	uncommitted := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	c.Assert(s.manager.preAcceptInstance(uncommitted, false), gocheck.IsNil)
+	s.addNewNode(c)
+	c.Assert(s.manager.HandleTopologyChange(), gocheck.IsNil)
+
+	// the uncommitted instance's epoch is kept
+	c.Check(s.manager.topology.NumEpochs(), gocheck.Equals, 2)
+	replicas, err := s.manager.getInstanceReplicas(uncommitted)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(len(replicas), gocheck.Equals, 2)
+	c.Check(s.replicaIDs(replicas)[s.newNode.id], gocheck.Equals, false)
+
+	c.Assert(s.manager.commitInstance(uncommitted, false), gocheck.IsNil)
+	c.Assert(s.manager.HandleTopologyChange(), gocheck.IsNil)
+	c.Check(s.manager.topology.NumEpochs(), gocheck.Equals, 1)
+}
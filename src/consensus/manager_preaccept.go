@@ -8,9 +8,9 @@ import (
 	"node"
 )
 
-func makePreAcceptCommitTimeout() time.Time {
+func makePreAcceptCommitTimeout(consistency ConsistencyLevel) time.Time {
 	waitTime := PREACCEPT_COMMIT_TIMEOUT
-	return time.Now().Add(time.Duration(waitTime) * time.Millisecond)
+	return time.Now().Add(getConsistencyTimeout(consistency, waitTime))
 }
 
 func (m *Manager) preAcceptInstanceUnsafe(inst *Instance, incrementBallot bool) error {
@@ -53,18 +53,25 @@ func (m *Manager) preAcceptInstance(inst *Instance, incrementBallot bool) error
 	return nil
 }
 
-// sends pre accept responses to the given replicas, and returns their responses. An error will be returned
-// if there are problems, or a quorum of responses were not received within the timeout
-func (m *Manager) sendPreAccept(instance *Instance, replicas []node.Node) ([]*PreAcceptResponse, error) {
+// sends pre accept responses to the given replicas, and returns their responses, and a bool indicating
+// if a fast path quorum of responses was received. An error will be returned if there are problems, or
+// a quorum of responses were not received within the timeout. Once a slow path quorum has been received,
+// the leader waits up to FAST_PATH_TIMEOUT for a fast path quorum
+func (m *Manager) sendPreAccept(instance *Instance, replicas []node.Node) ([]*PreAcceptResponse, bool, error) {
 	start := time.Now()
 	defer m.statsTiming("preaccept.message.send.time", start)
 	m.statsInc("preaccept.message.send.count", 1)
 
-	recvChan := make(chan *PreAcceptResponse, len(replicas))
+	// responses are nil if the message failed
+	type reply struct {
+		nid node.NodeId
+		response *PreAcceptResponse
+	}
+	recvChan := make(chan reply, len(replicas))
 
 	instanceCopy, err := instance.Copy()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	msg := &PreAcceptRequest{Instance: instanceCopy}
 
@@ -72,38 +79,93 @@ func (m *Manager) sendPreAccept(instance *Instance, replicas []node.Node) ([]*Pr
 		logger.Debug("Preaccept: Sending message to node %v for instance %v", n.GetId(), instance.InstanceID)
 		if response, err := n.SendMessage(msg); err != nil {
 			logger.Warning("Error receiving PreAcceptResponse: %v", err)
+			recvChan <- reply{n.GetId(), nil}
 		} else {
 			if preAccept, ok := response.(*PreAcceptResponse); ok {
 				logger.Debug("Preaccept: response received from node %v for instance %v", n.GetId(), instance.InstanceID)
-				recvChan <- preAccept
+				recvChan <- reply{n.GetId(), preAccept}
+				if len(preAccept.UnknownInstances) > 0 {
+					m.sendMissingInstances(n, preAccept.UnknownInstances)
+				}
 			} else {
 				logger.Warning("Unexpected PreAccept response type: %T", response)
+				recvChan <- reply{n.GetId(), nil}
 			}
 		}
 	}
 
-	for _, replica := range replicas {
+	quorum, err := m.newInstanceQuorumTracker(instance)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// thrifty leaders only message the replicas needed for a fast path quorum
+	sendTo, fallback := replicas, []node.Node{}
+	if THRIFTY {
+		sendTo, fallback = quorum.thriftyReplicas(replicas, fastQuorumSize)
+	}
+	for _, replica := range sendTo {
 		go sendMsg(replica)
 	}
+	numPending := len(sendTo)
+
+	var fallbackEvent <-chan time.Time
+	if len(fallback) > 0 {
+		fallbackEvent = getTimeoutEvent(getConsistencyTimeout(instance.Consistency, THRIFTY_FALLBACK_TIMEOUT))
+	}
+	sendFallback := func() {
+		m.statsInc("preaccept.message.send.fallback", 1)
+		logger.Debug("PreAccept: sending message to remaining %v replicas for instance %v", len(fallback), instance.InstanceID)
+		for _, replica := range fallback {
+			go sendMsg(replica)
+		}
+		numPending += len(fallback)
+		fallback = []node.Node{}
+		fallbackEvent = nil
+	}
 
-	numReceived := 1  // this node counts as a response
-//	quorumSize := ((len(replicas) + 1) / 2) + 1
-	quorumSize := (len(replicas) / 2) + 1
-	timeoutEvent := getTimeoutEvent(time.Duration(PREACCEPT_TIMEOUT) * time.Millisecond)
-	var response *PreAcceptResponse
+	var fastPathEvent <-chan time.Time
+	timeoutEvent := getTimeoutEvent(getConsistencyTimeout(instance.Consistency, PREACCEPT_TIMEOUT))
 	responses := make([]*PreAcceptResponse, 0, len(replicas))
-	for numReceived < quorumSize {
+	receive:
+	for !quorum.fastSatisfied() {
+		if numPending == 0 {
+			if len(fallback) > 0 {
+				sendFallback()
+			} else if quorum.satisfied() {
+				// no more responses are coming
+				break receive
+			}
+		}
 		select {
-		case response = <-recvChan:
+		case r := <-recvChan:
+			numPending--
+			if r.response == nil {
+				continue
+			}
 			logger.Debug("PreAccept response received: %v", instance.InstanceID)
-			responses = append(responses, response)
-			numReceived++
+			responses = append(responses, r.response)
+			quorum.add(r.nid)
+			if fastPathEvent == nil && quorum.satisfied() {
+				fastPathEvent = getTimeoutEvent(getConsistencyTimeout(instance.Consistency, FAST_PATH_TIMEOUT))
+			}
+		case <-fallbackEvent:
+			sendFallback()
+		case <-fastPathEvent:
+			logger.Debug("PreAccept: fast path quorum not received for instance: %v", instance.InstanceID)
+			break receive
 		case <-timeoutEvent:
 			m.statsInc("preaccept.message.send.timeout", 1)
 			logger.Info("PreAccept timeout for instance: %v", instance.InstanceID)
-			return nil, NewTimeoutError("Timeout while awaiting pre accept responses")
+			return nil, false, NewTimeoutError("Timeout while awaiting pre accept responses")
 		}
 	}
+	fastPath := quorum.fastSatisfied()
+	if fastPath {
+		m.statsInc("preaccept.message.send.fast_quorum", 1)
+	} else {
+		m.statsInc("preaccept.message.send.slow_quorum", 1)
+	}
 	logger.Debug("PreAccept response quorum received: %v", instance.InstanceID)
 
 	// check if any of the messages were rejected
@@ -122,10 +184,10 @@ func (m *Manager) sendPreAccept(instance *Instance, replicas []node.Node) ([]*Pr
 			bmResponses[i] = BallotMessage(response)
 		}
 		m.updateInstanceBallotFromResponses(instance, bmResponses)
-		return nil, NewBallotError("Ballot number rejected")
+		return nil, false, NewBallotError("Ballot number rejected")
 	}
 
-	return responses, nil
+	return responses, fastPath, nil
 }
 
 // merges the attributes from the pre accept responses onto the local instance
@@ -150,7 +212,10 @@ func (m *Manager) mergePreAcceptAttributes(instance *Instance, responses []*PreA
 
 // assigned to var for testing
 var managerPreAcceptPhase = func(m *Manager, instance *Instance) (acceptRequired bool, err error) {
-	replicas := m.getInstanceReplicas(instance)
+	replicas, err := m.getInstanceReplicas(instance)
+	if err != nil {
+		return false, err
+	}
 
 	if err := m.preAcceptInstance(instance, true); err != nil {
 		// this may be possible during an explicit prepare
@@ -164,7 +229,7 @@ var managerPreAcceptPhase = func(m *Manager, instance *Instance) (acceptRequired
 	instance = m.instances.Get(instance.InstanceID)
 
 	// send instance pre-accept to replicas
-	paResponses, err := m.sendPreAccept(instance, replicas)
+	paResponses, fastPath, err := m.sendPreAccept(instance, replicas)
 	if err != nil {
 		// quorum failed, a later explicit prepare may
 		// fix it but nothing can be done now
@@ -187,7 +252,10 @@ var managerPreAcceptPhase = func(m *Manager, instance *Instance) (acceptRequired
 		return false, err
 	}
 
-	return m.mergePreAcceptAttributes(instance, paResponses)
+	// the instance can only be committed without an accept phase
+	// if a fast path quorum agreed on it's attributes
+	changes, err := m.mergePreAcceptAttributes(instance, paResponses)
+	return changes || !fastPath, err
 }
 
 // runs the full preaccept phase for the given instance, returning
@@ -215,6 +283,10 @@ func (m *Manager) HandlePreAccept(request *PreAcceptRequest) (*PreAcceptResponse
 
 	extDeps := NewInstanceIDSet(request.Instance.Dependencies)
 
+	// the message instance's deps are replaced with the local
+	// deps when it's preaccepted, so unknown deps are found first
+	unknownDeps := m.getUnknownDependencies(request.Instance)
+
 	if instance := m.instances.Get(request.Instance.InstanceID); instance != nil {
 		if ballot := instance.getBallot(); ballot >= request.Instance.MaxBallot {
 			m.statsInc("preaccept.message.response.rejected", 1)
@@ -253,6 +325,7 @@ func (m *Manager) HandlePreAccept(request *PreAcceptRequest) (*PreAcceptResponse
 	reply := &PreAcceptResponse{
 		Accepted:         true,
 		MissingInstances: make([]*Instance, 0, len(missingDeps)),
+		UnknownInstances: unknownDeps,
 	}
 
 	if instanceCopy, err := instance.Copy(); err != nil {
@@ -279,6 +352,10 @@ func (m *Manager) HandlePreAccept(request *PreAcceptRequest) (*PreAcceptResponse
 	if len(reply.MissingInstances) > 0 {
 		logger.Debug("PreAccept reply for %v includes %v missing instances", request.Instance.InstanceID, len(reply.MissingInstances))
 	}
+	if len(reply.UnknownInstances) > 0 {
+		m.statsInc("preaccept.message.response.unknown.count", int64(len(reply.UnknownInstances)))
+		logger.Debug("PreAccept reply for %v includes %v unknown instances", request.Instance.InstanceID, len(reply.UnknownInstances))
+	}
 	return reply, nil
 }
 
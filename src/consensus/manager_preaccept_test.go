@@ -12,6 +12,7 @@ import (
 import (
 	"message"
 	"node"
+	"store"
 )
 
 type PreAcceptInstanceTest struct {
@@ -21,7 +22,7 @@ type PreAcceptInstanceTest struct {
 var _ = gocheck.Suite(&PreAcceptInstanceTest{})
 
 func (s *PreAcceptInstanceTest) TestSuccessCase(c *gocheck.C) {
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	originalBallot := instance.MaxBallot
 
 	// sanity check
@@ -36,7 +37,7 @@ func (s *PreAcceptInstanceTest) TestSuccessCase(c *gocheck.C) {
 }
 
 func (s *PreAcceptInstanceTest) TestBallotIncrement(c *gocheck.C) {
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	originalBallot := instance.MaxBallot
 
 	err := s.manager.preAcceptInstance(instance, true)
@@ -47,7 +48,7 @@ func (s *PreAcceptInstanceTest) TestBallotIncrement(c *gocheck.C) {
 
 func (s *PreAcceptInstanceTest) TestHigherStatusFailure(c *gocheck.C) {
 	var err error
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	err = s.manager.acceptInstance(instance, false)
 	c.Assert(err, gocheck.IsNil)
 
@@ -69,7 +70,7 @@ func (s *PreAcceptInstanceTest) TestHigherStatusFailure(c *gocheck.C) {
 // instances references in the manager's containers
 func (s *PreAcceptInstanceTest) TestRepeatPreaccept(c *gocheck.C ) {
 	var err error
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	repeat, _ := instance.Copy()
 
 	err = s.manager.preAcceptInstance(instance, false)
@@ -85,7 +86,7 @@ func (s *PreAcceptInstanceTest) TestRepeatPreaccept(c *gocheck.C ) {
 // preaccepting new instances
 func (s *PreAcceptInstanceTest) TestNewNoopPreaccept(c *gocheck.C) {
 	var err error
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	instance.Noop = true
 
 	err = s.manager.preAcceptInstance(instance, false)
@@ -98,7 +99,7 @@ func (s *PreAcceptInstanceTest) TestNewNoopPreaccept(c *gocheck.C) {
 // preaccepting previously seen instances
 func (s *PreAcceptInstanceTest) TestOldNoopPreaccept(c *gocheck.C) {
 	var err error
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	repeat, _ := instance.Copy()
 
 	err = s.manager.preAcceptInstance(instance, false)
@@ -119,7 +120,7 @@ type PreAcceptLeaderTest struct {
 func (s *PreAcceptLeaderTest) SetUpTest(c *gocheck.C) {
 	s.baseReplicaTest.SetUpTest(c)
 
-	s.instance = s.manager.makeInstance(getBasicInstruction())
+	s.instance = s.manager.makeInstance(s.consistency, getBasicInstruction())
 	err := s.manager.preAcceptInstance(s.instance, false)
 	c.Assert(err, gocheck.IsNil)
 }
@@ -143,8 +144,9 @@ func (s *PreAcceptLeaderTest) TestSendSuccessCase(c *gocheck.C) {
 		replica.messageHandler = responseFunc
 	}
 
-	responses, err := s.manager.sendPreAccept(s.instance, transformMockNodeArray(s.replicas))
+	responses, fastPath, err := s.manager.sendPreAccept(s.instance, transformMockNodeArray(s.replicas))
 	c.Assert(err, gocheck.IsNil)
+	c.Check(fastPath, gocheck.Equals, true)
 	c.Log(len(s.replicas))
 	c.Log(len(responses))
 	c.Assert(len(responses) < s.quorumSize() - 1, gocheck.Equals, false)  // less than quorum received
@@ -184,12 +186,84 @@ func (s *PreAcceptLeaderTest) TestSendQuorumFailure(c *gocheck.C) {
 		}
 	}
 
-	responses, err := s.manager.sendPreAccept(s.instance, transformMockNodeArray(s.replicas))
+	responses, _, err := s.manager.sendPreAccept(s.instance, transformMockNodeArray(s.replicas))
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err, gocheck.FitsTypeOf, TimeoutError{})
 	c.Assert(responses, gocheck.IsNil)
 }
 
+func (s *PreAcceptLeaderTest) setThrifty(c *gocheck.C) func() {
+	oldThrifty := THRIFTY
+	THRIFTY = true
+	return func() { THRIFTY = oldThrifty }
+}
+
+func (s *PreAcceptLeaderTest) numMessaged() int {
+	num := 0
+	for _, replica := range s.replicas {
+		if len(replica.sentMessages) > 0 {
+			num++
+		}
+	}
+	return num
+}
+
+// tests that thrifty leaders only message the
+// replicas needed for a fast path quorum
+func (s *PreAcceptLeaderTest) TestThriftySend(c *gocheck.C) {
+	defer s.setThrifty(c)()
+	responseFunc := func(n *mockNode, m message.Message) (message.Message, error) {
+		newInst, _ := s.instance.Copy()
+		return &PreAcceptResponse{
+			Accepted:         true,
+			MaxBallot:        newInst.MaxBallot,
+			Instance:         newInst,
+			MissingInstances: []*Instance{},
+		}, nil
+	}
+	for _, replica := range s.replicas {
+		replica.messageHandler = responseFunc
+	}
+
+	responses, fastPath, err := s.manager.sendPreAccept(s.instance, transformMockNodeArray(s.replicas))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(fastPath, gocheck.Equals, true)
+	c.Check(len(responses), gocheck.Equals, fastQuorumSize(s.numNodes) - 1)
+	c.Check(s.numMessaged(), gocheck.Equals, fastQuorumSize(s.numNodes) - 1)
+}
+
+// tests that thrifty leaders message the rest of
+// the replicas if the first ones fail to respond
+func (s *PreAcceptLeaderTest) TestThriftyFallback(c *gocheck.C) {
+	defer s.setThrifty(c)()
+	responseFunc := func(n *mockNode, m message.Message) (message.Message, error) {
+		newInst, _ := s.instance.Copy()
+		return &PreAcceptResponse{
+			Accepted:         true,
+			MaxBallot:        newInst.MaxBallot,
+			Instance:         newInst,
+			MissingInstances: []*Instance{},
+		}, nil
+	}
+	failResponse := func(n *mockNode, m message.Message) (message.Message, error) {
+		return nil, fmt.Errorf("nope")
+	}
+	// the first replicas are the ones thrifty leaders message first
+	for i, replica := range s.replicas {
+		if i < 1 {
+			replica.messageHandler = failResponse
+		} else {
+			replica.messageHandler = responseFunc
+		}
+	}
+
+	responses, fastPath, err := s.manager.sendPreAccept(s.instance, transformMockNodeArray(s.replicas))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(fastPath, gocheck.Equals, true)
+	c.Check(len(responses), gocheck.Equals, 3)
+	c.Check(s.numMessaged(), gocheck.Equals, len(s.replicas))
+}
+
 // check that a ballot error is returned if the remote instance
 // rejects the message
 func (s *PreAcceptLeaderTest) TestSendBallotFailure(c *gocheck.C) {
@@ -220,7 +294,7 @@ func (s *PreAcceptLeaderTest) TestSendBallotFailure(c *gocheck.C) {
 		}
 	}
 
-	responses, err := s.manager.sendPreAccept(s.instance, transformMockNodeArray(s.replicas))
+	responses, _, err := s.manager.sendPreAccept(s.instance, transformMockNodeArray(s.replicas))
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err, gocheck.FitsTypeOf, BallotError{})
 	c.Assert(responses, gocheck.IsNil)
@@ -356,7 +430,7 @@ func (s *PreAcceptReplicaTest) TestHandleIdenticalAttrs(c *gocheck.C) {
 	instance := &Instance{
 		InstanceID:   NewInstanceID(),
 		LeaderID:     node.NewNodeId(),
-		Command:      instructions,
+		Commands:     []store.Instruction{instructions},
 		Status:       INSTANCE_PREACCEPTED,
 	}
 	instance.Dependencies, _ = s.manager.getInstanceDeps(instance)
@@ -378,6 +452,26 @@ func (s *PreAcceptReplicaTest) TestHandleIdenticalAttrs(c *gocheck.C) {
 	c.Assert(expectedDeps.Equal(actualDeps), gocheck.Equals, true)
 	c.Check(localInstance.DependencyMatch, gocheck.Equals, true)
 	c.Check(len(response.MissingInstances), gocheck.Equals, 0)
+	c.Check(len(response.UnknownInstances), gocheck.Equals, 0)
+}
+
+// tests that dependencies the replica has never
+// seen are reported to the leader in the reply
+func (s *PreAcceptReplicaTest) TestHandleUnknownDeps(c *gocheck.C) {
+	instance := &Instance{
+		InstanceID:   NewInstanceID(),
+		LeaderID:     node.NewNodeId(),
+		Commands:     []store.Instruction{getBasicInstruction()},
+		Status:       INSTANCE_PREACCEPTED,
+	}
+	instance.Dependencies, _ = s.manager.getInstanceDeps(instance)
+	unknownID := NewInstanceID()
+	instance.Dependencies = append(instance.Dependencies, unknownID)
+
+	response, err := s.manager.HandlePreAccept(&PreAcceptRequest{Instance: instance})
+	c.Assert(err, gocheck.IsNil)
+	c.Check(response.Accepted, gocheck.Equals, true)
+	c.Check(response.UnknownInstances, gocheck.DeepEquals, []InstanceID{unknownID})
 }
 
 // tests that the replica updates the sequence and
@@ -404,12 +498,12 @@ func (s *PreAcceptReplicaTest) TestHandleDifferentAttrs(c *gocheck.C) {
 	instance := &Instance{
 		InstanceID:   NewInstanceID(),
 		LeaderID:     node.NewNodeId(),
-		Command:      instruction,
+		Commands:     []store.Instruction{instruction},
 		Dependencies: leaderDeps,
 		Status:       INSTANCE_PREACCEPTED,
 	}
 	c.Assert(instance, gocheck.NotNil)
-	c.Assert(instance.Command, gocheck.NotNil)
+	c.Assert(instance.Commands, gocheck.NotNil)
 	request := &PreAcceptRequest{
 		Instance: instance,
 	}
@@ -448,7 +542,7 @@ func (s *PreAcceptReplicaTest) TestHandleNewAttrs(c *gocheck.C) {
 	instance := &Instance{
 		InstanceID:   NewInstanceID(),
 		LeaderID:     node.NewNodeId(),
-		Command:      getBasicInstruction(),
+		Commands:     []store.Instruction{getBasicInstruction()},
 		Dependencies: []InstanceID{},
 		Status:       INSTANCE_PREACCEPTED,
 	}
@@ -476,7 +570,7 @@ func (s *PreAcceptReplicaTest) TestHandleNewAttrs(c *gocheck.C) {
 
 // tests that new attributes are returned
 func (s *PreAcceptReplicaTest) TestHandleBallotFailure(c *gocheck.C) {
-	instance := s.manager.makeInstance(getBasicInstruction())
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
 	instance.MaxBallot = 5
 	s.manager.preAcceptInstance(instance, false)
 
@@ -496,3 +590,133 @@ func (s *PreAcceptReplicaTest) TestHandleBallotFailure(c *gocheck.C) {
 	c.Check(response.Accepted, gocheck.Equals, false)
 
 }
+
+// tests preaccept quorums on a cluster large enough for
+// the fast path quorum to be larger than the slow path quorum
+type PreAcceptFastPathTest struct {
+	baseReplicaTest
+	instance *Instance
+	oldFastPathTimeout uint64
+}
+
+var _ = gocheck.Suite(&PreAcceptFastPathTest{})
+
+func (s *PreAcceptFastPathTest) SetUpSuite(c *gocheck.C) {
+	s.numNodes = 7
+}
+
+func (s *PreAcceptFastPathTest) SetUpTest(c *gocheck.C) {
+	s.baseReplicaTest.SetUpTest(c)
+	s.instance = s.manager.makeInstance(s.consistency, getBasicInstruction())
+	err := s.manager.preAcceptInstance(s.instance, false)
+	c.Assert(err, gocheck.IsNil)
+
+	s.oldFastPathTimeout = FAST_PATH_TIMEOUT
+	FAST_PATH_TIMEOUT = uint64(10)
+}
+
+func (s *PreAcceptFastPathTest) TearDownTest(c *gocheck.C) {
+	FAST_PATH_TIMEOUT = s.oldFastPathTimeout
+}
+
+// sets up the given number of replicas to agree with the leader,
+// and the rest to respond after the fast path timeout
+func (s *PreAcceptFastPathTest) setupResponses(numResponding int) {
+	responseFunc := func(n *mockNode, m message.Message) (message.Message, error) {
+		request := m.(*PreAcceptRequest)
+		return &PreAcceptResponse{
+			Accepted:         true,
+			MaxBallot:        request.Instance.MaxBallot,
+			Instance:         request.Instance,
+			MissingInstances: []*Instance{},
+		}, nil
+	}
+	hangResponse := func(n *mockNode, m message.Message) (message.Message, error) {
+		time.Sleep(200 * time.Millisecond)
+		return nil, fmt.Errorf("nope")
+	}
+	for i, replica := range s.replicas {
+		if i < numResponding {
+			replica.messageHandler = responseFunc
+		} else {
+			replica.messageHandler = hangResponse
+		}
+	}
+}
+
+func (s *PreAcceptFastPathTest) TestQuorumSizes(c *gocheck.C) {
+	c.Check(quorumSize(s.numNodes), gocheck.Equals, 4)
+	c.Check(fastQuorumSize(s.numNodes), gocheck.Equals, 6)
+}
+
+// tests that a fast path quorum is reported when
+// enough of the replicas respond
+func (s *PreAcceptFastPathTest) TestFastPath(c *gocheck.C) {
+	s.setupResponses(5)
+	responses, fastPath, err := s.manager.sendPreAccept(s.instance, transformMockNodeArray(s.replicas))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(fastPath, gocheck.Equals, true)
+	c.Check(len(responses), gocheck.Equals, 5)
+}
+
+// tests that the leader stops waiting on a fast path quorum
+// once it has a slow path quorum, and the fast path timeout ends
+func (s *PreAcceptFastPathTest) TestSlowPath(c *gocheck.C) {
+	s.setupResponses(3)
+	responses, fastPath, err := s.manager.sendPreAccept(s.instance, transformMockNodeArray(s.replicas))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(fastPath, gocheck.Equals, false)
+	c.Check(len(responses), gocheck.Equals, 3)
+}
+
+// tests that an accept phase is required if a fast path quorum
+// isn't received, even if all the responses agree with the leader
+func (s *PreAcceptFastPathTest) TestSlowPathRequiresAccept(c *gocheck.C) {
+	s.setupResponses(3)
+	acceptRequired, err := managerPreAcceptPhase(s.manager, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(acceptRequired, gocheck.Equals, true)
+
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	s.setupResponses(5)
+	acceptRequired, err = managerPreAcceptPhase(s.manager, instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(acceptRequired, gocheck.Equals, false)
+}
+
+// tests that a leader failing after its instance was preaccepted by
+// F + floor((F + 1) / 2) replicas, the epaxos paper's optimized fast
+// path quorum, can't lose a commit. The prepare phase doesn't run
+// TryPreAccept, so the leader has to run an accept phase, and the
+// prepare phase starts over with the preaccept phase
+func (s *PreAcceptFastPathTest) TestOptimizedQuorumLeaderFailure(c *gocheck.C) {
+	// the leader and 4 replicas
+	s.setupResponses(4)
+
+	// the leader fails, and a replica that didn't see the instance
+	// prepares it with a quorum made of itself, the other replica that
+	// didn't see it, and 2 of the replicas that did
+	recover := func() prepareAction {
+		localInstance, err := s.instance.Copy()
+		c.Assert(err, gocheck.IsNil)
+		localInstance.Status = InstanceStatus(0)
+		responses := []*PrepareResponse{
+			&PrepareResponse{Accepted: true, NodeID: s.replicas[4].id},
+		}
+		for _, replica := range s.replicas[2:4] {
+			instance, err := s.instance.Copy()
+			c.Assert(err, gocheck.IsNil)
+			instance.Status = INSTANCE_PREACCEPTED
+			instance.DependencyMatch = true
+			responses = append(responses, &PrepareResponse{Accepted: true, Instance: instance, NodeID: replica.id})
+		}
+		action, _, err := s.replicaManagers[5].applyPrepareResponses(responses, localInstance)
+		c.Assert(err, gocheck.IsNil)
+		return action
+	}
+
+	acceptRequired, err := managerPreAcceptPhase(s.manager, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(acceptRequired, gocheck.Equals, true)
+	c.Check(recover(), gocheck.Equals, PREPARE_PREACCEPT)
+}
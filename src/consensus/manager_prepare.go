@@ -9,97 +9,201 @@ import (
 	"node"
 )
 
-// analyzes the responses to a prepare request, and returns an instance
-// the prepare phase should use to determine how to proceed
-func (m *Manager) analyzePrepareResponses(responses []*PrepareResponse) (*Instance) {
-	otherInstancesSeen := false
+// the way a prepare phase recovers an instance
+type prepareAction byte
 
-	// find the highest response ballot
-	maxBallot := uint32(0)
-	for _, response := range responses {
-		if response.Instance != nil {
-			otherInstancesSeen = true
-			if ballot := response.Instance.MaxBallot; ballot > maxBallot {
-				maxBallot = ballot
-			}
-		}
-	}
+const (
+	_ = prepareAction(iota)
 
-	if !otherInstancesSeen {
-		return nil
-	}
+	// run the preaccept phase, followed by the
+	// accept phase, if required, and the commit phase
+	PREPARE_PREACCEPT
 
-	// find the highest response status
-	var maxStatus InstanceStatus
-	var instance *Instance
-	for _, response := range responses {
-		if response.Instance == nil {
-			continue
-		}
-		if response.Instance.MaxBallot < maxBallot {
-			continue
-		}
-		if status := response.Instance.Status; status > maxStatus {
-			maxStatus = status
-			instance = response.Instance
-		}
-	}
+	// run the accept phase with the prepare
+	// instance's attributes, then the commit phase
+	PREPARE_ACCEPT
 
-	return instance
+	// commit the prepare instance's attributes
+	PREPARE_COMMIT
+)
+
+func (a prepareAction) String() string {
+	switch a {
+	case PREPARE_PREACCEPT:
+		return "PREPARE_PREACCEPT"
+	case PREPARE_ACCEPT:
+		return "PREPARE_ACCEPT"
+	case PREPARE_COMMIT:
+		return "PREPARE_COMMIT"
+	default:
+		return fmt.Sprintf("Unknown prepareAction: %v", byte(a))
+	}
+	panic("unreachable")
 }
 
-// TODO: not used/done yet, replaces analyzePrepareResponses
-// transforms the local instance from the prepare responses
-func (m *Manager) applyPrepareResponses(responses []*PrepareResponse, localInstance *Instance) (*Instance, error) {
-	var maxBallot uint32
-	var maxStatus InstanceStatus
+// analyzes the responses to a prepare request, and returns the action the prepare phase should
+// take to recover the instance, and the instance whose attributes it should start with. The local
+// instance is analyzed along with the responses, since it's this replica's vote. Ballots are compared
+// by the ballot the attributes were accepted at, since handling the prepare request raised the
+// MaxBallot of every response to the prepare ballot.
+//
+// If any instance is committed or executed, it's attributes are committed. Attributes are only
+// committed without an accept phase if they've been committed before, since a concurrent prepare
+// phase with a different quorum may be accepting other attributes.
+// If any instance is accepted, the attributes accepted at the highest ballot are accepted again.
+// If enough replicas other than the leader preaccepted identical dependencies at the same ballot, with
+// the dependency match flag set, the leader may have committed them on the fast path, and they're
+// accepted. Enough is recoveryMatchSize of every replica set, which every fast path quorum leaves in
+// a prepare quorum. The leader's own vote always matches itself, so it isn't counted. Otherwise, if
+// all of the instances were preaccepted at the same ballot, the leader can't have committed on the
+// fast path, and the dependencies of every instance are merged and accepted.
+// Otherwise, the preaccept phase is run again, as a noop if no other replicas know about the instance
+func (m *Manager) applyPrepareResponses(responses []*PrepareResponse, localInstance *Instance) (prepareAction, *Instance, error) {
+	referenceInstance, err := localInstance.Copy()
+	if err != nil {
+		return 0, nil, err
+	}
 
 	// indicates that nil instances
 	// were encountered in the responses
 	var nilInstances bool
 
-	// indicates that non nil instances
-	// were encountered in the responses
-	var otherInstances bool
-
-	// get info about the response instances
+	// the instances, and the nodes that voted for them
+	instances := make([]*Instance, 0, len(responses) + 1)
+	voters := make([]node.NodeId, 0, len(responses) + 1)
 	for _, response := range responses {
 		if response.Instance != nil {
-			otherInstances = true
-			if ballot := response.Instance.MaxBallot; ballot > maxBallot {
-				maxBallot = ballot
-			}
-			if status := response.Instance.Status; status > maxStatus {
-				maxStatus = status
-			}
+			instances = append(instances, response.Instance)
+			voters = append(voters, response.NodeID)
 		} else {
 			nilInstances = true
 		}
 	}
 
-	referenceInstance, err := localInstance.Copy()
-	if err != nil {
-		return nil, err
+	// if no other instances have been seen...
+	if len(instances) == 0 {
+		if referenceInstance.Status <= INSTANCE_PREACCEPTED {
+			logger.Warning("Instance %v not recognized by other replicas, committing noop", referenceInstance.InstanceID)
+			referenceInstance.Noop = true
+		}
+		return PREPARE_PREACCEPT, referenceInstance, nil
 	}
 
-	// if no other instances have been seen...
-	if !otherInstances {
-		if referenceInstance.Status != INSTANCE_PREACCEPTED {
-			return nil, fmt.Errorf(
-				"Instance %v is unknown by other nodes, but has a status of %v",
-				referenceInstance.InstanceID,
-				referenceInstance.Status,
-			)
+	// the local instance is this replica's vote, if it's voted
+	if referenceInstance.Status >= INSTANCE_PREACCEPTED {
+		instances = append(instances, referenceInstance)
+		voters = append(voters, m.GetLocalID())
+	}
+
+	var maxStatus InstanceStatus
+	for _, instance := range instances {
+		if instance.Status > maxStatus {
+			maxStatus = instance.Status
+		}
+	}
+
+	switch maxStatus {
+	case INSTANCE_COMMITTED, INSTANCE_EXECUTED:
+		for _, instance := range instances {
+			if instance.Status >= INSTANCE_COMMITTED {
+				return PREPARE_COMMIT, instance, nil
+			}
+		}
+
+	case INSTANCE_ACCEPTED:
+		var maxInstance *Instance
+		for _, instance := range instances {
+			if instance.Status != INSTANCE_ACCEPTED {
+				continue
+			}
+			if maxInstance == nil || instance.AcceptedBallot > maxInstance.AcceptedBallot {
+				maxInstance = instance
+			}
+		}
+		return PREPARE_ACCEPT, maxInstance, nil
+
+	case INSTANCE_PREACCEPTED:
+		matchInstance, err := m.getRecoveryMatch(referenceInstance, instances, voters)
+		if err != nil {
+			return PREPARE_PREACCEPT, nil, err
+		}
+		if matchInstance != nil {
+			referenceInstance.Dependencies = matchInstance.Dependencies
+			referenceInstance.Noop = matchInstance.Noop
+			return PREPARE_ACCEPT, referenceInstance, nil
+		}
+		if nilInstances {
+			break
+		}
+
+		ballotsMatch := true
+		for _, instance := range instances {
+			ballotsMatch = ballotsMatch && instance.AcceptedBallot == instances[0].AcceptedBallot
+		}
+		if !ballotsMatch {
+			break
+		}
+
+		deps := NewInstanceIDSet(referenceInstance.Dependencies)
+		for _, instance := range instances {
+			deps.Add(instance.Dependencies...)
+		}
+		referenceInstance.Dependencies = deps.List()
+		return PREPARE_ACCEPT, referenceInstance, nil
+	}
+
+	// the responses don't agree on enough to skip
+	// the preaccept phase, start over with the
+	// highest status instance
+	for _, instance := range instances {
+		if instance.Status == maxStatus {
+			return PREPARE_PREACCEPT, instance, nil
 		}
-		referenceInstance.Noop = true
-		return referenceInstance, nil
 	}
+	return PREPARE_PREACCEPT, referenceInstance, nil
+}
 
-	// TODO: do all of the ballots need to match to advance the preaccept process? Yes, and the prepare messages need to be accepted
-	if maxStatus == INSTANCE_PREACCEPTED && !nilInstances {
+// returns a preaccepted instance whose dependencies were preaccepted, with the
+// dependency match flag set, by recoveryMatchSize replicas of every replica set
+// other than the leader, at the same ballot. Returns nil if there isn't one
+func (m *Manager) getRecoveryMatch(instance *Instance, instances []*Instance, voters []node.NodeId) (*Instance, error) {
+	replicaSets, err := m.getInstanceReplicaSets(instance)
+	if err != nil {
+		return nil, err
+	}
 
-	} else if maxStatus == INSTANCE_PREACCEPTED {
+	// groups of voters that preaccepted identical attributes
+	type matchGroup struct {
+		instance *Instance
+		deps InstanceIDSet
+		quorum *quorumTracker
+	}
+	groups := make([]*matchGroup, 0, len(instances))
 
+	for i, inst := range instances {
+		if inst.Status != INSTANCE_PREACCEPTED || !inst.DependencyMatch || voters[i] == instance.LeaderID {
+			continue
+		}
+		deps := NewInstanceIDSet(inst.Dependencies)
+		var group *matchGroup
+		for _, g := range groups {
+			if g.instance.AcceptedBallot == inst.AcceptedBallot && g.instance.Noop == inst.Noop && g.deps.Equal(deps) {
+				group = g
+				break
+			}
+		}
+		if group == nil {
+			group = &matchGroup{
+				instance: inst,
+				deps: deps,
+				quorum: &quorumTracker{replicaSets: replicaSets, received: make(map[node.NodeId]bool)},
+			}
+			groups = append(groups, group)
+		}
+		group.quorum.add(voters[i])
+		if group.quorum.satisfiedBy(recoveryMatchSize) {
+			return group.instance, nil
+		}
 	}
 	return nil, nil
 }
@@ -109,7 +213,10 @@ var managerSendPrepare = func(m *Manager, instance *Instance) ([]*PrepareRespons
 	defer m.statsTiming("prepare.message.send.time", start)
 	m.statsInc("prepare.message.send.count", 1)
 
-	replicas := m.getInstanceReplicas(instance)
+	replicas, err := m.getInstanceReplicas(instance)
+	if err != nil {
+		return nil, err
+	}
 
 	ballot := instance.incrementBallot()
 	if err := m.Persist(); err != nil {
@@ -117,14 +224,18 @@ var managerSendPrepare = func(m *Manager, instance *Instance) ([]*PrepareRespons
 	}
 	msg := &PrepareRequest{Ballot:ballot, InstanceID:instance.InstanceID}
 
-	recvChan := make(chan *PrepareResponse, len(replicas))
+	type reply struct {
+		nid node.NodeId
+		response *PrepareResponse
+	}
+	recvChan := make(chan reply, len(replicas))
 	sendMsg := func(n node.Node) {
 		logger.Debug("Sending prepare request to node %v with ballot %v", n.GetId(), msg.Ballot)
 		if response, err := n.SendMessage(msg); err != nil {
 			logger.Warning("Error receiving PrepareResponse: %v", err)
 		} else {
 			if preAccept, ok := response.(*PrepareResponse); ok {
-				recvChan <- preAccept
+				recvChan <- reply{n.GetId(), preAccept}
 			} else {
 				logger.Warning("Unexpected Prepare response type: %T", response)
 			}
@@ -136,19 +247,19 @@ var managerSendPrepare = func(m *Manager, instance *Instance) ([]*PrepareRespons
 	}
 
 	// receive responses from at least a quorum of nodes
-//	quorumSize := ((len(replicas) + 1) / 2) + 1
-	quorumSize := (len(replicas) / 2) + 1
-	numReceived := 1  // this node counts as a response
-	timeoutEvent := getTimeoutEvent(time.Duration(PREPARE_TIMEOUT) * time.Millisecond)
-	var response *PrepareResponse
+	quorum, err := m.newInstanceQuorumTracker(instance)
+	if err != nil {
+		return nil, err
+	}
+	timeoutEvent := getTimeoutEvent(getConsistencyTimeout(instance.Consistency, PREPARE_TIMEOUT))
 	responses := make([]*PrepareResponse, 0, len(replicas))
-	for numReceived < quorumSize {
+	for !quorum.satisfied() {
 		select {
-		case response = <-recvChan:
+		case r := <-recvChan:
 			m.statsInc("prepare.message.receive.success.count", 1)
 			logger.Debug("Prepare response received: %v", instance.InstanceID)
-			responses = append(responses, response)
-			numReceived++
+			responses = append(responses, r.response)
+			quorum.add(r.nid)
 		case <-timeoutEvent:
 			m.statsInc("prepare.message.receive.timeout.count", 1)
 			logger.Info("Prepare timeout for instance: %v", instance.InstanceID)
@@ -159,9 +270,8 @@ var managerSendPrepare = func(m *Manager, instance *Instance) ([]*PrepareRespons
 	// finally, receive any additional responses
 	drain: for {
 		select {
-		case response = <-recvChan:
-			responses = append(responses, response)
-			numReceived++
+		case r := <-recvChan:
+			responses = append(responses, r.response)
 		default:
 			break drain
 		}
@@ -235,41 +345,21 @@ var managerPrepareApply = func(m *Manager, instance *Instance, responses []*Prep
 		return err
 	}
 
-	remoteInstance := m.analyzePrepareResponses(responses)
-
-	var status InstanceStatus
-	var prepareInstance *Instance
-	if remoteInstance != nil {
-		if instance.Status > remoteInstance.Status {
-			prepareInstance = instance
-		} else {
-			prepareInstance = remoteInstance
-		}
-		status = prepareInstance.Status
-	} else {
-		if instance.Status <= INSTANCE_PREACCEPTED {
-			logger.Warning("Instance %v not recognized by other replicas, committing noop", instance.InstanceID)
-			instance.setNoop()
-			status = INSTANCE_PREACCEPTED
-			prepareInstance = instance
-		} else {
-			prepareInstance = instance
-			status = INSTANCE_PREACCEPTED
-		}
+	action, prepareInstance, err := m.applyPrepareResponses(responses, instance)
+	if err != nil {
+		return err
+	}
+	if prepareInstance.Noop {
+		instance.setNoop()
 	}
-
 
 	// for the first step that prepare takes (preaccept, accept, commit), it should use
-	// the remote instance, since the remote instance may have newer deps and seq if it's
+	// the prepare instance, since the remote instances may have newer deps if they've
 	// been accepted/committed. For all steps afterwards though, the local instance should
-	// be used, since the remote instance will not be updated after each step
-	// TODO: send back instances when a message/update is rejected, so the calling node can update itself
-
-	// TODO: consider running the full prepare phase only for preaccepted responses, otherwise, just accept/commit locally
+	// be used, since the prepare instance will not be updated after each step
 	acceptRequired := true
-	var err error
-	switch status {
-	case INSTANCE_PREACCEPTED:
+	switch action {
+	case PREPARE_PREACCEPT:
 		// run pre accept phase
 		m.statsInc("prepare.apply.preaccept.count", 1)
 		logger.Debug("Prepare phase starting at PreAccept phase for %v on %v", instance.InstanceID, m.GetLocalID())
@@ -281,12 +371,16 @@ var managerPrepareApply = func(m *Manager, instance *Instance, responses []*Prep
 		}
 		prepareInstance = instance
 		fallthrough
-	case INSTANCE_ACCEPTED:
+	case PREPARE_ACCEPT:
 		// run accept phase
-		if acceptRequired {
+		spansReplicaSets, err := m.instanceSpansReplicaSets(instance)
+		if err != nil {
+			return err
+		}
+		if acceptRequired || spansReplicaSets {
 			m.statsInc("prepare.apply.accept.count", 1)
 			logger.Debug("Prepare phase starting at Accept phase for %v on %v", instance.InstanceID, m.GetLocalID())
-			// use the remote instance to initiate the new accept phase, otherwise
+			// use the prepare instance to initiate the new accept phase, otherwise
 			// the existing (potentially incorrect) attributes will be used for the accept
 			err = m.acceptPhase(prepareInstance)
 			if err != nil {
@@ -297,11 +391,11 @@ var managerPrepareApply = func(m *Manager, instance *Instance, responses []*Prep
 			prepareInstance = instance
 		}
 		fallthrough
-	case INSTANCE_COMMITTED, INSTANCE_EXECUTED:
+	case PREPARE_COMMIT:
 		// commit instance
 		m.statsInc("prepare.apply.commit.count", 1)
 		logger.Debug("Prepare phase starting at Commit phase for %v on %v", instance.InstanceID, m.GetLocalID())
-		// use the remote instance to initiate the new commit phase, otherwise
+		// use the prepare instance to initiate the new commit phase, otherwise
 		// the existing (potentially incorrect) attributes will be committed
 		err = m.commitPhase(prepareInstance)
 		if err != nil {
@@ -311,7 +405,7 @@ var managerPrepareApply = func(m *Manager, instance *Instance, responses []*Prep
 		}
 	default:
 		m.statsInc("prepare.apply.error", 1)
-		return fmt.Errorf("Unknown instance status: %v", remoteInstance.Status)
+		return fmt.Errorf("Unknown prepare action: %v", action)
 	}
 
 	return nil
@@ -355,7 +449,10 @@ var managerDeferToSuccessor = func(m *Manager, instance *Instance) (bool, error)
 	m.statsInc("prepare.successor.count", 1)
 
 	// make a map of replicas
-	replicas := m.getInstanceReplicas(instance)
+	replicas, err := m.getInstanceReplicas(instance)
+	if err != nil {
+		return false, err
+	}
 	replicaMap := make(map[node.NodeId]node.Node, len(replicas))
 	for _, replica := range replicas {
 		replicaMap[replica.GetId()] = replica
@@ -422,7 +519,7 @@ var managerDeferToSuccessor = func(m *Manager, instance *Instance) (bool, error)
 				logger.Debug("Prepare Successor: commit event received for instance %v", instance.InstanceID)
 				return true, nil
 
-			case <- getTimeoutEvent(time.Duration(SUCCESSOR_TIMEOUT) * time.Millisecond):
+			case <- getTimeoutEvent(getConsistencyTimeout(instance.Consistency, SUCCESSOR_TIMEOUT)):
 				// timeout, go to the next successor
 				m.statsInc("prepare.successor.message.receive.timeout.count", 1)
 				logger.Info("Prepare Successor: timeout communicating with %v for instance %v", nid, instance.InstanceID)
@@ -538,7 +635,7 @@ func (m *Manager) HandlePrepare(request *PrepareRequest) (*PrepareResponse, erro
 	logger.Debug("Prepare message received for instance %v, ballot: %v", request.InstanceID, request.Ballot)
 
 	instance := m.getInstance(request.InstanceID)
-	response := &PrepareResponse{}
+	response := &PrepareResponse{NodeID: m.GetLocalID()}
 	var responseBallot uint32
 	if instance == nil {
 		response.Accepted = true
@@ -13,12 +13,15 @@ import (
 
 import (
 	"message"
+	"node"
 )
 
 type basePrepareTest struct {
 	baseManagerTest
 
+	oldManagerPreAcceptPhase func(*Manager, *Instance) (bool, error)
 	oldManagerAcceptPhase func(*Manager, *Instance) error
+	oldManagerCommitPhase func(*Manager, *Instance) error
 	oldManagerPreparePhase func(*Manager, *Instance) error
 	oldManagerPrepareApply func(*Manager, *Instance, []*PrepareResponse) error
 
@@ -31,11 +34,13 @@ type basePrepareTest struct {
 
 func (s *basePrepareTest) SetUpTest(c *gocheck.C) {
 	s.baseManagerTest.SetUpTest(c)
+	s.oldManagerPreAcceptPhase = managerPreAcceptPhase
 	s.oldManagerAcceptPhase = managerAcceptPhase
+	s.oldManagerCommitPhase = managerCommitPhase
 	s.oldManagerPreparePhase = managerPreparePhase
 	s.oldManagerPrepareApply = managerPrepareApply
 
-	s.instance = s.manager.makeInstance(getBasicInstruction())
+	s.instance = s.manager.makeInstance(s.consistency, getBasicInstruction())
 
 	s.preAcceptCalls = 0
 	s.acceptCalls = 0
@@ -65,7 +70,9 @@ func (s *basePrepareTest) patchCommit(e error) {
 
 
 func (s *basePrepareTest) TearDownTest(c *gocheck.C) {
+	managerPreAcceptPhase = s.oldManagerPreAcceptPhase
 	managerAcceptPhase = s.oldManagerAcceptPhase
+	managerCommitPhase = s.oldManagerCommitPhase
 	managerPreparePhase = s.oldManagerPreparePhase
 	managerPrepareApply = s.oldManagerPrepareApply
 }
@@ -98,7 +105,7 @@ func (s *PrepareLeaderTest) TearDownSuite(c *gocheck.C) {
 
 func (s *PrepareLeaderTest) SetUpTest(c *gocheck.C) {
 	s.baseReplicaTest.SetUpTest(c)
-	s.instance = s.manager.makeInstance(getBasicInstruction())
+	s.instance = s.manager.makeInstance(s.consistency, getBasicInstruction())
 }
 
 // tests that the local ballot is incremented before the
@@ -189,44 +196,302 @@ func (s *PrepareLeaderTest) TestBallotUpdateFromResponses(c *gocheck.C) {
 	// TODO: this
 }
 
-// tests the analyzePrepareResponses method
-type PrepareAnalyzeResponsesTest struct {
-	basePrepareTest
+// tests the applyPrepareResponses method. The instance is led by
+// the leader, and recovered by the first replica, with responses
+// from the other replicas
+type PrepareApplyResponsesTest struct {
+	baseReplicaTest
+	instance *Instance
+	recoverer *Manager
+	responses []*PrepareResponse
 }
 
-var _ = gocheck.Suite(&PrepareAnalyzeResponsesTest{})
+var _ = gocheck.Suite(&PrepareApplyResponsesTest{})
 
+func (s *PrepareApplyResponsesTest) SetUpTest(c *gocheck.C) {
+	s.baseReplicaTest.SetUpTest(c)
+	s.instance = s.manager.makeInstance(s.consistency, getBasicInstruction())
+	s.recoverer = s.replicaManagers[0]
+	s.responses = make([]*PrepareResponse, 0)
+}
+
+// the ballot of the prepare request, replicas raise
+// the MaxBallot of their instances to it when they
+// accept the request
+const testPrepareBallot = uint32(10)
+
+// adds a response with attributes accepted at the given ballot,
+// from the next replica that hasn't responded
+func (s *PrepareApplyResponsesTest) addResponse(ballot uint32, status InstanceStatus) *Instance {
+	instance, _ := s.instance.Copy()
+	instance.MaxBallot = testPrepareBallot
+	instance.AcceptedBallot = ballot
+	instance.Status = status
+	response := &PrepareResponse{Accepted: true, Instance: instance, NodeID: s.replicas[len(s.responses) + 1].id}
+	s.responses = append(s.responses, response)
+	return instance
+}
+
+// adds a preaccepted response that agreed with the leader's dependencies
+func (s *PrepareApplyResponsesTest) addMatchResponse(deps []InstanceID) *Instance {
+	instance := s.addResponse(uint32(5), INSTANCE_PREACCEPTED)
+	instance.DependencyMatch = true
+	instance.Dependencies = deps
+	return instance
+}
+
+// tests that committed attributes are committed, regardless of the other responses
+func (s *PrepareApplyResponsesTest) TestCommitted(c *gocheck.C) {
+	s.addResponse(uint32(4), INSTANCE_PREACCEPTED)
+	s.addResponse(uint32(5), INSTANCE_ACCEPTED)
+	committed := s.addResponse(uint32(4), INSTANCE_COMMITTED)
+	committed.Dependencies = []InstanceID{NewInstanceID()}
+	s.responses = append(s.responses, &PrepareResponse{Accepted: true, Instance: nil})
+
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_COMMIT)
+	c.Check(instance, gocheck.Equals, committed)
+}
 
-// tests that the instance with the highest ballot/status is returned
-func (s *PrepareAnalyzeResponsesTest) TestSuccessCase(c *gocheck.C) {
-	responses := make([]*PrepareResponse, 0)
-	addResponse := func(ballot uint32, status InstanceStatus) {
-		instance, _ := s.instance.Copy()
-		instance.MaxBallot = ballot
-		instance.Status = status
-		response := &PrepareResponse{
-			Accepted: true,
-			Instance: instance,
-		}
-		responses = append(responses, response)
+// tests that the highest balloted accepted attributes are accepted again
+func (s *PrepareApplyResponsesTest) TestAccepted(c *gocheck.C) {
+	s.addResponse(uint32(5), INSTANCE_PREACCEPTED)
+	s.addResponse(uint32(4), INSTANCE_ACCEPTED)
+	accepted := s.addResponse(uint32(5), INSTANCE_ACCEPTED)
+
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+	c.Check(instance, gocheck.Equals, accepted)
+}
+
+// tests that attributes accepted by every response are still accepted
+// again, since a concurrent prepare phase may be accepting others
+func (s *PrepareApplyResponsesTest) TestAllAccepted(c *gocheck.C) {
+	deps := []InstanceID{NewInstanceID(), NewInstanceID()}
+	s.addResponse(uint32(5), INSTANCE_ACCEPTED).Dependencies = deps
+	s.addResponse(uint32(5), INSTANCE_ACCEPTED).Dependencies = []InstanceID{deps[1], deps[0]}
+
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+	c.Check(NewInstanceIDSet(deps).Equal(NewInstanceIDSet(instance.Dependencies)), gocheck.Equals, true)
+}
+
+// tests that the attributes of preaccepted responses that agreed
+// with the leader are accepted, if enough of them did. They aren't
+// committed without an accept phase, since a concurrent prepare
+// phase with another quorum may be accepting other attributes
+func (s *PrepareApplyResponsesTest) TestPreAcceptedDependencyMatch(c *gocheck.C) {
+	deps := []InstanceID{NewInstanceID()}
+	for i := 0; i < recoveryMatchSize(s.numNodes); i++ {
+		s.addMatchResponse(deps)
 	}
-	addResponse(uint32(4), INSTANCE_PREACCEPTED)
-	addResponse(uint32(4), INSTANCE_ACCEPTED)
-	addResponse(uint32(4), INSTANCE_EXECUTED)
-	addResponse(uint32(5), INSTANCE_PREACCEPTED)
-	addResponse(uint32(5), INSTANCE_ACCEPTED)
-	addResponse(uint32(5), INSTANCE_COMMITTED)
+	s.addResponse(uint32(5), INSTANCE_PREACCEPTED).Dependencies = []InstanceID{NewInstanceID()}
+
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+	c.Check(instance.Dependencies, gocheck.DeepEquals, deps)
+	c.Check(instance.InstanceID, gocheck.Equals, s.instance.InstanceID)
+}
+
+// tests that the leader's preaccepted instance isn't counted towards
+// the responses agreeing with it, since it always agrees with itself
+func (s *PrepareApplyResponsesTest) TestPreAcceptedLeaderMatch(c *gocheck.C) {
+	deps := []InstanceID{NewInstanceID()}
+	s.addMatchResponse(deps)
+	s.addMatchResponse(deps)
+	s.responses[1].NodeID = s.leader.id
+
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+	c.Check(instance.Dependencies, gocheck.DeepEquals, deps)
+
+	// the leader recovering its own instance doesn't count itself either
+	s.responses = []*PrepareResponse{}
+	s.addMatchResponse(deps)
+	local, _ := s.instance.Copy()
+	local.Status = INSTANCE_PREACCEPTED
+	local.AcceptedBallot = uint32(5)
+	local.DependencyMatch = true
+	local.Dependencies = deps
+	action, _, err = s.manager.applyPrepareResponses(s.responses, local)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+}
+
+// tests that responses that agreed with the leader, but report different
+// dependencies, aren't counted together, and their dependencies are merged
+// and accepted if there aren't enough of any of them
+func (s *PrepareApplyResponsesTest) TestPreAcceptedSplitDependencies(c *gocheck.C) {
+	dep1 := NewInstanceID()
+	dep2 := NewInstanceID()
+	s.addMatchResponse([]InstanceID{dep1})
+	s.addMatchResponse([]InstanceID{dep1, dep2})
+
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+	expected := NewInstanceIDSet([]InstanceID{dep1, dep2})
+	c.Check(expected.Equal(NewInstanceIDSet(instance.Dependencies)), gocheck.Equals, true)
+
+	// enough matching responses with the same dependencies are
+	// accepted, without the dependencies of the others
+	s.responses[0].Instance.Dependencies = []InstanceID{dep1, NewInstanceID()}
+	s.addMatchResponse([]InstanceID{dep2, dep1})
+	action, instance, err = s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+	c.Check(expected.Equal(NewInstanceIDSet(instance.Dependencies)), gocheck.Equals, true)
+}
+
+// tests that matching responses preaccepted at different
+// ballots aren't counted together
+func (s *PrepareApplyResponsesTest) TestPreAcceptedMatchDifferentBallots(c *gocheck.C) {
+	deps := []InstanceID{NewInstanceID()}
+	s.addMatchResponse(deps)
+	s.addMatchResponse(deps).AcceptedBallot = uint32(4)
+
+	action, _, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_PREACCEPT)
+}
+
+// tests that the dependencies of preaccepted responses with
+// the same ballot are merged and accepted, if none of them
+// agreed with the leader
+func (s *PrepareApplyResponsesTest) TestPreAcceptedMerge(c *gocheck.C) {
+	s.instance.Dependencies = []InstanceID{NewInstanceID()}
+	dep1 := NewInstanceID()
+	dep2 := NewInstanceID()
+	s.addResponse(uint32(5), INSTANCE_PREACCEPTED).Dependencies = []InstanceID{dep1}
+	s.addResponse(uint32(5), INSTANCE_PREACCEPTED).Dependencies = []InstanceID{dep1, dep2}
+
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+	expected := NewInstanceIDSet([]InstanceID{s.instance.Dependencies[0], dep1, dep2})
+	c.Check(expected.Equal(NewInstanceIDSet(instance.Dependencies)), gocheck.Equals, true)
+
+	// the local instance isn't modified
+	c.Check(len(s.instance.Dependencies), gocheck.Equals, 1)
+}
+
+// tests that the dependencies of a response that agreed with the leader
+// are merged with the others, if too few agreed for the leader to have
+// committed them on the fast path
+func (s *PrepareApplyResponsesTest) TestPreAcceptedPartialMatch(c *gocheck.C) {
+	deps := []InstanceID{NewInstanceID()}
+	s.addMatchResponse(deps)
+	other := NewInstanceID()
+	s.addResponse(uint32(5), INSTANCE_PREACCEPTED).Dependencies = []InstanceID{other}
+
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+	expected := NewInstanceIDSet([]InstanceID{deps[0], other})
+	c.Check(expected.Equal(NewInstanceIDSet(instance.Dependencies)), gocheck.Equals, true)
+}
+
+// tests that the preaccept phase is run again if the
+// preaccepted responses don't agree on the ballot, or
+// some replicas don't know about the instance
+func (s *PrepareApplyResponsesTest) TestPreAcceptedDisagreement(c *gocheck.C) {
+	s.addResponse(uint32(4), INSTANCE_PREACCEPTED)
+	s.addResponse(uint32(5), INSTANCE_PREACCEPTED)
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_PREACCEPT)
+	c.Check(instance.Noop, gocheck.Equals, false)
+
+	s.responses = []*PrepareResponse{
+		&PrepareResponse{Accepted: true, Instance: nil},
+	}
+	s.addResponse(uint32(5), INSTANCE_PREACCEPTED).DependencyMatch = true
+	action, instance, err = s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_PREACCEPT)
+	c.Check(instance.Noop, gocheck.Equals, false)
+}
+
+// tests that a noop is preaccepted if no
+// other replicas know about the instance
+func (s *PrepareApplyResponsesTest) TestUnknownInstance(c *gocheck.C) {
+	s.responses = []*PrepareResponse{
+		&PrepareResponse{Accepted: true, Instance: nil},
+		&PrepareResponse{Accepted: true, Instance: nil},
+	}
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_PREACCEPT)
+	c.Check(instance.Noop, gocheck.Equals, true)
+	c.Check(s.instance.Noop, gocheck.Equals, false)
+}
+
+// tests that responses accepted at different ballots aren't
+// committed, even though the prepare request gave them all
+// the same MaxBallot
+func (s *PrepareApplyResponsesTest) TestAcceptedDifferentBallots(c *gocheck.C) {
+	s.addResponse(uint32(4), INSTANCE_ACCEPTED)
+	accepted := s.addResponse(uint32(5), INSTANCE_ACCEPTED)
+	s.addResponse(uint32(3), INSTANCE_ACCEPTED)
+
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+	c.Check(instance, gocheck.Equals, accepted)
+}
+
+// tests that the local instance is analyzed along with the
+// responses when it has the same status as they do
+func (s *PrepareApplyResponsesTest) TestLocalInstanceIncluded(c *gocheck.C) {
+	s.instance.Status = INSTANCE_ACCEPTED
+	s.instance.AcceptedBallot = uint32(6)
+	s.instance.Dependencies = []InstanceID{NewInstanceID()}
+	deps := []InstanceID{NewInstanceID()}
+	s.addResponse(uint32(5), INSTANCE_ACCEPTED).Dependencies = deps
+	s.addResponse(uint32(5), INSTANCE_ACCEPTED).Dependencies = deps
 
-	instance := s.manager.analyzePrepareResponses(responses)
-	c.Assert(instance, gocheck.NotNil)
-	c.Check(instance.MaxBallot, gocheck.Equals, uint32(5))
-	c.Check(instance.Status, gocheck.Equals, INSTANCE_COMMITTED)
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+	c.Check(instance.Dependencies, gocheck.DeepEquals, s.instance.Dependencies)
+
+	// a local preaccepted instance that agreed with the
+	// leader counts towards the matching responses
+	s.responses = []*PrepareResponse{}
+	s.instance.Status = INSTANCE_PREACCEPTED
+	s.instance.AcceptedBallot = uint32(5)
+	s.instance.DependencyMatch = false
+	s.addMatchResponse(deps)
+	action, _, err = s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+
+	s.instance.DependencyMatch = true
+	s.instance.Dependencies = deps
+	s.responses[0].Instance.Dependencies = deps
+	s.addResponse(uint32(5), INSTANCE_PREACCEPTED).Dependencies = []InstanceID{NewInstanceID()}
+	action, instance, err = s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+	c.Check(instance.Dependencies, gocheck.DeepEquals, deps)
 }
 
-// tests that a mix of responses with nil instances, and not nil instances
-// is safe from nil pointer errors
-func (s *PrepareAnalyzeResponsesTest) TestMixedNilResponses(c *gocheck.C) {
+// tests that the local instance is used if it's
+// further along than any of the responses
+func (s *PrepareApplyResponsesTest) TestLocalInstanceAccepted(c *gocheck.C) {
+	s.instance.Status = INSTANCE_ACCEPTED
+	s.addResponse(uint32(5), INSTANCE_PREACCEPTED)
 
+	action, instance, err := s.recoverer.applyPrepareResponses(s.responses, s.instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(action, gocheck.Equals, PREPARE_ACCEPT)
+	c.Check(instance.InstanceID, gocheck.Equals, s.instance.InstanceID)
+	c.Check(instance.Status, gocheck.Equals, INSTANCE_ACCEPTED)
 }
 
 // tests the prepare phase method
@@ -455,13 +720,14 @@ type PreparePhase2Test struct {
 var _ = gocheck.Suite(&PreparePhase2Test{})
 
 // tests that receiving prepare responses, where the highest
-// balloted instance's status was preaccepted, a preaccept phase
-// is initiated
+// balloted instance's status was preaccepted, and some replicas
+// don't know about the instance, a preaccept phase is initiated
 func (s *PreparePhase2Test) TestPreAcceptedSuccess(c *gocheck.C) {
 	remoteInstance, _ := s.instance.Copy()
 	remoteInstance.Status = INSTANCE_PREACCEPTED
 	responses := []*PrepareResponse{
 		&PrepareResponse{Accepted: true, Instance: remoteInstance},
+		&PrepareResponse{Accepted: true, Instance: nil},
 	}
 
 	// patch methods
@@ -480,14 +746,16 @@ func (s *PreparePhase2Test) TestPreAcceptedSuccess(c *gocheck.C) {
 }
 
 // tests that receiving prepare responses, where the highest
-// balloted instance's status was preaccepted, a preaccept phase
-// is initiated, and the accept phase is skipped if there are
-// dependency mismatches
+// balloted instance's status was preaccepted, and some replicas
+// don't know about the instance, a preaccept phase is initiated,
+// and the accept phase is skipped if there are no dependency
+// mismatches
 func (s *PreparePhase2Test) TestPreAcceptedChangeSuccess(c *gocheck.C) {
 	remoteInstance, _ := s.instance.Copy()
 	remoteInstance.Status = INSTANCE_PREACCEPTED
 	responses := []*PrepareResponse{
 		&PrepareResponse{Accepted: true, Instance: remoteInstance},
+		&PrepareResponse{Accepted: true, Instance: nil},
 	}
 
 	// patch methods
@@ -505,14 +773,15 @@ func (s *PreparePhase2Test) TestPreAcceptedChangeSuccess(c *gocheck.C) {
 }
 
 // tests that receiving prepare responses, where the highest
-// balloted instance's status was preaccepted, a preaccept phase
-// is initiated, and the method returns if pre accept returns an
-// error
+// balloted instance's status was preaccepted, and some replicas
+// don't know about the instance, a preaccept phase is initiated,
+// and the method returns if pre accept returns an error
 func (s *PreparePhase2Test) TestPreAcceptedFailure(c *gocheck.C) {
 	remoteInstance, _ := s.instance.Copy()
 	remoteInstance.Status = INSTANCE_PREACCEPTED
 	responses := []*PrepareResponse{
 		&PrepareResponse{Accepted: true, Instance: remoteInstance},
+		&PrepareResponse{Accepted: true, Instance: nil},
 	}
 
 	// patch methods
@@ -526,14 +795,65 @@ func (s *PreparePhase2Test) TestPreAcceptedFailure(c *gocheck.C) {
 	c.Check(s.commitCalls, gocheck.Equals, 0)
 }
 
+// tests that receiving prepare responses that are all preaccepted
+// with the same ballot skips the preaccept phase
+func (s *PreparePhase2Test) TestPreAcceptedMergeSuccess(c *gocheck.C) {
+	remoteInstance, _ := s.instance.Copy()
+	remoteInstance.Status = INSTANCE_PREACCEPTED
+	responses := []*PrepareResponse{
+		&PrepareResponse{Accepted: true, Instance: remoteInstance},
+	}
+
+	// patch methods
+	s.patchPreAccept(false, nil)
+	s.patchAccept(nil)
+	s.patchCommit(nil)
+
+	err := managerPrepareApply(s.manager, s.instance, responses)
+	c.Assert(err, gocheck.IsNil)
+
+	c.Check(s.preAcceptCalls, gocheck.Equals, 0)
+	c.Check(s.acceptCalls, gocheck.Equals, 1)
+	c.Check(s.commitCalls, gocheck.Equals, 1)
+}
+
+// tests that receiving prepare responses that are all preaccepted,
+// and agreed with the leader's attributes, accepts and commits the
+// instance without another preaccept phase. The instance was led by
+// another node, so the local replica's agreeing vote is counted
+func (s *PreparePhase2Test) TestPreAcceptedDependencyMatchSuccess(c *gocheck.C) {
+	s.instance.LeaderID = node.NewNodeId()
+	s.instance.Status = INSTANCE_PREACCEPTED
+	s.instance.DependencyMatch = true
+	remoteInstance, _ := s.instance.Copy()
+	remoteInstance.Status = INSTANCE_PREACCEPTED
+	remoteInstance.DependencyMatch = true
+	responses := []*PrepareResponse{
+		&PrepareResponse{Accepted: true, Instance: remoteInstance},
+	}
+
+	// patch methods
+	s.patchPreAccept(false, nil)
+	s.patchAccept(nil)
+	s.patchCommit(nil)
+
+	err := managerPrepareApply(s.manager, s.instance, responses)
+	c.Assert(err, gocheck.IsNil)
+
+	c.Check(s.preAcceptCalls, gocheck.Equals, 0)
+	c.Check(s.acceptCalls, gocheck.Equals, 1)
+	c.Check(s.commitCalls, gocheck.Equals, 1)
+}
+
 // tests that receiving prepare responses, where the highest
-// balloted instance's status was accepted, an accept phase
-// is initiated
+// balloted instance's status was accepted, and not every replica
+// accepted it, an accept phase is initiated
 func (s *PreparePhase2Test) TestAcceptSuccess(c *gocheck.C) {
 	remoteInstance, _ := s.instance.Copy()
 	remoteInstance.Status = INSTANCE_ACCEPTED
 	responses := []*PrepareResponse{
 		&PrepareResponse{Accepted: true, Instance: remoteInstance},
+		&PrepareResponse{Accepted: true, Instance: nil},
 	}
 
 	// patch methods
@@ -558,6 +878,7 @@ func (s *PreparePhase2Test) TestAcceptFailure(c *gocheck.C) {
 	remoteInstance.Status = INSTANCE_ACCEPTED
 	responses := []*PrepareResponse{
 		&PrepareResponse{Accepted: true, Instance: remoteInstance},
+		&PrepareResponse{Accepted: true, Instance: nil},
 	}
 
 	// patch methods
@@ -723,7 +1044,7 @@ var _ = gocheck.Suite(&PrepareReplicaTest{})
 
 func (s *PrepareReplicaTest) SetUpTest(c *gocheck.C) {
 	s.baseManagerTest.SetUpTest(c)
-	s.instance = s.manager.makeInstance(getBasicInstruction())
+	s.instance = s.manager.makeInstance(s.consistency, getBasicInstruction())
 }
 
 // tests that a prepare request with an incremented ballot
@@ -798,7 +1119,7 @@ var _ = gocheck.Suite(&SuccessorPreparePhaseTest{})
 
 func (s *SuccessorPreparePhaseTest) SetUpTest(c *gocheck.C) {
 	s.baseReplicaTest.SetUpTest(c)
-	s.instance = s.manager.makeInstance(getBasicInstruction())
+	s.instance = s.manager.makeInstance(s.consistency, getBasicInstruction())
 	err := s.manager.preAcceptInstance(s.instance, false)
 	c.Assert(err, gocheck.IsNil)
 	for _, replica := range s.replicas {
@@ -1026,7 +1347,7 @@ func (s *HandlePrepareSuccessorRequestTest) SetUpSuite(c *gocheck.C) {
 
 func (s *HandlePrepareSuccessorRequestTest) SetUpTest(c *gocheck.C) {
 	s.baseReplicaTest.SetUpTest(c)
-	s.instance = s.manager.makeInstance(getBasicInstruction())
+	s.instance = s.manager.makeInstance(s.consistency, getBasicInstruction())
 	err := s.manager.preAcceptInstance(s.instance, false)
 	c.Assert(err, gocheck.IsNil)
 }
@@ -0,0 +1,149 @@
+package consensus
+
+import (
+	"node"
+)
+
+// tracks the responses received for an instance. Instances operating
+// on keys owned by different replica sets need a quorum of responses
+// from each of them, not just a quorum of all the replicas involved
+type quorumTracker struct {
+	replicaSets [][]node.NodeId
+	received map[node.NodeId]bool
+}
+
+// the local node counts as a response for every replica set it's a member of
+func newQuorumTracker(localID node.NodeId, replicaSets [][]node.NodeId) *quorumTracker {
+	q := &quorumTracker{
+		replicaSets: replicaSets,
+		received: make(map[node.NodeId]bool),
+	}
+	q.add(localID)
+	return q
+}
+
+func (m *Manager) newInstanceQuorumTracker(instance *Instance) (*quorumTracker, error) {
+	replicaSets, err := m.getInstanceReplicaSets(instance)
+	if err != nil {
+		return nil, err
+	}
+	return newQuorumTracker(m.nodeID, replicaSets), nil
+}
+
+// the number of responses required from a replica set of the given size.
+// This is a strict majority, so any two quorums of an even sized replica
+// set intersect too
+func quorumSize(numReplicas int) int {
+	return (numReplicas / 2) + 1
+}
+
+// the number of failures a replica set of the given size tolerates
+// on the slow path
+func quorumFailures(numReplicas int) int {
+	return numReplicas - quorumSize(numReplicas)
+}
+
+// the number of responses required from a replica set of the given size
+// to commit on the fast path. This is 2F, where F is the number of failures
+// tolerated by the slow path quorum, and is never smaller than the slow path
+// quorum. The epaxos paper's optimized fast quorum, F + floor((F + 1) / 2),
+// isn't used, since it's only safe if recovery runs the paper's TryPreAccept
+// phase, which the prepare phase doesn't
+func fastQuorumSize(numReplicas int) int {
+	size := 2 * quorumFailures(numReplicas)
+	if slow := quorumSize(numReplicas); size < slow {
+		return slow
+	}
+	return size
+}
+
+// the number of replicas, other than the leader, from a replica set of the
+// given size, that must report identical dependencies agreeing with the leader
+// before a prepare phase can commit them. Any fast path commit leaves at least
+// this many in every prepare quorum. This is F, and at least 1
+func recoveryMatchSize(numReplicas int) int {
+	size := quorumFailures(numReplicas)
+	if size < 1 {
+		return 1
+	}
+	return size
+}
+
+// records a response from the given node
+func (q *quorumTracker) add(nid node.NodeId) {
+	q.received[nid] = true
+}
+
+// returns true if a quorum of responses
+// have been received from every replica set
+func (q *quorumTracker) satisfied() bool {
+	return q.satisfiedBy(quorumSize)
+}
+
+// returns true if a fast path quorum of responses
+// have been received from every replica set
+func (q *quorumTracker) fastSatisfied() bool {
+	return q.satisfiedBy(fastQuorumSize)
+}
+
+// returns true if the given number of responses have been
+// received from every replica set
+func (q *quorumTracker) satisfiedBy(size func(int) int) bool {
+	for _, replicaSet := range q.replicaSets {
+		if len(replicaSet) == 0 {
+			continue
+		}
+		numReceived := 0
+		for _, nid := range replicaSet {
+			if q.received[nid] {
+				numReceived++
+			}
+		}
+		if numReceived < size(len(replicaSet)) {
+			return false
+		}
+	}
+	return true
+}
+
+// splits the given replicas into the ones needed for a quorum of the
+// given size from every replica set, and the rest. Thrifty leaders only
+// message the first group, and fall back to the rest if responses are late.
+// Nodes that have already responded count towards the quorums
+func (q *quorumTracker) thriftyReplicas(replicas []node.Node, size func(int) int) ([]node.Node, []node.Node) {
+	selected := make(map[node.NodeId]bool, len(replicas))
+	for nid := range q.received {
+		selected[nid] = true
+	}
+	for _, replicaSet := range q.replicaSets {
+		members := make(map[node.NodeId]bool, len(replicaSet))
+		numSelected := 0
+		for _, nid := range replicaSet {
+			members[nid] = true
+			if selected[nid] {
+				numSelected++
+			}
+		}
+		required := size(len(replicaSet))
+		for _, replica := range replicas {
+			if numSelected >= required {
+				break
+			}
+			if nid := replica.GetId(); members[nid] && !selected[nid] {
+				selected[nid] = true
+				numSelected++
+			}
+		}
+	}
+
+	quorum := make([]node.Node, 0, len(replicas))
+	rest := make([]node.Node, 0, len(replicas))
+	for _, replica := range replicas {
+		if selected[replica.GetId()] {
+			quorum = append(quorum, replica)
+		} else {
+			rest = append(rest, replica)
+		}
+	}
+	return quorum, rest
+}
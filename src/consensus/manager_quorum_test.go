@@ -0,0 +1,155 @@
+package consensus
+
+import (
+	"launchpad.net/gocheck"
+)
+
+import (
+	"node"
+)
+
+type QuorumTrackerTest struct {
+	localID node.NodeId
+	nodes []node.NodeId
+}
+
+var _ = gocheck.Suite(&QuorumTrackerTest{})
+
+func (s *QuorumTrackerTest) SetUpTest(c *gocheck.C) {
+	s.localID = node.NewNodeId()
+	s.nodes = make([]node.NodeId, 5)
+	for i := range s.nodes {
+		s.nodes[i] = node.NewNodeId()
+	}
+}
+
+// tests that a single replica set requires the
+// same number of responses as before
+func (s *QuorumTrackerTest) TestSingleReplicaSet(c *gocheck.C) {
+	replicaSet := []node.NodeId{s.localID, s.nodes[0], s.nodes[1], s.nodes[2], s.nodes[3]}
+	quorum := newQuorumTracker(s.localID, [][]node.NodeId{replicaSet})
+	c.Check(quorum.satisfied(), gocheck.Equals, false)
+
+	quorum.add(s.nodes[0])
+	c.Check(quorum.satisfied(), gocheck.Equals, false)
+
+	// duplicate responses aren't counted twice
+	quorum.add(s.nodes[0])
+	c.Check(quorum.satisfied(), gocheck.Equals, false)
+
+	quorum.add(s.nodes[3])
+	c.Check(quorum.satisfied(), gocheck.Equals, true)
+}
+
+// tests that a quorum is required from every replica set,
+// and that the local node only counts towards its own
+func (s *QuorumTrackerTest) TestMultipleReplicaSets(c *gocheck.C) {
+	localSet := []node.NodeId{s.localID, s.nodes[0], s.nodes[1]}
+	remoteSet := []node.NodeId{s.nodes[1], s.nodes[2], s.nodes[3]}
+	quorum := newQuorumTracker(s.localID, [][]node.NodeId{localSet, remoteSet})
+
+	quorum.add(s.nodes[0])
+	c.Check(quorum.satisfied(), gocheck.Equals, false)
+
+	// node 1 is a member of both sets
+	quorum.add(s.nodes[1])
+	c.Check(quorum.satisfied(), gocheck.Equals, false)
+
+	// responses from nodes outside the sets don't count
+	quorum.add(s.nodes[4])
+	c.Check(quorum.satisfied(), gocheck.Equals, false)
+
+	quorum.add(s.nodes[3])
+	c.Check(quorum.satisfied(), gocheck.Equals, true)
+}
+
+// tests that both replicas of a replica set of 2 have to respond,
+// since one of them isn't a majority
+func (s *QuorumTrackerTest) TestTwoReplicas(c *gocheck.C) {
+	quorum := newQuorumTracker(s.localID, [][]node.NodeId{[]node.NodeId{s.localID, s.nodes[0]}})
+	c.Check(quorum.satisfied(), gocheck.Equals, false)
+	c.Check(quorum.fastSatisfied(), gocheck.Equals, false)
+
+	quorum.add(s.nodes[0])
+	c.Check(quorum.satisfied(), gocheck.Equals, true)
+	c.Check(quorum.fastSatisfied(), gocheck.Equals, true)
+}
+
+// tests that 3 of the replicas of a replica set of 4 have to respond,
+// so the quorums of any two instances intersect
+func (s *QuorumTrackerTest) TestFourReplicas(c *gocheck.C) {
+	replicaSet := []node.NodeId{s.localID, s.nodes[0], s.nodes[1], s.nodes[2]}
+	quorum := newQuorumTracker(s.localID, [][]node.NodeId{replicaSet})
+
+	quorum.add(s.nodes[0])
+	c.Check(quorum.satisfied(), gocheck.Equals, false)
+	c.Check(quorum.fastSatisfied(), gocheck.Equals, false)
+
+	quorum.add(s.nodes[2])
+	c.Check(quorum.satisfied(), gocheck.Equals, true)
+	c.Check(quorum.fastSatisfied(), gocheck.Equals, true)
+}
+
+func (s *QuorumTrackerTest) TestLocalOnly(c *gocheck.C) {
+	quorum := newQuorumTracker(s.localID, [][]node.NodeId{[]node.NodeId{s.localID}})
+	c.Check(quorum.satisfied(), gocheck.Equals, true)
+}
+
+func (s *QuorumTrackerTest) TestQuorumSize(c *gocheck.C) {
+	c.Check(quorumSize(1), gocheck.Equals, 1)
+	c.Check(quorumSize(2), gocheck.Equals, 2)
+	c.Check(quorumSize(3), gocheck.Equals, 2)
+	c.Check(quorumSize(4), gocheck.Equals, 3)
+	c.Check(quorumSize(5), gocheck.Equals, 3)
+}
+
+func (s *QuorumTrackerTest) TestFastQuorumSize(c *gocheck.C) {
+	c.Check(fastQuorumSize(1), gocheck.Equals, 1)
+	c.Check(fastQuorumSize(2), gocheck.Equals, 2)
+	c.Check(fastQuorumSize(3), gocheck.Equals, 2)
+	c.Check(fastQuorumSize(4), gocheck.Equals, 3)
+	c.Check(fastQuorumSize(5), gocheck.Equals, 4)
+	c.Check(fastQuorumSize(6), gocheck.Equals, 4)
+	c.Check(fastQuorumSize(7), gocheck.Equals, 6)
+	c.Check(fastQuorumSize(9), gocheck.Equals, 8)
+}
+
+// tests that every fast path quorum leaves recoveryMatchSize
+// replicas, other than the leader, in every prepare quorum
+func (s *QuorumTrackerTest) TestRecoveryMatchSize(c *gocheck.C) {
+	for n := 2; n < 10; n++ {
+		// the worst case prepare quorum is missing the leader, and
+		// as many of the fast path quorum's replicas as possible
+		fastReplicas := fastQuorumSize(n) - 1
+		missing := (n - 1) - quorumSize(n)
+		c.Check(fastReplicas - missing >= recoveryMatchSize(n), gocheck.Equals, true, gocheck.Commentf("%v replicas", n))
+	}
+	c.Check(recoveryMatchSize(3), gocheck.Equals, 1)
+	c.Check(recoveryMatchSize(5), gocheck.Equals, 2)
+	c.Check(recoveryMatchSize(7), gocheck.Equals, 3)
+}
+
+// tests that thrifty replica selection picks enough replicas
+// from each replica set, counting the ones already responded
+func (s *QuorumTrackerTest) TestThriftyReplicas(c *gocheck.C) {
+	nodes := make([]node.Node, len(s.nodes))
+	for i, nid := range s.nodes {
+		nodes[i] = &mockNode{id: nid}
+	}
+	setA := []node.NodeId{s.localID, s.nodes[0], s.nodes[1], s.nodes[2], s.nodes[3]}
+	setB := []node.NodeId{s.nodes[2], s.nodes[3], s.nodes[4]}
+	quorum := newQuorumTracker(s.localID, [][]node.NodeId{setA, setB})
+
+	// local node, 0 & 1 make a quorum of A, 2 & 3 make a quorum of B
+	selected, rest := quorum.thriftyReplicas(nodes, quorumSize)
+	c.Check(len(selected), gocheck.Equals, 4)
+	c.Check(len(rest), gocheck.Equals, 1)
+	c.Check(rest[0].GetId(), gocheck.Equals, s.nodes[4])
+
+	// nodes that have already responded count towards the quorums
+	quorum.add(s.nodes[3])
+	quorum.add(s.nodes[4])
+	selected, rest = quorum.thriftyReplicas(nodes, quorumSize)
+	c.Check(len(selected), gocheck.Equals, 3)
+	c.Check(len(rest), gocheck.Equals, 2)
+}
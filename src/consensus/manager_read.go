@@ -0,0 +1,187 @@
+package consensus
+
+import (
+	"fmt"
+	"time"
+)
+
+import (
+	"node"
+	"store"
+)
+
+// returns the ids of the local instances writing to the keys the given
+// read only instruction depends on, that haven't been executed yet
+func (m *Manager) getInFlightWrites(instruction store.Instruction) ([]InstanceID, error) {
+	deps, err := m.depsMngr.GetDeps(&Instance{Commands: []store.Instruction{instruction}, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	inFlight := make([]InstanceID, 0)
+	for _, iid := range deps {
+		instance := m.instances.Get(iid)
+		if instance == nil || instance.getStatus() >= INSTANCE_EXECUTED {
+			continue
+		}
+		// read only instances don't interfere with reads
+		writes := false
+		for _, cmd := range instance.Commands {
+			if !m.store.IsReadOnly(cmd) {
+				writes = true
+				break
+			}
+		}
+		if writes {
+			inFlight = append(inFlight, iid)
+		}
+	}
+	return inFlight, nil
+}
+
+// returns true if all of the given instances have been executed locally
+func (m *Manager) instancesExecuted(iids []InstanceID) bool {
+	for _, iid := range iids {
+		instance := m.instances.Get(iid)
+		if instance == nil || instance.getStatus() < INSTANCE_EXECUTED {
+			return false
+		}
+	}
+	return true
+}
+
+// sends read requests to the given replicas, and returns the responses
+// once a quorum has been received
+func (m *Manager) sendRead(instruction store.Instruction, consistency ConsistencyLevel, replicas []node.Node) ([]*ReadResponse, error) {
+	start := time.Now()
+	defer m.statsTiming("read.message.send.time", start)
+	m.statsInc("read.message.send.count", 1)
+
+	// responses are nil if the message failed
+	type reply struct {
+		nid node.NodeId
+		response *ReadResponse
+	}
+	recvChan := make(chan reply, len(replicas))
+	msg := &ReadRequest{Instruction: instruction}
+	sendMsg := func(n node.Node) {
+		if response, err := n.SendMessage(msg); err != nil {
+			logger.Warning("Error receiving ReadResponse: %v", err)
+			recvChan <- reply{n.GetId(), nil}
+		} else {
+			if read, ok := response.(*ReadResponse); ok {
+				recvChan <- reply{n.GetId(), read}
+			} else {
+				logger.Warning("Unexpected Read response type: %T", response)
+				recvChan <- reply{n.GetId(), nil}
+			}
+		}
+	}
+	for _, replica := range replicas {
+		go sendMsg(replica)
+	}
+
+	quorum, err := m.newInstanceQuorumTracker(&Instance{Commands: []store.Instruction{instruction}, Consistency: consistency})
+	if err != nil {
+		return nil, err
+	}
+	timeoutEvent := getTimeoutEvent(getConsistencyTimeout(consistency, READ_TIMEOUT))
+	responses := make([]*ReadResponse, 0, len(replicas))
+	for !quorum.satisfied() {
+		select {
+		case r := <-recvChan:
+			if r.response == nil {
+				continue
+			}
+			responses = append(responses, r.response)
+			quorum.add(r.nid)
+		case <-timeoutEvent:
+			m.statsInc("read.message.send.timeout", 1)
+			logger.Info("Read timeout for instruction: %v", instruction)
+			return nil, NewTimeoutError("Timeout while awaiting read responses")
+		}
+	}
+	return responses, nil
+}
+
+// attempts to read the given instruction from the local store, without running
+// an instance. A quorum of replicas is asked for the timestamp of the key's
+// last write, including deletes, and for any writes to it that haven't been executed. Since every
+// completed write has been preaccepted by a quorum of replicas, at least one
+// of the responses will know about any write that completed before the read
+// began. If none of the replicas have a newer value than the local node, and
+// all of the writes they know about have been executed locally, the local
+// value is linearizable. Returns false if an instance is required
+func (m *Manager) executeLeaderlessRead(instruction store.Instruction, consistency ConsistencyLevel) (bool, store.Value, error) {
+	inFlight, err := m.getInFlightWrites(instruction)
+	if err != nil {
+		return false, nil, err
+	}
+	if len(inFlight) > 0 {
+		m.statsInc("read.fallback.local_write.count", 1)
+		logger.Debug("Read: %v writes to %v in flight locally", len(inFlight), instruction.Key)
+		return false, nil, nil
+	}
+	localTimestamp := m.getKeyWriteTimestamp(instruction.Key)
+
+	replicas, err := m.getInstanceReplicas(&Instance{Commands: []store.Instruction{instruction}, Consistency: consistency})
+	if err != nil {
+		return false, nil, err
+	}
+	responses, err := m.sendRead(instruction, consistency, replicas)
+	if err != nil {
+		return false, nil, err
+	}
+
+	for _, response := range responses {
+		if response.Timestamp.After(localTimestamp) {
+			m.statsInc("read.fallback.stale.count", 1)
+			logger.Debug("Read: local value for %v is out of date", instruction.Key)
+			return false, nil, nil
+		}
+		if !m.instancesExecuted(response.InFlight) {
+			m.statsInc("read.fallback.remote_write.count", 1)
+			logger.Debug("Read: %v writes to %v in flight remotely", len(response.InFlight), instruction.Key)
+			return false, nil, nil
+		}
+	}
+
+	val, err := m.store.ExecuteInstruction(instruction)
+	return true, val, err
+}
+
+// executes the given read only instruction without running an instance if it's
+// safe to, falling back to executing it in an instance if interfering writes are
+// in flight, or the local node hasn't executed them yet
+func (m *Manager) executeRead(instruction store.Instruction, consistency ConsistencyLevel) (store.Value, error) {
+	start := time.Now()
+	defer m.statsTiming("read.time", start)
+	m.statsInc("read.count", 1)
+
+	if ok, val, err := m.executeLeaderlessRead(instruction, consistency); err != nil {
+		return nil, err
+	} else if ok {
+		m.statsInc("read.leaderless.count", 1)
+		return val, nil
+	}
+
+	m.statsInc("read.fallback.count", 1)
+	return m.executeQueryInstance(instruction, consistency)
+}
+
+// handles a read request from a node attempting a leaderless read
+func (m *Manager) HandleRead(request *ReadRequest) (*ReadResponse, error) {
+	m.statsInc("read.message.received.count", 1)
+	start := time.Now()
+	defer m.statsTiming("read.message.response.time", start)
+
+	instruction := request.Instruction
+	if !m.checkLocalKeyEligibility(instruction.Key) {
+		return nil, fmt.Errorf("key '%v' isn't replicated locally", instruction.Key)
+	}
+	inFlight, err := m.getInFlightWrites(instruction)
+	if err != nil {
+		return nil, err
+	}
+	return &ReadResponse{Timestamp: m.getKeyWriteTimestamp(instruction.Key), InFlight: inFlight}, nil
+}
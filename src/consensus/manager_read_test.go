@@ -0,0 +1,170 @@
+package consensus
+
+import (
+	"fmt"
+	"time"
+)
+
+import (
+	"launchpad.net/gocheck"
+)
+
+import (
+	"store"
+)
+
+type LeaderlessReadTest struct {
+	baseReplicaTest
+}
+
+var _ = gocheck.Suite(&LeaderlessReadTest{})
+
+func (s *LeaderlessReadTest) instruction(val int) store.Instruction {
+	return store.NewInstruction("set", "a", []string{fmt.Sprintf("%v", val)}, time.Time{})
+}
+
+// writes the given value to the key on each of the given managers,
+// outside of an instance
+func (s *LeaderlessReadTest) write(c *gocheck.C, timestamp time.Time, managers ...*Manager) {
+	for _, manager := range managers {
+		_, err := manager.store.ExecuteInstruction(store.NewInstruction("set", "a", []string{"4"}, timestamp))
+		c.Assert(err, gocheck.IsNil)
+	}
+}
+
+// preaccepts a write to the read key on the given manager
+func (s *LeaderlessReadTest) preAcceptWrite(c *gocheck.C, manager *Manager) *Instance {
+	instance := manager.makeInstance(s.consistency, store.NewInstruction("set", "a", []string{"6"}, time.Now()))
+	err := manager.preAcceptInstance(instance, false)
+	c.Assert(err, gocheck.IsNil)
+	return instance
+}
+
+// tests that the local value is read without running an instance
+// if none of the replicas have a newer value, or writes in flight
+func (s *LeaderlessReadTest) TestLeaderlessRead(c *gocheck.C) {
+	s.write(c, time.Now(), s.managers...)
+
+	val, err := s.manager.executeRead(s.instruction(5), s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(val.(*intVal).value, gocheck.Equals, 5)
+	c.Check(s.manager.instances.Len(), gocheck.Equals, 0)
+
+	stats := s.manager.stats.(*mockStatter)
+	c.Check(stats.counters["read.leaderless.count"], gocheck.Equals, int64(1))
+	c.Check(stats.counters["read.fallback.count"], gocheck.Equals, int64(0))
+	for _, replica := range s.replicas {
+		for _, msg := range replica.sentMessages {
+			c.Check(msg, gocheck.FitsTypeOf, &ReadRequest{})
+		}
+	}
+}
+
+// tests that an instance is run if a replica
+// has a newer value than the local node
+func (s *LeaderlessReadTest) TestStaleFallback(c *gocheck.C) {
+	timestamp := time.Now()
+	s.write(c, timestamp, s.managers...)
+	s.write(c, timestamp.Add(time.Second), s.replicaManagers...)
+
+	_, err := s.manager.executeRead(s.instruction(5), s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(s.manager.instances.Len(), gocheck.Equals, 1)
+
+	stats := s.manager.stats.(*mockStatter)
+	c.Check(stats.counters["read.fallback.stale.count"], gocheck.Equals, int64(1))
+	c.Check(stats.counters["read.fallback.count"], gocheck.Equals, int64(1))
+}
+
+// tests that an instance is run if a replica has deleted
+// the key since the local node's value was written
+func (s *LeaderlessReadTest) TestDeletedFallback(c *gocheck.C) {
+	timestamp := time.Now()
+	s.write(c, timestamp, s.managers...)
+	for _, manager := range s.replicaManagers {
+		_, err := manager.store.ExecuteInstruction(store.NewInstruction("del", "a", []string{}, timestamp.Add(time.Second)))
+		c.Assert(err, gocheck.IsNil)
+	}
+
+	ok, _, err := s.manager.executeLeaderlessRead(s.instruction(5), s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(ok, gocheck.Equals, false)
+
+	stats := s.manager.stats.(*mockStatter)
+	c.Check(stats.counters["read.fallback.stale.count"], gocheck.Equals, int64(1))
+}
+
+// tests that an instance is run if a write to
+// the key is in flight on the local node
+func (s *LeaderlessReadTest) TestLocalWriteFallback(c *gocheck.C) {
+	s.preAcceptWrite(c, s.manager)
+
+	ok, _, err := s.manager.executeLeaderlessRead(s.instruction(5), s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(ok, gocheck.Equals, false)
+
+	stats := s.manager.stats.(*mockStatter)
+	c.Check(stats.counters["read.fallback.local_write.count"], gocheck.Equals, int64(1))
+	c.Check(stats.counters["read.message.send.count"], gocheck.Equals, int64(0))
+}
+
+// tests that an instance is run if a write to the key
+// is in flight on the replicas, and hasn't been executed
+// by the local node
+func (s *LeaderlessReadTest) TestRemoteWriteFallback(c *gocheck.C) {
+	for _, manager := range s.replicaManagers {
+		s.preAcceptWrite(c, manager)
+	}
+
+	ok, _, err := s.manager.executeLeaderlessRead(s.instruction(5), s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(ok, gocheck.Equals, false)
+
+	stats := s.manager.stats.(*mockStatter)
+	c.Check(stats.counters["read.fallback.remote_write.count"], gocheck.Equals, int64(1))
+}
+
+// tests that writes in flight on the replicas don't require
+// an instance if they've already been executed locally
+func (s *LeaderlessReadTest) TestRemoteWriteExecutedLocally(c *gocheck.C) {
+	instance := s.preAcceptWrite(c, s.replicaManagers[0])
+	for _, manager := range s.replicaManagers[1:] {
+		instanceCopy, err := instance.Copy()
+		c.Assert(err, gocheck.IsNil)
+		c.Assert(manager.preAcceptInstance(instanceCopy, false), gocheck.IsNil)
+	}
+
+	instanceCopy, err := instance.Copy()
+	c.Assert(err, gocheck.IsNil)
+	instanceCopy.Status = INSTANCE_COMMITTED
+	c.Assert(s.manager.addMissingInstances(instanceCopy), gocheck.IsNil)
+	s.manager.instances.Get(instance.InstanceID).Status = INSTANCE_EXECUTED
+
+	ok, _, err := s.manager.executeLeaderlessRead(s.instruction(5), s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(ok, gocheck.Equals, true)
+}
+
+// tests that replicas report the timestamp of their value,
+// and the writes they haven't executed
+func (s *LeaderlessReadTest) TestHandleRead(c *gocheck.C) {
+	timestamp := time.Now()
+	s.write(c, timestamp, s.manager)
+	inFlight := s.preAcceptWrite(c, s.manager)
+
+	executed := s.preAcceptWrite(c, s.manager)
+	executed.Status = INSTANCE_EXECUTED
+
+	response, err := s.manager.HandleRead(&ReadRequest{Instruction: s.instruction(5)})
+	c.Assert(err, gocheck.IsNil)
+	c.Check(response.Timestamp.Equal(timestamp), gocheck.Equals, true)
+	c.Check(response.InFlight, gocheck.DeepEquals, []InstanceID{inFlight.InstanceID})
+
+	// deleted keys report the timestamp of the delete
+	deleted := timestamp.Add(time.Second)
+	_, err = s.manager.store.ExecuteInstruction(store.NewInstruction("del", "a", []string{}, deleted))
+	c.Assert(err, gocheck.IsNil)
+	response, err = s.manager.HandleRead(&ReadRequest{Instruction: s.instruction(5)})
+	c.Assert(err, gocheck.IsNil)
+	c.Check(response.Timestamp.Equal(deleted), gocheck.Equals, true)
+}
@@ -2,6 +2,7 @@ package consensus
 
 import (
 	"flag"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -14,6 +15,8 @@ import (
 import (
 	"store"
 	"node"
+	"partitioner"
+	"topology"
 )
 
 var _test_loglevel = flag.String("test.loglevel", "", "the loglevel to run tests with")
@@ -62,7 +65,7 @@ var _ = gocheck.Suite(&ManagerTest{})
 // test that instances are created properly
 func (s *ManagerTest) TestInstanceCreation(c *gocheck.C) {
 	instruction := store.NewInstruction("set", "b", []string{}, time.Now())
-	instance := s.manager.makeInstance(instruction)
+	instance := s.manager.makeInstance(s.consistency, instruction)
 	c.Check(instance.MaxBallot, gocheck.Equals, uint32(0))
 	c.Check(instance.LeaderID, gocheck.Equals, s.manager.GetLocalID())
 
@@ -85,7 +88,7 @@ func (s *ManagerTest) TestInstanceCreation(c *gocheck.C) {
 func (s *ManagerTest) TestAddMissingInstance(c *gocheck.C) {
 	var err error
 	getInst := func(status InstanceStatus) *Instance {
-		inst := s.manager.makeInstance(getBasicInstruction())
+		inst := s.manager.makeInstance(s.consistency, getBasicInstruction())
 		inst.Status = status
 		return inst
 	}
@@ -124,6 +127,33 @@ func (s *ManagerTest) TestAddMissingInstance(c *gocheck.C) {
 	c.Assert(executed.Status, gocheck.Equals, INSTANCE_COMMITTED)
 }
 
+// tests that instances sent by a leader, after
+// being reported as unknown, are added
+func (s *ManagerTest) TestHandleMissingInstances(c *gocheck.C) {
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	instance.Status = INSTANCE_ACCEPTED
+
+	response, err := s.manager.HandleMessage(&InstanceRequest{Instances: []*Instance{instance}})
+	c.Assert(err, gocheck.IsNil)
+	c.Check(response, gocheck.FitsTypeOf, &InstanceResponse{})
+	c.Check(len(response.(*InstanceResponse).Instances), gocheck.Equals, 0)
+	c.Check(s.manager.instances.ContainsID(instance.InstanceID), gocheck.Equals, true)
+	c.Check(len(s.manager.getUnknownDependencies(&Instance{Dependencies: []InstanceID{instance.InstanceID}})), gocheck.Equals, 0)
+}
+
+// tests that copies of the requested instances the local node knows about are returned
+func (s *ManagerTest) TestHandleInstanceRequest(c *gocheck.C) {
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	c.Assert(s.manager.commitInstance(instance, false), gocheck.IsNil)
+
+	response, err := s.manager.HandleMessage(&InstanceRequest{InstanceIDs: []InstanceID{instance.InstanceID, NewInstanceID()}})
+	c.Assert(err, gocheck.IsNil)
+	instances := response.(*InstanceResponse).Instances
+	c.Assert(len(instances), gocheck.Equals, 1)
+	c.Check(instances[0].InstanceID, gocheck.Equals, instance.InstanceID)
+	c.Check(instances[0], gocheck.Not(gocheck.Equals), instance)
+}
+
 //
 func (s *ManagerTest) TestGetOrSetNewInstance(c *gocheck.C) {
 	instance, existed := s.manager.getOrSetInstance(makeInstance(node.NewNodeId(), []InstanceID{}))
@@ -133,7 +163,7 @@ func (s *ManagerTest) TestGetOrSetNewInstance(c *gocheck.C) {
 
 func (s *ManagerTest) TestGetOrSetExistingInstance(c *gocheck.C) {
 	getInst := func(status InstanceStatus) *Instance {
-		inst := s.manager.makeInstance(getBasicInstruction())
+		inst := s.manager.makeInstance(s.consistency, getBasicInstruction())
 		inst.Status = status
 		return inst
 	}
@@ -176,7 +206,7 @@ func (s *ManagerTest) TestGetOrSetPreacceptedNewInstanceSkipsDepsManager(c *goch
 // new instance has an status of executed
 func (s *ManagerTest) TestGetOrSetResetsToCommitted(c *gocheck.C) {
 	getInst := func(status InstanceStatus) *Instance {
-		inst := s.manager.makeInstance(getBasicInstruction())
+		inst := s.manager.makeInstance(s.consistency, getBasicInstruction())
 		inst.Status = status
 		return inst
 	}
@@ -185,6 +215,95 @@ func (s *ManagerTest) TestGetOrSetResetsToCommitted(c *gocheck.C) {
 	c.Assert(instance.Status, gocheck.Equals, INSTANCE_COMMITTED)
 }
 
+// tests replica selection and execution of instances at each
+// consistency level, with replicas in multiple datacenters
+type ManagerConsistencyTest struct {
+	baseReplicaTest
+}
+
+var _ = gocheck.Suite(&ManagerConsistencyTest{})
+
+func (s *ManagerConsistencyTest) SetUpSuite(c *gocheck.C) {
+	s.baseReplicaTest.SetUpSuite(c)
+	s.consistency = CONSISTENCY_CONSENSUS
+}
+
+// tests that consensus instances use the replicas in every datacenter,
+// with the local datacenter's replicas first
+func (s *ManagerConsistencyTest) TestConsensusReplicas(c *gocheck.C) {
+	instance := s.manager.makeInstance(s.consistency, getBasicInstruction())
+	c.Check(instance.Consistency, gocheck.Equals, CONSISTENCY_CONSENSUS)
+	c.Check(len(instance.Successors), gocheck.Equals, s.numNodes - 1)
+
+	replicas, err := s.manager.getInstanceReplicas(instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(replicas), gocheck.Equals, s.numNodes - 1)
+	c.Check(replicas[0].GetId(), gocheck.Equals, s.nodes[3].id)
+	c.Check(s.nodeMap[replicas[0].GetId()].dcID, gocheck.Equals, s.leader.dcID)
+
+	// a quorum of all the replicas is required
+	replicaSets, err := s.manager.getInstanceReplicaSets(instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(replicaSets), gocheck.Equals, 1)
+	c.Check(len(replicaSets[0]), gocheck.Equals, s.numNodes)
+	quorum, err := s.manager.newInstanceQuorumTracker(instance)
+	c.Assert(err, gocheck.IsNil)
+	quorum.add(s.nodes[3].id)
+	c.Check(quorum.satisfied(), gocheck.Equals, false)
+	quorum.add(s.nodes[1].id)
+	c.Check(quorum.satisfied(), gocheck.Equals, true)
+}
+
+// tests that local consensus instances only use the
+// replicas in the local datacenter
+func (s *ManagerConsistencyTest) TestLocalConsensusReplicas(c *gocheck.C) {
+	instance := s.manager.makeInstance(CONSISTENCY_CONSENSUS_LOCAL, getBasicInstruction())
+	c.Check(instance.Consistency, gocheck.Equals, CONSISTENCY_CONSENSUS_LOCAL)
+	c.Check(len(instance.Successors), gocheck.Equals, 1)
+
+	replicas, err := s.manager.getInstanceReplicas(instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(replicas), gocheck.Equals, 1)
+	c.Check(replicas[0].GetId(), gocheck.Equals, s.nodes[3].id)
+
+	replicaSets, err := s.manager.getInstanceReplicaSets(instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(replicaSets), gocheck.Equals, 1)
+	c.Check(len(replicaSets[0]), gocheck.Equals, 2)
+}
+
+// tests that consensus instances have longer timeouts
+func (s *ManagerConsistencyTest) TestConsistencyTimeouts(c *gocheck.C) {
+	local := getConsistencyTimeout(CONSISTENCY_CONSENSUS_LOCAL, PREACCEPT_TIMEOUT)
+	c.Check(local, gocheck.Equals, time.Duration(PREACCEPT_TIMEOUT) * time.Millisecond)
+
+	global := getConsistencyTimeout(CONSISTENCY_CONSENSUS, PREACCEPT_TIMEOUT)
+	c.Check(global, gocheck.Equals, local * time.Duration(CONSENSUS_TIMEOUT_MULTIPLIER))
+}
+
+// tests that queries are committed by the replicas in every datacenter
+func (s *ManagerConsistencyTest) TestExecuteQuery(c *gocheck.C) {
+	val, err := s.manager.ExecuteQuery(store.NewInstruction("set", "a", []string{"1"}, time.Now()), s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(val.(*intVal).value, gocheck.Equals, 1)
+
+	iids := s.manager.instances.InstanceIDs()
+	c.Assert(len(iids), gocheck.Equals, 1)
+
+	// commit messages are sent asynchronously
+	committed := func(n *mockNode) bool {
+		instance := n.manager.instances.Get(iids[0])
+		return instance != nil && instance.getStatus() >= INSTANCE_COMMITTED
+	}
+	for _, n := range s.replicas {
+		for i := 0; i < 100 && !committed(n); i++ {
+			time.Sleep(time.Millisecond)
+		}
+		c.Assert(committed(n), gocheck.Equals, true, gocheck.Commentf("%v in %v", n.id, n.dcID))
+		c.Check(n.manager.instances.Get(iids[0]).Consistency, gocheck.Equals, CONSISTENCY_CONSENSUS)
+	}
+}
+
 type ManagerExecuteQueryTest struct {
 	baseManagerTest
 }
@@ -206,3 +325,250 @@ func (s *ManagerExecuteQueryTest) TestAcceptSuccess(c *gocheck.C) {
 func (s *ManagerExecuteQueryTest) TestAcceptBallotFailure(c *gocheck.C) {
 
 }
+
+// tests that the instructions in a transaction are executed
+// together, and that an error in one doesn't prevent the others
+func (s *ManagerExecuteQueryTest) TestTransaction(c *gocheck.C) {
+	vals, errs, err := s.manager.ExecuteTransaction([]store.Instruction{
+		store.NewInstruction("set", "a", []string{"1"}, time.Now()),
+		store.NewInstruction("set", "b", []string{"x"}, time.Now()),
+		store.NewInstruction("set", "c", []string{"3"}, time.Now()),
+	}, nil, s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(vals), gocheck.Equals, 3)
+	c.Assert(len(errs), gocheck.Equals, 3)
+
+	c.Check(errs[0], gocheck.IsNil)
+	c.Check(vals[0].(*intVal).value, gocheck.Equals, 1)
+	c.Check(errs[1], gocheck.NotNil)
+	c.Check(vals[1], gocheck.IsNil)
+	c.Check(errs[2], gocheck.IsNil)
+	c.Check(vals[2].(*intVal).value, gocheck.Equals, 3)
+
+	mStore := s.manager.store.(*mockStore)
+	c.Check(mStore.values["a"].value, gocheck.Equals, 1)
+	c.Check(mStore.values["c"].value, gocheck.Equals, 3)
+	c.Check(s.manager.instances.Len(), gocheck.Equals, 1)
+}
+
+// tests that transactions are executed if their watched keys haven't
+// changed, and aborted without executing anything if they have
+func (s *ManagerExecuteQueryTest) TestTransactionWatch(c *gocheck.C) {
+	mStore := s.manager.store.(*mockStore)
+
+	watch, err := s.manager.WatchKey("a", s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(watch.Timestamp.IsZero(), gocheck.Equals, true)
+
+	vals, _, err := s.manager.ExecuteTransaction(
+		[]store.Instruction{store.NewInstruction("set", "a", []string{"1"}, time.Now())},
+		[]WatchedKey{watch},
+		s.consistency,
+	)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(vals[0].(*intVal).value, gocheck.Equals, 1)
+
+	// the key has changed since the first watch
+	vals, _, err = s.manager.ExecuteTransaction(
+		[]store.Instruction{store.NewInstruction("set", "b", []string{"2"}, time.Now())},
+		[]WatchedKey{watch},
+		s.consistency,
+	)
+	c.Check(vals, gocheck.IsNil)
+	_, aborted := err.(TransactionAbortedError)
+	c.Check(aborted, gocheck.Equals, true)
+	c.Check(mStore.values["b"], gocheck.IsNil)
+
+	watch, err = s.manager.WatchKey("a", s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(watch.Timestamp, gocheck.Equals, mStore.values["a"].time)
+	_, _, err = s.manager.ExecuteTransaction(
+		[]store.Instruction{store.NewInstruction("set", "b", []string{"3"}, time.Now())},
+		[]WatchedKey{watch},
+		s.consistency,
+	)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(mStore.values["b"].value, gocheck.Equals, 3)
+}
+
+// tests that transactions watching a key that didn't exist are aborted
+// if the key is written, then deleted, since they were watched
+func (s *ManagerExecuteQueryTest) TestTransactionWatchDeleted(c *gocheck.C) {
+	mStore := s.manager.store.(*mockStore)
+
+	watch, err := s.manager.WatchKey("a", s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(watch.Timestamp.IsZero(), gocheck.Equals, true)
+
+	ts := time.Now()
+	_, err = mStore.ExecuteInstruction(store.NewInstruction("set", "a", []string{"1"}, ts))
+	c.Assert(err, gocheck.IsNil)
+	_, err = mStore.ExecuteInstruction(store.NewInstruction("del", "a", []string{}, ts.Add(time.Second)))
+	c.Assert(err, gocheck.IsNil)
+
+	vals, _, err := s.manager.ExecuteTransaction(
+		[]store.Instruction{store.NewInstruction("set", "b", []string{"2"}, time.Now())},
+		[]WatchedKey{watch},
+		s.consistency,
+	)
+	c.Check(vals, gocheck.IsNil)
+	_, aborted := err.(TransactionAbortedError)
+	c.Check(aborted, gocheck.Equals, true)
+	c.Check(mStore.values["b"], gocheck.IsNil)
+
+	// watching the deleted key reports the timestamp of the delete
+	watch, err = s.manager.WatchKey("a", s.consistency)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(watch.Timestamp.Equal(ts.Add(time.Second)), gocheck.Equals, true)
+}
+
+func (s *ManagerExecuteQueryTest) TestEmptyTransaction(c *gocheck.C) {
+	_, _, err := s.manager.ExecuteTransaction([]store.Instruction{}, nil, s.consistency)
+	c.Check(err, gocheck.NotNil)
+}
+
+// tests executing instances whose keys
+// are owned by different replica sets
+type ManagerMultiKeyTest struct {
+	manager *Manager
+	nodes []*mockNode
+
+	// a key replicated by the local node, and one that isn't
+	localKey string
+	remoteKey string
+}
+
+var _ = gocheck.Suite(&ManagerMultiKeyTest{})
+
+func (s *ManagerMultiKeyTest) SetUpTest(c *gocheck.C) {
+	s.nodes = make([]*mockNode, 4)
+	for i := range s.nodes {
+		s.nodes[i] = newMockNode()
+		s.nodes[i].token = partitioner.Token([]byte{byte(i * 64)})
+	}
+	t := topology.NewTopology(s.nodes[0].id, s.nodes[0].dcID, partitioner.NewMD5Partitioner(), 2)
+	for _, n := range s.nodes {
+		c.Assert(t.AddNode(n), gocheck.IsNil)
+	}
+	s.manager = NewManager(t, newMockStore())
+	s.manager.stats = newMockStatter()
+	s.nodes[0].manager = s.manager
+
+	s.localKey = ""
+	s.remoteKey = ""
+	for i:=0; s.localKey == "" || s.remoteKey == ""; i++ {
+		key := fmt.Sprintf("key%v", i)
+		if s.manager.checkLocalKeyEligibility(key) {
+			s.localKey = key
+		} else {
+			s.remoteKey = key
+		}
+	}
+}
+
+func (s *ManagerMultiKeyTest) instruction(key string, val int) store.Instruction {
+	return store.NewInstruction("set", key, []string{fmt.Sprint(val)}, time.Now())
+}
+
+func (s *ManagerMultiKeyTest) TestInstanceReplicas(c *gocheck.C) {
+	instance := s.manager.makeInstance(CONSISTENCY_CONSENSUS_LOCAL, s.instruction(s.localKey, 1), s.instruction(s.remoteKey, 2))
+
+	replicaSets, err := s.manager.getInstanceReplicaSets(instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(replicaSets), gocheck.Equals, 2)
+	expected := make(map[node.NodeId]bool)
+	for _, replicaSet := range replicaSets {
+		c.Check(len(replicaSet), gocheck.Equals, 2)
+		for _, nid := range replicaSet {
+			expected[nid] = true
+		}
+	}
+
+	nodes, err := s.manager.getInstanceNodes(instance)
+	c.Assert(err, gocheck.IsNil)
+	actual := make(map[node.NodeId]bool)
+	for _, n := range nodes {
+		actual[n.GetId()] = true
+	}
+	c.Check(len(nodes), gocheck.Equals, len(expected))
+	c.Check(actual, gocheck.DeepEquals, expected)
+	replicas, err := s.manager.getInstanceReplicas(instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(len(replicas), gocheck.Equals, len(expected) - 1)
+	c.Check(len(instance.Successors), gocheck.Equals, len(expected) - 1)
+
+	spans, err := s.manager.instanceSpansReplicaSets(instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(spans, gocheck.Equals, true)
+	c.Check(s.manager.checkLocalInstanceEligibility(instance), gocheck.Equals, true)
+
+	remote := s.manager.makeInstance(CONSISTENCY_CONSENSUS_LOCAL, s.instruction(s.remoteKey, 1))
+	spans, err = s.manager.instanceSpansReplicaSets(remote)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(spans, gocheck.Equals, false)
+	c.Check(s.manager.checkLocalInstanceEligibility(remote), gocheck.Equals, false)
+}
+
+// tests that only the instructions on locally replicated keys are applied
+func (s *ManagerMultiKeyTest) TestApplySkipsRemoteKeys(c *gocheck.C) {
+	instance := s.manager.makeInstance(CONSISTENCY_CONSENSUS_LOCAL, s.instruction(s.localKey, 1), s.instruction(s.remoteKey, 2))
+	instance.Status = INSTANCE_COMMITTED
+	resultListener := instance.addListener()
+
+	vals, err := s.manager.applyInstance(instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(vals), gocheck.Equals, 2)
+	c.Check(vals[0].(*intVal).value, gocheck.Equals, 1)
+	c.Check(vals[1], gocheck.IsNil)
+
+	// the skipped instruction isn't reported as a success
+	result := <-resultListener
+	c.Check(result.errs[0], gocheck.IsNil)
+	c.Check(result.errs[1], gocheck.FitsTypeOf, NotReplicatedError{})
+
+	mStore := s.manager.store.(*mockStore)
+	c.Check(len(mStore.instructions), gocheck.Equals, 1)
+	c.Check(mStore.values[s.remoteKey], gocheck.IsNil)
+	c.Check(instance.Status, gocheck.Equals, INSTANCE_EXECUTED)
+}
+
+// tests that queries on keys the local node doesn't
+// replicate are rejected, instead of being led locally
+func (s *ManagerMultiKeyTest) TestQueryRemoteKey(c *gocheck.C) {
+	_, err := s.manager.ExecuteQuery(s.instruction(s.remoteKey, 1), CONSISTENCY_CONSENSUS_LOCAL)
+	c.Check(err, gocheck.FitsTypeOf, NotReplicatedError{})
+
+	_, _, err = s.manager.ExecuteTransaction(
+		[]store.Instruction{s.instruction(s.remoteKey, 1)},
+		nil,
+		CONSISTENCY_CONSENSUS_LOCAL,
+	)
+	c.Check(err, gocheck.FitsTypeOf, NotReplicatedError{})
+	c.Check(s.manager.instances.Len(), gocheck.Equals, 0)
+}
+
+// tests that transactions can't include keys the local
+// node doesn't replicate, since it couldn't return their results
+func (s *ManagerMultiKeyTest) TestTransactionRemoteKey(c *gocheck.C) {
+	_, _, err := s.manager.ExecuteTransaction([]store.Instruction{
+		s.instruction(s.localKey, 1),
+		s.instruction(s.remoteKey, 2),
+	}, nil, CONSISTENCY_CONSENSUS_LOCAL)
+	c.Check(err, gocheck.FitsTypeOf, NotReplicatedError{})
+	c.Check(s.manager.instances.Len(), gocheck.Equals, 0)
+}
+
+// tests that keys the local node doesn't replicate can't be watched, and that
+// watched keys must be replicated by all of the transaction's replicas
+func (s *ManagerMultiKeyTest) TestWatchRemoteKey(c *gocheck.C) {
+	_, err := s.manager.WatchKey(s.remoteKey, CONSISTENCY_CONSENSUS_LOCAL)
+	c.Check(err, gocheck.NotNil)
+
+	_, _, err = s.manager.ExecuteTransaction(
+		[]store.Instruction{s.instruction(s.localKey, 1)},
+		[]WatchedKey{WatchedKey{Key: s.remoteKey}},
+		CONSISTENCY_CONSENSUS_LOCAL,
+	)
+	c.Check(err, gocheck.NotNil)
+	c.Check(s.manager.instances.Len(), gocheck.Equals, 0)
+}
@@ -3,10 +3,14 @@ package consensus
 import (
 	"bufio"
 	"encoding/binary"
+	"time"
 )
 
 import (
 	"message"
+	"node"
+	"serializer"
+	"store"
 	"types"
 )
 
@@ -33,8 +37,34 @@ const (
 
 	MESSAGE_INSTANCE_REQUEST = uint32(1011)
 	MESSAGE_INSTANCE_RESPONSE = uint32(1012)
+
+	MESSAGE_READ_REQUEST = uint32(1013)
+	MESSAGE_READ_RESPONSE = uint32(1014)
 )
 
+func numInstanceIDBytes(iids []InstanceID) int {
+	return 4 + (types.UUID_NUM_BYTES * len(iids))
+}
+
+func serializeInstanceIDs(buf *bufio.Writer, iids []InstanceID) error {
+	numIds := uint32(len(iids))
+	if err := binary.Write(buf, binary.LittleEndian, &numIds); err != nil { return err }
+	for i := range iids {
+		if err := (&iids[i]).WriteBuffer(buf); err != nil { return err }
+	}
+	return nil
+}
+
+func deserializeInstanceIDs(buf *bufio.Reader) ([]InstanceID, error) {
+	var numIds uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numIds); err != nil { return nil, err }
+	iids := make([]InstanceID, numIds)
+	for i := range iids {
+		if err := (&iids[i]).ReadBuffer(buf); err != nil { return nil, err }
+	}
+	return iids, nil
+}
+
 type PreAcceptRequest struct {
 	Instance *Instance
 }
@@ -76,6 +106,11 @@ type PreAcceptResponse struct {
 	// if the command leader seems to be missing
 	// instances in it's deps, return them here
 	MissingInstances []*Instance
+
+	// ids of the instances in the leader's deps that
+	// the replica has never seen. The leader sends
+	// them to the replica once it receives the reply
+	UnknownInstances []InstanceID
 }
 
 var _ = &PreAcceptResponse{}
@@ -101,6 +136,9 @@ func (m *PreAcceptResponse) NumBytes() int {
 		numBytes += inst.NumBytesLimited()
 	}
 
+	// unknown instances
+	numBytes += numInstanceIDBytes(m.UnknownInstances)
+
 	return numBytes
 }
 
@@ -117,6 +155,8 @@ func (m *PreAcceptResponse) Serialize(buf *bufio.Writer) error   {
 	for _, inst := range m.MissingInstances {
 		if err := inst.SerializeLimited(buf); err != nil { return err }
 	}
+
+	if err := serializeInstanceIDs(buf, m.UnknownInstances); err != nil { return err }
 	return nil
 }
 
@@ -134,6 +174,12 @@ func (m *PreAcceptResponse) Deserialize(buf *bufio.Reader) error {
 		m.MissingInstances[i] = &Instance{}
 		if err := m.MissingInstances[i].DeserializeLimited(buf); err != nil { return err }
 	}
+
+	if iids, err := deserializeInstanceIDs(buf); err != nil {
+		return err
+	} else {
+		m.UnknownInstances = iids
+	}
 	return nil
 }
 
@@ -199,6 +245,10 @@ type AcceptResponse struct {
 	// the highest ballot that's been seen
 	// for this instance
 	MaxBallot uint32
+
+	// ids of the instances in the leader's deps
+	// that the replica has never seen
+	UnknownInstances []InstanceID
 }
 
 func (m *AcceptResponse) NumBytes() int {
@@ -210,6 +260,9 @@ func (m *AcceptResponse) NumBytes() int {
 	// ballot
 	numBytes += 4
 
+	// unknown instances
+	numBytes += numInstanceIDBytes(m.UnknownInstances)
+
 	return numBytes
 }
 
@@ -223,6 +276,7 @@ func (m *AcceptResponse) Serialize(buf *bufio.Writer) error   {
 	if m.Accepted { accepted = 0xff }
 	if err := binary.Write(buf, binary.LittleEndian, &accepted); err != nil { return err }
 	if err := binary.Write(buf, binary.LittleEndian, &m.MaxBallot); err != nil { return err }
+	if err := serializeInstanceIDs(buf, m.UnknownInstances); err != nil { return err }
 	return nil
 }
 
@@ -231,6 +285,11 @@ func (m *AcceptResponse) Deserialize(buf *bufio.Reader) error {
 	if err := binary.Read(buf, binary.LittleEndian, &accepted); err != nil { return err }
 	m.Accepted = accepted != 0x0
 	if err := binary.Read(buf, binary.LittleEndian, &m.MaxBallot); err != nil { return err }
+	if iids, err := deserializeInstanceIDs(buf); err != nil {
+		return err
+	} else {
+		m.UnknownInstances = iids
+	}
 	return nil
 }
 
@@ -264,10 +323,14 @@ func (m *CommitRequest) Deserialize(buf *bufio.Reader) error {
 	return nil
 }
 
-type CommitResponse struct {}
+type CommitResponse struct {
+	// ids of the instances in the leader's deps
+	// that the replica has never seen
+	UnknownInstances []InstanceID
+}
 
 func (m *CommitResponse) NumBytes() int {
-	return 0
+	return numInstanceIDBytes(m.UnknownInstances)
 }
 
 var _ = &CommitResponse{}
@@ -275,10 +338,16 @@ var _ = &CommitResponse{}
 func (m *CommitResponse) GetType() uint32 { return MESSAGE_COMMIT_RESPONSE }
 
 func (m *CommitResponse) Serialize(buf *bufio.Writer) error   {
+	if err := serializeInstanceIDs(buf, m.UnknownInstances); err != nil { return err }
 	return nil
 }
 
 func (m *CommitResponse) Deserialize(buf *bufio.Reader) error {
+	if iids, err := deserializeInstanceIDs(buf); err != nil {
+		return err
+	} else {
+		m.UnknownInstances = iids
+	}
 	return nil
 }
 
@@ -321,6 +390,10 @@ type PrepareResponse struct {
 	// preaccept due to an out of date ballot
 	Accepted bool
 
+	// the node that handled the request, so the
+	// prepare phase can tell the leader's vote apart
+	NodeID node.NodeId
+
 	Instance *Instance
 }
 
@@ -334,6 +407,9 @@ func (m *PrepareResponse) NumBytes() int {
 	// accepted
 	numBytes += 1
 
+	// node id
+	numBytes += types.UUID_NUM_BYTES
+
 	// instance exists
 	numBytes += 1
 
@@ -350,6 +426,7 @@ func (m *PrepareResponse) Serialize(buf *bufio.Writer) error   {
 	var accepted byte
 	if m.Accepted { accepted = 0xff }
 	if err := binary.Write(buf, binary.LittleEndian, &accepted); err != nil { return err }
+	if err := (&m.NodeID).WriteBuffer(buf); err != nil { return err }
 
 	var isNil byte
 	if m.Instance == nil { isNil = 0xff }
@@ -364,6 +441,7 @@ func (m *PrepareResponse) Deserialize(buf *bufio.Reader) error {
 	var accepted byte
 	if err := binary.Read(buf, binary.LittleEndian, &accepted); err != nil { return err }
 	m.Accepted = accepted != 0x0
+	if err := (&m.NodeID).ReadBuffer(buf); err != nil { return err }
 
 	var isNil byte
 	if err := binary.Read(buf, binary.LittleEndian, &isNil); err != nil { return err }
@@ -445,8 +523,12 @@ func (m *PrepareSuccessorResponse) Deserialize(buf *bufio.Reader) error {
 	return nil
 }
 
+// asks a node for the instances with the given ids. Instances the sender
+// knows the receiver is missing, like the ones a replica reported as
+// unknown in it's reply to a leader, can be sent with the request
 type InstanceRequest struct {
 	InstanceIDs []InstanceID
+	Instances []*Instance
 }
 
 var _ = &InstanceRequest{}
@@ -463,6 +545,14 @@ func (m *InstanceRequest) NumBytes() int {
 	// ids
 	numBytes += types.UUID_NUM_BYTES * len(m.InstanceIDs)
 
+	// num instances
+	numBytes += 4
+
+	// instances
+	for _, inst := range m.Instances {
+		numBytes += inst.NumBytesLimited()
+	}
+
 	return numBytes
 }
 
@@ -474,6 +564,12 @@ func (m *InstanceRequest) Serialize(buf *bufio.Writer) error   {
 	for i := range m.InstanceIDs {
 		if err := (&m.InstanceIDs[i]).WriteBuffer(buf); err != nil { return err }
 	}
+
+	numInst := uint32(len(m.Instances))
+	if err := binary.Write(buf, binary.LittleEndian, &numInst); err != nil { return err }
+	for _, inst := range m.Instances {
+		if err := inst.SerializeLimited(buf); err != nil { return err }
+	}
 	return nil
 }
 
@@ -484,9 +580,18 @@ func (m *InstanceRequest) Deserialize(buf *bufio.Reader) error {
 	for i := range m.InstanceIDs {
 		if err := (&m.InstanceIDs[i]).ReadBuffer(buf); err != nil { return err }
 	}
+
+	var numInst uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numInst); err != nil { return err }
+	m.Instances = make([]*Instance, numInst)
+	for i := range m.Instances {
+		m.Instances[i] = &Instance{}
+		if err := m.Instances[i].DeserializeLimited(buf); err != nil { return err }
+	}
 	return nil
 }
 
+// returns the requested instances the receiver knows about
 type InstanceResponse struct {
 	Instances []*Instance
 }
@@ -529,6 +634,74 @@ func (m *InstanceResponse) Deserialize(buf *bufio.Reader) error {
 	return nil
 }
 
+// asks a replica for the state of the key
+// read by the given read only instruction
+type ReadRequest struct {
+	Instruction store.Instruction
+}
+
+var _ = &ReadRequest{}
+
+func (m *ReadRequest) GetType() uint32 { return MESSAGE_READ_REQUEST }
+
+func (m *ReadRequest) NumBytes() int {
+	return m.Instruction.NumBytes()
+}
+
+func (m *ReadRequest) Serialize(buf *bufio.Writer) error   {
+	if err := m.Instruction.Serialize(buf); err != nil { return err }
+	return nil
+}
+
+func (m *ReadRequest) Deserialize(buf *bufio.Reader) error {
+	if err := m.Instruction.Deserialize(buf); err != nil { return err }
+	return nil
+}
+
+type ReadResponse struct {
+	// the timestamp of the replica's last write to the key,
+	// including deletes, zero if it doesn't exist
+	Timestamp time.Time
+
+	// the ids of the writes interfering with the
+	// key that the replica hasn't executed yet
+	InFlight []InstanceID
+}
+
+var _ = &ReadResponse{}
+
+func (m *ReadResponse) GetType() uint32 { return MESSAGE_READ_RESPONSE }
+
+func (m *ReadResponse) NumBytes() int {
+	var numBytes int
+
+	// timestamp
+	numBytes += serializer.NumTimeBytes()
+
+	// in flight instances
+	numBytes += numInstanceIDBytes(m.InFlight)
+
+	return numBytes
+}
+
+func (m *ReadResponse) Serialize(buf *bufio.Writer) error   {
+	if err := serializer.WriteTime(buf, m.Timestamp); err != nil { return err }
+	if err := serializeInstanceIDs(buf, m.InFlight); err != nil { return err }
+	return nil
+}
+
+func (m *ReadResponse) Deserialize(buf *bufio.Reader) error {
+	if val, err := serializer.ReadTime(buf); err != nil { return err } else {
+		m.Timestamp = val
+	}
+	if iids, err := deserializeInstanceIDs(buf); err != nil {
+		return err
+	} else {
+		m.InFlight = iids
+	}
+	return nil
+}
+
 func init() {
 	message.RegisterMessage(MESSAGE_PREACCEPT_REQUEST, func() message.Message { return &PreAcceptRequest{} })
 	message.RegisterMessage(MESSAGE_PREACCEPT_RESPONSE, func() message.Message { return &PreAcceptResponse{} })
@@ -547,4 +720,7 @@ func init() {
 
 	message.RegisterMessage(MESSAGE_INSTANCE_REQUEST, func() message.Message { return &InstanceRequest{} })
 	message.RegisterMessage(MESSAGE_INSTANCE_RESPONSE, func() message.Message { return &InstanceResponse{} })
+
+	message.RegisterMessage(MESSAGE_READ_REQUEST, func() message.Message { return &ReadRequest{} })
+	message.RegisterMessage(MESSAGE_READ_RESPONSE, func() message.Message { return &ReadResponse{} })
 }
@@ -2,6 +2,7 @@ package consensus
 
 import (
 	"bytes"
+	"time"
 )
 
 import (
@@ -46,6 +47,7 @@ func (s *ConsensusMessageTest) TestPreAcceptResponse(c *gocheck.C) {
 			makeInstance(node.NewNodeId(), makeDependencies(3)),
 			makeInstance(node.NewNodeId(), makeDependencies(3)),
 		},
+		UnknownInstances: makeDependencies(2),
 	}
 
 	err = message.WriteMessage(buf, src)
@@ -87,6 +89,7 @@ func (s *ConsensusMessageTest) TestAcceptResponse(c *gocheck.C) {
 	src := &AcceptResponse{
 		Accepted: true,
 		MaxBallot: uint32(6),
+		UnknownInstances: makeDependencies(2),
 	}
 
 	err = message.WriteMessage(buf, src)
@@ -121,7 +124,9 @@ func (s *ConsensusMessageTest) TestCommitRequest(c *gocheck.C) {
 func (s *ConsensusMessageTest) TestCommitResponse(c *gocheck.C) {
 	var err error
 	buf := &bytes.Buffer{}
-	src := &CommitResponse{}
+	src := &CommitResponse{
+		UnknownInstances: makeDependencies(2),
+	}
 
 	err = message.WriteMessage(buf, src)
 	c.Assert(err, gocheck.IsNil)
@@ -158,6 +163,7 @@ func (s *ConsensusMessageTest) TestPrepareResponse(c *gocheck.C) {
 	buf := &bytes.Buffer{}
 	src := &PrepareResponse{
 		Accepted: true,
+		NodeID: node.NewNodeId(),
 		Instance: makeInstance(node.NewNodeId(), makeDependencies(3)),
 	}
 
@@ -177,6 +183,7 @@ func (s *ConsensusMessageTest) TestPrepareResponseNoInstance(c *gocheck.C) {
 	buf := &bytes.Buffer{}
 	src := &PrepareResponse{
 		Accepted: true,
+		NodeID: node.NewNodeId(),
 		Instance: nil,
 	}
 
@@ -250,6 +257,7 @@ func (s *ConsensusMessageTest) TestInstanceRequest(c *gocheck.C) {
 	buf := &bytes.Buffer{}
 	src := &InstanceRequest{
 		InstanceIDs: makeDependencies(4),
+		Instances: []*Instance{},
 	}
 
 	err = message.WriteMessage(buf, src)
@@ -263,6 +271,30 @@ func (s *ConsensusMessageTest) TestInstanceRequest(c *gocheck.C) {
 	c.Check(dst, gocheck.DeepEquals, src)
 }
 
+// tests that instances sent with an instance request are serialized
+func (s *ConsensusMessageTest) TestInstanceRequestInstances(c *gocheck.C) {
+	var err error
+	buf := &bytes.Buffer{}
+	src := &InstanceRequest{
+		InstanceIDs: []InstanceID{},
+		Instances: []*Instance{
+			makeInstance(node.NewNodeId(), makeDependencies(3)),
+			makeInstance(node.NewNodeId(), makeDependencies(3)),
+		},
+	}
+
+	err = message.WriteMessage(buf, src)
+	c.Assert(err, gocheck.IsNil)
+
+	// test num bytes
+	c.Check(len(buf.Bytes()), gocheck.Equals, src.NumBytes() + message.MESSAGE_HEADER_SIZE)
+
+	dst, err := message.ReadMessage(buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(dst.(*InstanceRequest).Instances[0].InstanceID, gocheck.Equals, src.Instances[0].InstanceID)
+	c.Check(len(dst.(*InstanceRequest).Instances), gocheck.Equals, 2)
+}
+
 func (s *ConsensusMessageTest) TestInstanceResponse(c *gocheck.C) {
 	var err error
 	buf := &bytes.Buffer{}
@@ -283,3 +315,45 @@ func (s *ConsensusMessageTest) TestInstanceResponse(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Check(dst, gocheck.DeepEquals, src)
 }
+
+func (s *ConsensusMessageTest) TestReadRequest(c *gocheck.C) {
+	var err error
+	buf := &bytes.Buffer{}
+	src := &ReadRequest{
+		Instruction: getBasicInstruction(),
+	}
+
+	err = message.WriteMessage(buf, src)
+	c.Assert(err, gocheck.IsNil)
+
+	// test num bytes
+	c.Check(len(buf.Bytes()), gocheck.Equals, src.NumBytes() + message.MESSAGE_HEADER_SIZE)
+
+	dst, err := message.ReadMessage(buf)
+	c.Assert(err, gocheck.IsNil)
+	instruction := dst.(*ReadRequest).Instruction
+	c.Check(instruction.Cmd, gocheck.Equals, src.Instruction.Cmd)
+	c.Check(instruction.Key, gocheck.Equals, src.Instruction.Key)
+	c.Check(instruction.Args, gocheck.DeepEquals, src.Instruction.Args)
+	c.Check(instruction.Timestamp.Equal(src.Instruction.Timestamp), gocheck.Equals, true)
+}
+
+func (s *ConsensusMessageTest) TestReadResponse(c *gocheck.C) {
+	var err error
+	buf := &bytes.Buffer{}
+	src := &ReadResponse{
+		Timestamp: time.Now(),
+		InFlight: makeDependencies(2),
+	}
+
+	err = message.WriteMessage(buf, src)
+	c.Assert(err, gocheck.IsNil)
+
+	// test num bytes
+	c.Check(len(buf.Bytes()), gocheck.Equals, src.NumBytes() + message.MESSAGE_HEADER_SIZE)
+
+	dst, err := message.ReadMessage(buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(dst.(*ReadResponse).Timestamp.Equal(src.Timestamp), gocheck.Equals, true)
+	c.Check(dst.(*ReadResponse).InFlight, gocheck.DeepEquals, src.InFlight)
+}
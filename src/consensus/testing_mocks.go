@@ -25,6 +25,7 @@ import (
 type intVal struct {
 	value int
 	time time.Time
+	deleted bool
 }
 
 func newIntVal(val int, ts time.Time) *intVal {
@@ -99,8 +100,7 @@ func newMockNode() *mockNode {
 
 func (n *mockNode) GetId() node.NodeId { return n.id }
 
-func (n *mockNode) ExecuteQuery(cmd string, key string, args []string, timestamp time.Time) (store.Value, error) {
-	store.NewInstruction(cmd, key, args, timestamp)
+func (n *mockNode) ExecuteQuery(instruction store.Instruction) (store.Value, error) {
 	return nil, nil
 }
 
@@ -298,6 +298,12 @@ func (s *mockStore) Stop() error { return nil }
 func (s *mockStore) ExecuteInstruction(instruction store.Instruction) (store.Value, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	if instruction.Cmd == "del" {
+		val := &intVal{time:instruction.Timestamp, deleted:true}
+		s.values[instruction.Key] = val
+		s.instructions = append(s.instructions, instruction)
+		return val, nil
+	}
 	intVal, err := strconv.Atoi(instruction.Args[0])
 	if err != nil { return nil, err }
 	val := newIntVal(intVal, instruction.Timestamp)
@@ -330,11 +336,41 @@ func (s *mockStore) IsWriteOnly(instruction store.Instruction) bool {
 	return mockStoreDefaultIsWriteOnly(s, instruction)
 }
 
+func (s *mockStore) GetRawKey(key string) (store.Value, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	val, exists := s.values[key]
+	if !exists {
+		return nil, fmt.Errorf("key [%v] does not exist", key)
+	}
+	return val, nil
+}
+
+func (s *mockStore) IsDeleted(val store.Value) bool {
+	if v, ok := val.(*intVal); ok {
+		return v.deleted
+	}
+	return false
+}
+
+func (s *mockStore) RequiresConsensus(instruction store.Instruction) bool {
+	return false
+}
+
+func (s *mockStore) LeaderInstruction(instruction store.Instruction, leader string) (store.Instruction, bool) {
+	return instruction, false
+}
+
+func (s *mockStore) ReplicationInstruction(instruction store.Instruction, leader string, result store.Value) (store.Instruction, error) {
+	return instruction, nil
+}
+
 // not implemented
+func (s *mockStore) SplitMultiKey(cmd string, args []string, timestamp time.Time) ([]store.Instruction, bool, error) { panic("not implemented") }
 func (s *mockStore) Reconcile(key string, values []store.Value) (store.Value, [][]store.Instruction, error) { panic("not implemented") }
 func (s *mockStore) SerializeValue(v store.Value) ([]byte, error) { panic("not implemented") }
 func (s *mockStore) DeserializeValue(b []byte) (store.Value, store.ValueType, error) { panic("not implemented") }
-func (s *mockStore) GetRawKey(key string) (store.Value, error) { panic("not implemented") }
 func (s *mockStore) SetRawKey(key string, val store.Value) error { panic("not implemented") }
 func (s *mockStore) GetKeys() []string { panic("not implemented") }
+func (s *mockStore) IterateKeys(p partitioner.Partitioner, start partitioner.Token) store.Iterator { panic("not implemented") }
 func (s *mockStore) KeyExists(key string) bool { panic("not implemented") }
@@ -25,7 +25,7 @@ func setupDeps(manager *Manager) {
 	seq := uint64(0)
 	for i := 0; i < 4; i++ {
 		seq++
-		instance := manager.makeInstance(getBasicInstruction())
+		instance := manager.makeInstance(CONSISTENCY_CONSENSUS_LOCAL, getBasicInstruction())
 		instance.Status = INSTANCE_EXECUTED
 		instance.Dependencies, _ = manager.getInstanceDeps(instance)
 		manager.instances.Add(instance)
@@ -33,14 +33,14 @@ func setupDeps(manager *Manager) {
 	}
 	for i := 0; i < 4; i++ {
 		seq++
-		instance := manager.makeInstance(getBasicInstruction())
+		instance := manager.makeInstance(CONSISTENCY_CONSENSUS_LOCAL, getBasicInstruction())
 		instance.Status = INSTANCE_COMMITTED
 		instance.Dependencies, _ = manager.getInstanceDeps(instance)
 		manager.instances.Add(instance)
 	}
 	for i := 0; i < 4; i++ {
 		seq++
-		instance := manager.makeInstance(getBasicInstruction())
+		instance := manager.makeInstance(CONSISTENCY_CONSENSUS_LOCAL, getBasicInstruction())
 		if i > 1 {
 			instance.Status = INSTANCE_ACCEPTED
 		} else {
@@ -51,16 +51,17 @@ func setupDeps(manager *Manager) {
 	}
 }
 
+// returns a topology containing only the local node, so
+// it replicates every key, and has no other replicas
+func setupLocalTopology() *topology.Topology {
+	n := newMockNode()
+	t := topology.NewTopology(n.id, n.dcID, partitioner.NewMD5Partitioner(), 3)
+	t.AddNode(n)
+	return t
+}
+
 func setupEmptyManager() *Manager {
-	return NewManager(
-		topology.NewTopology(
-			node.NewNodeId(),
-			topology.DatacenterID("DC1"),
-			partitioner.NewMD5Partitioner(),
-			3,
-		),
-		newMockStore(),
-	)
+	return NewManager(setupLocalTopology(), newMockStore())
 }
 func setupManager() *Manager {
 	manager := setupEmptyManager()
@@ -70,14 +71,20 @@ func setupManager() *Manager {
 
 // returns a set of mock nodes of the given size
 func setupReplicaSet(size int) []*mockNode {
+	return setupDatacenterReplicaSet(size, 1)
+}
+
+// returns a set of mock nodes of the given size, spread across the
+// given number of datacenters. Every node in a datacenter replicates
+// every key
+func setupDatacenterReplicaSet(size int, numDCs int) []*mockNode {
 	replicas := make([]*mockNode, size)
 	for i := 0; i < size; i++ {
 		replicas[i] = newMockNode()
+		replicas[i].dcID = topology.DatacenterID(fmt.Sprintf("DC%v", (i % numDCs) + 1))
+		replicas[i].manager.dcID = replicas[i].dcID
 	}
-	nodes := make([]node.Node, size)
-	for i:=0; i<size; i++ {
-		nodes[i] = replicas[i]
-	}
+	replicationFactor := (size + numDCs - 1) / numDCs
 
 	for _, n1 := range replicas {
 		// TODO: make the replication factor a mock node constructor param?
@@ -85,7 +92,7 @@ func setupReplicaSet(size int) []*mockNode {
 			n1.id,
 			n1.dcID,
 			partitioner.NewMD5Partitioner(),
-			uint(size),
+			uint(replicationFactor),
 		)
 		for _, n2 := range replicas {
 			n1.manager.topology.AddNode(n2)
@@ -117,7 +124,7 @@ func makeInstance(nid node.NodeId, deps []InstanceID) *Instance {
 	instance := &Instance{
 		InstanceID:   NewInstanceID(),
 		LeaderID:     nid,
-		Command:      getBasicInstruction(),
+		Commands:     []store.Instruction{getBasicInstruction()},
 		Dependencies: deps,
 		Status:       INSTANCE_PREACCEPTED,
 		Successors:   make([]node.NodeId, 0),
@@ -127,6 +134,11 @@ func makeInstance(nid node.NodeId, deps []InstanceID) *Instance {
 
 type baseManagerTest struct {
 	manager *Manager
+
+	// the consistency level of the instances and queries the
+	// test creates. The replicas are spread across datacenters
+	// if it's CONSISTENCY_CONSENSUS
+	consistency ConsistencyLevel
 }
 
 func (s *baseManagerTest) getInstruction(val int) store.Instruction {
@@ -134,15 +146,7 @@ func (s *baseManagerTest) getInstruction(val int) store.Instruction {
 }
 
 func (s *baseManagerTest) SetUpTest(c *gocheck.C) {
-	s.manager = NewManager(
-		topology.NewTopology(
-			node.NewNodeId(),
-			topology.DatacenterID("DC1"),
-			partitioner.NewMD5Partitioner(),
-			3,
-		),
-		newMockStore(),
-	)
+	s.manager = NewManager(setupLocalTopology(), newMockStore())
 	s.manager.stats = newMockStatter()
 }
 
@@ -156,6 +160,10 @@ type baseReplicaTest struct {
 	nodeMap map[node.NodeId]*mockNode
 
 	numNodes int
+
+	// the nodes are spread across this many datacenters
+	// if the consistency level is CONSISTENCY_CONSENSUS
+	numDCs int
 }
 
 func (s *baseReplicaTest) quorumSize() int {
@@ -164,11 +172,16 @@ func (s *baseReplicaTest) quorumSize() int {
 
 func (s *baseReplicaTest) SetUpSuite(c *gocheck.C) {
 	s.numNodes = 5
+	s.numDCs = 3
 }
 
 func (s *baseReplicaTest) SetUpTest(c *gocheck.C) {
 	c.Assert(s.numNodes > 2, gocheck.Equals, true)
-	s.nodes = setupReplicaSet(s.numNodes)
+	if s.consistency == CONSISTENCY_CONSENSUS {
+		s.nodes = setupDatacenterReplicaSet(s.numNodes, s.numDCs)
+	} else {
+		s.nodes = setupReplicaSet(s.numNodes)
+	}
 	s.managers = make([]*Manager, s.numNodes)
 	s.replicaManagers = make([]*Manager, s.numNodes - 1)
 
@@ -0,0 +1,233 @@
+/*
+Hybrid logical clock
+
+Timestamps issued by the clock track physical time as closely as possible,
+but never move backwards, and advance past any timestamp observed from
+other nodes. This keeps causally related writes ordered correctly, even
+when the wall clocks of the nodes issuing them are skewed.
+
+http://www.cse.buffalo.edu/tech-reports/2014-04.pdf
+*/
+package hlc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+import (
+	"node"
+	"types"
+)
+
+const (
+	// the resolution of the physical component
+	// of timestamps issued by the clock
+	PHYSICAL_RESOLUTION = int64(time.Microsecond)
+
+	// the number of logical ticks that fit between
+	// two physical ticks when a timestamp is packed
+	// into a time.Time. The physical component is
+	// advanced when the logical component overflows
+	MAX_LOGICAL = uint32(PHYSICAL_RESOLUTION)
+
+	// the default maximum distance a remote timestamp's physical
+	// component can be ahead of the local physical clock
+	DEFAULT_MAX_OFFSET = time.Duration(500 * time.Millisecond)
+)
+
+// returned when a remote timestamp is too far ahead of the local clock
+type OffsetError string
+func (e OffsetError) Error() string { return string(e) }
+
+// a hybrid logical clock timestamp
+type Timestamp struct {
+	// physical component, in nanoseconds since the unix
+	// epoch, at the resolution of PHYSICAL_RESOLUTION
+	WallTime int64
+
+	// logical component, incremented when the physical
+	// component can't distinguish between events
+	Logical uint32
+
+	// the node that issued the timestamp, used to
+	// break ties between timestamps from different nodes
+	NodeId node.NodeId
+}
+
+// unpacks a time value into a timestamp, the time's
+// sub resolution nanoseconds become the logical component
+func FromTime(t time.Time) Timestamp {
+	if t.IsZero() {
+		return Timestamp{}
+	}
+	nanos := t.UnixNano()
+	return Timestamp{
+		WallTime: nanos - (nanos % PHYSICAL_RESOLUTION),
+		Logical: uint32(nanos % PHYSICAL_RESOLUTION),
+	}
+}
+
+func (t Timestamp) IsZero() bool {
+	return t.WallTime == 0 && t.Logical == 0
+}
+
+// packs the timestamp into a time value, ordering is preserved, except
+// for the node id, which is lost. Timestamps packed this way can be used
+// anywhere a time.Time is expected, like instruction and value timestamps
+func (t Timestamp) Time() time.Time {
+	if t.IsZero() {
+		return time.Time{}
+	}
+	return time.Unix(0, t.WallTime + int64(t.Logical))
+}
+
+// returns -1 if t is before o, 1 if t is after o,
+// and 0 if they're equal. Ties between the physical
+// and logical components are broken by the node id
+func (t Timestamp) Compare(o Timestamp) int {
+	switch {
+	case t.WallTime < o.WallTime:
+		return -1
+	case t.WallTime > o.WallTime:
+		return 1
+	case t.Logical < o.Logical:
+		return -1
+	case t.Logical > o.Logical:
+		return 1
+	}
+	return bytes.Compare(t.NodeId.Bytes(), o.NodeId.Bytes())
+}
+
+func (t Timestamp) Before(o Timestamp) bool {
+	return t.Compare(o) < 0
+}
+
+func (t Timestamp) After(o Timestamp) bool {
+	return t.Compare(o) > 0
+}
+
+// ----------- serialization -----------
+
+func WriteTimestamp(buf *bufio.Writer, t Timestamp) error {
+	if err := binary.Write(buf, binary.LittleEndian, &t.WallTime); err != nil { return err }
+	if err := binary.Write(buf, binary.LittleEndian, &t.Logical); err != nil { return err }
+	if err := (&t.NodeId).WriteBuffer(buf); err != nil { return err }
+	return nil
+}
+
+func ReadTimestamp(buf *bufio.Reader) (Timestamp, error) {
+	t := Timestamp{}
+	if err := binary.Read(buf, binary.LittleEndian, &t.WallTime); err != nil { return t, err }
+	if err := binary.Read(buf, binary.LittleEndian, &t.Logical); err != nil { return t, err }
+	if err := (&t.NodeId).ReadBuffer(buf); err != nil { return t, err }
+	return t, nil
+}
+
+func NumTimestampBytes() int {
+	// wall time + logical + node id
+	return 8 + 4 + types.UUID_NUM_BYTES
+}
+
+// ----------- clock -----------
+
+// returns the current physical time, in unix nanoseconds.
+// assigned to a var for testing
+var physicalTime = func() int64 {
+	return time.Now().UnixNano()
+}
+
+// a node's hybrid logical clock
+type Clock struct {
+	nodeId node.NodeId
+	last Timestamp
+	maxOffset int64
+	lock sync.Mutex
+}
+
+func NewClock(nid node.NodeId) *Clock {
+	return &Clock{nodeId: nid, last: Timestamp{NodeId: nid}, maxOffset: int64(DEFAULT_MAX_OFFSET)}
+}
+
+// sets the maximum distance a remote timestamp can be ahead
+// of the local physical clock. A max of 0 disables the check
+func (c *Clock) SetMaxOffset(max time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.maxOffset = int64(max)
+}
+
+// returns the current physical time at the clock's resolution
+func (c *Clock) physicalNow() int64 {
+	pt := physicalTime()
+	return pt - (pt % PHYSICAL_RESOLUTION)
+}
+
+// advances the logical component of the last timestamp,
+// carrying over into the physical component on overflow
+func (c *Clock) tickUnsafe() {
+	c.last.Logical++
+	if c.last.Logical >= MAX_LOGICAL {
+		c.last.WallTime += PHYSICAL_RESOLUTION
+		c.last.Logical = 0
+	}
+}
+
+// returns a new timestamp, greater than any
+// previously issued or observed timestamp
+func (c *Clock) Now() Timestamp {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if pt := c.physicalNow(); pt > c.last.WallTime {
+		c.last.WallTime = pt
+		c.last.Logical = 0
+	} else {
+		c.tickUnsafe()
+	}
+	return c.last
+}
+
+// advances the clock past a timestamp received from another
+// node, and returns a new timestamp greater than both.
+//
+// Timestamps more than the max offset ahead of the local physical
+// clock are rejected, otherwise a single node with a bad clock could
+// move every other node's clock forward for good. The clock isn't
+// advanced, and an OffsetError is returned
+func (c *Clock) Update(remote Timestamp) (Timestamp, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	pt := c.physicalNow()
+	if c.maxOffset > 0 && remote.WallTime - pt > c.maxOffset {
+		return Timestamp{}, OffsetError(fmt.Sprintf(
+			"Remote timestamp from node %v is %v ahead of the local clock, maximum offset is %v",
+			remote.NodeId,
+			time.Duration(remote.WallTime - pt),
+			time.Duration(c.maxOffset),
+		))
+	}
+
+	switch {
+	case pt > c.last.WallTime && pt > remote.WallTime:
+		c.last.WallTime = pt
+		c.last.Logical = 0
+	case remote.WallTime > c.last.WallTime:
+		c.last.WallTime = remote.WallTime
+		c.last.Logical = remote.Logical
+		c.tickUnsafe()
+	case c.last.WallTime > remote.WallTime:
+		c.tickUnsafe()
+	default:
+		if remote.Logical > c.last.Logical {
+			c.last.Logical = remote.Logical
+		}
+		c.tickUnsafe()
+	}
+	return c.last, nil
+}
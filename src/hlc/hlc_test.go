@@ -0,0 +1,206 @@
+package hlc
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+import (
+	"launchpad.net/gocheck"
+)
+
+import (
+	"node"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	gocheck.TestingT(t)
+}
+
+type ClockTest struct {
+	now int64
+	oldPhysicalTime func() int64
+}
+
+var _ = gocheck.Suite(&ClockTest{})
+
+// replaces the physical clock with one controlled by the test
+func (s *ClockTest) SetUpTest(c *gocheck.C) {
+	s.now = time.Now().UnixNano()
+	s.now -= s.now % PHYSICAL_RESOLUTION
+	s.oldPhysicalTime = physicalTime
+	physicalTime = func() int64 { return s.now }
+}
+
+func (s *ClockTest) TearDownTest(c *gocheck.C) {
+	physicalTime = s.oldPhysicalTime
+}
+
+// tests that the clock tracks physical time when it's advancing
+func (s *ClockTest) TestNowPhysical(c *gocheck.C) {
+	clock := NewClock(node.NewNodeId())
+	ts0 := clock.Now()
+	c.Check(ts0.WallTime, gocheck.Equals, s.now)
+	c.Check(ts0.Logical, gocheck.Equals, uint32(0))
+
+	s.now += PHYSICAL_RESOLUTION
+	ts1 := clock.Now()
+	c.Check(ts1.WallTime, gocheck.Equals, s.now)
+	c.Check(ts1.Logical, gocheck.Equals, uint32(0))
+	c.Check(ts1.After(ts0), gocheck.Equals, true)
+}
+
+// tests that the logical component is advanced
+// when the physical clock doesn't move
+func (s *ClockTest) TestNowLogical(c *gocheck.C) {
+	clock := NewClock(node.NewNodeId())
+	ts0 := clock.Now()
+	ts1 := clock.Now()
+	c.Check(ts1.WallTime, gocheck.Equals, ts0.WallTime)
+	c.Check(ts1.Logical, gocheck.Equals, uint32(1))
+
+	// physical clock moves backwards
+	s.now -= 10 * PHYSICAL_RESOLUTION
+	ts2 := clock.Now()
+	c.Check(ts2.WallTime, gocheck.Equals, ts0.WallTime)
+	c.Check(ts2.Logical, gocheck.Equals, uint32(2))
+}
+
+// tests that the physical component is advanced
+// when the logical component overflows
+func (s *ClockTest) TestLogicalOverflow(c *gocheck.C) {
+	clock := NewClock(node.NewNodeId())
+	clock.last.WallTime = s.now
+	clock.last.Logical = MAX_LOGICAL - 1
+	ts := clock.Now()
+	c.Check(ts.WallTime, gocheck.Equals, s.now + PHYSICAL_RESOLUTION)
+	c.Check(ts.Logical, gocheck.Equals, uint32(0))
+}
+
+// tests that the clock moves past timestamps
+// received from nodes with clocks that are ahead
+func (s *ClockTest) TestUpdateRemoteAhead(c *gocheck.C) {
+	clock := NewClock(node.NewNodeId())
+	remote := Timestamp{WallTime: s.now + 5 * PHYSICAL_RESOLUTION, Logical: 3, NodeId: node.NewNodeId()}
+	ts, err := clock.Update(remote)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(ts.WallTime, gocheck.Equals, remote.WallTime)
+	c.Check(ts.Logical, gocheck.Equals, uint32(4))
+	c.Check(ts.After(remote), gocheck.Equals, true)
+
+	// subsequent local timestamps stay ahead
+	c.Check(clock.Now().After(ts), gocheck.Equals, true)
+}
+
+// tests that timestamps received from nodes with
+// clocks that are behind don't move the clock back
+func (s *ClockTest) TestUpdateRemoteBehind(c *gocheck.C) {
+	clock := NewClock(node.NewNodeId())
+	remote := Timestamp{WallTime: s.now - 5 * PHYSICAL_RESOLUTION, Logical: 3, NodeId: node.NewNodeId()}
+	ts, err := clock.Update(remote)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(ts.WallTime, gocheck.Equals, s.now)
+	c.Check(ts.Logical, gocheck.Equals, uint32(0))
+}
+
+// tests that the larger logical component is used
+// when the physical components are equal
+func (s *ClockTest) TestUpdateEqualWallTime(c *gocheck.C) {
+	clock := NewClock(node.NewNodeId())
+	clock.Now()
+	clock.Now()
+	remote := Timestamp{WallTime: s.now, Logical: 7, NodeId: node.NewNodeId()}
+	ts, err := clock.Update(remote)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(ts.WallTime, gocheck.Equals, s.now)
+	c.Check(ts.Logical, gocheck.Equals, uint32(8))
+}
+
+// tests that remote timestamps too far ahead of
+// the local physical clock don't advance the clock
+func (s *ClockTest) TestUpdateMaxOffset(c *gocheck.C) {
+	clock := NewClock(node.NewNodeId())
+	ts0 := clock.Now()
+
+	remote := Timestamp{WallTime: s.now + int64(DEFAULT_MAX_OFFSET) + PHYSICAL_RESOLUTION, NodeId: node.NewNodeId()}
+	_, err := clock.Update(remote)
+	c.Assert(err, gocheck.NotNil)
+	c.Check(err, gocheck.FitsTypeOf, OffsetError(""))
+
+	ts1 := clock.Now()
+	c.Check(ts1.WallTime, gocheck.Equals, ts0.WallTime)
+	c.Check(ts1.Before(remote), gocheck.Equals, true)
+
+	// timestamps at the max offset are accepted
+	remote.WallTime = s.now + int64(DEFAULT_MAX_OFFSET)
+	ts2, err := clock.Update(remote)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(ts2.After(remote), gocheck.Equals, true)
+}
+
+// tests that a max offset of 0 disables the check
+func (s *ClockTest) TestUpdateMaxOffsetDisabled(c *gocheck.C) {
+	clock := NewClock(node.NewNodeId())
+	clock.SetMaxOffset(0)
+	remote := Timestamp{WallTime: s.now + int64(time.Hour), NodeId: node.NewNodeId()}
+	ts, err := clock.Update(remote)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(ts.After(remote), gocheck.Equals, true)
+}
+
+type TimestampTest struct {}
+
+var _ = gocheck.Suite(&TimestampTest{})
+
+func (s *TimestampTest) TestCompare(c *gocheck.C) {
+	n0 := node.NewNodeId()
+	n1 := node.NewNodeId()
+	if bytes.Compare(n0.Bytes(), n1.Bytes()) > 0 {
+		n0, n1 = n1, n0
+	}
+
+	ts := Timestamp{WallTime: 2000, Logical: 5, NodeId: n0}
+	c.Check(ts.Compare(ts), gocheck.Equals, 0)
+	c.Check(ts.Compare(Timestamp{WallTime: 3000, Logical: 0, NodeId: n0}), gocheck.Equals, -1)
+	c.Check(ts.Compare(Timestamp{WallTime: 1000, Logical: 9, NodeId: n0}), gocheck.Equals, 1)
+	c.Check(ts.Compare(Timestamp{WallTime: 2000, Logical: 6, NodeId: n0}), gocheck.Equals, -1)
+	c.Check(ts.Compare(Timestamp{WallTime: 2000, Logical: 4, NodeId: n0}), gocheck.Equals, 1)
+
+	// ties are broken by node id
+	c.Check(ts.Compare(Timestamp{WallTime: 2000, Logical: 5, NodeId: n1}), gocheck.Equals, -1)
+}
+
+// tests that packing a timestamp into a time
+// value preserves the physical and logical components
+func (s *TimestampTest) TestTimeConversion(c *gocheck.C) {
+	nanos := time.Now().UnixNano()
+	ts := Timestamp{WallTime: nanos - (nanos % PHYSICAL_RESOLUTION), Logical: 17}
+	converted := FromTime(ts.Time())
+	c.Check(converted.WallTime, gocheck.Equals, ts.WallTime)
+	c.Check(converted.Logical, gocheck.Equals, ts.Logical)
+
+	c.Check(FromTime(time.Time{}).IsZero(), gocheck.Equals, true)
+	c.Check(Timestamp{}.Time().IsZero(), gocheck.Equals, true)
+
+	// ordering is preserved
+	later := Timestamp{WallTime: ts.WallTime, Logical: 18}
+	c.Check(later.Time().After(ts.Time()), gocheck.Equals, true)
+}
+
+func (s *TimestampTest) TestSerialization(c *gocheck.C) {
+	src := Timestamp{WallTime: 12345000, Logical: 17, NodeId: node.NewNodeId()}
+
+	buf := &bytes.Buffer{}
+	writer := bufio.NewWriter(buf)
+	err := WriteTimestamp(writer, src)
+	c.Assert(err, gocheck.IsNil)
+	writer.Flush()
+	c.Check(buf.Len(), gocheck.Equals, NumTimestampBytes())
+
+	dst, err := ReadTimestamp(bufio.NewReader(buf))
+	c.Assert(err, gocheck.IsNil)
+	c.Check(dst, gocheck.DeepEquals, src)
+}
@@ -0,0 +1,111 @@
+package kvstore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"store"
+	"types"
+)
+
+// SET options making the write conditional on whether the key exists
+const (
+	NX	= "NX"
+	XX	= "XX"
+)
+
+// conditional writes depend on the value they're executed against,
+// so they have to be executed in the order determined by consensus
+func isConditional(instruction store.Instruction) bool {
+	switch strings.ToUpper(instruction.Cmd) {
+	case SETNX, GETSET, CAS:
+		return true
+	case SET:
+		return len(instruction.Args) > 1
+	}
+	return false
+}
+
+func (s *KVStore) validateSetNX(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) != 1 {
+		return fmt.Errorf("incorrect number of args for SETNX. Expected 1, got %v", len(args))
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("SETNX Got zero timestamp")
+	}
+	return nil
+}
+
+func (s *KVStore) validateGetSet(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) != 1 {
+		return fmt.Errorf("incorrect number of args for GETSET. Expected 1, got %v", len(args))
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("GETSET Got zero timestamp")
+	}
+	return nil
+}
+
+func (s *KVStore) validateCAS(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) != 2 {
+		return fmt.Errorf("incorrect number of args for CAS. Expected 2, got %v", len(args))
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("CAS Got zero timestamp")
+	}
+	return nil
+}
+
+// returns the value stored at key, or nil if the key
+// doesn't exist, or has been deleted or expired
+func (s *KVStore) liveValue(key string, ts time.Time) store.Value {
+	val, exists := s.getValue(key, ts)
+	if !exists || val.GetValueType() == TOMBSTONE_VALUE {
+		return nil
+	}
+	return val
+}
+
+// Set key to hold the string value if the condition is met. With NX, the key
+// is only set if it doesn't exist, and with XX, it's only set if it does.
+// Returns a boolean indicating if the condition was met
+func (s *KVStore) setCond(key string, val string, option string, ts time.Time, origin types.UUID) store.Value {
+	exists := s.liveValue(key, ts) != nil
+	met := exists
+	if strings.ToUpper(option) == NX {
+		met = !exists
+	}
+	if met {
+		s.set(key, val, ts, origin)
+	}
+	return NewBoolean(met, ts)
+}
+
+// Set key to hold the string value, and return the value it held before,
+// or nil if it didn't exist
+func (s *KVStore) getset(key string, val string, ts time.Time, origin types.UUID) store.Value {
+	previous := s.liveValue(key, ts)
+	s.set(key, val, ts, origin)
+	return previous
+}
+
+// Set key to hold the string value if it currently holds the expected
+// string value. Returns a boolean indicating if the condition was met
+func (s *KVStore) cas(key string, expected string, val string, ts time.Time, origin types.UUID) (store.Value, error) {
+	met := false
+	switch current := s.liveValue(key, ts).(type) {
+	case nil:
+	case *String:
+		met = current.GetValue() == expected
+	default:
+		return nil, fmt.Errorf("CAS expected a string value, got %T", current)
+	}
+	if met {
+		s.set(key, val, ts, origin)
+	}
+	return NewBoolean(met, ts), nil
+}
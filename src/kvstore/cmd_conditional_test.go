@@ -0,0 +1,132 @@
+package kvstore
+
+import (
+	"testing"
+	"testing_helpers"
+	"time"
+
+	"store"
+	"types"
+)
+
+func assertCondition(t *testing.T, name string, expected bool, val store.Value, err error) {
+	if err != nil {
+		t.Fatalf("Unexpected error for %v: %v", name, err)
+	}
+	b, ok := val.(*Boolean)
+	if !ok {
+		t.Fatalf("Expected *Boolean for %v, got %T", name, val)
+	}
+	testing_helpers.AssertEqual(t, name, expected, b.GetValue())
+}
+
+func TestSetNX(t *testing.T) {
+	r := setupKVStore()
+	ts := time.Now()
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("SETNX", "a", []string{"b"}, ts))
+	assertCondition(t, "missing key", true, val, err)
+	testing_helpers.AssertEqual(t, "value", "b", r.data["a"].(*String).GetValue())
+
+	val, err = r.ExecuteInstruction(store.NewInstruction("SETNX", "a", []string{"c"}, ts.Add(time.Second)))
+	assertCondition(t, "existing key", false, val, err)
+	testing_helpers.AssertEqual(t, "value", "b", r.data["a"].(*String).GetValue())
+
+	// deleted keys don't exist
+	r.del("a", ts.Add(2 * time.Second), types.UUID{})
+	val, err = r.ExecuteInstruction(store.NewInstruction("SETNX", "a", []string{"d"}, ts.Add(3 * time.Second)))
+	assertCondition(t, "deleted key", true, val, err)
+	testing_helpers.AssertEqual(t, "value", "d", r.data["a"].(*String).GetValue())
+}
+
+func TestSetXX(t *testing.T) {
+	r := setupKVStore()
+	ts := time.Now()
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("SET", "a", []string{"b", "XX"}, ts))
+	assertCondition(t, "missing key", false, val, err)
+	if _, exists := r.data["a"]; exists {
+		t.Errorf("Unexpectedly found 'a' in store")
+	}
+
+	r.set("a", "b", ts, types.UUID{})
+	val, err = r.ExecuteInstruction(store.NewInstruction("SET", "a", []string{"c", "xx"}, ts.Add(time.Second)))
+	assertCondition(t, "existing key", true, val, err)
+	testing_helpers.AssertEqual(t, "value", "c", r.data["a"].(*String).GetValue())
+
+	val, err = r.ExecuteInstruction(store.NewInstruction("SET", "a", []string{"d", "NX"}, ts.Add(2 * time.Second)))
+	assertCondition(t, "nx existing key", false, val, err)
+	testing_helpers.AssertEqual(t, "value", "c", r.data["a"].(*String).GetValue())
+}
+
+func TestSetInvalidOption(t *testing.T) {
+	r := setupKVStore()
+	_, err := r.ExecuteInstruction(store.NewInstruction("SET", "a", []string{"b", "YY"}, time.Now()))
+	if err == nil {
+		t.Errorf("Expected error for invalid SET option")
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	r := setupKVStore()
+	ts := time.Now()
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("GETSET", "a", []string{"b"}, ts))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if val != nil {
+		t.Errorf("Expected nil previous value, got %v", val)
+	}
+
+	val, err = r.ExecuteInstruction(store.NewInstruction("GETSET", "a", []string{"c"}, ts.Add(time.Second)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertEqualValue(t, "previous", NewString("b", ts), val)
+	testing_helpers.AssertEqual(t, "value", "c", r.data["a"].(*String).GetValue())
+}
+
+func TestCAS(t *testing.T) {
+	r := setupKVStore()
+	ts := time.Now()
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("CAS", "a", []string{"b", "c"}, ts))
+	assertCondition(t, "missing key", false, val, err)
+
+	r.set("a", "b", ts, types.UUID{})
+	val, err = r.ExecuteInstruction(store.NewInstruction("CAS", "a", []string{"x", "c"}, ts.Add(time.Second)))
+	assertCondition(t, "mismatch", false, val, err)
+	testing_helpers.AssertEqual(t, "value", "b", r.data["a"].(*String).GetValue())
+
+	val, err = r.ExecuteInstruction(store.NewInstruction("CAS", "a", []string{"b", "c"}, ts.Add(2 * time.Second)))
+	assertCondition(t, "match", true, val, err)
+	testing_helpers.AssertEqual(t, "value", "c", r.data["a"].(*String).GetValue())
+
+	r.ExecuteInstruction(store.NewInstruction("HSET", "h", []string{"f", "v"}, ts))
+	if _, err := r.ExecuteInstruction(store.NewInstruction("CAS", "h", []string{"b", "c"}, ts.Add(time.Second))); err == nil {
+		t.Errorf("Expected error for CAS on non string value")
+	}
+}
+
+// tests that conditional writes are neither reads nor
+// write only, and can only be executed through consensus
+func TestConditionalClassification(t *testing.T) {
+	r := &KVStore{}
+	instructions := []store.Instruction{
+		store.NewInstruction("SETNX", "a", []string{"b"}, time.Time{}),
+		store.NewInstruction("SET", "a", []string{"b", "NX"}, time.Time{}),
+		store.NewInstruction("SET", "a", []string{"b", "XX"}, time.Time{}),
+		store.NewInstruction("GETSET", "a", []string{"b"}, time.Time{}),
+		store.NewInstruction("CAS", "a", []string{"b", "c"}, time.Time{}),
+	}
+	for _, instruction := range instructions {
+		testing_helpers.AssertEqual(t, "read only", false, r.IsReadOnly(instruction))
+		testing_helpers.AssertEqual(t, "write only", false, r.IsWriteOnly(instruction))
+		testing_helpers.AssertEqual(t, "requires consensus", true, r.RequiresConsensus(instruction))
+	}
+
+	set := store.NewInstruction("SET", "a", []string{"b"}, time.Time{})
+	testing_helpers.AssertEqual(t, "set write only", true, r.IsWriteOnly(set))
+	testing_helpers.AssertEqual(t, "set requires consensus", false, r.RequiresConsensus(set))
+}
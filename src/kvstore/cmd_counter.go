@@ -0,0 +1,152 @@
+package kvstore
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"store"
+)
+
+// validates INCR, DECR, INCRBY, and DECRBY instructions
+func (s *KVStore) validateIncr(cmd string, key string, args []string, timestamp time.Time) error {
+	_ = key
+	numArgs := 0
+	switch cmd {
+	case INCRBY, DECRBY:
+		numArgs = 1
+	}
+	if len(args) != numArgs {
+		return fmt.Errorf("incorrect number of args for %v. Expected %v, got %v", cmd, numArgs, len(args))
+	}
+	if numArgs > 0 {
+		delta, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%v value is not an integer: %v", cmd, args[0])
+		}
+		// the negated value would overflow
+		if cmd == DECRBY && delta == math.MinInt64 {
+			return fmt.Errorf("DECRBY value is out of range: %v", args[0])
+		}
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("%v Got zero timestamp", cmd)
+	}
+	return nil
+}
+
+// validates CINCRBY instructions, which take a delta and the
+// id of the shard to add it to
+func (s *KVStore) validateCIncrBy(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) != 2 {
+		return fmt.Errorf("incorrect number of args for CINCRBY. Expected 2, got %v", len(args))
+	}
+	if _, err := strconv.ParseInt(args[0], 10, 64); err != nil {
+		return fmt.Errorf("CINCRBY value is not an integer: %v", args[0])
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("CINCRBY Got zero timestamp")
+	}
+	return nil
+}
+
+func (s *KVStore) validateCShard(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if _, _, _, err := parseCounterShard(args); err != nil {
+		return err
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("CSHARD Got zero timestamp")
+	}
+	return nil
+}
+
+// returns the delta of a validated increment instruction
+func incrementDelta(cmd string, args []string) int64 {
+	var delta int64 = 1
+	switch cmd {
+	case INCRBY, DECRBY:
+		delta, _ = strconv.ParseInt(args[0], 10, 64)
+	}
+	switch cmd {
+	case DECR, DECRBY:
+		delta = -delta
+	}
+	return delta
+}
+
+// returns the counter stored at the given key for writing. If the key
+// doesn't exist, or has been deleted before the given timestamp, a new
+// counter with the given creation time is stored at the key. Nil is
+// returned if the key was deleted after the given timestamp, and an
+// error is returned if the key holds a value of another type
+func (s *KVStore) getCounterForWrite(key string, ts time.Time, created time.Time) (*Counter, error) {
+	existing, exists := s.getValue(key, ts)
+	if !exists {
+		counter := NewCounter(created)
+		s.setValue(key, counter)
+		return counter, nil
+	}
+	switch val := existing.(type) {
+	case *Counter:
+		return val, nil
+	case *Tombstone:
+		if ts.Before(val.time) {
+			return nil, nil
+		}
+		counter := NewCounter(created)
+		s.setValue(key, counter)
+		return counter, nil
+	default:
+		return nil, fmt.Errorf("WRONGTYPE key [%v] holds a %v value, not a counter", key, existing.GetValueType())
+	}
+}
+
+// returns a copy of the given counter, including it's expiry,
+// so it can be returned without exposing the stored value
+func copyCounter(counter *Counter) *Counter {
+	rval := NewCounter(counter.created)
+	rval.expiry = counter.expiry
+	for id, shard := range counter.shards {
+		s := *shard
+		rval.shards[id] = &s
+	}
+	return rval
+}
+
+// Increments the number stored at key by delta. If the key does not exist, it
+// is set to 0 before performing the operation.
+// Return value: the value of key after the increment
+//
+// internally, the delta is added to the given shard, and the whole counter is
+// returned, so the results can be reconciled. Use GetValue on the returned
+// counter to get it's value
+func (s *KVStore) incrby(key string, delta int64, shard string, ts time.Time) (store.Value, error) {
+	counter, err := s.getCounterForWrite(key, ts, ts)
+	if err != nil || counter == nil { return nil, err }
+	counter.add(shard, delta, ts)
+	return copyCounter(counter), nil
+}
+
+// merges a shard into the counter stored at key. This isn't a redis command,
+// it's used to replicate a leader replica's increments, and by reconciliation.
+// If the counter was created after the stored counter, the stored counter was
+// deleted, and it's replaced. If it was created before, the shard belongs to
+// a deleted counter, and it's ignored
+func (s *KVStore) cshard(key string, args []string, ts time.Time) (store.Value, error) {
+	id, shard, created, err := parseCounterShard(args)
+	if err != nil { return nil, err }
+
+	counter, err := s.getCounterForWrite(key, ts, created)
+	if err != nil || counter == nil { return nil, err }
+	if created.After(counter.created) {
+		counter = NewCounter(created)
+		s.setValue(key, counter)
+	}
+	if created.Equal(counter.created) {
+		counter.mergeShard(id, shard)
+	}
+	return copyCounter(counter), nil
+}
@@ -0,0 +1,179 @@
+package kvstore
+
+import (
+	"store"
+	"testing"
+	"time"
+	"testing_helpers"
+)
+
+func TestIncrDecr(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+
+	var expectations = []struct {
+		cmd string
+		args []string
+		value int64
+	}{
+		{"INCR", []string{}, 1},
+		{"INCRBY", []string{"5"}, 6},
+		{"DECR", []string{}, 5},
+		{"DECRBY", []string{"7"}, -2},
+		{"INCRBY", []string{"-3"}, -5},
+	}
+
+	for _, e := range expectations {
+		val, err := r.ExecuteInstruction(store.NewInstruction(e.cmd, "a", e.args, ts0))
+		if err != nil {
+			t.Fatalf("Unexpected error on %v: %v", e.cmd, err)
+		}
+		testing_helpers.AssertEqual(t, e.cmd, e.value, val.(*Counter).GetValue())
+	}
+
+	counter := r.data["a"].(*Counter)
+	testing_helpers.AssertEqual(t, "num shards", 1, len(counter.shards))
+	testing_helpers.AssertEqual(t, "incr", int64(6), counter.shards[CONSENSUS_SHARD].incr)
+	testing_helpers.AssertEqual(t, "decr", int64(11), counter.shards[CONSENSUS_SHARD].decr)
+}
+
+// tests that increments given a shard id are
+// only added to that shard
+func TestIncrShard(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+
+	r.ExecuteInstruction(store.NewInternalInstruction("CINCRBY", "a", []string{"2", "n1"}, ts0))
+	r.ExecuteInstruction(store.NewInternalInstruction("CINCRBY", "a", []string{"1", "n2"}, ts0))
+	r.ExecuteInstruction(store.NewInternalInstruction("CINCRBY", "a", []string{"-1", "n1"}, ts0))
+
+	counter := r.data["a"].(*Counter)
+	testing_helpers.AssertEqual(t, "value", int64(2), counter.GetValue())
+	testing_helpers.AssertEqual(t, "n1", int64(1), counter.shards["n1"].incr - counter.shards["n1"].decr)
+	testing_helpers.AssertEqual(t, "n2", int64(1), counter.shards["n2"].incr - counter.shards["n2"].decr)
+}
+
+// tests that the returned counter is a copy
+func TestIncrCopy(t *testing.T) {
+	r := setupKVStore()
+	val, _ := r.ExecuteInstruction(store.NewInstruction("INCR", "a", []string{}, time.Now()))
+	val.(*Counter).add(CONSENSUS_SHARD, 5, time.Now())
+	testing_helpers.AssertEqual(t, "value", int64(1), r.data["a"].(*Counter).GetValue())
+}
+
+// tests that increments on a deleted key start a new
+// counter, unless the deletion happened after them
+func TestIncrTombstone(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+	r.data["a"] = NewTombstone(ts0)
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("INCR", "a", []string{}, ts0.Add(time.Duration(-1))))
+	if val != nil || err != nil {
+		t.Errorf("Expected nil value and error, got %v, %v", val, err)
+	}
+
+	val, err = r.ExecuteInstruction(store.NewInstruction("INCR", "a", []string{}, ts0.Add(time.Duration(1))))
+	if err != nil {
+		t.Fatalf("Unexpected error on INCR: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "value", int64(1), val.(*Counter).GetValue())
+}
+
+// tests that CSHARD keeps the largest totals, and
+// handles counters created at different times
+func TestCShard(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(1000))
+	r.ExecuteInstruction(store.NewInternalInstruction("CINCRBY", "a", []string{"5", "n1"}, ts0))
+
+	// smaller totals are ignored
+	older := NewCounter(ts0)
+	older.add("n1", 3, ts0)
+	r.ExecuteInstruction(counterShardInstruction("a", "n1", older.shards["n1"], ts0, ts0))
+	testing_helpers.AssertEqual(t, "value", int64(5), r.data["a"].(*Counter).GetValue())
+
+	// larger totals are kept
+	newer := NewCounter(ts0)
+	newer.add("n1", 7, ts1)
+	newer.add("n1", -1, ts1)
+	r.ExecuteInstruction(counterShardInstruction("a", "n1", newer.shards["n1"], ts0, ts1))
+	testing_helpers.AssertEqual(t, "value", int64(6), r.data["a"].(*Counter).GetValue())
+
+	// shards of counters created before the stored one are ignored
+	r.ExecuteInstruction(counterShardInstruction("a", "n2", older.shards["n1"], ts0.Add(time.Duration(-1)), ts1))
+	testing_helpers.AssertEqual(t, "value", int64(6), r.data["a"].(*Counter).GetValue())
+
+	// shards of counters created after the stored one replace it
+	recreated := NewCounter(ts1)
+	recreated.add("n2", 2, ts1)
+	r.ExecuteInstruction(counterShardInstruction("a", "n2", recreated.shards["n2"], ts1, ts1))
+	assertEqualValue(t, "value", recreated, r.data["a"])
+}
+
+func TestLeaderInstruction(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+
+	instruction := store.NewInstruction("INCRBY", "a", []string{"5"}, ts0)
+	leaderInstruction, ok := r.LeaderInstruction(instruction, "n1")
+	testing_helpers.AssertEqual(t, "leader write", true, ok)
+	testing_helpers.AssertEqual(t, "cmd", "CINCRBY", leaderInstruction.Cmd)
+	testing_helpers.AssertStringArrayEqual(t, "args", []string{"5", "n1"}, leaderInstruction.Args)
+	testing_helpers.AssertStringArrayEqual(t, "original args", []string{"5"}, instruction.Args)
+
+	// decrements are negated
+	decrInstruction, _ := r.LeaderInstruction(store.NewInstruction("DECR", "a", []string{}, ts0), "n1")
+	testing_helpers.AssertStringArrayEqual(t, "decr args", []string{"-1", "n1"}, decrInstruction.Args)
+
+	_, ok = r.LeaderInstruction(store.NewInstruction("SET", "a", []string{"b"}, ts0), "n1")
+	testing_helpers.AssertEqual(t, "leader write", false, ok)
+
+	// replicate the leader's result to another store
+	result, err := r.ExecuteInstruction(leaderInstruction)
+	if err != nil {
+		t.Fatalf("Unexpected error on INCRBY: %v", err)
+	}
+	replication, err := r.ReplicationInstruction(instruction, "n1", result)
+	if err != nil {
+		t.Fatalf("Unexpected error getting replication instruction: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "cmd", "CSHARD", replication.Cmd)
+
+	follower := setupKVStore()
+	if _, err := follower.ExecuteInstruction(replication); err != nil {
+		t.Fatalf("Unexpected error on CSHARD: %v", err)
+	}
+	assertEqualValue(t, "replicated value", r.data["a"], follower.data["a"])
+}
+
+// tests validation of counter instructions
+func TestCounterValidation(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+	r.data["s"] = NewString("b", ts0)
+
+	var invalid = []store.Instruction{
+		store.NewInstruction("INCR", "a", []string{"x", "y"}, ts0),
+		store.NewInstruction("INCR", "a", []string{"n1"}, ts0),
+		store.NewInstruction("INCRBY", "a", []string{"5", "n1"}, ts0),
+		store.NewInstruction("DECRBY", "a", []string{"-9223372036854775808"}, ts0),
+		store.NewInternalInstruction("CINCRBY", "a", []string{"5"}, ts0),
+		store.NewInternalInstruction("CINCRBY", "a", []string{"x", "n1"}, ts0),
+		store.NewInstruction("INCRBY", "a", []string{}, ts0),
+		store.NewInstruction("INCRBY", "a", []string{"x"}, ts0),
+		store.NewInstruction("DECR", "a", []string{}, time.Time{}),
+		store.NewInternalInstruction("CSHARD", "a", []string{"n1", "1"}, ts0),
+		store.NewInstruction("INCR", "s", []string{}, ts0),
+	}
+	for _, instruction := range invalid {
+		val, err := r.ExecuteInstruction(instruction)
+		if val != nil {
+			t.Errorf("Unexpected non-nil value for %v", instruction)
+		}
+		if err == nil {
+			t.Errorf("Expected error for %v, got nil", instruction)
+		}
+	}
+}
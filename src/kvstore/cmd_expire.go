@@ -0,0 +1,171 @@
+package kvstore
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"store"
+	"types"
+)
+
+// how often the sweeper replaces expired values with tombstones
+const EXPIRY_SWEEP_INTERVAL = time.Second
+
+func (s *KVStore) validateExpire(cmd string, key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) != 1 {
+		return fmt.Errorf("incorrect number of args for %v. Expected 1, got %v", cmd, len(args))
+	}
+	if _, err := strconv.ParseInt(args[0], 10, 64); err != nil {
+		return fmt.Errorf("%v value is not an integer: %v", cmd, args[0])
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("%v Got zero timestamp", cmd)
+	}
+	return nil
+}
+
+func (s *KVStore) validatePersist(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) != 0 {
+		return fmt.Errorf("PERSIST takes 0 args, %v found", len(args))
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("PERSIST Got zero timestamp")
+	}
+	return nil
+}
+
+func (s *KVStore) validateSetEx(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) != 2 {
+		return fmt.Errorf("incorrect number of args for SETEX. Expected 2, got %v", len(args))
+	}
+	if seconds, err := strconv.ParseInt(args[0], 10, 64); err != nil || seconds <= 0 {
+		return fmt.Errorf("invalid expire time in SETEX: %v", args[0])
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("SETEX Got zero timestamp")
+	}
+	return nil
+}
+
+func (s *KVStore) validateTTL(key string, args []string) error {
+	_ = key
+	if len(args) != 0 {
+		return fmt.Errorf("TTL takes 0 args, %v found", len(args))
+	}
+	return nil
+}
+
+// returns the deadline the given number of seconds after the given
+// timestamp. Deadlines are truncated to milliseconds, so they can be
+// replicated with PEXPIREAT without losing precision
+func secondsDeadline(ts time.Time, seconds int64) time.Time {
+	return ts.Add(time.Duration(seconds) * time.Second).Truncate(time.Millisecond)
+}
+
+func millisDeadline(millis int64) time.Time {
+	return time.Unix(0, millis * int64(time.Millisecond))
+}
+
+func formatMillis(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano() / int64(time.Millisecond), 10)
+}
+
+// Set a timeout on key. After the timeout has expired, the key will automatically
+// be deleted.
+// Return value: true if the timeout was set, false if key does not exist.
+//
+// internally, the deadline is computed from the instruction's timestamp, so every
+// replica computes the same deadline. Expiries older than the key's current expiry
+// are ignored
+func (s *KVStore) expire(key string, deadline time.Time, ts time.Time) (*Boolean, error) {
+	val, exists := s.getValue(key, ts)
+	if !exists {
+		return NewBoolean(false, ts), nil
+	}
+	e, ok := val.(expiringValue)
+	if !ok {
+		return NewBoolean(false, ts), nil
+	}
+	return NewBoolean(e.getExpiry().setExpiry(deadline, ts), ts), nil
+}
+
+// Remove the existing timeout on key, turning the key from volatile to persistent.
+// Return value: true if the timeout was removed, false if key does not exist or
+// does not have an associated timeout.
+func (s *KVStore) persist(key string, ts time.Time) (*Boolean, error) {
+	val, exists := s.getValue(key, ts)
+	if !exists {
+		return NewBoolean(false, ts), nil
+	}
+	e, ok := val.(expiringValue)
+	if !ok {
+		return NewBoolean(false, ts), nil
+	}
+	hadDeadline := !e.getExpiry().deadline.IsZero()
+	return NewBoolean(e.getExpiry().setExpiry(time.Time{}, ts) && hadDeadline, ts), nil
+}
+
+// Set key to hold the string value and set key to timeout after a given number of
+// seconds.
+func (s *KVStore) setex(key string, val string, deadline time.Time, ts time.Time, origin types.UUID) store.Value {
+	existing, exists := s.getValue(key, ts)
+	if exists && store.WriteBefore(ts, origin, existing) {
+		return existing
+	}
+	value := NewString(val, ts)
+	value.origin = origin
+	value.setExpiry(deadline, ts)
+	s.setValue(key, value)
+	return value
+}
+
+// Returns the remaining time to live of a key that has a timeout.
+// Return value: TTL in seconds, -2 if the key does not exist, or -1
+// if the key exists but has no associated expire.
+func (s *KVStore) ttl(key string) (*Integer, error) {
+	at := now()
+	val, exists := s.getValue(key, at)
+	if !exists || val.GetValueType() == TOMBSTONE_VALUE {
+		return NewInteger(-2, time.Time{}), nil
+	}
+	e, ok := val.(expiringValue)
+	if !ok || e.getExpiry().deadline.IsZero() {
+		return NewInteger(-1, val.GetTimestamp()), nil
+	}
+	remaining := e.getExpiry().deadline.Sub(at)
+	return NewInteger(int64(remaining / time.Second), val.GetTimestamp()), nil
+}
+
+// replaces the values that have expired at the given time with
+// tombstones timestamped with their deadlines, and returns the
+// number of values that were replaced
+func (s *KVStore) sweepExpired(at time.Time) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	num := 0
+	for key, val := range s.data {
+		if expired := expireValue(val, at); expired != val {
+			s.setValue(key, expired)
+			num++
+		}
+	}
+	return num
+}
+
+func (s *KVStore) sweepLoop(stop chan bool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.sweepExpired(now())
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package kvstore
+
+import (
+	"store"
+	"types"
+	"testing"
+	"time"
+	"testing_helpers"
+)
+
+// replaces the store's clock for the duration of a test
+func setNow(t time.Time) func() {
+	now = func() time.Time { return t }
+	return func() { now = time.Now }
+}
+
+// tests that the copies of values made with a new expiry can
+// be modified without modifying the values they were copied from
+func TestWithExpiryCopies(t *testing.T) {
+	ts0 := time.Unix(1000, 0)
+	ts1 := ts0.Add(time.Second)
+	writes := []struct {
+		key string
+		create store.Instruction
+		modify store.Instruction
+	}{
+		{"h", store.NewInstruction("HSET", "h", []string{"a", "1"}, ts0), store.NewInstruction("HSET", "h", []string{"a", "2", "b", "3"}, ts1)},
+		{"s", store.NewInstruction("SADD", "s", []string{"a"}, ts0), store.NewInstruction("SREM", "s", []string{"a"}, ts1)},
+		{"z", store.NewInstruction("ZADD", "z", []string{"1", "a"}, ts0), store.NewInstruction("ZADD", "z", []string{"2", "a", "3", "b"}, ts1)},
+		{"l", store.NewInstruction("RPUSH", "l", []string{"a", "b"}, ts0), store.NewInstruction("LPOP", "l", []string{}, ts1)},
+		{"c", store.NewInstruction("INCRBY", "c", []string{"1"}, ts0), store.NewInstruction("INCRBY", "c", []string{"2"}, ts1)},
+	}
+
+	r := setupKVStore()
+	expected := setupKVStore()
+	for _, w := range writes {
+		for _, s := range []*KVStore{r, expected} {
+			if _, err := s.ExecuteInstruction(w.create); err != nil {
+				t.Fatalf("Unexpected error on %v: %v", w.create.Cmd, err)
+			}
+		}
+
+		original := r.data[w.key]
+		r.data[w.key] = original.(expiringValue).withExpiry(expiry{deadline: ts0.Add(time.Hour)})
+		if _, err := r.ExecuteInstruction(w.modify); err != nil {
+			t.Fatalf("Unexpected error on %v: %v", w.modify.Cmd, err)
+		}
+		testing_helpers.AssertEqual(t, w.create.Cmd, true, original.Equal(expected.data[w.key]))
+	}
+}
+
+// tests that the deadline set by EXPIRE is computed from
+// the instruction timestamp, not the time it's executed
+func TestExpireDeadline(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	r.set("a", "b", ts0, types.UUID{})
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("EXPIRE", "a", []string{"10"}, ts0.Add(time.Second)))
+	if err != nil {
+		t.Fatalf("Unexpected error on EXPIRE: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "result", true, val.(*Boolean).GetValue())
+	testing_helpers.AssertEqual(t, "deadline", ts0.Add(11 * time.Second), r.data["a"].(*String).GetExpiry())
+}
+
+func TestExpireMissingKey(t *testing.T) {
+	r := setupKVStore()
+	val, err := r.ExecuteInstruction(store.NewInstruction("EXPIRE", "a", []string{"10"}, time.Now()))
+	if err != nil {
+		t.Fatalf("Unexpected error on EXPIRE: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "result", false, val.(*Boolean).GetValue())
+}
+
+// tests that expiries older than the key's
+// current expiry are ignored
+func TestExpireOutOfOrder(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	r.set("a", "b", ts0, types.UUID{})
+
+	r.ExecuteInstruction(store.NewInstruction("EXPIRE", "a", []string{"10"}, ts0.Add(2 * time.Second)))
+	val, _ := r.ExecuteInstruction(store.NewInstruction("EXPIRE", "a", []string{"100"}, ts0.Add(time.Second)))
+	testing_helpers.AssertEqual(t, "result", false, val.(*Boolean).GetValue())
+	testing_helpers.AssertEqual(t, "deadline", ts0.Add(12 * time.Second), r.data["a"].(*String).GetExpiry())
+}
+
+// tests that expired keys are read as tombstones
+// timestamped with their deadline
+func TestGetExpired(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	r.ExecuteInstruction(store.NewInstruction("SETEX", "a", []string{"10", "b"}, ts0))
+
+	defer setNow(ts0.Add(9 * time.Second))()
+	val, _ := r.ExecuteInstruction(store.NewInstruction("GET", "a", []string{}, time.Time{}))
+	testing_helpers.AssertEqual(t, "value", "b", val.(*String).GetValue())
+
+	setNow(ts0.Add(10 * time.Second))
+	val, _ = r.ExecuteInstruction(store.NewInstruction("GET", "a", []string{}, time.Time{}))
+	assertEqualValue(t, "value", NewTombstone(ts0.Add(10 * time.Second)), val)
+}
+
+// tests that writes made after a key's deadline
+// see the key as deleted
+func TestWriteAfterExpiry(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	r.ExecuteInstruction(store.NewInstruction("SADD", "a", []string{"x"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("EXPIRE", "a", []string{"10"}, ts0))
+
+	r.ExecuteInstruction(store.NewInstruction("SADD", "a", []string{"y"}, ts0.Add(11 * time.Second)))
+	set := r.data["a"].(*Set)
+	testing_helpers.AssertEqual(t, "x", false, set.Contains("x"))
+	testing_helpers.AssertEqual(t, "y", true, set.Contains("y"))
+	testing_helpers.AssertEqual(t, "deadline", time.Time{}, set.GetExpiry())
+}
+
+func TestPersist(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	r.ExecuteInstruction(store.NewInstruction("SETEX", "a", []string{"10", "b"}, ts0))
+
+	val, _ := r.ExecuteInstruction(store.NewInstruction("PERSIST", "a", []string{}, ts0.Add(time.Second)))
+	testing_helpers.AssertEqual(t, "result", true, val.(*Boolean).GetValue())
+	testing_helpers.AssertEqual(t, "deadline", time.Time{}, r.data["a"].(*String).GetExpiry())
+
+	val, _ = r.ExecuteInstruction(store.NewInstruction("PERSIST", "a", []string{}, ts0.Add(2 * time.Second)))
+	testing_helpers.AssertEqual(t, "result", false, val.(*Boolean).GetValue())
+}
+
+// tests that SET discards the key's expiry
+func TestSetDiscardsExpiry(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	r.ExecuteInstruction(store.NewInstruction("SETEX", "a", []string{"10", "b"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("SET", "a", []string{"c"}, ts0.Add(time.Second)))
+	testing_helpers.AssertEqual(t, "deadline", time.Time{}, r.data["a"].(*String).GetExpiry())
+}
+
+// tests that list mutations keep the list's expiry
+func TestListKeepsExpiry(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	r.ExecuteInstruction(store.NewInstruction("RPUSH", "a", []string{"x", "y"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("EXPIRE", "a", []string{"10"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("RPUSH", "a", []string{"z"}, ts0.Add(time.Second)))
+	r.ExecuteInstruction(store.NewInstruction("LPOP", "a", []string{}, ts0.Add(2 * time.Second)))
+	testing_helpers.AssertEqual(t, "deadline", ts0.Add(10 * time.Second), r.data["a"].(*List).GetExpiry())
+}
+
+// tests that counter increments keep the counter's expiry, on the
+// stored counter, and the copy returned for reconciliation
+func TestCounterKeepsExpiry(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	r.ExecuteInstruction(store.NewInstruction("INCRBY", "a", []string{"1"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("EXPIRE", "a", []string{"10"}, ts0))
+	val, err := r.ExecuteInstruction(store.NewInstruction("INCRBY", "a", []string{"2"}, ts0.Add(time.Second)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "returned deadline", ts0.Add(10 * time.Second), val.(*Counter).GetExpiry())
+	testing_helpers.AssertEqual(t, "stored deadline", ts0.Add(10 * time.Second), r.data["a"].(*Counter).GetExpiry())
+}
+
+func TestTTL(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	defer setNow(ts0.Add(3 * time.Second))()
+
+	val, _ := r.ExecuteInstruction(store.NewInstruction("TTL", "a", []string{}, time.Time{}))
+	testing_helpers.AssertEqual(t, "missing", int64(-2), val.(*Integer).GetValue())
+
+	r.set("a", "b", ts0, types.UUID{})
+	val, _ = r.ExecuteInstruction(store.NewInstruction("TTL", "a", []string{}, time.Time{}))
+	testing_helpers.AssertEqual(t, "persistent", int64(-1), val.(*Integer).GetValue())
+
+	r.ExecuteInstruction(store.NewInstruction("EXPIRE", "a", []string{"10"}, ts0))
+	val, _ = r.ExecuteInstruction(store.NewInstruction("TTL", "a", []string{}, time.Time{}))
+	testing_helpers.AssertEqual(t, "volatile", int64(7), val.(*Integer).GetValue())
+
+	setNow(ts0.Add(10 * time.Second))
+	val, _ = r.ExecuteInstruction(store.NewInstruction("TTL", "a", []string{}, time.Time{}))
+	testing_helpers.AssertEqual(t, "expired", int64(-2), val.(*Integer).GetValue())
+}
+
+// tests that the sweeper replaces expired values with
+// tombstones, and leaves the rest alone
+func TestSweepExpired(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	r.ExecuteInstruction(store.NewInstruction("SETEX", "a", []string{"10", "b"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("SETEX", "b", []string{"20", "b"}, ts0))
+	r.set("c", "d", ts0, types.UUID{})
+
+	testing_helpers.AssertEqual(t, "num swept", 1, r.sweepExpired(ts0.Add(15 * time.Second)))
+	assertEqualValue(t, "a", NewTombstone(ts0.Add(10 * time.Second)), r.data["a"])
+	testing_helpers.AssertEqual(t, "b", STRING_VALUE, r.data["b"].GetValueType())
+	testing_helpers.AssertEqual(t, "c", STRING_VALUE, r.data["c"].GetValueType())
+}
+
+// tests that expired values are treated as deleted before they're swept
+func TestIsDeleted(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	r.ExecuteInstruction(store.NewInstruction("SETEX", "a", []string{"10", "b"}, ts0))
+
+	defer setNow(ts0.Add(9 * time.Second))()
+	testing_helpers.AssertEqual(t, "live", false, r.IsDeleted(r.data["a"]))
+	testing_helpers.AssertEqual(t, "tombstone", true, r.IsDeleted(NewTombstone(ts0)))
+
+	setNow(ts0.Add(10 * time.Second))
+	testing_helpers.AssertEqual(t, "expired", true, r.IsDeleted(r.data["a"]))
+}
+
+// tests that replicas holding a value with an older expiry
+// are corrected with PEXPIREAT
+func TestReconcileExpiry(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	defer setNow(ts0.Add(time.Second))()
+
+	v0 := NewString("b", ts0)
+	v1 := NewString("b", ts0)
+	v1.setExpiry(ts0.Add(10 * time.Second), ts0.Add(time.Second))
+
+	val, instructions, err := r.Reconcile("a", []store.Value{v0, v1})
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "deadline", ts0.Add(10 * time.Second), val.(*String).GetExpiry())
+	testing_helpers.AssertEqual(t, "num instructions 0", 1, len(instructions[0]))
+	testing_helpers.AssertEqual(t, "num instructions 1", 0, len(instructions[1]))
+
+	instruction := instructions[0][0]
+	testing_helpers.AssertEqual(t, "cmd", PEXPIREAT, instruction.Cmd)
+	testing_helpers.AssertEqual(t, "timestamp", ts0.Add(time.Second), instruction.Timestamp)
+
+	// check that executing the instruction makes the values equal
+	r.SetRawKey("a", v0)
+	r.ExecuteInstruction(instruction)
+	assertEqualValue(t, "corrected", v1, r.data["a"])
+}
+
+// tests that expired values are reconciled as
+// deletions made at their deadline
+func TestReconcileExpired(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Unix(1000, 0)
+	defer setNow(ts0.Add(20 * time.Second))()
+
+	v0 := NewString("b", ts0)
+	v0.setExpiry(ts0.Add(10 * time.Second), ts0)
+	v1 := NewString("b", ts0)
+
+	val, instructions, err := r.Reconcile("a", []store.Value{v0, v1})
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling: %v", err)
+	}
+	assertEqualValue(t, "value", NewTombstone(ts0.Add(10 * time.Second)), val)
+	testing_helpers.AssertEqual(t, "num instructions 0", 0, len(instructions[0]))
+	testing_helpers.AssertEqual(t, "num instructions 1", 1, len(instructions[1]))
+	testing_helpers.AssertEqual(t, "cmd", DEL, instructions[1][0].Cmd)
+}
@@ -17,6 +17,7 @@ func (s *KVStore) validateGet(key string, args []string) error {
 // An error is returned if the value stored at key is not a string, because GET only handles string values.
 func (s *KVStore) get(key string) (store.Value, error) {
 	// TODO: check that the returned value is a string or tombstone value
-	return s.data[key], nil
+	val, _ := s.getValue(key, now())
+	return val, nil
 }
 
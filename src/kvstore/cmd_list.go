@@ -0,0 +1,166 @@
+package kvstore
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"store"
+)
+
+func (s *KVStore) validatePush(cmd string, key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) < 1 {
+		return fmt.Errorf("%v takes at least 1 arg, %v found", cmd, len(args))
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("%v Got zero timestamp", cmd)
+	}
+	return nil
+}
+
+func (s *KVStore) validateLPop(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) != 0 {
+		return fmt.Errorf("LPOP takes 0 args, %v found", len(args))
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("LPOP Got zero timestamp")
+	}
+	return nil
+}
+
+func (s *KVStore) validateLRange(key string, args []string) error {
+	_ = key
+	if len(args) != 2 {
+		return fmt.Errorf("incorrect number of args for LRANGE. Expected 2, got %v", len(args))
+	}
+	for _, arg := range args {
+		if _, err := strconv.Atoi(arg); err != nil {
+			return fmt.Errorf("LRANGE start and stop must be integers, got %v", arg)
+		}
+	}
+	return nil
+}
+
+// returns the list stored at the given key. Nil is returned if the
+// key doesn't exist, has been deleted, or has expired at the given
+// time, and an error is returned if the key holds a value of another type
+//
+// list mutations are executed in consensus order, so unlike other
+// types, the timestamps of deletions aren't compared against them
+func (s *KVStore) getList(key string, at time.Time) (*List, error) {
+	existing, exists := s.getValue(key, at)
+	if !exists {
+		return nil, nil
+	}
+	switch val := existing.(type) {
+	case *List:
+		return val, nil
+	case *Tombstone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("WRONGTYPE key [%v] holds a %v value, not a list", key, existing.GetValueType())
+	}
+}
+
+// returns the later of the list's timestamp and the given timestamp
+func listTimestamp(list *List, ts time.Time) time.Time {
+	if list != nil && list.time.After(ts) {
+		return list.time
+	}
+	return ts
+}
+
+// stores a list containing the given values at the given key. The
+// existing list's expiry is carried over to the new list
+func (s *KVStore) putList(key string, list *List, values []string, ts time.Time) {
+	newList := NewList(values, listTimestamp(list, ts))
+	if list != nil {
+		newList.expiry = list.expiry
+	}
+	s.setValue(key, newList)
+}
+
+// Insert all the specified values at the head of the list stored at key. If key does
+// not exist, it is created as empty list before performing the push operations. Elements
+// are inserted one after the other to the head of the list, from the leftmost element to
+// the rightmost element.
+// Return value: the length of the list after the push operations.
+func (s *KVStore) lpush(key string, vals []string, ts time.Time) (*Integer, error) {
+	list, err := s.getList(key, ts)
+	if err != nil { return nil, err }
+
+	values := make([]string, 0, len(vals))
+	for i:=len(vals)-1; i>=0; i-- {
+		values = append(values, vals[i])
+	}
+	if list != nil {
+		values = append(values, list.values...)
+	}
+	s.putList(key, list, values, ts)
+	return NewInteger(int64(len(values)), ts), nil
+}
+
+// Insert all the specified values at the tail of the list stored at key. If key does
+// not exist, it is created as empty list before performing the push operation.
+// Return value: the length of the list after the push operation.
+func (s *KVStore) rpush(key string, vals []string, ts time.Time) (*Integer, error) {
+	list, err := s.getList(key, ts)
+	if err != nil { return nil, err }
+
+	values := make([]string, 0, len(vals))
+	if list != nil {
+		values = append(values, list.values...)
+	}
+	values = append(values, vals...)
+	s.putList(key, list, values, ts)
+	return NewInteger(int64(len(values)), ts), nil
+}
+
+// Removes and returns the first element of the list stored at key.
+// Return value: the value of the first element, or nil when key does not exist.
+//
+// internally, the key is replaced with a tombstone when it's last element is removed
+func (s *KVStore) lpop(key string, ts time.Time) (store.Value, error) {
+	list, err := s.getList(key, ts)
+	if err != nil || list == nil { return nil, err }
+
+	rval := NewString(list.values[0], ts)
+	if len(list.values) == 1 {
+		s.setValue(key, NewTombstone(listTimestamp(list, ts)))
+	} else {
+		values := make([]string, len(list.values) - 1)
+		copy(values, list.values[1:])
+		s.putList(key, list, values, ts)
+	}
+	return rval, nil
+}
+
+// Returns the specified elements of the list stored at key. The offsets start and stop
+// are zero-based indexes, with 0 being the first element of the list, 1 being the next
+// element and so on. They can also be negative numbers indicating offsets starting at
+// the end of the list. Out of range indexes will not produce an error.
+// Return value: list of elements in the specified range.
+func (s *KVStore) lrange(key string, start int, stop int) (store.Value, error) {
+	list, err := s.getList(key, now())
+	if err != nil || list == nil { return nil, err }
+
+	values := []string{}
+	if start, stop, ok := normalizeRange(len(list.values), start, stop); ok {
+		values = make([]string, stop - start + 1)
+		copy(values, list.values[start:stop + 1])
+	}
+	return NewList(values, list.time), nil
+}
+
+// converts the given inclusive range, where negative indexes count back
+// from the end, to non negative indexes clamped to the given size. False
+// is returned if the range is empty
+func normalizeRange(size int, start int, stop int) (int, int, bool) {
+	if start < 0 { start = size + start }
+	if stop < 0 { stop = size + stop }
+	if start < 0 { start = 0 }
+	if stop >= size { stop = size - 1 }
+	return start, stop, start <= stop
+}
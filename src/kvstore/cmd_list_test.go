@@ -0,0 +1,172 @@
+package kvstore
+
+import (
+	"store"
+	"testing"
+	"time"
+	"testing_helpers"
+)
+
+func TestLPush(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("LPUSH", "a", []string{"x", "y"}, ts0))
+	if err != nil {
+		t.Fatalf("Unexpected error on LPUSH: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "len", int64(2), val.(*Integer).GetValue())
+
+	val, err = r.ExecuteInstruction(store.NewInstruction("LPUSH", "a", []string{"z"}, ts0))
+	if err != nil {
+		t.Fatalf("Unexpected error on LPUSH: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "len", int64(3), val.(*Integer).GetValue())
+
+	list, ok := r.data["a"].(*List)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", r.data["a"])
+	}
+	testing_helpers.AssertStringArrayEqual(t, "values", []string{"z", "y", "x"}, list.GetValues())
+}
+
+func TestRPush(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+
+	r.ExecuteInstruction(store.NewInstruction("RPUSH", "a", []string{"x", "y"}, ts0))
+	val, err := r.ExecuteInstruction(store.NewInstruction("RPUSH", "a", []string{"z"}, ts0))
+	if err != nil {
+		t.Fatalf("Unexpected error on RPUSH: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "len", int64(3), val.(*Integer).GetValue())
+	testing_helpers.AssertStringArrayEqual(t, "values", []string{"x", "y", "z"}, r.data["a"].(*List).GetValues())
+}
+
+// tests that list mutations are applied in the order they're
+// executed, regardless of their timestamps
+func TestListIgnoresTimestamps(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+
+	r.data["a"] = NewTombstone(ts0)
+	r.ExecuteInstruction(store.NewInstruction("RPUSH", "a", []string{"x"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("RPUSH", "a", []string{"y"}, ts1))
+
+	list := r.data["a"].(*List)
+	testing_helpers.AssertStringArrayEqual(t, "values", []string{"x", "y"}, list.GetValues())
+	testing_helpers.AssertEqual(t, "time", ts0, list.GetTimestamp())
+}
+
+func TestLPop(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("RPUSH", "a", []string{"x", "y"}, ts0))
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("LPOP", "a", []string{}, ts0))
+	if err != nil {
+		t.Fatalf("Unexpected error on LPOP: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "value", "x", val.(*String).GetValue())
+	testing_helpers.AssertStringArrayEqual(t, "values", []string{"y"}, r.data["a"].(*List).GetValues())
+
+	// popping the last element should delete the key
+	val, err = r.ExecuteInstruction(store.NewInstruction("LPOP", "a", []string{}, ts0))
+	if err != nil {
+		t.Fatalf("Unexpected error on LPOP: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "value", "y", val.(*String).GetValue())
+	if _, ok := r.data["a"].(*Tombstone); !ok {
+		t.Fatalf("Unexpected value type: %T", r.data["a"])
+	}
+
+	val, err = r.ExecuteInstruction(store.NewInstruction("LPOP", "a", []string{}, ts0))
+	if val != nil || err != nil {
+		t.Errorf("Expected nil value and error, got %v, %v", val, err)
+	}
+}
+
+func TestLRange(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("RPUSH", "a", []string{"v", "w", "x", "y", "z"}, ts0))
+
+	var expectations = []struct {
+		start string
+		stop string
+		values []string
+	}{
+		{"0", "-1", []string{"v", "w", "x", "y", "z"}},
+		{"1", "2", []string{"w", "x"}},
+		{"-2", "-1", []string{"y", "z"}},
+		{"-100", "100", []string{"v", "w", "x", "y", "z"}},
+		{"3", "1", []string{}},
+		{"5", "10", []string{}},
+	}
+
+	for _, e := range expectations {
+		val, err := r.ExecuteInstruction(store.NewInstruction("LRANGE", "a", []string{e.start, e.stop}, time.Time{}))
+		if err != nil {
+			t.Fatalf("Unexpected error on LRANGE: %v", err)
+		}
+		testing_helpers.AssertStringArrayEqual(t, e.start + ":" + e.stop, e.values, val.(*List).GetValues())
+	}
+}
+
+func TestListWrongType(t *testing.T) {
+	r := setupKVStore()
+	r.data["a"] = NewString("b", time.Now())
+
+	for _, cmd := range []string{"LPUSH", "RPUSH"} {
+		val, err := r.ExecuteInstruction(store.NewInstruction(cmd, "a", []string{"x"}, time.Now()))
+		if val != nil {
+			t.Errorf("Unexpected non-nil value")
+		}
+		if err == nil {
+			t.Errorf("Expected error, got nil")
+		}
+	}
+}
+
+// tests validation of list instructions
+func TestListValidation(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+
+	var invalid = []store.Instruction{
+		store.NewInstruction("LPUSH", "a", []string{}, ts0),
+		store.NewInstruction("RPUSH", "a", []string{"x"}, time.Time{}),
+		store.NewInstruction("LPOP", "a", []string{"x"}, ts0),
+		store.NewInstruction("LRANGE", "a", []string{"0"}, time.Time{}),
+		store.NewInstruction("LRANGE", "a", []string{"0", "x"}, time.Time{}),
+	}
+	for _, instruction := range invalid {
+		val, err := r.ExecuteInstruction(instruction)
+		if val != nil {
+			t.Errorf("Unexpected non-nil value for %v", instruction)
+		}
+		if err == nil {
+			t.Errorf("Expected error for %v, got nil", instruction)
+		}
+	}
+}
+
+func TestRequiresConsensus(t *testing.T) {
+	r := &KVStore{}
+	for _, cmd := range []string{"LPUSH", "RPUSH", "LPOP"} {
+		testing_helpers.AssertEqual(t, cmd, true, r.RequiresConsensus(store.Instruction{Cmd:cmd}))
+	}
+	for _, cmd := range []string{"GET", "SET", "DEL", "HSET", "LRANGE"} {
+		testing_helpers.AssertEqual(t, cmd, false, r.RequiresConsensus(store.Instruction{Cmd:cmd}))
+	}
+}
+
+// LPOP returns the value it removes, so it's
+// neither a read, nor write only
+func TestLPopIsntWriteOnly(t *testing.T) {
+	r := &KVStore{}
+	instruction := store.Instruction{Cmd:"LPOP"}
+	testing_helpers.AssertEqual(t, "write only", false, r.IsWriteOnly(instruction))
+	testing_helpers.AssertEqual(t, "read only", false, r.IsReadOnly(instruction))
+}
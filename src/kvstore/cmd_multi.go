@@ -0,0 +1,39 @@
+package kvstore
+
+import (
+	"fmt"
+	"time"
+
+	"store"
+)
+
+func (s *KVStore) validateMGet(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("MGET requires at least 1 key")
+	}
+	return nil
+}
+
+func (s *KVStore) validateMDel(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("DEL requires at least 1 key")
+	}
+	return nil
+}
+
+func (s *KVStore) validateMSet(args []string) error {
+	if len(args) == 0 || len(args) % 2 != 0 {
+		return fmt.Errorf("MSET requires key value pairs, got %v args", len(args))
+	}
+	return nil
+}
+
+// returns an instruction with the given command
+// and no args for each of the given keys
+func splitKeys(cmd string, keys []string, timestamp time.Time) []store.Instruction {
+	instructions := make([]store.Instruction, len(keys))
+	for i, key := range keys {
+		instructions[i] = store.NewInstruction(cmd, key, []string{}, timestamp)
+	}
+	return instructions
+}
@@ -0,0 +1,59 @@
+package kvstore
+
+import (
+	"store"
+	"testing"
+	"time"
+	"testing_helpers"
+)
+
+func TestSplitMultiKey(t *testing.T) {
+	r := setupKVStore()
+	ts := time.Now()
+
+	var expectations = []struct {
+		cmd string
+		args []string
+		instructions []store.Instruction
+	}{
+		{"MGET", []string{"a", "b"}, []store.Instruction{
+			store.NewInstruction("GET", "a", []string{}, time.Time{}),
+			store.NewInstruction("GET", "b", []string{}, time.Time{}),
+		}},
+		{"MSET", []string{"a", "1", "b", "2"}, []store.Instruction{
+			store.NewInstruction("SET", "a", []string{"1"}, ts),
+			store.NewInstruction("SET", "b", []string{"2"}, ts),
+		}},
+		{"DEL", []string{"a", "b"}, []store.Instruction{
+			store.NewInstruction("DEL", "a", []string{}, ts),
+			store.NewInstruction("DEL", "b", []string{}, ts),
+		}},
+	}
+
+	for _, e := range expectations {
+		instructions, ok, err := r.SplitMultiKey(e.cmd, e.args, ts)
+		if err != nil {
+			t.Fatalf("Unexpected error splitting %v: %v", e.cmd, err)
+		}
+		testing_helpers.AssertEqual(t, e.cmd + " ok", true, ok)
+		testing_helpers.AssertEqual(t, e.cmd + " num instructions", len(e.instructions), len(instructions))
+		for i := range instructions {
+			if !e.instructions[i].Equal(instructions[i]) {
+				t.Errorf("%v instruction mismatch. Expected %v, got %v", e.cmd, e.instructions[i], instructions[i])
+			}
+		}
+	}
+}
+
+func TestSplitMultiKeyInvalid(t *testing.T) {
+	r := setupKVStore()
+	if _, _, err := r.SplitMultiKey("MSET", []string{"a", "1", "b"}, time.Now()); err == nil {
+		t.Errorf("Expected error for unpaired MSET args")
+	}
+	if _, _, err := r.SplitMultiKey("MGET", []string{}, time.Now()); err == nil {
+		t.Errorf("Expected error for MGET without keys")
+	}
+	if _, ok, _ := r.SplitMultiKey("GET", []string{"a"}, time.Now()); ok {
+		t.Errorf("GET identified as a multi key command")
+	}
+}
@@ -2,14 +2,23 @@ package kvstore
 
 import (
 	"store"
+	"strings"
 	"time"
 	"fmt"
+	"types"
 )
 
 func (s *KVStore) validateSet(key string, args []string, timestamp time.Time) error {
 	_ = key
-	if len(args) != 1 {
-		return fmt.Errorf("incorrect number of args for SET. Expected 1, got %v", len(args))
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("incorrect number of args for SET. Expected 1 or 2, got %v", len(args))
+	}
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1]) {
+		case NX, XX:
+		default:
+			return fmt.Errorf("invalid SET option: %v. Expected NX or XX", args[1])
+		}
 	}
 	if timestamp.IsZero() {
 		return fmt.Errorf("DEL Got zero timestamp")
@@ -20,13 +29,17 @@ func (s *KVStore) validateSet(key string, args []string, timestamp time.Time) er
 // Set key to hold the string value. If key already holds a value, it is overwritten,
 // regardless of its type. Any previous time to live associated with the key is discarded
 // on successful SET operation.
-func (s *KVStore) set(key string, val string, ts time.Time) (store.Value) {
-	existing, exists := s.data[key]
-	if exists && ts.Before(existing.GetTimestamp()) {
+//
+// internally, writes older than the current value are ignored. Writes with equal
+// timestamps are ordered by the id of the node that issued them
+func (s *KVStore) set(key string, val string, ts time.Time, origin types.UUID) (store.Value) {
+	existing, exists := s.getValue(key, ts)
+	if exists && store.WriteBefore(ts, origin, existing) {
 		return existing
 	}
 	value := NewString(val, ts)
-	s.data[key] = value
+	value.origin = origin
+	s.setValue(key, value)
 	return value
 }
 
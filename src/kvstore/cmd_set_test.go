@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"store"
+	"types"
 )
 
 // tests basic function of set
@@ -49,12 +50,34 @@ func TestSetConflictingTimestamp(t *testing.T) {
 	r := setupKVStore()
 	now := time.Now()
 	then := now.Add(time.Duration(-1))
-	expected := r.set("a", "b", now)
-	actual := r.set("a", "c", then)
+	expected := r.set("a", "b", now, types.UUID{})
+	actual := r.set("a", "c", then, types.UUID{})
 	testing_helpers.AssertEqual(t, "set val", expected, actual)
 
 }
 
+// writes with equal timestamps should be ordered by their origins,
+// so replicas receiving them in different orders agree on the value
+func TestSetEqualTimestamps(t *testing.T) {
+	ts := time.Now()
+	o0 := types.UUID{}
+	o1 := types.NewUUID1()
+
+	r0 := setupKVStore()
+	r0.set("a", "b", ts, o0)
+	r0.set("a", "c", ts, o1)
+
+	r1 := setupKVStore()
+	r1.set("a", "c", ts, o1)
+	r1.set("a", "b", ts, o0)
+
+	v0, _ := r0.getValue("a", ts)
+	v1, _ := r1.getValue("a", ts)
+	testing_helpers.AssertEqual(t, "r0 val", "c", v0.(*String).GetValue())
+	testing_helpers.AssertEqual(t, "r1 val", "c", v1.(*String).GetValue())
+	testing_helpers.AssertEqual(t, "origin", o1, store.GetOrigin(v0))
+}
+
 // tests validation of SET insructions
 func TestSetValidation(t *testing.T) {
 	r := setupKVStore()
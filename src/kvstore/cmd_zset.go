@@ -0,0 +1,248 @@
+package kvstore
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"store"
+	"types"
+)
+
+// a score range boundary, parsed from redis' score
+// range syntax, ie: "1.5", "(1.5", "-inf", "+inf"
+type scoreBound struct {
+	value float64
+	exclusive bool
+}
+
+func parseScoreBound(s string) (scoreBound, error) {
+	bound := scoreBound{}
+	if strings.HasPrefix(s, "(") {
+		bound.exclusive = true
+		s = s[1:]
+	}
+	switch strings.ToLower(s) {
+	case "-inf":
+		bound.value = math.Inf(-1)
+	case "+inf", "inf":
+		bound.value = math.Inf(1)
+	default:
+		val, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(val) {
+			return bound, fmt.Errorf("min or max is not a float: %v", s)
+		}
+		bound.value = val
+	}
+	return bound, nil
+}
+
+// returns true if the given score is within this lower bound
+func (b scoreBound) below(score float64) bool {
+	if b.exclusive {
+		return score > b.value
+	}
+	return score >= b.value
+}
+
+// returns true if the given score is within this upper bound
+func (b scoreBound) above(score float64) bool {
+	if b.exclusive {
+		return score < b.value
+	}
+	return score <= b.value
+}
+
+func (s *KVStore) validateZAdd(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) < 2 || len(args) % 2 != 0 {
+		return fmt.Errorf("ZADD takes score member pairs, %v args found", len(args))
+	}
+	// NaN scores can't be ordered against the other members
+	for i:=0; i<len(args); i+=2 {
+		if score, err := strconv.ParseFloat(args[i], 64); err != nil || math.IsNaN(score) {
+			return fmt.Errorf("ZADD score is not a float: %v", args[i])
+		}
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("ZADD Got zero timestamp")
+	}
+	return nil
+}
+
+func (s *KVStore) validateZRem(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) < 1 {
+		return fmt.Errorf("ZREM requires at least 1 member")
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("ZREM Got zero timestamp")
+	}
+	return nil
+}
+
+func (s *KVStore) validateZRange(key string, args []string) error {
+	_ = key
+	if len(args) != 2 {
+		return fmt.Errorf("incorrect number of args for ZRANGE. Expected 2, got %v", len(args))
+	}
+	for _, arg := range args {
+		if _, err := strconv.Atoi(arg); err != nil {
+			return fmt.Errorf("ZRANGE index is not an integer: %v", arg)
+		}
+	}
+	return nil
+}
+
+func (s *KVStore) validateZRangeByScore(key string, args []string) error {
+	_ = key
+	if len(args) != 2 {
+		return fmt.Errorf("incorrect number of args for ZRANGEBYSCORE. Expected 2, got %v", len(args))
+	}
+	for _, arg := range args {
+		if _, err := parseScoreBound(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the sorted set stored at the given key for writing. If the key
+// doesn't exist, or has been deleted before the given timestamp, a new
+// sorted set is stored at the key. Nil is returned if the key was
+// deleted after the given timestamp, and an error is returned if the
+// key holds a value of another type
+func (s *KVStore) getSortedSetForWrite(key string, ts time.Time) (*SortedSet, error) {
+	existing, exists := s.getValue(key, ts)
+	if !exists {
+		zset := NewSortedSet()
+		s.setValue(key, zset)
+		return zset, nil
+	}
+	switch val := existing.(type) {
+	case *SortedSet:
+		return val, nil
+	case *Tombstone:
+		if ts.Before(val.time) {
+			return nil, nil
+		}
+		zset := NewSortedSet()
+		s.setValue(key, zset)
+		return zset, nil
+	default:
+		return nil, fmt.Errorf("WRONGTYPE key [%v] holds a %v value, not a sorted set", key, existing.GetValueType())
+	}
+}
+
+// returns the sorted set stored at the given key for reading. Nil is
+// returned if the key doesn't exist, and an error is returned if the
+// key holds a value of another type
+func (s *KVStore) getSortedSetForRead(key string) (*SortedSet, error) {
+	existing, exists := s.getValue(key, now())
+	if !exists {
+		return nil, nil
+	}
+	switch val := existing.(type) {
+	case *SortedSet:
+		return val, nil
+	case *Tombstone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("WRONGTYPE key [%v] holds a %v value, not a sorted set", key, existing.GetValueType())
+	}
+}
+
+// Adds all the specified members with the specified scores to the sorted set stored
+// at key. If a specified member is already a member of the sorted set, the score is
+// updated and the element reinserted at the right position to ensure the correct
+// ordering. If key does not exist, a new sorted set with the specified members as
+// sole members is created.
+// Return value: The number of elements added to the sorted sets, not including
+// elements already existing for which the score was updated.
+//
+// internally, writes older than the member's current value are ignored, as are writes
+// to missing members timestamped before the gc's purge horizon, since the member may
+// have been removed, and its tombstone purged
+func (s *KVStore) zadd(key string, args []string, ts time.Time, origin types.UUID) (*Integer, error) {
+	zset, err := s.getSortedSetForWrite(key, ts)
+	if err != nil { return nil, err }
+	if zset == nil {
+		return NewInteger(0, ts), nil
+	}
+
+	num := 0
+	for i:=0; i<len(args); i+=2 {
+		score, _ := strconv.ParseFloat(args[i], 64)
+		member := args[i+1]
+		existing, exists := zset.members[member]
+		if exists && existing.after(ts, origin) {
+			continue
+		}
+		if !exists && ts.Before(s.gc.PurgedBefore) {
+			continue
+		}
+		if !exists || existing.removed {
+			num++
+		}
+		zset.setMember(member, &zsetMember{score: score, time: ts, origin: origin})
+	}
+	return NewInteger(int64(num), ts), nil
+}
+
+// Removes the specified members from the sorted set stored at key. Non existing
+// members are ignored.
+// Return value: The number of members removed from the sorted set, not including
+// non existing members.
+//
+// internally, the members are replaced with tombstones, so the removals can be
+// reconciled with replicas that haven't seen them. Missing members aren't tombstoned
+// by removals timestamped before the gc's purge horizon
+func (s *KVStore) zrem(key string, members []string, ts time.Time, origin types.UUID) (*Integer, error) {
+	zset, err := s.getSortedSetForWrite(key, ts)
+	if err != nil { return nil, err }
+	if zset == nil {
+		return NewInteger(0, ts), nil
+	}
+
+	num := 0
+	for _, member := range members {
+		existing, exists := zset.members[member]
+		if exists && existing.after(ts, origin) {
+			continue
+		}
+		if !exists && ts.Before(s.gc.PurgedBefore) {
+			continue
+		}
+		if exists && !existing.removed {
+			num++
+		}
+		zset.setMember(member, &zsetMember{time: ts, origin: origin, removed: true})
+	}
+	return NewInteger(int64(num), ts), nil
+}
+
+// Returns the specified range of elements in the sorted set stored at key. The
+// elements are considered to be ordered from the lowest to the highest score.
+// Start and stop are zero-based indexes, and can be negative numbers indicating
+// offsets from the end of the sorted set.
+//
+// internally, a sorted set containing only the members in the range is
+// returned, so the results can be reconciled member by member. Use Members
+// on the returned sorted set to get the members in order
+func (s *KVStore) zrange(key string, start int, stop int) (store.Value, error) {
+	zset, err := s.getSortedSetForRead(key)
+	if err != nil || zset == nil { return nil, err }
+	return zset.subset(zset.rangeByRank(start, stop)), nil
+}
+
+// Returns all the elements in the sorted set at key with a score between min
+// and max. The elements are considered to be ordered from low to high scores.
+//
+// internally, a sorted set is returned, the same as ZRANGE
+func (s *KVStore) zrangebyscore(key string, min scoreBound, max scoreBound) (store.Value, error) {
+	zset, err := s.getSortedSetForRead(key)
+	if err != nil || zset == nil { return nil, err }
+	return zset.subset(zset.rangeByScore(min, max)), nil
+}
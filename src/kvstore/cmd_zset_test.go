@@ -0,0 +1,154 @@
+package kvstore
+
+import (
+	"store"
+	"testing"
+	"time"
+	"testing_helpers"
+)
+
+// returns a store with a sorted set at key "a"
+func setupSortedSet(t *testing.T, ts time.Time) *KVStore {
+	r := setupKVStore()
+	args := []string{"3", "c", "1", "a", "2", "b", "2.5", "x", "-1", "z"}
+	val, err := r.ExecuteInstruction(store.NewInstruction("ZADD", "a", args, ts))
+	if err != nil {
+		t.Fatalf("Unexpected error on ZADD: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num added", int64(5), val.(*Integer).GetValue())
+	return r
+}
+
+func TestZAdd(t *testing.T) {
+	ts0 := time.Now()
+	r := setupSortedSet(t, ts0)
+
+	zset, ok := r.data["a"].(*SortedSet)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", r.data["a"])
+	}
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"z", "a", "b", "x", "c"}, zset.Members())
+
+	// update a score, and add a new member
+	val, err := r.ExecuteInstruction(store.NewInstruction("ZADD", "a", []string{"10", "a", "0", "y"}, ts0.Add(time.Duration(1))))
+	if err != nil {
+		t.Fatalf("Unexpected error on ZADD: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num added", int64(1), val.(*Integer).GetValue())
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"z", "y", "b", "x", "c", "a"}, zset.Members())
+}
+
+// tests that writes older than the member's
+// current value are ignored
+func TestZAddOutOfOrder(t *testing.T) {
+	ts0 := time.Now()
+	r := setupSortedSet(t, ts0)
+
+	r.ExecuteInstruction(store.NewInstruction("ZADD", "a", []string{"10", "a"}, ts0.Add(time.Duration(-1))))
+	score, _ := r.data["a"].(*SortedSet).Score("a")
+	testing_helpers.AssertEqual(t, "score", float64(1), score)
+}
+
+func TestZRem(t *testing.T) {
+	ts0 := time.Now()
+	r := setupSortedSet(t, ts0)
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("ZREM", "a", []string{"a", "q"}, ts0.Add(time.Duration(1))))
+	if err != nil {
+		t.Fatalf("Unexpected error on ZREM: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num removed", int64(1), val.(*Integer).GetValue())
+
+	zset := r.data["a"].(*SortedSet)
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"z", "b", "x", "c"}, zset.Members())
+	_, exists := zset.Score("a")
+	testing_helpers.AssertEqual(t, "exists", false, exists)
+}
+
+func TestZRange(t *testing.T) {
+	r := setupSortedSet(t, time.Now())
+
+	var expectations = []struct {
+		start string
+		stop string
+		members []string
+	}{
+		{"0", "-1", []string{"z", "a", "b", "x", "c"}},
+		{"1", "2", []string{"a", "b"}},
+		{"-2", "-1", []string{"x", "c"}},
+		{"3", "1", []string{}},
+	}
+
+	for _, e := range expectations {
+		val, err := r.ExecuteInstruction(store.NewInstruction("ZRANGE", "a", []string{e.start, e.stop}, time.Time{}))
+		if err != nil {
+			t.Fatalf("Unexpected error on ZRANGE: %v", err)
+		}
+		testing_helpers.AssertStringArrayEqual(t, e.start + ":" + e.stop, e.members, val.(*SortedSet).Members())
+	}
+}
+
+func TestZRangeByScore(t *testing.T) {
+	r := setupSortedSet(t, time.Now())
+
+	var expectations = []struct {
+		min string
+		max string
+		members []string
+	}{
+		{"-inf", "+inf", []string{"z", "a", "b", "x", "c"}},
+		{"1", "2.5", []string{"a", "b", "x"}},
+		{"(1", "(2.5", []string{"b"}},
+		{"2.6", "+inf", []string{"c"}},
+		{"5", "10", []string{}},
+		{"3", "1", []string{}},
+	}
+
+	for _, e := range expectations {
+		val, err := r.ExecuteInstruction(store.NewInstruction("ZRANGEBYSCORE", "a", []string{e.min, e.max}, time.Time{}))
+		if err != nil {
+			t.Fatalf("Unexpected error on ZRANGEBYSCORE: %v", err)
+		}
+		testing_helpers.AssertStringArrayEqual(t, e.min + ":" + e.max, e.members, val.(*SortedSet).Members())
+	}
+}
+
+// tests validation of sorted set instructions
+func TestSortedSetValidation(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+
+	var invalid = []store.Instruction{
+		store.NewInstruction("ZADD", "a", []string{"1"}, ts0),
+		store.NewInstruction("ZADD", "a", []string{"x", "a"}, ts0),
+		store.NewInstruction("ZADD", "a", []string{"NaN", "a"}, ts0),
+		store.NewInstruction("ZADD", "a", []string{"1", "a", "nan", "b"}, ts0),
+		store.NewInstruction("ZADD", "a", []string{"1", "a"}, time.Time{}),
+		store.NewInstruction("ZREM", "a", []string{}, ts0),
+		store.NewInstruction("ZRANGE", "a", []string{"0", "x"}, time.Time{}),
+		store.NewInstruction("ZRANGEBYSCORE", "a", []string{"0", "x"}, time.Time{}),
+		store.NewInstruction("ZRANGEBYSCORE", "a", []string{"0", "NaN"}, time.Time{}),
+	}
+	for _, instruction := range invalid {
+		val, err := r.ExecuteInstruction(instruction)
+		if val != nil {
+			t.Errorf("Unexpected non-nil value for %v", instruction)
+		}
+		if err == nil {
+			t.Errorf("Expected error for %v, got nil", instruction)
+		}
+	}
+}
+
+func TestSortedSetWrongType(t *testing.T) {
+	r := setupKVStore()
+	r.data["a"] = NewString("b", time.Now())
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("ZADD", "a", []string{"1", "x"}, time.Now()))
+	if val != nil {
+		t.Errorf("Unexpected non-nil value")
+	}
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
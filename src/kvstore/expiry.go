@@ -0,0 +1,193 @@
+package kvstore
+
+import (
+	"bufio"
+	"time"
+
+	"serializer"
+	"store"
+)
+
+// returns the current time, used to determine if keys have
+// expired when they're read. Tests can replace it
+var now = time.Now
+
+// the time a value expires, and the timestamp of the write that set it
+//
+// deadlines are absolute, and computed from the timestamp of the write
+// that set them, so every replica agrees on when a key expires, regardless
+// of when it received the write. Values of every type, except tombstones,
+// embed an expiry
+type expiry struct {
+	// zero if the value doesn't expire
+	deadline time.Time
+	expiryTime time.Time
+}
+
+func newExpiry(ts time.Time) expiry {
+	return expiry{expiryTime: ts}
+}
+
+// returns the time the value expires, or
+// the zero time if it doesn't expire
+func (e *expiry) GetExpiry() time.Time {
+	return e.deadline
+}
+
+func (e *expiry) getExpiry() *expiry {
+	return e
+}
+
+// returns true if the value has expired at the given time
+func (e *expiry) expired(at time.Time) bool {
+	return !e.deadline.IsZero() && !at.Before(e.deadline)
+}
+
+// sets the deadline, unless it was set by a later write. A
+// zero deadline removes the expiry. Returns false if the
+// deadline wasn't set
+func (e *expiry) setExpiry(deadline time.Time, ts time.Time) bool {
+	if ts.Before(e.expiryTime) {
+		return false
+	}
+	e.deadline = deadline
+	e.expiryTime = ts
+	return true
+}
+
+func (e *expiry) expiryEqual(o *expiry) bool {
+	return e.deadline.Equal(o.deadline)
+}
+
+func (e *expiry) serializeExpiry(buf *bufio.Writer) error {
+	if err := serializer.WriteTime(buf, e.deadline); err != nil {
+		return err
+	}
+	if err := serializer.WriteTime(buf, e.expiryTime); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (e *expiry) deserializeExpiry(buf *bufio.Reader) error {
+	var err error
+	if e.deadline, err = serializer.ReadTime(buf); err != nil {
+		return err
+	}
+	if e.expiryTime, err = serializer.ReadTime(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+// a value that can expire
+type expiringValue interface {
+	store.Value
+	getExpiry() *expiry
+
+	// returns a copy of the value with the given expiry. The copy
+	// doesn't share any state with the value, so either can be modified
+	withExpiry(e expiry) store.Value
+}
+
+// returns the given value, or a tombstone timestamped with it's
+// deadline if it's expired at the given time
+func expireValue(val store.Value, at time.Time) store.Value {
+	if e, ok := val.(expiringValue); ok && e.getExpiry().expired(at) {
+		return NewTombstone(e.getExpiry().deadline)
+	}
+	return val
+}
+
+// returns the value stored at the given key, treating values that have
+// expired at the given time as tombstones timestamped with their deadline.
+// Writes pass their own timestamp, so every replica agrees on whether
+// the key had expired when the write was made
+//
+// missing keys are treated as deleted when the purge horizon for
+// tombstones is after the given time, since a tombstone that would
+// have overridden the write may have been purged
+func (s *KVStore) getValue(key string, at time.Time) (store.Value, bool) {
+	val, exists := s.data[key]
+	if !exists {
+		if at.Before(s.gc.PurgedBefore) {
+			return NewTombstone(s.gc.PurgedBefore), true
+		}
+		return nil, false
+	}
+	return expireValue(val, at), true
+}
+
+// returns a copy of the given value without an expiry, so it's
+// contents can be reconciled without comparing expiries
+func withoutExpiry(val store.Value) store.Value {
+	if e, ok := val.(expiringValue); ok {
+		return e.withExpiry(expiry{})
+	}
+	return val
+}
+
+// returns the instruction that sets a value's expiry to the given expiry
+func expiryInstruction(key string, e expiry) store.Instruction {
+	if e.deadline.IsZero() {
+		return store.NewInstruction(PERSIST, key, []string{}, e.expiryTime)
+	}
+	return store.NewInstruction(PEXPIREAT, key, []string{formatMillis(e.deadline)}, e.expiryTime)
+}
+
+// reconciles the expiry of the reconciled value. Values that have expired
+// should be replaced with tombstones before the values are reconciled
+//
+// the expiry set by the most recent write is used, and instructions setting
+// it are appended to the instructions of replicas with different expiries.
+// Expiries set before the key was deleted, or overwritten with another type
+// are discarded. Since SET also sets the expiry time of the strings it
+// creates, expiries set before a SET are discarded as well
+func reconcileExpiry(key string, val store.Value, values []store.Value, instructions [][]store.Instruction) (store.Value, [][]store.Instruction) {
+	merged, ok := val.(expiringValue)
+	if !ok {
+		return val, instructions
+	}
+
+	// find the time the key was last reset
+	var resetTime time.Time
+	for _, v := range values {
+		if v.GetValueType() != val.GetValueType() {
+			if ts := v.GetTimestamp(); ts.After(resetTime) {
+				resetTime = ts
+			}
+		}
+	}
+
+	var latest expiry
+	for _, v := range values {
+		if v.GetValueType() != val.GetValueType() {
+			continue
+		}
+		e := v.(expiringValue).getExpiry()
+		if !e.expiryTime.Before(resetTime) && e.expiryTime.After(latest.expiryTime) {
+			latest = *e
+		}
+	}
+	if latest.expiryTime.IsZero() {
+		latest.expiryTime = resetTime
+	}
+
+	for i, v := range values {
+		if v.GetValueType() == val.GetValueType() {
+			if v.(expiringValue).getExpiry().expiryEqual(&latest) {
+				continue
+			}
+		} else if latest.deadline.IsZero() {
+			// replicas holding other types will be reset by the
+			// value's instructions, creating a value without an expiry
+			continue
+		}
+		instructions[i] = append(instructions[i], expiryInstruction(key, latest))
+	}
+
+	if !merged.getExpiry().expiryEqual(&latest) {
+		val = merged.withExpiry(latest)
+	}
+	return val, instructions
+}
@@ -0,0 +1,26 @@
+package kvstore
+
+import (
+	"time"
+)
+
+// sets how long tombstones are kept before they're purged
+func (s *KVStore) SetGCGrace(grace time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.gc.Grace = grace
+}
+
+// returns the number of tombstones purged since the store was created
+func (s *KVStore) GetNumPurged() uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.gc.NumPurged
+}
+
+// removes the tombstones that were created more than the gc grace period
+// before the given time, including the set tags, hash fields and sorted set
+// members tombstoned inside values, and returns the number removed
+func (s *KVStore) purgeTombstones(at time.Time) int {
+	return s.gc.Purge(&s.lock, s.data, s.index, TOMBSTONE_VALUE, at)
+}
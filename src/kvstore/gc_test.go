@@ -0,0 +1,133 @@
+package kvstore
+
+import (
+	"fmt"
+	"store"
+	"types"
+	"testing"
+	"time"
+	"testing_helpers"
+)
+
+// tests that only tombstones older than the
+// grace period are purged
+func TestPurgeTombstones(t *testing.T) {
+	r := setupKVStore()
+	r.SetGCGrace(time.Hour)
+	ts0 := time.Unix(100000, 0)
+
+	r.set("a", "b", ts0, types.UUID{})
+	r.data["b"] = NewTombstone(ts0)
+	r.data["c"] = NewTombstone(ts0.Add(2 * time.Hour))
+
+	testing_helpers.AssertEqual(t, "num purged", 1, r.purgeTombstones(ts0.Add(90 * time.Minute)))
+	testing_helpers.AssertEqual(t, "total purged", uint64(1), r.GetNumPurged())
+	testing_helpers.AssertEqual(t, "a exists", true, r.KeyExists("a"))
+	testing_helpers.AssertEqual(t, "b exists", false, r.KeyExists("b"))
+	testing_helpers.AssertEqual(t, "c exists", true, r.KeyExists("c"))
+	testing_helpers.AssertEqual(t, "num keys", 2, len(r.GetKeys()))
+}
+
+// tests that tombstones are purged across several batches
+func TestPurgeBatches(t *testing.T) {
+	r := setupKVStore()
+	r.SetGCGrace(time.Hour)
+	ts0 := time.Unix(100000, 0)
+	numKeys := store.GC_BATCH_SIZE * 2 + 1
+	for i:=0; i<numKeys; i++ {
+		r.SetRawKey(fmt.Sprint(i), NewTombstone(ts0))
+	}
+	r.set("a", "b", ts0, types.UUID{})
+
+	testing_helpers.AssertEqual(t, "num purged", numKeys, r.purgeTombstones(ts0.Add(2 * time.Hour)))
+	testing_helpers.AssertEqual(t, "total purged", uint64(numKeys), r.GetNumPurged())
+	testing_helpers.AssertEqual(t, "num keys", 1, len(r.GetKeys()))
+}
+
+// tests that writes made before the purge horizon don't
+// recreate keys that may have been deleted, but later
+// writes do
+func TestPurgedKeyWrites(t *testing.T) {
+	r := setupKVStore()
+	r.SetGCGrace(time.Hour)
+	ts0 := time.Unix(100000, 0)
+	r.data["a"] = NewTombstone(ts0)
+	r.purgeTombstones(ts0.Add(2 * time.Hour))
+
+	// a replayed hint, or repair, from before the deletion
+	r.ExecuteInstruction(store.NewInstruction("SET", "a", []string{"b"}, ts0.Add(-time.Minute)))
+	testing_helpers.AssertEqual(t, "a exists", false, r.KeyExists("a"))
+	r.ExecuteInstruction(store.NewInstruction("HSET", "b", []string{"f", "v"}, ts0.Add(-time.Minute)))
+	testing_helpers.AssertEqual(t, "b exists", false, r.KeyExists("b"))
+	r.ExecuteInstruction(store.NewInstruction("SETEX", "c", []string{"10", "v"}, ts0.Add(-time.Minute)))
+	testing_helpers.AssertEqual(t, "c exists", false, r.KeyExists("c"))
+
+	r.ExecuteInstruction(store.NewInstruction("SET", "a", []string{"b"}, ts0.Add(90 * time.Minute)))
+	testing_helpers.AssertEqual(t, "a exists", true, r.KeyExists("a"))
+}
+
+// tests that the tombstoned tags of sets are purged, and that adds
+// made before the purge horizon can't resurrect removed members
+func TestPurgeSetTags(t *testing.T) {
+	r := setupKVStore()
+	r.SetGCGrace(time.Hour)
+	ts0 := time.Unix(100000, 0)
+	r.ExecuteInstruction(store.NewInstruction("SADD", "s", []string{"a", "b"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("SREM", "s", []string{"a"}, ts0))
+
+	testing_helpers.AssertEqual(t, "num purged", 1, r.purgeTombstones(ts0.Add(2 * time.Hour)))
+	set := r.data["s"].(*Set)
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"b"}, set.sortedMembers())
+
+	// a repair from a replica that hasn't seen the removal
+	r.ExecuteInstruction(store.NewInstruction("SADD", "s", []string{"a"}, ts0))
+	testing_helpers.AssertEqual(t, "a present", false, set.Contains("a"))
+
+	r.ExecuteInstruction(store.NewInstruction("SADD", "s", []string{"a"}, ts0.Add(90 * time.Minute)))
+	testing_helpers.AssertEqual(t, "a present", true, set.Contains("a"))
+}
+
+// tests that the tombstones of deleted hash fields are purged, and
+// that writes made before the purge horizon can't recreate them
+func TestPurgeHashFields(t *testing.T) {
+	r := setupKVStore()
+	r.SetGCGrace(time.Hour)
+	ts0 := time.Unix(100000, 0)
+	r.ExecuteInstruction(store.NewInstruction("HSET", "h", []string{"a", "1", "b", "2"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("HDEL", "h", []string{"a"}, ts0))
+
+	testing_helpers.AssertEqual(t, "num purged", 1, r.purgeTombstones(ts0.Add(2 * time.Hour)))
+	hash := r.data["h"].(*Hash)
+	testing_helpers.AssertEqual(t, "num fields", 1, len(hash.fields))
+
+	// a repair from a replica that hasn't seen the deletion
+	r.ExecuteInstruction(store.NewInstruction("HSET", "h", []string{"a", "1"}, ts0.Add(-time.Minute)))
+	_, exists := hash.GetField("a")
+	testing_helpers.AssertEqual(t, "a exists", false, exists)
+
+	r.ExecuteInstruction(store.NewInstruction("HSET", "h", []string{"a", "3"}, ts0.Add(90 * time.Minute)))
+	val, exists := hash.GetField("a")
+	testing_helpers.AssertEqual(t, "a exists", true, exists)
+	testing_helpers.AssertEqual(t, "a value", "3", val)
+}
+
+// tests that the tombstones of removed sorted set members are purged,
+// and that adds made before the purge horizon can't recreate them
+func TestPurgeSortedSetMembers(t *testing.T) {
+	r := setupKVStore()
+	r.SetGCGrace(time.Hour)
+	ts0 := time.Unix(100000, 0)
+	r.ExecuteInstruction(store.NewInstruction("ZADD", "z", []string{"1", "a", "2", "b"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("ZREM", "z", []string{"a"}, ts0))
+
+	testing_helpers.AssertEqual(t, "num purged", 1, r.purgeTombstones(ts0.Add(2 * time.Hour)))
+	zset := r.data["z"].(*SortedSet)
+	testing_helpers.AssertEqual(t, "num members", 1, len(zset.members))
+
+	// a repair from a replica that hasn't seen the removal
+	r.ExecuteInstruction(store.NewInstruction("ZADD", "z", []string{"1", "a"}, ts0.Add(-time.Minute)))
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"b"}, zset.Members())
+
+	r.ExecuteInstruction(store.NewInstruction("ZADD", "z", []string{"3", "a"}, ts0.Add(90 * time.Minute)))
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"b", "a"}, zset.Members())
+}
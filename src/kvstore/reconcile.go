@@ -0,0 +1,134 @@
+package kvstore
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+import (
+	"store"
+)
+
+// the default reconcile policy, the value with the highest timestamp wins
+var LastWriteWins = store.ReconcileFunc(reconcileLastWriteWins)
+
+// reconciles numeric string values by keeping the largest, useful
+// for high water marks. Values that can't be parsed as numbers
+// cause reconciliation to fail
+var MaxPolicy = store.ReconcilePolicy(&stringMergePolicy{merge:mergeMax})
+
+// returns a policy that reconciles string values containing lists of
+// members separated by sep, by taking the union of the members of
+// each value. Members of the reconciled value are sorted
+func NewUnionPolicy(sep string) store.ReconcilePolicy {
+	return &stringMergePolicy{merge:func(values []string) (string, error) {
+		return mergeUnion(sep, values)
+	}}
+}
+
+func reconcileLastWriteWins(key string, values []store.Value) (store.Value, [][]store.Instruction, error) {
+	highValue := getHighValue(values)
+	if highValue == nil {
+		// none of the values have a timestamp, so there's nothing to reconcile.
+		// Counts of missing keys aren't timestamped, so they're returned as is
+		for _, val := range values {
+			if val, ok := val.(*Integer); ok {
+				return val, make([][]store.Instruction, len(values)), nil
+			}
+		}
+		return nil, make([][]store.Instruction, len(values)), nil
+	}
+
+	switch highValue.GetValueType() {
+	case STRING_VALUE:
+		return reconcileString(key, highValue.(*String), values)
+	case TOMBSTONE_VALUE:
+		return reconcileTombstone(key, highValue.(*Tombstone), values)
+	case HASH_VALUE:
+		return reconcileHash(key, values)
+	case LIST_VALUE:
+		return reconcileList(key, highValue.(*List), values)
+	case SET_VALUE:
+		return reconcileSet(key, values)
+	case ZSET_VALUE:
+		return reconcileSortedSet(key, values)
+	case COUNTER_VALUE:
+		return reconcileCounter(key, values)
+	case INTEGER_VALUE:
+		return reconcileInteger(key, values)
+	default:
+		return nil, [][]store.Instruction{}, fmt.Errorf("Unknown value type: %T", highValue)
+	}
+}
+
+// merges the contents of string values into a single value
+//
+// if the value with the highest timestamp isn't a string, the key
+// has been deleted or overwritten with another type, so the values are
+// reconciled with LastWriteWins instead. Otherwise, the merged value
+// takes the highest timestamp, so the corrective SET instructions
+// are applied by every replica
+type stringMergePolicy struct {
+	merge func(values []string) (string, error)
+}
+
+func (p *stringMergePolicy) Reconcile(key string, values []store.Value) (store.Value, [][]store.Instruction, error) {
+	highValue := getHighValue(values)
+	if highValue == nil || highValue.GetValueType() != STRING_VALUE {
+		return reconcileLastWriteWins(key, values)
+	}
+
+	strs := make([]string, 0, len(values))
+	for _, val := range values {
+		if str, ok := val.(*String); ok {
+			strs = append(strs, str.value)
+		}
+	}
+
+	merged, err := p.merge(strs)
+	if err != nil {
+		return nil, [][]store.Instruction{}, err
+	}
+	// the merged value keeps the high value's origin, otherwise the replica
+	// holding the high value would ignore the corrective SET
+	high := highValue.(*String)
+	mergedValue := NewString(merged, high.time)
+	mergedValue.origin = high.origin
+	return reconcileString(key, mergedValue, values)
+}
+
+func mergeMax(values []string) (string, error) {
+	var maxStr string
+	var maxNum float64
+	for i, str := range values {
+		num, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return "", fmt.Errorf("Cannot reconcile non numeric value: %v", str)
+		}
+		if i == 0 || num > maxNum {
+			maxNum = num
+			maxStr = str
+		}
+	}
+	return maxStr, nil
+}
+
+func mergeUnion(sep string, values []string) (string, error) {
+	members := make(map[string]bool)
+	for _, str := range values {
+		for _, member := range strings.Split(str, sep) {
+			if member != "" {
+				members[member] = true
+			}
+		}
+	}
+
+	union := make([]string, 0, len(members))
+	for member := range members {
+		union = append(union, member)
+	}
+	sort.Strings(union)
+	return strings.Join(union, sep), nil
+}
@@ -0,0 +1,224 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+
+	"testing_helpers"
+	"store"
+	"types"
+)
+
+// tests that the policy registered with the
+// longest matching prefix is used
+func TestReconcilePolicyLookup(t *testing.T) {
+	registry := store.NewReconcileRegistry()
+	union := NewUnionPolicy(",")
+	registry.Register("hw:", MaxPolicy)
+	registry.Register("hw:tags:", union)
+
+	testing_helpers.AssertEqual(t, "unregistered", nil, registry.Get("a"))
+	testing_helpers.AssertEqual(t, "prefix", MaxPolicy, registry.Get("hw:a"))
+	testing_helpers.AssertEqual(t, "longest prefix", union, registry.Get("hw:tags:a"))
+
+	registry.Unregister("hw:tags:")
+	testing_helpers.AssertEqual(t, "unregistered prefix", MaxPolicy, registry.Get("hw:tags:a"))
+}
+
+// tests that keys without a registered policy
+// are reconciled with last write wins
+func TestReconcileDefaultPolicy(t *testing.T) {
+	r := setupKVStore()
+	r.RegisterReconcilePolicy("hw:", MaxPolicy)
+
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	expected := NewString("1", ts0)
+	values := []store.Value{expected, NewString("5", ts1)}
+
+	ractual, _, err := r.Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	assertEqualValue(t, "reconciled value", expected, ractual)
+}
+
+// tests that values without timestamps, like the missing
+// values returned by reads, are reconciled to nil
+func TestReconcileNoTimestamps(t *testing.T) {
+	r := setupKVStore()
+	values := []store.Value{nil, NewBoolean(false, time.Time{})}
+
+	ractual, _, err := r.Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	if ractual != nil {
+		t.Errorf("expected nil value, got %v", ractual)
+	}
+}
+
+// tests that integer counts, like the results of HLEN, are reconciled
+// to the most recently timestamped count, without any adjustments
+func TestReconcileIntegers(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	expected := NewInteger(3, ts0)
+	values := []store.Value{NewInteger(2, ts1), expected, NewInteger(0, time.Time{})}
+
+	ractual, adjustments, err := r.Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	assertEqualValue(t, "reconciled value", expected, ractual)
+	testing_helpers.AssertEqual(t, "adjustment size", len(values), len(adjustments))
+	for _, adjustment := range adjustments {
+		testing_helpers.AssertEqual(t, "num instructions", 0, len(adjustment))
+	}
+
+	// counts of missing keys aren't timestamped
+	expected = NewInteger(0, time.Time{})
+	ractual, _, err = r.Reconcile("k", []store.Value{expected, NewInteger(0, time.Time{})})
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	assertEqualValue(t, "reconciled value", expected, ractual)
+}
+
+func TestReconcileMaxPolicy(t *testing.T) {
+	r := setupKVStore()
+	r.RegisterReconcilePolicy("hw:", MaxPolicy)
+
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	values := []store.Value{NewString("1", ts0), NewString("5", ts1), NewString("5", ts0)}
+
+	ractual, adjustments, err := r.Reconcile("hw:k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	// the max value should take the highest timestamp
+	expected := NewString("5", ts0)
+	assertEqualValue(t, "reconciled value", expected, ractual)
+	testing_helpers.AssertEqual(t, "adjustment size", len(values), len(adjustments))
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments[0]))
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments[1]))
+	testing_helpers.AssertEqual(t, "num instructions", 0, len(adjustments[2]))
+
+	expected_instr := store.Instruction{Cmd:"SET", Key:"hw:k", Args:[]string{"5"}, Timestamp:ts0}
+	for _, adjustment := range adjustments[:2] {
+		if !expected_instr.Equal(adjustment[0]) {
+			t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, adjustment[0])
+		}
+	}
+}
+
+func TestReconcileMaxPolicyNonNumeric(t *testing.T) {
+	r := setupKVStore()
+	r.RegisterReconcilePolicy("hw:", MaxPolicy)
+
+	ts0 := time.Now()
+	values := []store.Value{NewString("1", ts0), NewString("x", ts0)}
+
+	_, _, err := r.Reconcile("hw:k", values)
+	if err == nil {
+		t.Fatalf("expected reconciliation error")
+	}
+}
+
+func TestReconcileUnionPolicy(t *testing.T) {
+	r := setupKVStore()
+	r.RegisterReconcilePolicy("tags:", NewUnionPolicy(","))
+
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	values := []store.Value{NewString("b,c", ts0), NewString("a,b", ts1)}
+
+	ractual, adjustments, err := r.Reconcile("tags:k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	expected := NewString("a,b,c", ts0)
+	assertEqualValue(t, "reconciled value", expected, ractual)
+	expected_instr := store.Instruction{Cmd:"SET", Key:"tags:k", Args:[]string{"a,b,c"}, Timestamp:ts0}
+	for i, adjustment := range adjustments {
+		testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustment))
+		if !expected_instr.Equal(adjustment[0]) {
+			t.Fatalf("unexpected instruction value for %v. Expected: [%v], got: [%v]", i, expected_instr, adjustment[0])
+		}
+	}
+}
+
+// tests that a merge policy defers to last write
+// wins if the key has since been deleted
+func TestReconcileUnionPolicyDeleted(t *testing.T) {
+	r := setupKVStore()
+	r.RegisterReconcilePolicy("tags:", NewUnionPolicy(","))
+
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	expected := NewTombstone(ts0)
+	values := []store.Value{expected, NewString("a,b", ts1)}
+
+	ractual, adjustments, err := r.Reconcile("tags:k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	assertEqualValue(t, "reconciled value", expected, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 0, len(adjustments[0]))
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments[1]))
+	testing_helpers.AssertEqual(t, "instruction", "DEL", adjustments[1][0].Cmd)
+}
+
+// tests that applying the instructions returned by a merge policy
+// to each replica brings them all to the reconciled value, including
+// the replicas whose values were written at the same timestamp by
+// different nodes
+func TestReconcileMergePolicyConverges(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	writes := []store.Instruction{
+		store.Instruction{Cmd:"SET", Key:"tags:k", Args:[]string{"b,c"}, Timestamp:ts0, Origin:types.NewUUID1()},
+		store.Instruction{Cmd:"SET", Key:"tags:k", Args:[]string{"a"}, Timestamp:ts0, Origin:types.NewUUID1()},
+		store.Instruction{Cmd:"SET", Key:"tags:k", Args:[]string{"c,d"}, Timestamp:ts1, Origin:types.NewUUID1()},
+	}
+
+	replicas := make([]*KVStore, len(writes))
+	values := make([]store.Value, len(writes))
+	for i, instruction := range writes {
+		replicas[i] = setupKVStore()
+		replicas[i].RegisterReconcilePolicy("tags:", NewUnionPolicy(","))
+		if _, err := replicas[i].ExecuteInstruction(instruction); err != nil {
+			t.Fatalf("unexpected write error: %v", err)
+		}
+		val, err := replicas[i].GetRawKey("tags:k")
+		if err != nil {
+			t.Fatalf("unexpected read error: %v", err)
+		}
+		values[i] = val
+	}
+
+	reconciled, adjustments, err := replicas[0].Reconcile("tags:k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "reconciled value", "a,b,c,d", reconciled.(*String).value)
+
+	for i, replica := range replicas {
+		for _, instruction := range adjustments[i] {
+			if _, err := replica.ExecuteInstruction(instruction); err != nil {
+				t.Fatalf("unexpected adjustment error: %v", err)
+			}
+		}
+		val, err := replica.GetRawKey("tags:k")
+		if err != nil {
+			t.Fatalf("unexpected read error: %v", err)
+		}
+		assertEqualValue(t, "replica value", reconciled, val)
+		testing_helpers.AssertEqual(t, "replica origin", store.GetOrigin(reconciled), store.GetOrigin(val))
+	}
+}
@@ -3,11 +3,14 @@ package kvstore
 import (
 	"bytes"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 import (
+	"partitioner"
 	"store"
 )
 
@@ -15,12 +18,57 @@ import (
 // read instructions
 const (
 	GET		= "GET"
+	HGET	= "HGET"
+	HGETALL	= "HGETALL"
+	HLEN	= "HLEN"
+	LRANGE	= "LRANGE"
+	SISMEMBER	= "SISMEMBER"
+	SMEMBERS	= "SMEMBERS"
+	SCARD	= "SCARD"
+	ZRANGE	= "ZRANGE"
+	ZRANGEBYSCORE	= "ZRANGEBYSCORE"
+	TTL		= "TTL"
+)
+
+// multi key instructions, split into
+// single key instructions by SplitMultiKey
+const (
+	MGET	= "MGET"
+	MSET	= "MSET"
 )
 
 // write instructions
 const (
 	SET		= "SET"
 	DEL		= "DEL"
+	HSET	= "HSET"
+	HDEL	= "HDEL"
+	LPUSH	= "LPUSH"
+	RPUSH	= "RPUSH"
+	LPOP	= "LPOP"
+	SADD	= "SADD"
+	SREM	= "SREM"
+	SREMTAG	= "SREMTAG"
+	ZADD	= "ZADD"
+	ZREM	= "ZREM"
+	INCR	= "INCR"
+	DECR	= "DECR"
+	INCRBY	= "INCRBY"
+	DECRBY	= "DECRBY"
+	CSHARD	= "CSHARD"
+	CINCRBY	= "CINCRBY"
+	EXPIRE	= "EXPIRE"
+	PEXPIREAT	= "PEXPIREAT"
+	PERSIST	= "PERSIST"
+	SETEX	= "SETEX"
+)
+
+// conditional write instructions, which read the value they're
+// executed against, and can only be executed through consensus
+const (
+	SETNX	= "SETNX"
+	GETSET	= "GETSET"
+	CAS		= "CAS"
 )
 
 
@@ -28,6 +76,19 @@ type KVStore struct {
 
 	data map[string] store.Value
 
+	// key prefix -> reconcile policy
+	policies *store.ReconcileRegistry
+
+	// closed to stop the expiry sweeper and tombstone gc
+	stop chan bool
+
+	// purges old tombstones, guarded by lock
+	gc store.TombstoneGC
+
+	// token ordered index of the keys, built by the first
+	// iteration over the keys, and guarded by lock
+	index *store.KeyIndex
+
 	// TODO: delete
 	// temporary lock, used until
 	// things are broken out into
@@ -41,6 +102,8 @@ var _ = store.Store(&KVStore{})
 func NewKVStore() *KVStore {
 	r := &KVStore{
 		data:make(map[string] store.Value),
+		policies:store.NewReconcileRegistry(),
+		gc:store.NewTombstoneGC(),
 	}
 	return r
 }
@@ -58,11 +121,23 @@ func (s *KVStore) DeserializeValue(b []byte) (store.Value, store.ValueType, erro
 	return val, vtype, nil
 }
 
+// starts the sweeper that replaces expired values with
+// tombstones, and the gc that purges old tombstones
 func (s *KVStore) Start() error {
+	if s.stop != nil {
+		return fmt.Errorf("KVStore already started")
+	}
+	s.stop = make(chan bool)
+	go s.sweepLoop(s.stop, EXPIRY_SWEEP_INTERVAL)
+	go store.RunGC(s.stop, store.GC_INTERVAL, func() { s.purgeTombstones(now()) })
 	return nil
 }
 
 func (s *KVStore) Stop() error {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
 	return nil
 }
 
@@ -74,6 +149,11 @@ func (s *KVStore) ExecuteInstruction(instruction store.Instruction) (store.Value
 	key := instruction.Key
 	args := instruction.Args
 	timestamp := instruction.Timestamp
+	origin := instruction.Origin
+
+	if isInternalCommand(cmd) && !instruction.Internal {
+		return nil, fmt.Errorf("%v is an internal command, and can't be executed by clients", cmd)
+	}
 
 	switch cmd {
 	case GET:
@@ -84,10 +164,133 @@ func (s *KVStore) ExecuteInstruction(instruction store.Instruction) (store.Value
 		return rval, nil
 	case SET:
 		if err := s.validateSet(key, args, timestamp); err != nil { return nil, err }
-		return s.set(key, args[0], timestamp), nil
+		if len(args) > 1 {
+			return s.setCond(key, args[0], args[1], timestamp, origin), nil
+		}
+		return s.set(key, args[0], timestamp, origin), nil
+	case SETNX:
+		if err := s.validateSetNX(key, args, timestamp); err != nil { return nil, err }
+		return s.setCond(key, args[0], NX, timestamp, origin), nil
+	case GETSET:
+		if err := s.validateGetSet(key, args, timestamp); err != nil { return nil, err }
+		return s.getset(key, args[0], timestamp, origin), nil
+	case CAS:
+		if err := s.validateCAS(key, args, timestamp); err != nil { return nil, err }
+		return s.cas(key, args[0], args[1], timestamp, origin)
 	case DEL:
 		if err := s.validateDel(key, args, timestamp); err != nil { return nil, err }
-		return s.del(key, timestamp)
+		return s.del(key, timestamp, origin)
+	case HGET:
+		if err := s.validateHGet(key, args); err != nil { return nil, err }
+		return s.hget(key, args[0])
+	case HGETALL:
+		if err := s.validateHGetAll(key, args); err != nil { return nil, err }
+		return s.hgetall(key)
+	case HLEN:
+		if err := s.validateHLen(key, args); err != nil { return nil, err }
+		rval, err := s.hlen(key)
+		if err != nil { return nil, err }
+		return rval, nil
+	case HSET:
+		if err := s.validateHSet(key, args, timestamp); err != nil { return nil, err }
+		rval, err := s.hset(key, args, timestamp, origin)
+		if err != nil { return nil, err }
+		return rval, nil
+	case HDEL:
+		if err := s.validateHDel(key, args, timestamp); err != nil { return nil, err }
+		rval, err := s.hdel(key, args, timestamp, origin)
+		if err != nil { return nil, err }
+		return rval, nil
+	case LRANGE:
+		if err := s.validateLRange(key, args); err != nil { return nil, err }
+		start, _ := strconv.Atoi(args[0])
+		stop, _ := strconv.Atoi(args[1])
+		return s.lrange(key, start, stop)
+	case LPUSH:
+		if err := s.validatePush(cmd, key, args, timestamp); err != nil { return nil, err }
+		rval, err := s.lpush(key, args, timestamp)
+		if err != nil { return nil, err }
+		return rval, nil
+	case RPUSH:
+		if err := s.validatePush(cmd, key, args, timestamp); err != nil { return nil, err }
+		rval, err := s.rpush(key, args, timestamp)
+		if err != nil { return nil, err }
+		return rval, nil
+	case LPOP:
+		if err := s.validateLPop(key, args, timestamp); err != nil { return nil, err }
+		return s.lpop(key, timestamp)
+	case SISMEMBER:
+		if err := s.validateSIsMember(key, args); err != nil { return nil, err }
+		return s.sismember(key, args[0])
+	case SMEMBERS:
+		if err := s.validateSMembers(key, args); err != nil { return nil, err }
+		return s.smembers(key)
+	case SCARD:
+		if err := s.validateSCard(key, args); err != nil { return nil, err }
+		rval, err := s.scard(key)
+		if err != nil { return nil, err }
+		return rval, nil
+	case SADD:
+		if err := s.validateSetMembers(cmd, key, args, timestamp); err != nil { return nil, err }
+		rval, err := s.sadd(key, args, timestamp, origin)
+		if err != nil { return nil, err }
+		return rval, nil
+	case SREM:
+		if err := s.validateSetMembers(cmd, key, args, timestamp); err != nil { return nil, err }
+		rval, err := s.srem(key, args, timestamp, origin)
+		if err != nil { return nil, err }
+		return rval, nil
+	case SREMTAG:
+		if err := s.validateSRemTag(key, args, timestamp); err != nil { return nil, err }
+		return nil, s.sremtag(key, args[0], timestamp, origin)
+	case ZRANGE:
+		if err := s.validateZRange(key, args); err != nil { return nil, err }
+		start, _ := strconv.Atoi(args[0])
+		stop, _ := strconv.Atoi(args[1])
+		return s.zrange(key, start, stop)
+	case ZRANGEBYSCORE:
+		if err := s.validateZRangeByScore(key, args); err != nil { return nil, err }
+		min, _ := parseScoreBound(args[0])
+		max, _ := parseScoreBound(args[1])
+		return s.zrangebyscore(key, min, max)
+	case ZADD:
+		if err := s.validateZAdd(key, args, timestamp); err != nil { return nil, err }
+		rval, err := s.zadd(key, args, timestamp, origin)
+		if err != nil { return nil, err }
+		return rval, nil
+	case ZREM:
+		if err := s.validateZRem(key, args, timestamp); err != nil { return nil, err }
+		rval, err := s.zrem(key, args, timestamp, origin)
+		if err != nil { return nil, err }
+		return rval, nil
+	case INCR, DECR, INCRBY, DECRBY:
+		if err := s.validateIncr(cmd, key, args, timestamp); err != nil { return nil, err }
+		return s.incrby(key, incrementDelta(cmd, args), CONSENSUS_SHARD, timestamp)
+	case CINCRBY:
+		if err := s.validateCIncrBy(key, args, timestamp); err != nil { return nil, err }
+		delta, _ := strconv.ParseInt(args[0], 10, 64)
+		return s.incrby(key, delta, args[1], timestamp)
+	case CSHARD:
+		if err := s.validateCShard(key, args, timestamp); err != nil { return nil, err }
+		return s.cshard(key, args, timestamp)
+	case EXPIRE:
+		if err := s.validateExpire(cmd, key, args, timestamp); err != nil { return nil, err }
+		seconds, _ := strconv.ParseInt(args[0], 10, 64)
+		return s.expire(key, secondsDeadline(timestamp, seconds), timestamp)
+	case PEXPIREAT:
+		if err := s.validateExpire(cmd, key, args, timestamp); err != nil { return nil, err }
+		millis, _ := strconv.ParseInt(args[0], 10, 64)
+		return s.expire(key, millisDeadline(millis), timestamp)
+	case PERSIST:
+		if err := s.validatePersist(key, args, timestamp); err != nil { return nil, err }
+		return s.persist(key, timestamp)
+	case SETEX:
+		if err := s.validateSetEx(key, args, timestamp); err != nil { return nil, err }
+		seconds, _ := strconv.ParseInt(args[0], 10, 64)
+		return s.setex(key, args[1], secondsDeadline(timestamp, seconds), timestamp, origin), nil
+	case TTL:
+		if err := s.validateTTL(key, args); err != nil { return nil, err }
+		return s.ttl(key)
 	default:
 		return nil, fmt.Errorf("Unrecognized write command: %v", cmd)
 	}
@@ -113,45 +316,179 @@ func (s *KVStore) Reconcile(key string, values []store.Value) (store.Value, [][]
 		for _, v := range values { val = v }
 		return val, nil, nil
 	default:
-		highValue := getHighValue(values)
-
-		switch highValue.GetValueType() {
-		case STRING_VALUE:
-			return reconcileString(key, highValue.(*String), values)
-		case TOMBSTONE_VALUE:
-			return reconcileTombstone(key, highValue.(*Tombstone), values)
-		default:
-			return nil, [][]store.Instruction{}, fmt.Errorf("Unknown value type: %T", highValue)
+		// expired values are reconciled as deletions made at their deadline,
+		// and the expiries of the others are reconciled separately, after
+		// their contents have been
+		at := now()
+		live := make([]store.Value, len(values))
+		contents := make([]store.Value, len(values))
+		for i, val := range values {
+			live[i] = expireValue(val, at)
+			contents[i] = withoutExpiry(live[i])
+		}
+
+		reconcile := reconcileLastWriteWins
+		if s.policies != nil {
+			if policy := s.policies.Get(key); policy != nil {
+				reconcile = policy.Reconcile
+			}
+		}
+		val, instructions, err := reconcile(key, contents)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(instructions) != len(values) {
+			return nil, nil, fmt.Errorf("Expected %v sets of instructions, got %v", len(values), len(instructions))
 		}
+		val, instructions = reconcileExpiry(key, val, live, instructions)
+		return val, instructions, nil
 	}
 	return nil, [][]store.Instruction{}, nil
 }
 
+// registers a policy used to reconcile keys beginning with the given
+// prefix. Keys without a registered policy are reconciled with
+// LastWriteWins
+func (s *KVStore) RegisterReconcilePolicy(prefix string, policy store.ReconcilePolicy) {
+	s.policies.Register(prefix, policy)
+}
+
 func (s *KVStore) IsReadOnly(instruction store.Instruction) bool {
 	switch strings.ToUpper(instruction.Cmd) {
-	case GET:
+	case GET, HGET, HGETALL, HLEN, LRANGE, SISMEMBER, SMEMBERS, SCARD, ZRANGE, ZRANGEBYSCORE, TTL:
+		return true
+	}
+	return false
+}
+
+// returns true if the command is only used to replicate and reconcile
+// values. Instructions using them are only executed if they're internal
+func isInternalCommand(cmd string) bool {
+	switch strings.ToUpper(cmd) {
+	case SREMTAG, CSHARD, CINCRBY:
 		return true
 	}
 	return false
 }
 
+// conditional writes, and LPOP, read the value they're executing
+// against, so they're neither reads nor write only. Internal commands
+// are only writes if the instruction is internal, so clients can't
+// issue them
 func (s *KVStore) IsWriteOnly(instruction store.Instruction) bool {
+	if isConditional(instruction) {
+		return false
+	}
+	if isInternalCommand(instruction.Cmd) && !instruction.Internal {
+		return false
+	}
 	switch strings.ToUpper(instruction.Cmd) {
-	case SET, DEL:
+	case SET, DEL, HSET, HDEL, LPUSH, RPUSH, SADD, SREM, SREMTAG, ZADD, ZREM, INCR, DECR, INCRBY, DECRBY, CSHARD, CINCRBY, EXPIRE, PEXPIREAT, PERSIST, SETEX:
 		return true
 	}
 	return false
 }
 
+// list mutations and conditional writes depend on the order they're
+// executed in, so they can't be reconciled with last write wins
+func (s *KVStore) RequiresConsensus(instruction store.Instruction) bool {
+	if isConditional(instruction) {
+		return true
+	}
+	switch strings.ToUpper(instruction.Cmd) {
+	case LPUSH, RPUSH, LPOP:
+		return true
+	}
+	return false
+}
+
+// increments can't be replicated by executing them on every replica,
+// since each replica would add the increment to it's own shard. Instead,
+// a single leader replica adds it to it's shard with a CINCRBY instruction,
+// and the shard is replicated to the others with a CSHARD instruction.
+// Clients can't choose the shard, INCR and friends always use the
+// consensus shard when executed directly
+func (s *KVStore) LeaderInstruction(instruction store.Instruction, leader string) (store.Instruction, bool) {
+	cmd := strings.ToUpper(instruction.Cmd)
+	switch cmd {
+	case INCR, DECR, INCRBY, DECRBY:
+		if err := s.validateIncr(cmd, instruction.Key, instruction.Args, instruction.Timestamp); err != nil {
+			// leave invalid instructions to fail validation
+			return instruction, false
+		}
+		delta := incrementDelta(cmd, instruction.Args)
+		args := []string{strconv.FormatInt(delta, 10), leader}
+		return store.NewInternalInstruction(CINCRBY, instruction.Key, args, instruction.Timestamp), true
+	}
+	return instruction, false
+}
+
+func (s *KVStore) ReplicationInstruction(instruction store.Instruction, leader string, result store.Value) (store.Instruction, error) {
+	counter, ok := result.(*Counter)
+	if !ok {
+		return store.Instruction{}, fmt.Errorf("Expected counter result from leader, got %T", result)
+	}
+	shard, exists := counter.shards[leader]
+	if !exists {
+		return store.Instruction{}, fmt.Errorf("Leader shard [%v] not found in result", leader)
+	}
+	return counterShardInstruction(instruction.Key, leader, shard, counter.created, instruction.Timestamp), nil
+}
+
 func (s *KVStore) ReturnsValue(cmd string) bool {
 	switch strings.ToUpper(cmd) {
-	case GET:
+	case GET, HGET, HGETALL, HLEN, LRANGE, LPOP, SISMEMBER, SMEMBERS, SCARD, ZRANGE, ZRANGEBYSCORE, INCR, DECR, INCRBY, DECRBY, TTL, SETNX, GETSET, CAS:
 		return true
 	}
 	return false
 }
 
+// MGET and MSET are split into GET and SET instructions for each key, and
+// DEL is split into a DEL for each key when it's given a list of keys
+func (s *KVStore) SplitMultiKey(cmd string, args []string, timestamp time.Time) ([]store.Instruction, bool, error) {
+	switch strings.ToUpper(cmd) {
+	case MGET:
+		if err := s.validateMGet(args); err != nil { return nil, true, err }
+		return splitKeys(GET, args, time.Time{}), true, nil
+	case DEL:
+		if err := s.validateMDel(args); err != nil { return nil, true, err }
+		return splitKeys(DEL, args, timestamp), true, nil
+	case MSET:
+		if err := s.validateMSet(args); err != nil { return nil, true, err }
+		instructions := make([]store.Instruction, 0, len(args) / 2)
+		for i:=0; i<len(args); i+=2 {
+			instructions = append(instructions, store.NewInstruction(SET, args[i], []string{args[i+1]}, timestamp))
+		}
+		return instructions, true, nil
+	}
+	return nil, false, nil
+}
+
+// hash instructions operating on a single field interfere
+// with the hash's key, and the field, so instructions operating
+// on different fields of the same hash don't interfere with
+// each other, but do interfere with instructions operating
+// on the whole hash. Set and sorted set instructions operating
+// on a single member are treated the same way
 func (s *KVStore) InterferingKeys(instruction store.Instruction) []string {
+	switch strings.ToUpper(instruction.Cmd) {
+	case HGET, SISMEMBER, SREMTAG:
+		if len(instruction.Args) > 0 {
+			return []string{instruction.Key, instruction.Args[0]}
+		}
+	case HSET:
+		if len(instruction.Args) == 2 {
+			return []string{instruction.Key, instruction.Args[0]}
+		}
+	case HDEL, SADD, SREM, ZREM:
+		if len(instruction.Args) == 1 {
+			return []string{instruction.Key, instruction.Args[0]}
+		}
+	case ZADD:
+		if len(instruction.Args) == 2 {
+			return []string{instruction.Key, instruction.Args[1]}
+		}
+	}
 	return []string{instruction.Key}
 }
 
@@ -160,6 +497,8 @@ func (s *KVStore) InterferingKeys(instruction store.Instruction) []string {
 
 // blindly gets the contents of the given key
 func (s *KVStore) GetRawKey(key string) (store.Value, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	val, ok := s.data[key]
 	if !ok {
 		return nil, fmt.Errorf("key [%v] does not exist", key)
@@ -169,13 +508,25 @@ func (s *KVStore) GetRawKey(key string) (store.Value, error) {
 
 // blindly sets the contents of the given key
 func (s *KVStore) SetRawKey(key string, val store.Value) error {
-	s.data[key] = val
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.setValue(key, val)
 	return nil
 }
 
+// stores the value at the given key, indexing the key if it's new
+func (s *KVStore) setValue(key string, val store.Value) {
+	if _, exists := s.data[key]; !exists {
+		s.index.Add(key)
+	}
+	s.data[key] = val
+}
+
 // returns all of the keys held by the store, including keys containing
 // tombstones
 func (s *KVStore) GetKeys() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	keys := make([]string, 0, len(s.data))
 	for key := range s.data {
 		keys = append(keys, key)
@@ -183,7 +534,52 @@ func (s *KVStore) GetKeys() []string {
 	return keys
 }
 
+// iterates over the keys a page at a time, seeking to the iterator's position
+// in the key index. Each page is read under the read lock, and holds copies
+// of the values, so concurrent writes are seen if they're ahead of the iterator
+//
+// the index is kept for the type of partitioner the keys were last iterated
+// with, and rebuilt if they're iterated with another
+func (s *KVStore) IterateKeys(p partitioner.Partitioner, start partitioner.Token) store.Iterator {
+	s.lock.Lock()
+	if !s.index.IndexedBy(p) {
+		s.index = store.IndexKeys(p, s.data)
+	}
+	s.lock.Unlock()
+
+	return store.NewIterator(start, store.ITERATOR_PAGE_SIZE, func(read store.PageRead) {
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+		read(s.index, s.copyValue)
+	})
+}
+
+// returns a copy of the value stored at the given key, so it can be
+// used after the lock is released. Values are copied by serializing
+// them, which can only fail for values the store didn't create
+func (s *KVStore) copyValue(key string) (store.Value, bool) {
+	val, exists := s.data[key]
+	if !exists {
+		return nil, false
+	}
+	b, err := s.SerializeValue(val)
+	if err != nil {
+		panic(fmt.Sprintf("error copying value at key [%v]: %v", key, err))
+	}
+	val, _, err = s.DeserializeValue(b)
+	if err != nil {
+		panic(fmt.Sprintf("error copying value at key [%v]: %v", key, err))
+	}
+	return val, true
+}
+
 func (s *KVStore) KeyExists(key string) bool {
 	_, ok := s.data[key]
 	return ok
 }
+
+// tombstones, and values that have expired but
+// haven't been swept yet, are treated as deleted
+func (s *KVStore) IsDeleted(val store.Value) bool {
+	return expireValue(val, now()).GetValueType() == TOMBSTONE_VALUE
+}
@@ -1,9 +1,12 @@
 package kvstore
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 	"time"
 
+	"partitioner"
 	"testing_helpers"
 	"store"
 )
@@ -17,12 +20,41 @@ var isWrite = []struct {
 	{"GET", false},
 	{"SET", true},
 	{"DEL", true},
+	{"HGET", false},
+	{"HGETALL", false},
+	{"HLEN", false},
+	{"HSET", true},
+	{"HDEL", true},
+	{"LRANGE", false},
+	{"LPUSH", true},
+	{"RPUSH", true},
+	{"SISMEMBER", false},
+	{"SMEMBERS", false},
+	{"SCARD", false},
+	{"SADD", true},
+	{"SREM", true},
+	{"SREMTAG", true},
+	{"ZRANGE", false},
+	{"ZRANGEBYSCORE", false},
+	{"ZADD", true},
+	{"ZREM", true},
+	{"INCR", true},
+	{"DECR", true},
+	{"INCRBY", true},
+	{"DECRBY", true},
+	{"CSHARD", true},
+	{"CINCRBY", true},
+	{"EXPIRE", true},
+	{"PEXPIREAT", true},
+	{"PERSIST", true},
+	{"SETEX", true},
+	{"TTL", false},
 }
 
 func TestIsWriteCmd(t *testing.T) {
 	r := &KVStore{}
 	for _, c := range isWrite {
-		if result := r.IsWriteOnly(store.Instruction{Cmd:c.cmd}); result != c.result {
+		if result := r.IsWriteOnly(store.Instruction{Cmd:c.cmd, Internal:true}); result != c.result {
 			if result {
 				t.Errorf("%v erroneously identified as a write", c.cmd)
 			} else {
@@ -45,6 +77,54 @@ func TestIsReadCmd(t *testing.T) {
 	}
 }
 
+// tests that the internal commands used to replicate and
+// reconcile values are refused when clients issue them
+func TestClientInternalCommands(t *testing.T) {
+	r := setupKVStore()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("SADD", "s", []string{"a"}, ts0))
+
+	var internal = []store.Instruction{
+		store.NewInstruction("CSHARD", "c", []string{"n1", "5", "0", fmt.Sprint(ts0.UnixNano()), fmt.Sprint(ts0.UnixNano())}, ts0),
+		store.NewInstruction("CINCRBY", "c", []string{"5", "n1"}, ts0),
+		store.NewInstruction("SREMTAG", "s", []string{"a"}, ts0),
+	}
+	for _, instruction := range internal {
+		if r.IsWriteOnly(instruction) {
+			t.Errorf("Client %v erroneously identified as a write", instruction.Cmd)
+		}
+		val, err := r.ExecuteInstruction(instruction)
+		if val != nil || err == nil {
+			t.Errorf("Expected client %v to be refused, got %v, %v", instruction.Cmd, val, err)
+		}
+
+		instruction.Internal = true
+		if !r.IsWriteOnly(instruction) {
+			t.Errorf("Internal %v not identified as a write", instruction.Cmd)
+		}
+	}
+	testing_helpers.AssertEqual(t, "counter exists", false, r.KeyExists("c"))
+	testing_helpers.AssertEqual(t, "member present", true, r.data["s"].(*Set).members["a"].isPresent())
+}
+
+// tests that hash field instructions interfere
+// with the hash key, and the field
+func TestInterferingKeys(t *testing.T) {
+	r := &KVStore{}
+	testing_helpers.AssertStringArrayEqual(t, "SET", []string{"a"}, r.InterferingKeys(store.Instruction{Cmd:"SET", Key:"a", Args:[]string{"b"}}))
+	testing_helpers.AssertStringArrayEqual(t, "HSET", []string{"a", "f"}, r.InterferingKeys(store.Instruction{Cmd:"HSET", Key:"a", Args:[]string{"f", "b"}}))
+	testing_helpers.AssertStringArrayEqual(t, "HGET", []string{"a", "f"}, r.InterferingKeys(store.Instruction{Cmd:"HGET", Key:"a", Args:[]string{"f"}}))
+	testing_helpers.AssertStringArrayEqual(t, "HDEL", []string{"a", "f"}, r.InterferingKeys(store.Instruction{Cmd:"HDEL", Key:"a", Args:[]string{"f"}}))
+	testing_helpers.AssertStringArrayEqual(t, "HSET multiple", []string{"a"}, r.InterferingKeys(store.Instruction{Cmd:"HSET", Key:"a", Args:[]string{"f", "b", "g", "c"}}))
+	testing_helpers.AssertStringArrayEqual(t, "HDEL multiple", []string{"a"}, r.InterferingKeys(store.Instruction{Cmd:"HDEL", Key:"a", Args:[]string{"f", "g"}}))
+	testing_helpers.AssertStringArrayEqual(t, "HGETALL", []string{"a"}, r.InterferingKeys(store.Instruction{Cmd:"HGETALL", Key:"a"}))
+	testing_helpers.AssertStringArrayEqual(t, "SADD", []string{"a", "m"}, r.InterferingKeys(store.Instruction{Cmd:"SADD", Key:"a", Args:[]string{"m"}}))
+	testing_helpers.AssertStringArrayEqual(t, "SADD multi", []string{"a"}, r.InterferingKeys(store.Instruction{Cmd:"SADD", Key:"a", Args:[]string{"m", "n"}}))
+	testing_helpers.AssertStringArrayEqual(t, "SISMEMBER", []string{"a", "m"}, r.InterferingKeys(store.Instruction{Cmd:"SISMEMBER", Key:"a", Args:[]string{"m"}}))
+	testing_helpers.AssertStringArrayEqual(t, "ZADD", []string{"a", "m"}, r.InterferingKeys(store.Instruction{Cmd:"ZADD", Key:"a", Args:[]string{"1", "m"}}))
+	testing_helpers.AssertStringArrayEqual(t, "SMEMBERS", []string{"a"}, r.InterferingKeys(store.Instruction{Cmd:"SMEMBERS", Key:"a"}))
+}
+
 // ----------- data import / export -----------
 
 func TestGetRawKeySuccess(t *testing.T) {
@@ -106,6 +186,87 @@ func TestGetKeys(t *testing.T) {
 	}
 }
 
+// tests that keys, including tombstones, are iterated in token order
+func TestIterateKeys(t *testing.T) {
+	r := setupKVStore()
+	ts := time.Unix(100000, 0)
+	p := partitioner.NewMD5Partitioner()
+	for i:=0; i<20; i++ {
+		r.SetRawKey(fmt.Sprint(i), NewBoolean(true, ts))
+	}
+	r.SetRawKey("20", NewTombstone(ts))
+
+	num := 0
+	var last partitioner.Token
+	iter := r.IterateKeys(p, nil)
+	for iter.Next() {
+		num++
+		testing_helpers.AssertSliceEqual(t, "token", p.GetToken(iter.Key()), iter.Token())
+		if bytes.Compare(last, iter.Token()) >= 0 {
+			t.Errorf("Key '%v' is out of token order", iter.Key())
+		}
+		last = iter.Token()
+		assertEqualValue(t, "value", r.data[iter.Key()], iter.Value())
+	}
+	testing_helpers.AssertEqual(t, "num keys", 21, num)
+}
+
+// tests that iterators return copies of values, so they can
+// be used while the values are modified by other writes
+func TestIterateKeysCopiesValues(t *testing.T) {
+	r := setupKVStore()
+	ts := time.Unix(100000, 0)
+	r.ExecuteInstruction(store.NewInstruction("HSET", "h", []string{"a", "1"}, ts))
+
+	iter := r.IterateKeys(partitioner.NewMD5Partitioner(), nil)
+	testing_helpers.AssertEqual(t, "next", true, iter.Next())
+	hash := iter.Value().(*Hash)
+	r.ExecuteInstruction(store.NewInstruction("HSET", "h", []string{"a", "2", "b", "3"}, ts.Add(time.Second)))
+
+	val, _ := hash.GetField("a")
+	testing_helpers.AssertEqual(t, "a", "1", val)
+	testing_helpers.AssertEqual(t, "num fields", 1, hash.Len())
+}
+
+// tests that keys written, and purged, after the keys are
+// first iterated are added to, and removed from, the index
+func TestIterateKeysIndexMaintained(t *testing.T) {
+	r := setupKVStore()
+	r.SetGCGrace(time.Hour)
+	ts := time.Unix(100000, 0)
+	p := partitioner.NewMD5Partitioner()
+	r.ExecuteInstruction(store.NewInstruction("SET", "a", []string{"1"}, ts))
+	r.ExecuteInstruction(store.NewInstruction("DEL", "a", []string{}, ts))
+	testing_helpers.AssertEqual(t, "num keys", 1, len(iterateKeys(r.IterateKeys(p, nil))))
+
+	r.ExecuteInstruction(store.NewInstruction("SET", "b", []string{"1"}, ts))
+	r.purgeTombstones(ts.Add(2 * time.Hour))
+	testing_helpers.AssertStringArrayEqual(t, "keys", []string{"b"}, iterateKeys(r.IterateKeys(p, nil)))
+	testing_helpers.AssertEqual(t, "index size", 1, r.index.Len())
+}
+
+// tests that the index is kept when the keys are iterated
+// with another partitioner of the same type
+func TestIterateKeysIndexReused(t *testing.T) {
+	r := setupKVStore()
+	r.SetRawKey("a", NewBoolean(true, time.Unix(100000, 0)))
+	iterateKeys(r.IterateKeys(partitioner.NewMD5Partitioner(), nil))
+	index := r.index
+
+	iterateKeys(r.IterateKeys(partitioner.NewMD5Partitioner(), nil))
+	testing_helpers.AssertEqual(t, "index", true, index == r.index)
+	iterateKeys(r.IterateKeys(partitioner.MD5Partitioner{}, nil))
+	testing_helpers.AssertEqual(t, "index", false, index == r.index)
+}
+
+func iterateKeys(iter store.Iterator) []string {
+	keys := make([]string, 0)
+	for iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	return keys
+}
+
 func TestKeyExists(t *testing.T) {
 	r := setupKVStore()
 	val := NewBoolean(true, time.Now())
@@ -0,0 +1,301 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"serializer"
+	"store"
+)
+
+// the shard incremented by instructions executed through consensus. Since
+// every replica executes them in the same order, they can share a shard
+const CONSENSUS_SHARD = ""
+
+// the increment and decrement totals of a single shard
+type counterShard struct {
+	incr int64
+	decr int64
+	// time of the shard's last update
+	time time.Time
+}
+
+func (s *counterShard) equal(o *counterShard) bool {
+	return s.incr == o.incr && s.decr == o.decr && s.time.Equal(o.time)
+}
+
+// a conflict free counter, implemented as a PN-counter
+//
+// the increments and decrements made by each replica are kept in their
+// own shard, and only ever grow. Since a shard is only written to by
+// it's own replica, copies of it are reconciled by keeping the one
+// with the largest totals, and the counter's value is the sum of all
+// of it's shards.
+//
+// If the key is deleted, a new counter is created by the next increment,
+// and the creation time distinguishes it's shards from the old counter's
+type Counter struct {
+	expiry
+
+	created time.Time
+	// shard id -> shard
+	shards map[string]*counterShard
+}
+
+func NewCounter(created time.Time) *Counter {
+	return &Counter{created: created, shards: make(map[string]*counterShard)}
+}
+
+// returns the counter's value
+func (v *Counter) GetValue() int64 {
+	var val int64
+	for _, shard := range v.shards {
+		val += shard.incr - shard.decr
+	}
+	return val
+}
+
+// returns the time of the most recent update
+func (v *Counter) GetTimestamp() time.Time {
+	ts := v.created
+	for _, shard := range v.shards {
+		if shard.time.After(ts) {
+			ts = shard.time
+		}
+	}
+	return ts
+}
+
+func (v *Counter) GetValueType() store.ValueType {
+	return COUNTER_VALUE
+}
+
+func (v *Counter) Equal(o store.Value) bool {
+	other, ok := o.(*Counter)
+	if !ok { return false }
+	if !v.created.Equal(other.created) { return false }
+	if len(v.shards) != len(other.shards) { return false }
+	for id, shard := range v.shards {
+		otherShard, exists := other.shards[id]
+		if !exists || !shard.equal(otherShard) { return false }
+	}
+	if !v.expiryEqual(&other.expiry) { return false }
+	return true
+}
+
+func (v *Counter) withExpiry(e expiry) store.Value {
+	c := copyCounter(v)
+	c.expiry = e
+	return c
+}
+
+func (v *Counter) getShard(id string) *counterShard {
+	shard, exists := v.shards[id]
+	if !exists {
+		shard = &counterShard{}
+		v.shards[id] = shard
+	}
+	return shard
+}
+
+// adds delta to the given shard
+func (v *Counter) add(id string, delta int64, ts time.Time) {
+	shard := v.getShard(id)
+	if delta < 0 {
+		shard.decr -= delta
+	} else {
+		shard.incr += delta
+	}
+	if ts.After(shard.time) {
+		shard.time = ts
+	}
+}
+
+// merges the given shard totals into the shard with the given id
+func (v *Counter) mergeShard(id string, other *counterShard) {
+	shard := v.getShard(id)
+	if other.incr > shard.incr {
+		shard.incr = other.incr
+	}
+	if other.decr > shard.decr {
+		shard.decr = other.decr
+	}
+	if other.time.After(shard.time) {
+		shard.time = other.time
+	}
+}
+
+func (v *Counter) sortedShards() []string {
+	ids := make([]string, 0, len(v.shards))
+	for id := range v.shards {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (v *Counter) Serialize(buf *bufio.Writer) error {
+	if err := serializer.WriteTime(buf, v.created); err != nil {
+		return err
+	}
+	numShards := uint32(len(v.shards))
+	if err := binary.Write(buf, binary.LittleEndian, &numShards); err != nil {
+		return err
+	}
+	for _, id := range v.sortedShards() {
+		shard := v.shards[id]
+		if err := serializer.WriteFieldString(buf, id); err != nil {
+			return err
+		}
+		if err := binary.Write(buf, binary.LittleEndian, &shard.incr); err != nil {
+			return err
+		}
+		if err := binary.Write(buf, binary.LittleEndian, &shard.decr); err != nil {
+			return err
+		}
+		if err := serializer.WriteTime(buf, shard.time); err != nil {
+			return err
+		}
+	}
+	if err := v.serializeExpiry(buf); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (v *Counter) Deserialize(buf *bufio.Reader) error {
+	var err error
+	if v.created, err = serializer.ReadTime(buf); err != nil {
+		return err
+	}
+	var numShards uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numShards); err != nil {
+		return err
+	}
+	v.shards = make(map[string]*counterShard, numShards)
+	for i:=0; i<int(numShards); i++ {
+		id, err := serializer.ReadFieldString(buf)
+		if err != nil {
+			return err
+		}
+		shard := &counterShard{}
+		if err := binary.Read(buf, binary.LittleEndian, &shard.incr); err != nil {
+			return err
+		}
+		if err := binary.Read(buf, binary.LittleEndian, &shard.decr); err != nil {
+			return err
+		}
+		if shard.time, err = serializer.ReadTime(buf); err != nil {
+			return err
+		}
+		v.shards[id] = shard
+	}
+	if err := v.deserializeExpiry(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+// returns the instruction that merges the given shard into the copies
+// of the counter held by other replicas. The instruction is timestamped
+// with ts, so it isn't ignored by replicas that hold tombstones older
+// than the counter
+func counterShardInstruction(key string, id string, shard *counterShard, created time.Time, ts time.Time) store.Instruction {
+	args := []string{
+		id,
+		strconv.FormatInt(shard.incr, 10),
+		strconv.FormatInt(shard.decr, 10),
+		strconv.FormatInt(shard.time.UnixNano(), 10),
+		strconv.FormatInt(created.UnixNano(), 10),
+	}
+	return store.NewInternalInstruction(CSHARD, key, args, ts)
+}
+
+// merges the shards of the most recently created counters, keeping the
+// largest totals for each shard
+//
+// the consensus shard is included in the reconciled value, but isn't
+// corrected, since replicas that haven't executed the instructions that
+// incremented it will execute them once consensus has committed them
+func reconcileCounter(key string, values []store.Value) (*Counter, [][]store.Instruction, error) {
+	var created time.Time
+	var resetTime time.Time
+	for _, val := range values {
+		if counter, isCounter := val.(*Counter); isCounter {
+			if counter.created.After(created) {
+				created = counter.created
+			}
+		} else if ts := val.GetTimestamp(); ts.After(resetTime) {
+			resetTime = ts
+		}
+	}
+
+	merged := NewCounter(created)
+	for _, val := range values {
+		if counter, isCounter := val.(*Counter); isCounter && counter.created.Equal(created) {
+			for id, shard := range counter.shards {
+				merged.mergeShard(id, shard)
+			}
+		}
+	}
+	ts := merged.GetTimestamp()
+
+	// create instructions for the unequal nodes
+	instructions := make([][]store.Instruction, len(values))
+	ids := merged.sortedShards()
+	for i, val := range values {
+		if merged.Equal(val) {
+			continue
+		}
+
+		var existing *Counter
+		nodeInstructions := make([]store.Instruction, 0, len(ids) + 1)
+		switch val := val.(type) {
+		case *Counter:
+			if val.created.Equal(created) {
+				existing = val
+			}
+		case *Tombstone:
+		default:
+			nodeInstructions = append(nodeInstructions, store.NewInstruction(DEL, key, []string{}, resetTime))
+		}
+
+		for _, id := range ids {
+			if id == CONSENSUS_SHARD {
+				continue
+			}
+			shard := merged.shards[id]
+			if existing != nil {
+				if existingShard, exists := existing.shards[id]; exists && existingShard.equal(shard) {
+					continue
+				}
+			}
+			nodeInstructions = append(nodeInstructions, counterShardInstruction(key, id, shard, created, ts))
+		}
+		instructions[i] = nodeInstructions
+	}
+
+	return merged, instructions, nil
+}
+
+// parses the args of a CSHARD instruction
+func parseCounterShard(args []string) (id string, shard *counterShard, created time.Time, err error) {
+	if len(args) != 5 {
+		return "", nil, time.Time{}, fmt.Errorf("incorrect number of args for CSHARD. Expected 5, got %v", len(args))
+	}
+	nums := make([]int64, 4)
+	for i := range nums {
+		if nums[i], err = strconv.ParseInt(args[i+1], 10, 64); err != nil {
+			return "", nil, time.Time{}, fmt.Errorf("CSHARD arg is not an integer: %v", args[i+1])
+		}
+	}
+	shard = &counterShard{incr: nums[0], decr: nums[1], time: time.Unix(0, nums[2])}
+	return args[0], shard, time.Unix(0, nums[3]), nil
+}
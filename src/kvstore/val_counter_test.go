@@ -0,0 +1,138 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+
+	"testing_helpers"
+	"store"
+)
+
+// returns a counter with the given shard totals, timestamped with ts
+func newTestCounter(created time.Time, ts time.Time, shards map[string]int64) *Counter {
+	counter := NewCounter(created)
+	for id, delta := range shards {
+		counter.add(id, delta, ts)
+	}
+	return counter
+}
+
+func TestCounterValue(t *testing.T) {
+	s := setupKVStore()
+	ts0 := time.Unix(time.Now().Unix(), 0)
+	src := newTestCounter(ts0, ts0, map[string]int64{"a": 5, "b": -2, CONSENSUS_SHARD: 3})
+	src.add("a", -1, ts0)
+
+	b, err := s.SerializeValue(src)
+	if err != nil {
+		t.Fatalf("Unexpected serialization error: %v", err)
+	}
+
+	val, vtype, err := s.DeserializeValue(b)
+	if err != nil {
+		t.Fatalf("Unexpected deserialization error: %v", err)
+	}
+	if vtype != COUNTER_VALUE {
+		t.Fatalf("Unexpected value type enum: %v", vtype)
+	}
+	dst, ok := val.(*Counter)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", val)
+	}
+
+	testing_helpers.AssertEqual(t, "value", int64(5), dst.GetValue())
+	testing_helpers.AssertEqual(t, "equal", true, src.Equal(dst))
+}
+
+// tests the counter value's equality method
+func TestCounterEquality(t *testing.T) {
+	t0 := time.Now()
+	v0 := newTestCounter(t0, t0, map[string]int64{"a": 1})
+
+	testing_helpers.AssertEqual(t, "equal value", true, v0.Equal(newTestCounter(t0, t0, map[string]int64{"a": 1})))
+	testing_helpers.AssertEqual(t, "unequal created", false, v0.Equal(newTestCounter(t0.Add(4), t0, map[string]int64{"a": 1})))
+	testing_helpers.AssertEqual(t, "unequal total", false, v0.Equal(newTestCounter(t0, t0, map[string]int64{"a": 2})))
+	testing_helpers.AssertEqual(t, "unequal shards", false, v0.Equal(newTestCounter(t0, t0, map[string]int64{"b": 1})))
+	testing_helpers.AssertEqual(t, "unequal type", false, v0.Equal(NewString("asdf", t0)))
+}
+
+// tests that concurrent increments on different
+// shards are all kept by reconciliation
+func TestCounterReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(1000))
+	v0 := newTestCounter(ts0, ts1, map[string]int64{"a": 3, "b": 1})
+	v1 := newTestCounter(ts0, ts1, map[string]int64{"a": 2, "b": 4})
+	v1.add("c", -2, ts1)
+	values := []store.Value{v0, v1}
+
+	ractual, adjustments, err := setupKVStore().Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	merged, ok := ractual.(*Counter)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", ractual)
+	}
+	testing_helpers.AssertEqual(t, "value", int64(5), merged.GetValue())
+
+	for i, val := range values {
+		r := setupKVStore()
+		r.data["k"] = val
+		for _, instruction := range adjustments[i] {
+			testing_helpers.AssertEqual(t, "instruction", "CSHARD", instruction.Cmd)
+			if _, err := r.ExecuteInstruction(instruction); err != nil {
+				t.Fatalf("unexpected error applying instruction: %v", err)
+			}
+		}
+		testing_helpers.AssertEqual(t, "corrected value", true, merged.Equal(r.data["k"]))
+	}
+}
+
+// tests that the shards of a counter created after the
+// key was deleted replace the shards of the old counter
+func TestCounterResetReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(1000))
+	ts2 := ts0.Add(time.Duration(2000))
+	v0 := newTestCounter(ts0, ts0, map[string]int64{"a": 3})
+	v1 := NewTombstone(ts1)
+	v2 := newTestCounter(ts2, ts2, map[string]int64{"a": 1})
+	values := []store.Value{v0, v1, v2}
+
+	ractual, adjustments, err := setupKVStore().Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	assertEqualValue(t, "reconciled value", v2, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 0, len(adjustments[2]))
+	for i, val := range values[:2] {
+		r := setupKVStore()
+		r.data["k"] = val
+		for _, instruction := range adjustments[i] {
+			if _, err := r.ExecuteInstruction(instruction); err != nil {
+				t.Fatalf("unexpected error applying instruction: %v", err)
+			}
+		}
+		assertEqualValue(t, "corrected value", v2, r.data["k"])
+	}
+}
+
+// tests that the consensus shard is merged,
+// but not corrected
+func TestCounterConsensusShardReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	v0 := newTestCounter(ts0, ts0, map[string]int64{CONSENSUS_SHARD: 3})
+	v1 := newTestCounter(ts0, ts0, map[string]int64{CONSENSUS_SHARD: 2})
+	values := []store.Value{v0, v1}
+
+	ractual, adjustments, err := setupKVStore().Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	testing_helpers.AssertEqual(t, "value", int64(3), ractual.(*Counter).GetValue())
+	testing_helpers.AssertEqual(t, "num instructions", 0, len(adjustments[1]))
+}
@@ -0,0 +1,122 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"time"
+
+	"serializer"
+	"store"
+)
+
+// an ordered list of strings
+//
+// list mutations depend on the order they're applied in, so they
+// can't be merged with last write wins. They're only executed in the
+// order agreed on by consensus, and the list's timestamp is only used
+// to pick a version to return when reconciling reads
+type List struct {
+	expiry
+
+	values []string
+	time time.Time
+}
+
+func NewList(values []string, timestamp time.Time) *List {
+	v := &List{
+		values:values,
+		time:timestamp,
+	}
+	return v
+}
+
+func (v *List) GetValues() []string {
+	return v.values
+}
+
+func (v *List) Len() int {
+	return len(v.values)
+}
+
+func (v *List) GetTimestamp() time.Time {
+	return v.time
+}
+
+func (v *List) GetValueType() store.ValueType {
+	return LIST_VALUE
+}
+
+func (v *List) Equal(o store.Value) bool {
+	if !baseValueEqual(v, o) { return false }
+	other := o.(*List)
+	if len(v.values) != len(other.values) { return false }
+	for i := range v.values {
+		if v.values[i] != other.values[i] { return false }
+	}
+	if !v.expiryEqual(&other.expiry) { return false }
+	return true
+}
+
+func (v *List) withExpiry(e expiry) store.Value {
+	values := make([]string, len(v.values))
+	copy(values, v.values)
+	c := NewList(values, v.time)
+	c.expiry = e
+	return c
+}
+
+func (v *List) Serialize(buf *bufio.Writer) error {
+	numValues := uint32(len(v.values))
+	if err := binary.Write(buf, binary.LittleEndian, &numValues); err != nil {
+		return err
+	}
+	for _, val := range v.values {
+		if err := serializer.WriteFieldString(buf, val); err != nil {
+			return err
+		}
+	}
+	if err := serializer.WriteTime(buf, v.time); err != nil {
+		return err
+	}
+	if err := v.serializeExpiry(buf); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (v *List) Deserialize(buf *bufio.Reader) error {
+	var numValues uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numValues); err != nil {
+		return err
+	}
+	v.values = make([]string, numValues)
+	for i:=0; i<int(numValues); i++ {
+		if val, err := serializer.ReadFieldString(buf); err != nil {
+			return err
+		} else {
+			v.values[i] = val
+		}
+	}
+	if t, err := serializer.ReadTime(buf); err != nil {
+		return err
+	} else {
+		v.time = t
+	}
+	if err := v.deserializeExpiry(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+// lists can't be merged, and list mutations are only executed
+// through consensus, so differing versions only mean a replica
+// has fallen behind, and will be caught up by consensus. The
+// most recently modified version is returned, without
+// corrective instructions
+func reconcileList(key string, highValue *List, values []store.Value) (*List, [][]store.Instruction, error) {
+	_ = key
+	return highValue, make([][]store.Instruction, len(values)), nil
+}
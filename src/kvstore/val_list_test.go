@@ -0,0 +1,66 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+
+	"testing_helpers"
+	"store"
+)
+
+func TestListValue(t *testing.T) {
+	s := setupKVStore()
+	src := NewList([]string{"a", "b", "c"}, time.Unix(time.Now().Unix(), 0))
+
+	b, err := s.SerializeValue(src)
+	if err != nil {
+		t.Fatalf("Unexpected serialization error: %v", err)
+	}
+
+	val, vtype, err := s.DeserializeValue(b)
+	if err != nil {
+		t.Fatalf("Unexpected deserialization error: %v", err)
+	}
+	if vtype != LIST_VALUE {
+		t.Fatalf("Unexpected value type enum: %v", vtype)
+	}
+	dst, ok := val.(*List)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", val)
+	}
+
+	testing_helpers.AssertStringArrayEqual(t, "values", src.values, dst.values)
+	testing_helpers.AssertEqual(t, "equal", true, src.Equal(dst))
+}
+
+// tests the list value's equality method
+func TestListEquality(t *testing.T) {
+	t0 := time.Now()
+	v0 := NewList([]string{"a", "b"}, t0)
+
+	testing_helpers.AssertEqual(t, "equal value", true, v0.Equal(NewList([]string{"a", "b"}, t0)))
+	testing_helpers.AssertEqual(t, "unequal timestamp", false, v0.Equal(NewList([]string{"a", "b"}, t0.Add(4))))
+	testing_helpers.AssertEqual(t, "unequal order", false, v0.Equal(NewList([]string{"b", "a"}, t0)))
+	testing_helpers.AssertEqual(t, "unequal length", false, v0.Equal(NewList([]string{"a"}, t0)))
+	testing_helpers.AssertEqual(t, "unequal type", false, v0.Equal(NewString("asdf", t0)))
+}
+
+// tests that the most recent version is returned,
+// and no corrective instructions are issued
+func TestListReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	expected := NewList([]string{"a", "b"}, ts0)
+	values := []store.Value{NewList([]string{"a"}, ts1), expected}
+
+	ractual, adjustments, err := setupKVStore().Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	assertEqualValue(t, "reconciled value", expected, ractual)
+	testing_helpers.AssertEqual(t, "adjustment size", len(values), len(adjustments))
+	for _, adjustment := range adjustments {
+		testing_helpers.AssertEqual(t, "num instructions", 0, len(adjustment))
+	}
+}
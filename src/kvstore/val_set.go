@@ -0,0 +1,461 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"serializer"
+	"store"
+	"types"
+)
+
+// identifies a single SADD of a set member, by the unix nanosecond
+// timestamp of the instruction, and the node that issued it. SADDs
+// issued by different nodes at the same time get different tags
+type setTag struct {
+	time int64
+	origin types.UUID
+}
+
+func newSetTag(ts time.Time, origin types.UUID) setTag {
+	return setTag{time: ts.UnixNano(), origin: origin}
+}
+
+func (t setTag) timestamp() time.Time {
+	return time.Unix(0, t.time)
+}
+
+// returns true if the tag was written before, or by, the
+// write made at the given timestamp, by the given origin
+func (t setTag) notAfter(ts time.Time, origin types.UUID) bool {
+	return store.CompareWrites(t.timestamp(), t.origin, ts, origin) <= 0
+}
+
+// the add tags and tombstoned tags of a set member
+type setMember struct {
+	adds map[setTag]bool
+	removes map[setTag]bool
+}
+
+func newSetMember() *setMember {
+	return &setMember{adds: make(map[setTag]bool), removes: make(map[setTag]bool)}
+}
+
+// a member is present if it has add tags that haven't been removed
+func (m *setMember) isPresent() bool {
+	return len(m.adds) > 0
+}
+
+func (m *setMember) copy() *setMember {
+	c := newSetMember()
+	for tag := range m.adds {
+		c.adds[tag] = true
+	}
+	for tag := range m.removes {
+		c.removes[tag] = true
+	}
+	return c
+}
+
+func (m *setMember) equal(o *setMember) bool {
+	if len(m.adds) != len(o.adds) || len(m.removes) != len(o.removes) {
+		return false
+	}
+	for tag := range m.adds {
+		if !o.adds[tag] { return false }
+	}
+	for tag := range m.removes {
+		if !o.removes[tag] { return false }
+	}
+	return true
+}
+
+func (m *setMember) maxTag() int64 {
+	var max int64
+	for tag := range m.adds {
+		if tag.time > max { max = tag.time }
+	}
+	for tag := range m.removes {
+		if tag.time > max { max = tag.time }
+	}
+	return max
+}
+
+// an unordered set of strings, implemented as an observed-remove set
+//
+// each SADD tags the member with the instruction's timestamp and origin,
+// and SREM only tombstones the tags that the replica has observed, so an
+// add that's concurrent with a remove survives reconciliation. Tombstoned
+// tags are kept until they're purged by the gc, so removals aren't undone
+// by replicas that haven't seen them yet
+type Set struct {
+	expiry
+
+	members map[string]*setMember
+}
+
+func NewSet() *Set {
+	return &Set{members: make(map[string]*setMember)}
+}
+
+// returns true if the member is present in the set
+func (v *Set) Contains(member string) bool {
+	m, exists := v.members[member]
+	return exists && m.isPresent()
+}
+
+// returns the present members of the set, in sorted order
+func (v *Set) Members() []string {
+	members := make([]string, 0, len(v.members))
+	for member, m := range v.members {
+		if m.isPresent() {
+			members = append(members, member)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// returns the number of present members
+func (v *Set) Len() int {
+	num := 0
+	for _, m := range v.members {
+		if m.isPresent() {
+			num++
+		}
+	}
+	return num
+}
+
+// returns the timestamp of the most recent add or remove
+func (v *Set) GetTimestamp() time.Time {
+	var max int64
+	for _, m := range v.members {
+		if tag := m.maxTag(); tag > max {
+			max = tag
+		}
+	}
+	if max == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, max)
+}
+
+func (v *Set) GetValueType() store.ValueType {
+	return SET_VALUE
+}
+
+func (v *Set) Equal(o store.Value) bool {
+	other, ok := o.(*Set)
+	if !ok { return false }
+	if len(v.members) != len(other.members) { return false }
+	for member, m := range v.members {
+		otherMember, exists := other.members[member]
+		if !exists || !m.equal(otherMember) { return false }
+	}
+	if !v.expiryEqual(&other.expiry) { return false }
+	return true
+}
+
+func (v *Set) withExpiry(e expiry) store.Value {
+	c := NewSet()
+	c.expiry = e
+	for member, m := range v.members {
+		c.members[member] = m.copy()
+	}
+	return c
+}
+
+// tags the member with the given timestamp and origin.
+// Returns true if the member wasn't present before
+func (v *Set) add(member string, ts time.Time, origin types.UUID) bool {
+	tag := newSetTag(ts, origin)
+	m, exists := v.members[member]
+	if !exists {
+		m = newSetMember()
+		v.members[member] = m
+	}
+	if m.removes[tag] {
+		return false
+	}
+	wasPresent := m.isPresent()
+	m.adds[tag] = true
+	return !wasPresent
+}
+
+// tombstones the member's observed tags that weren't written
+// after the remove. Returns true if the member was present
+// before, and isn't anymore
+func (v *Set) remove(member string, ts time.Time, origin types.UUID) bool {
+	m, exists := v.members[member]
+	if !exists || !m.isPresent() {
+		return false
+	}
+	for tag := range m.adds {
+		if tag.notAfter(ts, origin) {
+			delete(m.adds, tag)
+			m.removes[tag] = true
+		}
+	}
+	return !m.isPresent()
+}
+
+// tombstones a single tag, whether or not it's been observed
+func (v *Set) removeTag(member string, ts time.Time, origin types.UUID) {
+	tag := newSetTag(ts, origin)
+	m, exists := v.members[member]
+	if !exists {
+		m = newSetMember()
+		v.members[member] = m
+	}
+	delete(m.adds, tag)
+	m.removes[tag] = true
+}
+
+// returns the set's member names in sorted order,
+// including those without any present tags
+func (v *Set) sortedMembers() []string {
+	members := make([]string, 0, len(v.members))
+	for member := range v.members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// removes the member's tombstoned tags written before the given
+// horizon, and returns the number removed
+func (v *Set) PurgeTombstones(horizon time.Time) int {
+	limit := horizon.UnixNano()
+	num := 0
+	for member, m := range v.members {
+		for tag := range m.removes {
+			if tag.time < limit {
+				delete(m.removes, tag)
+				num++
+			}
+		}
+		if len(m.adds) == 0 && len(m.removes) == 0 {
+			delete(v.members, member)
+		}
+	}
+	return num
+}
+
+// returns the given tags, ordered by timestamp, then origin
+func sortedTags(tags map[setTag]bool) []setTag {
+	sorted := make([]setTag, 0, len(tags))
+	for tag := range tags {
+		sorted = append(sorted, tag)
+	}
+	sort.Sort(setTagSlice(sorted))
+	return sorted
+}
+
+type setTagSlice []setTag
+
+func (s setTagSlice) Len() int           { return len(s) }
+func (s setTagSlice) Less(i, j int) bool { return store.CompareWrites(s[i].timestamp(), s[i].origin, s[j].timestamp(), s[j].origin) < 0 }
+func (s setTagSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func writeTags(buf *bufio.Writer, tags map[setTag]bool) error {
+	numTags := uint32(len(tags))
+	if err := binary.Write(buf, binary.LittleEndian, &numTags); err != nil {
+		return err
+	}
+	for _, tag := range sortedTags(tags) {
+		if err := binary.Write(buf, binary.LittleEndian, &tag.time); err != nil {
+			return err
+		}
+		if err := writeOrigin(buf, tag.origin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readTags(buf *bufio.Reader) (map[setTag]bool, error) {
+	var numTags uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numTags); err != nil {
+		return nil, err
+	}
+	tags := make(map[setTag]bool, numTags)
+	for i:=0; i<int(numTags); i++ {
+		var tag setTag
+		if err := binary.Read(buf, binary.LittleEndian, &tag.time); err != nil {
+			return nil, err
+		}
+		origin, err := readOrigin(buf)
+		if err != nil {
+			return nil, err
+		}
+		tag.origin = origin
+		tags[tag] = true
+	}
+	return tags, nil
+}
+
+func (v *Set) Serialize(buf *bufio.Writer) error {
+	numMembers := uint32(len(v.members))
+	if err := binary.Write(buf, binary.LittleEndian, &numMembers); err != nil {
+		return err
+	}
+	for _, member := range v.sortedMembers() {
+		m := v.members[member]
+		if err := serializer.WriteFieldString(buf, member); err != nil {
+			return err
+		}
+		if err := writeTags(buf, m.adds); err != nil {
+			return err
+		}
+		if err := writeTags(buf, m.removes); err != nil {
+			return err
+		}
+	}
+	if err := v.serializeExpiry(buf); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (v *Set) Deserialize(buf *bufio.Reader) error {
+	var numMembers uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numMembers); err != nil {
+		return err
+	}
+	v.members = make(map[string]*setMember, numMembers)
+	for i:=0; i<int(numMembers); i++ {
+		member, err := serializer.ReadFieldString(buf)
+		if err != nil {
+			return err
+		}
+		m := &setMember{}
+		if m.adds, err = readTags(buf); err != nil {
+			return err
+		}
+		if m.removes, err = readTags(buf); err != nil {
+			return err
+		}
+		v.members[member] = m
+	}
+	if err := v.deserializeExpiry(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+// merges the given set values by taking the union of each member's
+// add tags, minus the union of it's tombstoned tags
+//
+// like hashes, if any of the values are of another type, tags older
+// than the newest non set value are discarded, and replicas holding
+// them are reset before the merged tags are written to them
+func reconcileSet(key string, values []store.Value) (*Set, [][]store.Instruction, error) {
+	// find the time the key was last reset
+	var resetTime time.Time
+	for _, val := range values {
+		if _, isSet := val.(*Set); !isSet {
+			if ts := val.GetTimestamp(); ts.After(resetTime) {
+				resetTime = ts
+			}
+		}
+	}
+	resetTag := int64(0)
+	if !resetTime.IsZero() {
+		resetTag = resetTime.UnixNano()
+	}
+
+	merged := NewSet()
+	for _, val := range values {
+		set, isSet := val.(*Set)
+		if !isSet {
+			continue
+		}
+		for member, m := range set.members {
+			mm, exists := merged.members[member]
+			if !exists {
+				mm = newSetMember()
+			}
+			for tag := range m.adds {
+				if tag.time >= resetTag {
+					mm.adds[tag] = true
+				}
+			}
+			for tag := range m.removes {
+				if tag.time >= resetTag {
+					mm.removes[tag] = true
+				}
+			}
+			if len(mm.adds) > 0 || len(mm.removes) > 0 {
+				merged.members[member] = mm
+			}
+		}
+	}
+	for _, m := range merged.members {
+		for tag := range m.removes {
+			delete(m.adds, tag)
+		}
+	}
+
+	// create instructions for the unequal nodes
+	instructions := make([][]store.Instruction, len(values))
+	members := merged.sortedMembers()
+	for i, val := range values {
+		if merged.Equal(val) {
+			continue
+		}
+
+		set, isSet := val.(*Set)
+		needsReset := !isSet
+		if isSet {
+			for _, m := range set.members {
+				for tag := range m.adds {
+					if tag.time < resetTag { needsReset = true }
+				}
+				for tag := range m.removes {
+					if tag.time < resetTag { needsReset = true }
+				}
+			}
+		}
+
+		nodeInstructions := make([]store.Instruction, 0)
+		if needsReset {
+			nodeInstructions = append(nodeInstructions, store.NewInstruction(DEL, key, []string{}, resetTime))
+		}
+		for _, member := range members {
+			mm := merged.members[member]
+			existing := newSetMember()
+			if !needsReset {
+				if m, exists := set.members[member]; exists {
+					existing = m
+				}
+			}
+			for _, tag := range sortedTags(mm.adds) {
+				if !existing.adds[tag] {
+					nodeInstructions = append(nodeInstructions, setTagInstruction(SADD, key, member, tag))
+				}
+			}
+			for _, tag := range sortedTags(mm.removes) {
+				if !existing.removes[tag] {
+					nodeInstructions = append(nodeInstructions, setTagInstruction(SREMTAG, key, member, tag))
+				}
+			}
+		}
+		instructions[i] = nodeInstructions
+	}
+
+	return merged, instructions, nil
+}
+
+// returns an instruction writing the given member tag
+func setTagInstruction(cmd string, key string, member string, tag setTag) store.Instruction {
+	instruction := store.NewInstruction(cmd, key, []string{member}, tag.timestamp())
+	instruction.Origin = tag.origin
+	instruction.Internal = isInternalCommand(cmd)
+	return instruction
+}
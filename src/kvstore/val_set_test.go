@@ -0,0 +1,234 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+
+	"testing_helpers"
+	"store"
+	"types"
+)
+
+// returns a set with the given members, all tagged with ts
+func newTestSet(ts time.Time, members ...string) *Set {
+	set := NewSet()
+	for _, member := range members {
+		set.add(member, ts, types.UUID{})
+	}
+	return set
+}
+
+// applies the given instructions to a store holding the given value,
+// and returns the resulting value
+func applyInstructions(t *testing.T, val store.Value, instructions []store.Instruction) store.Value {
+	r := setupKVStore()
+	r.data["k"] = val
+	for _, instruction := range instructions {
+		if _, err := r.ExecuteInstruction(instruction); err != nil {
+			t.Fatalf("unexpected error applying instruction: %v", err)
+		}
+	}
+	return r.data["k"]
+}
+
+func TestSetValue(t *testing.T) {
+	s := setupKVStore()
+	ts0 := time.Now()
+	src := newTestSet(ts0, "a", "b", "c")
+	src.remove("c", ts0, types.UUID{})
+
+	b, err := s.SerializeValue(src)
+	if err != nil {
+		t.Fatalf("Unexpected serialization error: %v", err)
+	}
+
+	val, vtype, err := s.DeserializeValue(b)
+	if err != nil {
+		t.Fatalf("Unexpected deserialization error: %v", err)
+	}
+	if vtype != SET_VALUE {
+		t.Fatalf("Unexpected value type enum: %v", vtype)
+	}
+	dst, ok := val.(*Set)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", val)
+	}
+
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"a", "b"}, dst.Members())
+	testing_helpers.AssertEqual(t, "equal", true, src.Equal(dst))
+}
+
+// tests the set value's equality method
+func TestSetEquality(t *testing.T) {
+	t0 := time.Now()
+	v0 := newTestSet(t0, "a", "b")
+
+	testing_helpers.AssertEqual(t, "equal value", true, v0.Equal(newTestSet(t0, "a", "b")))
+	testing_helpers.AssertEqual(t, "unequal tag", false, v0.Equal(newTestSet(t0.Add(4), "a", "b")))
+	testing_helpers.AssertEqual(t, "unequal members", false, v0.Equal(newTestSet(t0, "a", "c")))
+	testing_helpers.AssertEqual(t, "extra member", false, v0.Equal(newTestSet(t0, "a", "b", "c")))
+	testing_helpers.AssertEqual(t, "unequal type", false, v0.Equal(NewString("asdf", t0)))
+
+	v1 := newTestSet(t0, "a", "b")
+	v1.remove("b", t0, types.UUID{})
+	testing_helpers.AssertEqual(t, "removed member", false, v0.Equal(v1))
+}
+
+// tests that a member added concurrently with a
+// remove of the same member survives reconciliation
+func TestSetConcurrentAddReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(1000))
+	ts2 := ts0.Add(time.Duration(2000))
+
+	// both replicas see the initial add, then one removes
+	// the member, and the other adds it again concurrently
+	v0 := newTestSet(ts0, "a", "b")
+	v0.remove("a", ts2, types.UUID{})
+	v1 := newTestSet(ts0, "a", "b")
+	v1.add("a", ts1, types.UUID{})
+	values := []store.Value{v0, v1}
+
+	ractual, adjustments, err := setupKVStore().Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	merged, ok := ractual.(*Set)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", ractual)
+	}
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"a", "b"}, merged.Members())
+
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments[0]))
+	expected_instr := store.Instruction{Cmd:"SADD", Key:"k", Args:[]string{"a"}, Timestamp:time.Unix(0, ts1.UnixNano())}
+	if !expected_instr.Equal(adjustments[0][0]) {
+		t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, adjustments[0][0])
+	}
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments[1]))
+	expected_instr = store.Instruction{Cmd:"SREMTAG", Key:"k", Args:[]string{"a"}, Timestamp:time.Unix(0, ts0.UnixNano()), Internal:true}
+	if !expected_instr.Equal(adjustments[1][0]) {
+		t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, adjustments[1][0])
+	}
+
+	for i, val := range values {
+		corrected := applyInstructions(t, val, adjustments[i])
+		testing_helpers.AssertEqual(t, "corrected value", true, merged.Equal(corrected))
+	}
+}
+
+// tests that removals are propagated to
+// replicas that haven't seen them
+func TestSetRemoveReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(1000))
+	v0 := newTestSet(ts0, "a", "b")
+	v0.remove("a", ts1, types.UUID{})
+	v1 := newTestSet(ts0, "a", "b")
+	values := []store.Value{v0, v1}
+
+	ractual, adjustments, err := setupKVStore().Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	assertEqualValue(t, "reconciled value", v0, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 0, len(adjustments[0]))
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments[1]))
+	corrected := applyInstructions(t, v1, adjustments[1])
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"b"}, corrected.(*Set).Members())
+}
+
+// tests that members added before the key was deleted
+// are discarded, and that the replicas holding them,
+// or the tombstone, are reset
+func TestSetResetReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	ts2 := ts0.Add(time.Duration(-6000))
+	v0 := newTestSet(ts0, "a")
+	v1 := NewTombstone(ts1)
+	v2 := newTestSet(ts2, "b")
+	v2.add("a", ts0, types.UUID{})
+	values := []store.Value{v0, v1, v2}
+
+	ractual, adjustments, err := setupKVStore().Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	assertEqualValue(t, "reconciled value", v0, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 0, len(adjustments[0]))
+	for i, adjustment := range adjustments[1:] {
+		testing_helpers.AssertEqual(t, "num instructions", 2, len(adjustment))
+		testing_helpers.AssertEqual(t, "instruction", "DEL", adjustment[0].Cmd)
+		testing_helpers.AssertEqual(t, "instruction", "SADD", adjustment[1].Cmd)
+		corrected := applyInstructions(t, values[i+1], adjustment)
+		testing_helpers.AssertEqual(t, "corrected value", true, v0.Equal(corrected))
+	}
+}
+
+// tests that a delete newer than all of the
+// members wins
+func TestSetDeletedReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	expected := NewTombstone(ts0)
+	values := []store.Value{expected, newTestSet(ts1, "a")}
+
+	ractual, adjustments, err := setupKVStore().Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	assertEqualValue(t, "reconciled value", expected, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments[1]))
+	testing_helpers.AssertEqual(t, "instruction", "DEL", adjustments[1][0].Cmd)
+}
+
+// tests that adds issued by different nodes at the same time get
+// different tags, so removing one doesn't remove the other
+func TestSetTagOrigins(t *testing.T) {
+	ts0 := time.Now()
+	n0 := types.NewUUID1()
+	n1 := types.NewUUID1()
+
+	v0 := NewSet()
+	v0.add("a", ts0, n0)
+	v1 := NewSet()
+	v1.add("a", ts0, n0)
+	v1.add("a", ts0, n1)
+	v0.remove("a", ts0, n0)
+	testing_helpers.AssertEqual(t, "removed", false, v0.Contains("a"))
+
+	values := []store.Value{v0, v1}
+	ractual, adjustments, err := setupKVStore().Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	merged := ractual.(*Set)
+	testing_helpers.AssertEqual(t, "contains", true, merged.Contains("a"))
+
+	for i, val := range values {
+		corrected := applyInstructions(t, val, adjustments[i])
+		testing_helpers.AssertEqual(t, "corrected value", true, merged.Equal(corrected))
+	}
+}
+
+// tests that tombstoned tags older than the horizon are purged,
+// and members without any remaining tags are removed
+func TestSetPurgeTombstones(t *testing.T) {
+	ts0 := time.Now()
+	set := NewSet()
+	set.add("a", ts0, types.UUID{})
+	set.add("b", ts0, types.UUID{})
+	set.add("b", ts0.Add(time.Minute), types.UUID{})
+	set.remove("a", ts0, types.UUID{})
+	set.remove("b", ts0.Add(time.Minute), types.UUID{})
+	set.add("c", ts0.Add(2 * time.Minute), types.UUID{})
+	set.remove("c", ts0.Add(2 * time.Minute), types.UUID{})
+
+	testing_helpers.AssertEqual(t, "num purged", 3, set.PurgeTombstones(ts0.Add(90 * time.Second)))
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"c"}, set.sortedMembers())
+	testing_helpers.AssertEqual(t, "num tombstones", 1, len(set.members["c"].removes))
+}
@@ -6,18 +6,25 @@ import (
 
 	"store"
 	"serializer"
+	"types"
 )
 
 // a single value used for
 // key/val types
 type String struct {
+	expiry
+
 	value string
 	time time.Time
+
+	// the node that issued the timestamp
+	origin types.UUID
 }
 
 // single value constructor
 func NewString(value string, time time.Time) *String {
 	v := &String{
+		expiry:newExpiry(time),
 		value:value,
 		time:time,
 	}
@@ -32,6 +39,10 @@ func (v *String) GetTimestamp() time.Time {
 	return v.time
 }
 
+func (v *String) GetOrigin() types.UUID {
+	return v.origin
+}
+
 func (v *String) GetValueType() store.ValueType {
 	return STRING_VALUE
 }
@@ -40,9 +51,16 @@ func (v *String) Equal(o store.Value) bool {
 	if !baseValueEqual(v, o) { return false }
 	other := o.(*String)
 	if v.value != other.value { return false }
+	if !v.expiryEqual(&other.expiry) { return false }
 	return true
 }
 
+func (v *String) withExpiry(e expiry) store.Value {
+	c := *v
+	c.expiry = e
+	return &c
+}
+
 func (v *String) Serialize(buf *bufio.Writer) error {
 	if err := serializer.WriteFieldBytes(buf, []byte(v.value)); err != nil {
 		return err
@@ -50,6 +68,12 @@ func (v *String) Serialize(buf *bufio.Writer) error {
 	if err := serializer.WriteTime(buf, v.time); err != nil {
 		return err
 	}
+	if err := writeOrigin(buf, v.origin); err != nil {
+		return err
+	}
+	if err := v.serializeExpiry(buf); err != nil {
+		return err
+	}
 	if err := buf.Flush(); err != nil {
 		return err
 	}
@@ -68,6 +92,14 @@ func (v *String) Deserialize(buf *bufio.Reader) error {
 	} else {
 		v.time = t
 	}
+	if o, err := readOrigin(buf); err != nil {
+		return err
+	} else {
+		v.origin = o
+	}
+	if err := v.deserializeExpiry(buf); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -81,6 +113,7 @@ func reconcileString(key string, highValue *String, values []store.Value) (*Stri
 				Key:key,
 				Args:[]string{highValue.value},
 				Timestamp:highValue.time,
+				Origin:highValue.origin,
 			}}
 		}
 	}
@@ -6,6 +6,7 @@ import (
 
 	"store"
 	"testing_helpers"
+	"types"
 )
 
 // tests the string value
@@ -73,6 +74,35 @@ func TestStringMismatchReconciliation(t *testing.T) {
 	}
 }
 
+// values with equal timestamps should be reconciled to the
+// value with the highest origin, and the correcting instructions
+// should carry it, so the replicas don't ignore them
+func TestStringEqualTimestampReconciliation(t *testing.T) {
+	ts := time.Now()
+	expected := NewString("a", ts)
+	expected.origin = types.NewUUID1()
+	other := NewString("b", ts)
+	for _, values := range [][]store.Value{{other, expected}, {expected, other}} {
+		ractual, adjustments, err := setupKVStore().Reconcile("k", values)
+		if err != nil {
+			t.Fatalf("unexpected reconciliation error: %v", err)
+		}
+		assertEqualValue(t, "reconciled value", expected, ractual)
+
+		for i, val := range values {
+			if val == expected {
+				testing_helpers.AssertEqual(t, "num instructions", 0, len(adjustments[i]))
+				continue
+			}
+			testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments[i]))
+			expected_instr := store.Instruction{Cmd:"SET", Key:"k", Args:[]string{"a"}, Timestamp:ts, Origin:expected.origin}
+			if !expected_instr.Equal(adjustments[i][0]) {
+				t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, adjustments[i][0])
+			}
+		}
+	}
+}
+
 // should set values of different types to the value
 // with the largest timestamp
 func TestStringMultiTypeReconciliation(t *testing.T) {
@@ -0,0 +1,376 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"time"
+
+	"serializer"
+	"store"
+	"types"
+)
+
+// a sorted set member's score, and the time it was last
+// written, and by which node. Removed members are kept as tombstones
+type zsetMember struct {
+	score float64
+	time time.Time
+	origin types.UUID
+	removed bool
+}
+
+func (m *zsetMember) equal(o *zsetMember) bool {
+	return m.score == o.score && m.time.Equal(o.time) && m.origin == o.origin && m.removed == o.removed
+}
+
+// returns true if the member was written after the given write
+func (m *zsetMember) after(ts time.Time, origin types.UUID) bool {
+	return store.CompareWrites(m.time, m.origin, ts, origin) > 0
+}
+
+// an entry in the sorted set's ordered index
+type zsetEntry struct {
+	member string
+	score float64
+}
+
+func (e zsetEntry) less(o zsetEntry) bool {
+	if e.score != o.score {
+		return e.score < o.score
+	}
+	return e.member < o.member
+}
+
+// a set of members ordered by score, ties are ordered by member
+//
+// like hashes, each member carries it's own timestamp, so concurrent
+// writes to different members can be merged during reconciliation.
+// Present members are also kept in an ordered index, so ranges can be
+// found by rank, or by score, without sorting the set
+type SortedSet struct {
+	expiry
+
+	members map[string]*zsetMember
+
+	// present members, ordered by score, then member
+	index []zsetEntry
+}
+
+func NewSortedSet() *SortedSet {
+	return &SortedSet{members: make(map[string]*zsetMember)}
+}
+
+// returns the member's score, and false if the
+// member doesn't exist, or has been removed
+func (v *SortedSet) Score(member string) (float64, bool) {
+	m, exists := v.members[member]
+	if !exists || m.removed {
+		return 0, false
+	}
+	return m.score, true
+}
+
+// returns the present members, ordered by score
+func (v *SortedSet) Members() []string {
+	members := make([]string, len(v.index))
+	for i, entry := range v.index {
+		members[i] = entry.member
+	}
+	return members
+}
+
+// returns the number of present members
+func (v *SortedSet) Len() int {
+	return len(v.index)
+}
+
+// returns the timestamp of the most recently written member
+func (v *SortedSet) GetTimestamp() time.Time {
+	var ts time.Time
+	for _, m := range v.members {
+		if m.time.After(ts) {
+			ts = m.time
+		}
+	}
+	return ts
+}
+
+func (v *SortedSet) GetValueType() store.ValueType {
+	return ZSET_VALUE
+}
+
+func (v *SortedSet) Equal(o store.Value) bool {
+	other, ok := o.(*SortedSet)
+	if !ok { return false }
+	if len(v.members) != len(other.members) { return false }
+	for member, m := range v.members {
+		otherMember, exists := other.members[member]
+		if !exists || !m.equal(otherMember) { return false }
+	}
+	if !v.expiryEqual(&other.expiry) { return false }
+	return true
+}
+
+func (v *SortedSet) withExpiry(e expiry) store.Value {
+	c := NewSortedSet()
+	c.expiry = e
+	for member, m := range v.members {
+		cm := *m
+		c.members[member] = &cm
+	}
+	c.index = make([]zsetEntry, len(v.index))
+	copy(c.index, v.index)
+	return c
+}
+
+// returns the position of the given entry in the index,
+// or the position it would be inserted at
+func (v *SortedSet) search(entry zsetEntry) int {
+	return sort.Search(len(v.index), func(i int) bool {
+		return !v.index[i].less(entry)
+	})
+}
+
+func (v *SortedSet) indexInsert(entry zsetEntry) {
+	i := v.search(entry)
+	v.index = append(v.index, zsetEntry{})
+	copy(v.index[i+1:], v.index[i:])
+	v.index[i] = entry
+}
+
+func (v *SortedSet) indexRemove(entry zsetEntry) {
+	i := v.search(entry)
+	if i < len(v.index) && v.index[i] == entry {
+		v.index = append(v.index[:i], v.index[i+1:]...)
+	}
+}
+
+// sets the member, replacing any previous value,
+// and updates the index
+func (v *SortedSet) setMember(member string, m *zsetMember) {
+	if existing, exists := v.members[member]; exists && !existing.removed {
+		v.indexRemove(zsetEntry{member: member, score: existing.score})
+	}
+	v.members[member] = m
+	if !m.removed {
+		v.indexInsert(zsetEntry{member: member, score: m.score})
+	}
+}
+
+// removes the tombstones of members removed before the
+// given horizon, and returns the number removed
+func (v *SortedSet) PurgeTombstones(horizon time.Time) int {
+	num := 0
+	for member, m := range v.members {
+		if m.removed && m.time.Before(horizon) {
+			delete(v.members, member)
+			num++
+		}
+	}
+	return num
+}
+
+// returns the index entries between the given ranks, inclusive
+func (v *SortedSet) rangeByRank(start int, stop int) []zsetEntry {
+	start, stop, ok := normalizeRange(len(v.index), start, stop)
+	if !ok {
+		return []zsetEntry{}
+	}
+	return v.index[start:stop + 1]
+}
+
+// returns the index entries with scores between min and max
+func (v *SortedSet) rangeByScore(min scoreBound, max scoreBound) []zsetEntry {
+	start := sort.Search(len(v.index), func(i int) bool {
+		return min.below(v.index[i].score)
+	})
+	stop := sort.Search(len(v.index), func(i int) bool {
+		return !max.above(v.index[i].score)
+	})
+	if stop < start {
+		return []zsetEntry{}
+	}
+	return v.index[start:stop]
+}
+
+// returns a sorted set containing only the given entries
+func (v *SortedSet) subset(entries []zsetEntry) *SortedSet {
+	rval := NewSortedSet()
+	rval.index = make([]zsetEntry, len(entries))
+	copy(rval.index, entries)
+	for _, entry := range entries {
+		m := *v.members[entry.member]
+		rval.members[entry.member] = &m
+	}
+	return rval
+}
+
+// returns the sorted set's member names in sorted order,
+// including removed members
+func (v *SortedSet) sortedMembers() []string {
+	members := make([]string, 0, len(v.members))
+	for member := range v.members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func (v *SortedSet) Serialize(buf *bufio.Writer) error {
+	numMembers := uint32(len(v.members))
+	if err := binary.Write(buf, binary.LittleEndian, &numMembers); err != nil {
+		return err
+	}
+	for _, member := range v.sortedMembers() {
+		m := v.members[member]
+		if err := serializer.WriteFieldString(buf, member); err != nil {
+			return err
+		}
+		if err := binary.Write(buf, binary.LittleEndian, &m.score); err != nil {
+			return err
+		}
+		if err := serializer.WriteTime(buf, m.time); err != nil {
+			return err
+		}
+		if err := writeOrigin(buf, m.origin); err != nil {
+			return err
+		}
+		var removed byte
+		if m.removed {
+			removed = 0x1
+		}
+		if err := binary.Write(buf, binary.LittleEndian, &removed); err != nil {
+			return err
+		}
+	}
+	if err := v.serializeExpiry(buf); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (v *SortedSet) Deserialize(buf *bufio.Reader) error {
+	var numMembers uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numMembers); err != nil {
+		return err
+	}
+	v.members = make(map[string]*zsetMember, numMembers)
+	v.index = nil
+	for i:=0; i<int(numMembers); i++ {
+		member, err := serializer.ReadFieldString(buf)
+		if err != nil {
+			return err
+		}
+		m := &zsetMember{}
+		if err := binary.Read(buf, binary.LittleEndian, &m.score); err != nil {
+			return err
+		}
+		if m.time, err = serializer.ReadTime(buf); err != nil {
+			return err
+		}
+		if m.origin, err = readOrigin(buf); err != nil {
+			return err
+		}
+		var removed byte
+		if err := binary.Read(buf, binary.LittleEndian, &removed); err != nil {
+			return err
+		}
+		m.removed = removed != 0x0
+		v.setMember(member, m)
+	}
+	if err := v.deserializeExpiry(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// returns the instruction that sets a member to the given value
+func zsetMemberInstruction(key string, member string, m *zsetMember) store.Instruction {
+	var instruction store.Instruction
+	if m.removed {
+		instruction = store.NewInstruction(ZREM, key, []string{member}, m.time)
+	} else {
+		instruction = store.NewInstruction(ZADD, key, []string{formatScore(m.score), member}, m.time)
+	}
+	instruction.Origin = m.origin
+	return instruction
+}
+
+// merges the members of the given sorted set values, keeping the
+// value with the highest timestamp for each member
+//
+// values of other types reset the key, the same as they do for hashes
+func reconcileSortedSet(key string, values []store.Value) (*SortedSet, [][]store.Instruction, error) {
+	// find the time the key was last reset
+	var resetTime time.Time
+	for _, val := range values {
+		if _, isZSet := val.(*SortedSet); !isZSet {
+			if ts := val.GetTimestamp(); ts.After(resetTime) {
+				resetTime = ts
+			}
+		}
+	}
+
+	merged := NewSortedSet()
+	for _, val := range values {
+		zset, isZSet := val.(*SortedSet)
+		if !isZSet {
+			continue
+		}
+		for member, m := range zset.members {
+			if m.time.Before(resetTime) {
+				continue
+			}
+			if existing, exists := merged.members[member]; !exists || m.after(existing.time, existing.origin) {
+				mcopy := *m
+				merged.setMember(member, &mcopy)
+			}
+		}
+	}
+
+	// create instructions for the unequal nodes
+	instructions := make([][]store.Instruction, len(values))
+	members := merged.sortedMembers()
+	for i, val := range values {
+		if merged.Equal(val) {
+			continue
+		}
+
+		zset, isZSet := val.(*SortedSet)
+		needsReset := !isZSet
+		if isZSet {
+			for _, m := range zset.members {
+				if m.time.Before(resetTime) {
+					needsReset = true
+					break
+				}
+			}
+		}
+
+		nodeInstructions := make([]store.Instruction, 0, len(members) + 1)
+		if needsReset {
+			nodeInstructions = append(nodeInstructions, store.NewInstruction(DEL, key, []string{}, resetTime))
+		}
+		for _, member := range members {
+			m := merged.members[member]
+			if !needsReset {
+				if existing, exists := zset.members[member]; exists && existing.equal(m) {
+					continue
+				}
+			}
+			nodeInstructions = append(nodeInstructions, zsetMemberInstruction(key, member, m))
+		}
+		instructions[i] = nodeInstructions
+	}
+
+	return merged, instructions, nil
+}
@@ -0,0 +1,145 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+
+	"testing_helpers"
+	"store"
+)
+
+// returns a sorted set with the given member scores, timestamped with ts
+func newTestSortedSet(ts time.Time, scores map[string]float64) *SortedSet {
+	zset := NewSortedSet()
+	for member, score := range scores {
+		zset.setMember(member, &zsetMember{score: score, time: ts})
+	}
+	return zset
+}
+
+func TestSortedSetValue(t *testing.T) {
+	s := setupKVStore()
+	ts0 := time.Unix(time.Now().Unix(), 0)
+	src := newTestSortedSet(ts0, map[string]float64{"a": 3, "b": 1.5, "c": -2})
+	src.setMember("d", &zsetMember{time: ts0, removed: true})
+
+	b, err := s.SerializeValue(src)
+	if err != nil {
+		t.Fatalf("Unexpected serialization error: %v", err)
+	}
+
+	val, vtype, err := s.DeserializeValue(b)
+	if err != nil {
+		t.Fatalf("Unexpected deserialization error: %v", err)
+	}
+	if vtype != ZSET_VALUE {
+		t.Fatalf("Unexpected value type enum: %v", vtype)
+	}
+	dst, ok := val.(*SortedSet)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", val)
+	}
+
+	testing_helpers.AssertEqual(t, "num members", 4, len(dst.members))
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"c", "b", "a"}, dst.Members())
+	testing_helpers.AssertEqual(t, "equal", true, src.Equal(dst))
+}
+
+// tests the sorted set value's equality method
+func TestSortedSetEquality(t *testing.T) {
+	t0 := time.Now()
+	v0 := newTestSortedSet(t0, map[string]float64{"a": 1})
+
+	testing_helpers.AssertEqual(t, "equal value", true, v0.Equal(newTestSortedSet(t0, map[string]float64{"a": 1})))
+	testing_helpers.AssertEqual(t, "unequal timestamp", false, v0.Equal(newTestSortedSet(t0.Add(4), map[string]float64{"a": 1})))
+	testing_helpers.AssertEqual(t, "unequal score", false, v0.Equal(newTestSortedSet(t0, map[string]float64{"a": 2})))
+	testing_helpers.AssertEqual(t, "extra member", false, v0.Equal(newTestSortedSet(t0, map[string]float64{"a": 1, "b": 2})))
+	testing_helpers.AssertEqual(t, "unequal type", false, v0.Equal(NewString("asdf", t0)))
+}
+
+// tests that the index is kept in score order
+// as members are added, updated, and removed
+func TestSortedSetIndex(t *testing.T) {
+	t0 := time.Now()
+	zset := newTestSortedSet(t0, map[string]float64{"a": 3, "b": 1, "c": 2, "d": 2})
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"b", "c", "d", "a"}, zset.Members())
+
+	zset.setMember("b", &zsetMember{score: 5, time: t0})
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"c", "d", "a", "b"}, zset.Members())
+
+	zset.setMember("d", &zsetMember{time: t0, removed: true})
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"c", "a", "b"}, zset.Members())
+	testing_helpers.AssertEqual(t, "len", 3, zset.Len())
+}
+
+// tests that members written on different
+// replicas are merged together
+func TestSortedSetMergeReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	v0 := newTestSortedSet(ts0, map[string]float64{"a": 1})
+	v0.setMember("b", &zsetMember{score: 2, time: ts1})
+	v1 := newTestSortedSet(ts1, map[string]float64{"a": 5, "b": 2})
+	v1.setMember("c", &zsetMember{time: ts0, removed: true})
+	values := []store.Value{v0, v1}
+
+	ractual, adjustments, err := setupKVStore().Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	merged, ok := ractual.(*SortedSet)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", ractual)
+	}
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"a", "b"}, merged.Members())
+	score, _ := merged.Score("a")
+	testing_helpers.AssertEqual(t, "score", float64(1), score)
+
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments[0]))
+	expected_instr := store.Instruction{Cmd:"ZREM", Key:"k", Args:[]string{"c"}, Timestamp:ts0}
+	if !expected_instr.Equal(adjustments[0][0]) {
+		t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, adjustments[0][0])
+	}
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments[1]))
+	expected_instr = store.Instruction{Cmd:"ZADD", Key:"k", Args:[]string{"1", "a"}, Timestamp:ts0}
+	if !expected_instr.Equal(adjustments[1][0]) {
+		t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, adjustments[1][0])
+	}
+}
+
+// tests that members written before the key was deleted
+// are discarded, and that the replicas holding them,
+// or the tombstone, are reset
+func TestSortedSetResetReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	ts2 := ts0.Add(time.Duration(-6000))
+	v0 := newTestSortedSet(ts0, map[string]float64{"a": 1})
+	v1 := NewTombstone(ts1)
+	v2 := newTestSortedSet(ts2, map[string]float64{"b": 2})
+	v2.setMember("a", &zsetMember{score: 1, time: ts0})
+	values := []store.Value{v0, v1, v2}
+
+	ractual, adjustments, err := setupKVStore().Reconcile("k", values)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	assertEqualValue(t, "reconciled value", v0, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 0, len(adjustments[0]))
+	for i, adjustment := range adjustments[1:] {
+		testing_helpers.AssertEqual(t, "num instructions", 2, len(adjustment))
+		testing_helpers.AssertEqual(t, "instruction", "DEL", adjustment[0].Cmd)
+		testing_helpers.AssertEqual(t, "instruction", "ZADD", adjustment[1].Cmd)
+
+		r := setupKVStore()
+		r.data["k"] = values[i+1]
+		for _, instruction := range adjustment {
+			if _, err := r.ExecuteInstruction(instruction); err != nil {
+				t.Fatalf("unexpected error applying instruction: %v", err)
+			}
+		}
+		assertEqualValue(t, "corrected value", v0, r.data["k"])
+	}
+}
@@ -1,9 +1,5 @@
 package node
 
-import (
-	"time"
-)
-
 import (
 	"message"
 	"store"
@@ -34,7 +30,9 @@ func (e *NodeError) Error() string {
 type Node interface {
 	GetId() NodeId
 
-	ExecuteQuery(cmd string, key string, args []string, timestamp time.Time) (store.Value, error)
+	// executes the instruction against the node's store. Instructions
+	// with zero timestamps are executed as reads
+	ExecuteQuery(instruction store.Instruction) (store.Value, error)
 	SendMessage(message.Message) (message.Message, error)
 
 	Start() error
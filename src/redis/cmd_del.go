@@ -3,6 +3,7 @@ package redis
 import (
 	"fmt"
 	"time"
+	"types"
 )
 
 func (s *Redis) validateDel(key string, args []string, timestamp time.Time) error {
@@ -22,10 +23,12 @@ func (s *Redis) validateDel(key string, args []string, timestamp time.Time) erro
 // internally, each key is deleted one at a time, and a bool value
 // is returned indicating if a key was deleted, and the previos value's
 // timestamp if one was found
-func (s *Redis) del(key string, ts time.Time) (*Boolean, error) {
+func (s *Redis) del(key string, ts time.Time, origin types.UUID) (*Boolean, error) {
 	var rval *Boolean
 	if val, exists := s.data[key]; exists {
-		s.data[key] = NewTombstone(ts)
+		tombstone := NewTombstone(ts)
+		tombstone.origin = origin
+		s.setValue(key, tombstone)
 		rval = NewBoolean(true, val.GetTimestamp())
 	} else {
 		rval = NewBoolean(false, time.Time{})
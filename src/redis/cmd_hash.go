@@ -0,0 +1,218 @@
+package redis
+
+import (
+	"fmt"
+	"time"
+
+	"store"
+	"types"
+)
+
+func (s *Redis) validateHSet(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) < 2 || len(args) % 2 != 0 {
+		return fmt.Errorf("incorrect number of args for HSET. Expected field value pairs, got %v args", len(args))
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("HSET Got zero timestamp")
+	}
+	return nil
+}
+
+func (s *Redis) validateHGet(key string, args []string) error {
+	_ = key
+	if len(args) != 1 {
+		return fmt.Errorf("incorrect number of args for HGET. Expected 1, got %v", len(args))
+	}
+	return nil
+}
+
+func (s *Redis) validateHDel(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) < 1 {
+		return fmt.Errorf("incorrect number of args for HDEL. Expected at least 1, got %v", len(args))
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("HDEL Got zero timestamp")
+	}
+	return nil
+}
+
+func (s *Redis) validateHGetAll(key string, args []string) error {
+	_ = key
+	if len(args) != 0 {
+		return fmt.Errorf("HGETALL takes 0 args, %v found", len(args))
+	}
+	return nil
+}
+
+func (s *Redis) validateHLen(key string, args []string) error {
+	_ = key
+	if len(args) != 0 {
+		return fmt.Errorf("HLEN takes 0 args, %v found", len(args))
+	}
+	return nil
+}
+
+// returns the hash stored at the given key for writing. If the key
+// doesn't exist, or has been deleted before the given timestamp, a
+// new hash is stored at the key. Nil is returned if the key was
+// deleted after the given timestamp, or may have been deleted by a
+// purged tombstone, and an error is returned if the key holds a
+// value of another type
+func (s *Redis) getHashForWrite(key string, ts time.Time) (*Hash, error) {
+	existing, exists := s.data[key]
+	if !exists {
+		if ts.Before(s.gc.PurgedBefore) {
+			return nil, nil
+		}
+		hash := NewHash()
+		s.setValue(key, hash)
+		return hash, nil
+	}
+	switch val := existing.(type) {
+	case *Hash:
+		return val, nil
+	case *Tombstone:
+		if ts.Before(val.time) {
+			return nil, nil
+		}
+		hash := NewHash()
+		s.setValue(key, hash)
+		return hash, nil
+	default:
+		return nil, fmt.Errorf("WRONGTYPE key [%v] holds a %v value, not a hash", key, existing.GetValueType())
+	}
+}
+
+// returns the hash stored at the given key for reading. Nil is returned
+// if the key doesn't exist, and an error is returned if the key holds
+// a value of another type
+func (s *Redis) getHashForRead(key string) (*Hash, error) {
+	existing, exists := s.data[key]
+	if !exists {
+		return nil, nil
+	}
+	switch val := existing.(type) {
+	case *Hash:
+		return val, nil
+	case *Tombstone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("WRONGTYPE key [%v] holds a %v value, not a hash", key, existing.GetValueType())
+	}
+}
+
+// Sets the specified fields to their respective values in the hash stored at key. If key
+// does not exist, a new key holding a hash is created. Fields that already exist in the
+// hash are overwritten.
+// Return value: the number of fields that were added.
+//
+// internally, writes older than a field's current value are ignored, as are writes to
+// missing fields timestamped before the gc's purge horizon, since the field may have
+// been deleted, and its tombstone purged
+func (s *Redis) hset(key string, pairs []string, ts time.Time, origin types.UUID) (*Integer, error) {
+	hash, err := s.getHashForWrite(key, ts)
+	if err != nil { return nil, err }
+	if hash == nil {
+		return NewInteger(0, ts), nil
+	}
+
+	num := 0
+	for i:=0; i<len(pairs); i+=2 {
+		field := pairs[i]
+		existing, exists := hash.fields[field]
+		if exists && store.WriteBefore(ts, origin, existing) {
+			continue
+		}
+		if !exists && ts.Before(s.gc.PurgedBefore) {
+			continue
+		}
+		str := NewString(pairs[i+1], ts)
+		str.origin = origin
+		hash.fields[field] = str
+		if !exists || existing.GetValueType() != STRING_VALUE {
+			num++
+		}
+	}
+	return NewInteger(int64(num), ts), nil
+}
+
+// Returns the value associated with field in the hash stored at key.
+//
+// internally, a hash containing only the requested field is returned, or nil if the field
+// doesn't exist. This allows the results of HGET to be reconciled field by field, without
+// touching the other fields in the hash. Deleted fields are returned as tombstones, use
+// GetField on the returned hash to get the field's value.
+func (s *Redis) hget(key string, field string) (store.Value, error) {
+	hash, err := s.getHashForRead(key)
+	if err != nil || hash == nil { return nil, err }
+
+	val, exists := hash.fields[field]
+	if !exists {
+		return nil, nil
+	}
+	rval := NewHash()
+	rval.fields[field] = val
+	return rval, nil
+}
+
+// Removes the specified fields from the hash stored at key. If key does not exist, it
+// is treated as an empty hash.
+// Return value: the number of fields that existed, and were removed
+//
+// internally, the fields are replaced with tombstones, so the deletions can be reconciled
+// with replicas that haven't seen them. If the key doesn't exist, a hash is created to hold them.
+// Missing fields aren't tombstoned by deletions timestamped before the gc's purge horizon
+func (s *Redis) hdel(key string, fields []string, ts time.Time, origin types.UUID) (*Integer, error) {
+	hash, err := s.getHashForWrite(key, ts)
+	if err != nil { return nil, err }
+	if hash == nil {
+		return NewInteger(0, ts), nil
+	}
+
+	num := 0
+	for _, field := range fields {
+		existing, exists := hash.fields[field]
+		if exists && store.WriteBefore(ts, origin, existing) {
+			continue
+		}
+		if !exists && ts.Before(s.gc.PurgedBefore) {
+			continue
+		}
+		tombstone := NewTombstone(ts)
+		tombstone.origin = origin
+		hash.fields[field] = tombstone
+		if exists && existing.GetValueType() == STRING_VALUE {
+			num++
+		}
+	}
+	return NewInteger(int64(num), ts), nil
+}
+
+// Returns all fields and values of the hash stored at key.
+//
+// internally, the returned hash includes the tombstones of deleted fields, so
+// the results of HGETALL can be reconciled. Use GetFields on the returned hash
+// to get the fields that haven't been deleted
+func (s *Redis) hgetall(key string) (store.Value, error) {
+	hash, err := s.getHashForRead(key)
+	if err != nil || hash == nil { return nil, err }
+
+	rval := NewHash()
+	for field, val := range hash.fields {
+		rval.fields[field] = val
+	}
+	return rval, nil
+}
+
+// Returns the number of fields contained in the hash stored at key.
+// Return value: number of fields in the hash, or 0 when key does not exist.
+func (s *Redis) hlen(key string) (*Integer, error) {
+	hash, err := s.getHashForRead(key)
+	if err != nil { return nil, err }
+	if hash == nil {
+		return NewInteger(0, time.Time{}), nil
+	}
+	return NewInteger(int64(hash.Len()), hash.GetTimestamp()), nil
+}
@@ -0,0 +1,232 @@
+package redis
+
+import (
+	"store"
+	"testing"
+	"time"
+	"testing_helpers"
+)
+
+func TestHSet(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "b"}, ts0))
+	if err != nil {
+		t.Fatalf("Unexpected error on HSET: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num added", int64(1), val.(*Integer).GetValue())
+
+	hash, ok := r.data["a"].(*Hash)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", r.data["a"])
+	}
+	fval, exists := hash.GetField("f")
+	testing_helpers.AssertEqual(t, "exists", true, exists)
+	testing_helpers.AssertEqual(t, "value", "b", fval)
+
+	// overwrite the field
+	val, err = r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "c"}, ts0.Add(time.Duration(1))))
+	if err != nil {
+		t.Fatalf("Unexpected error on HSET: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num added", int64(0), val.(*Integer).GetValue())
+	fval, _ = hash.GetField("f")
+	testing_helpers.AssertEqual(t, "value", "c", fval)
+}
+
+// tests setting several fields with one instruction
+func TestHSetMultipleFields(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "b"}, ts0))
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "c", "g", "d", "h", "e"}, ts0.Add(time.Duration(1))))
+	if err != nil {
+		t.Fatalf("Unexpected error on HSET: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num added", int64(2), val.(*Integer).GetValue())
+
+	fields := r.data["a"].(*Hash).GetFields()
+	testing_helpers.AssertEqual(t, "num fields", 3, len(fields))
+	testing_helpers.AssertEqual(t, "value", "c", fields["f"])
+	testing_helpers.AssertEqual(t, "value", "d", fields["g"])
+	testing_helpers.AssertEqual(t, "value", "e", fields["h"])
+}
+
+// tests that writes older than the field's
+// current value are ignored
+func TestHSetOutOfOrder(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "b"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "c"}, ts1))
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"g", "d"}, ts1))
+
+	hash := r.data["a"].(*Hash)
+	fval, _ := hash.GetField("f")
+	testing_helpers.AssertEqual(t, "value", "b", fval)
+	gval, _ := hash.GetField("g")
+	testing_helpers.AssertEqual(t, "value", "d", gval)
+}
+
+// tests that HSET on a deleted key creates a new hash, unless
+// the deletion happened after the HSET
+func TestHSetTombstone(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+
+	r.data["a"] = NewTombstone(ts0)
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "b"}, ts0.Add(time.Duration(-1))))
+	if _, ok := r.data["a"].(*Tombstone); !ok {
+		t.Fatalf("Unexpected value type: %T", r.data["a"])
+	}
+
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "b"}, ts0.Add(time.Duration(1))))
+	if _, ok := r.data["a"].(*Hash); !ok {
+		t.Fatalf("Unexpected value type: %T", r.data["a"])
+	}
+}
+
+func TestHSetWrongType(t *testing.T) {
+	r := setupRedis()
+	r.data["a"] = NewString("b", time.Now())
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "b"}, time.Now()))
+	if val != nil {
+		t.Errorf("Unexpected non-nil value")
+	}
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestHGet(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "b"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"g", "c"}, ts0))
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("HGET", "a", []string{"f"}, time.Time{}))
+	if err != nil {
+		t.Fatalf("Unexpected error on HGET: %v", err)
+	}
+	hash, ok := val.(*Hash)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", val)
+	}
+
+	// only the requested field should be returned
+	testing_helpers.AssertEqual(t, "num fields", 1, hash.Len())
+	fval, exists := hash.GetField("f")
+	testing_helpers.AssertEqual(t, "exists", true, exists)
+	testing_helpers.AssertEqual(t, "value", "b", fval)
+	testing_helpers.AssertEqual(t, "time", ts0, hash.GetTimestamp())
+
+	// missing fields and keys return nil
+	val, err = r.ExecuteInstruction(store.NewInstruction("HGET", "a", []string{"x"}, time.Time{}))
+	if val != nil || err != nil {
+		t.Errorf("Expected nil value and error, got %v, %v", val, err)
+	}
+	val, err = r.ExecuteInstruction(store.NewInstruction("HGET", "b", []string{"f"}, time.Time{}))
+	if val != nil || err != nil {
+		t.Errorf("Expected nil value and error, got %v, %v", val, err)
+	}
+}
+
+func TestHDel(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "b"}, ts0))
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("HDEL", "a", []string{"f"}, ts0.Add(time.Duration(1))))
+	if err != nil {
+		t.Fatalf("Unexpected error on HDEL: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num deleted", int64(1), val.(*Integer).GetValue())
+
+	hash := r.data["a"].(*Hash)
+	_, exists := hash.GetField("f")
+	testing_helpers.AssertEqual(t, "exists", false, exists)
+	testing_helpers.AssertEqual(t, "num fields", 0, hash.Len())
+	if _, ok := hash.fields["f"].(*Tombstone); !ok {
+		t.Errorf("Expected field tombstone, got %T", hash.fields["f"])
+	}
+
+	// deleting it again shouldn't report a deletion
+	val, _ = r.ExecuteInstruction(store.NewInstruction("HDEL", "a", []string{"f"}, ts0.Add(time.Duration(2))))
+	testing_helpers.AssertEqual(t, "num deleted", int64(0), val.(*Integer).GetValue())
+}
+
+// tests deleting several fields with one instruction
+func TestHDelMultipleFields(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "b", "g", "c", "h", "d"}, ts0))
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("HDEL", "a", []string{"f", "g", "x"}, ts0.Add(time.Duration(1))))
+	if err != nil {
+		t.Fatalf("Unexpected error on HDEL: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num deleted", int64(2), val.(*Integer).GetValue())
+
+	fields := r.data["a"].(*Hash).GetFields()
+	testing_helpers.AssertEqual(t, "num fields", 1, len(fields))
+	testing_helpers.AssertEqual(t, "value", "d", fields["h"])
+}
+
+func TestHGetAllAndHLen(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "b"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"g", "c"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("HDEL", "a", []string{"g"}, ts0.Add(time.Duration(1))))
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("HGETALL", "a", []string{}, time.Time{}))
+	if err != nil {
+		t.Fatalf("Unexpected error on HGETALL: %v", err)
+	}
+	fields := val.(*Hash).GetFields()
+	testing_helpers.AssertEqual(t, "num fields", 1, len(fields))
+	testing_helpers.AssertEqual(t, "value", "b", fields["f"])
+
+	val, err = r.ExecuteInstruction(store.NewInstruction("HLEN", "a", []string{}, time.Time{}))
+	if err != nil {
+		t.Fatalf("Unexpected error on HLEN: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "len", int64(1), val.(*Integer).GetValue())
+
+	val, err = r.ExecuteInstruction(store.NewInstruction("HLEN", "b", []string{}, time.Time{}))
+	if err != nil {
+		t.Fatalf("Unexpected error on HLEN: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "len", int64(0), val.(*Integer).GetValue())
+}
+
+// tests validation of hash instructions
+func TestHashValidation(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+
+	var invalid = []store.Instruction{
+		store.NewInstruction("HSET", "a", []string{"f"}, ts0),
+		store.NewInstruction("HSET", "a", []string{"f", "b", "g"}, ts0),
+		store.NewInstruction("HSET", "a", []string{"f", "b"}, time.Time{}),
+		store.NewInstruction("HGET", "a", []string{}, time.Time{}),
+		store.NewInstruction("HDEL", "a", []string{}, ts0),
+		store.NewInstruction("HDEL", "a", []string{"f"}, time.Time{}),
+		store.NewInstruction("HGETALL", "a", []string{"f"}, time.Time{}),
+		store.NewInstruction("HLEN", "a", []string{"f"}, time.Time{}),
+	}
+	for _, instruction := range invalid {
+		val, err := r.ExecuteInstruction(instruction)
+		if val != nil {
+			t.Errorf("Unexpected non-nil value for %v", instruction)
+		}
+		if err == nil {
+			t.Errorf("Expected error for %v, got nil", instruction)
+		}
+	}
+}
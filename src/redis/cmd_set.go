@@ -4,6 +4,7 @@ import (
 	"store"
 	"time"
 	"fmt"
+	"types"
 )
 
 func (s *Redis) validateSet(key string, args []string, timestamp time.Time) error {
@@ -20,13 +21,22 @@ func (s *Redis) validateSet(key string, args []string, timestamp time.Time) erro
 // Set key to hold the string value. If key already holds a value, it is overwritten,
 // regardless of its type. Any previous time to live associated with the key is discarded
 // on successful SET operation.
-func (s *Redis) set(key string, val string, ts time.Time) (store.Value) {
+//
+// internally, writes older than the current value are ignored. Writes with equal
+// timestamps are ordered by the id of the node that issued them. Sets on missing
+// keys timestamped before the tombstone purge horizon are ignored, since they may
+// be undoing a purged deletion
+func (s *Redis) set(key string, val string, ts time.Time, origin types.UUID) (store.Value) {
 	existing, exists := s.data[key]
-	if exists && ts.Before(existing.GetTimestamp()) {
+	if exists && store.WriteBefore(ts, origin, existing) {
 		return existing
 	}
+	if !exists && ts.Before(s.gc.PurgedBefore) {
+		return NewTombstone(s.gc.PurgedBefore)
+	}
 	value := NewString(val, ts)
-	s.data[key] = value
+	value.origin = origin
+	s.setValue(key, value)
 	return value
 }
 
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"store"
+	"types"
 )
 
 // tests basic function of set
@@ -49,12 +50,35 @@ func TestSetConflictingTimestamp(t *testing.T) {
 	r := setupRedis()
 	now := time.Now()
 	then := now.Add(time.Duration(-1))
-	expected := r.set("a", "b", now)
-	actual := r.set("a", "c", then)
+	expected := r.set("a", "b", now, types.UUID{})
+	actual := r.set("a", "c", then, types.UUID{})
 	testing_helpers.AssertEqual(t, "set val", expected, actual)
 
 }
 
+// writes with equal timestamps should be ordered by their origins,
+// so replicas receiving them in different orders agree on the value
+func TestSetEqualTimestamps(t *testing.T) {
+	ts := time.Now()
+	i0 := store.NewInstruction("SET", "a", []string{"b"}, ts)
+	i1 := store.NewInstruction("SET", "a", []string{"c"}, ts)
+	i1.Origin = types.NewUUID1()
+
+	r0 := setupRedis()
+	r0.ExecuteInstruction(i0)
+	r0.ExecuteInstruction(i1)
+
+	r1 := setupRedis()
+	r1.ExecuteInstruction(i1)
+	r1.ExecuteInstruction(i0)
+
+	v0, _ := r0.GetRawKey("a")
+	v1, _ := r1.GetRawKey("a")
+	testing_helpers.AssertEqual(t, "r0 val", "c", v0.(*String).GetValue())
+	testing_helpers.AssertEqual(t, "r1 val", "c", v1.(*String).GetValue())
+	testing_helpers.AssertEqual(t, "origin", i1.Origin, store.GetOrigin(v0))
+}
+
 // tests validation of SET insructions
 func TestSetValidation(t *testing.T) {
 	r := setupRedis()
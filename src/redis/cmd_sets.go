@@ -0,0 +1,211 @@
+package redis
+
+import (
+	"fmt"
+	"time"
+
+	"store"
+	"types"
+)
+
+func (s *Redis) validateSetMembers(cmd string, key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) < 1 {
+		return fmt.Errorf("%v requires at least 1 member", cmd)
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("%v Got zero timestamp", cmd)
+	}
+	return nil
+}
+
+func (s *Redis) validateSRemTag(key string, args []string, timestamp time.Time) error {
+	_ = key
+	if len(args) != 1 {
+		return fmt.Errorf("incorrect number of args for SREMTAG. Expected 1, got %v", len(args))
+	}
+	if timestamp.IsZero() {
+		return fmt.Errorf("SREMTAG Got zero timestamp")
+	}
+	return nil
+}
+
+func (s *Redis) validateSIsMember(key string, args []string) error {
+	_ = key
+	if len(args) != 1 {
+		return fmt.Errorf("incorrect number of args for SISMEMBER. Expected 1, got %v", len(args))
+	}
+	return nil
+}
+
+func (s *Redis) validateSMembers(key string, args []string) error {
+	_ = key
+	if len(args) != 0 {
+		return fmt.Errorf("SMEMBERS takes 0 args, %v found", len(args))
+	}
+	return nil
+}
+
+func (s *Redis) validateSCard(key string, args []string) error {
+	_ = key
+	if len(args) != 0 {
+		return fmt.Errorf("SCARD takes 0 args, %v found", len(args))
+	}
+	return nil
+}
+
+// returns the set stored at the given key for writing. If the key
+// doesn't exist, or has been deleted before the given timestamp, a
+// new set is stored at the key. Nil is returned if the key was
+// deleted after the given timestamp, or may have been deleted by a
+// purged tombstone, and an error is returned if the key holds a
+// value of another type
+func (s *Redis) getSetForWrite(key string, ts time.Time) (*Set, error) {
+	existing, exists := s.data[key]
+	if !exists {
+		if ts.Before(s.gc.PurgedBefore) {
+			return nil, nil
+		}
+		set := NewSet()
+		s.setValue(key, set)
+		return set, nil
+	}
+	switch val := existing.(type) {
+	case *Set:
+		return val, nil
+	case *Tombstone:
+		if ts.Before(val.time) {
+			return nil, nil
+		}
+		set := NewSet()
+		s.setValue(key, set)
+		return set, nil
+	default:
+		return nil, fmt.Errorf("WRONGTYPE key [%v] holds a %v value, not a set", key, existing.GetValueType())
+	}
+}
+
+// returns the set stored at the given key for reading. Nil is returned
+// if the key doesn't exist, and an error is returned if the key holds
+// a value of another type
+func (s *Redis) getSetForRead(key string) (*Set, error) {
+	existing, exists := s.data[key]
+	if !exists {
+		return nil, nil
+	}
+	switch val := existing.(type) {
+	case *Set:
+		return val, nil
+	case *Tombstone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("WRONGTYPE key [%v] holds a %v value, not a set", key, existing.GetValueType())
+	}
+}
+
+// Add the specified members to the set stored at key. Specified members that are
+// already a member of this set are ignored. If key does not exist, a new set is
+// created before adding the specified members.
+// Return value: the number of elements that were added to the set, not including
+// all the elements already present into the set.
+//
+// internally, each member is tagged with the instruction's timestamp and origin.
+// Tags timestamped before the gc's purge horizon are ignored, since the tombstones
+// of removed tags that old may have been purged
+func (s *Redis) sadd(key string, members []string, ts time.Time, origin types.UUID) (*Integer, error) {
+	set, err := s.getSetForWrite(key, ts)
+	if err != nil { return nil, err }
+	if set == nil || ts.Before(s.gc.PurgedBefore) {
+		return NewInteger(0, ts), nil
+	}
+
+	num := 0
+	for _, member := range members {
+		if set.add(member, ts, origin) {
+			num++
+		}
+	}
+	return NewInteger(int64(num), ts), nil
+}
+
+// Remove the specified members from the set stored at key. Specified members that
+// are not a member of this set are ignored. If key does not exist, it is treated
+// as an empty set and this command returns 0.
+// Return value: the number of members that were removed from the set, not including
+// non existing members.
+//
+// internally, only the tags observed by this replica, that weren't written after
+// the instruction, are tombstoned
+func (s *Redis) srem(key string, members []string, ts time.Time, origin types.UUID) (*Integer, error) {
+	set, err := s.getSetForRead(key)
+	if err != nil { return nil, err }
+	if set == nil {
+		return NewInteger(0, ts), nil
+	}
+
+	num := 0
+	for _, member := range members {
+		if set.remove(member, ts, origin) {
+			num++
+		}
+	}
+	return NewInteger(int64(num), ts), nil
+}
+
+// tombstones the member's tag written at the given timestamp, by the given origin.
+// This isn't a redis command, it's used by reconciliation to propagate removals to
+// replicas that haven't seen them, without removing tags they've observed since
+func (s *Redis) sremtag(key string, member string, ts time.Time, origin types.UUID) error {
+	set, err := s.getSetForWrite(key, ts)
+	if err != nil { return err }
+	if set != nil && !ts.Before(s.gc.PurgedBefore) {
+		set.removeTag(member, ts, origin)
+	}
+	return nil
+}
+
+// Returns if member is a member of the set stored at key.
+//
+// internally, a set containing only the requested member's tags is returned,
+// or nil if the member has never been added. This allows the results of SISMEMBER
+// to be reconciled without touching the rest of the set. Use Contains on the returned
+// set to see if the member is present
+func (s *Redis) sismember(key string, member string) (store.Value, error) {
+	set, err := s.getSetForRead(key)
+	if err != nil || set == nil { return nil, err }
+
+	m, exists := set.members[member]
+	if !exists {
+		return nil, nil
+	}
+	rval := NewSet()
+	rval.members[member] = m.copy()
+	return rval, nil
+}
+
+// Returns all the members of the set value stored at key.
+//
+// internally, the returned set includes the tombstoned tags, so the results
+// of SMEMBERS can be reconciled. Use Members on the returned set to get
+// the present members
+func (s *Redis) smembers(key string) (store.Value, error) {
+	set, err := s.getSetForRead(key)
+	if err != nil || set == nil { return nil, err }
+
+	rval := NewSet()
+	for member, m := range set.members {
+		rval.members[member] = m.copy()
+	}
+	return rval, nil
+}
+
+// Returns the set cardinality (number of elements) of the set stored at key.
+// Return value: the cardinality of the set, or 0 if key does not exist.
+func (s *Redis) scard(key string) (*Integer, error) {
+	set, err := s.getSetForRead(key)
+	if err != nil { return nil, err }
+	if set == nil {
+		return NewInteger(0, time.Time{}), nil
+	}
+	return NewInteger(int64(set.Len()), set.GetTimestamp()), nil
+}
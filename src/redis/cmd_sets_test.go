@@ -0,0 +1,171 @@
+package redis
+
+import (
+	"store"
+	"testing"
+	"time"
+	"testing_helpers"
+	"types"
+)
+
+func TestSAdd(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("SADD", "a", []string{"x", "y"}, ts0))
+	if err != nil {
+		t.Fatalf("Unexpected error on SADD: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num added", int64(2), val.(*Integer).GetValue())
+
+	val, err = r.ExecuteInstruction(store.NewInstruction("SADD", "a", []string{"y", "z"}, ts0.Add(time.Duration(1))))
+	if err != nil {
+		t.Fatalf("Unexpected error on SADD: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num added", int64(1), val.(*Integer).GetValue())
+
+	set, ok := r.data["a"].(*Set)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", r.data["a"])
+	}
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"x", "y", "z"}, set.Members())
+}
+
+func TestSRem(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("SADD", "a", []string{"x", "y"}, ts0))
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("SREM", "a", []string{"x", "z"}, ts0.Add(time.Duration(1))))
+	if err != nil {
+		t.Fatalf("Unexpected error on SREM: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num removed", int64(1), val.(*Integer).GetValue())
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"y"}, r.data["a"].(*Set).Members())
+
+	// the removed tag shouldn't be re-added
+	r.ExecuteInstruction(store.NewInstruction("SADD", "a", []string{"x"}, ts0))
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"y"}, r.data["a"].(*Set).Members())
+}
+
+// tests that a remove doesn't affect adds
+// with newer timestamps
+func TestSRemNewerAdd(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+
+	r.ExecuteInstruction(store.NewInstruction("SADD", "a", []string{"x"}, ts0))
+	val, err := r.ExecuteInstruction(store.NewInstruction("SREM", "a", []string{"x"}, ts1))
+	if err != nil {
+		t.Fatalf("Unexpected error on SREM: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num removed", int64(0), val.(*Integer).GetValue())
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"x"}, r.data["a"].(*Set).Members())
+}
+
+func TestSIsMember(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("SADD", "a", []string{"x", "y"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("SREM", "a", []string{"y"}, ts0))
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("SISMEMBER", "a", []string{"x"}, time.Time{}))
+	if err != nil {
+		t.Fatalf("Unexpected error on SISMEMBER: %v", err)
+	}
+	set := val.(*Set)
+	testing_helpers.AssertEqual(t, "is member", true, set.Contains("x"))
+	testing_helpers.AssertEqual(t, "num members", 1, len(set.members))
+
+	val, _ = r.ExecuteInstruction(store.NewInstruction("SISMEMBER", "a", []string{"y"}, time.Time{}))
+	testing_helpers.AssertEqual(t, "is member", false, val.(*Set).Contains("y"))
+
+	val, err = r.ExecuteInstruction(store.NewInstruction("SISMEMBER", "a", []string{"z"}, time.Time{}))
+	if val != nil || err != nil {
+		t.Errorf("Expected nil value and error, got %v, %v", val, err)
+	}
+}
+
+func TestSMembersAndSCard(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("SADD", "a", []string{"x", "y", "z"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("SREM", "a", []string{"y"}, ts0))
+
+	val, err := r.ExecuteInstruction(store.NewInstruction("SMEMBERS", "a", []string{}, time.Time{}))
+	if err != nil {
+		t.Fatalf("Unexpected error on SMEMBERS: %v", err)
+	}
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"x", "z"}, val.(*Set).Members())
+
+	// the returned value should be a copy
+	val.(*Set).add("w", ts0, types.UUID{})
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"x", "z"}, r.data["a"].(*Set).Members())
+
+	val, err = r.ExecuteInstruction(store.NewInstruction("SCARD", "a", []string{}, time.Time{}))
+	if err != nil {
+		t.Fatalf("Unexpected error on SCARD: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "cardinality", int64(2), val.(*Integer).GetValue())
+
+	val, _ = r.ExecuteInstruction(store.NewInstruction("SCARD", "b", []string{}, time.Time{}))
+	testing_helpers.AssertEqual(t, "cardinality", int64(0), val.(*Integer).GetValue())
+}
+
+// tests that SADD on a deleted key creates a new set, unless
+// the deletion happened after the SADD
+func TestSAddTombstone(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	r.data["a"] = NewTombstone(ts0)
+
+	r.ExecuteInstruction(store.NewInstruction("SADD", "a", []string{"x"}, ts0.Add(time.Duration(-1))))
+	if _, ok := r.data["a"].(*Tombstone); !ok {
+		t.Fatalf("Unexpected value type: %T", r.data["a"])
+	}
+
+	r.ExecuteInstruction(store.NewInstruction("SADD", "a", []string{"x"}, ts0.Add(time.Duration(1))))
+	set, ok := r.data["a"].(*Set)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", r.data["a"])
+	}
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"x"}, set.Members())
+}
+
+func TestSetWrongType(t *testing.T) {
+	r := setupRedis()
+	r.data["a"] = NewString("b", time.Now())
+
+	for _, cmd := range []string{"SADD", "SREM"} {
+		val, err := r.ExecuteInstruction(store.NewInstruction(cmd, "a", []string{"x"}, time.Now()))
+		if val != nil {
+			t.Errorf("Unexpected non-nil value")
+		}
+		if err == nil {
+			t.Errorf("Expected error, got nil")
+		}
+	}
+}
+
+// tests that clients can't execute the internal SREMTAG command
+func TestSRemTagInternal(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("SADD", "a", []string{"x"}, ts0))
+
+	instruction := store.NewInstruction("SREMTAG", "a", []string{"x"}, ts0)
+	if _, err := r.ExecuteInstruction(instruction); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	if _, err := r.ExecuteWrite("SREMTAG", "a", []string{"x"}, ts0); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{"x"}, r.data["a"].(*Set).Members())
+
+	instruction.Internal = true
+	if _, err := r.ExecuteInstruction(instruction); err != nil {
+		t.Fatalf("Unexpected error on SREMTAG: %v", err)
+	}
+	testing_helpers.AssertStringArrayEqual(t, "members", []string{}, r.data["a"].(*Set).Members())
+}
@@ -0,0 +1,42 @@
+package redis
+
+import (
+	"testing"
+	"testing_helpers"
+	"time"
+	"types"
+)
+
+// tests that only tombstones older than the
+// grace period are purged
+func TestPurgeTombstones(t *testing.T) {
+	r := setupRedis()
+	r.SetGCGrace(time.Hour)
+	ts0 := time.Unix(100000, 0)
+
+	r.set("a", "b", ts0, types.UUID{})
+	r.data["b"] = NewTombstone(ts0)
+	r.data["c"] = NewTombstone(ts0.Add(2 * time.Hour))
+
+	testing_helpers.AssertEqual(t, "num purged", 1, r.purgeTombstones(ts0.Add(90 * time.Minute)))
+	testing_helpers.AssertEqual(t, "total purged", uint64(1), r.GetNumPurged())
+	testing_helpers.AssertEqual(t, "a exists", true, r.KeyExists("a"))
+	testing_helpers.AssertEqual(t, "b exists", false, r.KeyExists("b"))
+	testing_helpers.AssertEqual(t, "c exists", true, r.KeyExists("c"))
+}
+
+// tests that sets made before the purge horizon don't
+// recreate keys that may have been deleted
+func TestPurgedKeySet(t *testing.T) {
+	r := setupRedis()
+	r.SetGCGrace(time.Hour)
+	ts0 := time.Unix(100000, 0)
+	r.data["a"] = NewTombstone(ts0)
+	r.purgeTombstones(ts0.Add(2 * time.Hour))
+
+	r.ExecuteWrite("SET", "a", []string{"b"}, ts0.Add(-time.Minute))
+	testing_helpers.AssertEqual(t, "a exists", false, r.KeyExists("a"))
+
+	r.ExecuteWrite("SET", "a", []string{"b"}, ts0.Add(90 * time.Minute))
+	testing_helpers.AssertEqual(t, "a exists", true, r.KeyExists("a"))
+}
@@ -0,0 +1,195 @@
+package redis
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+import (
+	"store"
+)
+
+// the default reconcile policy, the value with the highest timestamp wins
+var LastWriteWins = store.ReconcileFunc(func(key string, values []store.Value) (store.Value, [][]store.Instruction, error) {
+	return reconcileSlice(reconcileLastWriteWins, key, values)
+})
+
+// reconciles numeric string values by keeping the largest, useful
+// for high water marks. Values that can't be parsed as numbers
+// cause reconciliation to fail
+var MaxPolicy = store.ReconcileFunc(func(key string, values []store.Value) (store.Value, [][]store.Instruction, error) {
+	return reconcileSlice(reconcileMax, key, values)
+})
+
+// returns a policy that reconciles string values containing lists of
+// members separated by sep, by taking the union of the members of
+// each value. Members of the reconciled value are sorted
+func NewUnionPolicy(sep string) store.ReconcilePolicy {
+	return store.ReconcileFunc(func(key string, values []store.Value) (store.Value, [][]store.Instruction, error) {
+		return reconcileSlice(func(key string, values map[string]store.Value) (store.Value, map[string][]*store.Instruction, error) {
+			return reconcileUnion(sep, key, values)
+		}, key, values)
+	})
+}
+
+// reconciles a map of node ids -> values, keeping the value with the highest timestamp
+func reconcileLastWriteWins(key string, values map[string]store.Value) (store.Value, map[string][]*store.Instruction, error) {
+	highValue := getHighValue(values)
+	if highValue == nil {
+		// none of the values have a timestamp, so there's nothing to reconcile.
+		// Counts of missing keys aren't timestamped, so they're returned as is
+		for _, val := range values {
+			if val, ok := val.(*Integer); ok {
+				return val, make(map[string][]*store.Instruction), nil
+			}
+		}
+		return nil, make(map[string][]*store.Instruction), nil
+	}
+
+	switch highValue.GetValueType() {
+	case STRING_VALUE:
+		return reconcileString(key, highValue.(*String), values)
+	case TOMBSTONE_VALUE:
+		return reconcileTombstone(key, highValue.(*Tombstone), values)
+	case HASH_VALUE:
+		return reconcileHash(key, values)
+	case SET_VALUE:
+		return reconcileSet(key, values)
+	case INTEGER_VALUE:
+		return reconcileInteger(key, values)
+	default:
+		return nil, make(map[string][]*store.Instruction), fmt.Errorf("Unknown value type: %T", highValue)
+	}
+}
+
+// keeps the largest numeric string value
+//
+// if the value with the highest timestamp isn't a string, the key has
+// been deleted, so the values are reconciled with LastWriteWins instead.
+// Otherwise, the largest value takes the highest timestamp, so the
+// corrective SET instructions are applied by every replica
+func reconcileMax(key string, values map[string]store.Value) (store.Value, map[string][]*store.Instruction, error) {
+	highValue := getHighValue(values)
+	if highValue == nil || highValue.GetValueType() != STRING_VALUE {
+		return reconcileLastWriteWins(key, values)
+	}
+
+	var maxStr string
+	var maxNum float64
+	found := false
+	for _, val := range values {
+		str, ok := val.(*String)
+		if !ok {
+			continue
+		}
+		num, err := strconv.ParseFloat(str.value, 64)
+		if err != nil {
+			return nil, make(map[string][]*store.Instruction), fmt.Errorf("Cannot reconcile non numeric value: %v", str.value)
+		}
+		if !found || num > maxNum {
+			maxNum = num
+			maxStr = str.value
+			found = true
+		}
+	}
+
+	high := highValue.(*String)
+	maxValue := NewString(maxStr, high.time)
+	maxValue.origin = high.origin
+	return reconcileString(key, maxValue, values)
+}
+
+// takes the union of the members of each string value
+//
+// like reconcileMax, deleted keys are reconciled with LastWriteWins, and
+// the union takes the highest timestamp and origin, so the corrective SET
+// instructions are applied by every replica
+func reconcileUnion(sep string, key string, values map[string]store.Value) (store.Value, map[string][]*store.Instruction, error) {
+	highValue := getHighValue(values)
+	if highValue == nil || highValue.GetValueType() != STRING_VALUE {
+		return reconcileLastWriteWins(key, values)
+	}
+
+	members := make(map[string]bool)
+	for _, val := range values {
+		str, ok := val.(*String)
+		if !ok {
+			continue
+		}
+		for _, member := range strings.Split(str.value, sep) {
+			if member != "" {
+				members[member] = true
+			}
+		}
+	}
+
+	union := make([]string, 0, len(members))
+	for member := range members {
+		union = append(union, member)
+	}
+	sort.Strings(union)
+
+	high := highValue.(*String)
+	unionValue := NewString(strings.Join(union, sep), high.time)
+	unionValue.origin = high.origin
+	return reconcileString(key, unionValue, values)
+}
+
+// reconciles a map of node ids -> values with the given policy
+func reconcileWithPolicy(policy store.ReconcilePolicy, key string, values map[string]store.Value) (store.Value, map[string][]*store.Instruction, error) {
+	nodeids := make([]string, 0, len(values))
+	valueSlice := make([]store.Value, 0, len(values))
+	for nodeid, val := range values {
+		nodeids = append(nodeids, nodeid)
+		valueSlice = append(valueSlice, val)
+	}
+
+	val, adjustments, err := policy.Reconcile(key, valueSlice)
+	if err != nil {
+		return nil, make(map[string][]*store.Instruction), err
+	}
+	if len(adjustments) != len(values) {
+		return nil, make(map[string][]*store.Instruction), fmt.Errorf("Expected %v sets of instructions, got %v", len(values), len(adjustments))
+	}
+
+	instructions := make(map[string][]*store.Instruction)
+	for i, adjustment := range adjustments {
+		if len(adjustment) == 0 {
+			continue
+		}
+		nodeInstructions := make([]*store.Instruction, len(adjustment))
+		for j := range adjustment {
+			nodeInstructions[j] = &adjustment[j]
+		}
+		instructions[nodeids[i]] = nodeInstructions
+	}
+	return val, instructions, nil
+}
+
+// adapts a function reconciling a map of node ids -> values to
+// the ReconcilePolicy interface, which reconciles a slice of values
+func reconcileSlice(
+	reconcile func(string, map[string]store.Value) (store.Value, map[string][]*store.Instruction, error),
+	key string,
+	values []store.Value,
+) (store.Value, [][]store.Instruction, error) {
+	valueMap := make(map[string]store.Value, len(values))
+	for i, val := range values {
+		valueMap[strconv.Itoa(i)] = val
+	}
+
+	val, instructions, err := reconcile(key, valueMap)
+	if err != nil {
+		return nil, [][]store.Instruction{}, err
+	}
+
+	adjustments := make([][]store.Instruction, len(values))
+	for i := range values {
+		for _, instruction := range instructions[strconv.Itoa(i)] {
+			adjustments[i] = append(adjustments[i], *instruction)
+		}
+	}
+	return val, adjustments, nil
+}
@@ -0,0 +1,204 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"testing_helpers"
+	"store"
+	"types"
+)
+
+// tests that keys without a registered policy
+// are reconciled with last write wins
+func TestReconcileDefaultPolicy(t *testing.T) {
+	r := setupRedis()
+	r.RegisterReconcilePolicy("hw:", MaxPolicy)
+
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	expected := NewString("1", ts0)
+	vmap := map[string]store.Value{"0": expected, "1": NewString("5", ts1)}
+
+	ractual, _, err := r.Reconcile("k", vmap)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	assertEqualValue(t, "reconciled value", expected, ractual)
+}
+
+// tests that the policy registered for a key's prefix is used,
+// and it's adjustments are returned for the right nodes
+func TestReconcileMaxPolicy(t *testing.T) {
+	r := setupRedis()
+	r.RegisterReconcilePolicy("hw:", MaxPolicy)
+
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	vmap := map[string]store.Value{
+		"0": NewString("1", ts0),
+		"1": NewString("5", ts1),
+		"2": NewString("5", ts0),
+	}
+
+	ractual, adjustments, err := r.Reconcile("hw:k", vmap)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	// the max value should take the highest timestamp
+	expected := NewString("5", ts0)
+	assertEqualValue(t, "reconciled value", expected, ractual)
+	testing_helpers.AssertEqual(t, "adjustment size", 2, len(adjustments))
+	testing_helpers.AssertEqual(t, "node 2 adjustment", 0, len(adjustments["2"]))
+
+	expected_instr := store.Instruction{Cmd:"SET", Key:"hw:k", Args:[]string{"5"}, Timestamp:ts0}
+	for _, nodeid := range []string{"0", "1"} {
+		adjustment := adjustments[nodeid]
+		testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustment))
+		if !expected_instr.Equal(*adjustment[0]) {
+			t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, *adjustment[0])
+		}
+	}
+}
+
+func TestReconcileMaxPolicyNonNumeric(t *testing.T) {
+	r := setupRedis()
+	r.RegisterReconcilePolicy("hw:", MaxPolicy)
+
+	ts0 := time.Now()
+	vmap := map[string]store.Value{"0": NewString("1", ts0), "1": NewString("x", ts0)}
+
+	_, _, err := r.Reconcile("hw:k", vmap)
+	if err == nil {
+		t.Fatalf("expected reconciliation error")
+	}
+}
+
+// tests that deletions win over the max policy
+// when they're the most recent write
+func TestReconcileMaxPolicyTombstone(t *testing.T) {
+	r := setupRedis()
+	r.RegisterReconcilePolicy("hw:", MaxPolicy)
+
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	expected := NewTombstone(ts0)
+	vmap := map[string]store.Value{"0": expected, "1": NewString("5", ts1)}
+
+	ractual, adjustments, err := r.Reconcile("hw:k", vmap)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	assertEqualValue(t, "reconciled value", expected, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments["1"]))
+	testing_helpers.AssertEqual(t, "instruction", "DEL", adjustments["1"][0].Cmd)
+}
+
+// tests that a LastWriteWins policy registered under a longer prefix
+// overrides the policy registered under a shorter one
+func TestReconcileLastWriteWinsPolicy(t *testing.T) {
+	r := setupRedis()
+	r.RegisterReconcilePolicy("hw:", MaxPolicy)
+	r.RegisterReconcilePolicy("hw:lww:", LastWriteWins)
+
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	expected := NewString("1", ts0)
+	vmap := map[string]store.Value{"0": expected, "1": NewString("5", ts1)}
+
+	ractual, adjustments, err := r.Reconcile("hw:lww:k", vmap)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	assertEqualValue(t, "reconciled value", expected, ractual)
+	testing_helpers.AssertEqual(t, "adjustment size", 1, len(adjustments))
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments["1"]))
+}
+
+// tests that values with equal timestamps are reconciled
+// to the value issued by the node with the highest id,
+// and the corrections carry it's origin
+func TestReconcileEqualTimestamps(t *testing.T) {
+	ts := time.Now()
+	v0 := NewString("a", ts)
+	v1 := NewString("b", ts)
+	v1.origin = types.NewUUID1()
+	vmap := map[string]store.Value{"0": v0, "1": v1}
+
+	ractual, adjustments, err := setupRedis().Reconcile("k", vmap)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	assertEqualValue(t, "reconciled value", v1, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments["0"]))
+	testing_helpers.AssertEqual(t, "origin", v1.origin, adjustments["0"][0].Origin)
+}
+
+// tests that the union policy merges the members of every value, and
+// that applying it's adjustments to each replica converges them
+func TestReconcileUnionPolicy(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	writes := map[string]store.Instruction{
+		"0": store.Instruction{Cmd:"SET", Key:"tags:k", Args:[]string{"b,c"}, Timestamp:ts0, Origin:types.NewUUID1()},
+		"1": store.Instruction{Cmd:"SET", Key:"tags:k", Args:[]string{"a"}, Timestamp:ts0, Origin:types.NewUUID1()},
+		"2": store.Instruction{Cmd:"SET", Key:"tags:k", Args:[]string{"c,d"}, Timestamp:ts1, Origin:types.NewUUID1()},
+	}
+
+	replicas := make(map[string]*Redis)
+	vmap := make(map[string]store.Value)
+	for nodeid, instruction := range writes {
+		replicas[nodeid] = setupRedis()
+		if _, err := replicas[nodeid].ExecuteInstruction(instruction); err != nil {
+			t.Fatalf("unexpected write error: %v", err)
+		}
+		val, err := replicas[nodeid].GetRawKey("tags:k")
+		if err != nil {
+			t.Fatalf("unexpected read error: %v", err)
+		}
+		vmap[nodeid] = val
+	}
+
+	r := setupRedis()
+	r.RegisterReconcilePolicy("tags:", NewUnionPolicy(","))
+	reconciled, adjustments, err := r.Reconcile("tags:k", vmap)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "reconciled value", "a,b,c,d", reconciled.(*String).value)
+	testing_helpers.AssertEqual(t, "adjustment size", 3, len(adjustments))
+
+	for nodeid, replica := range replicas {
+		for _, instruction := range adjustments[nodeid] {
+			if _, err := replica.ExecuteInstruction(*instruction); err != nil {
+				t.Fatalf("unexpected adjustment error: %v", err)
+			}
+		}
+		val, err := replica.GetRawKey("tags:k")
+		if err != nil {
+			t.Fatalf("unexpected read error: %v", err)
+		}
+		assertEqualValue(t, "replica value", reconciled, val)
+	}
+}
+
+// tests that the union policy defers to last
+// write wins if the key has since been deleted
+func TestReconcileUnionPolicyDeleted(t *testing.T) {
+	r := setupRedis()
+	r.RegisterReconcilePolicy("tags:", NewUnionPolicy(","))
+
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	expected := NewTombstone(ts0)
+	vmap := map[string]store.Value{"0": expected, "1": NewString("a,b", ts1)}
+
+	ractual, adjustments, err := r.Reconcile("tags:k", vmap)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	assertEqualValue(t, "reconciled value", expected, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments["1"]))
+	testing_helpers.AssertEqual(t, "instruction", "DEL", adjustments["1"][0].Cmd)
+}
@@ -9,19 +9,37 @@ import (
 )
 
 import (
+	"partitioner"
 	"store"
+	"types"
 )
 
 
 // read instructions
 const (
 	GET		= "GET"
+	HGET	= "HGET"
+	HGETALL	= "HGETALL"
+	HLEN	= "HLEN"
+	SISMEMBER	= "SISMEMBER"
+	SMEMBERS	= "SMEMBERS"
+	SCARD	= "SCARD"
 )
 
 // write instructions
 const (
 	SET		= "SET"
 	DEL		= "DEL"
+	HSET	= "HSET"
+	HDEL	= "HDEL"
+	SADD	= "SADD"
+	SREM	= "SREM"
+)
+
+// internal write instructions, used by reconciliation,
+// which can't be executed by clients
+const (
+	SREMTAG	= "SREMTAG"
 )
 
 
@@ -29,6 +47,19 @@ type Redis struct {
 
 	data map[string] store.Value
 
+	// key prefix -> reconcile policy
+	policies *store.ReconcileRegistry
+
+	// closed to stop the tombstone gc
+	stop chan bool
+
+	// purges old tombstones, guarded by lock
+	gc store.TombstoneGC
+
+	// token ordered index of the keys, built by the first
+	// iteration over the keys, and guarded by lock
+	index *store.KeyIndex
+
 	// TODO: delete
 	// temporary lock, used until
 	// things are broken out into
@@ -40,6 +71,8 @@ type Redis struct {
 func NewRedis() *Redis {
 	r := &Redis{
 		data:make(map[string] store.Value),
+		policies:store.NewReconcileRegistry(),
+		gc:store.NewTombstoneGC(),
 	}
 	return r
 }
@@ -57,11 +90,21 @@ func (s *Redis) DeserializeValue(b []byte) (store.Value, store.ValueType, error)
 	return val, vtype, nil
 }
 
+// starts the gc that purges old tombstones
 func (s *Redis) Start() error {
+	if s.stop != nil {
+		return fmt.Errorf("Redis already started")
+	}
+	s.stop = make(chan bool)
+	go store.RunGC(s.stop, store.GC_INTERVAL, func() { s.purgeTombstones(time.Now()) })
 	return nil
 }
 
 func (s *Redis) Stop() error {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
 	return nil
 }
 
@@ -75,6 +118,28 @@ func (s *Redis) ExecuteRead(cmd string, key string, args []string) (store.Value,
 		rval, err := s.get(key)
 		if err != nil { return nil, err }
 		return rval, nil
+	case HGET:
+		if err := s.validateHGet(key, args); err != nil { return nil, err }
+		return s.hget(key, args[0])
+	case HGETALL:
+		if err := s.validateHGetAll(key, args); err != nil { return nil, err }
+		return s.hgetall(key)
+	case HLEN:
+		if err := s.validateHLen(key, args); err != nil { return nil, err }
+		rval, err := s.hlen(key)
+		if err != nil { return nil, err }
+		return rval, nil
+	case SISMEMBER:
+		if err := s.validateSIsMember(key, args); err != nil { return nil, err }
+		return s.sismember(key, args[0])
+	case SMEMBERS:
+		if err := s.validateSMembers(key, args); err != nil { return nil, err }
+		return s.smembers(key)
+	case SCARD:
+		if err := s.validateSCard(key, args); err != nil { return nil, err }
+		rval, err := s.scard(key)
+		if err != nil { return nil, err }
+		return rval, nil
 	default:
 		return nil, fmt.Errorf("Unrecognized read command: %v", cmd)
 	}
@@ -82,17 +147,60 @@ func (s *Redis) ExecuteRead(cmd string, key string, args []string) (store.Value,
 	return nil, nil
 }
 
+// executes a read or write instruction. Writes with equal timestamps
+// are ordered by the id of the node that issued them
+func (s *Redis) ExecuteInstruction(instruction store.Instruction) (store.Value, error) {
+	if isInternalCommand(instruction.Cmd) && !instruction.Internal {
+		return nil, fmt.Errorf("%v is an internal command, and can't be executed by clients", instruction.Cmd)
+	}
+	if s.IsReadCommand(instruction.Cmd) {
+		return s.ExecuteRead(instruction.Cmd, instruction.Key, instruction.Args)
+	}
+	return s.executeWrite(instruction.Cmd, instruction.Key, instruction.Args, instruction.Timestamp, instruction.Origin)
+}
+
+// executes a write that doesn't record the node that issued it
 func (s *Redis) ExecuteWrite(cmd string, key string, args []string, timestamp time.Time) (store.Value, error) {
+	if isInternalCommand(cmd) {
+		return nil, fmt.Errorf("%v is an internal command, and can't be executed by clients", cmd)
+	}
+	return s.executeWrite(cmd, key, args, timestamp, types.UUID{})
+}
+
+func (s *Redis) executeWrite(cmd string, key string, args []string, timestamp time.Time, origin types.UUID) (store.Value, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
 	switch cmd {
 	case SET:
 		if err := s.validateSet(key, args, timestamp); err != nil { return nil, err }
-		return s.set(key, args[0], timestamp), nil
+		return s.set(key, args[0], timestamp, origin), nil
 	case DEL:
 		if err := s.validateDel(key, args, timestamp); err != nil { return nil, err }
-		return s.del(key, timestamp)
+		return s.del(key, timestamp, origin)
+	case HSET:
+		if err := s.validateHSet(key, args, timestamp); err != nil { return nil, err }
+		rval, err := s.hset(key, args, timestamp, origin)
+		if err != nil { return nil, err }
+		return rval, nil
+	case HDEL:
+		if err := s.validateHDel(key, args, timestamp); err != nil { return nil, err }
+		rval, err := s.hdel(key, args, timestamp, origin)
+		if err != nil { return nil, err }
+		return rval, nil
+	case SADD:
+		if err := s.validateSetMembers(cmd, key, args, timestamp); err != nil { return nil, err }
+		rval, err := s.sadd(key, args, timestamp, origin)
+		if err != nil { return nil, err }
+		return rval, nil
+	case SREM:
+		if err := s.validateSetMembers(cmd, key, args, timestamp); err != nil { return nil, err }
+		rval, err := s.srem(key, args, timestamp, origin)
+		if err != nil { return nil, err }
+		return rval, nil
+	case SREMTAG:
+		if err := s.validateSRemTag(key, args, timestamp); err != nil { return nil, err }
+		return nil, s.sremtag(key, args[0], timestamp, origin)
 	default:
 		return nil, fmt.Errorf("Unrecognized write command: %v", cmd)
 	}
@@ -118,23 +226,26 @@ func (s *Redis) Reconcile(key string, values map[string] store.Value) (store.Val
 		for _, v := range values { val = v }
 		return val, nil, nil
 	default:
-		highValue := getHighValue(values)
-
-		switch highValue.GetValueType() {
-		case STRING_VALUE:
-			return reconcileString(key, highValue.(*String), values)
-		case TOMBSTONE_VALUE:
-			return reconcileTombstone(key, highValue.(*Tombstone), values)
-		default:
-			return nil, make(map[string][]*store.Instruction), fmt.Errorf("Unknown value type: %T", highValue)
+		if s.policies != nil {
+			if policy := s.policies.Get(key); policy != nil {
+				return reconcileWithPolicy(policy, key, values)
+			}
 		}
+		return reconcileLastWriteWins(key, values)
 	}
 	return nil, make(map[string][]*store.Instruction), nil
 }
 
+// registers a policy used to reconcile keys beginning with the given
+// prefix. Policies are given the store's own value types. Keys without
+// a registered policy are reconciled with LastWriteWins
+func (s *Redis) RegisterReconcilePolicy(prefix string, policy store.ReconcilePolicy) {
+	s.policies.Register(prefix, policy)
+}
+
 func (s *Redis) IsReadCommand(cmd string) bool {
 	switch strings.ToUpper(cmd) {
-	case GET:
+	case GET, HGET, HGETALL, HLEN, SISMEMBER, SMEMBERS, SCARD:
 		return true
 	}
 	return false
@@ -142,7 +253,7 @@ func (s *Redis) IsReadCommand(cmd string) bool {
 
 func (s *Redis) IsWriteCommand(cmd string) bool {
 	switch strings.ToUpper(cmd) {
-	case SET, DEL:
+	case SET, DEL, HSET, HDEL, SADD, SREM, SREMTAG:
 		return true
 	}
 	return false
@@ -150,17 +261,53 @@ func (s *Redis) IsWriteCommand(cmd string) bool {
 
 func (s *Redis) ReturnsValue(cmd string) bool {
 	switch strings.ToUpper(cmd) {
-	case GET:
+	case GET, HGET, HGETALL, HLEN, SISMEMBER, SMEMBERS, SCARD:
+		return true
+	}
+	return false
+}
+
+// returns true if the command is only used internally,
+// and can't be executed by clients
+func isInternalCommand(cmd string) bool {
+	switch strings.ToUpper(cmd) {
+	case SREMTAG:
 		return true
 	}
 	return false
 }
 
+// hash instructions operating on a single field interfere
+// with the hash's key, and the field, so instructions operating
+// on different fields of the same hash don't interfere with
+// each other, but do interfere with instructions operating
+// on the whole hash. Set instructions operating on a single
+// member are treated the same way
+func (s *Redis) InterferingKeys(instruction store.Instruction) []string {
+	switch strings.ToUpper(instruction.Cmd) {
+	case HGET, SISMEMBER, SREMTAG:
+		if len(instruction.Args) > 0 {
+			return []string{instruction.Key, instruction.Args[0]}
+		}
+	case HSET:
+		if len(instruction.Args) == 2 {
+			return []string{instruction.Key, instruction.Args[0]}
+		}
+	case HDEL, SADD, SREM:
+		if len(instruction.Args) == 1 {
+			return []string{instruction.Key, instruction.Args[0]}
+		}
+	}
+	return []string{instruction.Key}
+}
+
 // ----------- data import / export -----------
 
 
 // blindly gets the contents of the given key
 func (s *Redis) GetRawKey(key string) (store.Value, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	val, ok := s.data[key]
 	if !ok {
 		return nil, fmt.Errorf("key [%v] does not exist", key)
@@ -170,13 +317,25 @@ func (s *Redis) GetRawKey(key string) (store.Value, error) {
 
 // blindly sets the contents of the given key
 func (s *Redis) SetRawKey(key string, val store.Value) error {
-	s.data[key] = val
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.setValue(key, val)
 	return nil
 }
 
+// stores the value at the given key, indexing the key if it's new
+func (s *Redis) setValue(key string, val store.Value) {
+	if _, exists := s.data[key]; !exists {
+		s.index.Add(key)
+	}
+	s.data[key] = val
+}
+
 // returns all of the keys held by the store, including keys containing
 // tombstones
 func (s *Redis) GetKeys() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	var i int
 	keys := make([]string, len(s.data))
 	for key, _ := range s.data {
@@ -186,6 +345,45 @@ func (s *Redis) GetKeys() []string {
 	return keys
 }
 
+// iterates over the keys a page at a time, seeking to the iterator's position
+// in the key index. Each page is read under the read lock, and holds copies
+// of the values, so concurrent writes are seen if they're ahead of the iterator
+//
+// the index is kept for the type of partitioner the keys were last iterated
+// with, and rebuilt if they're iterated with another
+func (s *Redis) IterateKeys(p partitioner.Partitioner, start partitioner.Token) store.Iterator {
+	s.lock.Lock()
+	if !s.index.IndexedBy(p) {
+		s.index = store.IndexKeys(p, s.data)
+	}
+	s.lock.Unlock()
+
+	return store.NewIterator(start, store.ITERATOR_PAGE_SIZE, func(read store.PageRead) {
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+		read(s.index, s.copyValue)
+	})
+}
+
+// returns a copy of the value stored at the given key, so it can be
+// used after the lock is released. Values are copied by serializing
+// them, which can only fail for values the store didn't create
+func (s *Redis) copyValue(key string) (store.Value, bool) {
+	val, exists := s.data[key]
+	if !exists {
+		return nil, false
+	}
+	b, err := s.SerializeValue(val)
+	if err != nil {
+		panic(fmt.Sprintf("error copying value at key [%v]: %v", key, err))
+	}
+	val, _, err = s.DeserializeValue(b)
+	if err != nil {
+		panic(fmt.Sprintf("error copying value at key [%v]: %v", key, err))
+	}
+	return val, true
+}
+
 func (s *Redis) KeyExists(key string) bool {
 	_, ok := s.data[key]
 	return ok
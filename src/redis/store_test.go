@@ -1,9 +1,12 @@
 package redis
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 	"time"
 
+	"partitioner"
 	"testing_helpers"
 	"store"
 )
@@ -17,6 +20,16 @@ var isWrite = []struct {
 	{"GET", false},
 	{"SET", true},
 	{"DEL", true},
+	{"HGET", false},
+	{"HGETALL", false},
+	{"HLEN", false},
+	{"HSET", true},
+	{"HDEL", true},
+	{"SISMEMBER", false},
+	{"SMEMBERS", false},
+	{"SCARD", false},
+	{"SADD", true},
+	{"SREM", true},
 }
 
 func TestIsWriteCmd(t *testing.T) {
@@ -47,7 +60,26 @@ func TestIsReadCmd(t *testing.T) {
 
 func TestInterfaceIsImplemented(t *testing.T) {
 	t.Skipf("Not working yet!")
-	func(s store.Store) { _ = s }(&Redis{})
+	if _, ok := interface{}(&Redis{}).(store.Store); !ok {
+		t.Errorf("Redis doesn't implement store.Store")
+	}
+}
+
+// tests that hash field and set member instructions
+// interfere with the key, and the field or member
+func TestInterferingKeys(t *testing.T) {
+	r := &Redis{}
+	testing_helpers.AssertStringArrayEqual(t, "SET", []string{"a"}, r.InterferingKeys(store.Instruction{Cmd:"SET", Key:"a", Args:[]string{"b"}}))
+	testing_helpers.AssertStringArrayEqual(t, "HSET", []string{"a", "f"}, r.InterferingKeys(store.Instruction{Cmd:"HSET", Key:"a", Args:[]string{"f", "b"}}))
+	testing_helpers.AssertStringArrayEqual(t, "HGET", []string{"a", "f"}, r.InterferingKeys(store.Instruction{Cmd:"HGET", Key:"a", Args:[]string{"f"}}))
+	testing_helpers.AssertStringArrayEqual(t, "HDEL", []string{"a", "f"}, r.InterferingKeys(store.Instruction{Cmd:"HDEL", Key:"a", Args:[]string{"f"}}))
+	testing_helpers.AssertStringArrayEqual(t, "HSET multiple", []string{"a"}, r.InterferingKeys(store.Instruction{Cmd:"HSET", Key:"a", Args:[]string{"f", "b", "g", "c"}}))
+	testing_helpers.AssertStringArrayEqual(t, "HDEL multiple", []string{"a"}, r.InterferingKeys(store.Instruction{Cmd:"HDEL", Key:"a", Args:[]string{"f", "g"}}))
+	testing_helpers.AssertStringArrayEqual(t, "HGETALL", []string{"a"}, r.InterferingKeys(store.Instruction{Cmd:"HGETALL", Key:"a"}))
+	testing_helpers.AssertStringArrayEqual(t, "SADD", []string{"a", "m"}, r.InterferingKeys(store.Instruction{Cmd:"SADD", Key:"a", Args:[]string{"m"}}))
+	testing_helpers.AssertStringArrayEqual(t, "SADD multi", []string{"a"}, r.InterferingKeys(store.Instruction{Cmd:"SADD", Key:"a", Args:[]string{"m", "n"}}))
+	testing_helpers.AssertStringArrayEqual(t, "SISMEMBER", []string{"a", "m"}, r.InterferingKeys(store.Instruction{Cmd:"SISMEMBER", Key:"a", Args:[]string{"m"}}))
+	testing_helpers.AssertStringArrayEqual(t, "SMEMBERS", []string{"a"}, r.InterferingKeys(store.Instruction{Cmd:"SMEMBERS", Key:"a"}))
 }
 
 // ----------- data import / export -----------
@@ -61,11 +93,11 @@ func TestGetRawKeySuccess(t *testing.T) {
 
 	rval, err := r.GetRawKey("a")
 	if err != nil {
-		t.Fatal("unexpectedly got error: %v", err)
+		t.Fatalf("unexpectedly got error: %v", err)
 	}
 	val, ok := rval.(*String)
 	if !ok {
-		t.Fatal("expected value of type stringValue, got %T", rval)
+		t.Fatalf("expected value of type stringValue, got %T", rval)
 	}
 	testing_helpers.AssertEqual(t, "value", expected, val)
 }
@@ -81,7 +113,7 @@ func TestSetRawKey(t *testing.T) {
 	}
 	val, ok := rval.(*Boolean)
 	if !ok {
-		t.Fatal("expected value of type boolValues, got %T", rval)
+		t.Fatalf("expected value of type boolValues, got %T", rval)
 	}
 
 	testing_helpers.AssertEqual(t, "val", expected, val)
@@ -111,6 +143,43 @@ func TestGetKeys(t *testing.T) {
 	}
 }
 
+// tests that keys, including tombstones, are iterated in token order
+func TestIterateKeys(t *testing.T) {
+	r := setupRedis()
+	ts := time.Unix(100000, 0)
+	p := partitioner.NewMD5Partitioner()
+	for i:=0; i<20; i++ {
+		r.SetRawKey(fmt.Sprint(i), NewBoolean(true, ts))
+	}
+	r.SetRawKey("20", NewTombstone(ts))
+
+	num := 0
+	var last partitioner.Token
+	iter := r.IterateKeys(p, nil)
+	for iter.Next() {
+		num++
+		testing_helpers.AssertSliceEqual(t, "token", p.GetToken(iter.Key()), iter.Token())
+		if bytes.Compare(last, iter.Token()) >= 0 {
+			t.Errorf("Key '%v' is out of token order", iter.Key())
+		}
+		last = iter.Token()
+		assertEqualValue(t, "value", r.data[iter.Key()], iter.Value())
+	}
+	testing_helpers.AssertEqual(t, "num keys", 21, num)
+}
+
+// tests that the index is kept when the keys are iterated
+// with another partitioner of the same type
+func TestIterateKeysIndexReused(t *testing.T) {
+	r := setupRedis()
+	r.SetRawKey("a", NewBoolean(true, time.Unix(100000, 0)))
+	r.IterateKeys(partitioner.NewMD5Partitioner(), nil).Next()
+	index := r.index
+
+	r.IterateKeys(partitioner.NewMD5Partitioner(), nil).Next()
+	testing_helpers.AssertEqual(t, "index", true, index == r.index)
+}
+
 func TestKeyExists(t *testing.T) {
 	r := setupRedis()
 	val := NewBoolean(true, time.Now())
@@ -0,0 +1,247 @@
+package redis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"serializer"
+	"store"
+)
+
+// a map of fields to string values
+//
+// each field carries it's own timestamp, so concurrent writes to
+// different fields can be merged during reconciliation. Deleted
+// fields are kept as tombstones, so deletions aren't undone by
+// replicas that haven't seen them yet
+type Hash struct {
+	// field -> *String or *Tombstone
+	fields map[string]store.Value
+}
+
+func NewHash() *Hash {
+	return &Hash{fields: make(map[string]store.Value)}
+}
+
+// returns the value of the given field, and false if
+// the field doesn't exist, or has been deleted
+func (v *Hash) GetField(field string) (string, bool) {
+	if str, ok := v.fields[field].(*String); ok {
+		return str.value, true
+	}
+	return "", false
+}
+
+// returns the values of all of the fields that haven't been deleted
+func (v *Hash) GetFields() map[string]string {
+	fields := make(map[string]string, len(v.fields))
+	for field, val := range v.fields {
+		if str, ok := val.(*String); ok {
+			fields[field] = str.value
+		}
+	}
+	return fields
+}
+
+// returns the number of fields that haven't been deleted
+func (v *Hash) Len() int {
+	num := 0
+	for _, val := range v.fields {
+		if val.GetValueType() == STRING_VALUE {
+			num++
+		}
+	}
+	return num
+}
+
+// returns the timestamp of the most recently written field
+func (v *Hash) GetTimestamp() time.Time {
+	var ts time.Time
+	for _, val := range v.fields {
+		if fts := val.GetTimestamp(); fts.After(ts) {
+			ts = fts
+		}
+	}
+	return ts
+}
+
+func (v *Hash) GetValueType() store.ValueType {
+	return HASH_VALUE
+}
+
+func (v *Hash) Equal(o store.Value) bool {
+	other, ok := o.(*Hash)
+	if !ok { return false }
+	if len(v.fields) != len(other.fields) { return false }
+	for field, val := range v.fields {
+		otherVal, exists := other.fields[field]
+		if !exists || !val.Equal(otherVal) { return false }
+	}
+	return true
+}
+
+// removes the tombstones of fields deleted before the
+// given horizon, and returns the number removed
+func (v *Hash) PurgeTombstones(horizon time.Time) int {
+	num := 0
+	for field, val := range v.fields {
+		if val.GetValueType() == TOMBSTONE_VALUE && val.GetTimestamp().Before(horizon) {
+			delete(v.fields, field)
+			num++
+		}
+	}
+	return num
+}
+
+// returns the hash's field names in sorted order
+func (v *Hash) sortedFields() []string {
+	fields := make([]string, 0, len(v.fields))
+	for field := range v.fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func (v *Hash) Serialize(buf *bufio.Writer) error {
+	numFields := uint32(len(v.fields))
+	if err := binary.Write(buf, binary.LittleEndian, &numFields); err != nil {
+		return err
+	}
+	for _, field := range v.sortedFields() {
+		val := v.fields[field]
+		if err := serializer.WriteFieldString(buf, field); err != nil {
+			return err
+		}
+		if err := serializer.WriteFieldString(buf, string(val.GetValueType())); err != nil {
+			return err
+		}
+		if err := val.Serialize(buf); err != nil {
+			return err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (v *Hash) Deserialize(buf *bufio.Reader) error {
+	var numFields uint32
+	if err := binary.Read(buf, binary.LittleEndian, &numFields); err != nil {
+		return err
+	}
+	v.fields = make(map[string]store.Value, numFields)
+	for i:=0; i<int(numFields); i++ {
+		field, err := serializer.ReadFieldString(buf)
+		if err != nil {
+			return err
+		}
+		vtype, err := serializer.ReadFieldString(buf)
+		if err != nil {
+			return err
+		}
+		switch store.ValueType(vtype) {
+		case STRING_VALUE, TOMBSTONE_VALUE:
+		default:
+			return fmt.Errorf("Unexpected hash field value type: %v", vtype)
+		}
+		val, err := newValue(store.ValueType(vtype))
+		if err != nil {
+			return err
+		}
+		if err := val.Deserialize(buf); err != nil {
+			return err
+		}
+		v.fields[field] = val
+	}
+	return nil
+}
+
+// returns the instruction that sets a field to the given value
+func hashFieldInstruction(key string, field string, val store.Value) *store.Instruction {
+	var instruction store.Instruction
+	if str, ok := val.(*String); ok {
+		instruction = store.NewInstruction(HSET, key, []string{field, str.value}, str.time)
+	} else {
+		instruction = store.NewInstruction(HDEL, key, []string{field}, val.GetTimestamp())
+	}
+	instruction.Origin = store.GetOrigin(val)
+	return &instruction
+}
+
+// merges the fields of a map of node ids -> hash values, keeping
+// the value with the highest timestamp for each field
+//
+// if any of the values are of another type, the hash was deleted or
+// overwritten before any of the newer fields were written, so fields
+// older than the newest non hash value are discarded, and replicas
+// holding them are reset before the merged fields are written to them
+func reconcileHash(key string, values map[string]store.Value) (*Hash, map[string][]*store.Instruction, error) {
+	// find the time the key was last reset
+	var resetTime time.Time
+	for _, val := range values {
+		if _, isHash := val.(*Hash); !isHash {
+			if ts := val.GetTimestamp(); ts.After(resetTime) {
+				resetTime = ts
+			}
+		}
+	}
+
+	merged := NewHash()
+	for _, val := range values {
+		hash, isHash := val.(*Hash)
+		if !isHash {
+			continue
+		}
+		for field, fieldVal := range hash.fields {
+			if fieldVal.GetTimestamp().Before(resetTime) {
+				continue
+			}
+			if existing, exists := merged.fields[field]; !exists || store.ValueAfter(fieldVal, existing) {
+				merged.fields[field] = fieldVal
+			}
+		}
+	}
+
+	// create instructions for the unequal nodes
+	instructions := make(map[string][]*store.Instruction)
+	fields := merged.sortedFields()
+	for nodeid, val := range values {
+		if merged.Equal(val) {
+			continue
+		}
+
+		hash, isHash := val.(*Hash)
+		needsReset := !isHash
+		if isHash {
+			for _, fieldVal := range hash.fields {
+				if fieldVal.GetTimestamp().Before(resetTime) {
+					needsReset = true
+					break
+				}
+			}
+		}
+
+		nodeInstructions := make([]*store.Instruction, 0, len(fields) + 1)
+		if needsReset {
+			reset := store.NewInstruction(DEL, key, []string{}, resetTime)
+			nodeInstructions = append(nodeInstructions, &reset)
+		}
+		for _, field := range fields {
+			fieldVal := merged.fields[field]
+			if !needsReset {
+				if existing, exists := hash.fields[field]; exists && existing.Equal(fieldVal) {
+					continue
+				}
+			}
+			nodeInstructions = append(nodeInstructions, hashFieldInstruction(key, field, fieldVal))
+		}
+		instructions[nodeid] = nodeInstructions
+	}
+
+	return merged, instructions, nil
+}
@@ -0,0 +1,211 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"testing_helpers"
+	"types"
+	"store"
+)
+
+// returns a hash with the given fields, timestamped with ts
+func newTestHash(ts time.Time, fields map[string]string) *Hash {
+	hash := NewHash()
+	for field, val := range fields {
+		hash.fields[field] = NewString(val, ts)
+	}
+	return hash
+}
+
+func TestHashValue(t *testing.T) {
+	s := setupRedis()
+	ts0 := time.Unix(time.Now().Unix(), 0)
+	src := newTestHash(ts0, map[string]string{"a": "b", "c": "d"})
+	src.fields["e"] = NewTombstone(ts0)
+
+	b, err := s.SerializeValue(src)
+	if err != nil {
+		t.Fatalf("Unexpected serialization error: %v", err)
+	}
+
+	val, vtype, err := s.DeserializeValue(b)
+	if err != nil {
+		t.Fatalf("Unexpected deserialization error: %v", err)
+	}
+	if vtype != HASH_VALUE {
+		t.Fatalf("Unexpected value type enum: %v", vtype)
+	}
+	dst, ok := val.(*Hash)
+	if !ok {
+		t.Fatalf("Unexpected value type: %T", val)
+	}
+
+	testing_helpers.AssertEqual(t, "num fields", 3, len(dst.fields))
+	testing_helpers.AssertEqual(t, "equal", true, src.Equal(dst))
+}
+
+// tests the hash value's equality method
+func TestHashEquality(t *testing.T) {
+	t0 := time.Now()
+	v0 := newTestHash(t0, map[string]string{"a": "b"})
+
+	testing_helpers.AssertEqual(t, "equal value", true, v0.Equal(newTestHash(t0, map[string]string{"a": "b"})))
+	testing_helpers.AssertEqual(t, "unequal timestamp", false, v0.Equal(newTestHash(t0.Add(4), map[string]string{"a": "b"})))
+	testing_helpers.AssertEqual(t, "unequal value", false, v0.Equal(newTestHash(t0, map[string]string{"a": "c"})))
+	testing_helpers.AssertEqual(t, "extra field", false, v0.Equal(newTestHash(t0, map[string]string{"a": "b", "c": "d"})))
+	testing_helpers.AssertEqual(t, "unequal type", false, v0.Equal(NewString("asdf", t0)))
+}
+
+// tests that fields written on different
+// replicas are merged together
+func TestHashFieldMergeReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	v0 := newTestHash(ts0, map[string]string{"a": "x"})
+	v0.fields["b"] = NewString("y", ts1)
+	v1 := newTestHash(ts1, map[string]string{"a": "z", "b": "y"})
+	v1.fields["c"] = NewString("w", ts0)
+	vmap := map[string]store.Value{"0": v0, "1": v1}
+
+	ractual, adjustments, err := setupRedis().Reconcile("k", vmap)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	expected := NewHash()
+	expected.fields["a"] = v0.fields["a"]
+	expected.fields["b"] = v0.fields["b"]
+	expected.fields["c"] = v1.fields["c"]
+	assertEqualValue(t, "reconciled value", expected, ractual)
+
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments["0"]))
+	expected_instr := store.Instruction{Cmd:"HSET", Key:"k", Args:[]string{"c", "w"}, Timestamp:ts0}
+	if !expected_instr.Equal(*adjustments["0"][0]) {
+		t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, *adjustments["0"][0])
+	}
+
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments["1"]))
+	expected_instr = store.Instruction{Cmd:"HSET", Key:"k", Args:[]string{"a", "x"}, Timestamp:ts0}
+	if !expected_instr.Equal(*adjustments["1"][0]) {
+		t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, *adjustments["1"][0])
+	}
+}
+
+// tests that fields with equal timestamps are
+// merged by the origin of their writes
+func TestHashFieldEqualTimestampReconciliation(t *testing.T) {
+	ts := time.Now()
+	v0 := newTestHash(ts, map[string]string{"a": "x"})
+	v1 := newTestHash(ts, map[string]string{"a": "y"})
+	v1.fields["a"].(*String).origin = types.NewUUID1()
+
+	ractual, adjustments, err := setupRedis().Reconcile("k", map[string]store.Value{"0": v0, "1": v1})
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+	assertEqualValue(t, "reconciled value", v1, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments["0"]))
+	testing_helpers.AssertEqual(t, "origin", v1.fields["a"].(*String).origin, adjustments["0"][0].Origin)
+}
+
+// tests that deleted fields are propagated to
+// replicas that haven't seen the deletion
+func TestHashFieldDeleteReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	v0 := NewHash()
+	v0.fields["a"] = NewTombstone(ts0)
+	v1 := newTestHash(ts1, map[string]string{"a": "x"})
+	vmap := map[string]store.Value{"0": v0, "1": v1}
+
+	ractual, adjustments, err := setupRedis().Reconcile("k", vmap)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	assertEqualValue(t, "reconciled value", v0, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 0, len(adjustments["0"]))
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments["1"]))
+	expected_instr := store.Instruction{Cmd:"HDEL", Key:"k", Args:[]string{"a"}, Timestamp:ts0}
+	if !expected_instr.Equal(*adjustments["1"][0]) {
+		t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, *adjustments["1"][0])
+	}
+}
+
+// tests that fields written before the key was deleted
+// are discarded, and that the replicas holding them,
+// or the tombstone, are reset
+func TestHashResetReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	ts2 := ts0.Add(time.Duration(-6000))
+	v0 := newTestHash(ts0, map[string]string{"a": "x"})
+	v1 := NewTombstone(ts1)
+	v2 := newTestHash(ts2, map[string]string{"b": "y"})
+	v2.fields["a"] = NewString("x", ts0)
+	vmap := map[string]store.Value{"0": v0, "1": v1, "2": v2}
+
+	ractual, adjustments, err := setupRedis().Reconcile("k", vmap)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	assertEqualValue(t, "reconciled value", v0, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 0, len(adjustments["0"]))
+
+	expected := []store.Instruction{
+		store.Instruction{Cmd:"DEL", Key:"k", Args:[]string{}, Timestamp:ts1},
+		store.Instruction{Cmd:"HSET", Key:"k", Args:[]string{"a", "x"}, Timestamp:ts0},
+	}
+	for _, nodeid := range []string{"1", "2"} {
+		adjustment := adjustments[nodeid]
+		testing_helpers.AssertEqual(t, "num instructions", len(expected), len(adjustment))
+		for i, instruction := range adjustment {
+			if !expected[i].Equal(*instruction) {
+				t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected[i], *instruction)
+			}
+		}
+	}
+
+	// apply the instructions to a store holding the
+	// old value, and check that it ends up equal
+	r := setupRedis()
+	r.data["k"] = v2
+	for _, instruction := range adjustments["2"] {
+		if _, err := r.ExecuteInstruction(*instruction); err != nil {
+			t.Fatalf("unexpected error applying instruction: %v", err)
+		}
+	}
+	assertEqualValue(t, "corrected value", v0, r.data["k"])
+}
+
+// tests that a delete newer than all of the
+// fields wins
+func TestHashDeletedReconciliation(t *testing.T) {
+	ts0 := time.Now()
+	ts1 := ts0.Add(time.Duration(-3000))
+	expected := NewTombstone(ts0)
+	vmap := map[string]store.Value{"0": expected, "1": newTestHash(ts1, map[string]string{"a": "x"})}
+
+	ractual, adjustments, err := setupRedis().Reconcile("k", vmap)
+	if err != nil {
+		t.Fatalf("unexpected reconciliation error: %v", err)
+	}
+
+	assertEqualValue(t, "reconciled value", expected, ractual)
+	testing_helpers.AssertEqual(t, "num instructions", 1, len(adjustments["1"]))
+	testing_helpers.AssertEqual(t, "instruction", "DEL", adjustments["1"][0].Cmd)
+}
+
+// tests that the tombstones of deleted fields are purged
+func TestHashPurgeTombstones(t *testing.T) {
+	r := setupRedis()
+	ts0 := time.Now()
+	r.ExecuteInstruction(store.NewInstruction("HSET", "a", []string{"f", "b", "g", "c"}, ts0))
+	r.ExecuteInstruction(store.NewInstruction("HDEL", "a", []string{"f"}, ts0))
+
+	testing_helpers.AssertEqual(t, "num purged", 1, r.purgeTombstones(ts0.Add(r.gc.Grace + time.Second)))
+	hash := r.data["a"].(*Hash)
+	testing_helpers.AssertEqual(t, "num fields", 1, len(hash.fields))
+}
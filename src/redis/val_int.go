@@ -0,0 +1,89 @@
+package redis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"time"
+
+	"serializer"
+	"store"
+)
+
+// an integer value, returned by commands
+// that report counts, like HLEN
+type Integer struct {
+	value int64
+	time time.Time
+}
+
+func NewInteger(val int64, timestamp time.Time) *Integer {
+	v := &Integer{
+		value:val,
+		time:timestamp,
+	}
+	return v
+}
+
+func (v *Integer) GetValue() int64 {
+	return v.value
+}
+
+func (v *Integer) GetTimestamp() time.Time {
+	return v.time
+}
+
+func (v *Integer) GetValueType() store.ValueType {
+	return INTEGER_VALUE
+}
+
+func (v *Integer) Equal(o store.Value) bool {
+	if !baseValueEqual(v, o) { return false }
+	other := o.(*Integer)
+	if v.value != other.value { return false }
+	return true
+}
+
+func (v *Integer) Serialize(buf *bufio.Writer) error {
+	if err := binary.Write(buf, binary.LittleEndian, &v.value); err != nil {
+		return err
+	}
+	if err := serializer.WriteTime(buf, v.time); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (v *Integer) Deserialize(buf *bufio.Reader) error {
+	if err := binary.Read(buf, binary.LittleEndian, &v.value); err != nil {
+		return err
+	}
+	if t, err := serializer.ReadTime(buf); err != nil {
+		return err
+	} else {
+		v.time = t
+	}
+	return nil
+}
+
+// reconciles a map of node ids -> integer values. Integers are counts
+// derived from the value stored at a key, like the length of a hash, so
+// they can't be repaired directly. The most recently timestamped count is
+// returned, and the underlying values are repaired by reads of the key
+// itself. Counts of missing keys aren't timestamped, and lose to any other
+func reconcileInteger(key string, values map[string]store.Value) (*Integer, map[string][]*store.Instruction, error) {
+	_ = key
+	var highValue *Integer
+	for _, val := range values {
+		i, ok := val.(*Integer)
+		if !ok {
+			continue
+		}
+		if highValue == nil || i.time.After(highValue.time) || (i.time.Equal(highValue.time) && i.value > highValue.value) {
+			highValue = i
+		}
+	}
+	return highValue, make(map[string][]*store.Instruction), nil
+}
@@ -6,6 +6,7 @@ import (
 
 	"store"
 	"serializer"
+	"types"
 )
 
 // a single value used for
@@ -13,6 +14,9 @@ import (
 type String struct {
 	value string
 	time time.Time
+
+	// the node that issued the timestamp
+	origin types.UUID
 }
 
 // single value constructor
@@ -32,6 +36,10 @@ func (v *String) GetTimestamp() time.Time {
 	return v.time
 }
 
+func (v *String) GetOrigin() types.UUID {
+	return v.origin
+}
+
 func (v *String) GetValueType() store.ValueType {
 	return STRING_VALUE
 }
@@ -50,6 +58,9 @@ func (v *String) Serialize(buf *bufio.Writer) error {
 	if err := serializer.WriteTime(buf, v.time); err != nil {
 		return err
 	}
+	if err := writeOrigin(buf, v.origin); err != nil {
+		return err
+	}
 	if err := buf.Flush(); err != nil {
 		return err
 	}
@@ -68,6 +79,11 @@ func (v *String) Deserialize(buf *bufio.Reader) error {
 	} else {
 		v.time = t
 	}
+	if o, err := readOrigin(buf); err != nil {
+		return err
+	} else {
+		v.origin = o
+	}
 	return nil
 }
 
@@ -81,6 +97,7 @@ func reconcileString(key string, highValue *String, values map[string]store.Valu
 				Key:key,
 				Args:[]string{highValue.value},
 				Timestamp:highValue.time,
+				Origin:highValue.origin,
 			}}
 		}
 	}
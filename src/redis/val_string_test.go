@@ -6,12 +6,14 @@ import (
 
 	"store"
 	"testing_helpers"
+	"types"
 )
 
 // tests the string value
 func TestStringValue(t *testing.T) {
 	s := setupRedis()
 	src := NewString("blake", time.Now())
+	src.origin = types.NewUUID1()
 
 	b, err := s.SerializeValue(src)
 	if err != nil {
@@ -32,6 +34,7 @@ func TestStringValue(t *testing.T) {
 
 	testing_helpers.AssertEqual(t, "value", src.value, dst.value)
 	testing_helpers.AssertEqual(t, "time", src.time, dst.time)
+	testing_helpers.AssertEqual(t, "origin", src.origin, dst.origin)
 }
 
 // tests that the tombstone struct satisfies the
@@ -72,7 +75,7 @@ func TestStringMismatchReconciliation(t *testing.T) {
 
 	instruction := instructions[0]
 	expected_instr := store.Instruction{Cmd:"SET", Key:"k", Args:[]string{"a"}, Timestamp:ts0}
-	if !expected_instr.Equal(instruction) {
+	if !expected_instr.Equal(*instruction) {
 		t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, instruction)
 	}
 }
@@ -108,7 +111,7 @@ func TestStringMultiTypeReconciliation(t *testing.T) {
 
 	instruction := instructions[0]
 	expected_instr := store.Instruction{Cmd:"SET", Key:"k", Args:[]string{"a"}, Timestamp:ts0}
-	if !expected_instr.Equal(instruction) {
+	if !expected_instr.Equal(*instruction) {
 		t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, instruction)
 	}
 }
@@ -6,11 +6,15 @@ import (
 
 	"serializer"
 	"store"
+	"types"
 )
 
 // a value indicating a deletion
 type Tombstone struct {
 	time time.Time
+
+	// the node that issued the timestamp
+	origin types.UUID
 }
 
 // single value constructor
@@ -25,6 +29,10 @@ func (v *Tombstone) GetTimestamp() time.Time {
 	return v.time
 }
 
+func (v *Tombstone) GetOrigin() types.UUID {
+	return v.origin
+}
+
 func (v *Tombstone) GetValueType() store.ValueType {
 	return TOMBSTONE_VALUE
 }
@@ -37,6 +45,9 @@ func (v *Tombstone) Serialize(buf *bufio.Writer) error {
 	if err := serializer.WriteTime(buf, v.time); err != nil {
 		return err
 	}
+	if err := writeOrigin(buf, v.origin); err != nil {
+		return err
+	}
 	if err := buf.Flush(); err != nil {
 		return err
 	}
@@ -49,6 +60,11 @@ func (v *Tombstone) Deserialize(buf *bufio.Reader) error {
 	} else {
 		v.time = t
 	}
+	if o, err := readOrigin(buf); err != nil {
+		return err
+	} else {
+		v.origin = o
+	}
 	return nil
 }
 
@@ -62,6 +78,7 @@ func reconcileTombstone(key string, highValue *Tombstone, values map[string]stor
 				Key:key,
 				Args:[]string{},
 				Timestamp:highValue.time,
+				Origin:highValue.origin,
 			}}
 		}
 	}
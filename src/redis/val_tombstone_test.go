@@ -6,11 +6,13 @@ import (
 
 	"testing_helpers"
 	"store"
+	"types"
 )
 
 func TestTombstoneValue(t *testing.T) {
 	s := setupRedis()
 	src := NewTombstone(time.Now())
+	src.origin = types.NewUUID1()
 
 	b, err := s.SerializeValue(src)
 	if err != nil {
@@ -30,6 +32,7 @@ func TestTombstoneValue(t *testing.T) {
 	}
 
 	testing_helpers.AssertEqual(t, "time", src.time, dst.time)
+	testing_helpers.AssertEqual(t, "origin", src.origin, dst.origin)
 }
 
 // tests that the tombstone struct satisfies the
@@ -70,7 +73,7 @@ func TestTombstoneMismatchReconciliation(t *testing.T) {
 
 	instruction := instructions[0]
 	expected_instr := store.Instruction{Cmd:"DEL", Key:"k", Args:[]string{}, Timestamp:ts0}
-	if !expected_instr.Equal(instruction) {
+	if !expected_instr.Equal(*instruction) {
 		t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, instruction)
 	}
 }
@@ -106,7 +109,7 @@ func TestTombstoneMultiTypeReconciliation(t *testing.T) {
 
 	instruction := instructions[0]
 	expected_instr := store.Instruction{Cmd:"DEL", Key:"k", Args:[]string{}, Timestamp:ts0}
-	if !expected_instr.Equal(instruction) {
+	if !expected_instr.Equal(*instruction) {
 		t.Fatalf("unexpected instruction value. Expected: [%v], got: [%v]", expected_instr, instruction)
 	}
 }
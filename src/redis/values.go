@@ -4,16 +4,19 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"time"
 
 	"serializer"
 	"store"
+	"types"
 )
 
 const (
 	STRING_VALUE = store.ValueType("STRING")
 	TOMBSTONE_VALUE	= store.ValueType("TOMBSTONE")
 	BOOL_VALUE	= store.ValueType("BOOL")
+	INTEGER_VALUE	= store.ValueType("INTEGER")
+	HASH_VALUE	= store.ValueType("HASH")
+	SET_VALUE	= store.ValueType("SET")
 )
 
 func WriteValue(buf io.Writer, v store.Value) error {
@@ -32,20 +35,31 @@ func ReadValue(buf io.Reader) (store.Value, store.ValueType, error) {
 	if err != nil { return nil, "", err }
 
 	vtype := store.ValueType(vstr)
-	var value store.Value
+	value, err := newValue(vtype)
+	if err != nil { return nil, "", err }
+
+	if err := value.Deserialize(reader); err != nil { return nil, "", err}
+	return value, vtype, nil
+}
+
+// returns an empty value of the given type, for deserialization
+func newValue(vtype store.ValueType) (store.Value, error) {
 	switch vtype {
 	case STRING_VALUE:
-		value = &String{}
+		return &String{}, nil
 	case TOMBSTONE_VALUE:
-		value = &Tombstone{}
+		return &Tombstone{}, nil
 	case BOOL_VALUE:
-		value = &Boolean{}
+		return &Boolean{}, nil
+	case INTEGER_VALUE:
+		return &Integer{}, nil
+	case HASH_VALUE:
+		return &Hash{}, nil
+	case SET_VALUE:
+		return &Set{}, nil
 	default:
-		return nil, "", fmt.Errorf("Unexpected value type: %v", vtype)
+		return nil, fmt.Errorf("Unexpected value type: %v", vtype)
 	}
-
-	if err := value.Deserialize(reader); err != nil { return nil, "", err}
-	return value, vtype, nil
 }
 
 // ----------- equality helpers -----------
@@ -58,15 +72,29 @@ func baseValueEqual(v0, v1 store.Value) bool {
 
 // ----------- reconcile helpers -----------
 
-// returns the value with the highest timestamp
+// returns the value with the highest timestamp, ordering
+// values with equal timestamps like store.ValueAfter does
 func getHighValue(values map[string]store.Value) store.Value {
-	var highTimestamp time.Time
 	var highValue store.Value
 	for _, val := range values {
-		if ts := val.GetTimestamp(); ts.After(highTimestamp) {
-			highTimestamp = ts
+		if val.GetTimestamp().IsZero() {
+			continue
+		}
+		if highValue == nil || store.ValueAfter(val, highValue) {
 			highValue = val
 		}
 	}
 	return highValue
 }
+
+// writes the id of the node that issued a value's timestamp
+func writeOrigin(buf *bufio.Writer, origin types.UUID) error {
+	return (&origin).WriteBuffer(buf)
+}
+
+// reads the id of the node that issued a value's timestamp
+func readOrigin(buf *bufio.Reader) (types.UUID, error) {
+	origin := types.UUID{}
+	err := (&origin).ReadBuffer(buf)
+	return origin, err
+}
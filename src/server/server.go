@@ -1,12 +1,182 @@
 package server
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 import (
 	"cluster"
+	"consensus"
 	"store"
+	"types"
+)
+
+const (
+	MULTI	= "MULTI"
+	EXEC	= "EXEC"
+	DISCARD	= "DISCARD"
+	WATCH	= "WATCH"
+	UNWATCH	= "UNWATCH"
 )
 
+var (
+	// the consistency level client reads and writes are executed at
+	DEFAULT_CONSISTENCY = cluster.CONSISTENCY_QUORUM
+
+	// the consistency level transactions, and their
+	// watched keys, are executed at
+	TRANSACTION_CONSISTENCY = consensus.CONSISTENCY_CONSENSUS
+
+	// the time client reads and writes wait on replicas, in milliseconds
+	QUERY_TIMEOUT = time.Duration(1000)
+)
+
+// executes the reads and writes clients make outside of transactions
+type QueryExecutor interface {
+	ExecuteRead(cmd string, key string, args []string, consistency cluster.ConsistencyLevel, timeout time.Duration, synchronous bool) (store.Value, error)
+	ExecuteWrite(cmd string, key string, args []string, timestamp time.Time, consistency cluster.ConsistencyLevel, timeout time.Duration, synchronous bool) (store.Value, error)
+}
+
+var _ = QueryExecutor(&cluster.Cluster{})
+
+// the reply to a client command
+type Reply struct {
+	// status replies, like OK and QUEUED
+	Status string
+
+	// the value returned by a read or write
+	Value store.Value
+
+	// the values and errors returned by the commands of an
+	// executed transaction, in the order they were queued
+	Values []store.Value
+	Errors []error
+
+	// true if EXEC didn't execute the transaction, because
+	// one of it's watched keys had changed
+	Aborted bool
+}
 
 type Server struct {
-	cluster *cluster.Cluster
-	store *store.Store
+	cluster QueryExecutor
+	store store.Store
+	transactions TransactionExecutor
+
+	// used to timestamp the writes of transactions
+	clock func() time.Time
+
+	// the local node's id, recorded as the origin of transaction writes
+	origin types.UUID
+}
+
+func NewServer(c *cluster.Cluster, s store.Store, transactions TransactionExecutor) *Server {
+	return newServer(c, s, transactions, c.Now, c.GetNodeId().UUID)
+}
+
+func newServer(queries QueryExecutor, s store.Store, transactions TransactionExecutor, clock func() time.Time, origin types.UUID) *Server {
+	return &Server{
+		cluster: queries,
+		store: s,
+		transactions: transactions,
+		clock: clock,
+		origin: origin,
+	}
+}
+
+// returns the transaction state of a new client connection
+func (s *Server) NewSession() *Session {
+	return NewSession(s.store, s.transactions, TRANSACTION_CONSISTENCY, s.clock, s.origin)
+}
+
+// executes a command received from the client connection the given session
+// belongs to. MULTI, EXEC, DISCARD, WATCH and UNWATCH are handled by the
+// session. Between MULTI and EXEC, other commands are queued by the session,
+// instead of being executed, and QUEUED is returned. The first arg of any
+// other command is the key it operates on
+func (s *Server) ExecuteCommand(session *Session, cmd string, args []string) (*Reply, error) {
+	switch strings.ToUpper(cmd) {
+	case MULTI:
+		if len(args) != 0 {
+			return nil, fmt.Errorf("ERR wrong number of arguments for '%v' command", cmd)
+		}
+		if err := session.Multi(); err != nil {
+			return nil, err
+		}
+		return &Reply{Status: "OK"}, nil
+
+	case EXEC:
+		if len(args) != 0 {
+			return nil, fmt.Errorf("ERR wrong number of arguments for '%v' command", cmd)
+		}
+		vals, errs, err := session.Exec()
+		if err != nil {
+			return nil, err
+		}
+		if vals == nil && errs == nil {
+			return &Reply{Aborted: true}, nil
+		}
+		return &Reply{Values: vals, Errors: errs}, nil
+
+	case DISCARD:
+		if len(args) != 0 {
+			return nil, fmt.Errorf("ERR wrong number of arguments for '%v' command", cmd)
+		}
+		if err := session.Discard(); err != nil {
+			return nil, err
+		}
+		return &Reply{Status: "OK"}, nil
+
+	case WATCH:
+		if len(args) == 0 {
+			return nil, fmt.Errorf("ERR wrong number of arguments for '%v' command", cmd)
+		}
+		if err := session.Watch(args...); err != nil {
+			return nil, err
+		}
+		return &Reply{Status: "OK"}, nil
+
+	case UNWATCH:
+		if len(args) != 0 {
+			return nil, fmt.Errorf("ERR wrong number of arguments for '%v' command", cmd)
+		}
+		// inside MULTI, UNWATCH is queued, as in redis, but EXEC
+		// unwatches every key anyway, so there's nothing to queue
+		if session.InMulti() {
+			return &Reply{Status: "QUEUED"}, nil
+		}
+		session.Unwatch()
+		return &Reply{Status: "OK"}, nil
+	}
+
+	if len(args) == 0 {
+		if session.InMulti() {
+			// an invalid command discards the transaction
+			session.dirty = true
+		}
+		return nil, fmt.Errorf("ERR wrong number of arguments for '%v' command", cmd)
+	}
+	key := args[0]
+	args = args[1:]
+
+	if session.InMulti() {
+		if err := session.Queue(cmd, key, args); err != nil {
+			return nil, err
+		}
+		return &Reply{Status: "QUEUED"}, nil
+	}
+
+	var val store.Value
+	var err error
+	if s.store.IsReadOnly(store.NewInstruction(cmd, key, args, time.Time{})) {
+		val, err = s.cluster.ExecuteRead(cmd, key, args, DEFAULT_CONSISTENCY, QUERY_TIMEOUT, false)
+	} else {
+		// the cluster timestamps the write
+		val, err = s.cluster.ExecuteWrite(cmd, key, args, time.Time{}, DEFAULT_CONSISTENCY, QUERY_TIMEOUT, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Reply{Value: val}, nil
 }
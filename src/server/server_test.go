@@ -0,0 +1,133 @@
+package server
+
+import (
+	"testing"
+	"testing_helpers"
+	"time"
+)
+
+import (
+	"cluster"
+	"kvstore"
+	"store"
+)
+
+// executes reads and writes directly against a kvstore
+type mockQueryExecutor struct {
+	store *kvstore.KVStore
+	reads []string
+	writes []string
+}
+
+func (e *mockQueryExecutor) ExecuteRead(cmd string, key string, args []string, consistency cluster.ConsistencyLevel, timeout time.Duration, synchronous bool) (store.Value, error) {
+	e.reads = append(e.reads, cmd)
+	return e.store.ExecuteInstruction(store.NewInstruction(cmd, key, args, time.Time{}))
+}
+
+func (e *mockQueryExecutor) ExecuteWrite(cmd string, key string, args []string, timestamp time.Time, consistency cluster.ConsistencyLevel, timeout time.Duration, synchronous bool) (store.Value, error) {
+	e.writes = append(e.writes, cmd)
+	return e.store.ExecuteInstruction(store.NewInstruction(cmd, key, args, time.Now()))
+}
+
+func setupServer() (*Server, *mockQueryExecutor, *mockExecutor) {
+	transactions := newMockExecutor()
+	queries := &mockQueryExecutor{store: transactions.store}
+	return newServer(queries, transactions.store, transactions, time.Now, testOrigin), queries, transactions
+}
+
+func executeCommand(t *testing.T, s *Server, session *Session, cmd string, args ...string) *Reply {
+	reply, err := s.ExecuteCommand(session, cmd, args)
+	if err != nil {
+		t.Fatalf("Unexpected error executing %v: %v", cmd, err)
+	}
+	return reply
+}
+
+// tests that commands outside of transactions
+// are executed against the cluster
+func TestServerCommands(t *testing.T) {
+	s, queries, transactions := setupServer()
+	session := s.NewSession()
+
+	executeCommand(t, s, session, "SET", "a", "b")
+	reply := executeCommand(t, s, session, "GET", "a")
+	testing_helpers.AssertEqual(t, "get val", "b", reply.Value.(*kvstore.String).GetValue())
+	testing_helpers.AssertEqual(t, "num reads", 1, len(queries.reads))
+	testing_helpers.AssertEqual(t, "num writes", 1, len(queries.writes))
+	testing_helpers.AssertEqual(t, "num transactions", 0, len(transactions.executed))
+
+	if _, err := s.ExecuteCommand(session, "GET", []string{}); err == nil {
+		t.Errorf("Expected error for command without a key")
+	}
+}
+
+// tests that commands between MULTI and EXEC are
+// queued, and executed as a single transaction
+func TestServerMultiExec(t *testing.T) {
+	s, queries, transactions := setupServer()
+	session := s.NewSession()
+
+	testing_helpers.AssertEqual(t, "multi", "OK", executeCommand(t, s, session, "multi").Status)
+	testing_helpers.AssertEqual(t, "set", "QUEUED", executeCommand(t, s, session, "SET", "a", "b").Status)
+	testing_helpers.AssertEqual(t, "get", "QUEUED", executeCommand(t, s, session, "GET", "a").Status)
+	testing_helpers.AssertEqual(t, "num reads", 0, len(queries.reads))
+	testing_helpers.AssertEqual(t, "num writes", 0, len(queries.writes))
+
+	reply := executeCommand(t, s, session, "EXEC")
+	testing_helpers.AssertEqual(t, "aborted", false, reply.Aborted)
+	testing_helpers.AssertEqual(t, "num vals", 2, len(reply.Values))
+	testing_helpers.AssertEqual(t, "get val", "b", reply.Values[1].(*kvstore.String).GetValue())
+	testing_helpers.AssertEqual(t, "num transactions", 1, len(transactions.executed))
+	testing_helpers.AssertEqual(t, "write origin", testOrigin, transactions.executed[0][0].Origin)
+	testing_helpers.AssertEqual(t, "in multi", false, session.InMulti())
+
+	if _, err := s.ExecuteCommand(session, "EXEC", []string{}); err == nil {
+		t.Errorf("Expected error for EXEC without MULTI")
+	}
+}
+
+// tests that EXEC returns an aborted reply if
+// a watched key has changed
+func TestServerWatch(t *testing.T) {
+	s, _, transactions := setupServer()
+	session := s.NewSession()
+
+	testing_helpers.AssertEqual(t, "watch", "OK", executeCommand(t, s, session, "WATCH", "a", "b").Status)
+	transactions.set("a", "c")
+	executeCommand(t, s, session, "MULTI")
+	executeCommand(t, s, session, "SET", "b", "d")
+	reply := executeCommand(t, s, session, "EXEC")
+	testing_helpers.AssertEqual(t, "aborted", true, reply.Aborted)
+	testing_helpers.AssertEqual(t, "num transactions", 0, len(transactions.executed))
+
+	// unwatched keys don't abort the transaction
+	executeCommand(t, s, session, "WATCH", "a")
+	testing_helpers.AssertEqual(t, "unwatch", "OK", executeCommand(t, s, session, "UNWATCH").Status)
+	transactions.set("a", "e")
+	executeCommand(t, s, session, "MULTI")
+	executeCommand(t, s, session, "SET", "b", "d")
+	reply = executeCommand(t, s, session, "EXEC")
+	testing_helpers.AssertEqual(t, "aborted", false, reply.Aborted)
+	testing_helpers.AssertEqual(t, "num transactions", 1, len(transactions.executed))
+}
+
+// tests that DISCARD drops the queued commands, and that
+// invalid commands inside MULTI discard the transaction
+func TestServerDiscard(t *testing.T) {
+	s, _, transactions := setupServer()
+	session := s.NewSession()
+
+	executeCommand(t, s, session, "MULTI")
+	executeCommand(t, s, session, "SET", "a", "b")
+	testing_helpers.AssertEqual(t, "discard", "OK", executeCommand(t, s, session, "DISCARD").Status)
+	testing_helpers.AssertEqual(t, "in multi", false, session.InMulti())
+
+	executeCommand(t, s, session, "MULTI")
+	executeCommand(t, s, session, "SET", "a", "b")
+	if _, err := s.ExecuteCommand(session, "GET", []string{}); err == nil {
+		t.Fatalf("Expected error for command without a key")
+	}
+	_, err := s.ExecuteCommand(session, "EXEC", []string{})
+	assertErrorPrefix(t, "EXEC", "EXECABORT", err)
+	testing_helpers.AssertEqual(t, "num transactions", 0, len(transactions.executed))
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+import (
+	"consensus"
+	"store"
+	"types"
+)
+
+// executes the transactions queued by client sessions
+type TransactionExecutor interface {
+	// returns the current state of the given key, to be
+	// checked when a transaction watching it is executed
+	WatchKey(key string, consistency consensus.ConsistencyLevel) (consensus.WatchedKey, error)
+
+	// executes the given instructions atomically. A TransactionAbortedError
+	// is returned if any of the watched keys have changed
+	ExecuteTransaction(instructions []store.Instruction, watches []consensus.WatchedKey, consistency consensus.ConsistencyLevel) ([]store.Value, []error, error)
+}
+
+var _ = TransactionExecutor(&consensus.Manager{})
+
+// the transaction state of a client connection. Commands received
+// between MULTI and EXEC are queued, and executed together in a single
+// consensus instance when EXEC is received.
+//
+// Keys watched before MULTI record the timestamp of their value. If any
+// of them have changed by the time the transaction is executed, none of
+// it's commands are executed
+type Session struct {
+	store store.Store
+	executor TransactionExecutor
+
+	// the consistency level watches and transactions are executed at
+	consistency consensus.ConsistencyLevel
+
+	// used to timestamp the writes of executed transactions
+	clock func() time.Time
+
+	// the id of the node the session's writes are made at, used
+	// to order them against writes with the same timestamp
+	origin types.UUID
+
+	// true between MULTI, and EXEC or DISCARD
+	multi bool
+
+	// commands queued since MULTI
+	queued []store.Instruction
+
+	// set if a command couldn't be queued. The
+	// transaction is discarded when EXEC is received
+	dirty bool
+
+	watches []consensus.WatchedKey
+}
+
+func NewSession(s store.Store, executor TransactionExecutor, consistency consensus.ConsistencyLevel, clock func() time.Time, origin types.UUID) *Session {
+	return &Session{
+		store: s,
+		executor: executor,
+		consistency: consistency,
+		clock: clock,
+		origin: origin,
+		queued: make([]store.Instruction, 0),
+		watches: make([]consensus.WatchedKey, 0),
+	}
+}
+
+// returns true if the session is between MULTI, and EXEC or DISCARD
+func (s *Session) InMulti() bool {
+	return s.multi
+}
+
+// marks the start of a transaction
+func (s *Session) Multi() error {
+	if s.multi {
+		return fmt.Errorf("ERR MULTI calls can not be nested")
+	}
+	s.multi = true
+	return nil
+}
+
+// queues a command to be executed when EXEC is received. Unknown
+// commands aren't queued, and cause the transaction to be discarded
+// when EXEC is received. Commands operating on several keys, like
+// MGET, can't be queued. Queued instructions are never internal, so
+// the store's internal commands are treated as unknown
+func (s *Session) Queue(cmd string, key string, args []string) error {
+	if !s.multi {
+		return fmt.Errorf("ERR %v queued without MULTI", cmd)
+	}
+	instruction := store.NewInstruction(cmd, key, args, time.Time{})
+
+	if !s.store.IsReadOnly(instruction) && !s.store.IsWriteOnly(instruction) && !s.store.RequiresConsensus(instruction) {
+		s.dirty = true
+		return fmt.Errorf("ERR unknown command '%v'", cmd)
+	}
+
+	// single key forms of multi key commands, like DEL, are executed as is
+	split, isMultiKey, err := s.store.SplitMultiKey(cmd, append([]string{key}, args...), time.Time{})
+	if err != nil {
+		s.dirty = true
+		return fmt.Errorf("ERR %v", err)
+	}
+	if isMultiKey && !(len(split) == 1 && strings.ToUpper(split[0].Cmd) == strings.ToUpper(cmd)) {
+		s.dirty = true
+		return fmt.Errorf("ERR %v can't be executed in a transaction", cmd)
+	}
+
+	s.queued = append(s.queued, instruction)
+	return nil
+}
+
+// executes the queued commands, and returns the result and error of each
+// of them. An error in one command doesn't prevent the others from being
+// executed. If a watched key has changed, nothing is executed, and nil
+// values and errors are returned. The session's keys are unwatched
+// whether the transaction is executed or not
+func (s *Session) Exec() ([]store.Value, []error, error) {
+	if !s.multi {
+		return nil, nil, fmt.Errorf("ERR EXEC without MULTI")
+	}
+	queued := s.queued
+	watches := s.watches
+	dirty := s.dirty
+	s.reset()
+
+	if dirty {
+		return nil, nil, fmt.Errorf("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	// there's nothing to run an instance for,
+	// so check the watched keys locally
+	if len(queued) == 0 {
+		for _, watch := range watches {
+			current, err := s.executor.WatchKey(watch.Key, s.consistency)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !current.Timestamp.Equal(watch.Timestamp) {
+				return nil, nil, nil
+			}
+		}
+		return []store.Value{}, []error{}, nil
+	}
+
+	// writes are all stamped with the time the transaction was executed,
+	// and the session's origin, reads aren't timestamped, as in the cluster
+	timestamp := s.clock()
+	instructions := make([]store.Instruction, len(queued))
+	for i, instruction := range queued {
+		if s.store.IsReadOnly(instruction) {
+			instructions[i] = instruction
+		} else {
+			instructions[i] = store.NewInstruction(instruction.Cmd, instruction.Key, instruction.Args, timestamp)
+			instructions[i].Origin = s.origin
+		}
+	}
+
+	vals, errs, err := s.executor.ExecuteTransaction(instructions, watches, s.consistency)
+	if err != nil {
+		if _, aborted := err.(consensus.TransactionAbortedError); aborted {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	return vals, errs, nil
+}
+
+// discards the queued commands, and unwatches the session's keys
+func (s *Session) Discard() error {
+	if !s.multi {
+		return fmt.Errorf("ERR DISCARD without MULTI")
+	}
+	s.reset()
+	return nil
+}
+
+// watches the given keys. A key that's already
+// watched keeps the timestamp it was first watched at
+func (s *Session) Watch(keys ...string) error {
+	if s.multi {
+		return fmt.Errorf("ERR WATCH inside MULTI is not allowed")
+	}
+	watched := make(map[string]bool, len(s.watches))
+	for _, watch := range s.watches {
+		watched[watch.Key] = true
+	}
+	for _, key := range keys {
+		if watched[key] {
+			continue
+		}
+		watch, err := s.executor.WatchKey(key, s.consistency)
+		if err != nil {
+			return fmt.Errorf("ERR %v", err)
+		}
+		watched[key] = true
+		s.watches = append(s.watches, watch)
+	}
+	return nil
+}
+
+// forgets all watched keys
+func (s *Session) Unwatch() {
+	s.watches = make([]consensus.WatchedKey, 0)
+}
+
+// clears the transaction state, and unwatches all keys
+func (s *Session) reset() {
+	s.multi = false
+	s.dirty = false
+	s.queued = make([]store.Instruction, 0)
+	s.Unwatch()
+}
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"testing_helpers"
+	"time"
+)
+
+import (
+	"consensus"
+	"kvstore"
+	"store"
+	"types"
+)
+
+// executes transactions directly against a kvstore,
+// tracking the timestamp of each key's last write
+type mockExecutor struct {
+	store *kvstore.KVStore
+	timestamps map[string]time.Time
+	executed [][]store.Instruction
+	consistencies []consensus.ConsistencyLevel
+}
+
+func newMockExecutor() *mockExecutor {
+	return &mockExecutor{
+		store: kvstore.NewKVStore(),
+		timestamps: make(map[string]time.Time),
+		executed: make([][]store.Instruction, 0),
+	}
+}
+
+func (e *mockExecutor) WatchKey(key string, consistency consensus.ConsistencyLevel) (consensus.WatchedKey, error) {
+	e.consistencies = append(e.consistencies, consistency)
+	return consensus.WatchedKey{Key: key, Timestamp: e.timestamps[key]}, nil
+}
+
+func (e *mockExecutor) ExecuteTransaction(instructions []store.Instruction, watches []consensus.WatchedKey, consistency consensus.ConsistencyLevel) ([]store.Value, []error, error) {
+	e.consistencies = append(e.consistencies, consistency)
+	for _, watch := range watches {
+		if !e.timestamps[watch.Key].Equal(watch.Timestamp) {
+			return nil, nil, consensus.NewTransactionAbortedError("watched key changed")
+		}
+	}
+	e.executed = append(e.executed, instructions)
+	vals := make([]store.Value, len(instructions))
+	errs := make([]error, len(instructions))
+	for i, instruction := range instructions {
+		vals[i], errs[i] = e.store.ExecuteInstruction(instruction)
+		if !instruction.Timestamp.IsZero() {
+			e.timestamps[instruction.Key] = instruction.Timestamp
+		}
+	}
+	return vals, errs, nil
+}
+
+// writes to the given key outside of a transaction
+func (e *mockExecutor) set(key string, val string) {
+	ts := time.Now()
+	e.store.ExecuteInstruction(store.NewInstruction("SET", key, []string{val}, ts))
+	e.timestamps[key] = ts
+}
+
+// the origin of the test sessions' writes
+var testOrigin = types.NewUUID4()
+
+func setupSession() (*Session, *mockExecutor) {
+	executor := newMockExecutor()
+	return NewSession(executor.store, executor, consensus.CONSISTENCY_CONSENSUS_LOCAL, time.Now, testOrigin), executor
+}
+
+func assertErrorPrefix(t *testing.T, name string, prefix string, err error) {
+	if err == nil {
+		t.Fatalf("Expected %v error, got nil", name)
+	}
+	if !strings.HasPrefix(err.Error(), prefix) {
+		t.Errorf("Expected %v error to start with '%v', got '%v'", name, prefix, err)
+	}
+}
+
+func TestMultiExec(t *testing.T) {
+	s, executor := setupSession()
+
+	if err := s.Multi(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "in multi", true, s.InMulti())
+	if err := s.Queue("SET", "a", []string{"b"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := s.Queue("GET", "a", []string{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// nothing is executed until EXEC
+	testing_helpers.AssertEqual(t, "num executed", 0, len(executor.executed))
+
+	vals, errs, err := s.Exec()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num executed", 1, len(executor.executed))
+	testing_helpers.AssertEqual(t, "num vals", 2, len(vals))
+	testing_helpers.AssertEqual(t, "num errs", 2, len(errs))
+	testing_helpers.AssertEqual(t, "get val", "b", vals[1].(*kvstore.String).GetValue())
+	testing_helpers.AssertEqual(t, "in multi", false, s.InMulti())
+
+	// writes are timestamped, reads aren't
+	testing_helpers.AssertEqual(t, "write timestamp", false, executor.executed[0][0].Timestamp.IsZero())
+	testing_helpers.AssertEqual(t, "read timestamp", true, executor.executed[0][1].Timestamp.IsZero())
+
+	// writes are made at the session's origin
+	testing_helpers.AssertEqual(t, "write origin", testOrigin, executor.executed[0][0].Origin)
+}
+
+func queueAll(t *testing.T, s *Session, instructions ...store.Instruction) {
+	for _, instruction := range instructions {
+		if err := s.Queue(instruction.Cmd, instruction.Key, instruction.Args); err != nil {
+			t.Fatalf("Unexpected error queueing %v: %v", instruction, err)
+		}
+	}
+}
+
+// tests that runtime errors are returned for the
+// failed commands, and don't prevent the others
+func TestExecCommandError(t *testing.T) {
+	s, executor := setupSession()
+	executor.set("a", "b")
+
+	s.Multi()
+	queueAll(
+		t,
+		s,
+		store.NewInstruction("HGET", "a", []string{"f"}, time.Time{}),
+		store.NewInstruction("SET", "c", []string{"d"}, time.Time{}),
+	)
+	vals, errs, err := s.Exec()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if errs[0] == nil {
+		t.Errorf("Expected error for HGET on string value")
+	}
+	if errs[1] != nil {
+		t.Errorf("Unexpected error for SET: %v", errs[1])
+	}
+	testing_helpers.AssertEqual(t, "num vals", 2, len(vals))
+	testing_helpers.AssertEqual(t, "c exists", true, executor.store.KeyExists("c"))
+}
+
+func TestEmptyExec(t *testing.T) {
+	s, executor := setupSession()
+	s.Multi()
+	vals, errs, err := s.Exec()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if vals == nil || errs == nil {
+		t.Errorf("Expected empty, non nil results")
+	}
+	testing_helpers.AssertEqual(t, "num vals", 0, len(vals))
+	testing_helpers.AssertEqual(t, "num executed", 0, len(executor.executed))
+}
+
+func TestNestedMulti(t *testing.T) {
+	s, _ := setupSession()
+	s.Multi()
+	assertErrorPrefix(t, "nested multi", "ERR", s.Multi())
+
+	// nesting doesn't discard the transaction
+	queueAll(t, s, store.NewInstruction("SET", "a", []string{"b"}, time.Time{}))
+	_, _, err := s.Exec()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestWithoutMulti(t *testing.T) {
+	s, _ := setupSession()
+	_, _, err := s.Exec()
+	assertErrorPrefix(t, "exec", "ERR", err)
+	assertErrorPrefix(t, "discard", "ERR", s.Discard())
+	assertErrorPrefix(t, "queue", "ERR", s.Queue("SET", "a", []string{"b"}))
+}
+
+func TestWatchInsideMulti(t *testing.T) {
+	s, _ := setupSession()
+	s.Multi()
+	assertErrorPrefix(t, "watch", "ERR", s.Watch("a"))
+	testing_helpers.AssertEqual(t, "num watches", 0, len(s.watches))
+}
+
+// tests that commands which can't be queued cause
+// the whole transaction to be discarded on EXEC
+func TestQueueErrorAbortsExec(t *testing.T) {
+	s, executor := setupSession()
+	s.Multi()
+	queueAll(t, s, store.NewInstruction("SET", "a", []string{"b"}, time.Time{}))
+	assertErrorPrefix(t, "unknown command", "ERR", s.Queue("FOO", "a", []string{}))
+
+	_, _, err := s.Exec()
+	assertErrorPrefix(t, "exec", "EXECABORT", err)
+	testing_helpers.AssertEqual(t, "num executed", 0, len(executor.executed))
+	testing_helpers.AssertEqual(t, "in multi", false, s.InMulti())
+
+	// the next transaction isn't affected
+	s.Multi()
+	queueAll(t, s, store.NewInstruction("SET", "a", []string{"b"}, time.Time{}))
+	if _, _, err := s.Exec(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestQueueMultiKey(t *testing.T) {
+	s, _ := setupSession()
+	s.Multi()
+	// single key DEL is fine
+	queueAll(t, s, store.NewInstruction("DEL", "a", []string{}, time.Time{}))
+	assertErrorPrefix(t, "mget", "ERR", s.Queue("MGET", "a", []string{"b"}))
+	testing_helpers.AssertEqual(t, "dirty", true, s.dirty)
+}
+
+// tests that the internal commands stores use to replicate
+// and reconcile values can't be queued by clients
+func TestQueueInternal(t *testing.T) {
+	for _, cmd := range []string{"CSHARD", "CINCRBY", "SREMTAG"} {
+		s, _ := setupSession()
+		s.Multi()
+		assertErrorPrefix(t, cmd, "ERR unknown command", s.Queue(cmd, "a", []string{"n1", "1"}))
+		testing_helpers.AssertEqual(t, "dirty", true, s.dirty)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	s, executor := setupSession()
+	s.Watch("a")
+	s.Multi()
+	queueAll(t, s, store.NewInstruction("SET", "a", []string{"b"}, time.Time{}))
+
+	if err := s.Discard(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "in multi", false, s.InMulti())
+	testing_helpers.AssertEqual(t, "num queued", 0, len(s.queued))
+	testing_helpers.AssertEqual(t, "num watches", 0, len(s.watches))
+	testing_helpers.AssertEqual(t, "num executed", 0, len(executor.executed))
+}
+
+// tests that EXEC returns nil results, and executes
+// nothing, if a watched key has changed
+func TestWatchAbort(t *testing.T) {
+	s, executor := setupSession()
+	executor.set("a", "b")
+
+	if err := s.Watch("a"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	executor.set("a", "c")
+
+	s.Multi()
+	queueAll(t, s, store.NewInstruction("SET", "d", []string{"e"}, time.Time{}))
+	vals, errs, err := s.Exec()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if vals != nil || errs != nil {
+		t.Errorf("Expected nil results for aborted transaction, got %v, %v", vals, errs)
+	}
+	testing_helpers.AssertEqual(t, "d exists", false, executor.store.KeyExists("d"))
+
+	// exec unwatches keys, so the next transaction is executed
+	testing_helpers.AssertEqual(t, "num watches", 0, len(s.watches))
+	s.Multi()
+	queueAll(t, s, store.NewInstruction("SET", "d", []string{"e"}, time.Time{}))
+	vals, _, err = s.Exec()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num vals", 1, len(vals))
+	testing_helpers.AssertEqual(t, "d exists", true, executor.store.KeyExists("d"))
+}
+
+// tests that the first watch of a key is kept, and
+// that unchanged watched keys don't abort transactions
+func TestWatchUnchanged(t *testing.T) {
+	s, executor := setupSession()
+	s.Watch("a", "b")
+	executor.set("a", "c")
+	s.Watch("a")
+	testing_helpers.AssertEqual(t, "num watches", 2, len(s.watches))
+	testing_helpers.AssertEqual(t, "watch timestamp", true, s.watches[0].Timestamp.IsZero())
+
+	s.Unwatch()
+	s.Watch("a")
+	s.Multi()
+	queueAll(t, s, store.NewInstruction("GET", "a", []string{}, time.Time{}))
+	vals, _, err := s.Exec()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "get val", "c", vals[0].(*kvstore.String).GetValue())
+}
+
+// tests that an empty transaction is aborted if a watched key changed
+func TestWatchAbortEmpty(t *testing.T) {
+	s, executor := setupSession()
+	s.Watch("a")
+	executor.set("a", "b")
+	s.Multi()
+	vals, errs, err := s.Exec()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if vals != nil || errs != nil {
+		t.Errorf("Expected nil results for aborted transaction, got %v, %v", vals, errs)
+	}
+}
+
+// tests that watches and transactions are executed
+// at the session's consistency level
+func TestSessionConsistency(t *testing.T) {
+	executor := newMockExecutor()
+	s := NewSession(executor.store, executor, consensus.CONSISTENCY_CONSENSUS, time.Now, testOrigin)
+	s.Watch("a")
+	s.Multi()
+	queueAll(t, s, store.NewInstruction("GET", "a", []string{}, time.Time{}))
+	if _, _, err := s.Exec(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testing_helpers.AssertEqual(t, "num calls", 2, len(executor.consistencies))
+	for _, consistency := range executor.consistencies {
+		testing_helpers.AssertEqual(t, "consistency", consensus.CONSISTENCY_CONSENSUS, consistency)
+	}
+}
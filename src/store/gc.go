@@ -0,0 +1,119 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// how long tombstones are kept before they're purged by default
+//
+// a replica that misses a deletion has until the tombstone is purged to
+// receive it, through hinted handoff or read repair. If it's down for
+// longer than the grace period, the deleted value it holds can come back
+const DEFAULT_GC_GRACE = 10 * 24 * time.Hour
+
+// how often stores check for tombstones to purge
+const GC_INTERVAL = time.Minute
+
+// the number of keys purged each time the gc takes a store's write lock
+const GC_BATCH_SIZE = 1000
+
+// implemented by values that keep tombstones for their members, like
+// the removed tags of sets, or the deleted fields of hashes. Returns
+// the number of tombstones removed
+type TombstonePurger interface {
+	PurgeTombstones(horizon time.Time) int
+}
+
+// purges the tombstones of a store's data. It's guarded by the same
+// lock as the data it purges, which Purge takes itself
+type TombstoneGC struct {
+	// how long tombstones are kept before they're purged
+	Grace time.Duration
+	// tombstones timestamped before this time may have been purged
+	PurgedBefore time.Time
+	// number of tombstones purged since the store was created
+	NumPurged uint64
+}
+
+func NewTombstoneGC() TombstoneGC {
+	return TombstoneGC{Grace: DEFAULT_GC_GRACE}
+}
+
+// removes the values of the given tombstone type that were created more
+// than the grace period before the given time, and the tombstones kept
+// inside values, and returns the number of tombstones removed. Purged
+// keys are removed from the store's key index
+//
+// the keys to purge are found under the read lock, and purged in batches of
+// GC_BATCH_SIZE, each under the write lock, so the store's reads and writes
+// aren't blocked for the whole sweep. Keys written between the scan and their
+// batch are checked again before they're purged
+//
+// since replicas purge tombstones independently, stores should ignore writes
+// timestamped before PurgedBefore for keys that don't exist, so replayed hints
+// and repairs of values that were deleted can't recreate them
+func (gc *TombstoneGC) Purge(lock *sync.RWMutex, data map[string]Value, index *KeyIndex, tombstone ValueType, at time.Time) int {
+	purgeable := func(val Value, horizon time.Time) bool {
+		return val.GetValueType() == tombstone && val.GetTimestamp().Before(horizon)
+	}
+
+	lock.RLock()
+	horizon := at.Add(-gc.Grace)
+	keys := make([]string, 0)
+	for key, val := range data {
+		if _, ok := val.(TombstonePurger); ok || purgeable(val, horizon) {
+			keys = append(keys, key)
+		}
+	}
+	lock.RUnlock()
+
+	// PurgedBefore is advanced before any tombstone is
+	// removed, so writes that would recreate them are ignored
+	lock.Lock()
+	if horizon.After(gc.PurgedBefore) {
+		gc.PurgedBefore = horizon
+	}
+	lock.Unlock()
+
+	num := 0
+	for start := 0; start < len(keys); start += GC_BATCH_SIZE {
+		end := start + GC_BATCH_SIZE
+		if end > len(keys) {
+			end = len(keys)
+		}
+		lock.Lock()
+		batchNum := 0
+		for _, key := range keys[start:end] {
+			val, exists := data[key]
+			if !exists {
+				continue
+			}
+			if purgeable(val, horizon) {
+				delete(data, key)
+				index.Remove(key)
+				batchNum++
+			} else if purger, ok := val.(TombstonePurger); ok {
+				batchNum += purger.PurgeTombstones(horizon)
+			}
+		}
+		gc.NumPurged += uint64(batchNum)
+		lock.Unlock()
+		num += batchNum
+	}
+	return num
+}
+
+// calls purge every interval, until stop is closed
+func RunGC(stop chan bool, interval time.Duration, purge func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			purge()
+		case <-stop:
+			return
+		}
+	}
+}
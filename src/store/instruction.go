@@ -8,6 +8,7 @@ import (
 
 import (
 	"serializer"
+	"types"
 )
 
 // an instruction to be executed against
@@ -18,6 +19,16 @@ type Instruction struct {
 	Key string
 	Args []string
 	Timestamp time.Time
+
+	// the node that issued the timestamp, used to order
+	// writes with equal timestamps. See CompareWrites
+	Origin types.UUID
+
+	// set on the instructions stores generate with their internal commands,
+	// which replicate and reconcile values, like counter shards and removed
+	// set tags. Stores refuse internal commands without it, so clients
+	// can't issue them directly
+	Internal bool
 }
 
 // creates a new instruction
@@ -30,6 +41,14 @@ func NewInstruction(cmd string, key string, args []string, timestamp time.Time)
 	}
 }
 
+// creates a new internal instruction. These should only be created by
+// stores, when they replicate or reconcile values, never from client commands
+func NewInternalInstruction(cmd string, key string, args []string, timestamp time.Time) Instruction {
+	instruction := NewInstruction(cmd, key, args, timestamp)
+	instruction.Internal = true
+	return instruction
+}
+
 // instruction equality test
 func (i *Instruction) Equal(o Instruction) bool {
 	if i.Cmd != o.Cmd { return false }
@@ -39,6 +58,8 @@ func (i *Instruction) Equal(o Instruction) bool {
 		if i.Args[n] != o.Args[n] { return false}
 	}
 	if i.Timestamp != o.Timestamp { return false }
+	if i.Origin != o.Origin { return false }
+	if i.Internal != o.Internal { return false }
 	return true
 }
 
@@ -48,6 +69,8 @@ func (i *Instruction) Copy() Instruction {
 		Key: i.Key,
 		Args: make([]string, len(i.Args)),
 		Timestamp: i.Timestamp,
+		Origin: i.Origin,
+		Internal: i.Internal,
 	}
 	copy(newInstr.Args, i.Args)
 	return newInstr
@@ -68,6 +91,8 @@ func (i *Instruction) NumBytes() (int) {
 		numBytes += serializer.NumStringBytes(arg)
 	}
 	numBytes += serializer.NumTimeBytes()
+	numBytes += types.UUID_NUM_BYTES
+	numBytes += 1  // internal bool
 	return numBytes
 }
 
@@ -80,6 +105,8 @@ func (i *Instruction) Serialize(buf *bufio.Writer) error {
 		if err := serializer.WriteFieldString(buf, arg); err != nil { return err }
 	}
 	if err := serializer.WriteTime(buf, i.Timestamp); err != nil { return err }
+	if err := (&i.Origin).WriteBuffer(buf); err != nil { return err }
+	if err := binary.Write(buf, binary.LittleEndian, &i.Internal); err != nil { return err }
 	return nil
 }
 
@@ -102,5 +129,7 @@ func (i *Instruction) Deserialize(buf *bufio.Reader) error {
 	if val, err := serializer.ReadTime(buf); err != nil { return err } else {
 		i.Timestamp = val
 	}
+	if err := (&i.Origin).ReadBuffer(buf); err != nil { return err }
+	if err := binary.Read(buf, binary.LittleEndian, &i.Internal); err != nil { return err }
 	return nil
 }
@@ -0,0 +1,274 @@
+package store
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"time"
+)
+
+import (
+	"partitioner"
+)
+
+// the number of keys an iterator holds in memory at once
+const ITERATOR_PAGE_SIZE = 1000
+
+// iterates over the keys held by a store, and their values, in token
+// order, and by key within a token. Tombstones are included
+type Iterator interface {
+	// advances the iterator to the next key, returning false
+	// once every key has been visited
+	Next() bool
+
+	// returns the key, token, and value the iterator is positioned at
+	Key() string
+	Token() partitioner.Token
+	Value() Value
+}
+
+// reads a page from a store's key index. The value function returns a
+// copy of the value stored at a key, and false if the key doesn't exist,
+// so the page can be used after the store's lock is released
+type PageRead func(index *KeyIndex, value func(key string) (Value, bool))
+
+// calls read with the store's key index, while holding
+// the lock that prevents the store from being modified
+type PageReader func(read PageRead)
+
+type iteratorEntry struct {
+	key string
+	token partitioner.Token
+	val Value
+}
+
+// the maximum height of the key index's skip list
+const maxIndexLevel = 32
+
+type indexNode struct {
+	key string
+	token partitioner.Token
+	next []*indexNode
+}
+
+// returns true if the node comes before the given token and key
+func (n *indexNode) before(token partitioner.Token, key string) bool {
+	if cmp := bytes.Compare(n.token, token); cmp != 0 {
+		return cmp < 0
+	}
+	return n.key < key
+}
+
+// a skip list of a store's keys, ordered by token, then key, so iterators
+// can seek to their position without visiting every key. It isn't safe for
+// concurrent use, stores guard it with the same lock as their data.
+// Methods on a nil index do nothing, so stores that haven't been
+// iterated over don't need to maintain one
+type KeyIndex struct {
+	partitioner partitioner.Partitioner
+	head *indexNode
+	level int
+	size int
+	rand *rand.Rand
+}
+
+func NewKeyIndex(p partitioner.Partitioner) *KeyIndex {
+	return &KeyIndex{
+		partitioner:p,
+		head:&indexNode{next:make([]*indexNode, maxIndexLevel)},
+		level:1,
+		rand:rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// returns an index of the keys of the given data
+func IndexKeys(p partitioner.Partitioner, data map[string]Value) *KeyIndex {
+	index := NewKeyIndex(p)
+	for key := range data {
+		index.Add(key)
+	}
+	return index
+}
+
+// returns the partitioner used to get the tokens of indexed keys
+func (idx *KeyIndex) Partitioner() partitioner.Partitioner {
+	if idx == nil {
+		return nil
+	}
+	return idx.partitioner
+}
+
+// returns true if the keys are indexed in the token order of the given
+// partitioner. Partitioners are identified by their type, since partitioners
+// of the same type produce the same tokens. Comparing the partitioners
+// themselves panics for types that aren't comparable, and pointers to
+// stateless partitioners aren't reliably equal or unequal
+func (idx *KeyIndex) IndexedBy(p partitioner.Partitioner) bool {
+	if idx == nil {
+		return false
+	}
+	return reflect.TypeOf(idx.partitioner) == reflect.TypeOf(p)
+}
+
+// returns the number of indexed keys
+func (idx *KeyIndex) Len() int {
+	if idx == nil {
+		return 0
+	}
+	return idx.size
+}
+
+// returns the first node at or after the given position. If update isn't
+// nil, it's filled with the last node before the position at each level
+func (idx *KeyIndex) find(token partitioner.Token, key string, update []*indexNode) *indexNode {
+	x := idx.head
+	for lvl:=idx.level-1; lvl>=0; lvl-- {
+		for x.next[lvl] != nil && x.next[lvl].before(token, key) {
+			x = x.next[lvl]
+		}
+		if update != nil {
+			update[lvl] = x
+		}
+	}
+	return x.next[0]
+}
+
+func (idx *KeyIndex) randomLevel() int {
+	lvl := 1
+	for lvl < maxIndexLevel && idx.rand.Int63() & 3 == 0 {
+		lvl++
+	}
+	return lvl
+}
+
+// adds the key to the index, if it isn't already indexed
+func (idx *KeyIndex) Add(key string) {
+	if idx == nil {
+		return
+	}
+	token := idx.partitioner.GetToken(key)
+	update := make([]*indexNode, maxIndexLevel)
+	if n := idx.find(token, key, update); n != nil && n.key == key {
+		return
+	}
+
+	lvl := idx.randomLevel()
+	for ; idx.level < lvl; idx.level++ {
+		update[idx.level] = idx.head
+	}
+	n := &indexNode{key:key, token:token, next:make([]*indexNode, lvl)}
+	for i:=0; i<lvl; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	idx.size++
+}
+
+// removes the key from the index
+func (idx *KeyIndex) Remove(key string) {
+	if idx == nil {
+		return
+	}
+	token := idx.partitioner.GetToken(key)
+	update := make([]*indexNode, maxIndexLevel)
+	n := idx.find(token, key, update)
+	if n == nil || n.key != key {
+		return
+	}
+	for i:=0; i<len(n.next); i++ {
+		update[i].next[i] = n.next[i]
+	}
+	for idx.level > 1 && idx.head.next[idx.level - 1] == nil {
+		idx.level--
+	}
+	idx.size--
+}
+
+// returns up to limit entries, with copies of their values, beginning after
+// the last entry, or at the start token if last is nil
+func (idx *KeyIndex) page(start partitioner.Token, last *iteratorEntry, limit int, value func(string) (Value, bool)) []*iteratorEntry {
+	var n *indexNode
+	if last == nil {
+		n = idx.find(start, "", nil)
+	} else {
+		n = idx.find(last.token, last.key, nil)
+		if n != nil && n.key == last.key {
+			n = n.next[0]
+		}
+	}
+
+	page := make([]*iteratorEntry, 0, limit)
+	for ; n != nil && len(page) < limit; n = n.next[0] {
+		if val, exists := value(n.key); exists {
+			page = append(page, &iteratorEntry{key:n.key, token:n.token, val:val})
+		}
+	}
+	return page
+}
+
+// an iterator that loads keys a page at a time, so memory use
+// is bounded by the page size, not the number of keys
+//
+// each page is read from a consistent view of the store, and begins
+// after the last key of the previous page. So writes made while
+// iterating are seen if they're ahead of the iterator, and not if
+// they're behind it, and no key is visited more than once
+type pagedIterator struct {
+	reader PageReader
+	pageSize int
+
+	// the last key yielded, nil until the first key is
+	last *iteratorEntry
+	start partitioner.Token
+
+	page []*iteratorEntry
+	current *iteratorEntry
+	exhausted bool
+}
+
+// returns an iterator over the keys in the index given to the page reads, starting
+// at the first key with a token greater than or equal to the start token. An
+// empty start token starts at the beginning of the token space
+func NewIterator(start partitioner.Token, pageSize int, reader PageReader) Iterator {
+	if pageSize < 1 {
+		pageSize = ITERATOR_PAGE_SIZE
+	}
+	return &pagedIterator{
+		reader:reader,
+		pageSize:pageSize,
+		start:start,
+	}
+}
+
+// loads the next page of keys
+func (i *pagedIterator) loadPage() {
+	i.page = nil
+	i.reader(func(index *KeyIndex, value func(string) (Value, bool)) {
+		if index != nil {
+			i.page = index.page(i.start, i.last, i.pageSize, value)
+		}
+	})
+	i.exhausted = len(i.page) < i.pageSize
+}
+
+func (i *pagedIterator) Next() bool {
+	if len(i.page) == 0 {
+		if i.exhausted {
+			i.current = nil
+			return false
+		}
+		i.loadPage()
+		if len(i.page) == 0 {
+			i.current = nil
+			return false
+		}
+	}
+	i.current = i.page[0]
+	i.page = i.page[1:]
+	i.last = i.current
+	return true
+}
+
+func (i *pagedIterator) Key() string { return i.current.key }
+func (i *pagedIterator) Token() partitioner.Token { return i.current.token }
+func (i *pagedIterator) Value() Value { return i.current.val }
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"testing_helpers"
+)
+
+import (
+	"partitioner"
+)
+
+// uses the key as it's token, so keys are iterated in key order
+type keyPartitioner struct {}
+
+func (p keyPartitioner) GetToken(key string) partitioner.Token {
+	return partitioner.Token(key)
+}
+
+// a partitioner that isn't comparable
+type sliceKeyPartitioner []string
+
+func (p sliceKeyPartitioner) GetToken(key string) partitioner.Token {
+	return partitioner.Token(key)
+}
+
+// a map of keys, indexed the same way as a store
+type indexedMap struct {
+	data map[string]Value
+	index *KeyIndex
+}
+
+func newIndexedMap(keys ...string) *indexedMap {
+	m := &indexedMap{data:make(map[string]Value), index:NewKeyIndex(keyPartitioner{})}
+	for _, key := range keys {
+		m.set(key)
+	}
+	return m
+}
+
+func (m *indexedMap) set(key string) {
+	m.data[key] = nil
+	m.index.Add(key)
+}
+
+func (m *indexedMap) remove(key string) {
+	delete(m.data, key)
+	m.index.Remove(key)
+}
+
+func (m *indexedMap) iterator(start partitioner.Token, pageSize int) Iterator {
+	return NewIterator(start, pageSize, func(read PageRead) {
+		read(m.index, func(key string) (Value, bool) {
+			val, exists := m.data[key]
+			return val, exists
+		})
+	})
+}
+
+func iterateAll(iter Iterator) []string {
+	keys := make([]string, 0)
+	for iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	return keys
+}
+
+func assertKeys(t *testing.T, expected []string, actual []string) {
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("keys mismatch. Expecting %v, got %v", expected, actual)
+	}
+}
+
+func TestIteratorOrder(t *testing.T) {
+	data := newIndexedMap("d", "a", "c", "e", "b")
+	for _, pageSize := range []int{1, 2, 5, 10} {
+		iter := data.iterator(nil, pageSize)
+		assertKeys(t, []string{"a", "b", "c", "d", "e"}, iterateAll(iter))
+	}
+}
+
+func TestIteratorStartToken(t *testing.T) {
+	data := newIndexedMap("a", "b", "c", "d")
+	iter := data.iterator(partitioner.Token("b"), 2)
+	assertKeys(t, []string{"b", "c", "d"}, iterateAll(iter))
+
+	iter = data.iterator(partitioner.Token("bb"), 2)
+	assertKeys(t, []string{"c", "d"}, iterateAll(iter))
+}
+
+// tests that keys written ahead of the iterator are visited,
+// and keys written behind it, or removed, are not
+func TestIteratorConcurrentWrites(t *testing.T) {
+	data := newIndexedMap("b", "d", "f", "h")
+	iter := data.iterator(nil, 2)
+
+	keys := make([]string, 0)
+	for iter.Next() {
+		keys = append(keys, iter.Key())
+		if iter.Key() == "d" {
+			data.set("a")
+			data.set("e")
+			data.set("g")
+			data.remove("b")
+			data.remove("h")
+		}
+	}
+	assertKeys(t, []string{"b", "d", "e", "f", "g"}, keys)
+}
+
+func TestEmptyIterator(t *testing.T) {
+	iter := newIndexedMap().iterator(nil, 2)
+	testing_helpers.AssertEqual(t, "next", false, iter.Next())
+	testing_helpers.AssertEqual(t, "next", false, iter.Next())
+}
+
+// tests that keys are only indexed once, and removed keys are
+// unlinked from every level of the index
+func TestKeyIndex(t *testing.T) {
+	data := newIndexedMap()
+	keys := make([]string, 0)
+	for i:=0; i<1000; i++ {
+		keys = append(keys, fmt.Sprintf("%04d", i))
+	}
+	for _, key := range keys {
+		data.set(key)
+		data.set(key)
+	}
+	testing_helpers.AssertEqual(t, "size", 1000, data.index.Len())
+	assertKeys(t, keys, iterateAll(data.iterator(nil, 7)))
+
+	remaining := make([]string, 0)
+	for i, key := range keys {
+		if i % 3 == 0 {
+			data.remove(key)
+		} else {
+			remaining = append(remaining, key)
+		}
+	}
+	data.remove("missing")
+	testing_helpers.AssertEqual(t, "size", len(remaining), data.index.Len())
+	assertKeys(t, remaining, iterateAll(data.iterator(nil, 7)))
+	assertKeys(t, remaining[len(remaining)-2:], iterateAll(data.iterator(partitioner.Token("0997"), 7)))
+}
+
+// tests that methods on a nil index do nothing, so
+// stores don't need to index keys until they're iterated
+func TestNilKeyIndex(t *testing.T) {
+	var index *KeyIndex
+	index.Add("a")
+	index.Remove("a")
+	testing_helpers.AssertEqual(t, "size", 0, index.Len())
+}
+
+// tests that indexes identify their partitioner by type, without
+// comparing partitioners that can't be compared
+func TestIndexedBy(t *testing.T) {
+	var nilIndex *KeyIndex
+	testing_helpers.AssertEqual(t, "nil index", false, nilIndex.IndexedBy(keyPartitioner{}))
+
+	index := NewKeyIndex(keyPartitioner{})
+	testing_helpers.AssertEqual(t, "same type", true, index.IndexedBy(keyPartitioner{}))
+	testing_helpers.AssertEqual(t, "other type", false, index.IndexedBy(sliceKeyPartitioner{}))
+
+	index = NewKeyIndex(sliceKeyPartitioner{"a"})
+	testing_helpers.AssertEqual(t, "uncomparable", true, index.IndexedBy(sliceKeyPartitioner{"b"}))
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"strings"
+	"sync"
+)
+
+// reconciles the values held by multiple replicas for a key, and
+// returns the reconciled value, along with the instructions needed
+// to bring each replica's value in line with it. The returned
+// instructions are indexed the same as the given values. Policies
+// operate on the value types of the store they're registered with
+type ReconcilePolicy interface {
+	Reconcile(key string, values []Value) (Value, [][]Instruction, error)
+}
+
+// adapts an ordinary function to the ReconcilePolicy interface
+type ReconcileFunc func(key string, values []Value) (Value, [][]Instruction, error)
+
+func (f ReconcileFunc) Reconcile(key string, values []Value) (Value, [][]Instruction, error) {
+	return f(key, values)
+}
+
+// maps key prefixes to the policies used to reconcile keys
+// beginning with them. Keys can be namespaced by registering
+// a policy under a prefix like "tags:"
+type ReconcileRegistry struct {
+	policies map[string]ReconcilePolicy
+	lock sync.RWMutex
+}
+
+func NewReconcileRegistry() *ReconcileRegistry {
+	return &ReconcileRegistry{policies: make(map[string]ReconcilePolicy)}
+}
+
+// registers a policy for all keys beginning with the given prefix,
+// replacing any policy previously registered for it
+func (r *ReconcileRegistry) Register(prefix string, policy ReconcilePolicy) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.policies[prefix] = policy
+}
+
+func (r *ReconcileRegistry) Unregister(prefix string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.policies, prefix)
+}
+
+// returns the policy registered with the longest prefix of
+// the given key, or nil if no registered prefixes match it
+func (r *ReconcileRegistry) Get(key string) ReconcilePolicy {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var policy ReconcilePolicy
+	matchLen := -1
+	for prefix, p := range r.policies {
+		if len(prefix) > matchLen && strings.HasPrefix(key, prefix) {
+			policy = p
+			matchLen = len(prefix)
+		}
+	}
+	return policy
+}
@@ -5,6 +5,10 @@ import (
 	"time"
 )
 
+import (
+	"partitioner"
+)
+
 // enum indicating type of value
 type ValueType string
 
@@ -49,6 +53,26 @@ type Store interface {
 
 	IsWriteOnly(instruction Instruction) bool
 
+	// returns true if the given instruction can't be safely reconciled
+	// with other writes, and must be executed in the order determined
+	// by consensus, like mutations of order sensitive types
+	RequiresConsensus(instruction Instruction) bool
+
+	// returns the instruction a single leader replica should execute in
+	// place of the given instruction, for writes that can't be replicated
+	// by executing them on every replica, like counter increments. False
+	// is returned for writes that can be executed on every replica
+	LeaderInstruction(instruction Instruction, leader string) (Instruction, bool)
+
+	// returns the instruction that replicates the leader's result of
+	// a leader instruction to the other replicas
+	ReplicationInstruction(instruction Instruction, leader string, result Value) (Instruction, error)
+
+	// splits a command operating on several keys, like MGET, into single
+	// key instructions, in the order the keys were given. False is returned
+	// if the command doesn't operate on several keys
+	SplitMultiKey(cmd string, args []string, timestamp time.Time) ([]Instruction, bool, error)
+
 	// ----------- data import / export -----------
 
 	// serializes a value
@@ -66,7 +90,16 @@ type Store interface {
 	// returns all of the keys held by the store
 	GetKeys() []string
 
+	// returns an iterator over the keys held by the store, and their values,
+	// in the token order of the given partitioner, starting at the first key
+	// with a token greater than or equal to the start token
+	IterateKeys(p partitioner.Partitioner, start partitioner.Token) Iterator
+
 	// checks if a key exists in the store
 	KeyExists(key string) bool
+
+	// returns true if the given value marks its key as deleted, like
+	// a tombstone, or a value that has expired
+	IsDeleted(val Value) bool
 }
 
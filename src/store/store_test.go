@@ -0,0 +1,14 @@
+package store
+
+import (
+	"testing"
+)
+
+import (
+	"launchpad.net/gocheck"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	gocheck.TestingT(t)
+}
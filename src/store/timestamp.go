@@ -0,0 +1,51 @@
+package store
+
+import (
+	"bytes"
+	"time"
+)
+
+import (
+	"types"
+)
+
+// implemented by values that record the node that issued
+// their timestamp, so writes with equal timestamps can
+// be ordered the same way by every replica
+type OriginValue interface {
+	GetOrigin() types.UUID
+}
+
+// returns the id of the node that issued the value's
+// timestamp, or a zero id if the value doesn't record one
+func GetOrigin(v Value) types.UUID {
+	if ov, ok := v.(OriginValue); ok {
+		return ov.GetOrigin()
+	}
+	return types.UUID{}
+}
+
+// compares the timestamps of two writes, returning -1 if the first
+// was written before the second, 1 if it was written after, and 0 if
+// they're equal. Ties between timestamps are broken by the id of the
+// node that issued them, like hybrid logical clock timestamps are
+func CompareWrites(t0 time.Time, o0 types.UUID, t1 time.Time, o1 types.UUID) int {
+	switch {
+	case t0.Before(t1):
+		return -1
+	case t0.After(t1):
+		return 1
+	}
+	return bytes.Compare(o0.Bytes(), o1.Bytes())
+}
+
+// returns true if v0 was written after v1
+func ValueAfter(v0 Value, v1 Value) bool {
+	return CompareWrites(v0.GetTimestamp(), GetOrigin(v0), v1.GetTimestamp(), GetOrigin(v1)) > 0
+}
+
+// returns true if a write made at the given timestamp, by
+// the given origin, was made before the given value
+func WriteBefore(ts time.Time, origin types.UUID, v Value) bool {
+	return CompareWrites(ts, origin, v.GetTimestamp(), GetOrigin(v)) < 0
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"bufio"
+	"time"
+)
+
+import (
+	"launchpad.net/gocheck"
+)
+
+import (
+	"types"
+)
+
+// a value that doesn't record the node that issued it's timestamp
+type plainValue struct {
+	timestamp time.Time
+}
+
+func (v *plainValue) GetTimestamp() time.Time { return v.timestamp }
+func (v *plainValue) GetValueType() ValueType { return ValueType("PLAIN") }
+func (v *plainValue) Serialize(buf *bufio.Writer) error { return nil }
+func (v *plainValue) Deserialize(buf *bufio.Reader) error { return nil }
+func (v *plainValue) Equal(o Value) bool { return false }
+
+// a value that records the node that issued it's timestamp
+type originValue struct {
+	plainValue
+	origin types.UUID
+}
+
+func (v *originValue) GetOrigin() types.UUID { return v.origin }
+
+type TimestampTest struct { }
+
+var _ = gocheck.Suite(&TimestampTest{})
+
+// tests that writes are ordered by timestamp first
+func (t *TimestampTest) TestCompareTimestamps(c *gocheck.C) {
+	ts := time.Now()
+	o0 := types.NewUUID1()
+	o1 := types.NewUUID1()
+
+	c.Check(CompareWrites(ts, o0, ts.Add(time.Microsecond), o1), gocheck.Equals, -1)
+	c.Check(CompareWrites(ts.Add(time.Microsecond), o0, ts, o1), gocheck.Equals, 1)
+	c.Check(CompareWrites(ts, o0, ts, o0), gocheck.Equals, 0)
+}
+
+// tests that writes with equal timestamps are ordered
+// by origin, regardless of the order they're compared in
+func (t *TimestampTest) TestCompareOrigins(c *gocheck.C) {
+	ts := time.Now()
+	o0 := types.NewUUID1()
+	o1 := types.NewUUID1()
+
+	c.Check(CompareWrites(ts, o0, ts, o1), gocheck.Not(gocheck.Equals), 0)
+	c.Check(CompareWrites(ts, o0, ts, o1), gocheck.Equals, -CompareWrites(ts, o1, ts, o0))
+	c.Check(CompareWrites(ts, types.UUID{}, ts, o0), gocheck.Equals, -1)
+}
+
+// tests that values with equal timestamps are ordered by origin
+func (t *TimestampTest) TestValueAfter(c *gocheck.C) {
+	ts := time.Now()
+	o0 := types.NewUUID1()
+	o1 := types.NewUUID1()
+	if CompareWrites(ts, o0, ts, o1) > 0 {
+		o0, o1 = o1, o0
+	}
+
+	v0 := &originValue{plainValue{ts}, o0}
+	v1 := &originValue{plainValue{ts}, o1}
+	c.Check(ValueAfter(v1, v0), gocheck.Equals, true)
+	c.Check(ValueAfter(v0, v1), gocheck.Equals, false)
+	c.Check(ValueAfter(v0, v0), gocheck.Equals, false)
+
+	// later timestamps win, regardless of origin
+	v2 := &originValue{plainValue{ts.Add(time.Microsecond)}, o0}
+	c.Check(ValueAfter(v2, v1), gocheck.Equals, true)
+	c.Check(ValueAfter(v1, v2), gocheck.Equals, false)
+}
+
+// tests that values without an origin lose ties
+// against values and writes with one
+func (t *TimestampTest) TestMissingOrigin(c *gocheck.C) {
+	ts := time.Now()
+	o0 := types.NewUUID1()
+
+	plain := &plainValue{ts}
+	c.Check(GetOrigin(plain), gocheck.Equals, types.UUID{})
+	c.Check(ValueAfter(&originValue{plainValue{ts}, o0}, plain), gocheck.Equals, true)
+	c.Check(WriteBefore(ts, o0, plain), gocheck.Equals, false)
+}
+
+// tests that writes are compared against values by timestamp, then origin
+func (t *TimestampTest) TestWriteBefore(c *gocheck.C) {
+	ts := time.Now()
+	o0 := types.NewUUID1()
+	o1 := types.NewUUID1()
+	if CompareWrites(ts, o0, ts, o1) > 0 {
+		o0, o1 = o1, o0
+	}
+
+	v := &originValue{plainValue{ts}, o1}
+	c.Check(WriteBefore(ts, o0, v), gocheck.Equals, true)
+	c.Check(WriteBefore(ts, o1, v), gocheck.Equals, false)
+	c.Check(WriteBefore(ts.Add(-time.Microsecond), o1, v), gocheck.Equals, true)
+	c.Check(WriteBefore(ts.Add(time.Microsecond), o0, v), gocheck.Equals, false)
+}
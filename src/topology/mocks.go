@@ -1,10 +1,5 @@
 package topology
 
-import (
-	"time"
-)
-
-
 import (
 	"message"
 	"node"
@@ -51,7 +46,7 @@ func (n *mockNode) Stop() error {
 	return nil
 }
 
-func (n *mockNode) ExecuteQuery(cmd string, key string, args []string, timestamp time.Time) (store.Value, error) {
+func (n *mockNode) ExecuteQuery(instruction store.Instruction) (store.Value, error) {
 	panic("not implemented")
 }
 func (n *mockNode) SendMessage(message.Message) (message.Message, error) {
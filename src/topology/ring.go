@@ -22,9 +22,13 @@ func (ns *nodeSorter) Len() int {
 }
 
 // returns true if the item at index i is less than
-// the item and index j
+// the item and index j. Nodes with the same token are
+// ordered by their ids, so every node builds the same ring
 func (ns *nodeSorter) Less(i, j int) bool {
-	return bytes.Compare(ns.nodes[i].GetToken(), ns.nodes[j].GetToken()) == -1
+	if cmp := bytes.Compare(ns.nodes[i].GetToken(), ns.nodes[j].GetToken()); cmp != 0 {
+		return cmp == -1
+	}
+	return bytes.Compare(ns.nodes[i].GetId().Bytes(), ns.nodes[j].GetId().Bytes()) == -1
 }
 
 // switches the position of nodes at indices i & j
@@ -140,24 +144,28 @@ func (r *Ring) AllNodes() []Node {
 func (r *Ring) GetNodesForToken(t partitioner.Token, replicationFactor uint32) []Node {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
+	return getNodesForToken(r.tokenRing, t, replicationFactor)
+}
 
+// returns the nodes from the given token ordered ring that
+// replicate the given token. The ring isn't modified
+func getNodesForToken(ring []Node, t partitioner.Token, replicationFactor uint32) []Node {
 	numNodes := int(replicationFactor)
-	ringLen := len(r.tokenRing)
+	ringLen := len(ring)
 	if ringLen < int(replicationFactor) {
-		numNodes = len(r.tokenRing)
+		numNodes = len(ring)
 	}
 	nodes := make([]Node, numNodes)
 
 	// this will return the first node with a token greater than
 	// the given token
 	searcher := func(i int) bool {
-		return bytes.Compare(t, r.tokenRing[i].GetToken()) <= 0
+		return bytes.Compare(t, ring[i].GetToken()) <= 0
 	}
 	idx := sort.Search(ringLen, searcher)
 
 	for i:=0;i<numNodes;i++ {
-		nodes[i] = r.tokenRing[(idx + i) % ringLen]
+		nodes[i] = ring[(idx + i) % ringLen]
 	}
 	return nodes
 }
-
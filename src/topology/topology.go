@@ -5,6 +5,8 @@ package topology
 
 import (
 	"fmt"
+	"hash/fnv"
+	"sort"
 	"sync"
 )
 
@@ -25,6 +27,20 @@ type Topology struct {
 	rings map[DatacenterID]*Ring
 	nodes map[node.NodeId]Node
 	lock  sync.RWMutex
+
+	// identifies the current ring membership. Changes
+	// every time the ring membership changes
+	epoch uint64
+
+	// the token ordered rings of each datacenter, as they
+	// were at each epoch. Instances record the epoch they
+	// were created at, and continue to use it's replicas
+	// until they're committed, so prior epochs are kept
+	// until they're pruned by the consensus manager
+	epochRings map[uint64]map[DatacenterID][]Node
+
+	// the known epochs, oldest first
+	epochOrder []uint64
 }
 
 func NewTopology(
@@ -40,6 +56,7 @@ func NewTopology(
 		replicationFactor: replicationFactor,
 		rings: make(map[DatacenterID]*Ring, 1),
 		nodes: make(map[node.NodeId]Node, 1),
+		epochRings: make(map[uint64]map[DatacenterID][]Node),
 	}
 }
 
@@ -54,10 +71,89 @@ func (t *Topology) AddNode(n Node) error {
 	err := t.rings[dcId].AddNode(n)
 	if err == nil {
 		t.nodes[n.GetId()] = n
+		t.incrementEpoch()
 	}
 	return err
 }
 
+// returns the epoch of the given rings. Epochs are derived from the ring
+// membership, instead of counting local changes, so every node that has
+// discovered the same rings from it's peers agrees on the epoch, and on
+// the replicas of an instance created at it. Zero is never returned,
+// instances use it to indicate the current topology
+func ringEpoch(rings map[DatacenterID][]Node) uint64 {
+	dcids := make([]string, 0, len(rings))
+	for dcid := range rings {
+		dcids = append(dcids, string(dcid))
+	}
+	sort.Strings(dcids)
+
+	h := fnv.New64a()
+	for _, dcid := range dcids {
+		h.Write([]byte(dcid))
+		for _, n := range rings[DatacenterID(dcid)] {
+			h.Write([]byte(n.GetToken()))
+			h.Write(n.GetId().Bytes())
+		}
+	}
+	epoch := h.Sum64()
+	if epoch == 0 {
+		epoch = 1
+	}
+	return epoch
+}
+
+// records a snapshot of the current rings under their epoch
+// this method does no locking, the caller needs to do that
+func (t *Topology) incrementEpoch() {
+	snapshot := make(map[DatacenterID][]Node, len(t.rings))
+	for dcid, ring := range t.rings {
+		snapshot[dcid] = ring.AllNodes()
+	}
+	t.epoch = ringEpoch(snapshot)
+	t.epochRings[t.epoch] = snapshot
+
+	order := make([]uint64, 0, len(t.epochOrder) + 1)
+	for _, epoch := range t.epochOrder {
+		if epoch != t.epoch {
+			order = append(order, epoch)
+		}
+	}
+	order = append(order, t.epoch)
+	t.epochOrder = order
+}
+
+// returns the current topology epoch
+func (t *Topology) GetEpoch() uint64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.epoch
+}
+
+// forgets the rings of prior epochs that aren't in the given set. The
+// current epoch is always kept
+func (t *Topology) PruneEpochs(inUse map[uint64]bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	order := make([]uint64, 0, len(t.epochOrder))
+	for _, epoch := range t.epochOrder {
+		if epoch == t.epoch || inUse[epoch] {
+			order = append(order, epoch)
+		} else {
+			delete(t.epochRings, epoch)
+		}
+	}
+	t.epochOrder = order
+}
+
+// returns the number of epochs whose rings are known
+func (t *Topology) NumEpochs() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return len(t.epochRings)
+}
+
 func (t *Topology) Size() int {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
@@ -146,6 +242,41 @@ func (t *Topology) GetLocalNodesForToken(tk partitioner.Token) []Node {
 	return ring.GetNodesForToken(tk, uint32(t.replicationFactor))
 }
 
+// returns a map of datacenter ids -> replica nodes for the given
+// token, as they were at the given epoch. An error is returned if
+// the epoch is unknown
+func (t *Topology) GetNodesForTokenAtEpoch(tk partitioner.Token, epoch uint64) (map[DatacenterID][]Node, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	rings, exists := t.epochRings[epoch]
+	if !exists {
+		return nil, fmt.Errorf("Unknown topology epoch [%v]", epoch)
+	}
+	nodes := make(map[DatacenterID][]Node, len(rings))
+	for dcid, ring := range rings {
+		nodes[dcid] = getNodesForToken(ring, tk, uint32(t.replicationFactor))
+	}
+	return nodes, nil
+}
+
+// returns the local dc replicas for the given token, as they were at the
+// given epoch. An error is returned if the epoch is unknown
+func (t *Topology) GetLocalNodesForTokenAtEpoch(tk partitioner.Token, epoch uint64) ([]Node, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	rings, exists := t.epochRings[epoch]
+	if !exists {
+		return nil, fmt.Errorf("Unknown topology epoch [%v]", epoch)
+	}
+	ring := rings[t.localDcID]
+	if len(ring) == 0 {
+		return []Node{}, nil
+	}
+	return getNodesForToken(ring, tk, uint32(t.replicationFactor)), nil
+}
+
 // returns true if the given token is replicated by the local node
 func (t *Topology) TokenLocallyReplicated(tk partitioner.Token) bool {
 	for _, n := range t.GetLocalNodesForToken(tk) {
@@ -123,3 +123,116 @@ func (t *TopologyTest) TestTokenIsLocallyReplicated(c *gocheck.C) {
 		c.Check(t.tp.TokenLocallyReplicated(tk), gocheck.Equals, shouldReplicate, gocheck.Commentf("%v: %v", i, tk))
 	}
 }
+
+// tests that adding a node changes the topology epoch
+func (t *TopologyTest) TestEpochChange(c *gocheck.C) {
+	epoch := t.tp.GetEpoch()
+	c.Check(epoch, gocheck.Not(gocheck.Equals), uint64(0))
+
+	n := newMockNode(node.NewNodeId(), t.localDCID, partitioner.Token([]byte{0,0,1,5}), "N10")
+	c.Assert(t.tp.AddNode(n), gocheck.IsNil)
+	newEpoch := t.tp.GetEpoch()
+	c.Check(newEpoch, gocheck.Not(gocheck.Equals), epoch)
+
+	// failed additions don't change the epoch
+	c.Assert(t.tp.AddNode(n), gocheck.NotNil)
+	c.Check(t.tp.GetEpoch(), gocheck.Equals, newEpoch)
+}
+
+// tests that topologies with the same rings have the same epoch,
+// regardless of the order their nodes were discovered in
+func (t *TopologyTest) TestEpochAgreement(c *gocheck.C) {
+	nodes := t.tp.AllNodes()
+	other := NewTopology(nodes[len(nodes) - 1].GetId(), t.localDCID, partitioner.NewMD5Partitioner(), 3)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		c.Assert(other.AddNode(nodes[i]), gocheck.IsNil)
+	}
+	c.Check(other.GetEpoch(), gocheck.Equals, t.tp.GetEpoch())
+}
+
+// tests that prior epochs are kept until they're pruned, since
+// uncommitted instances may still be using them
+func (t *TopologyTest) TestEpochHistory(c *gocheck.C) {
+	numEpochs := t.tp.NumEpochs()
+	epochs := make([]uint64, 0)
+	for i:=0; i<20; i++ {
+		n := newMockNode(node.NewNodeId(), t.localDCID, partitioner.Token([]byte{0,0,1,byte(i)}), fmt.Sprintf("N1%v", i))
+		c.Assert(t.tp.AddNode(n), gocheck.IsNil)
+		epochs = append(epochs, t.tp.GetEpoch())
+	}
+	c.Check(t.tp.NumEpochs(), gocheck.Equals, numEpochs + 20)
+	tk := partitioner.Token([]byte{0,0,1,5})
+	for _, epoch := range epochs {
+		_, err := t.tp.GetLocalNodesForTokenAtEpoch(tk, epoch)
+		c.Check(err, gocheck.IsNil)
+	}
+}
+
+// tests that pruning keeps the current epoch, and the epochs in use
+func (t *TopologyTest) TestPruneEpochs(c *gocheck.C) {
+	epochs := []uint64{t.tp.GetEpoch()}
+	for i:=0; i<2; i++ {
+		n := newMockNode(node.NewNodeId(), t.localDCID, partitioner.Token([]byte{0,0,1,byte(i)}), fmt.Sprintf("N1%v", i))
+		c.Assert(t.tp.AddNode(n), gocheck.IsNil)
+		epochs = append(epochs, t.tp.GetEpoch())
+	}
+
+	t.tp.PruneEpochs(map[uint64]bool{epochs[0]: true})
+	c.Check(t.tp.NumEpochs(), gocheck.Equals, 2)
+	tk := partitioner.Token([]byte{0,0,1,5})
+	_, err := t.tp.GetLocalNodesForTokenAtEpoch(tk, epochs[0])
+	c.Check(err, gocheck.IsNil)
+	_, err = t.tp.GetLocalNodesForTokenAtEpoch(tk, epochs[1])
+	c.Check(err, gocheck.NotNil)
+	_, err = t.tp.GetLocalNodesForTokenAtEpoch(tk, epochs[2])
+	c.Check(err, gocheck.IsNil)
+}
+
+// tests that replicas can be looked up for prior epochs
+func (t *TopologyTest) TestGetLocalNodesForTokenAtEpoch(c *gocheck.C) {
+	tk := partitioner.Token([]byte{0,0,1,5})
+	epoch := t.tp.GetEpoch()
+	n := newMockNode(node.NewNodeId(), t.localDCID, partitioner.Token([]byte{0,0,1,5}), "N10")
+	c.Assert(t.tp.AddNode(n), gocheck.IsNil)
+
+	oldNodes, err := t.tp.GetLocalNodesForTokenAtEpoch(tk, epoch)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(oldNodes), gocheck.Equals, 3)
+	for _, oldNode := range oldNodes {
+		c.Check(oldNode.GetId(), gocheck.Not(gocheck.Equals), n.GetId())
+	}
+	c.Check(oldNodes[0].GetToken(), gocheck.DeepEquals, partitioner.Token([]byte{0,0,2,0}))
+
+	newNodes, err := t.tp.GetLocalNodesForTokenAtEpoch(tk, t.tp.GetEpoch())
+	c.Assert(err, gocheck.IsNil)
+	c.Check(newNodes, gocheck.DeepEquals, t.tp.GetLocalNodesForToken(tk))
+	c.Check(newNodes[0].GetId(), gocheck.Equals, n.GetId())
+
+	_, err = t.tp.GetLocalNodesForTokenAtEpoch(tk, t.tp.GetEpoch() + 1)
+	c.Check(err, gocheck.NotNil)
+}
+
+
+// tests that replicas in every datacenter can be looked up for prior epochs
+func (t *TopologyTest) TestGetNodesForTokenAtEpoch(c *gocheck.C) {
+	tk := partitioner.Token([]byte{0,0,1,5})
+	epoch := t.tp.GetEpoch()
+	n := newMockNode(node.NewNodeId(), DatacenterID("DC2"), partitioner.Token([]byte{0,0,1,5}), "N10")
+	c.Assert(t.tp.AddNode(n), gocheck.IsNil)
+
+	oldNodes, err := t.tp.GetNodesForTokenAtEpoch(tk, epoch)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(oldNodes), gocheck.Equals, 3)
+	for _, dcNodes := range oldNodes {
+		c.Assert(len(dcNodes), gocheck.Equals, 3)
+		c.Check(dcNodes[0].GetToken(), gocheck.DeepEquals, partitioner.Token([]byte{0,0,2,0}))
+	}
+
+	newNodes, err := t.tp.GetNodesForTokenAtEpoch(tk, t.tp.GetEpoch())
+	c.Assert(err, gocheck.IsNil)
+	c.Check(newNodes, gocheck.DeepEquals, t.tp.GetNodesForToken(tk))
+	c.Check(newNodes["DC2"][0].GetId(), gocheck.Equals, n.GetId())
+
+	_, err = t.tp.GetNodesForTokenAtEpoch(tk, t.tp.GetEpoch() + 1)
+	c.Check(err, gocheck.NotNil)
+}